@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+)
+
+// newLogForwarder creates the client copying the workload's output to the
+// provider's log server. Output is paced to what the provider acknowledges,
+// and dropped once the provider cannot be reached for a while, so the
+// workload never hangs or fails on its own output.
+func newLogForwarder(cid uint32) *agent.LogClient {
+	return agent.NewLogClient(func() (net.Conn, error) {
+		return transport.Dial(agent.ParentCID, agent.LogPort(cid))
+	})
+}
@@ -0,0 +1,561 @@
+// The agent is the entrypoint of every enclave launched by the provider. It
+// runs the workload and reports back to the provider over vsock.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+const (
+	// The default PATH used to look up the workload when the image sets none.
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// Where the agent serves the DNS queries of the workload.
+	dnsAddress     = "127.0.0.1:53"
+	resolvConfPath = "/etc/resolv.conf"
+
+	// How long to keep trying to report the workload's exit to the provider.
+	reportTimeout = 10 * time.Second
+	reportRetry   = 500 * time.Millisecond
+
+	// How long to wait for the provider to acknowledge the last output of the workload.
+	logFlushTimeout = 5 * time.Second
+
+	// How long a request to Vault may take.
+	vaultTimeout = 30 * time.Second
+)
+
+func main() {
+	stdin := flag.Bool("stdin", false, "keep the workload's stdin open for attached clients")
+	tty := flag.Bool("tty", false, "run the workload on a pseudo terminal")
+	egressProxy := flag.String("egress-proxy", "", "listen on this address for the workload's HTTP proxy connections, forwarded to the provider's egress proxy")
+	dns := flag.Bool("dns", false, "resolve the workload's DNS queries through the provider's DNS proxy")
+	syncClock := flag.Bool("sync-clock", false, "synchronize the enclave's clock with the provider's time service")
+	credentials := flag.String("credentials", "", "listen on this address for the workload's AWS credential requests, forwarded to the provider's credential endpoint")
+	waitFiles := flag.Duration("wait-files", 0, "how long to wait for the provider to push the files of projected volumes before starting the workload")
+	kmsSecrets := flag.Duration("kms-secrets", 0, "how long to wait for the provider to release the KMS secrets of the pod, decrypted for a key of the enclave, before starting the workload")
+	vault := flag.String("vault", "", "listen on this address for the workload's Vault connections, forwarded to the provider's Vault forwarder")
+	vaultURL := flag.String("vault-url", "", "URL of the Vault server the connections are forwarded to, whose scheme and server name the workload uses")
+	vaultRole := flag.String("vault-role", "", "log into Vault as this role before starting the workload, keeping the token in "+agent.VaultTokenFile)
+	vaultAuth := flag.String("vault-auth", agent.VaultAuthKubernetes, "auth method to log into Vault with, kubernetes or attestation")
+	vaultAuthPath := flag.String("vault-auth-path", "", "mount path of the Vault auth method, the name of the method when empty")
+	raTLS := flag.Bool("ra-tls", false, "generate the key pair of the enclave and an attested TLS certificate for the workload to serve")
+	raTLSNames := flag.String("ra-tls-names", "", "DNS names and IP addresses, separated by commas, of the attested TLS certificate")
+	setCondition := flag.String("set-condition", "", "set a condition of the pod, such as one of its readiness gates, as type=true|false with the arguments as its message, and exit")
+	var tmpfs tmpfsFlag
+	flag.Var(&tmpfs, "tmpfs", "mount a tmpfs of the given size before starting the workload, as path:sizeMiB (repeatable)")
+	logConfig := logging.DefaultConfig()
+	logConfig.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logging.Setup(logConfig); err != nil {
+		log.L.Fatal(err)
+	}
+	args := flag.Args()
+	// The workload runs the agent to signal its own initialization.
+	if *setCondition != "" {
+		if err := sendCondition(*setCondition, strings.Join(args, " ")); err != nil {
+			log.L.Fatalf("Failed to set condition: %v", err)
+		}
+		return
+	}
+	if len(args) == 0 {
+		log.L.Fatal("No command to run")
+	}
+
+	if os.Getenv("PATH") == "" {
+		os.Setenv("PATH", defaultPath)
+	}
+
+	// Simulated enclaves are processes of the host, which keep the entropy,
+	// mounts, clock and resolver of the host.
+	simRoot := os.Getenv(agent.SimulatedRootEnv)
+	simulated := simRoot != ""
+	if simulated {
+		log.L.Infof("Running in a simulated enclave rooted at %s", simRoot)
+	}
+
+	// Seed the kernel's random pool, so the workload's cryptography never
+	// runs on weak entropy early in boot.
+	if !simulated {
+		if err := agent.SeedEntropy(); err != nil {
+			log.L.Warnf("Failed to seed entropy: %v", err)
+		}
+	}
+
+	// Mount the scratch volumes of the workload.
+	if !simulated {
+		for _, m := range tmpfs {
+			if err := m.Mount(); err != nil {
+				log.L.Warnf("Failed to mount tmpfs at %s: %v", m.Path, err)
+			}
+		}
+	}
+
+	// Relay UDP traffic forwarded by the provider to the workload.
+	if l, err := transport.Listen(agent.UDPRelayPort); err != nil {
+		log.L.Errorf("Failed to start udp relay: %v", err)
+	} else {
+		go agent.UDPRelay{}.Serve(l) //nolint:errcheck
+	}
+
+	// Run the commands of exec probes, kubectl exec and kubectl cp.
+	if l, err := transport.Listen(agent.ExecPort); err != nil {
+		log.L.Errorf("Failed to start exec server: %v", err)
+	} else {
+		go agent.ExecServer{Root: simRoot}.Serve(l) //nolint:errcheck
+	}
+
+	// Serve attestation documents of the enclave to the provider.
+	if l, err := transport.Listen(agent.AttestPort); err != nil {
+		log.L.Errorf("Failed to start attestation server: %v", err)
+	} else {
+		go agent.AttestServer{}.Serve(l) //nolint:errcheck
+	}
+
+	// Let the workload reach allowed destinations through the provider's egress proxy.
+	if *egressProxy != "" {
+		if err := startEgressForwarder(listenAddress(*egressProxy, simulated)); err != nil {
+			log.L.Errorf("Failed to start egress forwarder: %v", err)
+		}
+	}
+
+	// Keep the enclave's clock, which has no NTP, in sync with the host.
+	if *syncClock && !simulated {
+		if err := startClockSync(); err != nil {
+			log.L.Errorf("Failed to start clock synchronization: %v", err)
+		}
+	}
+
+	// Let the workload get the AWS credentials of its pod's role from the provider.
+	if *credentials != "" {
+		if err := startCredentialsForwarder(listenAddress(*credentials, simulated)); err != nil {
+			log.L.Errorf("Failed to start credentials forwarder: %v", err)
+		}
+	}
+
+	// Resolve names through the provider's DNS proxy.
+	if *dns && !simulated {
+		if err := startDNSForwarder(); err != nil {
+			log.L.Errorf("Failed to start DNS forwarder: %v", err)
+		}
+	}
+
+	// Write the files of projected volumes, such as service account tokens,
+	// waiting for the first ones so the workload finds them at start.
+	files := agent.NewFileServer()
+	files.Root = simRoot
+	if l, err := transport.Listen(agent.FilesPort); err != nil {
+		log.L.Errorf("Failed to start file server: %v", err)
+	} else {
+		go files.Serve(l) //nolint:errcheck
+		if *waitFiles > 0 {
+			select {
+			case <-files.Ready():
+			case <-time.After(*waitFiles):
+				log.L.Warn("Timed out waiting for projected volumes")
+			}
+		}
+	}
+
+	// Decrypt the KMS secrets the provider releases once it attested the
+	// enclave, waiting for them so the workload finds them at start.
+	if *kmsSecrets > 0 {
+		secrets := agent.NewSecretServer()
+		secrets.Root = simRoot
+		if l, err := transport.Listen(agent.SecretsPort); err != nil {
+			log.L.Errorf("Failed to start secret server: %v", err)
+		} else {
+			go secrets.Serve(l) //nolint:errcheck
+			select {
+			case <-secrets.Ready():
+			case <-time.After(*kmsSecrets):
+				log.L.Warn("Timed out waiting for KMS secrets")
+			}
+		}
+	}
+
+	// Let the workload reach Vault through the provider, logged in with the
+	// identity of the pod or the attestation of the enclave.
+	if *vault != "" {
+		addr := listenAddress(*vault, simulated)
+		if err := startVaultForwarder(addr, *vaultURL); err != nil {
+			log.L.Errorf("Failed to start Vault forwarder: %v", err)
+		} else if *vaultRole != "" {
+			login := &agent.VaultLogin{
+				Method:    *vaultAuth,
+				Path:      *vaultAuthPath,
+				Role:      *vaultRole,
+				JWTFile:   filepath.Join(simRoot, agent.ServiceAccountTokenFile),
+				Attest:    func() ([]byte, error) { return agent.NSMAttest(nil, nil) },
+				TokenFile: filepath.Join(simRoot, agent.VaultTokenFile),
+			}
+			if err := loginVault(login, addr, *vaultURL); err != nil {
+				log.L.Errorf("Failed to log into Vault: %v", err)
+			}
+		}
+	}
+
+	// Give the workload a TLS certificate bound to the attestation of the enclave.
+	if *raTLS {
+		if err := writeRATLSCertificate(filepath.Join(simRoot, agent.RATLSDir), *raTLSNames); err != nil {
+			log.L.Errorf("Failed to generate attested TLS certificate: %v", err)
+		}
+	}
+
+	// Forward the workload's output to the provider, keeping it on the console.
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	var logs *agent.LogClient
+	if cid, err := transport.ContextID(); err != nil {
+		log.L.Warnf("Failed to get context ID, not forwarding logs: %v", err)
+	} else {
+		logs = newLogForwarder(cid)
+		stdout = io.MultiWriter(os.Stdout, logs.Stream(agent.LogStdout))
+		stderr = io.MultiWriter(os.Stderr, logs.Stream(agent.LogStderr))
+	}
+
+	workload, err := agent.StartWorkload(args, agent.WorkloadOptions{
+		Stdin:  *stdin,
+		TTY:    *tty,
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+	if err != nil {
+		log.L.Errorf("Failed to start %s: %v", args[0], err)
+		report(agent.Event{Type: agent.EventExit, Time: time.Now(), ExitCode: 127})
+		os.Exit(127)
+	}
+
+	// Tell the provider the workload started, which the pod waits for to be ready.
+	go report(agent.Event{Type: agent.EventStarted, Time: time.Now()})
+
+	// Let the provider attach to the workload's stdio.
+	if l, err := transport.Listen(agent.AttachPort); err != nil {
+		log.L.Errorf("Failed to start attach server: %v", err)
+	} else {
+		go workload.Serve(l) //nolint:errcheck
+	}
+
+	// Run the ephemeral containers of the pod as helpers beside the workload.
+	if l, err := transport.Listen(agent.HelperPort); err != nil {
+		log.L.Errorf("Failed to start helper server: %v", err)
+	} else {
+		go agent.NewHelperServer().Serve(l) //nolint:errcheck
+	}
+
+	// Forward termination signals to the workload.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			workload.Signal(sig)
+		}
+	}()
+
+	event := exitEvent(workload.Wait())
+	log.L.Infof("%s exited with code %d", args[0], event.ExitCode)
+	if logs != nil {
+		logs.Close(logFlushTimeout)
+	}
+	report(event)
+	os.Exit(event.ExitCode)
+}
+
+// exitEvent describes how the workload exited.
+func exitEvent(err error) agent.Event {
+	event := agent.Event{Type: agent.EventExit, Time: time.Now()}
+
+	code, signal, ok := agent.ExitStatus(err)
+	if !ok {
+		code = 1
+	}
+	event.ExitCode, event.Signal = code, signal
+	return event
+}
+
+// sendCondition reports a condition of the pod given as type=true|false to the provider.
+func sendCondition(value, message string) error {
+	condition, status, ok := strings.Cut(value, "=")
+	if !ok || condition == "" {
+		return fmt.Errorf("invalid condition %q, expected type=true|false", value)
+	}
+	b, err := strconv.ParseBool(status)
+	if err != nil {
+		return fmt.Errorf("invalid condition status %q", status)
+	}
+	cid, err := transport.ContextID()
+	if err != nil {
+		return err
+	}
+	return send(cid, agent.Event{Type: agent.EventCondition, Time: time.Now(), Condition: condition, Status: b, Message: message})
+}
+
+// report sends an event to the provider, retrying while its listener comes up.
+func report(event agent.Event) {
+	cid, err := transport.ContextID()
+	if err != nil {
+		log.L.Warnf("Failed to get context ID: %v", err)
+		return
+	}
+
+	deadline := time.Now().Add(reportTimeout)
+	for {
+		err = send(cid, event)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(reportRetry)
+	}
+	if err != nil {
+		log.L.Warnf("Failed to report %s event: %v", event.Type, err)
+	}
+}
+
+func send(cid uint32, event agent.Event) error {
+	conn, err := transport.Dial(agent.ParentCID, agent.EventPort(cid))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return agent.SendEvent(conn, event, reportRetry*2)
+}
+
+// startEgressForwarder forwards the connections made to addr to the egress
+// proxy of the provider, and makes it the HTTP proxy of the workload unless
+// the workload configures its own.
+func startEgressForwarder(addr string) error {
+	cid, err := transport.ContextID()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	forwarder := agent.EgressForwarder{Dial: func() (net.Conn, error) {
+		return transport.Dial(agent.ParentCID, agent.EgressPort(cid))
+	}}
+	go forwarder.Serve(l) //nolint:errcheck
+
+	proxy := "http://" + addr
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, proxy)
+		}
+	}
+	if os.Getenv("NO_PROXY") == "" && os.Getenv("no_proxy") == "" {
+		os.Setenv("NO_PROXY", "localhost,127.0.0.1,::1")
+	}
+	return nil
+}
+
+// startClockSync synchronizes the clock of the enclave with the time service of
+// the provider once before the workload starts, so it validates certificates
+// and tokens against the right time, then periodically.
+func startClockSync() error {
+	cid, err := transport.ContextID()
+	if err != nil {
+		return err
+	}
+	dial := func() (net.Conn, error) {
+		return transport.Dial(agent.ParentCID, agent.ClockPort(cid))
+	}
+	if err := agent.SyncClock(dial); err != nil {
+		log.L.Warnf("Failed to synchronize clock: %v", err)
+	}
+	go func() {
+		for {
+			time.Sleep(agent.ClockSyncInterval)
+			if err := agent.SyncClock(dial); err != nil {
+				log.L.Warnf("Failed to synchronize clock: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// startCredentialsForwarder forwards the connections made to addr to the
+// credential endpoint of the provider, and makes it the container credential
+// provider of the AWS SDKs of the workload unless the workload configures its own.
+func startCredentialsForwarder(addr string) error {
+	cid, err := transport.ContextID()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// The connections are forwarded as is, like those to the egress proxy.
+	forwarder := agent.EgressForwarder{Dial: func() (net.Conn, error) {
+		return transport.Dial(agent.ParentCID, agent.CredentialsPort(cid))
+	}}
+	go forwarder.Serve(l) //nolint:errcheck
+
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") == "" && os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") == "" {
+		os.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "http://"+addr+agent.CredentialsPath)
+	}
+	return nil
+}
+
+// startVaultForwarder forwards the connections made to addr to the Vault
+// forwarder of the provider, and makes it the Vault server of the workload
+// unless the workload configures its own. TLS passes through, so the
+// workload verifies the server name of the Vault URL.
+func startVaultForwarder(addr, vaultURL string) error {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return err
+	}
+	cid, err := transport.ContextID()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	forwarder := agent.EgressForwarder{Dial: func() (net.Conn, error) {
+		return transport.Dial(agent.ParentCID, agent.VaultPort(cid))
+	}}
+	go forwarder.Serve(l) //nolint:errcheck
+
+	if os.Getenv("VAULT_ADDR") == "" {
+		os.Setenv("VAULT_ADDR", u.Scheme+"://"+addr)
+		if u.Scheme == "https" && os.Getenv("VAULT_TLS_SERVER_NAME") == "" {
+			os.Setenv("VAULT_TLS_SERVER_NAME", u.Hostname())
+		}
+	}
+	return nil
+}
+
+// loginVault logs into the Vault server forwarded from addr, trusting the
+// certificate authorities of VAULT_CACERT as the Vault CLI does, besides
+// those of the system, and keeps the token fresh in the background. It
+// keeps trying when it fails.
+func loginVault(login *agent.VaultLogin, addr, vaultURL string) error {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if file := os.Getenv("VAULT_CACERT"); file != "" {
+		pem, err := os.ReadFile(file)
+		if err != nil || !roots.AppendCertsFromPEM(pem) {
+			log.L.Warnf("Failed to load the Vault CA certificate %s: %v", file, err)
+		}
+	}
+	login.Address = u.Scheme + "://" + addr
+	login.Client = &http.Client{
+		Timeout: vaultTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: u.Hostname(), RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	ctx := context.Background()
+	token, err := login.Login(ctx)
+	go login.Refresh(ctx, token, log.L.Warnf)
+	return err
+}
+
+// startDNSForwarder relays the DNS queries made to the local name server
+// to the DNS proxy of the provider, and makes it the resolver of the workload.
+func startDNSForwarder() error {
+	cid, err := transport.ContextID()
+	if err != nil {
+		return err
+	}
+	forwarder := agent.DNSForwarder{Dial: func() (net.Conn, error) {
+		return transport.Dial(agent.ParentCID, agent.DNSPort(cid))
+	}}
+
+	pc, err := net.ListenPacket("udp", dnsAddress)
+	if err != nil {
+		return err
+	}
+	go forwarder.ServeUDP(pc) //nolint:errcheck
+	l, err := net.Listen("tcp", dnsAddress)
+	if err != nil {
+		return err
+	}
+	go forwarder.ServeTCP(l) //nolint:errcheck
+
+	host, _, _ := net.SplitHostPort(dnsAddress)
+	return agent.WriteResolvConf(resolvConfPath, host)
+}
+
+// writeRATLSCertificate writes the attested TLS certificate of the enclave
+// and its key to dir, and points the workload to them unless it configures
+// its own files.
+func writeRATLSCertificate(dir, names string) error {
+	var list []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			list = append(list, name)
+		}
+	}
+	certFile, keyFile, err := agent.WriteRATLSCertificate(dir, list, agent.NSMAttestKey)
+	if err != nil {
+		return err
+	}
+	if os.Getenv("RA_TLS_CERT_FILE") == "" && os.Getenv("RA_TLS_KEY_FILE") == "" {
+		os.Setenv("RA_TLS_CERT_FILE", certFile)
+		os.Setenv("RA_TLS_KEY_FILE", keyFile)
+	}
+	return nil
+}
+
+// listenAddress returns the address the agent listens on for the workload,
+// on the loopback address of the enclave in simulated enclaves, which share
+// the loopback interface of the host.
+func listenAddress(addr string, simulated bool) string {
+	_, port, err := net.SplitHostPort(addr)
+	if !simulated || err != nil {
+		return addr
+	}
+	return net.JoinHostPort(transport.LocalHost(), port)
+}
+
+// tmpfsFlag collects the tmpfs mounts passed to the agent.
+type tmpfsFlag []agent.TmpfsMount
+
+func (f *tmpfsFlag) String() string {
+	mounts := make([]string, 0, len(*f))
+	for _, m := range *f {
+		mounts = append(mounts, m.String())
+	}
+	return strings.Join(mounts, ",")
+}
+
+func (f *tmpfsFlag) Set(value string) error {
+	m, err := agent.ParseTmpfsMount(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, m)
+	return nil
+}
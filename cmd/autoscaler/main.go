@@ -0,0 +1,112 @@
+// The autoscaler scales the Nitro hosts of enclave nodes with the demand for
+// enclaves, through an EC2 Auto Scaling group or CloudWatch metrics. A single
+// replica runs per cluster.
+package main
+
+import (
+	"context"
+	"flag"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/autoscale"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/webhook"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func main() {
+	kubeconfig := flag.String("kubeconfig", "", "kube config file to use for connecting to the Kubernetes API server, the in-cluster configuration if empty")
+	asgName := flag.String("asg-name", "", "name of the Auto Scaling group of the Nitro hosts to scale")
+	metricNamespace := flag.String("metric-namespace", "", "CloudWatch namespace to publish the PendingEnclaveHosts and IdleEnclaveHosts metrics to, instead of scaling a group")
+	metricDimensions := flag.String("metric-dimensions", "", "dimensions of the metrics, such as Cluster=prod,Pool=enclaves")
+	nodeSelector := flag.String("node-selector", "type=virtual-kubelet", "label selector of the enclave nodes")
+	taintKey := flag.String("taint-key", webhook.DefaultTaintKey, "key of the taint of enclave nodes, pods tolerating it target them")
+	runtimeClass := flag.String("runtime-class", "", "runtime class of the pods running in enclaves, pods requesting it target enclave nodes")
+	interval := flag.Duration("interval", 30*time.Second, "how often the demand for enclaves is observed")
+	cooldown := flag.Duration("cooldown", 5*time.Minute, "how long after a scaling no other is decided, while the hosts added join")
+	idleTimeout := flag.Duration("idle-timeout", 15*time.Minute, "how long a node runs no pods before its host is removed, never if zero")
+	podsPerNode := flag.Int("pods-per-node", cli.MaxEnclavesPerInstance, "how many enclave pods a host runs")
+	minNodes := flag.Int("min-nodes", 1, "fewest enclave nodes kept")
+	maxNodes := flag.Int("max-nodes", 0, "most enclave nodes added, unlimited if zero")
+	logConfig := logging.DefaultConfig()
+	logConfig.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logging.Setup(logConfig); err != nil {
+		log.L.Fatal(err)
+	}
+
+	if (*asgName == "") == (*metricNamespace == "") {
+		log.L.Fatal("exactly one of -asg-name and -metric-namespace is required")
+	}
+	selector, err := labels.Parse(*nodeSelector)
+	if err != nil {
+		log.L.Fatalf("invalid -node-selector %q: %v", *nodeSelector, err)
+	}
+
+	var restConfig *rest.Config
+	if *kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		log.L.Fatalf("error getting rest client config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.L.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.G(ctx).Fatalf("failed to load the AWS configuration: %v", err)
+	}
+
+	var scaler autoscale.Scaler
+	if *asgName != "" {
+		scaler = autoscale.NewASG(awsConfig, *asgName)
+	} else {
+		dimensions := make(map[string]string)
+		for _, pair := range strings.Split(*metricDimensions, ",") {
+			if pair == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				log.G(ctx).Fatalf("invalid -metric-dimensions %q, dimensions are name=value", *metricDimensions)
+			}
+			dimensions[name] = value
+		}
+		scaler = autoscale.NewMetric(awsConfig, *metricNamespace, dimensions)
+	}
+
+	policy := autoscale.DefaultPolicy{
+		PodsPerNode: *podsPerNode,
+		IdleTimeout: *idleTimeout,
+		MinNodes:    *minNodes,
+		MaxNodes:    *maxNodes,
+	}
+	targets := webhook.New(webhook.Config{TaintKey: *taintKey, RuntimeClassName: *runtimeClass})
+	autoscaler := autoscale.New(client, policy, scaler, autoscale.Config{
+		NodeSelector:    selector,
+		TargetsEnclaves: targets.TargetsEnclaves,
+		Interval:        *interval,
+		Cooldown:        *cooldown,
+		// Only the group removes the very hosts of the idle nodes.
+		Cordon: *asgName != "",
+	})
+
+	log.G(ctx).Infof("Scaling the hosts of the %s nodes every %s", selector, *interval)
+	autoscaler.Run(ctx)
+}
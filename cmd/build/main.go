@@ -1,20 +1,29 @@
 package main
 
 import (
-	"log"
+	"flag"
 	"os"
 
 	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 func main() {
+	logConfig := logging.DefaultConfig()
+	logConfig.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logging.Setup(logConfig); err != nil {
+		log.L.Fatal(err)
+	}
+
 	file, err := os.CreateTemp("", "bootstrap")
 	if err != nil {
-		log.Fatal(err)
+		log.L.Fatal(err)
 	}
 
-	err = build.BuildEif("/usr/share/nitro_enclaves/blobs/", "busybox", []string{"/bin/sh", "-c", "watch echo $FOO"}, map[string]string{"FOO": "hello world"}, file.Name())
+	err = build.BuildEif(build.DefaultBlobsPath, "busybox", []string{"/bin/sh", "-c", "watch echo $FOO"}, map[string]string{"FOO": "hello world"}, file.Name())
 	if err != nil {
-		log.Fatal(err)
+		log.L.Fatal(err)
 	}
 }
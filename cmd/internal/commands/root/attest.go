@@ -0,0 +1,66 @@
+package root
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// attestPath prefixes the kubelet API paths of attestation documents,
+// /attest/{namespace}/{pod}. Like the other kubelet API paths outside of
+// the stats and metrics, callers are authorized for the proxy subresource
+// of the node.
+const attestPath = "/attest/"
+
+// attestHandler serves fresh attestation documents of the enclaves of pods,
+// including the nonce and userData query parameters, in unpadded base64url,
+// so external verifiers can challenge live enclaves. Documents are COSE_Sign1
+// structures, served as they are signed by the Nitro Secure Module.
+func attestHandler(a provider.Attester) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "attestation documents are requested with GET or POST", http.StatusMethodNotAllowed)
+			return
+		}
+		namespace, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, attestPath), "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			http.Error(w, "expected /attest/{namespace}/{pod}", http.StatusNotFound)
+			return
+		}
+		query := r.URL.Query()
+		nonce, err := decodeParam(query.Get("nonce"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid nonce: %v", err), http.StatusBadRequest)
+			return
+		}
+		userData, err := decodeParam(query.Get("userData"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid userData: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		doc, err := a.Attest(r.Context(), namespace, name, nonce, userData)
+		switch {
+		case errdefs.IsNotFound(err):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errdefs.IsInvalidInput(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			log.G(r.Context()).Warnf("Failed to attest the enclave of pod %s/%s: %v", namespace, name, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			w.Header().Set("Content-Type", "application/cose")
+			w.Write(doc) //nolint:errcheck
+		}
+	})
+}
+
+// decodeParam decodes a query parameter in base64url, padded or not.
+func decodeParam(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
@@ -0,0 +1,90 @@
+package root
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
+	"github.com/virtual-kubelet/virtual-kubelet/node/nodeutil"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// auditedPaths prefix the paths of the kubelet API requests on enclaves
+// recorded in the audit log, /{operation}/{namespace}/{pod}/{container}.
+var auditedPaths = map[string]audit.Operation{
+	"/containerLogs/": audit.OperationLogs,
+	"/exec/":          audit.OperationExec,
+	"/attach/":        audit.OperationAttach,
+}
+
+// auditAuth records the kubelet API requests on enclaves in the audit log
+// of the provider, with the user they are authenticated as, once they are
+// authorized or denied.
+type auditAuth struct {
+	nodeutil.Auth
+	// auditor returns the provider, nil until it is initialized or when it
+	// keeps no audit log.
+	auditor func() provider.Auditor
+}
+
+// auditAttributes are the attributes of a request being authorized, along with the request.
+type auditAttributes struct {
+	authorizer.Attributes
+	request *http.Request
+}
+
+func (a auditAuth) GetRequestAttributes(u user.Info, r *http.Request) authorizer.Attributes {
+	return auditAttributes{Attributes: a.Auth.GetRequestAttributes(u, r), request: r}
+}
+
+func (a auditAuth) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	attributes, ok := attrs.(auditAttributes)
+	if !ok {
+		return a.Auth.Authorize(ctx, attrs)
+	}
+	decision, reason, err := a.Auth.Authorize(ctx, attributes.Attributes)
+
+	record, audited := auditRecord(attributes.request)
+	auditor := a.auditor()
+	if !audited || auditor == nil {
+		return decision, reason, err
+	}
+	if u := attrs.GetUser(); u != nil {
+		record.Actor = u.GetName()
+	}
+	switch {
+	case err != nil:
+		record.Error = err.Error()
+	case decision != authorizer.DecisionAllow:
+		record.Error = "forbidden"
+		if reason != "" {
+			record.Error += ": " + reason
+		}
+	}
+	auditor.AuditRequest(ctx, record)
+	return decision, reason, err
+}
+
+// auditRecord returns the audit record of a kubelet API request, if it is
+// on an enclave.
+func auditRecord(r *http.Request) (audit.Record, bool) {
+	for prefix, operation := range auditedPaths {
+		rest, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(rest, "/")
+		if len(parts) != 3 {
+			return audit.Record{}, false
+		}
+		record := audit.Record{Operation: operation, Namespace: parts[0], Pod: parts[1], Container: parts[2]}
+		if operation == audit.OperationExec {
+			record.Command = r.URL.Query()["command"]
+		}
+		return record, true
+	}
+	return audit.Record{}, false
+}
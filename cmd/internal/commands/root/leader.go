@@ -0,0 +1,108 @@
+package root
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// errLeadershipLost is returned when another instance took the lease over,
+// so this one exits without touching the enclaves it now manages.
+var errLeadershipLost = errors.New("lost the lease of the node to another instance")
+
+// leaderLeaseName returns the name of the lease electing the instance
+// managing the enclaves of a node, distinct from the heartbeat lease.
+func leaderLeaseName(nodeName string) string {
+	return nodeName + "-provider"
+}
+
+// leader is the election of the instance managing the enclaves of a node.
+type leader struct {
+	// leading is closed once this instance holds the lease.
+	leading chan struct{}
+	// lost is closed once this instance stopped holding the lease.
+	lost chan struct{}
+	// released is set when the lease was given up on purpose.
+	released context.Context
+	release  context.CancelFunc
+}
+
+// electLeader runs for the lease of the node until release is called,
+// giving it up then. Releasing it only after the provider shut down, rather
+// than on the cancellation of the context, lets the provider persist its
+// pods before a standby instance adopts them.
+func electLeader(ctx context.Context, client kubernetes.Interface, c Opts) (*leader, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting hostname")
+	}
+	// Instances upgrading each other run on the same host.
+	identity := hostname + "_" + string(uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: c.LeaderElectNamespace,
+			Name:      leaderLeaseName(c.NodeName),
+		},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+
+	l := &leader{leading: make(chan struct{}), lost: make(chan struct{})}
+	l.released, l.release = context.WithCancel(log.WithLogger(context.Background(), log.G(ctx)))
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   c.LeaderElectLeaseDuration,
+		RenewDeadline:   c.LeaderElectRenewDeadline,
+		RetryPeriod:     c.LeaderElectRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lock.LeaseMeta.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.G(ctx).WithField("identity", identity).Info("Acquired the lease of the node")
+				close(l.leading)
+			},
+			OnStoppedLeading: func() {
+				close(l.lost)
+			},
+			OnNewLeader: func(current string) {
+				if current != identity {
+					log.G(ctx).WithField("leader", current).Info("Standing by while another instance holds the lease of the node")
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error setting up leader election")
+	}
+
+	go elector.Run(l.released)
+	return l, nil
+}
+
+// wait blocks until this instance holds the lease, or the context is done.
+func (l *leader) wait(ctx context.Context) error {
+	select {
+	case <-l.leading:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lostLease tells whether the lease was taken over rather than released.
+func (l *leader) lostLease() bool {
+	select {
+	case <-l.lost:
+		return l.released.Err() == nil
+	default:
+		return false
+	}
+}
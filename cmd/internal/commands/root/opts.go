@@ -41,6 +41,11 @@ const (
 	DefaultTaintKey              = "virtual-kubelet.io/provider"
 	DefaultStreamIdleTimeout     = 30 * time.Second
 	DefaultStreamCreationTimeout = 30 * time.Second
+
+	DefaultLeaderElectNamespace     = corev1.NamespaceNodeLease
+	DefaultLeaderElectLeaseDuration = 15 * time.Second
+	DefaultLeaderElectRenewDeadline = 10 * time.Second
+	DefaultLeaderElectRetryPeriod   = 2 * time.Second
 )
 
 // Opts stores all the options for configuring the root virtual-kubelet command.
@@ -73,6 +78,8 @@ type Opts struct {
 	DisableTaint bool
 
 	MetricsAddr string
+	// EnablePprof serves the pprof profiles of the process on MetricsAddr.
+	EnablePprof bool
 
 	// Number of workers to use to handle pod notifications
 	PodSyncWorkers       int
@@ -93,6 +100,21 @@ type Opts struct {
 	// StreamCreationTimeout is the maximum time for streaming connection
 	StreamCreationTimeout time.Duration
 
+	// LeaderElect runs the provider only while it holds the lease of the
+	// node, so of several instances, such as the old and new versions during
+	// an upgrade, one manages the enclaves while the others stand by.
+	LeaderElect bool
+	// LeaderElectNamespace is the namespace of the lease.
+	LeaderElectNamespace string
+	// LeaderElectLeaseDuration is how long standby instances wait after the
+	// last renewal of the lease before taking it over.
+	LeaderElectLeaseDuration time.Duration
+	// LeaderElectRenewDeadline is how long the leader retries renewing the
+	// lease before giving it up.
+	LeaderElectRenewDeadline time.Duration
+	// LeaderElectRetryPeriod is how often the lease is tried.
+	LeaderElectRetryPeriod time.Duration
+
 	Version string
 }
 
@@ -174,5 +196,21 @@ func SetDefaultOpts(c *Opts) error {
 		c.StreamCreationTimeout = DefaultStreamCreationTimeout
 	}
 
+	if c.LeaderElectNamespace == "" {
+		c.LeaderElectNamespace = DefaultLeaderElectNamespace
+	}
+
+	if c.LeaderElectLeaseDuration == 0 {
+		c.LeaderElectLeaseDuration = DefaultLeaderElectLeaseDuration
+	}
+
+	if c.LeaderElectRenewDeadline == 0 {
+		c.LeaderElectRenewDeadline = DefaultLeaderElectRenewDeadline
+	}
+
+	if c.LeaderElectRetryPeriod == 0 {
+		c.LeaderElectRetryPeriod = DefaultLeaderElectRetryPeriod
+	}
+
 	return nil
 }
@@ -17,9 +17,9 @@ package root
 import (
 	"context"
 	"crypto/tls"
-	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"runtime"
 	"time"
 
@@ -36,8 +36,11 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apiserver/pkg/server/dynamiccertificates"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 // NewCommand creates a new top-level command.
@@ -100,8 +103,44 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		return err
 	}
 
+	// Standby instances serve their health checks too.
+	st := &status{phase: phaseStarting}
+	if c.LeaderElect {
+		st.phase = phaseStandby
+	}
+	if c.MetricsAddr != "" {
+		go serveStatus(ctx, c.MetricsAddr, st, c.EnablePprof)
+	}
+
+	// With several instances, only the one holding the lease of the node
+	// creates the provider, which adopts the enclaves the previous one left.
+	var elected *leader
+	if c.LeaderElect {
+		elected, err = electLeader(ctx, clientSet, c)
+		if err != nil {
+			return err
+		}
+		// The lease is released last, once the provider left its enclaves.
+		defer elected.release()
+
+		log.G(ctx).Info("Waiting for the lease of the node")
+		if err := elected.wait(ctx); err != nil {
+			return nil
+		}
+		go func() {
+			<-elected.lost
+			cancel()
+		}()
+	}
+	st.set(phaseStarting, nil)
+
+	// Share an event recorder between the pod controller and the provider.
+	eb := record.NewBroadcaster()
+	recorder := eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: path.Join(c.NodeName, "pod-controller")})
+
 	// Set-up the node provider.
 	mux := http.NewServeMux()
+	var p provider.Provider
 	newProvider := func(cfg nodeutil.ProviderConfig) (nodeutil.Provider, node.NodeProvider, error) {
 		rm, err := manager.NewResourceManager(cfg.Pods, cfg.Secrets, cfg.ConfigMaps, cfg.Services)
 		if err != nil {
@@ -115,19 +154,30 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 			DaemonPort:        c.ListenPort,
 			InternalIP:        os.Getenv("VKUBELET_POD_IP"),
 			KubeClusterDomain: c.KubeClusterDomain,
+			KubeClient:        clientSet,
+			EventRecorder:     recorder,
 		}
 		pInit := s.Get(c.Provider)
 		if pInit == nil {
 			return nil, nil, errors.Errorf("provider %q not found", c.Provider)
 		}
 
-		p, err := pInit(initConfig)
+		p, err = pInit(initConfig)
 		if err != nil {
 			return nil, nil, errors.Wrapf(err, "error initializing provider %s", c.Provider)
 		}
+		st.set(phaseStarting, p)
+		if attester, ok := p.(provider.Attester); ok {
+			mux.Handle(attestPath, attestHandler(attester))
+		}
 		p.ConfigureNode(ctx, cfg.Node)
 		cfg.Node.Status.NodeInfo.KubeletVersion = c.Version
-		return p, nil, nil
+		// Providers reporting node status changes update the node themselves.
+		var np node.NodeProvider
+		if nodeProvider, ok := p.(node.NodeProvider); ok {
+			np = nodeProvider
+		}
+		return p, np, nil
 	}
 
 	apiConfig, err := getAPIConfig(c)
@@ -135,7 +185,7 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		return err
 	}
 
-	fmt.Println("apiConfig %+v", apiConfig)
+	log.G(ctx).Debugf("apiConfig %+v", apiConfig)
 
 	cm, err := nodeutil.NewNode(c.NodeName, newProvider, func(cfg *nodeutil.NodeConfig) error {
 		cfg.KubeconfigPath = c.KubeConfigPath
@@ -154,11 +204,15 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		cfg.DebugHTTP = true
 
 		cfg.NumWorkers = c.PodSyncWorkers
+		cfg.EventRecorder = recorder
 
 		return nil
 	},
 		nodeutil.WithClient(clientSet),
-		setAuth(c.NodeName, apiConfig),
+		setAuth(c.NodeName, apiConfig, func() provider.Auditor {
+			auditor, _ := p.(provider.Auditor)
+			return auditor
+		}),
 		nodeutil.WithTLSConfig(
 			nodeutil.WithKeyPairFromPath(apiConfig.CertPath, apiConfig.KeyPath),
 			maybeCA(apiConfig.CACertPath),
@@ -169,9 +223,18 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		return err
 	}
 
-	if err := setupTracing(ctx, c); err != nil {
+	shutdownTracing, err := setupTracing(ctx, c)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		// The context is done by now, the spans left are flushed regardless.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.G(ctx).Warnf("Failed to flush the traces: %v", err)
+		}
+	}()
 
 	ctx = log.WithLogger(ctx, log.G(ctx).WithFields(log.Fields{
 		"provider":         c.Provider,
@@ -180,6 +243,10 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 		"watchedNamespace": c.KubeNamespace,
 	}))
 
+	eb.StartLogging(log.G(ctx).Infof)
+	eb.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: clientSet.CoreV1().Events(corev1.NamespaceAll)})
+	defer eb.Shutdown()
+
 	go cm.Run(ctx) //nolint:errcheck
 
 	log.G(ctx).Debug("starting serve open proxy")
@@ -205,19 +272,33 @@ func runRootCommand(ctx context.Context, s *provider.Store, c Opts) error {
 	}
 
 	log.G(ctx).Info("Ready")
+	st.set(phaseRunning, nil)
 
 	select {
 	case <-ctx.Done():
 	case <-cm.Done():
 		return cm.Err()
 	}
+
+	// The enclaves belong to the instance which took the lease over.
+	if elected != nil && elected.lostLease() {
+		return errLeadershipLost
+	}
+
+	// Let the provider stop or leave its workloads as configured, past the cancelled context.
+	if s, ok := p.(provider.Shutdowner); ok {
+		log.G(ctx).Info("Shutting down the provider")
+		if err := s.Shutdown(log.WithLogger(context.Background(), log.G(ctx))); err != nil {
+			log.G(ctx).WithError(err).Error("Failed to shut down the provider")
+		}
+	}
 	return nil
 }
 
-func setAuth(node string, apiCfg *apiServerConfig) nodeutil.NodeOpt {
+func setAuth(node string, apiCfg *apiServerConfig, auditor func() provider.Auditor) nodeutil.NodeOpt {
 	if apiCfg.CACertPath == "" {
 		return func(cfg *nodeutil.NodeConfig) error {
-			cfg.Handler = api.InstrumentHandler(nodeutil.WithAuth(nodeutil.NoAuth(), cfg.Handler))
+			cfg.Handler = api.InstrumentHandler(nodeutil.WithAuth(auditAuth{nodeutil.NoAuth(), auditor}, cfg.Handler))
 			return nil
 		}
 	}
@@ -231,7 +312,7 @@ func setAuth(node string, apiCfg *apiServerConfig) nodeutil.NodeOpt {
 		if err != nil {
 			return err
 		}
-		cfg.Handler = api.InstrumentHandler(nodeutil.WithAuth(auth, cfg.Handler))
+		cfg.Handler = api.InstrumentHandler(nodeutil.WithAuth(auditAuth{auth, auditor}, cfg.Handler))
 		return nil
 	}
 }
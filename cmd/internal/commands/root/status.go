@@ -0,0 +1,116 @@
+package root
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+)
+
+const (
+	// statusShutdownTimeout is how long pending requests are given to complete on exit.
+	statusShutdownTimeout = 5 * time.Second
+	// statusCheckTimeout bounds the health checks of the provider.
+	statusCheckTimeout = 5 * time.Second
+)
+
+// Phases of the process, reported by its readiness endpoint.
+const (
+	phaseStandby  = "standing by for the lease of the node"
+	phaseStarting = "starting"
+	phaseRunning  = "running"
+)
+
+// status is the state of the process, for its health endpoints.
+type status struct {
+	mu       sync.Mutex
+	phase    string
+	provider provider.Provider
+}
+
+func (s *status) set(phase string, p provider.Provider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase
+	if p != nil {
+		s.provider = p
+	}
+}
+
+func (s *status) get() (string, provider.Provider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.phase, s.provider
+}
+
+// healthz tells whether the process is alive: its provider, if any, still
+// monitors the node. Standby processes are alive.
+func (s *status) healthz(w http.ResponseWriter, r *http.Request) {
+	_, p := s.get()
+	if np, ok := p.(node.NodeProvider); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), statusCheckTimeout)
+		defer cancel()
+		if err := np.Ping(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyz tells whether the process runs the node, and the node can run pods.
+func (s *status) readyz(w http.ResponseWriter, r *http.Request) {
+	phase, p := s.get()
+	if phase != phaseRunning {
+		http.Error(w, phase, http.StatusServiceUnavailable)
+		return
+	}
+	if readier, ok := p.(provider.Readier); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), statusCheckTimeout)
+		defer cancel()
+		if err := readier.Ready(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveStatus serves the Prometheus metrics of the provider and the health
+// endpoints of the process on addr, along with pprof when enabled, until
+// the context is done.
+func serveStatus(ctx context.Context, addr string, s *status, enablePprof bool) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	if enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx) //nolint:errcheck
+	}()
+
+	log.G(ctx).Infof("Serving metrics and health checks on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.G(ctx).WithError(err).Error("Failed to serve metrics and health checks")
+	}
+}
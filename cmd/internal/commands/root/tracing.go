@@ -38,10 +38,12 @@ var (
 	}
 )
 
-func setupTracing(ctx context.Context, c Opts) error {
+// setupTracing sets up the trace exporters, the returned function flushes
+// and stops them.
+func setupTracing(ctx context.Context, c Opts) (func(context.Context) error, error) {
 	for k := range c.TraceConfig.Tags {
 		if reservedTagNames[k] {
-			return errdefs.InvalidInputf("invalid trace tag %q, must not use a reserved tag key", k)
+			return nil, errdefs.InvalidInputf("invalid trace tag %q, must not use a reserved tag key", k)
 		}
 	}
 	if c.TraceConfig.Tags == nil {
@@ -50,6 +52,15 @@ func setupTracing(ctx context.Context, c Opts) error {
 	c.TraceConfig.Tags["operatingSystem"] = c.OperatingSystem
 	c.TraceConfig.Tags["provider"] = c.Provider
 	c.TraceConfig.Tags["nodeName"] = c.NodeName
+	for _, e := range c.TraceExporters {
+		if !isOTLPExporter(e) {
+			continue
+		}
+		if len(c.TraceExporters) > 1 {
+			return nil, errdefs.InvalidInputf("trace exporter %q cannot be combined with other exporters", e)
+		}
+		return setupOTLP(ctx, e, c.TraceSampleRate, c.TraceConfig)
+	}
 	for _, e := range c.TraceExporters {
 		if e == "zpages" {
 			setupZpages(ctx)
@@ -57,7 +68,7 @@ func setupTracing(ctx context.Context, c Opts) error {
 		}
 		exporter, err := GetTracingExporter(e, c.TraceConfig)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		octrace.RegisterExporter(exporter)
 	}
@@ -72,10 +83,10 @@ func setupTracing(ctx context.Context, c Opts) error {
 		default:
 			rate, err := strconv.Atoi(c.TraceSampleRate)
 			if err != nil {
-				return errdefs.AsInvalidInput(errors.Wrap(err, "unsupported trace sample rate"))
+				return nil, errdefs.AsInvalidInput(errors.Wrap(err, "unsupported trace sample rate"))
 			}
 			if rate < 0 || rate > 100 {
-				return errdefs.AsInvalidInput(errors.Wrap(err, "trace sample rate must be between 0 and 100"))
+				return nil, errdefs.AsInvalidInput(errors.Wrap(err, "trace sample rate must be between 0 and 100"))
 			}
 			s = octrace.ProbabilitySampler(float64(rate) / 100)
 		}
@@ -89,7 +100,7 @@ func setupTracing(ctx context.Context, c Opts) error {
 		}
 	}
 
-	return nil
+	return func(context.Context) error { return nil }, nil
 }
 
 func setupZpages(ctx context.Context) {
@@ -0,0 +1,127 @@
+package root
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/trace"
+	"github.com/virtual-kubelet/virtual-kubelet/trace/opentelemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Trace exporters sending the spans over OTLP, through OpenTelemetry rather
+// than OpenCensus. The exporter is configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables, such as
+// OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_EXPORTER_OTLP_INSECURE.
+const (
+	otlpExporter = "otlp"
+	// xrayExporter sends the spans to an OpenTelemetry collector exporting
+	// them to AWS X-Ray, such as the AWS Distro for OpenTelemetry, with trace
+	// IDs X-Ray accepts.
+	xrayExporter = "xray"
+)
+
+// traceShutdownTimeout is how long the spans left are given to be exported on exit.
+const traceShutdownTimeout = 5 * time.Second
+
+// otlpTraceExporters are the trace exporters set up by setupOTLP.
+var otlpTraceExporters = []string{otlpExporter, xrayExporter}
+
+// isOTLPExporter tells whether a trace exporter is set up by setupOTLP.
+func isOTLPExporter(name string) bool {
+	return name == otlpExporter || name == xrayExporter
+}
+
+// setupOTLP makes the spans of virtual-kubelet go through OpenTelemetry and
+// exports them over OTLP, sampled at the given rate. The returned function
+// flushes the spans left and stops the exporter.
+func setupOTLP(ctx context.Context, exporter string, sampleRate string, opts TracingExporterOptions) (func(context.Context) error, error) {
+	sampler, err := otlpSampler(sampleRate)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceNameKey.String(opts.ServiceName)}
+	for k, v := range opts.Tags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating the trace resource")
+	}
+
+	client, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating the OTLP trace exporter")
+	}
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(client),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(res),
+	}
+	if exporter == xrayExporter {
+		providerOpts = append(providerOpts, sdktrace.WithIDGenerator(xrayIDGenerator{}))
+	}
+	tp := sdktrace.NewTracerProvider(providerOpts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		log.G(ctx).Warnf("Failed to export traces: %v", err)
+	}))
+	trace.T = opentelemetry.Adapter{}
+	return tp.Shutdown, nil
+}
+
+// otlpSampler returns the sampler of the given rate, which is "always",
+// "never" or a percentage. Spans of sampled parents are always sampled.
+func otlpSampler(rate string) (sdktrace.Sampler, error) {
+	var s sdktrace.Sampler
+	switch strings.ToLower(rate) {
+	case "", "always":
+		s = sdktrace.AlwaysSample()
+	case "never":
+		s = sdktrace.NeverSample()
+	default:
+		r, err := strconv.Atoi(rate)
+		if err != nil {
+			return nil, errdefs.AsInvalidInput(errors.Wrap(err, "unsupported trace sample rate"))
+		}
+		if r < 0 || r > 100 {
+			return nil, errdefs.InvalidInput("trace sample rate must be between 0 and 100")
+		}
+		s = sdktrace.TraceIDRatioBased(float64(r) / 100)
+	}
+	return sdktrace.ParentBased(s), nil
+}
+
+// xrayIDGenerator generates trace IDs starting with their time in seconds,
+// as X-Ray requires.
+type xrayIDGenerator struct{}
+
+// NewIDs implements sdktrace.IDGenerator.
+func (g xrayIDGenerator) NewIDs(ctx context.Context) (oteltrace.TraceID, oteltrace.SpanID) {
+	var tid oteltrace.TraceID
+	binary.BigEndian.PutUint32(tid[:4], uint32(time.Now().Unix()))
+	rand.Read(tid[4:]) //nolint:errcheck
+	return tid, g.NewSpanID(ctx, tid)
+}
+
+// NewSpanID implements sdktrace.IDGenerator.
+func (xrayIDGenerator) NewSpanID(context.Context, oteltrace.TraceID) oteltrace.SpanID {
+	var sid oteltrace.SpanID
+	rand.Read(sid[:]) //nolint:errcheck
+	return sid
+}
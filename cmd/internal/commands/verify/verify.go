@@ -0,0 +1,93 @@
+package verify
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+	"github.com/spf13/cobra"
+)
+
+// NewCommand returns the command verifying attestation documents, such as
+// those served by the attest path of the kubelet API.
+func NewCommand() *cobra.Command {
+	var (
+		nonce    string
+		pcrs     []string
+		rootFile string
+	)
+	cmd := &cobra.Command{
+		Use:   "verify-attestation [file]",
+		Short: "Verify an attestation document",
+		Long: `Verify an attestation document signed by the Nitro Secure Module of an
+enclave, read from the file or the standard input, against the AWS Nitro
+Enclaves root certificate. Its module ID, signing time and PCRs are printed
+once verified.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var opts attestation.VerifyOptions
+			var err error
+			if nonce != "" {
+				if opts.Nonce, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(nonce, "=")); err != nil {
+					return fmt.Errorf("invalid nonce: %v", err)
+				}
+			}
+			if opts.PCRs, err = attestation.ParsePCRs(pcrs); err != nil {
+				return err
+			}
+			if rootFile != "" {
+				pem, err := os.ReadFile(rootFile)
+				if err != nil {
+					return err
+				}
+				opts.Roots = x509.NewCertPool()
+				if !opts.Roots.AppendCertsFromPEM(pem) {
+					return fmt.Errorf("no certificate in %s", rootFile)
+				}
+			}
+
+			in := cmd.InOrStdin()
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				in = f
+			}
+			data, err := io.ReadAll(in)
+			if err != nil {
+				return err
+			}
+
+			doc, err := attestation.Verify(data, opts)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Module: %s\nTime: %s\n", doc.ModuleID, doc.Time())
+			if len(doc.UserData) > 0 {
+				fmt.Fprintf(out, "UserData: %s\n", base64.RawURLEncoding.EncodeToString(doc.UserData))
+			}
+			indexes := make([]uint, 0, len(doc.PCRs))
+			for index := range doc.PCRs {
+				indexes = append(indexes, index)
+			}
+			sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+			for _, index := range indexes {
+				fmt.Fprintf(out, "PCR%d: %s\n", index, doc.PCR(index))
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&nonce, "nonce", "", "nonce the document must include, in base64url")
+	flags.StringArrayVar(&pcrs, "pcr", nil, "PCR the document must have, written index=hex, repeatable")
+	flags.StringVar(&rootFile, "root", "", "PEM file of the trusted root certificates, instead of the AWS Nitro Enclaves root")
+	return cmd
+}
@@ -0,0 +1,47 @@
+package enclave
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
+)
+
+// openAuditLog opens the audit log of the provider configuration, nil when
+// it has none, sending its records to CloudWatch Logs until ctx is done
+// when it has a log group. The region of the log group defaults to that of
+// the instance hosting the node.
+func openAuditLog(ctx context.Context, c *EnclaveConfig, nodeName string) (*audit.Log, error) {
+	if c.AuditLog == "" {
+		return nil, nil
+	}
+	var cloudWatch *audit.CloudWatch
+	if c.AuditLogGroup != "" {
+		awsConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the AWS configuration of the audit log: %v", err)
+		}
+		if awsConfig.Region == "" {
+			identity, err := instanceIdentity(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to detect the region of the audit log group: %v", err)
+			}
+			awsConfig.Region = identity.Region
+		}
+		cloudWatch = audit.NewCloudWatch(awsConfig, c.AuditLogGroup, nodeName)
+	}
+	log, err := audit.Open(c.AuditLog, cloudWatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit log: %v", err)
+	}
+	if cloudWatch != nil {
+		go cloudWatch.Run(ctx)
+	}
+	return log, nil
+}
+
+// AuditRequest records a kubelet API request on the enclave of a pod in the audit log.
+func (p *EnclaveProvider) AuditRequest(ctx context.Context, record audit.Record) {
+	p.node.AuditRequest(ctx, record)
+}
@@ -0,0 +1,642 @@
+package enclave
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
+	enclavenode "github.com/brave-experiments/nitro-enclave-kubelet/pkg/node"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// envPrefix prefixes the environment variables overriding the fields of the
+// provider configuration, such as NEK_CPU or NEK_RESERVED_MEMORY.
+const envPrefix = "NEK_"
+
+// logGroupPattern matches the names of CloudWatch Logs groups.
+var logGroupPattern = regexp.MustCompile(`^[\w./#-]{1,512}$`)
+
+// ConfigError reports every problem of a provider configuration at once,
+// rather than the first one found.
+type ConfigError struct {
+	// Problems are prefixed by the field or environment variable at fault.
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid provider configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Report returns the problems of the configuration one per line, for people.
+func (e *ConfigError) Report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Invalid provider configuration, %d problem(s):", len(e.Problems))
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
+
+// loadConfig loads the provider configuration of the node from the given
+// file, if any, overridden by the environment. Unknown fields and variables
+// are reported along the problems found by validateConfig.
+func loadConfig(providerConfig, nodeName string) (config EnclaveConfig, err error) {
+	var problems []string
+	if providerConfig != "" {
+		data, err := os.ReadFile(providerConfig)
+		if err != nil {
+			return config, err
+		}
+		if config, problems, err = parseConfig(data, nodeName); err != nil {
+			return config, fmt.Errorf("invalid provider configuration %s: %v", providerConfig, err)
+		}
+	}
+	problems = append(problems, unknownEnv(os.Environ())...)
+	problems = append(problems, applyEnv(&config, os.LookupEnv)...)
+
+	// Valid configurations are validated again when completed.
+	if len(problems) > 0 {
+		return config, &ConfigError{Problems: append(problems, validateConfig(&config)...)}
+	}
+	return config, nil
+}
+
+// parseConfig parses a provider configuration in YAML or JSON, whose fields
+// are those of EnclaveConfig. The legacy format maps node names to their
+// configuration, the defaults apply to nodes it has no configuration for.
+// The fields unknown to the configuration of the node are returned as problems.
+func parseConfig(data []byte, nodeName string) (EnclaveConfig, []string, error) {
+	var config EnclaveConfig
+	fields := map[string]json.RawMessage{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return config, nil, err
+	}
+	known := configFields()
+	if isLegacyConfig(fields, known) {
+		raw, ok := fields[nodeName]
+		if !ok {
+			return config, nil, nil
+		}
+		fields = map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return config, nil, err
+		}
+	}
+
+	// Fields are decoded one by one to report all their problems.
+	var problems []string
+	v := reflect.ValueOf(&config).Elem()
+	for _, name := range sortedKeys(fields) {
+		field, ok := known[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown field%s", name, suggest(name, known)))
+			continue
+		}
+		value := v.FieldByIndex(field.Index)
+		decoder := json.NewDecoder(bytes.NewReader(fields[name]))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(value.Addr().Interface()); err != nil {
+			problems = append(problems, decodeProblem(name, err))
+			value.Set(reflect.Zero(value.Type()))
+		}
+	}
+	return config, problems, nil
+}
+
+// isLegacyConfig tells whether the fields of a configuration file are those
+// of the legacy format, node names mapped to objects, rather than fields of
+// EnclaveConfig.
+func isLegacyConfig(fields map[string]json.RawMessage, known map[string]reflect.StructField) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	for name, raw := range fields {
+		if _, ok := known[name]; ok {
+			return false
+		}
+		if raw := bytes.TrimSpace(raw); len(raw) == 0 || raw[0] != '{' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeProblem describes the error decoding the field of the given name.
+func decodeProblem(name string, err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return fmt.Sprintf("%s: %s", name, strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if typeErr.Field != "" {
+		name += "." + typeErr.Field
+	}
+	problem := fmt.Sprintf("%s: expected a %s, not a %s", name, typeErr.Type, typeErr.Value)
+	if typeErr.Value == "bool" || typeErr.Value == "number" {
+		// YAML reads yes, no, on, off and numbers unless they are quoted.
+		problem += ", quote the value"
+	}
+	return problem
+}
+
+// validateConfig returns the problems of a provider configuration, whose
+// empty fields stand for their defaults.
+func validateConfig(config *EnclaveConfig) []string {
+	var problems []string
+	problemf := func(field, format string, args ...interface{}) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	quantities := []struct{ field, value string }{
+		{"cpu", config.CPU},
+		{"memory", config.Memory},
+		{"reservedCpu", config.ReservedCPU},
+		{"reservedMemory", config.ReservedMemory},
+		{"pods", config.Pods},
+	}
+	for _, key := range sortedKeys(config.Others) {
+		quantities = append(quantities, struct{ field, value string }{"others." + key, config.Others[key]})
+	}
+	for _, q := range quantities {
+		if _, err := resource.ParseQuantity(q.value); err != nil && q.value != "" {
+			problemf(q.field, "invalid quantity %q, expected e.g. \"2\", \"500m\" or \"512Mi\"", q.value)
+		}
+	}
+	if config.Enclaves != "" {
+		if n, err := strconv.Atoi(config.Enclaves); err != nil || n < 1 {
+			problemf("enclaves", "invalid %q, expected a positive integer", config.Enclaves)
+		}
+	}
+
+	if a := config.Allocator; a != nil {
+		if a.MemoryMib <= 0 {
+			problemf("allocator.memory_mib", "must be positive")
+		}
+		if a.CPUCount != 0 && a.CPUPool != "" {
+			problemf("allocator", "cpu_count and cpu_pool are mutually exclusive")
+		}
+		cpus, err := a.CPUs()
+		if err != nil {
+			problemf("allocator.cpu_pool", "%v", err)
+		} else if cpus <= 0 {
+			problemf("allocator", "cpu_count or cpu_pool must reserve CPUs")
+		}
+		// Enclaves cannot use more than the pools of the allocator.
+		if q, err := resource.ParseQuantity(config.CPU); err == nil && cpus > 0 && q.Cmp(*resource.NewQuantity(cpus, resource.DecimalSI)) > 0 {
+			problemf("cpu", "%s exceeds the %d CPUs of the allocator pool", config.CPU, cpus)
+		}
+		if q, err := resource.ParseQuantity(config.Memory); err == nil && a.MemoryMib > 0 && q.Cmp(*resource.NewQuantity(a.MemoryMib<<20, resource.BinarySI)) > 0 {
+			problemf("memory", "%s exceeds the %dMi of the allocator pool", config.Memory, a.MemoryMib)
+		}
+	}
+
+	for _, name := range sortedKeys(config.Profiles) {
+		field := "profiles." + name
+		profile := config.Profiles[name]
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			problemf(field, "invalid name: %s", strings.Join(errs, ", "))
+		}
+		if err := profile.Validate(); err != nil {
+			problemf(field, "%v", err)
+			continue
+		}
+		if profile.DebugMode == enclavenode.ProfileDebugModeAlways && !config.AllowDebugMode {
+			problemf(field, "debugMode always requires allowDebugMode")
+		}
+		if a := config.Allocator; a != nil {
+			if cpus, err := a.CPUs(); err == nil && cpus > 0 && profile.CPUs > cpus {
+				problemf(field, "%d CPUs exceed the %d CPUs of the allocator pool", profile.CPUs, cpus)
+			}
+			if a.MemoryMib > 0 && profile.MemoryMiB() > a.MemoryMib {
+				problemf(field, "%dMi of memory exceed the %dMi of the allocator pool", profile.MemoryMiB(), a.MemoryMib)
+			}
+		}
+		if profile.Kernel != "" {
+			if missing := build.MissingBlobs(profile.Kernel); len(missing) > 0 {
+				problemf(field, "kernel %s lacks %s", profile.Kernel, strings.Join(missing, ", "))
+			}
+		}
+	}
+
+	if config.AgentPath != "" {
+		if info, err := os.Stat(config.AgentPath); os.IsNotExist(err) {
+			problemf("agentPath", "%s does not exist", config.AgentPath)
+		} else if err != nil {
+			problemf("agentPath", "%v", err)
+		} else if !info.Mode().IsRegular() {
+			problemf("agentPath", "%s is not a file", config.AgentPath)
+		}
+	}
+	if config.HostRoot != "" {
+		if info, err := os.Stat(config.HostRoot); err != nil {
+			problemf("hostRoot", "%v", err)
+		} else if !info.IsDir() {
+			problemf("hostRoot", "%s is not a directory", config.HostRoot)
+		}
+	}
+	blobsPath := config.BlobsPath
+	if blobsPath == "" {
+		blobsPath = build.DefaultBlobsPath
+	}
+	if missing := build.MissingBlobs(blobsPath); len(missing) > 0 {
+		problemf("blobsPath", "%s lacks %s, install aws-nitro-enclaves-cli-devel or set the directory of the blobs", blobsPath, strings.Join(missing, ", "))
+	}
+
+	if (config.SigningCertificate == "") != (config.SigningKey == "") {
+		problemf("signingKey", "signingCertificate and signingKey are set together")
+	}
+	if config.SigningKey != "" {
+		if _, err := os.Stat(config.SigningKey); err != nil {
+			problemf("signingKey", "%v", err)
+		}
+	}
+	if config.RequireSignedImages && config.SigningCertificate == "" {
+		problemf("requireSignedImages", "requires signingCertificate")
+	}
+	if !config.RequireSignedImages && (config.TrustedSignerCAs != "" || len(config.TrustedSignerFingerprints) > 0) {
+		problemf("trustedSignerCAs", "trusted signers require requireSignedImages")
+	}
+	fingerprintsValid := true
+	for _, fingerprint := range config.TrustedSignerFingerprints {
+		if _, err := enclavenode.Fingerprint(fingerprint); err != nil {
+			problemf("trustedSignerFingerprints", "%v", err)
+			fingerprintsValid = false
+		}
+	}
+	if config.SigningCertificate != "" && fingerprintsValid {
+		if signing, err := signingPolicy(config); err != nil {
+			problemf("trustedSignerCAs", "%v", err)
+		} else if _, err := signing.VerifyCertificate(time.Now()); err != nil {
+			problemf("signingCertificate", "%v", err)
+		}
+	}
+
+	if s := config.Sandbox; s != nil {
+		if s.User == "" && (s.Group != "" || len(s.Groups) > 0) {
+			problemf("sandbox.user", "required by sandbox.group and sandbox.groups")
+		}
+		if s.TempDir != "" && !filepath.IsAbs(s.TempDir) {
+			problemf("sandbox.tempDir", "invalid %q, expected an absolute path", s.TempDir)
+		}
+		for _, name := range s.Env {
+			if name == "" || strings.ContainsAny(name, "=\x00") {
+				problemf("sandbox.env", "invalid variable name %q", name)
+			}
+		}
+	}
+
+	if config.DNSServer != "" {
+		if host, port, err := net.SplitHostPort(config.DNSServer); err != nil || host == "" {
+			problemf("dnsServer", "invalid %q, expected host:port", config.DNSServer)
+		} else if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			problemf("dnsServer", "invalid port %q", port)
+		}
+	}
+	if config.ClusterDomain != "" {
+		if errs := validation.IsDNS1123Subdomain(config.ClusterDomain); len(errs) > 0 {
+			problemf("clusterDomain", "invalid %q: %s", config.ClusterDomain, strings.Join(errs, ", "))
+		}
+	}
+	if _, err := enclavenode.NewLogSink(config.LogSink); err != nil {
+		problemf("logSink", "%v, expected \"file\", \"stdout\" or \"tcp://host:port\"", err)
+	}
+	if config.AuditLog != "" && !filepath.IsAbs(config.AuditLog) {
+		problemf("auditLog", "invalid %q, expected an absolute path", config.AuditLog)
+	}
+	if config.AuditLogGroup != "" {
+		if config.AuditLog == "" {
+			problemf("auditLogGroup", "requires auditLog")
+		}
+		if !logGroupPattern.MatchString(config.AuditLogGroup) {
+			problemf("auditLogGroup", "invalid log group name %q", config.AuditLogGroup)
+		}
+	}
+	switch config.BindAddress {
+	case "", enclavenode.BindAny, enclavenode.BindInternalIP, enclavenode.BindLocalhost:
+	default:
+		if net.ParseIP(config.BindAddress) == nil {
+			problemf("bindAddress", "invalid %q, expected an IP address, %q, %q or %q", config.BindAddress, enclavenode.BindAny, enclavenode.BindInternalIP, enclavenode.BindLocalhost)
+		}
+	}
+	if config.InternalIPv6 != "" {
+		if ip := net.ParseIP(config.InternalIPv6); ip == nil || ip.To4() != nil {
+			problemf("internalIPv6", "invalid IPv6 address %q", config.InternalIPv6)
+		}
+	}
+
+	if config.RuntimeClass != "" {
+		if errs := validation.IsDNS1123Subdomain(config.RuntimeClass); len(errs) > 0 {
+			problemf("runtimeClass", "invalid %q: %s", config.RuntimeClass, strings.Join(errs, ", "))
+		}
+	}
+	allowed := make(map[string]bool, len(config.AllowedNamespaces))
+	for _, namespace := range config.AllowedNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			problemf("allowedNamespaces", "invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		allowed[namespace] = true
+	}
+	for _, namespace := range config.DeniedNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			problemf("deniedNamespaces", "invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		if allowed[namespace] {
+			problemf("deniedNamespaces", "%q is also allowed by allowedNamespaces", namespace)
+		}
+	}
+	allowedImages := make(map[string]bool, len(config.AllowedImages))
+	for _, pattern := range config.AllowedImages {
+		if err := enclavenode.ValidateImagePattern(pattern); err != nil {
+			problemf("allowedImages", "%v", err)
+		}
+		allowedImages[pattern] = true
+	}
+	for _, pattern := range config.DeniedImages {
+		if err := enclavenode.ValidateImagePattern(pattern); err != nil {
+			problemf("deniedImages", "%v", err)
+		}
+		if allowedImages[pattern] {
+			problemf("deniedImages", "%q is also allowed by allowedImages", pattern)
+		}
+	}
+	debugAllowed := make(map[string]bool, len(config.DebugModeAllowedNamespaces))
+	for _, namespace := range config.DebugModeAllowedNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			problemf("debugModeAllowedNamespaces", "invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		debugAllowed[namespace] = true
+	}
+	if len(config.DebugModeAllowedNamespaces) > 0 && !config.AllowDebugMode {
+		problemf("debugModeAllowedNamespaces", "requires allowDebugMode")
+	}
+	for _, namespace := range config.DebugModeDeniedNamespaces {
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			problemf("debugModeDeniedNamespaces", "invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+		}
+		if debugAllowed[namespace] {
+			problemf("debugModeDeniedNamespaces", "%q is also allowed by debugModeAllowedNamespaces", namespace)
+		}
+	}
+	if config.ComputeType != "" {
+		if errs := validation.IsValidLabelValue(config.ComputeType); len(errs) > 0 {
+			problemf("computeType", "invalid %q: %s", config.ComputeType, strings.Join(errs, ", "))
+		}
+	}
+	for _, key := range sortedKeys(config.Labels) {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			problemf("labels", "invalid key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(config.Labels[key]); len(errs) > 0 {
+			problemf("labels", "invalid value %q of %q: %s", config.Labels[key], key, strings.Join(errs, ", "))
+		}
+	}
+	for _, key := range config.RemoveLabels {
+		if _, ok := config.Labels[key]; ok {
+			problemf("removeLabels", "%q is also set by labels", key)
+		}
+	}
+	for _, key := range sortedKeys(config.Annotations) {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			problemf("annotations", "invalid key %q: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	for _, taint := range config.Taints {
+		if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+			problemf("taints", "invalid key %q: %s", taint.Key, strings.Join(errs, ", "))
+		}
+		switch taint.Effect {
+		case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+		default:
+			problemf("taints", "invalid effect %q of %q, expected %s, %s or %s", taint.Effect, taint.Key, v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute)
+		}
+	}
+	if config.DedicatedTaint != "" {
+		if _, err := enclavenode.ParseDedicatedTaint(config.DedicatedTaint); err != nil {
+			problemf("dedicatedTaint", "%v", err)
+		}
+	}
+
+	if config.StatusUpdateInterval != "" {
+		if interval, err := time.ParseDuration(config.StatusUpdateInterval); err != nil || interval < 0 {
+			problemf("statusUpdateInterval", "invalid duration %q, expected e.g. \"500ms\" or \"0\"", config.StatusUpdateInterval)
+		}
+	}
+	if config.KMSEndpoint != "" {
+		if u, err := url.Parse(config.KMSEndpoint); err != nil || u.Scheme != "https" || u.Host == "" {
+			problemf("kmsEndpoint", "invalid %q, expected an https URL", config.KMSEndpoint)
+		}
+	}
+	switch config.ShutdownMode {
+	case "", shutdownModeKeep, shutdownModeDrain:
+	default:
+		problemf("shutdownMode", "invalid %q, expected %q or %q", config.ShutdownMode, shutdownModeKeep, shutdownModeDrain)
+	}
+	if config.ShutdownGracePeriod != "" {
+		if period, err := time.ParseDuration(config.ShutdownGracePeriod); err != nil || period <= 0 {
+			problemf("shutdownGracePeriod", "invalid duration %q, expected e.g. \"30s\"", config.ShutdownGracePeriod)
+		}
+	}
+	return problems
+}
+
+// configFields returns the JSON names of the fields of EnclaveConfig.
+func configFields() map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	t := reflect.TypeOf(EnclaveConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields[name] = t.Field(i)
+	}
+	return fields
+}
+
+// applyEnv overrides the fields of the configuration set by the environment,
+// returning the problems of their values. Lists and maps are comma separated,
+// as in NEK_LABELS=tier=enclave,team=x. The allocator and taints are
+// configured by file only.
+func applyEnv(config *EnclaveConfig, lookup func(string) (string, bool)) []string {
+	var problems []string
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		env := envName(name)
+		value, ok := lookup(env)
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.String:
+			field.SetString(value)
+		case field.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid boolean %q, expected true or false", env, value))
+				continue
+			}
+			field.SetBool(b)
+		case field.Type() == reflect.TypeOf([]string(nil)):
+			field.Set(reflect.ValueOf(splitList(value)))
+		case field.Type() == reflect.TypeOf(map[string]string(nil)):
+			m := make(map[string]string)
+			for _, pair := range splitList(value) {
+				key, value, ok := strings.Cut(pair, "=")
+				if !ok {
+					problems = append(problems, fmt.Sprintf("%s: invalid %q, expected key=value pairs", env, pair))
+					continue
+				}
+				m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+			field.Set(reflect.ValueOf(m))
+		default:
+			problems = append(problems, fmt.Sprintf("%s: %s is configured by file only", env, name))
+		}
+	}
+	return problems
+}
+
+// unknownEnv returns the problems of the variables of the environment which
+// are prefixed like the configuration but override no field, such as typos.
+func unknownEnv(environ []string) []string {
+	known := make(map[string]reflect.StructField)
+	for name, field := range configFields() {
+		known[envName(name)] = field
+	}
+	var problems []string
+	for _, pair := range environ {
+		env, _, _ := strings.Cut(pair, "=")
+		if _, ok := known[env]; strings.HasPrefix(env, envPrefix) && !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown variable%s", env, suggest(env, known)))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// suggest returns a hint naming the known name closest to an unknown one, if
+// any is close enough to be a typo.
+func suggest(name string, known map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for candidate := range known {
+		if d := distance(strings.ToLower(name), strings.ToLower(candidate)); d < bestDistance || d == bestDistance && candidate < best {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// distance returns the Levenshtein distance between two strings.
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// sortedKeys returns the keys of a map in order, so problems are reported in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envName returns the environment variable overriding the configuration
+// field of the given JSON name, NEK_INTERNAL_IPV6 for internalIPv6.
+func envName(field string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	var previous rune
+	for _, r := range field {
+		if unicode.IsUpper(r) && (unicode.IsLower(previous) || unicode.IsDigit(previous)) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+		previous = r
+	}
+	return b.String()
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// signingPolicy returns the policy of the node on the signatures of enclave
+// images, nil when they are not signed.
+func signingPolicy(config *EnclaveConfig) (*enclavenode.SigningPolicy, error) {
+	if config.SigningCertificate == "" {
+		return nil, nil
+	}
+	policy := &enclavenode.SigningPolicy{
+		Certificate: config.SigningCertificate,
+		Required:    config.RequireSignedImages,
+	}
+	if config.TrustedSignerCAs != "" {
+		data, err := os.ReadFile(config.TrustedSignerCAs)
+		if err != nil {
+			return nil, err
+		}
+		policy.Roots = x509.NewCertPool()
+		if !policy.Roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s has no PEM certificate", config.TrustedSignerCAs)
+		}
+	}
+	for _, fingerprint := range config.TrustedSignerFingerprints {
+		normalized, err := enclavenode.Fingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		policy.Fingerprints = append(policy.Fingerprints, normalized)
+	}
+	return policy, nil
+}
@@ -2,13 +2,21 @@ package enclave
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
 	enclavenode "github.com/brave-experiments/nitro-enclave-kubelet/pkg/node"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/sandbox"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
@@ -17,16 +25,39 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
-	// Provider configuration defaults.
+	// Provider configuration defaults. The CPU and memory capacity default
+	// to the enclave pools of the allocator when they can be read.
 	defaultCPUCapacity            = "4"
 	defaultMemoryCapacity         = "1024Mi"
 	defaultReservedCPUCapacity    = "2"
 	defaultReservedMemoryCapacity = "512Mi"
 	defaultPodCapacity            = "10"
-	defaultNitroEnclaveCapacity   = "1"
+	defaultAgentPath              = "/bin/nitro-agent"
+	defaultLogDir                 = "/var/log/nitro-enclave-kubelet"
+	defaultStateDir               = "/var/lib/nitro-enclave-kubelet"
+	defaultResolvConf             = "/etc/resolv.conf"
+	defaultStatusUpdateInterval   = "1s"
+	defaultShutdownGracePeriod    = "30s"
+	defaultDedicatedTaint         = enclavenode.DedicatedTaintKey + "=true"
+
+	// Shutdown modes of the provider, see EnclaveConfig.ShutdownMode.
+	shutdownModeKeep  = "keep"
+	shutdownModeDrain = "drain"
+
+	// nitroEnclavesResource is how many enclaves the node runs at once, the
+	// extended resource of the Nitro Enclaves device plugin.
+	nitroEnclavesResource v1.ResourceName = "aws.ec2.nitro/nitro_enclaves"
+
+	// computeTypeLabel tells EKS the compute type of a node.
+	computeTypeLabel = "eks.amazonaws.com/compute-type"
+	// legacyExcludeBalancersLabel is the alpha label excluding nodes from
+	// load balancers, still honoured by older service controllers.
+	legacyExcludeBalancersLabel = "alpha.service-controller.kubernetes.io/exclude-balancer"
 
 	// Values used in tracing as attribute keys.
 	namespaceKey     = "namespace"
@@ -36,6 +67,8 @@ const (
 
 var (
 	errNotImplemented = fmt.Errorf("not implemented by Nitro Enclave provider")
+	// defaultRemoveLabels are the labels of virtual-kubelet nodes which enclave nodes do not have.
+	defaultRemoveLabels = []string{"kubernetes.io/role"}
 )
 
 // EnclaveProvider implements the virtual-kubelet provider interface and stores pods in memory.
@@ -46,9 +79,26 @@ type EnclaveProvider struct { //nolint:golint
 	daemonEndpointPort int32
 
 	node      *enclavenode.Node
-	config    EnclaveConfig
 	startTime time.Time
-	notifier  func(*v1.Pod)
+	// systemInfo describes the host of the node.
+	systemInfo v1.NodeSystemInfo
+	// dedicatedTaint is the taint pods must tolerate, none when nil.
+	dedicatedTaint *v1.Taint
+
+	// config is the provider configuration, configPath its file and
+	// configData its contents as last loaded, guarded by nodeMu.
+	config     EnclaveConfig
+	configPath string
+	configData []byte
+	// detected holds the settings detected from the host at startup, reused
+	// when the configuration is reloaded.
+	detected EnclaveConfig
+
+	// nodeSpec is the node configured by ConfigureNode, and notifyNodeStatus
+	// the callback updating its status, guarded by nodeMu.
+	nodeSpec         *v1.Node
+	notifyNodeStatus func(*v1.Node)
+	nodeMu           sync.Mutex
 }
 
 // EnclaveConfig contains a enclave virtual-kubelet's configurable parameters.
@@ -59,32 +109,246 @@ type EnclaveConfig struct { //nolint:golint
 	ReservedMemory string            `json:"reservedMemory,omitempty"`
 	Pods           string            `json:"pods,omitempty"`
 	Others         map[string]string `json:"others,omitempty"`
-	ProviderID     string            `json:"providerID,omitempty"`
+	// ProviderID is the provider ID of the node, detected from the instance
+	// metadata service of the EC2 instance hosting it when empty.
+	ProviderID string `json:"providerID,omitempty"`
+	// Allocator, when set, is the enclave memory and CPU pool to reserve on the host.
+	Allocator *allocator.Config `json:"allocator,omitempty"`
+	// AgentPath is the host path of the agent binary installed in every enclave.
+	AgentPath string `json:"agentPath,omitempty"`
+	// BlobsPath is the directory of the kernel, init and linuxkit blobs
+	// enclave images are built with, that of aws-nitro-enclaves-cli-devel
+	// when empty.
+	BlobsPath string `json:"blobsPath,omitempty"`
+	// SigningCertificate and SigningKey are the PEM files of the certificate
+	// and private key enclave images are signed with, which PCR8 of the
+	// enclaves measures. Images are not signed when empty.
+	SigningCertificate string `json:"signingCertificate,omitempty"`
+	SigningKey         string `json:"signingKey,omitempty"`
+	// Sandbox, when set, restricts the helper binaries the node runs,
+	// linuxkit, eif_build and nitro-cli, so a compromised blob cannot act
+	// as the provider: they run as a dedicated user, with no_new_privs, and
+	// keep their files in a directory of their own.
+	Sandbox *sandbox.Config `json:"sandbox,omitempty"`
+	// RequireSignedImages refuses to launch the enclave images which
+	// describe-eif does not report as validly signed by the signing
+	// certificate, and fails their pods.
+	RequireSignedImages bool `json:"requireSignedImages,omitempty"`
+	// TrustedSignerCAs is a PEM file of the certificate authorities the
+	// signing certificate must chain to, and TrustedSignerFingerprints are
+	// SHA-256 fingerprints one of which it must have. They are checked on
+	// start and before every launch, so an expired or revoked signing
+	// certificate launches no more enclaves.
+	TrustedSignerCAs          string   `json:"trustedSignerCAs,omitempty"`
+	TrustedSignerFingerprints []string `json:"trustedSignerFingerprints,omitempty"`
+	// RequireEnclavePolicies fails the pods whose enclave image cannot be
+	// checked against the EnclavePolicies of their namespace as their
+	// resource is not installed, rather than launch it with a warning.
+	RequireEnclavePolicies bool `json:"requireEnclavePolicies,omitempty"`
+	// KMSEndpoint is the URL the node decrypts the KMS secrets of pods at,
+	// such as a VPC endpoint, the KMS endpoint of their region when empty.
+	KMSEndpoint string `json:"kmsEndpoint,omitempty"`
+	// KMSNodeCredentials decrypts the KMS secrets of the pods with no IAM
+	// role bound to their service account with the credentials of the node,
+	// rather than fail to release them.
+	KMSNodeCredentials bool `json:"kmsNodeCredentials,omitempty"`
+	// LogDir is where the logs of enclaves are kept.
+	LogDir string `json:"logDir,omitempty"`
+	// StateDir is where pod specs are kept to recover pods when the provider restarts.
+	StateDir string `json:"stateDir,omitempty"`
+	// Enclaves is how many enclaves can run at once, detected from nitro-cli when empty.
+	Enclaves string `json:"enclaves,omitempty"`
+	// AllowDebugMode lets pods run their enclave in debug mode, which cannot be attested.
+	AllowDebugMode bool `json:"allowDebugMode,omitempty"`
+	// DebugModeAllowedNamespaces, when set, are the only namespaces whose
+	// pods may run their enclave in debug mode, which requires AllowDebugMode.
+	DebugModeAllowedNamespaces []string `json:"debugModeAllowedNamespaces,omitempty"`
+	// DebugModeDeniedNamespaces are namespaces whose pods may not run their
+	// enclave in debug mode, such as those of production workloads.
+	DebugModeDeniedNamespaces []string `json:"debugModeDeniedNamespaces,omitempty"`
+	// Profiles are the size classes of enclaves, such as small or large,
+	// which pods select by name with the profile annotation.
+	Profiles map[string]enclavenode.Profile `json:"profiles,omitempty"`
+	// RuntimeClass, when set, is the runtime class pods must request to run
+	// on the node, such as nitro-enclave. The node is labelled with it so the
+	// RuntimeClass schedules its pods there.
+	RuntimeClass string `json:"runtimeClass,omitempty"`
+	// AllowedNamespaces, when set, are the only namespaces whose pods may run
+	// enclaves on the node. Pods of other namespaces are rejected.
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// DeniedNamespaces are namespaces whose pods may not run enclaves on the node.
+	DeniedNamespaces []string `json:"deniedNamespaces,omitempty"`
+	// AllowedImages, when set, are the only images pods may run in enclaves
+	// on the node: registries such as registry.example.com, prefixes such as
+	// registry.example.com/team/*, repositories with or without a tag or
+	// digest, or sha256 digests. Pods of other images are rejected before
+	// anything is pulled or built.
+	AllowedImages []string `json:"allowedImages,omitempty"`
+	// DeniedImages are images pods may not run in enclaves on the node, even
+	// when allowed, with the patterns of AllowedImages.
+	DeniedImages []string `json:"deniedImages,omitempty"`
+	// RequireEnclaveResources rejects the pods which do not request the vCPUs
+	// and memory of their enclave as the aws.ec2.nitro/enclave_cpus and
+	// aws.ec2.nitro/enclave_memory_mib extended resources, so the resource
+	// quotas of their namespace cap them.
+	RequireEnclaveResources bool `json:"requireEnclaveResources,omitempty"`
+	// DNSServer is the host:port of the name server resolving the DNS queries
+	// of enclaves, the first one of the host's resolv.conf when empty.
+	DNSServer string `json:"dnsServer,omitempty"`
+	// ClusterDomain is the DNS domain of the cluster searched by enclaves, cluster.local when empty.
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+	// LogSink is where the logs of enclaves are shipped on top of their log
+	// files: "file" for nowhere else, "stdout", or a "tcp://host:port" collector.
+	LogSink string `json:"logSink,omitempty"`
+	// AuditLog is the file the builds, launches and terminations of enclaves,
+	// and the console, exec and attach requests on them, are appended to as
+	// JSON lines. Nothing is audited when empty.
+	AuditLog string `json:"auditLog,omitempty"`
+	// AuditLogGroup, when set, is the CloudWatch Logs group the audit records
+	// are sent to as well, in a log stream named after the node.
+	AuditLogGroup string `json:"auditLogGroup,omitempty"`
+	// BindAddress is the host address the port proxies of pods listen on:
+	// "any" (the default), "internal-ip", "localhost" or an IP address.
+	// Pods may override it with the bind-address annotation.
+	BindAddress string `json:"bindAddress,omitempty"`
+	// InternalIPv6 is the IPv6 address of dual-stack nodes, reported along
+	// their internal IP, which is IPv4. IPv6-only nodes have an IPv6 internal IP.
+	InternalIPv6 string `json:"internalIPv6,omitempty"`
+	// InternalDNS is the private DNS name of the node, the local hostname of
+	// the EC2 instance hosting it when empty.
+	InternalDNS string `json:"internalDNS,omitempty"`
+	// HostRoot is where the root filesystem of the host is mounted when the
+	// provider runs in a container, so the node info describes the host
+	// rather than the container: its distribution, machine ID and system
+	// UUID. The host files are read at / when empty.
+	HostRoot string `json:"hostRoot,omitempty"`
+	// Hostname is the hostname of the node, that of the host when empty.
+	Hostname string `json:"hostname,omitempty"`
+	// ComputeType, when set, is the eks.amazonaws.com/compute-type label of
+	// the node. EKS keeps daemonsets, which cannot run in enclaves, off nodes
+	// of the fargate compute type, but handles their pods differently too.
+	ComputeType string `json:"computeType,omitempty"`
+	// ExcludeFromLoadBalancers labels the node so service controllers do not
+	// add it to the external load balancers of services.
+	ExcludeFromLoadBalancers bool `json:"excludeFromLoadBalancers,omitempty"`
+	// Labels are set on the node, overriding the ones above.
+	Labels map[string]string `json:"labels,omitempty"`
+	// RemoveLabels are removed from the node, kubernetes.io/role when nil.
+	RemoveLabels []string `json:"removeLabels,omitempty"`
+	// Annotations are set on the node.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Taints are added to the node along the virtual-kubelet taint, so only
+	// pods tolerating them run in enclaves.
+	Taints []v1.Taint `json:"taints,omitempty"`
+	// DedicatedTaint is the NoSchedule taint of the node pods must tolerate
+	// to be admitted, "key=value" or "key", aws.ec2.nitro/enclave=true when
+	// empty. "none" disables it.
+	DedicatedTaint string `json:"dedicatedTaint,omitempty"`
+	// StatusUpdateInterval is the minimum interval between the status updates
+	// of a pod, such as "500ms", which are coalesced meanwhile. "0" notifies
+	// every change right away.
+	StatusUpdateInterval string `json:"statusUpdateInterval,omitempty"`
+	// ShutdownMode is what becomes of the enclaves when the provider exits:
+	// "keep" (the default) leaves them running for the next provider to
+	// adopt, "drain" stops them gracefully and fails their pods.
+	ShutdownMode string `json:"shutdownMode,omitempty"`
+	// ShutdownGracePeriod bounds how long draining the enclaves takes, 30s when empty.
+	ShutdownGracePeriod string `json:"shutdownGracePeriod,omitempty"`
 }
 
-// NewEnclaveProviderEnclaveConfig creates a new EnclaveV0Provider. Enclave legacy provider does not implement the new asynchronous podnotifier interface
-func NewEnclaveProviderEnclaveConfig(ctx context.Context, config EnclaveConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32) (*EnclaveProvider, error) {
-	// set defaults
-	if config.CPU == "" {
-		config.CPU = defaultCPUCapacity
+// NewEnclaveProviderEnclaveConfig creates a new EnclaveProvider, which implements the PodNotifier interface
+func NewEnclaveProviderEnclaveConfig(ctx context.Context, config EnclaveConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32, resources enclavenode.ResourceGetter, client kubernetes.Interface, recorder record.EventRecorder) (*EnclaveProvider, error) {
+	// The helpers are sandboxed from the first one, as nitro-cli detects
+	// the capacity of the node.
+	if config.Sandbox != nil {
+		helpers, err := sandbox.New(*config.Sandbox)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up the sandbox of the helpers: %v", err)
+		}
+		build.SetSandbox(helpers)
+		cli.SetSandbox(helpers)
 	}
-	if config.ReservedCPU == "" {
-		config.ReservedCPU = defaultReservedCPUCapacity
+	var detected EnclaveConfig
+	enclaves, err := completeConfig(ctx, &config, &detected)
+	if err != nil {
+		return nil, err
 	}
-	if config.Memory == "" {
-		config.Memory = defaultMemoryCapacity
+	if internalIP == "" {
+		if internalIP, err = detectInternalIP(ctx); err != nil {
+			return nil, fmt.Errorf("failed to detect the internal IP of the node: %v", err)
+		}
+		log.G(ctx).Infof("Detected internal IP %s", internalIP)
 	}
-	if config.ReservedMemory == "" {
-		config.ReservedMemory = defaultReservedMemoryCapacity
+
+	logSink, err := enclavenode.NewLogSink(config.LogSink)
+	if err != nil {
+		return nil, err
 	}
-	if config.Pods == "" {
-		config.Pods = defaultPodCapacity
+	auditLog, err := openAuditLog(ctx, &config, nodeName)
+	if err != nil {
+		return nil, err
 	}
-
-	en, err := enclavenode.NewNode(ctx, &enclavenode.NodeConfig{Name: nodeName}, internalIP)
+	statusUpdateInterval, err := time.ParseDuration(config.StatusUpdateInterval)
+	if err != nil {
+		return nil, err
+	}
+	dedicatedTaint, err := enclavenode.ParseDedicatedTaint(config.DedicatedTaint)
 	if err != nil {
 		return nil, err
 	}
+	signing, err := signingPolicy(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the signing policy: %v", err)
+	}
+	build.SetSigner(config.SigningCertificate, config.SigningKey)
+
+	nodeConfig := &enclavenode.NodeConfig{
+		Name:           nodeName,
+		AgentPath:      config.AgentPath,
+		BlobsPath:      config.BlobsPath,
+		LogDir:         config.LogDir,
+		StateDir:       config.StateDir,
+		Resources:      resources,
+		Client:         client,
+		Recorder:       recorder,
+		MaxEnclaves:    enclaves,
+		AllowDebugMode: config.AllowDebugMode,
+		Profiles:       config.Profiles,
+		RuntimeClass:   config.RuntimeClass,
+		DedicatedTaint: dedicatedTaint,
+		DNSServer:      config.DNSServer,
+		ClusterDomain:  config.ClusterDomain,
+		LogSink:        logSink,
+		Audit:          auditLog,
+		BindAddress:    config.BindAddress,
+		InternalIPv6:   config.InternalIPv6,
+
+		AllowedNamespaces:       config.AllowedNamespaces,
+		DeniedNamespaces:        config.DeniedNamespaces,
+		RequireEnclaveResources: config.RequireEnclaveResources,
+		Signing:                 signing,
+		RequireEnclavePolicies:  config.RequireEnclavePolicies,
+		KMSEndpoint:             config.KMSEndpoint,
+		KMSNodeCredentials:      config.KMSNodeCredentials,
+		StatusUpdateInterval:    statusUpdateInterval,
+
+		AllowedImages: config.AllowedImages,
+		DeniedImages:  config.DeniedImages,
+
+		DebugModeAllowedNamespaces: config.DebugModeAllowedNamespaces,
+		DebugModeDeniedNamespaces:  config.DebugModeDeniedNamespaces,
+	}
+	en, err := enclavenode.NewNode(ctx, nodeConfig, internalIP)
+	if err != nil {
+		return nil, err
+	}
+	go en.CollectOrphans(ctx)
+	go en.MonitorPressure(ctx)
+	go en.MonitorHealth(ctx)
+	go en.WatchEphemeralContainers(ctx)
+	go en.WatchDrain(ctx)
+	metrics.CountRunningEnclaves(en.RunningEnclaves)
+	go en.Reconcile(ctx)
 
 	provider := EnclaveProvider{
 		nodeName:           nodeName,
@@ -93,67 +357,183 @@ func NewEnclaveProviderEnclaveConfig(ctx context.Context, config EnclaveConfig,
 		daemonEndpointPort: daemonEndpointPort,
 		node:               en,
 		config:             config,
+		detected:           detected,
 		startTime:          time.Now(),
+		systemInfo:         systemInfo(ctx, operatingSystem, config.HostRoot),
+		dedicatedTaint:     dedicatedTaint,
 	}
 
+	applyAllocatorConfig(ctx, &provider.config)
+	detectCapacity(ctx, &provider.config)
+
 	return &provider, nil
 }
 
-// NewEnclaveProvider creates a new EnclaveProvider, which implements the PodNotifier interface
-func NewEnclaveProvider(ctx context.Context, providerConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32) (*EnclaveProvider, error) {
-	config, err := loadConfig(providerConfig, nodeName)
+// completeConfig validates a provider configuration and sets its defaults,
+// returning how many enclaves the node runs at once. Settings detected from
+// the host are recorded in detected, and taken from it rather than detected
+// again when already there.
+func completeConfig(ctx context.Context, config, detected *EnclaveConfig) (int, error) {
+	if problems := validateConfig(config); len(problems) > 0 {
+		return 0, &ConfigError{Problems: problems}
+	}
+
+	// set defaults
+	if config.ReservedCPU == "" {
+		config.ReservedCPU = defaultReservedCPUCapacity
+	}
+	if config.ReservedMemory == "" {
+		config.ReservedMemory = defaultReservedMemoryCapacity
+	}
+	if config.Pods == "" {
+		config.Pods = defaultPodCapacity
+	}
+	if config.AgentPath == "" {
+		config.AgentPath = defaultAgentPath
+	}
+	if config.BlobsPath == "" {
+		config.BlobsPath = build.DefaultBlobsPath
+	}
+	if config.LogDir == "" {
+		config.LogDir = defaultLogDir
+	}
+	if config.StateDir == "" {
+		config.StateDir = defaultStateDir
+	}
+	if config.DNSServer == "" {
+		if detected.DNSServer == "" {
+			detected.DNSServer = nitro.SystemDNSServer(defaultResolvConf)
+		}
+		config.DNSServer = detected.DNSServer
+	}
+	if config.Enclaves == "" {
+		if detected.Enclaves == "" {
+			detected.Enclaves = detectEnclaveCapacity(ctx)
+		}
+		config.Enclaves = detected.Enclaves
+	}
+	if config.ProviderID == "" {
+		if detected.ProviderID == "" {
+			providerID, err := detectProviderID(ctx)
+			if err != nil {
+				log.G(ctx).Warnf("Failed to detect the provider ID of the node: %v", err)
+			}
+			detected.ProviderID = providerID
+		}
+		config.ProviderID = detected.ProviderID
+	}
+	if config.RemoveLabels == nil {
+		config.RemoveLabels = defaultRemoveLabels
+	}
+	if config.StatusUpdateInterval == "" {
+		config.StatusUpdateInterval = defaultStatusUpdateInterval
+	}
+	if config.ShutdownMode == "" {
+		config.ShutdownMode = shutdownModeKeep
+	}
+	if config.ShutdownGracePeriod == "" {
+		config.ShutdownGracePeriod = defaultShutdownGracePeriod
+	}
+	if config.DedicatedTaint == "" {
+		config.DedicatedTaint = defaultDedicatedTaint
+	}
+	enclaves, err := strconv.Atoi(config.Enclaves)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("invalid enclaves %q: %v", config.Enclaves, err)
 	}
+	if config.InternalDNS == "" {
+		if detected.InternalDNS == "" {
+			if detected.InternalDNS, err = detectInternalDNS(ctx); err != nil {
+				log.G(ctx).Debugf("Failed to detect the internal DNS name of the node: %v", err)
+			}
+		}
+		config.InternalDNS = detected.InternalDNS
+	}
+	if config.Hostname == "" {
+		if detected.Hostname == "" {
+			if detected.Hostname, err = os.Hostname(); err != nil {
+				log.G(ctx).Warnf("Failed to get the hostname of the node: %v", err)
+			}
+		}
+		config.Hostname = detected.Hostname
+	}
+	return enclaves, nil
+}
 
-	return NewEnclaveProviderEnclaveConfig(ctx, config, nodeName, operatingSystem, internalIP, daemonEndpointPort)
+// detectEnclaveCapacity returns how many enclaves the installed nitro-cli can run at once.
+func detectEnclaveCapacity(ctx context.Context) string {
+	version, err := cli.Version()
+	if err != nil {
+		log.G(ctx).Warnf("Failed to detect nitro-cli version, running a single enclave at once: %v", err)
+		return "1"
+	}
+	return strconv.Itoa(cli.MaxEnclaves(version))
 }
 
-// loadConfig loads the given json configuration files.
-func loadConfig(providerConfig, nodeName string) (config EnclaveConfig, err error) {
-	data, err := os.ReadFile(providerConfig)
+// applyAllocatorConfig resizes the host's enclave pool to match the provider configuration.
+func applyAllocatorConfig(ctx context.Context, config *EnclaveConfig) {
+	if config.Allocator == nil {
+		return
+	}
+
+	changed, err := allocator.Resize(ctx, allocator.DefaultConfigPath, *config.Allocator)
 	if err != nil {
-		return config, err
+		log.G(ctx).Errorf("Failed to resize enclave allocator pool: %v.\n", err)
+		return
 	}
-	configMap := map[string]EnclaveConfig{}
-	err = json.Unmarshal(data, &configMap)
+	if changed {
+		log.G(ctx).Infof("Resized enclave allocator pool to %+v", *config.Allocator)
+	}
+}
+
+// detectCapacity sets the CPU and memory capacity left unset by the
+// configuration to the enclave pools reserved by the allocator, which is all
+// enclaves can use. Defaults are used when the pools cannot be read.
+func detectCapacity(ctx context.Context, config *EnclaveConfig) {
+	if config.CPU != "" && config.Memory != "" {
+		return
+	}
+
+	capacity, err := allocator.ReadCapacity()
 	if err != nil {
-		return config, err
+		log.G(ctx).Warnf("Failed to read the enclave pools, using the default capacity: %v", err)
+		capacity = &allocator.Capacity{}
 	}
-	if _, exist := configMap[nodeName]; exist {
-		config = configMap[nodeName]
-		if config.CPU == "" {
-			config.CPU = defaultCPUCapacity
-		}
-		if config.ReservedCPU == "" {
-			config.ReservedCPU = defaultReservedCPUCapacity
-		}
-		if config.Memory == "" {
-			config.Memory = defaultMemoryCapacity
-		}
-		if config.ReservedMemory == "" {
-			config.ReservedMemory = defaultReservedMemoryCapacity
+	if config.CPU == "" {
+		config.CPU = defaultCPUCapacity
+		if capacity.CPUs > 0 {
+			config.CPU = strconv.FormatInt(capacity.CPUs, 10)
 		}
-		if config.Pods == "" {
-			config.Pods = defaultPodCapacity
+	}
+	if config.Memory == "" {
+		config.Memory = defaultMemoryCapacity
+		if capacity.MemoryMib > 0 {
+			config.Memory = fmt.Sprintf("%dMi", capacity.MemoryMib)
 		}
 	}
+	log.G(ctx).Infof("Node capacity is %s CPUs and %s of memory", config.CPU, config.Memory)
+}
 
-	if _, err = resource.ParseQuantity(config.CPU); err != nil {
-		return config, fmt.Errorf("Invalid CPU value %v", config.CPU)
-	}
-	if _, err = resource.ParseQuantity(config.Memory); err != nil {
-		return config, fmt.Errorf("Invalid memory value %v", config.Memory)
+// NewEnclaveProvider creates a new EnclaveProvider, which implements the PodNotifier interface
+func NewEnclaveProvider(ctx context.Context, providerConfig, nodeName, operatingSystem string, internalIP string, daemonEndpointPort int32, resources enclavenode.ResourceGetter, client kubernetes.Interface, recorder record.EventRecorder) (*EnclaveProvider, error) {
+	config, err := loadConfig(providerConfig, nodeName)
+	if err != nil {
+		return nil, err
 	}
-	if _, err = resource.ParseQuantity(config.Pods); err != nil {
-		return config, fmt.Errorf("Invalid pods value %v", config.Pods)
+
+	provider, err := NewEnclaveProviderEnclaveConfig(ctx, config, nodeName, operatingSystem, internalIP, daemonEndpointPort, resources, client, recorder)
+	if err != nil {
+		return nil, err
 	}
-	for _, v := range config.Others {
-		if _, err = resource.ParseQuantity(v); err != nil {
-			return config, fmt.Errorf("Invalid other value %v", v)
+	// Deployments configured by the environment only have nothing to reload.
+	if providerConfig != "" {
+		if provider.configData, err = os.ReadFile(providerConfig); err != nil {
+			return nil, err
 		}
+		provider.configPath = providerConfig
+		go provider.watchConfig(ctx)
 	}
-	return config, nil
+	return provider, nil
 }
 
 // CreatePod accepts a Pod definition and launches it as an enclave
@@ -165,22 +545,32 @@ func (p *EnclaveProvider) CreatePod(ctx context.Context, pod *v1.Pod) error {
 	ctx = addAttributes(ctx, span, namespaceKey, pod.Namespace, nameKey, pod.Name)
 
 	log.G(ctx).Infof("receive CreatePod %q", pod.Name)
+	start := time.Now()
+	result := "failed"
+	defer func() {
+		metrics.CreatePodDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	}()
 
 	enclavePod, err := enclavenode.NewPod(ctx, p.node, pod)
 	if err != nil {
+		// Pods the node can never run fail for good rather than being retried.
+		var rejection *enclavenode.AdmissionError
+		if errors.As(err, &rejection) && rejection.Terminal {
+			p.node.RejectPod(ctx, pod, rejection)
+			result = "rejected"
+			return nil
+		}
 		log.G(ctx).Errorf("Failed to create pod: %v.\n", err)
 		return err
 	}
 
-	err = enclavePod.Start(ctx, p.notifier)
+	err = enclavePod.Start(ctx)
 	if err != nil {
 		log.G(ctx).Errorf("Failed to start pod: %v.\n", err)
 		return err
 	}
 
-	pod.Status = enclavePod.GetStatus()
-	p.notifier(pod)
-
+	result = "created"
 	return nil
 }
 
@@ -194,8 +584,19 @@ func (p *EnclaveProvider) UpdatePod(ctx context.Context, pod *v1.Pod) error {
 
 	log.G(ctx).Infof("receive UpdatePod %q", pod.Name)
 
-	// TODO add limited support to allow recovering from kubelet restart?
-	return errNotImplemented
+	enclavePod, err := p.node.GetPodByUID(pod.Namespace, pod.Name, pod.UID)
+	if err != nil {
+		log.G(ctx).Errorf("Failed to get pod: %v.\n", err)
+		return err
+	}
+
+	_, err = enclavePod.Update(ctx, pod)
+	if err != nil {
+		log.G(ctx).Errorf("Failed to update pod: %v.\n", err)
+		return err
+	}
+
+	return nil
 }
 
 // DeletePod deletes the pod, terminating the running enclave.
@@ -208,7 +609,8 @@ func (p *EnclaveProvider) DeletePod(ctx context.Context, pod *v1.Pod) (err error
 
 	log.G(ctx).Infof("receive DeletePod %q", pod.Name)
 
-	enclavePod, err := p.node.GetPod(pod.Namespace, pod.Name)
+	// Only stop the enclave of this incarnation of the pod, not one recreated with the same name.
+	enclavePod, err := p.node.GetPodByUID(pod.Namespace, pod.Name, pod.UID)
 	if err != nil {
 		log.G(ctx).Errorf("Failed to get pod: %v.\n", err)
 		return err
@@ -267,15 +669,43 @@ func (p *EnclaveProvider) GetContainerLogs(ctx context.Context, namespace, podNa
 // RunInContainer executes a command in a container in the pod, copying data
 // between in/out/err and the container's stdin/stdout/stderr.
 func (p *EnclaveProvider) RunInContainer(ctx context.Context, namespace, name, container string, cmd []string, attach api.AttachIO) error {
-	log.G(context.TODO()).Infof("receive ExecInContainer %q", container)
-	return nil
+	ctx, span := trace.StartSpan(ctx, "RunInContainer")
+	defer span.End()
+
+	// Add pod and container attributes to the current span.
+	ctx = addAttributes(ctx, span, namespaceKey, namespace, nameKey, name, containerNameKey, container)
+
+	log.G(ctx).Infof("receive ExecInContainer %q", container)
+
+	return p.node.RunInContainer(ctx, namespace, name, cmd, attach)
+}
+
+// Attest returns a fresh attestation document of the enclave of a pod,
+// including the nonce and user data of the caller.
+func (p *EnclaveProvider) Attest(ctx context.Context, namespace, name string, nonce, userData []byte) ([]byte, error) {
+	ctx, span := trace.StartSpan(ctx, "Attest")
+	defer span.End()
+
+	// Add namespace and name as attributes to the current span.
+	ctx = addAttributes(ctx, span, namespaceKey, namespace, nameKey, name)
+
+	log.G(ctx).Infof("receive Attest %q", name)
+
+	return p.node.Attest(ctx, namespace, name, nonce, userData)
 }
 
 // AttachToContainer attaches to the executing process of a container in the pod, copying data
 // between in/out/err and the container's stdin/stdout/stderr.
 func (p *EnclaveProvider) AttachToContainer(ctx context.Context, namespace, name, container string, attach api.AttachIO) error {
+	ctx, span := trace.StartSpan(ctx, "AttachToContainer")
+	defer span.End()
+
+	// Add pod and container attributes to the current span.
+	ctx = addAttributes(ctx, span, namespaceKey, namespace, nameKey, name, containerNameKey, container)
+
 	log.G(ctx).Infof("receive AttachToContainer %q", container)
-	return nil
+
+	return p.node.AttachToContainer(ctx, namespace, name, container, attach)
 }
 
 // GetPodStatus returns the status of a pod by name that is "running".
@@ -333,64 +763,271 @@ func (p *EnclaveProvider) ConfigureNode(ctx context.Context, n *v1.Node) { //nol
 	ctx, span := trace.StartSpan(ctx, "enclave.ConfigureNode") //nolint:staticcheck,ineffassign
 	defer span.End()
 
-	if p.config.ProviderID != "" {
-		n.Spec.ProviderID = p.config.ProviderID
+	// The configuration may be reloaded meanwhile, the node is configured
+	// from a single version of it.
+	config := p.currentConfig()
+	if config.ProviderID != "" {
+		n.Spec.ProviderID = config.ProviderID
 	}
-	n.Status.Capacity = p.capacity()
-	n.Status.Allocatable = p.allocatable()
+	n.Status.Capacity = p.capacity(&config)
+	n.Status.Allocatable = p.allocatable(&config)
 	n.Status.Conditions = p.nodeConditions()
-	n.Status.Addresses = p.nodeAddresses()
+	n.Status.Addresses = p.nodeAddresses(&config)
 	n.Status.DaemonEndpoints = p.nodeDaemonEndpoints()
-	os := p.operatingSystem
-	if os == "" {
-		os = "linux"
+	kubeletVersion := n.Status.NodeInfo.KubeletVersion
+	n.Status.NodeInfo = p.systemInfo
+	n.Status.NodeInfo.KubeletVersion = kubeletVersion
+	p.applyNodeMetadata(n, &config)
+
+	p.nodeMu.Lock()
+	p.nodeSpec = n
+	p.nodeMu.Unlock()
+}
+
+// currentConfig returns the provider configuration as last loaded.
+func (p *EnclaveProvider) currentConfig() EnclaveConfig {
+	p.nodeMu.Lock()
+	defer p.nodeMu.Unlock()
+	return p.config
+}
+
+// applyNodeMetadata sets the labels, annotations and taints of the node configured by the operator.
+func (p *EnclaveProvider) applyNodeMetadata(n *v1.Node, config *EnclaveConfig) {
+	for _, key := range config.RemoveLabels {
+		delete(n.ObjectMeta.Labels, key)
+	}
+	labels := nodeLabels(config)
+	if n.ObjectMeta.Labels == nil && len(labels) > 0 {
+		n.ObjectMeta.Labels = make(map[string]string, len(labels))
+	}
+	for key, value := range labels {
+		n.ObjectMeta.Labels[key] = value
+	}
+	if n.ObjectMeta.Annotations == nil && len(config.Annotations) > 0 {
+		n.ObjectMeta.Annotations = make(map[string]string, len(config.Annotations))
+	}
+	for key, value := range config.Annotations {
+		n.ObjectMeta.Annotations[key] = value
+	}
+	for _, taint := range config.Taints {
+		if !hasTaint(n.Spec.Taints, taint) {
+			n.Spec.Taints = append(n.Spec.Taints, taint)
+		}
+	}
+	if p.dedicatedTaint != nil && !hasTaint(n.Spec.Taints, *p.dedicatedTaint) {
+		n.Spec.Taints = append(n.Spec.Taints, *p.dedicatedTaint)
 	}
-	n.Status.NodeInfo.OperatingSystem = os
-	n.Status.NodeInfo.Architecture = "amd64"
-	delete(n.ObjectMeta.Labels, "kubernetes.io/role")
+}
+
+// nodeLabels returns the labels of the node configured by the operator.
+func nodeLabels(config *EnclaveConfig) map[string]string {
+	labels := make(map[string]string)
+	if config.ComputeType != "" {
+		labels[computeTypeLabel] = config.ComputeType
+	}
+	if config.RuntimeClass != "" {
+		labels[enclavenode.RuntimeClassLabel] = config.RuntimeClass
+	}
+	if config.ExcludeFromLoadBalancers {
+		labels[v1.LabelNodeExcludeBalancers] = "true"
+		labels[legacyExcludeBalancersLabel] = "true"
+	}
+	for key, value := range config.Labels {
+		labels[key] = value
+	}
+	return labels
+}
 
-	// FIXME
-	n.ObjectMeta.Labels["eks.amazonaws.com/compute-type"] = "fargate"
-	//n.ObjectMeta.Labels["alpha.service-controller.kubernetes.io/exclude-balancer"] = "true"
-	//n.ObjectMeta.Labels["node.kubernetes.io/exclude-from-external-load-balancers"] = "true"
+// hasTaint tells whether taints has one with the key and effect of taint.
+func hasTaint(taints []v1.Taint, taint v1.Taint) bool {
+	for _, t := range taints {
+		if t.MatchTaint(&taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ping checks if the node is still active. The node lease is only renewed,
+// and the node status only updated, while it is.
+func (p *EnclaveProvider) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.node.Alive()
+}
+
+// Ready tells whether the node can run enclaves as of its last health check.
+func (p *EnclaveProvider) Ready(ctx context.Context) error {
+	health := p.node.Health()
+	switch {
+	case health.Checked.IsZero():
+		return errors.New("the health of the node was not checked yet")
+	case !health.Ready():
+		return fmt.Errorf("%s: %s", health.Reason, health.Message)
+	}
+	return nil
+}
+
+// Shutdown drains the enclaves of the node within the shutdown grace period,
+// or leaves them running, according to the shutdown mode of the provider.
+func (p *EnclaveProvider) Shutdown(ctx context.Context) error {
+	p.nodeMu.Lock()
+	mode := p.config.ShutdownMode
+	gracePeriod, _ := time.ParseDuration(p.config.ShutdownGracePeriod)
+	p.nodeMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, gracePeriod)
+	defer cancel()
+	return p.node.Shutdown(ctx, mode == shutdownModeDrain)
+}
+
+// NotifyNodeStatus sets the callback updating the node status, called
+// whenever the pressure on the enclave pools, the health of the node or its
+// drain changes, and when the provider configuration is reloaded.
+func (p *EnclaveProvider) NotifyNodeStatus(ctx context.Context, cb func(*v1.Node)) {
+	update := func() {
+		p.nodeMu.Lock()
+		if p.nodeSpec == nil {
+			p.nodeMu.Unlock()
+			return
+		}
+		n := p.nodeSpec.DeepCopy()
+		config := p.config
+		p.nodeMu.Unlock()
+
+		n.Status.Allocatable = p.allocatable(&config)
+
+		n.Status.Conditions = p.nodeConditions()
+		cb(n)
+	}
+	p.nodeMu.Lock()
+	p.notifyNodeStatus = cb
+	p.nodeMu.Unlock()
+	p.node.NotifyPressure(func(enclavenode.Pressure) { update() })
+	p.node.NotifyHealth(func(enclavenode.Health) { update() })
+	p.node.NotifyDrain(func(enclavenode.Drain) { update() })
 }
 
 // Capacity returns a resource list containing the capacity limits.
-func (p *EnclaveProvider) capacity() v1.ResourceList {
+func (p *EnclaveProvider) capacity(config *EnclaveConfig) v1.ResourceList {
 	rl := v1.ResourceList{
-		"cpu":    resource.MustParse(p.config.CPU),
-		"memory": resource.MustParse(p.config.Memory),
-		"pods":   resource.MustParse(p.config.Pods),
-		"aws.ec2.nitro/nitro_enclaves": resource.MustParse(defaultNitroEnclaveCapacity),
+		"cpu":                 resource.MustParse(config.CPU),
+		"memory":              resource.MustParse(config.Memory),
+		"pods":                resource.MustParse(config.Pods),
+		nitroEnclavesResource: resource.MustParse(config.Enclaves),
 	}
-	for k, v := range p.config.Others {
+	addHugePages(rl)
+	addEnclaveCPUs(rl)
+	for k, v := range config.Others {
 		rl[v1.ResourceName(k)] = resource.MustParse(v)
 	}
 	return rl
 }
 
+// addHugePages adds the hugepage pools reserved for enclaves to a resource
+// list, by page size, and their total as enclave memory, so the scheduler,
+// resource quotas and limit ranges account for enclave memory apart from the
+// memory of the host.
+func addHugePages(rl v1.ResourceList) {
+	pools, err := allocator.ReadHugePagePools(allocator.DefaultHugePagesPath)
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, pool := range pools {
+		if pool.Total == 0 {
+			continue
+		}
+		pageSize := resource.NewQuantity(pool.PageSizeKiB*1024, resource.BinarySI)
+		rl[v1.ResourceName(v1.ResourceHugePagesPrefix+pageSize.String())] = *resource.NewQuantity(pool.TotalMiB()*1024*1024, resource.BinarySI)
+		total += pool.TotalMiB()
+	}
+	rl[enclavenode.ResourceEnclaveMemoryMiB] = *resource.NewQuantity(total, resource.DecimalSI)
+}
+
+// addEnclaveCPUs adds the CPU pool reserved for enclaves to a resource list
+// as enclave vCPUs, so the scheduler and resource quotas account for them.
+func addEnclaveCPUs(rl v1.ResourceList) {
+	capacity, err := allocator.ReadCapacity()
+	if err != nil {
+		return
+	}
+	rl[enclavenode.ResourceEnclaveCPUs] = *resource.NewQuantity(capacity.CPUs, resource.DecimalSI)
+}
+
 // Allocatable returns a resource list containing the allocatable limits.
-func (p *EnclaveProvider) allocatable() v1.ResourceList {
-	rl := p.capacity()
+func (p *EnclaveProvider) allocatable(config *EnclaveConfig) v1.ResourceList {
+	rl := p.capacity(config)
 	// Reserve cpu and memory for non-enclave processes
-	rl.Cpu().Sub(resource.MustParse(p.config.ReservedCPU))
-	rl.Memory().Sub(resource.MustParse(p.config.ReservedMemory))
+	reserve(rl, v1.ResourceCPU, resource.MustParse(config.ReservedCPU))
+	reserve(rl, v1.ResourceMemory, resource.MustParse(config.ReservedMemory))
+
+	// Enclaves which are not pods of the node hold resources pods cannot use.
+	foreign, err := p.node.ForeignReservation()
+	if err != nil {
+		return rl
+	}
+	reserve(rl, v1.ResourceCPU, *resource.NewQuantity(foreign.CPUs, resource.DecimalSI))
+	reserve(rl, v1.ResourceMemory, *resource.NewQuantity(foreign.MemoryMiB*1024*1024, resource.BinarySI))
+	reserve(rl, enclavenode.ResourceEnclaveMemoryMiB, *resource.NewQuantity(foreign.MemoryMiB, resource.DecimalSI))
+	reserve(rl, enclavenode.ResourceEnclaveCPUs, *resource.NewQuantity(foreign.CPUs, resource.DecimalSI))
+	reserve(rl, nitroEnclavesResource, *resource.NewQuantity(int64(foreign.Enclaves), resource.DecimalSI))
 	return rl
 }
 
+// reserve subtracts a reserved quantity from a resource of the list, down to zero.
+func reserve(rl v1.ResourceList, name v1.ResourceName, reserved resource.Quantity) {
+	quantity, ok := rl[name]
+	if !ok {
+		return
+	}
+	quantity.Sub(reserved)
+	if quantity.Sign() < 0 {
+		quantity.Set(0)
+	}
+	rl[name] = quantity
+}
+
 // NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), for updates to the node status
 // within Kubernetes.
 func (p *EnclaveProvider) nodeConditions() []v1.NodeCondition {
+	pressure := p.node.Pressure()
+	memoryPressure := v1.NodeCondition{
+		Type:               "MemoryPressure",
+		Status:             v1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "KubeletHasSufficientMemory",
+		Message:            "kubelet has sufficient memory available",
+	}
+	if pressure.Memory {
+		memoryPressure.Status = v1.ConditionTrue
+		memoryPressure.Reason = "KubeletHasInsufficientMemory"
+		memoryPressure.Message = "enclaves reserve more than the hugepage pool"
+	}
+	cpuPressure := v1.NodeCondition{
+		Type:               enclavenode.NodeEnclaveCPUPressure,
+		Status:             v1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "KubeletHasSufficientEnclaveCPUs",
+		Message:            "kubelet has sufficient enclave CPUs available",
+	}
+	if pressure.CPUs {
+		cpuPressure.Status = v1.ConditionTrue
+		cpuPressure.Reason = "KubeletHasInsufficientEnclaveCPUs"
+		cpuPressure.Message = "enclaves reserve more than the CPU pool"
+	}
+
+	health := p.node.Health()
+	runtime := deviceCondition(enclavenode.NodeEnclaveRuntimeUnavailable, health.Devices.Driver, "NitroDriverLoaded", "NitroDriverMissing", "the nitro_enclaves driver is loaded")
+	allocatorService := deviceCondition(enclavenode.NodeEnclaveAllocatorUnavailable, health.Devices.Allocator, "AllocatorActive", "AllocatorFailed", "the allocator service reserved the enclave pools")
+	pool := deviceCondition(enclavenode.NodeEnclavePoolUnavailable, health.Devices.Pool, "EnclavePoolAvailable", "EnclavePoolUnusable", "the enclave hugepage and CPU pools are usable")
+
 	// TODO: Make this configurable
 	return []v1.NodeCondition{
-		{
-			Type:               "Ready",
-			Status:             v1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletPending",
-			Message:            "kubelet is pending.",
-		},
+		p.readyCondition(health),
 		{
 			Type:               "OutOfDisk",
 			Status:             v1.ConditionFalse,
@@ -399,14 +1036,12 @@ func (p *EnclaveProvider) nodeConditions() []v1.NodeCondition {
 			Reason:             "KubeletHasSufficientDisk",
 			Message:            "kubelet has sufficient disk space available",
 		},
-		{
-			Type:               "MemoryPressure",
-			Status:             v1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasSufficientMemory",
-			Message:            "kubelet has sufficient memory available",
-		},
+		memoryPressure,
+		cpuPressure,
+		runtime,
+		allocatorService,
+		pool,
+		drainCondition(p.node.Drain()),
 		{
 			Type:               "DiskPressure",
 			Status:             v1.ConditionFalse,
@@ -427,15 +1062,102 @@ func (p *EnclaveProvider) nodeConditions() []v1.NodeCondition {
 
 }
 
+// readyCondition returns the Ready condition of the node, true while the node
+// can run enclaves as of its last health check.
+func (p *EnclaveProvider) readyCondition(health enclavenode.Health) v1.NodeCondition {
+	ready := v1.NodeCondition{
+		Type:               v1.NodeReady,
+		Status:             v1.ConditionTrue,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.NewTime(health.Since),
+		Reason:             "KubeletReady",
+		Message:            "kubelet is posting ready status",
+	}
+	switch {
+	case health.Checked.IsZero():
+		ready.Status = v1.ConditionFalse
+		ready.LastTransitionTime = metav1.NewTime(p.startTime)
+		ready.Reason = "KubeletPending"
+		ready.Message = "kubelet is pending."
+	case !health.Ready():
+		ready.Status = v1.ConditionFalse
+		ready.Reason = health.Reason
+		ready.Message = health.Message
+	}
+	return ready
+}
+
+// deviceCondition returns a node condition telling whether a part of the
+// enclave runtime of the host is unavailable, given its problem if it is.
+func deviceCondition(conditionType v1.NodeConditionType, problem, okReason, problemReason, okMessage string) v1.NodeCondition {
+	condition := v1.NodeCondition{
+		Type:               conditionType,
+		Status:             v1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             okReason,
+		Message:            okMessage,
+	}
+	if problem != "" {
+		condition.Status = v1.ConditionTrue
+		condition.Reason = problemReason
+		condition.Message = problem
+	}
+	return condition
+}
+
+// drainCondition returns the node condition telling whether the node is
+// cordoned or drained, so it admits no new pods.
+func drainCondition(drain enclavenode.Drain) v1.NodeCondition {
+	condition := v1.NodeCondition{
+		Type:               enclavenode.NodeEnclaveDraining,
+		Status:             v1.ConditionFalse,
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             "NotDraining",
+		Message:            "the node admits new pods",
+	}
+	switch {
+	case drain.Requested:
+		condition.Status = v1.ConditionTrue
+		condition.Reason = "DrainRequested"
+		condition.Message = "the node is drained, it admits no new pods and evicts its pods"
+	case drain.Cordoned:
+		condition.Status = v1.ConditionTrue
+		condition.Reason = "Cordoned"
+		condition.Message = "the node is cordoned, it admits no new pods"
+	}
+	return condition
+}
+
 // NodeAddresses returns a list of addresses for the node status
 // within Kubernetes.
-func (p *EnclaveProvider) nodeAddresses() []v1.NodeAddress {
-	return []v1.NodeAddress{
+func (p *EnclaveProvider) nodeAddresses(config *EnclaveConfig) []v1.NodeAddress {
+	addresses := []v1.NodeAddress{
 		{
 			Type:    "InternalIP",
 			Address: p.internalIP,
 		},
 	}
+	if config.InternalIPv6 != "" {
+		addresses = append(addresses, v1.NodeAddress{
+			Type:    "InternalIP",
+			Address: config.InternalIPv6,
+		})
+	}
+	if config.InternalDNS != "" {
+		addresses = append(addresses, v1.NodeAddress{
+			Type:    "InternalDNS",
+			Address: config.InternalDNS,
+		})
+	}
+	if config.Hostname != "" {
+		addresses = append(addresses, v1.NodeAddress{
+			Type:    "Hostname",
+			Address: config.Hostname,
+		})
+	}
+	return addresses
 }
 
 // NodeDaemonEndpoints returns NodeDaemonEndpoints for the node status
@@ -449,16 +1171,29 @@ func (p *EnclaveProvider) nodeDaemonEndpoints() v1.NodeDaemonEndpoints {
 }
 
 // NotifyPods is called to set a pod notifier callback function. This should be called before any operations are done
-// within the provider.
+// within the provider. Pod status transitions are pushed to the notifier as they happen.
 func (p *EnclaveProvider) NotifyPods(ctx context.Context, notifier func(*v1.Pod)) {
-	p.notifier = notifier
+	p.node.NotifyPods(notifier)
 }
 
+// GetMetricsResource returns the resource metrics of the node and its pods.
 func (p *EnclaveProvider) GetMetricsResource(ctx context.Context) ([]*dto.MetricFamily, error) {
-	return nil, errNotImplemented
+	ctx, span := trace.StartSpan(ctx, "GetMetricsResource")
+	defer span.End()
+
+	log.G(ctx).Info("receive GetMetricsResource")
+
+	return p.node.GetMetricsResource(ctx)
 }
+
+// GetStatsSummary returns the resource usage of the node and its pods.
 func (p *EnclaveProvider) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
-	return nil, errNotImplemented
+	ctx, span := trace.StartSpan(ctx, "GetStatsSummary")
+	defer span.End()
+
+	log.G(ctx).Info("receive GetStatsSummary")
+
+	return p.node.GetStatsSummary(ctx)
 }
 
 // addAttributes adds the specified attributes to the provided span.
@@ -0,0 +1,106 @@
+package enclave
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+)
+
+// imdsTimeout bounds the queries to the instance metadata service, which
+// hosts outside EC2 never answer.
+const imdsTimeout = 5 * time.Second
+
+var (
+	// identity caches the identity document of the instance, which does not change.
+	identity     *imds.InstanceIdentityDocument
+	identityErr  error
+	identityOnce sync.Once
+)
+
+// instanceIdentity returns the identity document of the EC2 instance
+// hosting the node, queried once.
+func instanceIdentity(ctx context.Context) (*imds.InstanceIdentityDocument, error) {
+	identityOnce.Do(func() {
+		identity, identityErr = queryInstanceIdentity(ctx)
+	})
+	return identity, identityErr
+}
+
+// queryInstanceIdentity returns the identity document of the EC2 instance
+// hosting the node from the instance metadata service, using IMDSv2.
+var queryInstanceIdentity = func(ctx context.Context) (*imds.InstanceIdentityDocument, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	out, err := imds.New(imds.Options{}).GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the instance identity document: %v", err)
+	}
+	return &out.InstanceIdentityDocument, nil
+}
+
+// instanceMetadata returns a category of the instance metadata of the EC2
+// instance hosting the node, such as local-hostname, using IMDSv2.
+var instanceMetadata = func(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	out, err := imds.New(imds.Options{}).GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the %s instance metadata: %v", path, err)
+	}
+	defer out.Content.Close()
+	data, err := io.ReadAll(out.Content)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the %s instance metadata: %v", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// detectProviderID returns the provider ID of the EC2 instance hosting the
+// node, in the aws:///<availability zone>/<instance id> format of the AWS
+// cloud provider, so the node is matched with its instance.
+func detectProviderID(ctx context.Context) (string, error) {
+	identity, err := instanceIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	if identity.AvailabilityZone == "" || identity.InstanceID == "" {
+		return "", fmt.Errorf("the instance identity document has no availability zone or instance ID")
+	}
+	return fmt.Sprintf("aws:///%s/%s", identity.AvailabilityZone, identity.InstanceID), nil
+}
+
+// detectInternalIP returns the primary private address of the EC2 instance
+// hosting the node, that of its primary network interface. Hosts outside
+// EC2 fall back to the source address of their default route.
+func detectInternalIP(ctx context.Context) (string, error) {
+	identity, err := instanceIdentity(ctx)
+	if err == nil {
+		if identity.PrivateIP != "" {
+			return identity.PrivateIP, nil
+		}
+		err = fmt.Errorf("the instance identity document has no private IP")
+	}
+
+	// Dialing UDP sends nothing, it only picks the route to the address.
+	conn, dialErr := net.Dial("udp", "169.254.169.254:80")
+	if dialErr != nil {
+		return "", fmt.Errorf("%v, and no default route: %v", err, dialErr)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// detectInternalDNS returns the private DNS name of the EC2 instance hosting the node.
+func detectInternalDNS(ctx context.Context) (string, error) {
+	// Hosts outside EC2 would wait for the metadata service once more.
+	if _, err := instanceIdentity(ctx); err != nil {
+		return "", err
+	}
+	return instanceMetadata(ctx, "local-hostname")
+}
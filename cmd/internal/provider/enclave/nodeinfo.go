@@ -0,0 +1,103 @@
+package enclave
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"golang.org/x/sys/unix"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Host files describing the host, as read by the kubelet. Those of /proc and
+// /sys are the host's in containers too, the others are in the host root.
+const (
+	osReleasePath       = "/etc/os-release"
+	machineIDPath       = "/etc/machine-id"
+	dbusMachineIDPath   = "/var/lib/dbus/machine-id"
+	bootIDPath          = "/proc/sys/kernel/random/boot_id"
+	productUUIDPath     = "/sys/class/dmi/id/product_uuid"
+	defaultHostRootPath = "/"
+)
+
+// systemInfo describes the host of the node, whose root filesystem is at
+// hostRoot, and nitro-cli as its container runtime. The kubelet version is
+// set by the root command, with the build version of the provider.
+func systemInfo(ctx context.Context, operatingSystem, hostRoot string) v1.NodeSystemInfo {
+	if operatingSystem == "" {
+		operatingSystem = runtime.GOOS
+	}
+	if hostRoot == "" {
+		hostRoot = defaultHostRootPath
+	}
+	host := func(path string) string { return filepath.Join(hostRoot, path) }
+	info := v1.NodeSystemInfo{
+		OperatingSystem: operatingSystem,
+		Architecture:    runtime.GOARCH,
+		KernelVersion:   kernelVersion(),
+		OSImage:         osImage(host(osReleasePath)),
+		MachineID:       readID(host(machineIDPath), host(dbusMachineIDPath)),
+		BootID:          readID(bootIDPath),
+		SystemUUID:      readID(productUUIDPath),
+	}
+	if info.MachineID == "" {
+		log.G(ctx).Warnf("Failed to read the machine ID of the host in %s", hostRoot)
+	}
+
+	version, err := cli.Version()
+	if err != nil {
+		log.G(ctx).Warnf("Failed to detect nitro-cli version: %v", err)
+		info.ContainerRuntimeVersion = "nitro-cli://unknown"
+	} else {
+		info.ContainerRuntimeVersion = fmt.Sprintf("nitro-cli://%d.%d.%d", version[0], version[1], version[2])
+	}
+	return info
+}
+
+// kernelVersion returns the release of the running kernel, like uname -r.
+func kernelVersion() string {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Release[:])
+}
+
+// osImage returns the pretty name of the distribution of the host from its
+// os-release file, or "Unknown" like the kubelet.
+func osImage(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return "Unknown"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return "Unknown"
+}
+
+// readID returns the identifier held by the first host file of paths which
+// can be read, empty when none can.
+func readID(paths ...string) string {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id
+		}
+	}
+	return ""
+}
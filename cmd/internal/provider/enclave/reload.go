@@ -0,0 +1,133 @@
+package enclave
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	enclavenode "github.com/brave-experiments/nitro-enclave-kubelet/pkg/node"
+	"github.com/fsnotify/fsnotify"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// watchConfig reloads the provider configuration whenever its file changes
+// or the kubelet receives SIGHUP, until the context is done. The directory of
+// the file is watched, as ConfigMap volumes replace files by renaming them.
+func (p *EnclaveProvider) watchConfig(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var events chan fsnotify.Event
+	var errors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(filepath.Dir(p.configPath))
+		events, errors = watcher.Events, watcher.Errors
+	}
+	if err != nil {
+		log.G(ctx).Warnf("Failed to watch the provider configuration, reloading it on SIGHUP only: %v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.G(ctx).Info("Received SIGHUP, reloading the provider configuration")
+		case <-events:
+		case err := <-errors:
+			// The watcher blocks until its errors are read.
+			log.G(ctx).Warnf("Failed to watch the provider configuration: %v", err)
+			continue
+		}
+		if err := p.reloadConfig(ctx); err != nil {
+			log.G(ctx).Errorf("Failed to reload the provider configuration: %v.\n", err)
+		}
+	}
+}
+
+// reloadConfig applies the provider configuration when its file changed:
+// the capacity, labels and annotations of the node, the allocator pools and
+// the admission policies, profiles, runtime class, namespaces and images included.
+// The node is reconfigured and its status updated. Other settings, such as
+// directories and taints, apply on restart.
+func (p *EnclaveProvider) reloadConfig(ctx context.Context) error {
+	data, err := os.ReadFile(p.configPath)
+	if err != nil {
+		return err
+	}
+	p.nodeMu.Lock()
+	unchanged := bytes.Equal(data, p.configData)
+	p.nodeMu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	config, err := loadConfig(p.configPath, p.nodeName)
+	if err != nil {
+		return err
+	}
+	// The settings detected at startup are kept, rather than queried again.
+	detected := p.detected
+	enclaves, err := completeConfig(ctx, &config, &detected)
+	if err != nil {
+		return err
+	}
+	applyAllocatorConfig(ctx, &config)
+	detectCapacity(ctx, &config)
+	p.node.Reconfigure(&enclavenode.NodeConfig{
+		MaxEnclaves:    enclaves,
+		AllowDebugMode: config.AllowDebugMode,
+		Profiles:       config.Profiles,
+		RuntimeClass:   config.RuntimeClass,
+
+		AllowedNamespaces:       config.AllowedNamespaces,
+		DeniedNamespaces:        config.DeniedNamespaces,
+		RequireEnclaveResources: config.RequireEnclaveResources,
+
+		AllowedImages: config.AllowedImages,
+		DeniedImages:  config.DeniedImages,
+
+		DebugModeAllowedNamespaces: config.DebugModeAllowedNamespaces,
+		DebugModeDeniedNamespaces:  config.DebugModeDeniedNamespaces,
+	})
+
+	p.nodeMu.Lock()
+	previous := p.config
+	p.config = config
+	p.configData = data
+	var n *v1.Node
+	if p.nodeSpec != nil {
+		n = p.nodeSpec.DeepCopy()
+	}
+	notify := p.notifyNodeStatus
+	p.nodeMu.Unlock()
+	log.G(ctx).Info("Reloaded the provider configuration")
+
+	if n == nil {
+		return nil
+	}
+	removeNodeMetadata(n, &previous)
+	p.ConfigureNode(ctx, n)
+	if notify != nil {
+		notify(n.DeepCopy())
+	}
+	return nil
+}
+
+// removeNodeMetadata removes the labels and annotations of a configuration
+// from the node, so those dropped from the configuration do not linger.
+func removeNodeMetadata(n *v1.Node, config *EnclaveConfig) {
+	for key := range nodeLabels(config) {
+		delete(n.ObjectMeta.Labels, key)
+	}
+	for key := range config.Annotations {
+		delete(n.ObjectMeta.Annotations, key)
+	}
+}
@@ -3,6 +3,7 @@ package provider
 import (
 	"context"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
 	"github.com/virtual-kubelet/virtual-kubelet/node/nodeutil"
 	v1 "k8s.io/api/core/v1"
 )
@@ -14,3 +15,27 @@ type Provider interface {
 	// will be used for Kubernetes.
 	ConfigureNode(context.Context, *v1.Node)
 }
+
+// Shutdowner is implemented by providers handling the shutdown of the node,
+// once its controllers stopped.
+type Shutdowner interface {
+	Shutdown(context.Context) error
+}
+
+// Readier is implemented by providers telling whether the node can run pods,
+// for the readiness endpoint of the process.
+type Readier interface {
+	Ready(context.Context) error
+}
+
+// Attester is implemented by providers serving fresh attestation documents
+// of the enclaves of pods, including the nonce and user data of the caller.
+type Attester interface {
+	Attest(ctx context.Context, namespace, name string, nonce, userData []byte) ([]byte, error)
+}
+
+// Auditor is implemented by providers recording the kubelet API requests on
+// the enclaves of pods, such as exec, in an audit log.
+type Auditor interface {
+	AuditRequest(ctx context.Context, record audit.Record)
+}
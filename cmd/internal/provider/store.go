@@ -5,6 +5,8 @@ import (
 
 	"github.com/brave-experiments/nitro-enclave-kubelet/internal/manager"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 // Store is used for registering/fetching providers
@@ -69,6 +71,8 @@ type InitConfig struct {
 	DaemonPort        int32
 	KubeClusterDomain string
 	ResourceManager   *manager.ResourceManager
+	KubeClient        kubernetes.Interface
+	EventRecorder     record.EventRecorder
 }
 
 type InitFunc func(InitConfig) (Provider, error) //nolint:golint
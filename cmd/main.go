@@ -16,21 +16,24 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime/debug"
 	"strings"
 	"syscall"
 
 	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/commands/providers"
 	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/commands/root"
+	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/commands/verify"
 	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/commands/version"
 	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider"
+	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider/enclave"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
-	logruslogger "github.com/virtual-kubelet/virtual-kubelet/log/logrus"
 	"github.com/virtual-kubelet/virtual-kubelet/trace"
 	"github.com/virtual-kubelet/virtual-kubelet/trace/opencensus"
 )
@@ -38,7 +41,7 @@ import (
 var (
 	buildVersion = "N/A"
 	buildTime    = "N/A"
-	k8sVersion   = "v1.15.2" // This should follow the version of k8s.io/kubernetes we are importing
+	k8sVersion   = "v1.27.2" // This should follow the version of k8s.io/kubernetes we are importing
 )
 
 func main() {
@@ -50,21 +53,27 @@ func main() {
 		cancel()
 	}()
 
-	log.L = logruslogger.FromLogrus(logrus.NewEntry(logrus.StandardLogger()))
+	logConfig := logging.DefaultConfig()
+	if err := logging.Setup(logConfig); err != nil {
+		log.G(ctx).Fatal(err)
+	}
 	trace.T = opencensus.Adapter{}
 
 	var opts root.Opts
 	optsErr := root.SetDefaultOpts(&opts)
+	if buildVersion == "N/A" {
+		buildVersion = moduleVersion()
+	}
+	// The kubelet version reported by the node, such as v1.27.2-vk-v0.3.0.
 	opts.Version = strings.Join([]string{k8sVersion, "vk", buildVersion}, "-")
 
 	s := provider.NewStore()
 	registerEnclave(ctx, s)
 
 	rootCmd := root.NewCommand(ctx, filepath.Base(os.Args[0]), s, opts)
-	rootCmd.AddCommand(version.NewCommand(buildVersion, buildTime), providers.NewCommand(s))
+	rootCmd.AddCommand(version.NewCommand(buildVersion, buildTime), providers.NewCommand(s), verify.NewCommand())
 	preRun := rootCmd.PreRunE
 
-	var logLevel string
 	rootCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		if optsErr != nil {
 			return optsErr
@@ -75,20 +84,52 @@ func main() {
 		return nil
 	}
 
-	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", `set the log level, e.g. "debug", "info", "warn", "error"`)
+	rootCmd.PersistentFlags().StringVar(&logConfig.Level, "log-level", logConfig.Level, `set the log level, e.g. "debug", "info", "warn", "error"`)
+	rootCmd.PersistentFlags().StringVar(&logConfig.Format, "log-format", logConfig.Format, `set the log format, "console" or "json"`)
 
 	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
-		if logLevel != "" {
-			lvl, err := logrus.ParseLevel(logLevel)
-			if err != nil {
-				return errors.Wrap(err, "could not parse log level")
-			}
-			logrus.SetLevel(lvl)
-		}
-		return nil
+		return logging.Setup(logConfig)
 	}
 
 	if err := rootCmd.Execute(); err != nil && errors.Cause(err) != context.Canceled {
+		// Every problem of the provider configuration is reported on its own line.
+		var configErr *enclave.ConfigError
+		if errors.As(err, &configErr) {
+			fmt.Fprintln(os.Stderr, configErr.Report())
+			os.Exit(1)
+		}
 		log.G(ctx).Fatal(err)
 	}
 }
+
+// moduleVersion returns the version of the provider when it is not set at
+// link time: that of its module when installed with go install, otherwise
+// its VCS revision, suffixed with ".dirty" when modified, or "N/A".
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "N/A"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision == "" {
+		return "N/A"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += ".dirty"
+	}
+	return revision
+}
@@ -2,21 +2,44 @@ package main
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 
 	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider"
 	"github.com/brave-experiments/nitro-enclave-kubelet/cmd/internal/provider/enclave"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/sim"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 func registerEnclave(ctx context.Context, s *provider.Store) {
 	/* #nosec */
 	s.Register("enclave", func(cfg provider.InitConfig) (provider.Provider, error) { //nolint:errcheck
-		return enclave.NewEnclaveProvider(
-			ctx,
-			cfg.ConfigPath,
-			cfg.NodeName,
-			cfg.OperatingSystem,
-			cfg.InternalIP,
-			cfg.DaemonPort,
-		)
+		return newEnclaveProvider(ctx, cfg)
 	})
+
+	// The simulator runs the enclaves as processes of the host, for
+	// development and CI without Nitro hardware.
+	/* #nosec */
+	s.Register("enclave-sim", func(cfg provider.InitConfig) (provider.Provider, error) { //nolint:errcheck
+		dir := filepath.Join(os.TempDir(), "nitro-enclave-sim", cfg.NodeName)
+		if _, err := sim.Enable(dir); err != nil {
+			return nil, err
+		}
+		log.G(ctx).Warnf("Simulating enclaves as processes of the host in %s, workloads are neither isolated nor attested", dir)
+		return newEnclaveProvider(ctx, cfg)
+	})
+}
+
+func newEnclaveProvider(ctx context.Context, cfg provider.InitConfig) (provider.Provider, error) {
+	return enclave.NewEnclaveProvider(
+		ctx,
+		cfg.ConfigPath,
+		cfg.NodeName,
+		cfg.OperatingSystem,
+		cfg.InternalIP,
+		cfg.DaemonPort,
+		cfg.ResourceManager,
+		cfg.KubeClient,
+		cfg.EventRecorder,
+	)
 }
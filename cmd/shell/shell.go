@@ -1,20 +1,19 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"os/exec"
+	"strconv"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
 	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
-	appctx "github.com/brave-intl/bat-go/libs/context"
-
-	"github.com/mdlayher/vsock"
-	"github.com/rs/zerolog"
+	"github.com/sirupsen/logrus"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 type RemoteWriter struct {
@@ -31,34 +30,26 @@ func (w *RemoteWriter) Write(p []byte) (n int, err error) {
 }
 
 func Listen(p uint) {
-	ctx := context.Background()
-	cid, err := vsock.ContextID()
+	cid, err := transport.ContextID()
 	if err == nil {
-		writer := RemoteWriter{
+		logrus.SetOutput(&RemoteWriter{
 			RemoteWriter: nitro.NewVsockWriter(fmt.Sprintf("vm(4):%d", 10000+cid)),
-			LocalWriter:  zerolog.ConsoleWriter{Out: os.Stderr},
-		}
-		ctx = zerolog.New(&writer).WithContext(ctx)
-	}
-
-	logger, err := appctx.GetLogger(ctx)
-	if err != nil {
-		fmt.Println(err)
-		return
+			LocalWriter:  os.Stderr,
+		})
 	}
 
-	l, err := vsock.Listen(uint32(p), &vsock.Config{})
+	l, err := transport.Listen(uint32(p))
 	if nil != err {
-		log.Fatalf("Could not bind to interface: %v", err)
+		log.L.Fatalf("Could not bind to interface: %v", err)
 	}
 	defer l.Close()
-	logger.Info().Str("addr", l.Addr().String()).Str("network", l.Addr().Network()).Msg("Listening on")
+	log.L.WithFields(log.Fields{"addr": l.Addr().String(), "network": l.Addr().Network()}).Info("Listening on")
 	for {
 		c, err := l.Accept()
 		if nil != err {
-			log.Fatalf("Could not accept connection: %v", err)
+			log.L.Fatalf("Could not accept connection: %v", err)
 		}
-		logger.Info().Str("addr", c.RemoteAddr().String()).Msg("Accepted connection")
+		log.L.WithField("addr", c.RemoteAddr().String()).Info("Accepted connection")
 
 		cmd := exec.Command("/bin/bash", "-i")
 		cmd.Stdin = c
@@ -69,13 +60,13 @@ func Listen(p uint) {
 }
 
 func Connect(i *string, p uint) {
-	sock := fmt.Sprintf("%s:%d", *i, p)
+	sock := net.JoinHostPort(*i, strconv.FormatUint(uint64(p), 10))
 	c, err := net.Dial("tcp", sock)
 	if nil != err {
-		log.Fatalf("Could not open TCP connection: %v", err)
+		log.L.Fatalf("Could not open TCP connection: %v", err)
 	}
 	defer c.Close()
-	log.Println("TCP connection established")
+	log.L.Info("TCP connection established")
 
 	go io.Copy(c, os.Stdin)
 	go io.Copy(os.Stdout, c)
@@ -87,7 +78,12 @@ func main() {
 	p := flag.Uint("p", 4444, "Port")
 	l := flag.Bool("l", false, "Listen")
 	c := flag.String("c", "", "Connect IP")
+	logConfig := logging.DefaultConfig()
+	logConfig.AddFlags(flag.CommandLine)
 	flag.Parse()
+	if err := logging.Setup(logConfig); err != nil {
+		log.L.Fatal(err)
+	}
 	if *l {
 		Listen(*p)
 	} else {
@@ -0,0 +1,71 @@
+// The webhook is a validating admission webhook rejecting the pods targeting
+// enclave nodes which the provider cannot run, when they are applied, and a
+// mutating one setting the enclave resources of their containers.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/webhook"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// shutdownTimeout is how long pending reviews are given to complete on exit.
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	addr := flag.String("listen-address", ":8443", "listen on this address for the admission reviews of the API server")
+	certFile := flag.String("tls-cert-file", "", "serve TLS with this certificate, trusted by the webhook configuration")
+	keyFile := flag.String("tls-key-file", "", "serve TLS with this private key")
+	taintKey := flag.String("taint-key", webhook.DefaultTaintKey, "key of the taint of enclave nodes, pods tolerating it target them")
+	runtimeClass := flag.String("runtime-class", "", "runtime class the pods running in enclaves must request, none if empty")
+	maxMemory := flag.String("max-enclave-memory", "", "most memory an enclave can have, such as 8Gi, unlimited if empty")
+	logConfig := logging.DefaultConfig()
+	logConfig.AddFlags(flag.CommandLine)
+	flag.Parse()
+	if err := logging.Setup(logConfig); err != nil {
+		log.L.Fatal(err)
+	}
+
+	if *certFile == "" || *keyFile == "" {
+		log.L.Fatal("the API server only calls webhooks over TLS, -tls-cert-file and -tls-key-file are required")
+	}
+	config := webhook.Config{TaintKey: *taintKey, RuntimeClassName: *runtimeClass}
+	if *maxMemory != "" {
+		quantity, err := resource.ParseQuantity(*maxMemory)
+		if err != nil {
+			log.L.Fatalf("invalid -max-enclave-memory %q: %v", *maxMemory, err)
+		}
+		config.MaxMemoryMiB = quantity.Value() / (1024 * 1024)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/validate", webhook.New(config))
+	mux.Handle("/mutate", webhook.NewMutator(config))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx) //nolint:errcheck
+	}()
+
+	log.G(ctx).Infof("Serving admission reviews on %s", *addr)
+	if err := server.ListenAndServeTLS(*certFile, *keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.G(ctx).Fatal(err)
+	}
+}
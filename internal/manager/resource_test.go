@@ -17,8 +17,8 @@ package manager_test
 import (
 	"testing"
 
-	"github.com/virtual-kubelet/virtual-kubelet/internal/manager"
-	testutil "github.com/virtual-kubelet/virtual-kubelet/internal/test/util"
+	"github.com/brave-experiments/nitro-enclave-kubelet/internal/manager"
+	testutil "github.com/brave-experiments/nitro-enclave-kubelet/internal/test/util"
 	"gotest.tools/assert"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
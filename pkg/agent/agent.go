@@ -0,0 +1,131 @@
+// Package agent implements the protocol spoken between the provider and the
+// agent which runs as the entrypoint of every enclave. The agent starts the
+// workload, and reports back to the provider over vsock.
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+const (
+	// ParentCID is the vsock context ID of the parent instance as seen from an enclave.
+	ParentCID = 3
+	// LogPortBase is added to an enclave's CID to get the host vsock port on
+	// which the provider receives the output of that enclave's workload.
+	LogPortBase = 10000
+	// EventPortBase is added to an enclave's CID to get the host vsock port on
+	// which the provider receives events from that enclave's agent.
+	EventPortBase = 11000
+	// Path is where the agent is installed in the enclave's root filesystem.
+	Path = "/nitro-agent"
+	// SimulatedRootEnv is the environment variable the simulator sets to the
+	// root directory of the simulated enclave an agent runs in, as a process
+	// of the host.
+	SimulatedRootEnv = "NITRO_ENCLAVE_SIM_ROOT"
+)
+
+// LogPort returns the host vsock port receiving logs for the enclave with the given CID.
+func LogPort(cid uint32) uint32 {
+	return LogPortBase + cid
+}
+
+// EventPort returns the host vsock port receiving events for the enclave with the given CID.
+func EventPort(cid uint32) uint32 {
+	return EventPortBase + cid
+}
+
+// EventType identifies the kind of an Event.
+type EventType string
+
+const (
+	// EventStarted is sent once the enclave is initialized, its entropy
+	// seeded, and the workload started.
+	EventStarted EventType = "started"
+	// EventExit is sent once the workload has exited.
+	EventExit EventType = "exit"
+	// EventCondition sets a condition of the pod, such as one of its readiness
+	// gates. The workload sends it to signal its own initialization.
+	EventCondition EventType = "condition"
+	// eventAck is sent by the provider to acknowledge an event.
+	eventAck EventType = "ack"
+)
+
+// Event is a notification sent by the agent to the provider.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	// ExitCode and Signal describe how the workload terminated, for exit events.
+	ExitCode int    `json:"exitCode,omitempty"`
+	Signal   string `json:"signal,omitempty"`
+
+	// Condition, Status and Message describe the pod condition set by condition events.
+	Condition string `json:"condition,omitempty"`
+	Status    bool   `json:"status,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// SendEvent writes an event to conn and waits for the provider to acknowledge it.
+func SendEvent(conn net.Conn, e Event, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(e); err != nil {
+		return err
+	}
+
+	var ack Event
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&ack); err != nil {
+		return fmt.Errorf("failed to read acknowledgement: %v", err)
+	}
+	if ack.Type != eventAck {
+		return fmt.Errorf("unexpected acknowledgement %q", ack.Type)
+	}
+	return nil
+}
+
+// EventServer receives events from an enclave's agent.
+type EventServer struct {
+	handler func(Event)
+}
+
+// NewEventServer creates a new EventServer passing every event received to handler.
+func NewEventServer(handler func(Event)) *EventServer {
+	return &EventServer{handler: handler}
+}
+
+// Serve accepts connections from the agent until the listener is closed.
+func (s *EventServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *EventServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		var e Event
+		if err := decoder.Decode(&e); err != nil {
+			return
+		}
+		s.handler(e)
+
+		if err := encoder.Encode(Event{Type: eventAck, Time: time.Now()}); err != nil {
+			log.L.Warnf("Failed to acknowledge agent event: %s", err)
+			return
+		}
+	}
+}
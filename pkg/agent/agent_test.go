@@ -0,0 +1,519 @@
+package agent
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cms/cmstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendEvent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	events := make(chan Event, 1)
+	s := NewEventServer(func(e Event) {
+		events <- e
+	})
+	go s.Serve(l) //nolint:errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	sent := Event{Type: EventExit, ExitCode: 3, Time: time.Now().UTC()}
+	assert.Nil(t, SendEvent(conn, sent, 5*time.Second))
+
+	select {
+	case e := <-events:
+		assert.Equal(t, sent.Type, e.Type)
+		assert.Equal(t, sent.ExitCode, e.ExitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not received")
+	}
+}
+
+func TestPushFiles(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	s := NewFileServer()
+	go s.Serve(l) //nolint:errcheck
+
+	dir := t.TempDir()
+	push := func(files ...File) error {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		return PushFiles(conn, files, 5*time.Second)
+	}
+
+	token := filepath.Join(dir, "serviceaccount", "token")
+	assert.Nil(t, push(File{Path: token, Data: []byte("first"), Mode: 0600}))
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatal("file server is not ready")
+	}
+
+	// Refreshed files replace the previous ones.
+	assert.Nil(t, push(File{Path: token, Data: []byte("second")}))
+	data, err := os.ReadFile(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(data))
+
+	assert.NotNil(t, push(File{Path: "relative/token", Data: []byte("x")}))
+}
+
+func TestRequestAttestation(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	go AttestServer{Attest: func(nonce, userData []byte) ([]byte, error) {
+		if len(nonce) == 0 {
+			return nil, fmt.Errorf("missing nonce")
+		}
+		return append(append([]byte("document:"), nonce...), userData...), nil
+	}}.Serve(l) //nolint:errcheck
+
+	request := func(nonce, userData []byte) ([]byte, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		return RequestAttestation(conn, nonce, userData, 5*time.Second)
+	}
+
+	doc, err := request([]byte("nonce"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "document:nonce", string(doc))
+
+	doc, err = request([]byte("nonce"), []byte(":data"))
+	assert.Nil(t, err)
+	assert.Equal(t, "document:nonce:data", string(doc))
+
+	_, err = request(nil, nil)
+	assert.EqualError(t, err, "missing nonce")
+}
+
+func TestReleaseSecrets(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	// The document of the fake module is the attested public key.
+	s := NewSecretServer()
+	s.Attest = func(nonce, publicKey []byte) ([]byte, error) {
+		assert.Equal(t, "nonce", string(nonce))
+		return publicKey, nil
+	}
+	go s.Serve(l) //nolint:errcheck
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db", "password")
+	release := func(seal func(document []byte) ([]SealedFile, error)) error {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		return ReleaseSecrets(conn, []byte("nonce"), seal, 5*time.Second)
+	}
+
+	// Nothing is released when the attestation is not trusted.
+	assert.EqualError(t, release(func([]byte) ([]SealedFile, error) {
+		return nil, fmt.Errorf("untrusted")
+	}), "untrusted")
+	assert.NotNil(t, release(func([]byte) ([]SealedFile, error) {
+		return []SealedFile{{Path: path, Ciphertext: []byte("plaintext")}}, nil
+	}))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, release(func(document []byte) ([]SealedFile, error) {
+		key, err := x509.ParsePKIXPublicKey(document)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := cmstest.Encrypt([]byte("s3cr3t"), key.(*rsa.PublicKey))
+		return []SealedFile{{Path: path, Ciphertext: ciphertext, Mode: 0400}}, err
+	}))
+	select {
+	case <-s.Ready():
+	default:
+		t.Fatal("secret server is not ready")
+	}
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cr3t", string(data))
+}
+
+func TestVaultLogin(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, r.URL.Path)
+		switch {
+		case r.URL.Path == "/v1/auth/k8s/login" && body["role"] == "web" && body["jwt"] == "jwt":
+			fmt.Fprint(w, `{"auth": {"client_token": "first", "lease_duration": 3600, "renewable": true}}`)
+		case r.URL.Path == "/v1/auth/attestation/login" && body["attestation_document"] == "ZG9j":
+			fmt.Fprint(w, `{"auth": {"client_token": "attested", "lease_duration": 60}}`)
+		case r.URL.Path == "/v1/auth/token/renew-self" && r.Header.Get("X-Vault-Token") == "first":
+			fmt.Fprint(w, `{"auth": {"client_token": "first", "lease_duration": 1800, "renewable": true}}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	jwt := filepath.Join(dir, "token")
+	assert.Nil(t, os.WriteFile(jwt, []byte("jwt\n"), 0600))
+	login := &VaultLogin{
+		Address:   server.URL,
+		Method:    VaultAuthKubernetes,
+		Path:      "/k8s/",
+		Role:      "web",
+		JWTFile:   jwt,
+		TokenFile: filepath.Join(dir, "vault", "token"),
+	}
+	token, err := login.Login(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, &VaultToken{Token: "first", LeaseDuration: time.Hour, Renewable: true}, token)
+	data, err := os.ReadFile(login.TokenFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(data))
+
+	token, err = login.Renew(context.Background(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Minute, token.LeaseDuration)
+
+	login.Method, login.Path = VaultAuthAttestation, ""
+	login.Attest = func() ([]byte, error) { return []byte("doc"), nil }
+	token, err = login.Login(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "attested", token.Token)
+
+	login.Role = "db"
+	login.Attest = func() ([]byte, error) { return []byte("other"), nil }
+	_, err = login.Login(context.Background())
+	assert.EqualError(t, err, "failed to log into Vault: 403 Forbidden: permission denied")
+	assert.Equal(t, []string{"/v1/auth/k8s/login", "/v1/auth/token/renew-self", "/v1/auth/attestation/login", "/v1/auth/attestation/login"}, requests)
+}
+
+func TestParseTmpfsMount(t *testing.T) {
+	m := TmpfsMount{Path: "/var/cache", SizeMiB: 64}
+	parsed, err := ParseTmpfsMount(m.String())
+	assert.Nil(t, err)
+	assert.Equal(t, m, parsed)
+
+	for _, invalid := range []string{"/tmp", "/tmp:0", "tmp:64", "/tmp:64Mi"} {
+		_, err := ParseTmpfsMount(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, WriteUDPRelayHeader(&buf, 53))
+	assert.Nil(t, WriteDatagram(&buf, []byte("hello")))
+	assert.Nil(t, WriteDatagram(&buf, nil))
+	assert.NotNil(t, WriteDatagram(&buf, make([]byte, MaxDatagramSize+1)))
+
+	port, err := ReadUDPRelayHeader(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(53), port)
+
+	p := make([]byte, MaxDatagramSize)
+	n, err := ReadDatagram(&buf, p)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(p[:n]))
+	n, err = ReadDatagram(&buf, p)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestExecInteractive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go ExecServer{}.Serve(l) //nolint:errcheck
+
+	exec := func(req ExecRequest, streams ExecStreams) (*ExecResult, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		return ExecInteractive(conn, req, streams)
+	}
+
+	var stdout, stderr bytes.Buffer
+	result, err := exec(ExecRequest{Command: []string{"sh", "-c", "cat; echo oops >&2; exit 3"}}, ExecStreams{
+		Stdin:  strings.NewReader("hello"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "hello", stdout.String())
+	assert.Equal(t, "oops\n", stderr.String())
+
+	_, err = exec(ExecRequest{Command: []string{"/does/not/exist"}}, ExecStreams{})
+	assert.NotNil(t, err)
+
+	master, slave, err := openPTY()
+	if err != nil {
+		t.Skipf("no pty available: %v", err)
+	}
+	master.Close()
+	slave.Close()
+
+	stdout.Reset()
+	result, err = exec(ExecRequest{Command: []string{"sh", "-c", "test -t 0 && echo tty"}, TTY: true}, ExecStreams{Stdout: &stdout})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "tty\r\n", stdout.String())
+}
+
+func TestExecCopy(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "data", "sub"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "data", "sub", "result"), []byte("42"), 0600))
+	assert.Nil(t, os.Symlink("sub/result", filepath.Join(root, "data", "latest")))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go ExecServer{Root: root}.Serve(l) //nolint:errcheck
+	exec := func(cmd []string, stdin io.Reader, stdout io.Writer) int {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		result, err := ExecInteractive(conn, ExecRequest{Command: cmd}, ExecStreams{Stdin: stdin, Stdout: stdout, Stderr: io.Discard})
+		assert.Nil(t, err)
+		return result.ExitCode
+	}
+
+	// kubectl cp checks its destination, then copies with tar.
+	assert.Equal(t, 0, exec([]string{"test", "-d", "/data"}, nil, nil))
+	assert.Equal(t, 1, exec([]string{"test", "-d", "/data/sub/result"}, nil, nil))
+
+	var archive bytes.Buffer
+	assert.Equal(t, 0, exec([]string{"tar", "cf", "-", "/data"}, nil, &archive))
+	var names []string
+	r := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	for header, err := r.Next(); err == nil; header, err = r.Next() {
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"data/", "data/latest", "data/sub/", "data/sub/result"}, names)
+
+	assert.Equal(t, 0, exec([]string{"tar", "--no-same-permissions", "--no-same-owner", "-xmf", "-", "-C", "/copy"}, bytes.NewReader(archive.Bytes()), nil))
+	data, err := os.ReadFile(filepath.Join(root, "copy", "data", "latest"))
+	assert.Nil(t, err)
+	assert.Equal(t, "42", string(data))
+
+	// Members do not escape the destination.
+	archive.Reset()
+	w := tar.NewWriter(&archive)
+	assert.Nil(t, w.WriteHeader(&tar.Header{Name: "../../escaped", Mode: 0644, Size: 2, Typeflag: tar.TypeReg}))
+	w.Write([]byte("no")) //nolint:errcheck
+	w.Close()
+	assert.Equal(t, 0, exec([]string{"tar", "xf", "-", "-C", "/copy"}, &archive, nil))
+	_, err = os.Stat(filepath.Join(root, "copy", "escaped"))
+	assert.Nil(t, err)
+
+	// Other uses of tar run the tar of the enclave.
+	assert.Nil(t, lookupBuiltin([]string{"tar", "czf", "out.tgz", "/data"}))
+}
+
+func TestAttach(t *testing.T) {
+	var output bytes.Buffer
+	w, err := StartWorkload([]string{"cat"}, WorkloadOptions{Stdin: true, Stdout: &output})
+	assert.Nil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go w.Serve(l) //nolint:errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	done := make(chan *ExecResult)
+	go func() {
+		result, err := Attach(conn, ExecStreams{Stdin: stdinR, Stdout: stdoutW})
+		assert.Nil(t, err)
+		done <- result
+	}()
+
+	// The workload echoes the attached stdin.
+	go stdinW.Write([]byte("hello")) //nolint:errcheck
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(stdoutR, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// Attached clients receive the exit status of the workload.
+	assert.Nil(t, w.Signal(syscall.SIGTERM))
+	select {
+	case result := <-done:
+		assert.Equal(t, 128+int(syscall.SIGTERM), result.ExitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("attach did not end with the workload")
+	}
+	assert.Equal(t, "hello", output.String())
+}
+
+func TestHelper(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go NewHelperServer().Serve(l) //nolint:errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	wait, err := StartHelper(conn, HelperRequest{
+		Name:    "debugger",
+		Command: []string{"sh", "-c", "read line; echo $line $GREETING; exit 3"},
+		Env:     []string{"GREETING=world"},
+		Stdin:   true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// Helpers are only started once.
+	again, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer again.Close()
+	_, err = StartHelper(again, HelperRequest{Name: "debugger", Command: []string{"true"}})
+	assert.EqualError(t, err, `helper "debugger" was already started`)
+
+	attach, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer attach.Close()
+	var stdout bytes.Buffer
+	stdinR, stdinW := io.Pipe()
+	done := make(chan *ExecResult)
+	go func() {
+		result, err := AttachHelper(attach, "debugger", ExecStreams{Stdin: stdinR, Stdout: &stdout})
+		assert.Nil(t, err)
+		done <- result
+	}()
+	go stdinW.Write([]byte("hello\n")) //nolint:errcheck
+
+	result, err := wait()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	select {
+	case result := <-done:
+		assert.Equal(t, 3, result.ExitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("attach did not end with the helper")
+	}
+	assert.Equal(t, "hello world\n", stdout.String())
+}
+
+type logRecorder struct {
+	mu  sync.Mutex
+	out map[LogStream]*bytes.Buffer
+}
+
+func (r *logRecorder) WriteLog(stream LogStream, p []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.out[stream] == nil {
+		r.out[stream] = new(bytes.Buffer)
+	}
+	r.out[stream].Write(p)
+	return nil
+}
+
+func (r *logRecorder) String(stream LogStream) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.out[stream] == nil {
+		return ""
+	}
+	return r.out[stream].String()
+}
+
+func TestLogClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	receiver := NewLogReceiver()
+	logs := &logRecorder{out: make(map[LogStream]*bytes.Buffer)}
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go func() {
+				r := bufio.NewReader(conn)
+				first, err := r.Peek(1)
+				if err != nil || !IsVersioned(first[0]) {
+					conn.Close()
+					return
+				}
+				receiver.Serve(conn, r, logs) //nolint:errcheck
+			}()
+		}
+	}()
+
+	c := NewLogClient(func() (net.Conn, error) { return net.Dial("tcp", l.Addr().String()) })
+	c.Stream(LogStdout).Write([]byte("hello\n")) //nolint:errcheck
+	c.Stream(LogStderr).Write([]byte("oops\n"))  //nolint:errcheck
+	assert.Eventually(t, func() bool { return logs.String(LogStderr) == "oops\n" }, 5*time.Second, 10*time.Millisecond)
+
+	// The client reconnects once its connection is lost, resuming its session.
+	(<-conns).Close()
+	c.Stream(LogStdout).Write([]byte("world\n")) //nolint:errcheck
+	c.Close(5 * time.Second)
+	assert.Equal(t, "hello\nworld\n", logs.String(LogStdout))
+	assert.Equal(t, "oops\n", logs.String(LogStderr))
+}
+
+func TestMeasureClock(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go ClockServer{}.Serve(l) //nolint:errcheck
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// Both ends share the clock of the host.
+	offset, rtt, err := MeasureClock(conn)
+	assert.Nil(t, err)
+	assert.True(t, rtt >= 0 && rtt < time.Second, "rtt %s", rtt)
+	assert.True(t, offset > -rtt-time.Millisecond && offset < rtt+time.Millisecond, "offset %s", offset)
+}
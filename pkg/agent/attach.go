@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// AttachPort is the vsock port on which the agent attaches the provider to the workload's stdio.
+const AttachPort = 5102
+
+// AttachRequest asks the agent to attach to the workload.
+type AttachRequest struct {
+	Stdin bool `json:"stdin,omitempty"`
+}
+
+// WorkloadOptions configure the stdio of the workload.
+type WorkloadOptions struct {
+	// Stdin keeps the workload's stdin open for attached clients.
+	Stdin bool
+	// TTY runs the workload on a pseudo terminal.
+	TTY bool
+	// Env is added to the environment of the agent for the workload.
+	Env []string
+	// Stdout and Stderr receive the workload's output besides attached clients.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Workload is the primary process of the enclave, whose stdio can be attached to.
+type Workload struct {
+	cmd   *exec.Cmd
+	pty   *os.File
+	stdin io.WriteCloser
+
+	output sync.WaitGroup
+	done   chan struct{}
+	err    error
+
+	mu      sync.Mutex
+	clients map[*attachClient]struct{}
+}
+
+// attachClient is a connection attached to the workload.
+type attachClient struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// StartWorkload starts the workload with the given command line.
+func StartWorkload(args []string, opts WorkloadOptions) (*Workload, error) {
+	w := &Workload{
+		cmd:     exec.Command(args[0], args[1:]...),
+		done:    make(chan struct{}),
+		clients: make(map[*attachClient]struct{}),
+	}
+	if len(opts.Env) > 0 {
+		w.cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = io.Discard
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = io.Discard
+	}
+	stdout := io.MultiWriter(opts.Stdout, w.broadcaster(StreamStdout))
+	stderr := io.MultiWriter(opts.Stderr, w.broadcaster(StreamStderr))
+
+	if opts.TTY {
+		master, slave, err := openPTY()
+		if err != nil {
+			return nil, err
+		}
+		w.cmd.Stdin, w.cmd.Stdout, w.cmd.Stderr = slave, slave, slave
+		w.cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+		err = w.cmd.Start()
+		slave.Close()
+		if err != nil {
+			master.Close()
+			return nil, err
+		}
+
+		w.pty, w.stdin = master, master
+		w.output.Add(1)
+		go func() {
+			defer w.output.Done()
+			io.Copy(stdout, master) //nolint:errcheck
+		}()
+	} else {
+		w.cmd.Stdout, w.cmd.Stderr = stdout, stderr
+		if opts.Stdin {
+			stdin, err := w.cmd.StdinPipe()
+			if err != nil {
+				return nil, err
+			}
+			w.stdin = stdin
+		}
+		if err := w.cmd.Start(); err != nil {
+			return nil, err
+		}
+	}
+
+	go func() {
+		w.err = w.cmd.Wait()
+		w.output.Wait()
+		if w.pty != nil {
+			w.pty.Close()
+		}
+		close(w.done)
+		w.detachAll()
+	}()
+
+	return w, nil
+}
+
+// Signal sends a signal to the workload.
+func (w *Workload) Signal(sig os.Signal) error {
+	return w.cmd.Process.Signal(sig)
+}
+
+// Wait waits for the workload to exit and returns the error of exec.Cmd.Wait.
+func (w *Workload) Wait() error {
+	<-w.done
+	return w.err
+}
+
+// Serve attaches connections to the workload until the listener is closed.
+func (w *Workload) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go w.attach(conn)
+	}
+}
+
+func (w *Workload) attach(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	var req AttachRequest
+	if err := decoder.Decode(&req); err != nil {
+		return
+	}
+	w.serveClient(conn, io.MultiReader(decoder.Buffered(), conn), req.Stdin)
+}
+
+// serveClient attaches a connection to the workload until it detaches,
+// reading the frames sent by the provider from r.
+func (w *Workload) serveClient(conn net.Conn, r io.Reader, stdin bool) {
+	client := &attachClient{conn: conn}
+	w.mu.Lock()
+	select {
+	case <-w.done:
+		w.mu.Unlock()
+		client.exit(w.err)
+		return
+	default:
+	}
+	w.clients[client] = struct{}{}
+	w.mu.Unlock()
+	defer w.detach(client)
+
+	// Input from the provider, until it detaches.
+	for {
+		stream, p, err := ReadFrame(r)
+		if err != nil {
+			return
+		}
+
+		switch stream {
+		case StreamStdin:
+			// Closing an attached stdin only detaches it, other clients may still write to it.
+			if stdin && w.stdin != nil && len(p) > 0 {
+				w.stdin.Write(p) //nolint:errcheck
+			}
+		case StreamResize:
+			var size TermSize
+			if w.pty != nil && json.Unmarshal(p, &size) == nil {
+				resizePTY(w.pty, size) //nolint:errcheck
+			}
+		}
+	}
+}
+
+// broadcaster returns a writer copying the workload's output of a stream to every attached client.
+func (w *Workload) broadcaster(stream byte) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		for client := range w.clients {
+			client.mu.Lock()
+			err := WriteFrame(client.conn, stream, p)
+			client.mu.Unlock()
+			if err != nil {
+				// The client went away, its reader detaches it.
+				client.conn.Close()
+			}
+		}
+		return len(p), nil
+	})
+}
+
+func (w *Workload) detach(client *attachClient) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.clients, client)
+}
+
+// detachAll reports the workload's exit to every attached client.
+func (w *Workload) detachAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for client := range w.clients {
+		client.exit(w.err)
+		client.conn.Close()
+	}
+}
+
+// exit sends the exit status of the workload to the client.
+func (c *attachClient) exit(err error) {
+	result := ExecResult{}
+	code, _, ok := ExitStatus(err)
+	if ok {
+		result.ExitCode = code
+	} else {
+		result.Error = err.Error()
+	}
+	p, _ := json.Marshal(result)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	WriteFrame(c.conn, StreamExit, p) //nolint:errcheck
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hf/nsm"
+	"github.com/hf/nsm/request"
+)
+
+// AttestPort is the vsock port on which the agent serves attestation
+// documents of its enclave to the provider.
+const AttestPort = 5104
+
+// attestRequest asks the agent for an attestation document.
+type attestRequest struct {
+	Nonce    []byte `json:"nonce,omitempty"`
+	UserData []byte `json:"userData,omitempty"`
+}
+
+// attestResult carries the attestation document returned by the agent.
+type attestResult struct {
+	Document []byte `json:"document,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RequestAttestation asks the agent reachable over conn for an attestation
+// document of its enclave, which includes the nonce and user data.
+func RequestAttestation(conn net.Conn, nonce, userData []byte, timeout time.Duration) ([]byte, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(conn).Encode(attestRequest{Nonce: nonce, UserData: userData}); err != nil {
+		return nil, err
+	}
+
+	var result attestResult
+	if err := json.NewDecoder(conn).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to read attestation document: %v", err)
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return result.Document, nil
+}
+
+// AttestServer serves attestation documents signed by the Nitro Secure
+// Module. It runs inside the enclave.
+type AttestServer struct {
+	// Attest returns an attestation document including the nonce and user
+	// data, NSMAttest when nil.
+	Attest func(nonce, userData []byte) ([]byte, error)
+}
+
+// Serve answers attestation requests until the listener is closed.
+func (s AttestServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s AttestServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req attestRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	attest := s.Attest
+	if attest == nil {
+		attest = NSMAttest
+	}
+	var result attestResult
+	doc, err := attest(req.Nonce, req.UserData)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Document = doc
+	json.NewEncoder(conn).Encode(result) //nolint:errcheck
+}
+
+// NSMAttest requests an attestation document including the nonce and user
+// data from the Nitro Secure Module.
+func NSMAttest(nonce, userData []byte) ([]byte, error) {
+	return nsmAttest(&request.Attestation{Nonce: nonce, UserData: userData})
+}
+
+// NSMAttestKey requests an attestation document including the public key,
+// in DER, from the Nitro Secure Module.
+func NSMAttestKey(publicKey []byte) ([]byte, error) {
+	return nsmAttest(&request.Attestation{PublicKey: publicKey})
+}
+
+func nsmAttest(req *request.Attestation) ([]byte, error) {
+	s, err := nsm.OpenDefaultSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	res, err := s.Send(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.Attestation == nil || res.Attestation.Document == nil {
+		return nil, errors.New("NSM device did not return an attestation")
+	}
+	return res.Attestation.Document, nil
+}
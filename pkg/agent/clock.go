@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ClockPortBase is added to an enclave's CID to get the host vsock port of
+	// the time service the agent synchronizes the enclave's clock with.
+	ClockPortBase = 15000
+
+	// ClockSyncInterval is how often the agent synchronizes the clock of the enclave.
+	ClockSyncInterval = time.Minute
+	// clockSamples is how many exchanges a synchronization takes, the fastest one is trusted.
+	clockSamples = 4
+	// clockStepThreshold is the offset beyond which the clock is stepped, smaller ones are slewed.
+	clockStepThreshold = 100 * time.Millisecond
+	// clockTimeout bounds an exchange with the time service.
+	clockTimeout = 5 * time.Second
+)
+
+// ClockPort returns the host vsock port of the time service for the enclave with the given CID.
+func ClockPort(cid uint32) uint32 {
+	return ClockPortBase + cid
+}
+
+// The clock protocol is a simplified NTP exchange: the client sends the time
+// it sends its request, 8 bytes of nanoseconds since the epoch, to which the
+// server replies with that time followed by the times it received the request
+// and sent its reply.
+
+// ClockServer serves the time of the host to the agents of enclaves, whose
+// clocks drift without NTP.
+type ClockServer struct{}
+
+// Serve answers time requests until the listener is closed.
+func (s ClockServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func (ClockServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var request [8]byte
+	for {
+		if err := conn.SetDeadline(time.Now().Add(clockTimeout)); err != nil {
+			return
+		}
+		if _, err := conn.Read(request[:]); err != nil {
+			return
+		}
+		received := time.Now()
+		var reply [24]byte
+		copy(reply[:8], request[:])
+		binary.BigEndian.PutUint64(reply[8:], uint64(received.UnixNano()))
+		binary.BigEndian.PutUint64(reply[16:], uint64(time.Now().UnixNano()))
+		if _, err := conn.Write(reply[:]); err != nil {
+			return
+		}
+	}
+}
+
+// MeasureClock measures the offset of the local clock to the clock of the
+// time service reachable over conn, to be added to the local clock, and the
+// round trip time of the exchange it was measured with.
+func MeasureClock(conn net.Conn) (offset, rtt time.Duration, err error) {
+	if err := conn.SetDeadline(time.Now().Add(clockTimeout)); err != nil {
+		return 0, 0, err
+	}
+
+	var request [8]byte
+	var reply [24]byte
+	for i := 0; i < clockSamples; i++ {
+		sent := time.Now()
+		binary.BigEndian.PutUint64(request[:], uint64(sent.UnixNano()))
+		if _, err := conn.Write(request[:]); err != nil {
+			return 0, 0, err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return 0, 0, err
+		}
+		// The monotonic clock measures the round trip, as the wall clock may be stepped meanwhile.
+		elapsed := time.Since(sent)
+
+		t0 := int64(binary.BigEndian.Uint64(reply[:8]))
+		t1 := int64(binary.BigEndian.Uint64(reply[8:]))
+		t2 := int64(binary.BigEndian.Uint64(reply[16:]))
+		t3 := t0 + int64(elapsed)
+		sampleRTT := elapsed - time.Duration(t2-t1)
+		if i == 0 || sampleRTT < rtt {
+			offset, rtt = time.Duration(((t1-t0)+(t2-t3))/2), sampleRTT
+		}
+	}
+	return offset, rtt, nil
+}
+
+// SyncClock synchronizes the clock of the enclave with the time service
+// reached with dial. It runs inside the enclave.
+func SyncClock(dial func() (net.Conn, error)) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	offset, _, err := MeasureClock(conn)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	return adjustClock(offset)
+}
+
+// adjustClock steps the clock by large offsets, and slews it by small ones
+// so time never goes backwards under the workload.
+func adjustClock(offset time.Duration) error {
+	if offset > clockStepThreshold || offset < -clockStepThreshold {
+		log.L.Infof("Stepping clock by %s", offset)
+		tv := unix.NsecToTimeval(time.Now().Add(offset).UnixNano())
+		return unix.Settimeofday(&tv)
+	}
+	tx := unix.Timex{Modes: unix.ADJ_OFFSET_SINGLESHOT, Offset: offset.Microseconds()}
+	_, err := unix.Adjtimex(&tx)
+	return err
+}
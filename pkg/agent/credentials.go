@@ -0,0 +1,14 @@
+package agent
+
+// CredentialsPortBase is added to an enclave's CID to get the host vsock port
+// of the credential endpoint vending the AWS credentials of that enclave's pod.
+const CredentialsPortBase = 14000
+
+// CredentialsPort returns the host vsock port of the credential endpoint for the enclave with the given CID.
+func CredentialsPort(cid uint32) uint32 {
+	return CredentialsPortBase + cid
+}
+
+// CredentialsPath is the path of the credential endpoint, which serves the
+// credentials in the format of the ECS container credential provider.
+const CredentialsPath = "/credentials"
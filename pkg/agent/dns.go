@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+const (
+	// DNSPortBase is added to an enclave's CID to get the host vsock port of
+	// the DNS proxy resolving names for that enclave.
+	DNSPortBase = 13000
+
+	// dnsTimeout bounds how long a query relayed to the provider may take.
+	dnsTimeout = 10 * time.Second
+)
+
+// DNSPort returns the host vsock port of the DNS proxy for the enclave with the given CID.
+func DNSPort(cid uint32) uint32 {
+	return DNSPortBase + cid
+}
+
+// ReadDNSMessage reads a DNS message prefixed by its 2 bytes length, as in DNS over TCP.
+func ReadDNSMessage(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, size)
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// WriteDNSMessage writes a DNS message prefixed by its 2 bytes length.
+func WriteDNSMessage(w io.Writer, msg []byte) error {
+	if len(msg) > 65535 {
+		return fmt.Errorf("DNS message of %d bytes is too large", len(msg))
+	}
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	_, err := w.Write(buf)
+	return err
+}
+
+// DNSForwarder relays the DNS queries of the workload to the DNS proxy of
+// the provider. It runs inside the enclave.
+type DNSForwarder struct {
+	// Dial connects to the DNS proxy.
+	Dial func() (net.Conn, error)
+}
+
+// ServeUDP relays the queries received on pc until it is closed.
+func (f DNSForwarder) ServeUDP(pc net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			response, err := f.exchange(query)
+			if err != nil {
+				log.L.Warnf("Failed to relay DNS query: %v", err)
+				return
+			}
+			pc.WriteTo(response, addr) //nolint:errcheck
+		}()
+	}
+}
+
+// ServeTCP relays the queries received over the connections accepted on l until it is closed.
+func (f DNSForwarder) ServeTCP(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				conn.SetDeadline(time.Now().Add(dnsTimeout))
+				query, err := ReadDNSMessage(r)
+				if err != nil {
+					return
+				}
+				response, err := f.exchange(query)
+				if err != nil {
+					log.L.Warnf("Failed to relay DNS query: %v", err)
+					return
+				}
+				if err := WriteDNSMessage(conn, response); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// exchange relays a query to the provider and returns its response.
+func (f DNSForwarder) exchange(query []byte) ([]byte, error) {
+	conn, err := f.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if err := WriteDNSMessage(conn, query); err != nil {
+		return nil, err
+	}
+	return ReadDNSMessage(conn)
+}
+
+// WriteResolvConf points the resolver of the enclave to the given name
+// server, keeping the search domains and options of the existing file, which
+// the provider generates from the DNS config of the pod.
+func WriteResolvConf(path, nameserver string) error {
+	lines := []string{"nameserver " + nameserver}
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && (fields[0] == "search" || fields[0] == "options") {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
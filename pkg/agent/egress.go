@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// EgressPortBase is added to an enclave's CID to get the host vsock port of
+// the egress proxy forwarding that enclave's traffic to allowed destinations.
+const EgressPortBase = 12000
+
+// EgressPort returns the host vsock port of the egress proxy for the enclave with the given CID.
+func EgressPort(cid uint32) uint32 {
+	return EgressPortBase + cid
+}
+
+// EgressForwarder forwards the connections of the workload to the egress
+// proxy of the provider, so the workload can use it as its HTTP proxy. It
+// runs inside the enclave.
+type EgressForwarder struct {
+	// Dial connects to the egress proxy.
+	Dial func() (net.Conn, error)
+}
+
+// Serve accepts connections from the workload until the listener is closed.
+func (f EgressForwarder) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go f.forward(conn)
+	}
+}
+
+func (f EgressForwarder) forward(conn net.Conn) {
+	defer conn.Close()
+
+	upstream, err := f.Dial()
+	if err != nil {
+		log.L.Warnf("Failed to reach egress proxy: %v", err)
+		return
+	}
+	defer upstream.Close()
+
+	// Either side closing ends the connection.
+	var once sync.Once
+	done := make(chan struct{})
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src) //nolint:errcheck
+		once.Do(func() { close(done) })
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	<-done
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"github.com/hf/nsm"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// entropySeedSize is how many random bytes of the NSM seed the kernel's
+	// pool, as much as the pool of recent kernels holds.
+	entropySeedSize = 256
+
+	// rndAddEntropy is the RNDADDENTROPY ioctl of random(4), adding bytes to
+	// the pool and crediting their entropy.
+	rndAddEntropy = 0x40085203
+)
+
+// SeedEntropy seeds the kernel's random pool with bytes of the random source
+// of the Nitro Secure Module. Enclaves have no other hardware source, so
+// reads of getrandom(2) block, or /dev/urandom returns weak bytes, for a
+// while after boot otherwise.
+func SeedEntropy() error {
+	s, err := nsm.OpenDefaultSession()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	seed := make([]byte, entropySeedSize)
+	if _, err := io.ReadFull(s, seed); err != nil {
+		return fmt.Errorf("failed to read NSM random source: %w", err)
+	}
+	return addEntropy(seed)
+}
+
+// addEntropy adds the bytes to the kernel's random pool, crediting their
+// entropy when allowed, else only mixing them in through /dev/urandom.
+func addEntropy(seed []byte) error {
+	f, err := os.OpenFile("/dev/random", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// struct rand_pool_info { int entropy_count; int buf_size; __u32 buf[]; }
+	info := make([]byte, 8+len(seed))
+	*(*int32)(unsafe.Pointer(&info[0])) = int32(len(seed) * 8)
+	*(*int32)(unsafe.Pointer(&info[4])) = int32(len(seed))
+	copy(info[8:], seed)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), rndAddEntropy, uintptr(unsafe.Pointer(&info[0])))
+	if errno == 0 {
+		return nil
+	}
+
+	// Writes mix the bytes in without crediting them.
+	if _, err := f.Write(seed); err != nil {
+		return fmt.Errorf("failed to add entropy: %v, %w", errno, err)
+	}
+	return nil
+}
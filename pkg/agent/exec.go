@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// ExecPort is the vsock port on which the agent runs commands on behalf of the provider.
+	ExecPort = 5101
+
+	// maxExecOutput is how much of a command's output is returned, matching
+	// the kubelet's limit on probe output.
+	maxExecOutput = 10 * 1024
+)
+
+// ExecRequest asks the agent to run a command in the enclave.
+type ExecRequest struct {
+	Command []string      `json:"command"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// Interactive commands stream their stdio in frames, see ExecInteractive.
+	Interactive bool `json:"interactive,omitempty"`
+	Stdin       bool `json:"stdin,omitempty"`
+	TTY         bool `json:"tty,omitempty"`
+}
+
+// ExecResult is the outcome of a command run by the agent.
+type ExecResult struct {
+	ExitCode int    `json:"exitCode"`
+	Output   []byte `json:"output,omitempty"`
+	// Error is set when the command could not be run at all.
+	Error string `json:"error,omitempty"`
+}
+
+// Exec runs a command through the agent reachable over conn.
+func Exec(conn net.Conn, req ExecRequest) (*ExecResult, error) {
+	if req.Timeout > 0 {
+		// Leave the agent some time to report a command which timed out.
+		if err := conn.SetDeadline(time.Now().Add(2 * req.Timeout)); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	var result ExecResult
+	if err := json.NewDecoder(conn).Decode(&result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return &result, nil
+}
+
+// ExecServer runs commands requested by the provider. It runs inside the enclave.
+type ExecServer struct {
+	// Root is the directory the paths of builtin commands are in, / when
+	// empty. Simulated enclaves keep their files in their own root.
+	Root string
+}
+
+// Serve accepts requests until the listener is closed.
+func (s ExecServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s ExecServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	var req ExecRequest
+	if err := decoder.Decode(&req); err != nil {
+		return
+	}
+	if req.Interactive {
+		runInteractive(conn, io.MultiReader(decoder.Buffered(), conn), req, s.Root)
+		return
+	}
+	json.NewEncoder(conn).Encode(runExec(req)) //nolint:errcheck
+}
+
+// ExitStatus returns the exit code of a command from the error returned by
+// its Wait method, following the shell convention of reporting 128+n for a
+// process killed by signal n. ok is false when the command did not run.
+func ExitStatus(err error) (code int, signal string, ok bool) {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, "", true
+	case errors.As(err, &exitErr):
+		status, isWaitStatus := exitErr.Sys().(syscall.WaitStatus)
+		if isWaitStatus && status.Signaled() {
+			return 128 + int(status.Signal()), status.Signal().String(), true
+		}
+		return exitErr.ExitCode(), "", true
+	default:
+		return 0, "", false
+	}
+}
+
+func runExec(req ExecRequest) ExecResult {
+	if len(req.Command) == 0 {
+		return ExecResult{Error: "no command to run"}
+	}
+
+	ctx := context.Background()
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	output, err := exec.CommandContext(ctx, req.Command[0], req.Command[1:]...).CombinedOutput()
+	if len(output) > maxExecOutput {
+		output = output[:maxExecOutput]
+	}
+
+	if ctx.Err() != nil {
+		return ExecResult{Error: "command timed out"}
+	}
+	code, _, ok := ExitStatus(err)
+	if !ok {
+		return ExecResult{Error: err.Error()}
+	}
+	return ExecResult{ExitCode: code, Output: output}
+}
+
+// runInteractive runs a command streaming its stdio over conn, reading the
+// frames sent by the provider from r. Builtins run with the paths in root.
+func runInteractive(conn net.Conn, r io.Reader, req ExecRequest, root string) {
+	var mu sync.Mutex
+	exit := func(result ExecResult) {
+		p, _ := json.Marshal(result)
+		mu.Lock()
+		defer mu.Unlock()
+		WriteFrame(conn, StreamExit, p) //nolint:errcheck
+	}
+	if len(req.Command) == 0 {
+		exit(ExecResult{Error: "no command to run"})
+		return
+	}
+	if run := lookupBuiltin(req.Command); run != nil && !req.TTY {
+		exit(ExecResult{ExitCode: runBuiltin(run, root, r, frameWriter{&mu, conn, StreamStdout}, frameWriter{&mu, conn, StreamStderr})})
+		return
+	}
+
+	cmd := exec.Command(req.Command[0], req.Command[1:]...)
+	stdout := frameWriter{&mu, conn, StreamStdout}
+
+	var (
+		stdin  io.WriteCloser
+		pty    *os.File
+		output sync.WaitGroup
+	)
+	if req.TTY {
+		master, slave, err := openPTY()
+		if err != nil {
+			exit(ExecResult{Error: err.Error()})
+			return
+		}
+		defer master.Close()
+
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+		err = cmd.Start()
+		slave.Close()
+		if err != nil {
+			exit(ExecResult{Error: err.Error()})
+			return
+		}
+
+		pty, stdin = master, master
+		output.Add(1)
+		go func() {
+			defer output.Done()
+			io.Copy(stdout, master) //nolint:errcheck
+		}()
+	} else {
+		cmd.Stdout = stdout
+		cmd.Stderr = frameWriter{&mu, conn, StreamStderr}
+		if req.Stdin {
+			var err error
+			if stdin, err = cmd.StdinPipe(); err != nil {
+				exit(ExecResult{Error: err.Error()})
+				return
+			}
+		}
+		if err := cmd.Start(); err != nil {
+			exit(ExecResult{Error: err.Error()})
+			return
+		}
+	}
+
+	// Input from the provider, the command is killed if the provider goes away.
+	go func() {
+		for {
+			stream, p, err := ReadFrame(r)
+			if err != nil {
+				cmd.Process.Kill()
+				return
+			}
+
+			switch stream {
+			case StreamStdin:
+				switch {
+				case stdin == nil:
+				case len(p) == 0 && pty == nil:
+					stdin.Close()
+				default:
+					stdin.Write(p) //nolint:errcheck
+				}
+			case StreamResize:
+				var size TermSize
+				if pty != nil && json.Unmarshal(p, &size) == nil {
+					resizePTY(pty, size) //nolint:errcheck
+				}
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	output.Wait()
+
+	code, _, ok := ExitStatus(err)
+	if !ok {
+		exit(ExecResult{Error: err.Error()})
+		return
+	}
+	exit(ExecResult{ExitCode: code})
+}
+
+// runBuiltin runs a builtin with the stdin frames read from r, returning its
+// exit code. The builtin fails reading its stdin if the provider goes away.
+func runBuiltin(run builtin, root string, r io.Reader, stdout, stderr io.Writer) int {
+	stdin, input := io.Pipe()
+	defer stdin.Close()
+	go func() {
+		for {
+			stream, p, err := ReadFrame(r)
+			if err != nil {
+				input.CloseWithError(err)
+				return
+			}
+			if stream == StreamStdin {
+				if len(p) == 0 {
+					input.Close()
+				} else {
+					input.Write(p) //nolint:errcheck
+				}
+			}
+		}
+	}()
+	return run(root, stdin, stdout, stderr)
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FilesPort is the vsock port on which the agent receives the files of
+// projected volumes pushed by the provider.
+const FilesPort = 5103
+
+// File is a file written in the enclave by the agent.
+type File struct {
+	Path string      `json:"path"`
+	Data []byte      `json:"data"`
+	Mode os.FileMode `json:"mode,omitempty"`
+}
+
+// filesResult acknowledges pushed files.
+type filesResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+// PushFiles sends files to the agent reachable over conn and waits for them to be written.
+func PushFiles(conn net.Conn, files []File, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(files); err != nil {
+		return err
+	}
+
+	var result filesResult
+	if err := json.NewDecoder(conn).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read acknowledgement: %v", err)
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// FileServer writes the files pushed by the provider. It runs inside the enclave.
+type FileServer struct {
+	// Root is the directory the paths of the files are in, / when empty.
+	// Simulated enclaves keep their files in their own root.
+	Root string
+
+	once  sync.Once
+	ready chan struct{}
+}
+
+// NewFileServer creates a new FileServer.
+func NewFileServer() *FileServer {
+	return &FileServer{ready: make(chan struct{})}
+}
+
+// Ready is closed once the first files have been written.
+func (s *FileServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Serve accepts files until the listener is closed.
+func (s *FileServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *FileServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var files []File
+	if err := json.NewDecoder(conn).Decode(&files); err != nil {
+		return
+	}
+
+	var result filesResult
+	for _, f := range files {
+		if err := writeFile(s.Root, f); err != nil {
+			result.Error = err.Error()
+			break
+		}
+	}
+	if result.Error == "" {
+		s.once.Do(func() { close(s.ready) })
+	}
+	json.NewEncoder(conn).Encode(result) //nolint:errcheck
+}
+
+// writeFile atomically replaces a file of root, so the workload never reads
+// a partial update.
+func writeFile(root string, f File) error {
+	if !filepath.IsAbs(f.Path) || filepath.Clean(f.Path) != f.Path {
+		return fmt.Errorf("invalid path %q", f.Path)
+	}
+	path := filepath.Join("/", root, f.Path)
+	mode := f.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(f.Data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// HelperPort is the vsock port on which the agent runs helper processes
+// beside the workload, such as the ephemeral debug containers of a pod.
+const HelperPort = 5105
+
+// HelperRequest asks the agent to start a helper process, or to attach to
+// the stdio of a helper it started.
+type HelperRequest struct {
+	// Name identifies the helper, only one helper of a name is ever started.
+	Name    string   `json:"name"`
+	Command []string `json:"command,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Stdin   bool     `json:"stdin,omitempty"`
+	TTY     bool     `json:"tty,omitempty"`
+
+	// Attach attaches to the helper instead of starting it, see AttachHelper.
+	Attach bool `json:"attach,omitempty"`
+}
+
+// StartHelper starts a helper process through the agent reachable over conn.
+// The returned function waits for the helper to exit, or for conn to be closed.
+func StartHelper(conn net.Conn, req HelperRequest) (func() (*ExecResult, error), error) {
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(conn)
+	var started ExecResult
+	if err := decoder.Decode(&started); err != nil {
+		return nil, err
+	}
+	if started.Error != "" {
+		return nil, errors.New(started.Error)
+	}
+
+	return func() (*ExecResult, error) {
+		var result ExecResult
+		if err := decoder.Decode(&result); err != nil {
+			return nil, err
+		}
+		if result.Error != "" {
+			return nil, errors.New(result.Error)
+		}
+		return &result, nil
+	}, nil
+}
+
+// AttachHelper attaches to the stdio of a helper through the agent reachable
+// over conn, until the helper exits or the connection is closed.
+func AttachHelper(conn net.Conn, name string, streams ExecStreams) (*ExecResult, error) {
+	return stream(conn, HelperRequest{Name: name, Attach: true, Stdin: streams.Stdin != nil}, streams)
+}
+
+// HelperServer runs the helper processes requested by the provider. It runs
+// inside the enclave.
+type HelperServer struct {
+	mu      sync.Mutex
+	helpers map[string]*Workload
+}
+
+// NewHelperServer creates a new HelperServer.
+func NewHelperServer() *HelperServer {
+	return &HelperServer{helpers: make(map[string]*Workload)}
+}
+
+// Serve accepts requests until the listener is closed.
+func (s *HelperServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *HelperServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	var req HelperRequest
+	if err := decoder.Decode(&req); err != nil {
+		return
+	}
+
+	if req.Attach {
+		s.mu.Lock()
+		helper, ok := s.helpers[req.Name]
+		s.mu.Unlock()
+		if !ok {
+			(&attachClient{conn: conn}).exit(fmt.Errorf("no helper named %q", req.Name))
+			return
+		}
+		helper.serveClient(conn, io.MultiReader(decoder.Buffered(), conn), req.Stdin)
+		return
+	}
+
+	helper, err := s.start(req)
+	if err != nil {
+		json.NewEncoder(conn).Encode(ExecResult{Error: err.Error()}) //nolint:errcheck
+		return
+	}
+	if err := json.NewEncoder(conn).Encode(ExecResult{}); err != nil {
+		return
+	}
+
+	// Exited helpers stay around, so attaching to them reports their exit.
+	code, _, ok := ExitStatus(helper.Wait())
+	if !ok {
+		json.NewEncoder(conn).Encode(ExecResult{Error: helper.err.Error()}) //nolint:errcheck
+		return
+	}
+	json.NewEncoder(conn).Encode(ExecResult{ExitCode: code}) //nolint:errcheck
+}
+
+// start starts a helper, unless one of the same name was started already.
+func (s *HelperServer) start(req HelperRequest) (*Workload, error) {
+	if req.Name == "" || len(req.Command) == 0 {
+		return nil, errors.New("a helper needs a name and a command")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.helpers[req.Name]; ok {
+		return nil, fmt.Errorf("helper %q was already started", req.Name)
+	}
+	helper, err := StartWorkload(req.Command, WorkloadOptions{Stdin: req.Stdin, TTY: req.TTY, Env: req.Env})
+	if err != nil {
+		return nil, err
+	}
+	s.helpers[req.Name] = helper
+	return helper, nil
+}
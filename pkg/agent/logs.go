@@ -0,0 +1,404 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// LogStream identifies the output stream of the workload a log frame comes from.
+type LogStream byte
+
+const (
+	// LogStdout tags the frames of the workload's standard output.
+	LogStdout LogStream = 1
+	// LogStderr tags the frames of the workload's standard error.
+	LogStderr LogStream = 2
+
+	// LogProtocolVersion is the version of the log protocol spoken by this agent.
+	LogProtocolVersion = 1
+	// logMagic starts the log connections of agents speaking a versioned log
+	// protocol. Agents predating it send their raw output, which never starts with a NUL.
+	logMagic = "\x00NEL"
+
+	// maxLogFrame bounds the payload of a log frame, larger writes are split.
+	maxLogFrame = 32 * 1024
+	// logWindow bounds the bytes of the frames not acknowledged yet. Writes
+	// block while the window is full, so bursts are paced to the provider.
+	logWindow = 1024 * 1024
+	// logBackpressureTimeout bounds how long writes block on a full window,
+	// the oldest frames are dropped afterwards so the workload never hangs
+	// on its output while the provider is unreachable.
+	logBackpressureTimeout = 5 * time.Second
+	// logRedialDelay is how long the client waits before reconnecting.
+	logRedialDelay = time.Second
+)
+
+func (s LogStream) String() string {
+	switch s {
+	case LogStdout:
+		return "stdout"
+	case LogStderr:
+		return "stderr"
+	}
+	return fmt.Sprintf("stream %d", byte(s))
+}
+
+// Valid tells whether s is a known stream.
+func (s LogStream) Valid() bool {
+	return s == LogStdout || s == LogStderr
+}
+
+// LogWriter receives the output of the workload, stream by stream.
+type LogWriter interface {
+	WriteLog(stream LogStream, p []byte) error
+}
+
+// The log protocol starts with the client sending logMagic, its protocol
+// version and the 8 bytes ID of its session, to which the server replies with
+// its own version and the 8 bytes sequence number of the last frame of the
+// session it received, 0 for new sessions. Frames follow, made of their stream,
+// 8 bytes sequence number, 4 bytes length and payload. The server acknowledges
+// frames with their sequence number once written. A client reconnecting resumes
+// its session, resending the frames which were not acknowledged.
+
+type logFrame struct {
+	stream LogStream
+	seq    uint64
+	data   []byte
+}
+
+func writeLogFrame(w io.Writer, f logFrame) error {
+	buf := make([]byte, 13+len(f.data))
+	buf[0] = byte(f.stream)
+	binary.BigEndian.PutUint64(buf[1:], f.seq)
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(f.data)))
+	copy(buf[13:], f.data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readLogFrame(r io.Reader) (logFrame, error) {
+	var header [13]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return logFrame{}, err
+	}
+	f := logFrame{stream: LogStream(header[0]), seq: binary.BigEndian.Uint64(header[1:])}
+	if !f.stream.Valid() {
+		return logFrame{}, fmt.Errorf("invalid log frame of %s", f.stream)
+	}
+	size := binary.BigEndian.Uint32(header[9:])
+	if size > maxLogFrame {
+		return logFrame{}, fmt.Errorf("log frame of %d bytes is too large", size)
+	}
+	f.data = make([]byte, size)
+	_, err := io.ReadFull(r, f.data)
+	return f, err
+}
+
+// LogClient forwards the output of the workload to the log server of the
+// provider, reconnecting and resending what was not acknowledged whenever
+// the connection is lost. It runs inside the enclave.
+type LogClient struct {
+	dial    func() (net.Conn, error)
+	session uint64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []logFrame // frames not acknowledged yet, by sequence number
+	sent    int        // how many pending frames were sent on the current connection
+	size    int        // bytes of the pending frames
+	nextSeq uint64
+	dropped int
+	closed  bool
+	// abandoned tells the client stopped before the pending frames were acknowledged.
+	abandoned bool
+	done      chan struct{}
+}
+
+// NewLogClient creates a client connecting to the log server with dial, and starts forwarding.
+func NewLogClient(dial func() (net.Conn, error)) *LogClient {
+	var session [8]byte
+	_, _ = rand.Read(session[:])
+	c := &LogClient{
+		dial:    dial,
+		session: binary.BigEndian.Uint64(session[:]),
+		nextSeq: 1,
+		done:    make(chan struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	go c.run()
+	return c
+}
+
+// Stream returns the writer forwarding the output of one of the workload's streams.
+func (c *LogClient) Stream(stream LogStream) io.Writer {
+	return logStreamWriter{c, stream}
+}
+
+type logStreamWriter struct {
+	c      *LogClient
+	stream LogStream
+}
+
+func (w logStreamWriter) Write(p []byte) (int, error) {
+	for data := p; len(data) > 0; {
+		n := len(data)
+		if n > maxLogFrame {
+			n = maxLogFrame
+		}
+		w.c.enqueue(w.stream, append([]byte(nil), data[:n]...))
+		data = data[n:]
+	}
+	return len(p), nil
+}
+
+// enqueue queues a frame, waiting for room in the window.
+func (c *LogClient) enqueue(stream LogStream, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	if c.size+len(data) > logWindow {
+		timeout := time.AfterFunc(logBackpressureTimeout, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// Drop the oldest frames, which are resent otherwise.
+			for len(c.pending) > 0 && c.size+len(data) > logWindow {
+				c.size -= len(c.pending[0].data)
+				c.pending = c.pending[1:]
+				if c.sent > 0 {
+					c.sent--
+				}
+				c.dropped++
+			}
+			c.cond.Broadcast()
+		})
+		for c.size+len(data) > logWindow && !c.closed {
+			c.cond.Wait()
+		}
+		timeout.Stop()
+	}
+	c.pending = append(c.pending, logFrame{stream, c.nextSeq, data})
+	c.nextSeq++
+	c.size += len(data)
+	c.cond.Broadcast()
+}
+
+// acknowledge removes the frames received by the server from the pending frames.
+func (c *LogClient) acknowledge(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.pending) > 0 && c.pending[0].seq <= seq {
+		c.size -= len(c.pending[0].data)
+		c.pending = c.pending[1:]
+		if c.sent > 0 {
+			c.sent--
+		}
+	}
+	c.cond.Broadcast()
+}
+
+// finished tells whether the client is done forwarding. The caller holds c.mu.
+func (c *LogClient) finished() bool {
+	return c.abandoned || c.closed && len(c.pending) == 0
+}
+
+// run forwards the pending frames, reconnecting until the client is finished.
+func (c *LogClient) run() {
+	defer close(c.done)
+	for {
+		err := c.forward()
+
+		c.mu.Lock()
+		finished := c.finished()
+		c.mu.Unlock()
+		if finished {
+			return
+		}
+		if err != nil {
+			time.Sleep(logRedialDelay)
+		}
+	}
+}
+
+// forward sends the pending frames over a new connection until it fails.
+func (c *LogClient) forward() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	hello := make([]byte, len(logMagic)+9)
+	copy(hello, logMagic)
+	hello[len(logMagic)] = LogProtocolVersion
+	binary.BigEndian.PutUint64(hello[len(logMagic)+1:], c.session)
+	if _, err := conn.Write(hello); err != nil {
+		return err
+	}
+	var reply [9]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != LogProtocolVersion {
+		return fmt.Errorf("unsupported log protocol version %d", reply[0])
+	}
+	c.mu.Lock()
+	c.sent = 0
+	c.mu.Unlock()
+	c.acknowledge(binary.BigEndian.Uint64(reply[1:]))
+
+	// Read the acknowledgements, waking the sender once the connection fails.
+	failed := make(chan struct{})
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			close(failed)
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		}()
+		r := bufio.NewReader(conn)
+		var ack [8]byte
+		for {
+			if _, err := io.ReadFull(r, ack[:]); err != nil {
+				return
+			}
+			c.acknowledge(binary.BigEndian.Uint64(ack[:]))
+		}
+	}()
+	defer func() {
+		conn.Close()
+		<-failed
+	}()
+
+	for {
+		c.mu.Lock()
+		for c.sent == len(c.pending) && !c.finished() && !isClosed(failed) {
+			c.cond.Wait()
+		}
+		if c.finished() {
+			c.mu.Unlock()
+			return nil
+		}
+		if isClosed(failed) {
+			c.mu.Unlock()
+			return fmt.Errorf("log connection lost")
+		}
+		frame := c.pending[c.sent]
+		c.sent++
+		dropped := c.dropped
+		c.dropped = 0
+		c.mu.Unlock()
+
+		if dropped > 0 {
+			log.L.Warnf("Dropped %d log frames the provider did not acknowledge in time", dropped)
+		}
+		if err := writeLogFrame(conn, frame); err != nil {
+			return err
+		}
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close flushes the pending frames, waiting for their acknowledgement up to
+// the timeout, and stops the client.
+func (c *LogClient) Close(timeout time.Duration) {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		c.mu.Lock()
+		c.abandoned = true
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+// LogReceiver receives the output of the workloads of enclaves on the host,
+// skipping the frames resent by agents which reconnected.
+type LogReceiver struct {
+	mu       sync.Mutex
+	sessions map[uint64]uint64
+}
+
+// NewLogReceiver creates a LogReceiver without sessions.
+func NewLogReceiver() *LogReceiver {
+	return &LogReceiver{sessions: make(map[uint64]uint64)}
+}
+
+// IsVersioned tells whether a log connection starting with the given byte
+// speaks a versioned log protocol, rather than sending raw output.
+func IsVersioned(first byte) bool {
+	return first == logMagic[0]
+}
+
+// Serve writes the frames received on a versioned log connection to w until
+// the connection fails. r reads from conn, and may have buffered its start.
+func (lr *LogReceiver) Serve(conn io.Writer, r io.Reader, w LogWriter) error {
+	hello := make([]byte, len(logMagic)+9)
+	if _, err := io.ReadFull(r, hello); err != nil {
+		return err
+	}
+	if string(hello[:len(logMagic)]) != logMagic {
+		return fmt.Errorf("invalid log protocol header")
+	}
+	// Clients speaking a later version are told which version to speak.
+	session := binary.BigEndian.Uint64(hello[len(logMagic)+1:])
+
+	lr.mu.Lock()
+	last := lr.sessions[session]
+	lr.mu.Unlock()
+	reply := make([]byte, 9)
+	reply[0] = LogProtocolVersion
+	binary.BigEndian.PutUint64(reply[1:], last)
+	if _, err := conn.Write(reply); err != nil {
+		return err
+	}
+	if hello[len(logMagic)] != LogProtocolVersion {
+		return fmt.Errorf("unsupported log protocol version %d", hello[len(logMagic)])
+	}
+
+	ack := make([]byte, 8)
+	for {
+		f, err := readLogFrame(r)
+		if err != nil {
+			return err
+		}
+		if f.seq > last {
+			if err := w.WriteLog(f.stream, f.data); err != nil {
+				log.L.Warnf("Failed to write log frame: %s", err)
+			}
+			last = f.seq
+			lr.mu.Lock()
+			lr.sessions[session] = last
+			lr.mu.Unlock()
+		}
+		binary.BigEndian.PutUint64(ack, f.seq)
+		if _, err := conn.Write(ack); err != nil {
+			return err
+		}
+	}
+}
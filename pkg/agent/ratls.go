@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/ratls"
+)
+
+const (
+	// RATLSDir is where the agent writes the attested TLS certificate of the
+	// enclave and its key, for the workload to serve TLS with.
+	RATLSDir      = "/run/ra-tls"
+	RATLSCertFile = "tls.crt"
+	RATLSKeyFile  = "tls.key"
+
+	// RATLSValidity is how long the attested certificate is valid. Its key
+	// lives as long as the enclave, whose image cannot change.
+	RATLSValidity = 365 * 24 * time.Hour
+)
+
+// WriteRATLSCertificate generates the key pair of the enclave and writes it
+// to dir in PEM, along a certificate for the names embedding an
+// attestation document of its public key.
+func WriteRATLSCertificate(dir string, names []string, attest ratls.Attester) (certFile, keyFile string, err error) {
+	cert, err := ratls.NewCertificate(attest, names, RATLSValidity)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	certFile, keyFile = filepath.Join(dir, RATLSCertFile), filepath.Join(dir, RATLSKeyFile)
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cms"
+	"github.com/hf/nsm/request"
+)
+
+const (
+	// SecretsPort is the vsock port on which the agent receives the secrets
+	// the provider releases to its enclave once attested.
+	SecretsPort = 5106
+	// SecretsDir is where the agent writes the released secrets, in a
+	// directory per Secret with a file per key.
+	SecretsDir = "/run/kms-secrets"
+
+	// secretsKeyBits is the size of the RSA key secrets are sealed to,
+	// the smallest KMS accepts.
+	secretsKeyBits = 2048
+)
+
+// SealedFile is a file whose content is sealed to the key of the enclave,
+// as the enveloped data of the KMS responses to attested requests.
+type SealedFile struct {
+	Path       string      `json:"path"`
+	Ciphertext []byte      `json:"ciphertext"`
+	Mode       os.FileMode `json:"mode,omitempty"`
+}
+
+// secretsChallenge asks the agent for an attestation document of the key the
+// secrets are sealed to.
+type secretsChallenge struct {
+	Nonce []byte `json:"nonce"`
+}
+
+// ReleaseSecrets releases secrets to the agent reachable over conn. The agent
+// generates a key pair and attests its public key along with the nonce, then
+// seal returns the files sealed to the key of the attestation document, which
+// only the agent can decrypt. It fails without releasing anything when seal
+// does.
+func ReleaseSecrets(conn net.Conn, nonce []byte, seal func(document []byte) ([]SealedFile, error), timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(secretsChallenge{Nonce: nonce}); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(conn)
+	var attested attestResult
+	if err := decoder.Decode(&attested); err != nil {
+		return fmt.Errorf("failed to read attestation document: %v", err)
+	}
+	if attested.Error != "" {
+		return errors.New(attested.Error)
+	}
+
+	files, err := seal(attested.Document)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(files); err != nil {
+		return err
+	}
+	var result filesResult
+	if err := decoder.Decode(&result); err != nil {
+		return fmt.Errorf("failed to read acknowledgement: %v", err)
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// SecretServer writes the secrets released by the provider, decrypting them
+// with a key which never leaves the enclave. It runs inside the enclave.
+type SecretServer struct {
+	// Root is the directory the paths of the files are in, / when empty.
+	Root string
+	// Attest returns an attestation document including the nonce and the
+	// public key, in DER, from the Nitro Secure Module when nil.
+	Attest func(nonce, publicKey []byte) ([]byte, error)
+
+	once  sync.Once
+	ready chan struct{}
+}
+
+// NewSecretServer creates a new SecretServer.
+func NewSecretServer() *SecretServer {
+	return &SecretServer{ready: make(chan struct{})}
+}
+
+// Ready is closed once the first secrets have been written.
+func (s *SecretServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Serve accepts secrets until the listener is closed.
+func (s *SecretServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SecretServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	var challenge secretsChallenge
+	if err := decoder.Decode(&challenge); err != nil {
+		return
+	}
+
+	// A key per release, so sealed secrets cannot be replayed to another.
+	var attested attestResult
+	key, err := rsa.GenerateKey(rand.Reader, secretsKeyBits)
+	if err == nil {
+		attested.Document, err = s.attest(challenge.Nonce, &key.PublicKey)
+	}
+	if err != nil {
+		attested.Error = err.Error()
+	}
+	if json.NewEncoder(conn).Encode(attested) != nil || err != nil {
+		return
+	}
+
+	var files []SealedFile
+	if err := decoder.Decode(&files); err != nil {
+		return
+	}
+	var result filesResult
+	for _, f := range files {
+		data, err := cms.Decrypt(f.Ciphertext, key)
+		if err == nil {
+			err = writeFile(s.Root, File{Path: f.Path, Data: data, Mode: f.Mode})
+		}
+		if err != nil {
+			result.Error = fmt.Sprintf("%s: %v", f.Path, err)
+			break
+		}
+	}
+	if result.Error == "" {
+		s.once.Do(func() { close(s.ready) })
+	}
+	json.NewEncoder(conn).Encode(result) //nolint:errcheck
+}
+
+// attest returns an attestation document of the public key and nonce.
+func (s *SecretServer) attest(nonce []byte, key *rsa.PublicKey) ([]byte, error) {
+	publicKey, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.Attest != nil {
+		return s.Attest(nonce, publicKey)
+	}
+	return nsmAttest(&request.Attestation{Nonce: nonce, PublicKey: publicKey})
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Streams multiplexed over an interactive exec connection. Every frame starts
+// with the stream it belongs to and the length of its payload.
+const (
+	StreamStdin byte = iota
+	StreamStdout
+	StreamStderr
+	// StreamResize frames carry a JSON encoded TermSize.
+	StreamResize
+	// StreamExit is the last frame of a session, it carries a JSON encoded ExecResult.
+	StreamExit
+)
+
+// maxFrameSize bounds the payload of a frame.
+const maxFrameSize = 1 << 20
+
+// TermSize is the size of the terminal of an interactive command.
+type TermSize struct {
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+}
+
+// WriteFrame writes a frame of the given stream to w. An empty stdin frame
+// closes the command's stdin.
+func WriteFrame(w io.Writer, stream byte, p []byte) error {
+	if len(p) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes is too large", len(p))
+	}
+	buf := make([]byte, 5+len(p))
+	buf[0] = stream
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(p)))
+	copy(buf[5:], p)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadFrame reads the next frame from r.
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	p := make([]byte, size)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return 0, nil, err
+	}
+	return header[0], p, nil
+}
+
+// frameWriter is an io.Writer producing frames of a single stream. Frame
+// writers of the same connection share a lock so frames do not interleave.
+type frameWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	stream byte
+}
+
+func (f frameWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	written := len(p)
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		if err := WriteFrame(f.w, f.stream, p[:n]); err != nil {
+			return 0, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ExecStreams are the streams of an interactive command. Unused streams are nil.
+type ExecStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	Resize <-chan TermSize
+}
+
+// ExecInteractive runs a command through the agent reachable over conn,
+// streaming its stdio until it exits.
+func ExecInteractive(conn net.Conn, req ExecRequest, streams ExecStreams) (*ExecResult, error) {
+	req.Interactive = true
+	req.Stdin = streams.Stdin != nil
+	return stream(conn, req, streams)
+}
+
+// Attach attaches to the stdio of the workload through the agent reachable
+// over conn, until the workload exits or the connection is closed.
+func Attach(conn net.Conn, streams ExecStreams) (*ExecResult, error) {
+	return stream(conn, AttachRequest{Stdin: streams.Stdin != nil}, streams)
+}
+
+// stream sends a request and copies the frames of the session from and to streams.
+func stream(conn net.Conn, req interface{}, streams ExecStreams) (*ExecResult, error) {
+	// Frames follow the request immediately, without the newline of json.Encoder.
+	p, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(p); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	done := make(chan struct{})
+	defer close(done)
+
+	if streams.Stdin != nil {
+		go func() {
+			io.Copy(frameWriter{&mu, conn, StreamStdin}, streams.Stdin) //nolint:errcheck
+			mu.Lock()
+			WriteFrame(conn, StreamStdin, nil) //nolint:errcheck
+			mu.Unlock()
+		}()
+	}
+	if streams.Resize != nil {
+		go func() {
+			for {
+				select {
+				case size, ok := <-streams.Resize:
+					if !ok {
+						return
+					}
+					p, _ := json.Marshal(size)
+					mu.Lock()
+					err := WriteFrame(conn, StreamResize, p)
+					mu.Unlock()
+					if err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	for {
+		stream, p, err := ReadFrame(conn)
+		if err != nil {
+			return nil, fmt.Errorf("session ended unexpectedly: %v", err)
+		}
+
+		switch stream {
+		case StreamStdout:
+			if streams.Stdout != nil {
+				streams.Stdout.Write(p) //nolint:errcheck
+			}
+		case StreamStderr:
+			if streams.Stderr != nil {
+				streams.Stderr.Write(p) //nolint:errcheck
+			}
+		case StreamExit:
+			var result ExecResult
+			if err := json.Unmarshal(p, &result); err != nil {
+				return nil, err
+			}
+			if result.Error != "" {
+				return nil, fmt.Errorf("%s", result.Error)
+			}
+			return &result, nil
+		}
+	}
+}
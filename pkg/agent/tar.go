@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// builtin is a command the agent runs itself rather than the enclave, for
+// the tools kubectl relies on which enclave images often lack. Paths are in
+// root, and the exit code is returned.
+type builtin func(root string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// lookupBuiltin returns the builtin running a command, nil when the enclave
+// runs it: the tar invocations of kubectl cp and the test -d checking its
+// destination. Other uses of tar and test run the tools of the enclave.
+func lookupBuiltin(command []string) builtin {
+	switch {
+	case len(command) == 3 && command[0] == "test" && command[1] == "-d":
+		return func(root string, stdin io.Reader, stdout, stderr io.Writer) int {
+			if info, err := os.Stat(filepath.Join("/", root, command[2])); err != nil || !info.IsDir() {
+				return 1
+			}
+			return 0
+		}
+	case len(command) > 0 && command[0] == "tar":
+		if opts, ok := parseTar(command[1:]); ok {
+			return opts.run
+		}
+	}
+	return nil
+}
+
+// tarOptions are the options of the tar invocations of kubectl cp, such as
+// "tar cf - /data" and "tar -xmf - -C /data".
+type tarOptions struct {
+	create, extract bool
+	// dir is the directory of -C, / when empty.
+	dir string
+	// keepTimes is false with -m, which extracts files with the current time.
+	keepTimes bool
+	// sameOwner and samePermissions are false with --no-same-owner and
+	// --no-same-permissions.
+	sameOwner, samePermissions bool
+	paths                      []string
+}
+
+// parseTar parses the arguments of tar, ok is false for the options which
+// are not those of kubectl cp.
+func parseTar(args []string) (opts *tarOptions, ok bool) {
+	opts = &tarOptions{keepTimes: true, sameOwner: true, samePermissions: true}
+	archive := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--no-same-owner":
+			opts.sameOwner = false
+		case arg == "--no-same-permissions":
+			opts.samePermissions = false
+		case arg == "-C":
+			if i++; i == len(args) {
+				return nil, false
+			}
+			opts.dir = args[i]
+		case strings.HasPrefix(arg, "--"):
+			return nil, false
+		case i == 0 || strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// Bundled letters, with a dash or, first, without.
+			for _, letter := range strings.TrimPrefix(arg, "-") {
+				switch letter {
+				case 'c':
+					opts.create = true
+				case 'x':
+					opts.extract = true
+				case 'm':
+					opts.keepTimes = false
+				case 'p':
+				case 'f':
+					if i++; i == len(args) {
+						return nil, false
+					}
+					archive = args[i]
+				default:
+					return nil, false
+				}
+			}
+		default:
+			opts.paths = append(opts.paths, arg)
+		}
+	}
+	if archive != "-" || opts.create == opts.extract {
+		return nil, false
+	}
+	if opts.create && len(opts.paths) == 0 || opts.extract && len(opts.paths) > 0 {
+		return nil, false
+	}
+	return opts, true
+}
+
+// run runs tar, reading or writing the archive from stdin or to stdout.
+func (opts *tarOptions) run(root string, stdin io.Reader, stdout, stderr io.Writer) int {
+	dir := filepath.Join("/", root, opts.dir)
+	if opts.create {
+		return opts.createArchive(dir, stdout, stderr)
+	}
+	return opts.extractArchive(dir, stdin, stderr)
+}
+
+// createArchive writes the paths, relative to dir, to an archive. Like GNU
+// tar, the members of absolute paths are named without their leading slash.
+func (opts *tarOptions) createArchive(dir string, stdout, stderr io.Writer) int {
+	code := 0
+	failed := func(name string, err error) {
+		fmt.Fprintf(stderr, "tar: %s: %v\n", name, err)
+		code = 2
+	}
+
+	w := tar.NewWriter(stdout)
+	for _, path := range opts.paths {
+		base := strings.TrimLeft(filepath.Clean(path), "/")
+		top := filepath.Join(dir, path)
+		err := filepath.Walk(top, func(file string, info os.FileInfo, err error) error {
+			rel, _ := filepath.Rel(top, file)
+			name := filepath.Join(base, rel)
+			if err != nil {
+				failed(name, err)
+				return nil
+			}
+
+			link := ""
+			if info.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(file); err != nil {
+					failed(name, err)
+					return nil
+				}
+			}
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				failed(name, err)
+				return nil
+			}
+			header.Name = name
+			if info.IsDir() {
+				header.Name += "/"
+			}
+			if err := w.WriteHeader(header); err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := os.Open(file)
+			if err != nil {
+				// The header promised the content, the archive is unusable.
+				return err
+			}
+			defer f.Close()
+			_, err = io.CopyN(w, f, header.Size)
+			return err
+		})
+		if err != nil {
+			failed(path, err)
+			return code
+		}
+	}
+	if err := w.Close(); err != nil {
+		failed("-", err)
+	}
+	return code
+}
+
+// extractArchive extracts an archive in dir. The members are confined to dir
+// like kubectl confines those it extracts, without guarding against symbolic
+// links since users who exec in the enclave can write anywhere already.
+func (opts *tarOptions) extractArchive(dir string, stdin io.Reader, stderr io.Writer) int {
+	code := 0
+	failed := func(name string, err error) {
+		fmt.Fprintf(stderr, "tar: %s: %v\n", name, err)
+		code = 2
+	}
+
+	r := tar.NewReader(stdin)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return code
+		}
+		if err != nil {
+			failed("-", err)
+			return code
+		}
+
+		target := filepath.Join(dir, filepath.Clean("/"+header.Name))
+		mode := header.FileInfo().Mode().Perm()
+		if !opts.samePermissions {
+			mode &^= 022
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			failed(header.Name, err)
+			continue
+		}
+		if header.Typeflag != tar.TypeDir {
+			// Existing files are replaced rather than written through.
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				failed(header.Name, err)
+				continue
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode)
+			if err == nil {
+				err = os.Chmod(target, mode)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(target, r, mode)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+		case tar.TypeLink:
+			err = os.Link(filepath.Join(dir, filepath.Clean("/"+header.Linkname)), target)
+		default:
+			fmt.Fprintf(stderr, "tar: %s: skipping unsupported member type %q\n", header.Name, header.Typeflag)
+			continue
+		}
+		if err != nil {
+			failed(header.Name, err)
+			continue
+		}
+
+		if opts.sameOwner {
+			os.Lchown(target, header.Uid, header.Gid) //nolint:errcheck
+		}
+		if opts.keepTimes && header.Typeflag != tar.TypeSymlink {
+			if err := os.Chtimes(target, time.Now(), header.ModTime); err != nil {
+				failed(header.Name, err)
+			}
+		}
+	}
+}
+
+// extractFile writes the content of a regular file member to path.
+func extractFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// The mode of new files is restricted by the umask.
+		err = os.Chmod(path, mode)
+	}
+	return err
+}
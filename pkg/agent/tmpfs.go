@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// TmpfsMount is a memory backed file system mounted by the agent before it
+// starts the workload, as passed to its -tmpfs flag.
+type TmpfsMount struct {
+	Path    string
+	SizeMiB int64
+}
+
+// String formats the mount as the agent's -tmpfs flag value, path:sizeMiB.
+func (m TmpfsMount) String() string {
+	return fmt.Sprintf("%s:%d", m.Path, m.SizeMiB)
+}
+
+// ParseTmpfsMount parses a -tmpfs flag value.
+func ParseTmpfsMount(s string) (TmpfsMount, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return TmpfsMount{}, fmt.Errorf("invalid tmpfs mount %q, expected path:sizeMiB", s)
+	}
+	size, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil || size <= 0 {
+		return TmpfsMount{}, fmt.Errorf("invalid size of tmpfs mount %q", s)
+	}
+	path := s[:i]
+	if !filepath.IsAbs(path) {
+		return TmpfsMount{}, fmt.Errorf("tmpfs mount path %q is not absolute", path)
+	}
+	return TmpfsMount{Path: filepath.Clean(path), SizeMiB: size}, nil
+}
+
+// Mount mounts the tmpfs, creating its mount point if needed.
+func (m TmpfsMount) Mount() error {
+	if err := os.MkdirAll(m.Path, 0755); err != nil {
+		return err
+	}
+	return unix.Mount("tmpfs", m.Path, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("size=%dm,mode=1777", m.SizeMiB))
+}
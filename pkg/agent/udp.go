@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+const (
+	// UDPRelayPort is the vsock port on which the agent relays UDP datagrams
+	// to the workload. Vsock only supports streams, so every UDP client of a
+	// port is relayed as its own connection carrying length-prefixed datagrams.
+	UDPRelayPort = 5100
+
+	// MaxDatagramSize is the largest UDP payload which can be relayed.
+	MaxDatagramSize = 65535
+)
+
+// WriteUDPRelayHeader starts a relay connection to the given container port.
+func WriteUDPRelayHeader(w io.Writer, port uint16) error {
+	return binary.Write(w, binary.BigEndian, port)
+}
+
+// ReadUDPRelayHeader returns the container port a relay connection is for.
+func ReadUDPRelayHeader(r io.Reader) (uint16, error) {
+	var port uint16
+	err := binary.Read(r, binary.BigEndian, &port)
+	return port, err
+}
+
+// WriteDatagram writes a length-prefixed datagram to w.
+func WriteDatagram(w io.Writer, p []byte) error {
+	if len(p) > MaxDatagramSize {
+		return fmt.Errorf("datagram of %d bytes is too large", len(p))
+	}
+	buf := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadDatagram reads a length-prefixed datagram into buf and returns its size.
+// buf must be able to hold MaxDatagramSize bytes.
+func ReadDatagram(r io.Reader, buf []byte) (int, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, err
+	}
+	if int(size) > len(buf) {
+		return 0, fmt.Errorf("datagram of %d bytes does not fit in buffer", size)
+	}
+	return io.ReadFull(r, buf[:size])
+}
+
+// UDPRelay forwards datagrams received from the provider to UDP ports of the
+// workload, and relays the replies back. It runs inside the enclave.
+type UDPRelay struct{}
+
+// Serve accepts relay connections until the listener is closed.
+func (UDPRelay) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go relayUDP(conn)
+	}
+}
+
+func relayUDP(conn net.Conn) {
+	defer conn.Close()
+
+	port, err := ReadUDPRelayHeader(conn)
+	if err != nil {
+		return
+	}
+	udp, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		log.L.Warnf("Failed to dial udp port %d: %s", port, err)
+		return
+	}
+	defer udp.Close()
+
+	// Replies from the workload.
+	go func() {
+		defer conn.Close()
+
+		buf := make([]byte, MaxDatagramSize)
+		for {
+			n, err := udp.Read(buf)
+			if err != nil {
+				return
+			}
+			if err := WriteDatagram(conn, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, err := ReadDatagram(conn, buf)
+		if err != nil {
+			return
+		}
+		if _, err := udp.Write(buf[:n]); err != nil {
+			log.L.Warnf("Failed to write to udp port %d: %s", port, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// VaultPortBase is added to an enclave's CID to get the host vsock port
+	// forwarded to the Vault server of that enclave's pod.
+	VaultPortBase = 16000
+
+	// VaultTokenFile is where the agent keeps the Vault token it logged in
+	// with, renewed until the enclave terminates, as the file sink of Vault Agent does.
+	VaultTokenFile = "/run/vault/token"
+
+	// VaultAuthKubernetes logs in with the token of the pod's service
+	// account to the Kubernetes auth method.
+	VaultAuthKubernetes = "kubernetes"
+	// VaultAuthAttestation logs in with an attestation document of the
+	// enclave to an auth plugin verifying Nitro attestation documents.
+	VaultAuthAttestation = "attestation"
+
+	// ServiceAccountTokenFile is where the token of the pod's service
+	// account is mounted.
+	ServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// vaultRetryInterval is how long to wait before logging in again after a failure.
+	vaultRetryInterval = 30 * time.Second
+	// maxVaultResponseSize bounds the responses of Vault read.
+	maxVaultResponseSize = 1 << 20
+)
+
+// VaultPort returns the host vsock port forwarded to Vault for the enclave with the given CID.
+func VaultPort(cid uint32) uint32 {
+	return VaultPortBase + cid
+}
+
+// VaultToken is a token of Vault.
+type VaultToken struct {
+	Token         string
+	LeaseDuration time.Duration
+	Renewable     bool
+}
+
+// VaultLogin logs the enclave into Vault and keeps its token fresh. It runs
+// inside the enclave.
+type VaultLogin struct {
+	// Address is the URL of Vault.
+	Address string
+	// Client sends the requests to Vault, http.DefaultClient when nil.
+	Client *http.Client
+	// Method is VaultAuthKubernetes or VaultAuthAttestation, and Path the
+	// mount path of the auth method, Method when empty.
+	Method string
+	Path   string
+	// Role is the role of the auth method to log in as.
+	Role string
+	// JWTFile is the service account token of the Kubernetes auth method,
+	// ServiceAccountTokenFile when empty.
+	JWTFile string
+	// Attest returns an attestation document of the enclave, for the
+	// attestation auth method.
+	Attest func() ([]byte, error)
+	// TokenFile is where the token is written.
+	TokenFile string
+}
+
+// vaultResponse is the response of the login and renewal requests.
+type vaultResponse struct {
+	Auth *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// Login logs into Vault and writes the token to the token file.
+func (v *VaultLogin) Login(ctx context.Context) (*VaultToken, error) {
+	body := map[string]string{"role": v.Role}
+	switch v.Method {
+	case VaultAuthKubernetes:
+		file := v.JWTFile
+		if file == "" {
+			file = ServiceAccountTokenFile
+		}
+		jwt, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %v", err)
+		}
+		body["jwt"] = strings.TrimSpace(string(jwt))
+	case VaultAuthAttestation:
+		if v.Attest == nil {
+			return nil, errors.New("no attestation document available")
+		}
+		doc, err := v.Attest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to attest enclave: %v", err)
+		}
+		body["attestation_document"] = base64.StdEncoding.EncodeToString(doc)
+	default:
+		return nil, fmt.Errorf("unsupported Vault auth method %q", v.Method)
+	}
+	path := v.Path
+	if path == "" {
+		path = v.Method
+	}
+	token, err := v.call(ctx, "/v1/auth/"+strings.Trim(path, "/")+"/login", "", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log into Vault: %v", err)
+	}
+	return token, v.write(token)
+}
+
+// Renew renews the token and writes it to the token file.
+func (v *VaultLogin) Renew(ctx context.Context, token *VaultToken) (*VaultToken, error) {
+	renewed, err := v.call(ctx, "/v1/auth/token/renew-self", token.Token, map[string]string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew Vault token: %v", err)
+	}
+	return renewed, v.write(renewed)
+}
+
+// Refresh keeps the token fresh until ctx is done, renewing it after two
+// thirds of its lease, and logging in again once it cannot be renewed.
+func (v *VaultLogin) Refresh(ctx context.Context, token *VaultToken, logf func(format string, args ...interface{})) {
+	for {
+		wait := vaultRetryInterval
+		if token != nil && token.LeaseDuration > 0 {
+			wait = token.LeaseDuration * 2 / 3
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		// Tokens reaching their maximum TTL are renewed for less and less,
+		// a new one is logged in for then.
+		if token != nil && token.Renewable {
+			renewed, err := v.Renew(ctx, token)
+			if err == nil && renewed.LeaseDuration >= vaultRetryInterval {
+				token = renewed
+				continue
+			}
+			if err != nil {
+				logf("%v", err)
+			}
+		}
+		var err error
+		if token, err = v.Login(ctx); err != nil {
+			logf("%v", err)
+		}
+	}
+}
+
+func (v *VaultLogin) call(ctx context.Context, path, token string, body interface{}) (*VaultToken, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(v.Address, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxVaultResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var result vaultResponse
+	if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(result.Errors, "; "))
+		}
+		return nil, errors.New(resp.Status)
+	}
+	if result.Auth == nil || result.Auth.ClientToken == "" {
+		return nil, errors.New("no token in the response of Vault")
+	}
+	return &VaultToken{
+		Token:         result.Auth.ClientToken,
+		LeaseDuration: time.Duration(result.Auth.LeaseDuration) * time.Second,
+		Renewable:     result.Auth.Renewable,
+	}, nil
+}
+
+// write atomically replaces the token file with the token.
+func (v *VaultLogin) write(token *VaultToken) error {
+	if err := os.MkdirAll(filepath.Dir(v.TokenFile), 0700); err != nil {
+		return err
+	}
+	return writeFile("", File{Path: v.TokenFile, Data: []byte(token.Token), Mode: 0600})
+}
@@ -0,0 +1,201 @@
+package allocator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultConfigPath is where the nitro-enclaves-allocator service reads its configuration.
+	DefaultConfigPath = "/etc/nitro_enclaves/allocator.yaml"
+	// DefaultHugePagesPath is the sysfs directory containing the hugepage pools.
+	DefaultHugePagesPath = "/sys/kernel/mm/hugepages"
+	// DefaultCPUPoolPath is the sysfs parameter holding the CPUs offlined for enclaves.
+	DefaultCPUPoolPath = "/sys/module/nitro_enclaves/parameters/ne_cpus"
+
+	MiB int64 = 1024
+)
+
+// Config is the contents of the allocator configuration file.
+type Config struct {
+	// MemoryMib is the amount of memory reserved for enclaves.
+	MemoryMib int64 `json:"memory_mib"`
+	// CPUCount is the number of CPUs reserved for enclaves.
+	CPUCount int64 `json:"cpu_count,omitempty"`
+	// CPUPool is an explicit list of CPUs reserved for enclaves, e.g. "2,3,6-9".
+	// It conflicts with CPUCount.
+	CPUPool string `json:"cpu_pool,omitempty"`
+}
+
+// ReadConfig parses the allocator configuration file at path.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := new(Config)
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if config.CPUCount != 0 && config.CPUPool != "" {
+		return nil, fmt.Errorf("invalid %s: cpu_count and cpu_pool are mutually exclusive", path)
+	}
+	return config, nil
+}
+
+// CPUs returns the number of CPUs reserved by this configuration.
+func (c *Config) CPUs() (int64, error) {
+	if c.CPUPool == "" {
+		return c.CPUCount, nil
+	}
+	cpus, err := ParseCPUList(c.CPUPool)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(cpus)), nil
+}
+
+// ParseCPUList parses a kernel style CPU list such as "1,3,6-9".
+func ParseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		lo, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu list %q: %v", s, err)
+		}
+		hi := lo
+		if len(bounds) == 2 {
+			hi, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu list %q: %v", s, err)
+			}
+		}
+		if hi < lo {
+			return nil, fmt.Errorf("invalid cpu list %q: range %d-%d is reversed", s, lo, hi)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// HugePagePool describes one of the kernel hugepage pools.
+type HugePagePool struct {
+	// PageSizeKiB is the size of a single page in the pool.
+	PageSizeKiB int64
+	// Total is the number of pages in the pool.
+	Total int64
+	// Free is the number of pages in the pool which are not in use.
+	Free int64
+}
+
+// TotalMiB returns the size of the pool in MiB.
+func (p HugePagePool) TotalMiB() int64 {
+	return p.Total * p.PageSizeKiB / MiB
+}
+
+// FreeMiB returns the unused size of the pool in MiB.
+func (p HugePagePool) FreeMiB() int64 {
+	return p.Free * p.PageSizeKiB / MiB
+}
+
+// ReadHugePagePools returns the hugepage pools found under root, ordered by page size.
+func ReadHugePagePools(root string) ([]HugePagePool, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var pools []HugePagePool
+	for _, entry := range entries {
+		// Directories are named hugepages-<size>kB
+		name := entry.Name()
+		if !strings.HasPrefix(name, "hugepages-") || !strings.HasSuffix(name, "kB") {
+			continue
+		}
+		size, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, "hugepages-"), "kB"), 10, 64)
+		if err != nil {
+			continue
+		}
+		total, err := readInt(filepath.Join(root, name, "nr_hugepages"))
+		if err != nil {
+			return nil, err
+		}
+		free, err := readInt(filepath.Join(root, name, "free_hugepages"))
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, HugePagePool{PageSizeKiB: size, Total: total, Free: free})
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].PageSizeKiB < pools[j].PageSizeKiB })
+
+	return pools, nil
+}
+
+// Capacity is the enclave capacity of the host.
+type Capacity struct {
+	// CPUs is the number of CPUs available to enclaves.
+	CPUs int64
+	// MemoryMib is the amount of hugepage memory available to enclaves.
+	MemoryMib int64
+	// FreeMemoryMib is the amount of hugepage memory not used by running enclaves.
+	FreeMemoryMib int64
+}
+
+// ReadCapacity derives the enclave capacity of the host from the allocator
+// configuration, the CPU pool and the hugepage pools.
+func ReadCapacity() (*Capacity, error) {
+	return readCapacity(DefaultConfigPath, DefaultCPUPoolPath, DefaultHugePagesPath)
+}
+
+func readCapacity(configPath, cpuPoolPath, hugePagesPath string) (*Capacity, error) {
+	capacity := new(Capacity)
+
+	// The driver's CPU pool is authoritative once the allocator has run,
+	// fall back to the configuration otherwise.
+	if data, err := os.ReadFile(cpuPoolPath); err == nil && strings.TrimSpace(string(data)) != "" {
+		cpus, err := ParseCPUList(string(data))
+		if err != nil {
+			return nil, err
+		}
+		capacity.CPUs = int64(len(cpus))
+	} else {
+		config, err := ReadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if capacity.CPUs, err = config.CPUs(); err != nil {
+			return nil, err
+		}
+	}
+
+	pools, err := ReadHugePagePools(hugePagesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, pool := range pools {
+		capacity.MemoryMib += pool.TotalMiB()
+		capacity.FreeMemoryMib += pool.FreeMiB()
+	}
+
+	return capacity, nil
+}
+
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,103 @@
+package allocator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0644))
+}
+
+func TestReadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "allocator.yaml")
+	writeFile(t, path, `---
+# How much memory to allocate for enclaves (in MiB).
+memory_mib: 1600
+cpu_pool: 2,3,6-9
+`)
+
+	config, err := ReadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, Config{MemoryMib: 1600, CPUPool: "2,3,6-9"}, *config)
+
+	cpus, err := config.CPUs()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), cpus)
+
+	writeFile(t, path, "memory_mib: 512\ncpu_count: 2\ncpu_pool: 1,3\n")
+	_, err = ReadConfig(path)
+	assert.NotNil(t, err)
+}
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := ParseCPUList("1,3,6-9\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 3, 6, 7, 8, 9}, cpus)
+
+	_, err = ParseCPUList("9-6")
+	assert.NotNil(t, err)
+}
+
+func TestReadCapacity(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "allocator.yaml"), "memory_mib: 2048\ncpu_count: 2\n")
+	writeFile(t, filepath.Join(dir, "hugepages", "hugepages-2048kB", "nr_hugepages"), "512\n")
+	writeFile(t, filepath.Join(dir, "hugepages", "hugepages-2048kB", "free_hugepages"), "256\n")
+	writeFile(t, filepath.Join(dir, "hugepages", "hugepages-1048576kB", "nr_hugepages"), "1\n")
+	writeFile(t, filepath.Join(dir, "hugepages", "hugepages-1048576kB", "free_hugepages"), "0\n")
+
+	// Without a CPU pool the configuration is used.
+	capacity, err := readCapacity(filepath.Join(dir, "allocator.yaml"), filepath.Join(dir, "ne_cpus"), filepath.Join(dir, "hugepages"))
+	assert.Nil(t, err)
+	assert.Equal(t, Capacity{CPUs: 2, MemoryMib: 2048, FreeMemoryMib: 512}, *capacity)
+
+	writeFile(t, filepath.Join(dir, "ne_cpus"), "1,3,5,7\n")
+	capacity, err = readCapacity(filepath.Join(dir, "allocator.yaml"), filepath.Join(dir, "ne_cpus"), filepath.Join(dir, "hugepages"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), capacity.CPUs)
+}
+
+func TestResize(t *testing.T) {
+	restart := restartService
+	defer func() { restartService, listEnclaves = restart, cli.DescribeEnclaves }()
+
+	restarts := 0
+	restartService = func(context.Context) error {
+		restarts++
+		return nil
+	}
+	var enclaves []cli.EnclaveInfo
+	listEnclaves = func() ([]cli.EnclaveInfo, error) {
+		return enclaves, nil
+	}
+
+	path := filepath.Join(t.TempDir(), "allocator.yaml")
+	writeFile(t, path, "memory_mib: 512\ncpu_count: 2\n")
+
+	// Unchanged configuration does not restart the allocator.
+	changed, err := Resize(context.Background(), path, Config{MemoryMib: 512, CPUCount: 2})
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 0, restarts)
+
+	changed, err = Resize(context.Background(), path, Config{MemoryMib: 4096, CPUPool: "1,3"})
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 1, restarts)
+
+	config, err := ReadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, Config{MemoryMib: 4096, CPUPool: "1,3"}, *config)
+
+	enclaves = []cli.EnclaveInfo{{EnclaveID: "enc-1"}}
+	_, err = Resize(context.Background(), path, Config{MemoryMib: 512, CPUCount: 2})
+	assert.ErrorIs(t, err, ErrEnclavesRunning)
+}
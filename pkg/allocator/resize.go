@@ -0,0 +1,102 @@
+package allocator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"sigs.k8s.io/yaml"
+)
+
+// ServiceName is the systemd unit which reserves the enclave memory and CPU pool.
+const ServiceName = "nitro-enclaves-allocator.service"
+
+// ErrEnclavesRunning is returned when the pool cannot be resized because it is in use.
+var ErrEnclavesRunning = errors.New("cannot resize the enclave pool while enclaves are running")
+
+// restartService is swapped out in tests.
+var restartService = func(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, "systemctl", "restart", ServiceName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to restart %s: %v: %s", ServiceName, err, out)
+	}
+	return nil
+}
+
+// ServiceFailed tells whether the allocator service failed, leaving the
+// enclave pool unreserved or partially reserved.
+func ServiceFailed(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", "is-active", ServiceName).Output()
+	state := strings.TrimSpace(string(out))
+	if err != nil && state == "" {
+		return false, fmt.Errorf("failed to query %s: %v", ServiceName, err)
+	}
+	return state == "failed", nil
+}
+
+// listEnclaves is swapped out in tests.
+var listEnclaves = cli.DescribeEnclaves
+
+// WriteConfig atomically replaces the allocator configuration file at path.
+func WriteConfig(path string, config *Config) error {
+	if config.CPUCount != 0 && config.CPUPool != "" {
+		return fmt.Errorf("cpu_count and cpu_pool are mutually exclusive")
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".allocator")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Resize rewrites the allocator configuration at path and restarts the
+// allocator service so the new pool size takes effect. It is a no-op when
+// the configuration already matches. The pool cannot be resized while any
+// enclave is running since the allocator has to release it first.
+func Resize(ctx context.Context, path string, config Config) (bool, error) {
+	current, err := ReadConfig(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if current != nil && *current == config {
+		return false, nil
+	}
+
+	enclaves, err := listEnclaves()
+	if err != nil {
+		return false, err
+	}
+	if len(enclaves) > 0 {
+		return false, ErrEnclavesRunning
+	}
+
+	if err := WriteConfig(path, &config); err != nil {
+		return false, err
+	}
+	if err := restartService(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
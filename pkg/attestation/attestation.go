@@ -0,0 +1,67 @@
+// Package attestation decodes and verifies the attestation documents which
+// the Nitro Secure Module signs for enclaves.
+package attestation
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Document is the payload of an attestation document.
+type Document struct {
+	ModuleID    string          `cbor:"module_id"`
+	Digest      string          `cbor:"digest"`
+	Timestamp   uint64          `cbor:"timestamp"`
+	PCRs        map[uint][]byte `cbor:"pcrs"`
+	Certificate []byte          `cbor:"certificate"`
+	CABundle    [][]byte        `cbor:"cabundle"`
+	PublicKey   []byte          `cbor:"public_key,omitempty"`
+	UserData    []byte          `cbor:"user_data,omitempty"`
+	Nonce       []byte          `cbor:"nonce,omitempty"`
+}
+
+// coseSign1 is the COSE_Sign1 structure wrapping an attestation document.
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected cbor.RawMessage
+	Payload     []byte
+	Signature   []byte
+}
+
+// Parse decodes an attestation document. Its signature is not verified,
+// see Verify.
+func Parse(data []byte) (*Document, error) {
+	_, doc, err := decode(data)
+	return doc, err
+}
+
+// decode decodes an attestation document and the message wrapping it.
+func decode(data []byte) (*coseSign1, *Document, error) {
+	var msg coseSign1
+	if err := cbor.Unmarshal(data, &msg); err != nil {
+		return nil, nil, fmt.Errorf("invalid attestation document: %v", err)
+	}
+	var doc Document
+	if err := cbor.Unmarshal(msg.Payload, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid attestation document payload: %v", err)
+	}
+	if doc.ModuleID == "" || len(doc.PCRs) == 0 {
+		return nil, nil, fmt.Errorf("invalid attestation document: missing module ID or PCRs")
+	}
+	return &msg, &doc, nil
+}
+
+// Time returns when the document was signed.
+func (d *Document) Time() time.Time {
+	return time.UnixMilli(int64(d.Timestamp)).UTC()
+}
+
+// PCR returns a platform configuration register as a hex string, which is
+// empty when the document lacks it.
+func (d *Document) PCR(index uint) string {
+	return hex.EncodeToString(d.PCRs[index])
+}
@@ -0,0 +1,35 @@
+package attestation
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	payload, err := cbor.Marshal(Document{
+		ModuleID:  "i-0123456789abcdef0-enc0123456789abcdef",
+		Digest:    "SHA384",
+		Timestamp: 1700000000123,
+		PCRs:      map[uint][]byte{0: bytes.Repeat([]byte{0xab}, 48), 8: make([]byte, 48)},
+		Nonce:     []byte("nonce"),
+	})
+	assert.Nil(t, err)
+	// Documents are tagged as COSE_Sign1 messages.
+	data, err := cbor.Marshal(cbor.Tag{Number: 18, Content: []interface{}{[]byte{}, map[string]string{}, payload, []byte("signature")}})
+	assert.Nil(t, err)
+
+	doc, err := Parse(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "i-0123456789abcdef0-enc0123456789abcdef", doc.ModuleID)
+	assert.Equal(t, time.Date(2023, 11, 14, 22, 13, 20, 123000000, time.UTC), doc.Time())
+	assert.Equal(t, "abababababababababababababababababababababababababababababababababababababababababababababababab", doc.PCR(0))
+	assert.Equal(t, "", doc.PCR(1))
+	assert.Equal(t, []byte("nonce"), doc.Nonce)
+
+	_, err = Parse([]byte("not cbor"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,127 @@
+// Package attestationtest signs attestation documents like a Nitro Secure
+// Module, with certificates of a test root, for the tests of verifiers.
+package attestationtest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Module is a fake Nitro Secure Module.
+type Module struct {
+	// ModuleID and PCRs are those of the documents of the module.
+	ModuleID string
+	PCRs     map[uint][]byte
+
+	root, intermediate, cert *x509.Certificate
+	key                      *ecdsa.PrivateKey
+}
+
+// NewModule returns a module whose certificates are valid for an hour, with
+// PCR0 to 2 set to distinct values.
+func NewModule() (*Module, error) {
+	root, rootKey, err := newCertificate("root", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	intermediate, intermediateKey, err := newCertificate("intermediate", root, rootKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, key, err := newCertificate("module", intermediate, intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Module{
+		ModuleID: "i-0123456789abcdef0-enc0123456789abcdef",
+		PCRs: map[uint][]byte{
+			0: bytes.Repeat([]byte{0x00}, 48),
+			1: bytes.Repeat([]byte{0x01}, 48),
+			2: bytes.Repeat([]byte{0x02}, 48),
+		},
+		root:         root,
+		intermediate: intermediate,
+		cert:         cert,
+		key:          key,
+	}, nil
+}
+
+// Roots returns a pool of the root certificate of the module, to verify its documents with.
+func (m *Module) Roots() *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(m.root)
+	return roots
+}
+
+// Attest returns a signed attestation document including the nonce, user
+// data and public key.
+func (m *Module) Attest(nonce, userData, publicKey []byte) ([]byte, error) {
+	payload, err := cbor.Marshal(attestation.Document{
+		ModuleID:    m.ModuleID,
+		Digest:      "SHA384",
+		Timestamp:   uint64(time.Now().UnixMilli()),
+		PCRs:        m.PCRs,
+		Certificate: m.cert.Raw,
+		CABundle:    [][]byte{m.root.Raw, m.intermediate.Raw},
+		PublicKey:   publicKey,
+		UserData:    userData,
+		Nonce:       nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The protected header selects ES384.
+	protected, err := cbor.Marshal(map[int]int{1: -35})
+	if err != nil {
+		return nil, err
+	}
+	toBeSigned, err := cbor.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha512.Sum384(toBeSigned)
+	r, s, err := ecdsa.Sign(rand.Reader, m.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 96)
+	r.FillBytes(signature[:48])
+	s.FillBytes(signature[48:])
+	return cbor.Marshal([]interface{}{protected, map[string]string{}, payload, signature})
+}
+
+// newCertificate issues a P-384 certificate, self-signed without a parent.
+func newCertificate(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  name != "module",
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
@@ -0,0 +1,188 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// The Nitro Secure Module signs documents with ECDSA over P-384 and
+	// SHA-384, the ES384 algorithm of the protected header of COSE_Sign1.
+	coseHeaderAlgorithm = 1
+	coseAlgorithmES384  = -35
+	digestSHA384        = "SHA384"
+
+	// awsNitroRootPEM is the root certificate of AWS Nitro Enclaves, whose
+	// SHA-256 fingerprint is
+	// 641A0321A3E244EFE456463195D606317ED7CDCC3C1756E09893F3C68F79BB5B.
+	awsNitroRootPEM = `-----BEGIN CERTIFICATE-----
+MIICETCCAZagAwIBAgIRAPkxdWgbkK/hHUbMtOTn+FYwCgYIKoZIzj0EAwMwSTEL
+MAkGA1UEBhMCVVMxDzANBgNVBAoMBkFtYXpvbjEMMAoGA1UECwwDQVdTMRswGQYD
+VQQDDBJhd3Mubml0cm8tZW5jbGF2ZXMwHhcNMTkxMDI4MTMyODA1WhcNNDkxMDI4
+MTQyODA1WjBJMQswCQYDVQQGEwJVUzEPMA0GA1UECgwGQW1hem9uMQwwCgYDVQQL
+DANBV1MxGzAZBgNVBAMMEmF3cy5uaXRyby1lbmNsYXZlczB2MBAGByqGSM49AgEG
+BSuBBAAiA2IABPwCVOumCMHzaHDimtqQvkY4MpJzbolL//Zy2YlES1BR5TSksfbb
+48C8WBoyt7F2Bw7eEtaaP+ohG2bnUs990d0JX28TcPQXCEPZ3BABIeTPYwEoCWZE
+h8l5YoQwTcU/9KNCMEAwDwYDVR0TAQH/BAUwAwEB/zAdBgNVHQ4EFgQUkCW1DdkF
+R+eWw5b6cp3PmanfS5YwDgYDVR0PAQH/BAQDAgGGMAoGCCqGSM49BAMDA2kAMGYC
+MQCjfy+Rocm9Xue4YnwWmNJVA44fA0P5W2OpYow9OYCVRaEevL8uO1XYru5xtMPW
+rfMCMQCi85sWBbJwKKXdS6BptQFuZbT73o/gBh1qUxl/nNr12UO8Yfwr6wPLb+6N
+IwLz3/Y=
+-----END CERTIFICATE-----`
+)
+
+// AWSNitroRoots returns a pool of the root certificate of AWS Nitro
+// Enclaves, which every genuine attestation document chains to.
+func AWSNitroRoots() *x509.CertPool {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(awsNitroRootPEM)) {
+		panic("invalid AWS Nitro Enclaves root certificate")
+	}
+	return roots
+}
+
+// VerifyOptions are the expectations of Verify besides a valid signature.
+type VerifyOptions struct {
+	// Roots are the trusted root certificates, AWSNitroRoots when nil.
+	Roots *x509.CertPool
+	// CurrentTime is when the certificates must be valid, now when zero.
+	CurrentTime time.Time
+	// Nonce is the nonce the document must include, when not empty.
+	Nonce []byte
+	// PCRs are the values, in hex, the document must have for the
+	// platform configuration registers of their index.
+	PCRs map[uint]string
+}
+
+// Verify decodes an attestation document, checks that it is signed by a
+// Nitro Secure Module certified by a trusted root, and that it has the
+// nonce and PCRs of the options.
+func Verify(data []byte, opts VerifyOptions) (*Document, error) {
+	msg, doc, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Digest != digestSHA384 {
+		return nil, fmt.Errorf("unsupported attestation document digest %q", doc.Digest)
+	}
+
+	roots := opts.Roots
+	if roots == nil {
+		roots = AWSNitroRoots()
+	}
+	currentTime := opts.CurrentTime
+	if currentTime.IsZero() {
+		currentTime = time.Now()
+	}
+	cert, err := doc.verifyCertificate(roots, currentTime)
+	if err != nil {
+		return nil, err
+	}
+	if err := msg.verifySignature(cert); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Nonce) > 0 && !bytes.Equal(doc.Nonce, opts.Nonce) {
+		return nil, fmt.Errorf("attestation document does not include the nonce")
+	}
+	indexes := make([]uint, 0, len(opts.PCRs))
+	for index := range opts.PCRs {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for _, index := range indexes {
+		if expected, actual := opts.PCRs[index], doc.PCR(index); !strings.EqualFold(expected, actual) {
+			return nil, fmt.Errorf("attestation document PCR%d is %s, expected %s", index, actual, expected)
+		}
+	}
+	return doc, nil
+}
+
+// verifyCertificate returns the certificate of the module which signed the
+// document, once chained through its CA bundle to one of the roots.
+func (d *Document) verifyCertificate(roots *x509.CertPool, currentTime time.Time) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(d.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation document certificate: %v", err)
+	}
+	// The bundle starts with the root, the chain is built from the pool.
+	intermediates := x509.NewCertPool()
+	for _, der := range d.CABundle {
+		ca, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation document CA bundle: %v", err)
+		}
+		intermediates.AddCert(ca)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   currentTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("untrusted attestation document certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// verifySignature checks the signature of the message with the key of the certificate.
+func (m *coseSign1) verifySignature(cert *x509.Certificate) error {
+	var header map[int]int
+	if err := cbor.Unmarshal(m.Protected, &header); err != nil {
+		return fmt.Errorf("invalid attestation document header: %v", err)
+	}
+	if alg := header[coseHeaderAlgorithm]; alg != coseAlgorithmES384 {
+		return fmt.Errorf("unsupported attestation document signature algorithm %d", alg)
+	}
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P384() {
+		return fmt.Errorf("attestation document certificate has no P-384 key")
+	}
+
+	// The signature covers the Sig_structure of RFC 8152, with no external data.
+	toBeSigned, err := cbor.Marshal([]interface{}{"Signature1", m.Protected, []byte{}, m.Payload})
+	if err != nil {
+		return err
+	}
+	digest := sha512.Sum384(toBeSigned)
+	size := (key.Curve.Params().BitSize + 7) / 8
+	if len(m.Signature) != 2*size {
+		return fmt.Errorf("invalid attestation document signature of %d bytes", len(m.Signature))
+	}
+	r := new(big.Int).SetBytes(m.Signature[:size])
+	s := new(big.Int).SetBytes(m.Signature[size:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return fmt.Errorf("invalid attestation document signature")
+	}
+	return nil
+}
+
+// ParsePCRs parses PCR values written "index=hex", such as the flags of
+// verifiers.
+func ParsePCRs(specs []string) (map[uint]string, error) {
+	pcrs := make(map[uint]string, len(specs))
+	for _, spec := range specs {
+		index, value, ok := strings.Cut(spec, "=")
+		i, err := strconv.ParseUint(index, 10, 32)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid PCR %q, expected index=hex", spec)
+		}
+		if _, err := hex.DecodeString(value); err != nil || value == "" {
+			return nil, fmt.Errorf("invalid PCR%d value %q, expected hex", i, value)
+		}
+		pcrs[uint(i)] = value
+	}
+	return pcrs, nil
+}
@@ -0,0 +1,112 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCertificate issues a P-384 certificate, self-signed without a parent.
+func newCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  name != "module",
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func TestVerify(t *testing.T) {
+	root, rootKey := newCertificate(t, "root", nil, nil)
+	intermediate, intermediateKey := newCertificate(t, "intermediate", root, rootKey)
+	module, moduleKey := newCertificate(t, "module", intermediate, intermediateKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	pcr0 := bytes.Repeat([]byte{0xab}, 48)
+	payload, err := cbor.Marshal(Document{
+		ModuleID:    "i-0123456789abcdef0-enc0123456789abcdef",
+		Digest:      "SHA384",
+		Timestamp:   uint64(time.Now().UnixMilli()),
+		PCRs:        map[uint][]byte{0: pcr0, 8: make([]byte, 48)},
+		Certificate: module.Raw,
+		CABundle:    [][]byte{root.Raw, intermediate.Raw},
+		Nonce:       []byte("nonce"),
+	})
+	assert.Nil(t, err)
+	protected, err := cbor.Marshal(map[int]int{coseHeaderAlgorithm: coseAlgorithmES384})
+	assert.Nil(t, err)
+	sign := func(payload []byte) []byte {
+		toBeSigned, err := cbor.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+		assert.Nil(t, err)
+		digest := sha512.Sum384(toBeSigned)
+		r, s, err := ecdsa.Sign(rand.Reader, moduleKey, digest[:])
+		assert.Nil(t, err)
+		signature := make([]byte, 96)
+		r.FillBytes(signature[:48])
+		s.FillBytes(signature[48:])
+		data, err := cbor.Marshal([]interface{}{protected, map[string]string{}, payload, signature})
+		assert.Nil(t, err)
+		return data
+	}
+	data := sign(payload)
+
+	doc, err := Verify(data, VerifyOptions{Roots: roots, Nonce: []byte("nonce"), PCRs: map[uint]string{0: "AB" + hex.EncodeToString(pcr0[1:])}})
+	assert.Nil(t, err)
+	if assert.NotNil(t, doc) {
+		assert.Equal(t, "i-0123456789abcdef0-enc0123456789abcdef", doc.ModuleID)
+	}
+
+	_, err = Verify(data, VerifyOptions{Roots: roots, Nonce: []byte("other")})
+	assert.EqualError(t, err, "attestation document does not include the nonce")
+	_, err = Verify(data, VerifyOptions{Roots: roots, PCRs: map[uint]string{8: hex.EncodeToString(pcr0)}})
+	assert.ErrorContains(t, err, "attestation document PCR8 is 0000")
+	// Documents are only trusted when they chain to a trusted root, the AWS
+	// one by default, at the time of verification.
+	_, err = Verify(data, VerifyOptions{})
+	assert.ErrorContains(t, err, "untrusted attestation document certificate")
+	_, err = Verify(data, VerifyOptions{Roots: roots, CurrentTime: time.Now().Add(2 * time.Hour)})
+	assert.ErrorContains(t, err, "untrusted attestation document certificate")
+
+	// Tampered documents are rejected.
+	tampered := sign(payload)
+	var msg coseSign1
+	assert.Nil(t, cbor.Unmarshal(tampered, &msg))
+	msg.Payload = bytes.Replace(msg.Payload, []byte("nonce"), []byte("nance"), 1)
+	tampered, err = cbor.Marshal(msg)
+	assert.Nil(t, err)
+	_, err = Verify(tampered, VerifyOptions{Roots: roots})
+	assert.EqualError(t, err, "invalid attestation document signature")
+
+	pcrs, err := ParsePCRs([]string{"0=ab", "8=CD"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[uint]string{0: "ab", 8: "CD"}, pcrs)
+	_, err = ParsePCRs([]string{"ab"})
+	assert.NotNil(t, err)
+	_, err = ParsePCRs([]string{"0=xyz"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+// Package audit keeps an append-only log of the operations on enclaves: the
+// builds, launches and terminations the node drives, and the console, exec
+// and attach requests of users, for incident response and compliance reviews.
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operation is an operation on an enclave recorded in the audit log.
+type Operation string
+
+const (
+	// OperationBuild is the build of the enclave image of a pod.
+	OperationBuild Operation = "build"
+	// OperationLaunch is the launch of an enclave.
+	OperationLaunch Operation = "launch"
+	// OperationTerminate is the termination of an enclave, when its pod is
+	// deleted or the enclave is killed to be restarted.
+	OperationTerminate Operation = "terminate"
+	// OperationConsole is a request for the console output of an enclave in debug mode.
+	OperationConsole Operation = "console"
+	// OperationLogs is a request for the logs of the workload of an enclave.
+	OperationLogs Operation = "logs"
+	// OperationExec is a request to run a command in an enclave.
+	OperationExec Operation = "exec"
+	// OperationAttach is a request to attach to the stdio of the workload of an enclave.
+	OperationAttach Operation = "attach"
+)
+
+// Record is an entry of the audit log, written as a JSON line.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Operation Operation `json:"operation"`
+	// Actor is who requested the operation: the authenticated user of
+	// kubelet API requests, or the node for the lifecycle of enclaves.
+	Actor string `json:"actor"`
+	// Node is the node the enclave runs on.
+	Node           string `json:"node,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	Pod            string `json:"pod,omitempty"`
+	UID            string `json:"uid,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	Container      string `json:"container,omitempty"`
+	// Image is the container image the enclave image is built from, and
+	// ImageDigest its digest when the pod pins it.
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	EnclaveID   string `json:"enclaveID,omitempty"`
+	DebugMode   bool   `json:"debugMode,omitempty"`
+	// PCRs are the measurements of the enclave image, by index.
+	PCRs map[uint]string `json:"pcrs,omitempty"`
+	// Command is the command of exec requests.
+	Command []string `json:"command,omitempty"`
+	// Reason tells why the node performed the operation, such as why it
+	// terminated an enclave.
+	Reason string `json:"reason,omitempty"`
+	// Error is why the operation failed or was denied, empty when it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Log appends records to an audit file, and sends them to CloudWatch Logs
+// too when configured. A nil Log records nothing.
+type Log struct {
+	mu         sync.Mutex
+	file       *os.File
+	cloudWatch *CloudWatch
+}
+
+// Open opens the audit file at path for appending, creating it readable by
+// its owner only if it does not exist. Records are also sent to cloudWatch,
+// unless nil.
+func Open(path string, cloudWatch *CloudWatch) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{file: file, cloudWatch: cloudWatch}, nil
+}
+
+// Record appends a record to the audit file, stamping it with the current
+// time unless it has one, and flushes it to disk.
+func (l *Log) Record(r Record) error {
+	if l == nil {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC()
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// A single write per record keeps the lines whole.
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.cloudWatch.send(r.Time, data)
+	return nil
+}
+
+// Close closes the audit file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func readRecords(t *testing.T, path string) []Record {
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, l.Record(Record{Operation: OperationBuild, Actor: "system:node:n", Namespace: "default", Pod: "web",
+		PCRs: map[uint]string{0: "aa", 8: "bb"}}))
+	assert.Nil(t, l.Close())
+
+	// Reopening appends to the records already there.
+	l, err = Open(path, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, l.Record(Record{Operation: OperationExec, Actor: "alice", Namespace: "default", Pod: "web", Command: []string{"sh"}}))
+	assert.Nil(t, l.Close())
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	records := readRecords(t, path)
+	assert.Len(t, records, 2)
+	assert.Equal(t, OperationBuild, records[0].Operation)
+	assert.Equal(t, map[uint]string{0: "aa", 8: "bb"}, records[0].PCRs)
+	assert.False(t, records[0].Time.IsZero())
+	assert.Equal(t, "alice", records[1].Actor)
+	assert.Equal(t, []string{"sh"}, records[1].Command)
+
+	var nilLog *Log
+	assert.Nil(t, nilLog.Record(Record{Operation: OperationLaunch}))
+}
+
+func TestCloudWatch(t *testing.T) {
+	var targets []string
+	var events []cloudWatchEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "/logs/aws4_request")
+		target := r.Header.Get("X-Amz-Target")
+		targets = append(targets, target)
+		data, _ := io.ReadAll(r.Body)
+		var in struct {
+			LogGroupName  string            `json:"logGroupName"`
+			LogStreamName string            `json:"logStreamName"`
+			LogEvents     []cloudWatchEvent `json:"logEvents"`
+		}
+		assert.Nil(t, json.Unmarshal(data, &in))
+		assert.Equal(t, "audit", in.LogGroupName)
+		assert.Equal(t, "node-1", in.LogStreamName)
+		if target == "Logs_20140328.CreateLogStream" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceAlreadyExistsException","message":"The specified log stream already exists"}`)) //nolint:errcheck
+			return
+		}
+		events = append(events, in.LogEvents...)
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{Region: "us-east-1", Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})}
+	c := NewCloudWatch(cfg, "audit", "node-1")
+	c.endpoint = server.URL
+	l, err := Open(filepath.Join(t.TempDir(), "audit.log"), c)
+	assert.Nil(t, err)
+	defer l.Close()
+	now := time.Now()
+	assert.Nil(t, l.Record(Record{Time: now, Operation: OperationLaunch, Pod: "web"}))
+	assert.Nil(t, l.Record(Record{Time: now, Operation: OperationTerminate, Pod: "web"}))
+
+	// The pending records are sent once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Run(ctx)
+	assert.Equal(t, []string{"Logs_20140328.CreateLogStream", "Logs_20140328.PutLogEvents"}, targets)
+	assert.Len(t, events, 2)
+	assert.Equal(t, now.UnixMilli(), events[0].Timestamp)
+	var r Record
+	assert.Nil(t, json.Unmarshal([]byte(events[1].Message), &r))
+	assert.Equal(t, OperationTerminate, r.Operation)
+}
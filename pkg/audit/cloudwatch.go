@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+const (
+	// cloudWatchFlushInterval is how often the pending records are sent.
+	cloudWatchFlushInterval = 5 * time.Second
+	// cloudWatchFlushTimeout bounds sending the records pending on shutdown.
+	cloudWatchFlushTimeout = 10 * time.Second
+	// cloudWatchQueueSize bounds the records queued while CloudWatch Logs is
+	// unreachable. Those dropped once it is full stay in the audit file.
+	cloudWatchQueueSize = 10000
+	// cloudWatchBatchSize bounds the records of a PutLogEvents request, a
+	// fraction of its limit of 10000 events and 1 MiB.
+	cloudWatchBatchSize = 500
+	// maxCloudWatchResponseSize bounds the responses of CloudWatch Logs read.
+	maxCloudWatchResponseSize = 1 << 20
+)
+
+// cloudWatchEvent is a log event of CloudWatch Logs.
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// cloudWatchError is an error returned by CloudWatch Logs.
+type cloudWatchError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *cloudWatchError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// CloudWatch sends the audit records to a log stream of CloudWatch Logs, in
+// the background, as the audit file keeps them regardless.
+type CloudWatch struct {
+	config aws.Config
+	group  string
+	stream string
+	// endpoint is the URL of CloudWatch Logs, the regional endpoint when empty.
+	endpoint string
+	events   chan cloudWatchEvent
+	// created tells the log stream exists.
+	created bool
+}
+
+// NewCloudWatch creates a CloudWatch sending the records to the stream of
+// the log group, creating the stream if needed. Records are sent once Run.
+func NewCloudWatch(config aws.Config, group, stream string) *CloudWatch {
+	return &CloudWatch{
+		config: config,
+		group:  group,
+		stream: stream,
+		events: make(chan cloudWatchEvent, cloudWatchQueueSize),
+	}
+}
+
+// send queues a record, dropping it when the queue is full.
+func (c *CloudWatch) send(t time.Time, data []byte) {
+	if c == nil {
+		return
+	}
+	select {
+	case c.events <- cloudWatchEvent{Timestamp: t.UnixMilli(), Message: string(data)}:
+	default:
+	}
+}
+
+// Run sends the queued records in batches until ctx is done, then those
+// still pending.
+func (c *CloudWatch) Run(ctx context.Context) {
+	ticker := time.NewTicker(cloudWatchFlushInterval)
+	defer ticker.Stop()
+
+	var pending []cloudWatchEvent
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), cloudWatchFlushTimeout)
+			defer cancel()
+			c.drain(flushCtx, &pending)
+			return
+		case <-ticker.C:
+			c.drain(ctx, &pending)
+		}
+	}
+}
+
+// drain sends the pending and queued records. Those which could not be sent
+// stay pending for the next attempt.
+func (c *CloudWatch) drain(ctx context.Context, pending *[]cloudWatchEvent) {
+	for {
+	fill:
+		for len(*pending) < cloudWatchBatchSize {
+			select {
+			case e := <-c.events:
+				*pending = append(*pending, e)
+			default:
+				break fill
+			}
+		}
+		if len(*pending) == 0 {
+			return
+		}
+		if err := c.put(ctx, *pending); err != nil {
+			log.G(ctx).Warnf("Failed to send %d audit records to CloudWatch Logs: %v", len(*pending), err)
+			return
+		}
+		*pending = (*pending)[:0]
+	}
+}
+
+// put sends a batch of records, creating the log stream first if needed.
+func (c *CloudWatch) put(ctx context.Context, events []cloudWatchEvent) error {
+	if !c.created {
+		err := c.call(ctx, "CreateLogStream", map[string]interface{}{
+			"logGroupName":  c.group,
+			"logStreamName": c.stream,
+		})
+		var e *cloudWatchError
+		if err != nil && !(errors.As(err, &e) && strings.HasSuffix(e.Type, "ResourceAlreadyExistsException")) {
+			return err
+		}
+		c.created = true
+	}
+	return c.call(ctx, "PutLogEvents", map[string]interface{}{
+		"logGroupName":  c.group,
+		"logStreamName": c.stream,
+		"logEvents":     events,
+	})
+}
+
+// call calls an action of the JSON API of CloudWatch Logs.
+func (c *CloudWatch) call(ctx context.Context, action string, in interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://logs.%s.amazonaws.com/", c.config.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+
+	credentials, err := c.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), "logs", c.config.Region, time.Now()); err != nil {
+		return err
+	}
+
+	client := c.config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCloudWatchResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := new(cloudWatchError)
+		if json.Unmarshal(data, e) != nil || e.Type == "" {
+			return fmt.Errorf("%s failed: %s", action, resp.Status)
+		}
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package autoscale scales the Nitro hosts of enclave nodes with the demand
+// for enclaves: hosts are added while enclave pods cannot be scheduled, and
+// removed once their node ran no pods for a while. The decisions are taken
+// by a Policy and carried out by a Scaler, such as an EC2 Auto Scaling group
+// or a CloudWatch metric driving its scaling policies.
+package autoscale
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// State is the demand for enclaves and the enclave nodes serving it.
+type State struct {
+	// Now is when the state was observed.
+	Now time.Time
+	// PendingPods is how many enclave pods cannot be scheduled.
+	PendingPods int
+	// Nodes are the enclave nodes.
+	Nodes []Node
+}
+
+// Node is an enclave node.
+type Node struct {
+	Name string
+	// InstanceID is the ID of the EC2 instance hosting the node, from its
+	// provider ID, if known.
+	InstanceID string
+	// Ready tells the node is ready, nodes of hosts being added are not yet,
+	// and neither are those of hosts gone.
+	Ready bool
+	// Unschedulable tells the node is cordoned.
+	Unschedulable bool
+	// Pods is how many pods the node runs.
+	Pods int
+	// IdleSince is since when the node runs no pods, zero while it runs some.
+	IdleSince time.Time
+}
+
+// Decision is what a Policy decided for a State.
+type Decision struct {
+	// ScaleOut is how many hosts to add.
+	ScaleOut int
+	// ScaleIn are the nodes whose hosts to remove.
+	ScaleIn []Node
+	// Reason tells why, for the logs.
+	Reason string
+}
+
+// Policy decides how to scale the hosts of enclave nodes.
+type Policy interface {
+	Decide(State) Decision
+}
+
+// Scaler carries out the decisions of a Policy.
+type Scaler interface {
+	Apply(ctx context.Context, state State, decision Decision) error
+}
+
+// Config configures an Autoscaler.
+type Config struct {
+	// NodeSelector selects the enclave nodes.
+	NodeSelector labels.Selector
+	// TargetsEnclaves tells whether a pod targets enclave nodes.
+	TargetsEnclaves func(*corev1.Pod) bool
+	// Interval is how often the state is observed.
+	Interval time.Duration
+	// Cooldown is how long after a scaling no other is decided, so the
+	// hosts being added join before the demand is observed again.
+	Cooldown time.Duration
+	// Cordon makes the nodes scaled in unschedulable before their hosts are
+	// removed, for scalers removing the hosts of these nodes, such as ASG.
+	Cordon bool
+}
+
+// Autoscaler observes the demand for enclaves and scales their hosts.
+type Autoscaler struct {
+	client kubernetes.Interface
+	policy Policy
+	scaler Scaler
+	config Config
+
+	// idleSince is since when the nodes run no pods, by name.
+	idleSince map[string]time.Time
+	// lastScaling is when the hosts were last scaled.
+	lastScaling time.Time
+}
+
+// New creates a new Autoscaler.
+func New(client kubernetes.Interface, policy Policy, scaler Scaler, config Config) *Autoscaler {
+	return &Autoscaler{
+		client:    client,
+		policy:    policy,
+		scaler:    scaler,
+		config:    config,
+		idleSince: make(map[string]time.Time),
+	}
+}
+
+// Run scales the hosts until the context is done.
+func (a *Autoscaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := a.scale(ctx); err != nil {
+			log.G(ctx).Errorf("Failed to scale the enclave hosts: %v.\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scale observes the state once and carries out the decision of the policy.
+// Scalers are given every decision, even empty ones, so the metrics they
+// publish go back to zero.
+func (a *Autoscaler) scale(ctx context.Context) error {
+	state, err := a.observe(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var decision Decision
+	if a.lastScaling.IsZero() || state.Now.Sub(a.lastScaling) >= a.config.Cooldown {
+		decision = a.policy.Decide(state)
+	}
+	if decision.ScaleOut > 0 || len(decision.ScaleIn) > 0 {
+		log.G(ctx).Infof("Scaling the enclave hosts out by %d and in by %d: %s", decision.ScaleOut, len(decision.ScaleIn), decision.Reason)
+		// Nodes are cordoned first, so no pod lands on them while their host goes away.
+		for _, node := range decision.ScaleIn {
+			if !a.config.Cordon {
+				break
+			}
+			if err := a.cordon(ctx, node.Name); err != nil {
+				return err
+			}
+		}
+		a.lastScaling = state.Now
+	}
+	return a.scaler.Apply(ctx, state, decision)
+}
+
+// observe returns the current state.
+func (a *Autoscaler) observe(ctx context.Context, now time.Time) (State, error) {
+	state := State{Now: now}
+	nodes, err := a.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: a.config.NodeSelector.String()})
+	if err != nil {
+		return state, err
+	}
+	pods, err := a.client.CoreV1().Pods(corev1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return state, err
+	}
+
+	running := make(map[string]int)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if pod.Spec.NodeName != "" {
+			running[pod.Spec.NodeName]++
+		} else if unschedulable(pod) && a.config.TargetsEnclaves(pod) {
+			state.PendingPods++
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range nodes.Items {
+		n := &nodes.Items[i]
+		node := Node{
+			Name:          n.Name,
+			InstanceID:    instanceID(n.Spec.ProviderID),
+			Ready:         ready(n),
+			Unschedulable: n.Spec.Unschedulable,
+			Pods:          running[n.Name],
+		}
+		seen[n.Name] = true
+		if node.Pods > 0 {
+			delete(a.idleSince, n.Name)
+		} else {
+			if _, ok := a.idleSince[n.Name]; !ok {
+				a.idleSince[n.Name] = now
+			}
+			node.IdleSince = a.idleSince[n.Name]
+		}
+		state.Nodes = append(state.Nodes, node)
+	}
+	for name := range a.idleSince {
+		if !seen[name] {
+			delete(a.idleSince, name)
+		}
+	}
+	return state, nil
+}
+
+// cordon makes a node unschedulable.
+func (a *Autoscaler) cordon(ctx context.Context, name string) error {
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	_, err := a.client.CoreV1().Nodes().Patch(ctx, name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// unschedulable tells the scheduler found no node for a pod.
+func unschedulable(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled {
+			return condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable
+		}
+	}
+	return false
+}
+
+// ready tells whether a node is ready.
+func ready(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// instanceID returns the EC2 instance ID of a provider ID in the
+// aws:///<availability zone>/<instance id> format, or an empty string.
+func instanceID(providerID string) string {
+	if !strings.HasPrefix(providerID, "aws://") {
+		return ""
+	}
+	id := providerID[strings.LastIndex(providerID, "/")+1:]
+	if !strings.HasPrefix(id, "i-") {
+		return ""
+	}
+	return id
+}
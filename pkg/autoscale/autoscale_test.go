@@ -0,0 +1,107 @@
+package autoscale
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	now := time.Now()
+	policy := DefaultPolicy{PodsPerNode: 4, IdleTimeout: time.Minute, MinNodes: 1, MaxNodes: 3}
+
+	// Enough hosts are added for the pending pods, counting those joining.
+	state := State{Now: now, PendingPods: 9, Nodes: []Node{{Name: "a", Ready: true}, {Name: "b"}}}
+	assert.Equal(t, 1, policy.Decide(state).ScaleOut)
+	policy.MaxNodes = 0
+	assert.Equal(t, 2, policy.Decide(state).ScaleOut)
+
+	// Idle hosts are removed, down to the fewest nodes kept.
+	idle := now.Add(-2 * time.Minute)
+	state = State{Now: now, Nodes: []Node{
+		{Name: "a", InstanceID: "i-a", Ready: true, IdleSince: idle},
+		{Name: "b", InstanceID: "i-b", Ready: true, IdleSince: idle},
+		{Name: "c", InstanceID: "i-c", Ready: true, IdleSince: now},
+		{Name: "d", InstanceID: "i-d", Ready: true, Pods: 1},
+	}}
+	decision := policy.Decide(state)
+	assert.Equal(t, 0, decision.ScaleOut)
+	assert.Equal(t, []Node{state.Nodes[0], state.Nodes[1]}, decision.ScaleIn)
+	policy.MinNodes = 3
+	assert.Equal(t, []Node{state.Nodes[0]}, policy.Decide(state).ScaleIn)
+}
+
+func TestObserve(t *testing.T) {
+	enclaveNode := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "enclave", Labels: map[string]string{"type": "virtual-kubelet"}},
+		Spec:       corev1.NodeSpec{ProviderID: "aws:///us-west-2a/i-0123456789abcdef0"},
+	}
+	otherNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pending"},
+		Status: corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{
+			{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable},
+		}},
+	}
+	client := fake.NewSimpleClientset(enclaveNode, otherNode, pending)
+	a := New(client, DefaultPolicy{}, nil, Config{
+		NodeSelector:    labels.SelectorFromSet(labels.Set{"type": "virtual-kubelet"}),
+		TargetsEnclaves: func(*corev1.Pod) bool { return true },
+	})
+
+	now := time.Now()
+	state, err := a.observe(context.Background(), now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, state.PendingPods)
+	assert.Equal(t, []Node{{Name: "enclave", InstanceID: "i-0123456789abcdef0", IdleSince: now}}, state.Nodes)
+
+	// Nodes stay idle since they were first seen idle.
+	state, err = a.observe(context.Background(), now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, now, state.Nodes[0].IdleSince)
+}
+
+func TestASG(t *testing.T) {
+	var actions []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256"))
+		body, _ := io.ReadAll(r.Body)
+		params, _ := url.ParseQuery(string(body))
+		actions = append(actions, params)
+		if params.Get("Action") == "DescribeAutoScalingGroups" {
+			io.WriteString(w, `<DescribeAutoScalingGroupsResponse><DescribeAutoScalingGroupsResult><AutoScalingGroups><member>
+<AutoScalingGroupName>enclaves</AutoScalingGroupName><DesiredCapacity>2</DesiredCapacity><MaxSize>3</MaxSize>
+</member></AutoScalingGroups></DescribeAutoScalingGroupsResult></DescribeAutoScalingGroupsResponse>`) //nolint:errcheck
+			return
+		}
+		io.WriteString(w, `<Response/>`) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	config := aws.Config{Region: "us-west-2", Credentials: credentials.NewStaticCredentialsProvider("key", "secret", "")}
+	asg := NewASG(config, "enclaves")
+	asg.client.endpoint = server.URL
+
+	// The desired capacity stays within the size of the group.
+	assert.Nil(t, asg.Apply(context.Background(), State{}, Decision{ScaleOut: 2}))
+	assert.Equal(t, "SetDesiredCapacity", actions[1].Get("Action"))
+	assert.Equal(t, "3", actions[1].Get("DesiredCapacity"))
+
+	actions = nil
+	assert.Nil(t, asg.Apply(context.Background(), State{}, Decision{ScaleIn: []Node{{Name: "a", InstanceID: "i-a"}}}))
+	assert.Equal(t, "TerminateInstanceInAutoScalingGroup", actions[0].Get("Action"))
+	assert.Equal(t, "i-a", actions[0].Get("InstanceId"))
+}
@@ -0,0 +1,196 @@
+package autoscale
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// maxResponseSize bounds the responses of the AWS query APIs read.
+const maxResponseSize = 1 << 20
+
+// queryClient calls an AWS API of the query protocol, such as those of EC2
+// Auto Scaling and CloudWatch.
+type queryClient struct {
+	config  aws.Config
+	service string
+	version string
+	// endpoint is the URL of the API, the regional endpoint of the service
+	// when empty.
+	endpoint string
+}
+
+// queryError is an error returned by an AWS query API.
+type queryError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// call calls an action of the API, decoding its XML response into out, if any.
+func (c *queryClient) call(ctx context.Context, action string, params url.Values, out interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", c.version)
+	body := []byte(params.Encode())
+
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com/", c.service, c.config.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	credentials, err := c.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), c.service, c.config.Region, time.Now()); err != nil {
+		return err
+	}
+
+	client := c.config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		e := new(queryError)
+		if xml.Unmarshal(data, e) != nil || e.Code == "" {
+			return fmt.Errorf("%s failed: %s", action, resp.Status)
+		}
+		return fmt.Errorf("%s failed: %w", action, e)
+	}
+	if out == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, out)
+}
+
+// ASG is a Scaler setting the desired capacity of an EC2 Auto Scaling group
+// of Nitro hosts, and terminating the hosts of the nodes scaled in.
+type ASG struct {
+	name   string
+	client *queryClient
+}
+
+// NewASG creates a new ASG scaling the Auto Scaling group of the given name.
+func NewASG(config aws.Config, name string) *ASG {
+	return &ASG{name: name, client: &queryClient{config: config, service: "autoscaling", version: "2011-01-01"}}
+}
+
+// describeAutoScalingGroupsResponse is the response of DescribeAutoScalingGroups.
+type describeAutoScalingGroupsResponse struct {
+	Groups []struct {
+		Name            string `xml:"AutoScalingGroupName"`
+		DesiredCapacity int    `xml:"DesiredCapacity"`
+		MaxSize         int    `xml:"MaxSize"`
+	} `xml:"DescribeAutoScalingGroupsResult>AutoScalingGroups>member"`
+}
+
+// Apply implements Scaler.
+func (g *ASG) Apply(ctx context.Context, _ State, decision Decision) error {
+	for _, node := range decision.ScaleIn {
+		params := url.Values{
+			"InstanceId":                     {node.InstanceID},
+			"ShouldDecrementDesiredCapacity": {"true"},
+		}
+		if err := g.client.call(ctx, "TerminateInstanceInAutoScalingGroup", params, nil); err != nil {
+			return fmt.Errorf("failed to terminate the host of node %s: %v", node.Name, err)
+		}
+	}
+	if decision.ScaleOut == 0 {
+		return nil
+	}
+
+	var groups describeAutoScalingGroupsResponse
+	if err := g.client.call(ctx, "DescribeAutoScalingGroups", url.Values{"AutoScalingGroupNames.member.1": {g.name}}, &groups); err != nil {
+		return err
+	}
+	if len(groups.Groups) == 0 {
+		return fmt.Errorf("auto scaling group %s is not found", g.name)
+	}
+	group := groups.Groups[0]
+	desired := group.DesiredCapacity + decision.ScaleOut
+	if desired > group.MaxSize {
+		desired = group.MaxSize
+	}
+	if desired == group.DesiredCapacity {
+		return fmt.Errorf("auto scaling group %s is at its maximum size of %d", g.name, group.MaxSize)
+	}
+	params := url.Values{
+		"AutoScalingGroupName": {g.name},
+		"DesiredCapacity":      {strconv.Itoa(desired)},
+	}
+	return g.client.call(ctx, "SetDesiredCapacity", params, nil)
+}
+
+// Metric is a Scaler publishing the decisions of the policy as CloudWatch
+// metrics, for the scaling policies of an Auto Scaling group to act on:
+// PendingEnclaveHosts, the hosts to add, and IdleEnclaveHosts, the hosts
+// which may be removed.
+type Metric struct {
+	namespace  string
+	dimensions map[string]string
+	client     *queryClient
+}
+
+// NewMetric creates a new Metric publishing to the given CloudWatch
+// namespace, with the given dimensions.
+func NewMetric(config aws.Config, namespace string, dimensions map[string]string) *Metric {
+	return &Metric{
+		namespace:  namespace,
+		dimensions: dimensions,
+		client:     &queryClient{config: config, service: "monitoring", version: "2010-08-01"},
+	}
+}
+
+// Apply implements Scaler.
+func (m *Metric) Apply(ctx context.Context, state State, decision Decision) error {
+	params := url.Values{"Namespace": {m.namespace}}
+	metrics := map[string]int{
+		"PendingEnclaveHosts": decision.ScaleOut,
+		"IdleEnclaveHosts":    len(decision.ScaleIn),
+	}
+	i := 1
+	for name, value := range metrics {
+		prefix := fmt.Sprintf("MetricData.member.%d.", i)
+		params.Set(prefix+"MetricName", name)
+		params.Set(prefix+"Value", strconv.Itoa(value))
+		params.Set(prefix+"Unit", "Count")
+		params.Set(prefix+"Timestamp", state.Now.UTC().Format(time.RFC3339))
+		j := 1
+		for key, value := range m.dimensions {
+			params.Set(fmt.Sprintf("%sDimensions.member.%d.Name", prefix, j), key)
+			params.Set(fmt.Sprintf("%sDimensions.member.%d.Value", prefix, j), value)
+			j++
+		}
+		i++
+	}
+	return m.client.call(ctx, "PutMetricData", params, nil)
+}
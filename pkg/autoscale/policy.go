@@ -0,0 +1,76 @@
+package autoscale
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultPolicy adds enough hosts for the enclave pods which cannot be
+// scheduled, counting the hosts still joining, whose nodes are schedulable
+// but not ready yet, and removes the hosts whose schedulable node ran no pods
+// for IdleTimeout, within MinNodes and MaxNodes. Cordoned nodes are left
+// alone, they are on their way out.
+type DefaultPolicy struct {
+	// PodsPerNode is how many enclave pods a host runs.
+	PodsPerNode int
+	// IdleTimeout is how long a node runs no pods before its host is removed,
+	// hosts are never removed when zero.
+	IdleTimeout time.Duration
+	// MinNodes is the fewest enclave nodes kept.
+	MinNodes int
+	// MaxNodes is the most enclave nodes added, unlimited when zero.
+	MaxNodes int
+}
+
+// Decide implements Policy.
+func (p DefaultPolicy) Decide(state State) Decision {
+	var schedulable, joining int
+	for _, node := range state.Nodes {
+		if node.Unschedulable {
+			continue
+		}
+		schedulable++
+		if !node.Ready {
+			joining++
+		}
+	}
+
+	if state.PendingPods > 0 {
+		podsPerNode := p.PodsPerNode
+		if podsPerNode < 1 {
+			podsPerNode = 1
+		}
+		needed := (state.PendingPods+podsPerNode-1)/podsPerNode - joining
+		if p.MaxNodes > 0 && schedulable+needed > p.MaxNodes {
+			needed = p.MaxNodes - schedulable
+		}
+		if needed > 0 {
+			return Decision{
+				ScaleOut: needed,
+				Reason:   fmt.Sprintf("%d enclave pods cannot be scheduled and %d hosts are joining", state.PendingPods, joining),
+			}
+		}
+		// Idle hosts are kept while pods wait, they may fit once they join.
+		return Decision{}
+	}
+
+	if p.IdleTimeout <= 0 {
+		return Decision{}
+	}
+	var decision Decision
+	remaining := schedulable
+	for _, node := range state.Nodes {
+		if remaining <= p.MinNodes {
+			break
+		}
+		if node.Unschedulable || node.InstanceID == "" || node.IdleSince.IsZero() || state.Now.Sub(node.IdleSince) < p.IdleTimeout {
+			continue
+		}
+		decision.ScaleIn = append(decision.ScaleIn, node)
+		remaining--
+	}
+	if len(decision.ScaleIn) > 0 {
+		decision.Reason = fmt.Sprintf("%d nodes ran no pods for %s", len(decision.ScaleIn), p.IdleTimeout)
+	}
+	return decision
+}
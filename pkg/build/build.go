@@ -1,12 +1,19 @@
 package build
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"text/template"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/sandbox"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 const (
@@ -46,90 +53,264 @@ files:
     mode: "0644"
   - path: env
     source: {{ .env }}
-    mode: "0644"`
+    mode: "0644"
+{{- range .files }}
+  - path: rootfs{{ .Path }}
+    source: {{ .Source }}
+    mode: "{{ .Mode }}"
+{{- end }}`
 )
 
-func generateBootstrap(initPath, nsmkoPath string) (*os.File, error) {
-	file, err := os.CreateTemp("", "bootstrap")
+// File is an extra file copied from the host into the enclave's root filesystem.
+type File struct {
+	// Path is the absolute path of the file in the enclave.
+	Path string
+	// Source is the path of the file on the host.
+	Source string
+	// Content, when not nil, is the content of the file, generated rather than
+	// copied from Source.
+	Content []byte
+	// Mode is the octal permission of the file, e.g. "0755".
+	Mode string
+}
+
+// ArtifactPrefix prefixes the names of the temporary files and directories
+// of builds, so the artifacts left behind by a crashed process can be swept.
+const ArtifactPrefix = "nitro-enclave-build-"
+
+// DefaultBlobsPath is where the aws-nitro-enclaves-cli-devel package installs
+// the blobs images are built with.
+const DefaultBlobsPath = "/usr/share/nitro_enclaves/blobs/"
+
+// writeTemplate writes a template executed with data to a new file of dir.
+func writeTemplate(dir, name, text string, data map[string]interface{}) (string, error) {
+	file, err := os.Create(filepath.Join(dir, name))
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	defer file.Close()
+
+	templ := template.Must(template.New(name).Parse(text))
+	if err := templ.Execute(file, data); err != nil {
+		return "", err
 	}
-	templ := template.Must(template.New("bootstrap").Parse(bootstrapTemplate))
-	err = templ.Execute(file, map[string]interface{}{
+	return file.Name(), file.Close()
+}
+
+func generateBootstrap(dir, initPath, nsmkoPath string) (string, error) {
+	return writeTemplate(dir, "bootstrap.yaml", bootstrapTemplate, map[string]interface{}{
 		"initPath":  initPath,
 		"nsmkoPath": nsmkoPath,
 	})
-	return file, err
 }
 
-func generateCustomer(image, cmdPath, envPath string) (*os.File, error) {
-	file, err := os.CreateTemp("", "customer")
-	if err != nil {
-		return nil, err
-	}
-	templ := template.Must(template.New("customer").Parse(customerTemplate))
-	err = templ.Execute(file, map[string]interface{}{
+func generateCustomer(dir, image, cmdPath, envPath string, files []File) (string, error) {
+	return writeTemplate(dir, "customer.yaml", customerTemplate, map[string]interface{}{
 		"image": image,
 		"cmd":   cmdPath,
 		"env":   envPath,
+		"files": files,
 	})
-	return file, err
 }
 
-func BuildEif(blobsPath string, image string, cmds []string, envs map[string]string, output string) error {
-	artifactsDir, err := os.MkdirTemp("", "initramfs")
-	if err != nil {
-		return err
+// writeLines writes a file of dir made of the given lines.
+func writeLines(dir, name string, lines []string) (string, error) {
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line+"\n"...)
 	}
-	defer os.RemoveAll(artifactsDir)
+	path := filepath.Join(dir, name)
+	return path, os.WriteFile(path, data, 0644)
+}
 
-	bootstrap, err := generateBootstrap(filepath.Join(blobsPath, "init"), filepath.Join(blobsPath, "nsm.ko"))
-	if err != nil {
-		return err
+// writeContents writes the generated files to dir, returning the files with
+// their source set to where they were written.
+func writeContents(dir string, files []File) ([]File, error) {
+	written := make([]File, len(files))
+	for i, f := range files {
+		if f.Content != nil {
+			f.Source = filepath.Join(dir, fmt.Sprintf("file-%d", i))
+			if err := os.WriteFile(f.Source, f.Content, 0644); err != nil {
+				return nil, err
+			}
+		}
+		written[i] = f
 	}
-	defer os.Remove(bootstrap.Name())
+	return written, nil
+}
 
-	cmd, err := os.CreateTemp("", "cmd")
+// Stages of the builds of enclave image files, telling which failed.
+const (
+	// StageSetup writes the artifacts of the build.
+	StageSetup = "setup"
+	// StageBootstrap builds the bootstrap ramdisk.
+	StageBootstrap = "bootstrap"
+	// StageImage builds the customer ramdisk, pulling the container image.
+	StageImage = "image"
+	// StageEIF assembles the enclave image file.
+	StageEIF = "eif"
+)
+
+// Error is the failure of a stage of BuildEif.
+type Error struct {
+	Stage string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FailedStage returns the stage of the build err failed, or StageSetup.
+func FailedStage(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Stage
+	}
+	return StageSetup
+}
+
+// Builder builds enclave image files, see BuildEif.
+type Builder func(blobsPath string, image string, cmds []string, envs map[string]string, output string, files ...File) error
+
+// builder builds the enclave image files of BuildEif when SetBuilder set it,
+// buildEif builds them with linuxkit and eif_build otherwise.
+var builder Builder
+
+// signer is the certificate and private key buildEif signs images with,
+// none when empty.
+var signer struct{ certificate, key string }
+
+// blobs are the files of the blobs directory buildEif builds images with.
+var blobs = []string{"init", "nsm.ko", "linuxkit", "cmdline", "bzImage", "bzImage.config"}
+
+// SetBuilder makes b build the enclave image files of BuildEif, such as the
+// images of simulated enclaves. It is called before any image is built.
+func SetBuilder(b Builder) {
+	builder = b
+}
+
+// sandboxed runs linuxkit and eif_build, unrestricted when nil.
+var sandboxed *sandbox.Sandbox
+
+// SetSandbox makes s run linuxkit and eif_build, and hold the artifacts of
+// the builds in its temporary directory. It is called before any image is built.
+func SetSandbox(s *sandbox.Sandbox) {
+	sandboxed = s
+}
+
+// TempDir returns the directory of the artifacts of the builds.
+func TempDir() string {
+	if dir := sandboxed.TempDir(); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// SetSigner makes buildEif sign the enclave image files with the PEM files
+// of a certificate and its private key, which PCR8 of the enclaves
+// measures. It is called before any image is built.
+func SetSigner(certificate, key string) {
+	signer.certificate, signer.key = certificate, key
+}
+
+// BuildEif builds an enclave image file from a container image, running cmds
+// with the environment envs. Extra files are added to the root filesystem.
+// The intermediate artifacts are removed, and so is the output on failure,
+// which is an Error telling the stage which failed.
+func BuildEif(blobsPath string, image string, cmds []string, envs map[string]string, output string, files ...File) error {
+	if builder != nil {
+		return builder(blobsPath, image, cmds, envs, output, files...)
+	}
+	return buildEif(blobsPath, image, cmds, envs, output, files...)
+}
+
+// MissingBlobs returns the files images are built with which are missing
+// from blobsPath, none when SetBuilder replaced the builder.
+func MissingBlobs(blobsPath string) []string {
+	if builder != nil {
+		return nil
+	}
+	var missing []string
+	for _, blob := range blobs {
+		if _, err := os.Stat(filepath.Join(blobsPath, blob)); err != nil {
+			missing = append(missing, blob)
+		}
+	}
+	return missing
+}
+
+func buildEif(blobsPath string, image string, cmds []string, envs map[string]string, output string, files ...File) (err error) {
+	stage := StageSetup
+	defer func() {
+		if err != nil {
+			err = &Error{Stage: stage, Err: err}
+		}
+	}()
+
+	artifactsDir, err := os.MkdirTemp(sandboxed.TempDir(), ArtifactPrefix+"*")
 	if err != nil {
 		return err
 	}
-	defer os.Remove(cmd.Name())
+	defer os.RemoveAll(artifactsDir)
+	defer func() {
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
 
-	env, err := os.CreateTemp("", "env")
+	bootstrap, err := generateBootstrap(artifactsDir, filepath.Join(blobsPath, "init"), filepath.Join(blobsPath, "nsm.ko"))
 	if err != nil {
 		return err
 	}
-	defer os.Remove(env.Name())
 
 	// TODO for now we will ignore the cmd and env from the docker image
-	for _, c := range cmds {
-		fmt.Fprintf(cmd, "%s\n", c)
+	cmd, err := writeLines(artifactsDir, "cmd", cmds)
+	if err != nil {
+		return err
 	}
+	var env []string
 	for k, v := range envs {
-		fmt.Fprintf(env, "%s=%s\n", k, v)
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	envPath, err := writeLines(artifactsDir, "env", env)
+	if err != nil {
+		return err
 	}
 
-	customer, err := generateCustomer(image, cmd.Name(), env.Name())
+	files, err = writeContents(artifactsDir, files)
+	if err != nil {
+		return err
+	}
+	customer, err := generateCustomer(artifactsDir, image, cmd, envPath, files)
 	if err != nil {
 		return err
 	}
-	defer os.Remove(customer.Name())
 
 	bootstrapRamdisk := filepath.Join(artifactsDir, "bootstrap-initrd.img")
 	customerRamdisk := filepath.Join(artifactsDir, "customer-initrd.img")
+	if err = sandboxed.Chown(artifactsDir); err != nil {
+		return err
+	}
 
+	stage = StageBootstrap
 	command := execCommand(filepath.Join(blobsPath, "linuxkit"),
 		"build",
 		"-name",
 		filepath.Join(artifactsDir, "bootstrap"),
 		"-format",
 		"kernel+initrd",
-		bootstrap.Name(),
+		bootstrap,
 	)
-	if err = command.Run(); err != nil {
+	if err = sandboxed.Run(command); err != nil {
 		return err
 	}
 
+	stage = StageImage
 	command = execCommand(filepath.Join(blobsPath, "linuxkit"),
 		"build",
 		"-name",
@@ -138,17 +319,18 @@ func BuildEif(blobsPath string, image string, cmds []string, envs map[string]str
 		"kernel+initrd",
 		"-prefix",
 		"rootfs/",
-		customer.Name(),
+		customer,
 	)
-	if err = command.Run(); err != nil {
+	if err = sandboxed.Run(command); err != nil {
 		return err
 	}
 
+	stage = StageEIF
 	cmdline, err := ioutil.ReadFile(filepath.Join(blobsPath, "cmdline"))
 	if err != nil {
 		return err
 	}
-	command = execCommand("eif_build",
+	args := []string{
 		"--kernel",
 		filepath.Join(blobsPath, "bzImage"),
 		"--kernel_config",
@@ -161,18 +343,63 @@ func BuildEif(blobsPath string, image string, cmds []string, envs map[string]str
 		customerRamdisk,
 		"--output",
 		output,
-	)
-	if err = command.Run(); err != nil {
+	}
+	if signer.certificate != "" {
+		args = append(args, "--signing-certificate", signer.certificate, "--private-key", signer.key)
+	}
+	command = execCommand("eif_build", args...)
+	if err = sandboxed.Run(command); err != nil {
 		return err
 	}
 	return nil
 }
 
+// CreateEif creates an empty file of the temporary directory to build an
+// enclave image file named after name into.
+func CreateEif(name string) (string, error) {
+	file, err := os.CreateTemp(sandboxed.TempDir(), ArtifactPrefix+name+"-*.eif")
+	if err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return file.Name(), sandboxed.Chown(file.Name())
+}
+
+// RemoveArtifacts removes the build artifacts of dir last modified before the
+// given time, left behind by processes which crashed, and returns how many
+// were removed.
+func RemoveArtifacts(dir string, before time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), ArtifactPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(before) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
 func execCommand(name string, arg ...string) *exec.Cmd {
-	fmt.Println("Running:", name, arg)
+	logger := log.L.WithField("command", filepath.Base(name))
+	logger.Infof("Running %s %s", name, strings.Join(arg, " "))
 
-	command := exec.Command(name, arg...)
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
+	command := sandboxed.Command(name, arg...)
+	// The same writer for both, so the lines of the command stay in order.
+	output := logging.Writer(logger.Debug)
+	command.Stdout = output
+	command.Stderr = output
 	return command
 }
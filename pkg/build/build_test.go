@@ -0,0 +1,49 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, ArtifactPrefix+"123")
+	assert.Nil(t, os.MkdirAll(filepath.Join(stale, "initramfs"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, ArtifactPrefix+"pod-1.eif"), nil, 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "other"), nil, 0644))
+
+	// Artifacts of builds started since are kept.
+	removed, err := RemoveArtifacts(dir, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, removed)
+
+	removed, err = RemoveArtifacts(dir, time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "other", entries[0].Name())
+}
+
+func TestFailedStage(t *testing.T) {
+	// Without linuxkit, the build fails building the bootstrap ramdisk.
+	err := BuildEif(t.TempDir(), "alpine", []string{"true"}, nil, filepath.Join(t.TempDir(), "out.eif"))
+	assert.NotNil(t, err)
+	assert.Equal(t, StageBootstrap, FailedStage(err))
+
+	assert.Equal(t, StageSetup, FailedStage(os.ErrNotExist))
+}
+
+func TestMissingBlobs(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, blobs, MissingBlobs(dir))
+	for _, blob := range blobs[1:] {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, blob), nil, 0644))
+	}
+	assert.Equal(t, []string{"init"}, MissingBlobs(dir))
+}
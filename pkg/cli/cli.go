@@ -9,6 +9,10 @@ import (
 	"os"
 	"os/exec"
 	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/logging"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/sandbox"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 type EnclaveConfig struct {
@@ -21,6 +25,9 @@ type EnclaveConfig struct {
 	DebugMode   bool   `json:"debug_mode,omitempty"`
 }
 
+// FlagDebugMode is the flag of enclaves running in debug mode.
+const FlagDebugMode = "DEBUG_MODE"
+
 type EnclaveInfo struct {
 	EnclaveName  string `json:"EnclaveName"`
 	EnclaveID    string `json:"EnclaveID"`
@@ -29,7 +36,7 @@ type EnclaveInfo struct {
 	NumberOfCPUs int64  `json:"NumberOfCPUs"`
 	CPUIDs       []int  `json:"CPUIDs"`
 	MemoryMiB    int64  `json:"MemoryMiB"`
-	State        string `json:"State"`
+	State        State  `json:"State"`
 	Flags        string `json:"Flags"`
 }
 
@@ -45,12 +52,14 @@ type EifInfo struct {
 		Pcr0          string `json:"PCR0"`
 		Pcr1          string `json:"PCR1"`
 		Pcr2          string `json:"PCR2"`
+		Pcr8          string `json:"PCR8,omitempty"`
 	} `json:"Measurements"`
-	IsSigned     bool   `json:"IsSigned"`
-	CheckCRC     bool   `json:"CheckCRC"`
-	ImageName    string `json:"ImageName"`
-	ImageVersion string `json:"ImageVersion"`
-	Metadata     struct {
+	IsSigned       bool   `json:"IsSigned"`
+	SignatureCheck *bool  `json:"SignatureCheck,omitempty"`
+	CheckCRC       bool   `json:"CheckCRC"`
+	ImageName      string `json:"ImageName"`
+	ImageVersion   string `json:"ImageVersion"`
+	Metadata       struct {
 		BuildTime        time.Time   `json:"BuildTime"`
 		BuildTool        string      `json:"BuildTool"`
 		BuildToolVersion string      `json:"BuildToolVersion"`
@@ -60,32 +69,47 @@ type EifInfo struct {
 	} `json:"Metadata"`
 }
 
-func RunEnclave(c *EnclaveConfig) (*EnclaveInfo, error) {
-	file, err := os.CreateTemp("", "enclaveconfig")
-	if err != nil {
-		return nil, err
-	}
-	defer os.Remove(file.Name())
-	data, _ := json.MarshalIndent(c, "", " ")
-	if _, err := file.Write(data); err != nil {
-		return nil, err
-	}
+// Backend runs the enclaves of the node: a Host, or a simulator of enclaves.
+type Backend interface {
+	RunEnclave(c *EnclaveConfig) (*EnclaveInfo, error)
+	DescribeEnclaves() ([]EnclaveInfo, error)
+	TerminateEnclave(enclaveID string) (*TerminationResponse, error)
+	Console(enclaveID string) (io.ReadCloser, error)
+	Version() ([3]int, error)
+}
 
-	info := new(EnclaveInfo)
-	err = run(&info, '{', "nitro-cli", "run-enclave", "--config", file.Name())
-	return info, err
+// backend runs the enclaves of the package functions, the local host unless
+// SetBackend replaced it.
+var backend Backend = Local
+
+// SetBackend makes b run the enclaves of the package functions. It is called
+// before any enclave runs.
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// sandboxed runs nitro-cli on the local host, unrestricted when nil.
+var sandboxed *sandbox.Sandbox
+
+// SetSandbox makes s run nitro-cli on the local host. It is called before
+// any enclave runs.
+func SetSandbox(s *sandbox.Sandbox) {
+	sandboxed = s
+}
+
+// RunEnclave launches an enclave of the node.
+func RunEnclave(c *EnclaveConfig) (*EnclaveInfo, error) {
+	return backend.RunEnclave(c)
 }
 
+// DescribeEnclaves describes the enclaves of the node.
 func DescribeEnclaves() ([]EnclaveInfo, error) {
-	info := new([]EnclaveInfo)
-	err := run(&info, '[', "nitro-cli", "describe-enclaves")
-	return *info, err
+	return backend.DescribeEnclaves()
 }
 
+// TerminateEnclave terminates an enclave of the node.
 func TerminateEnclave(enclaveID string) (*TerminationResponse, error) {
-	resp := new(TerminationResponse)
-	err := run(&resp, '{', "nitro-cli", "terminate-enclave", "--enclave-id", enclaveID)
-	return resp, err
+	return backend.TerminateEnclave(enclaveID)
 }
 
 type consoleReadCloser struct {
@@ -111,19 +135,9 @@ func (r consoleReadCloser) Close() error {
 	return r.pw.Close()
 }
 
+// Console follows the console of an enclave of the node.
 func Console(enclaveID string) (io.ReadCloser, error) {
-	cmd := exec.Command("nitro-cli", "console", "--enclave-id", enclaveID)
-	pr, pw, err := os.Pipe()
-	if err != nil {
-		return nil, err
-	}
-	cmd.Stdout = pw
-	cmd.Stderr = pw
-
-	if err := cmd.Start(); err != nil {
-		return nil, err
-	}
-	return consoleReadCloser{cmd, pr, pw}, nil
+	return backend.Console(enclaveID)
 }
 
 func DescribeEif(eif string) (*EifInfo, error) {
@@ -132,13 +146,32 @@ func DescribeEif(eif string) (*EifInfo, error) {
 	return info, err
 }
 
+// SigningCertificatePCR returns PCR8 of the enclave images signed with the
+// PEM file of a certificate, in hex.
+func SigningCertificatePCR(certificate string) (string, error) {
+	var pcrs map[string]string
+	if err := run(&pcrs, '{', "nitro-cli", "pcr", "--signing-certificate", certificate); err != nil {
+		return "", err
+	}
+	for _, pcr := range pcrs {
+		return pcr, nil
+	}
+	return "", fmt.Errorf("nitro-cli measured no PCR of %s", certificate)
+}
+
 func run(v any, stop byte, name string, arg ...string) error {
-	cmd := exec.Command(name, arg...)
+	return runCommand(v, stop, sandboxed.Command(name, arg...))
+}
+
+// runCommand runs a nitro-cli command and decodes the JSON document it
+// prints from the first stop byte.
+func runCommand(v any, stop byte, cmd *exec.Cmd) error {
 	buf := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
 	cmd.Stdout = buf
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return err
+	cmd.Stderr = io.MultiWriter(logging.Writer(log.L.WithField("command", cmd.Args[0]).Debug), stderr)
+	if err := sandboxed.Run(cmd); err != nil {
+		return newError(err, stderr.Bytes())
 	}
 
 	reader := bufio.NewReader(buf)
@@ -36,6 +36,17 @@ Started enclave with enclave-cid: 10, memory: 1600 MiB, cpu-ids: [1, 3]
 	assert.Equal(t, *info, expected, "they should be equal")
 }
 
+func TestRunError(t *testing.T) {
+	info := new(EnclaveInfo)
+	err := run(&info, '{', "/bin/sh", "-c", `echo 'Start allocating memory...' >&2
+echo '[ E26 ] Insufficient CPUs available in the pool.' >&2
+echo 'For more details, please visit https://docs.aws.amazon.com/enclaves/latest/user/cli-errors.html#E26' >&2
+exit 1`)
+	assert.NotNil(t, err)
+	assert.Equal(t, "E26", ErrorCode(err))
+	assert.Equal(t, "nitro-cli: E26: Insufficient CPUs available in the pool.", err.Error())
+}
+
 func TestDescribeEnclaves(t *testing.T) {
 	info := new([]EnclaveInfo)
 	err := run(&info, '[', "/bin/echo", `[
@@ -85,3 +96,17 @@ func TestTerminateEnclave(t *testing.T) {
 	}
 	assert.Equal(t, *resp, expected, "they should be equal")
 }
+
+func TestMaxEnclaves(t *testing.T) {
+	version, err := parseVersion("Nitro CLI 1.2.2\n")
+	assert.Nil(t, err)
+	assert.Equal(t, [3]int{1, 2, 2}, version)
+	assert.Equal(t, MaxEnclavesPerInstance, MaxEnclaves(version))
+
+	version, err = parseVersion("Nitro CLI 1.1.0")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, MaxEnclaves(version))
+
+	_, err = parseVersion("nitro-cli: command not found")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// errorPattern matches the error line printed by nitro-cli, e.g.
+// "[ E26 ] Insufficient CPUs available in the pool."
+var errorPattern = regexp.MustCompile(`\[ (E\d+) \] ([^\n]*)`)
+
+// Error is a failure reported by nitro-cli.
+type Error struct {
+	// Code is the nitro-cli error code, such as E26, empty if none was printed.
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("nitro-cli: %v", e.Err)
+	}
+	return fmt.Sprintf("nitro-cli: %s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError builds the error of a failed nitro-cli command from its error output.
+func newError(err error, stderr []byte) *Error {
+	e := &Error{Err: err}
+	if m := errorPattern.FindSubmatch(stderr); m != nil {
+		e.Code = string(m[1])
+		e.Message = string(m[2])
+	}
+	return e
+}
+
+// ErrorCode returns the nitro-cli error code of err, or an empty string.
+func ErrorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultRemoteEIFDir is where EIFs are copied to on remote hosts.
+	DefaultRemoteEIFDir = "/var/lib/nitro-enclave-kubelet/eifs"
+
+	// ssmProxyCommand tunnels SSH through SSM Session Manager, so instances
+	// are reached by their ID without opening their SSH port.
+	ssmProxyCommand = "aws ssm start-session --target %h --document-name AWS-StartSSHSession --parameters portNumber=%p"
+)
+
+// Host is a parent instance nitro-cli runs on: the local instance, or a
+// remote one reached over SSH, or over SSH tunnelled through SSM when only
+// its instance ID is known. Commands run on remote hosts the same way, and
+// the EIFs they launch are copied to them first.
+//
+// Only nitro-cli goes through the host: the vsock channels of the agents of
+// enclaves are local to their parent instance.
+type Host struct {
+	// Address is the SSH address of a remote host, [user@]host, empty for
+	// the local host unless InstanceID is set.
+	Address string
+	// Port is the SSH port of a remote host, 22 when zero.
+	Port int
+	// InstanceID is the EC2 instance ID of a remote host reached through
+	// SSM, which needs the AWS CLI and its session manager plugin.
+	InstanceID string
+	// User is the SSH user of a remote host reached through SSM.
+	User string
+	// IdentityFile is the SSH private key of a remote host, if not the default.
+	IdentityFile string
+	// EIFDir is the directory EIFs are copied to on a remote host,
+	// DefaultRemoteEIFDir when empty.
+	EIFDir string
+}
+
+// Local is the local host.
+var Local = &Host{}
+
+// Remote tells whether the host is a remote instance.
+func (h *Host) Remote() bool {
+	return h.Address != "" || h.InstanceID != ""
+}
+
+// String returns the address of the host.
+func (h *Host) String() string {
+	if !h.Remote() {
+		return "localhost"
+	}
+	return h.target()
+}
+
+// target returns the SSH destination of a remote host.
+func (h *Host) target() string {
+	if h.Address != "" {
+		return h.Address
+	}
+	if h.User != "" {
+		return h.User + "@" + h.InstanceID
+	}
+	return h.InstanceID
+}
+
+// sshOptions returns the options of the SSH and SCP commands reaching a
+// remote host, which never prompt.
+func (h *Host) sshOptions(portFlag string) []string {
+	options := []string{"-o", "BatchMode=yes"}
+	if h.Port != 0 {
+		options = append(options, portFlag, strconv.Itoa(h.Port))
+	}
+	if h.IdentityFile != "" {
+		options = append(options, "-i", h.IdentityFile)
+	}
+	if h.Address == "" && h.InstanceID != "" {
+		options = append(options, "-o", "ProxyCommand="+ssmProxyCommand)
+	}
+	return options
+}
+
+// Command returns the command running a program on the host, in the
+// sandbox of the package on the local host.
+func (h *Host) Command(name string, arg ...string) *exec.Cmd {
+	if !h.Remote() {
+		return sandboxed.Command(name, arg...)
+	}
+	// The remote shell splits the command line again.
+	words := make([]string, 0, len(arg)+1)
+	for _, s := range append([]string{name}, arg...) {
+		words = append(words, shellQuote(s))
+	}
+	args := append(h.sshOptions("-p"), h.target(), "--", strings.Join(words, " "))
+	return exec.Command("ssh", args...)
+}
+
+// CopyFile copies a local file to a path of the host, unless the host is local.
+func (h *Host) CopyFile(local, remote string) error {
+	if !h.Remote() || local == remote {
+		return nil
+	}
+	if output, err := h.Command("mkdir", "-p", path.Dir(remote)).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create %s on %s: %v: %s", path.Dir(remote), h, err, strings.TrimSpace(string(output)))
+	}
+	args := append(h.sshOptions("-P"), "-q", local, h.target()+":"+remote)
+	if output, err := exec.Command("scp", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %v: %s", local, h, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// eifPath returns the path of a local EIF on the host. Built EIFs have unique
+// names, so an EIF copied once is not copied again.
+func (h *Host) eifPath(eif string) string {
+	if !h.Remote() {
+		return eif
+	}
+	dir := h.EIFDir
+	if dir == "" {
+		dir = DefaultRemoteEIFDir
+	}
+	return path.Join(dir, filepath.Base(eif))
+}
+
+// pushEIF copies a local EIF to the host unless it has it already, and
+// returns its path on the host.
+func (h *Host) pushEIF(eif string) (string, error) {
+	remote := h.eifPath(eif)
+	if !h.Remote() {
+		return remote, nil
+	}
+	if err := h.Command("test", "-f", remote).Run(); err == nil {
+		return remote, nil
+	}
+	// The EIF is copied aside first, so an interrupted copy is never launched.
+	partial := remote + ".partial"
+	if err := h.CopyFile(eif, partial); err != nil {
+		return "", err
+	}
+	if output, err := h.Command("mv", partial, remote).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to move %s on %s: %v: %s", partial, h, err, strings.TrimSpace(string(output)))
+	}
+	return remote, nil
+}
+
+// RunEnclave launches an enclave on the host, copying its EIF there first.
+func (h *Host) RunEnclave(c *EnclaveConfig) (*EnclaveInfo, error) {
+	config := *c
+	eif, err := h.pushEIF(c.EifPath)
+	if err != nil {
+		return nil, err
+	}
+	config.EifPath = eif
+
+	file, err := os.CreateTemp(sandboxed.TempDir(), "enclaveconfig")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	data, _ := json.MarshalIndent(config, "", " ")
+	if _, err := file.Write(data); err != nil {
+		return nil, err
+	}
+	if err := sandboxed.Chown(file.Name()); err != nil {
+		return nil, err
+	}
+
+	configPath := file.Name()
+	if h.Remote() {
+		configPath = path.Join(path.Dir(eif), c.EnclaveName+".json")
+		if err := h.CopyFile(file.Name(), configPath); err != nil {
+			return nil, err
+		}
+		defer h.Command("rm", "-f", configPath).Run() //nolint:errcheck
+	}
+
+	info := new(EnclaveInfo)
+	err = runCommand(&info, '{', h.Command("nitro-cli", "run-enclave", "--config", configPath))
+	return info, err
+}
+
+// DescribeEnclaves describes the enclaves of the host.
+func (h *Host) DescribeEnclaves() ([]EnclaveInfo, error) {
+	info := new([]EnclaveInfo)
+	err := runCommand(&info, '[', h.Command("nitro-cli", "describe-enclaves"))
+	return *info, err
+}
+
+// TerminateEnclave terminates an enclave of the host.
+func (h *Host) TerminateEnclave(enclaveID string) (*TerminationResponse, error) {
+	resp := new(TerminationResponse)
+	err := runCommand(&resp, '{', h.Command("nitro-cli", "terminate-enclave", "--enclave-id", enclaveID))
+	return resp, err
+}
+
+// Console follows the console of an enclave of the host.
+func (h *Host) Console(enclaveID string) (io.ReadCloser, error) {
+	cmd := h.Command("nitro-cli", "console", "--enclave-id", enclaveID)
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := sandboxed.Start(cmd); err != nil {
+		return nil, err
+	}
+	return consoleReadCloser{cmd, pr, pw}, nil
+}
+
+// Version returns the major, minor and patch version of the nitro-cli of the host.
+func (h *Host) Version() ([3]int, error) {
+	cmd := h.Command("nitro-cli", "--version")
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	if err := sandboxed.Run(cmd); err != nil {
+		return [3]int{}, err
+	}
+	return parseVersion(output.String())
+}
+
+// shellQuote quotes a word for a POSIX shell, unless it needs no quoting.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,@%+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
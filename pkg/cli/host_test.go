@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostCommand(t *testing.T) {
+	cmd := Local.Command("nitro-cli", "describe-enclaves")
+	assert.Equal(t, []string{"nitro-cli", "describe-enclaves"}, cmd.Args)
+
+	host := &Host{Address: "ec2-user@10.0.0.1", Port: 2222}
+	cmd = host.Command("nitro-cli", "terminate-enclave", "--enclave-id", "it's")
+	assert.Equal(t, []string{"ssh", "-o", "BatchMode=yes", "-p", "2222", "ec2-user@10.0.0.1", "--",
+		`nitro-cli terminate-enclave --enclave-id 'it'\''s'`}, cmd.Args)
+
+	// Instances known by their ID only are reached through SSM.
+	host = &Host{InstanceID: "i-0123456789abcdef0", User: "ec2-user"}
+	cmd = host.Command("nitro-cli", "describe-enclaves")
+	assert.Equal(t, []string{"ssh", "-o", "BatchMode=yes", "-o", "ProxyCommand=" + ssmProxyCommand,
+		"ec2-user@i-0123456789abcdef0", "--", "nitro-cli describe-enclaves"}, cmd.Args)
+	assert.Equal(t, DefaultRemoteEIFDir+"/app-1.eif", host.eifPath("/tmp/app-1.eif"))
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// State is the lifecycle state of an enclave as reported by describe-enclaves.
+type State string
+
+const (
+	StateRunning     State = "RUNNING"
+	StateTerminating State = "TERMINATING"
+	// StateTerminated is never reported by nitro-cli, an enclave which has
+	// terminated is simply absent from describe-enclaves.
+	StateTerminated State = "TERMINATED"
+)
+
+// DefaultPollInterval is how often WaitForState polls describe-enclaves.
+const DefaultPollInterval = 500 * time.Millisecond
+
+// describeEnclaves is swapped out in tests.
+var describeEnclaves = DescribeEnclaves
+
+// GetState returns the current state of the enclave with the given ID.
+func GetState(enclaveID string) (State, error) {
+	enclaves, err := describeEnclaves()
+	if err != nil {
+		return "", err
+	}
+	for _, info := range enclaves {
+		if info.EnclaveID == enclaveID {
+			return info.State, nil
+		}
+	}
+	return StateTerminated, nil
+}
+
+// WaitForState polls describe-enclaves until the enclave with the given ID
+// reaches the desired state, the timeout elapses or the context is cancelled.
+// A zero timeout waits until the context is cancelled.
+func WaitForState(ctx context.Context, enclaveID string, state State, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(DefaultPollInterval)
+	defer ticker.Stop()
+
+	for {
+		current, err := GetState(enclaveID)
+		if err != nil {
+			return err
+		}
+		if current == state {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("enclave %s did not reach state %s (currently %s): %w", enclaveID, state, current, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
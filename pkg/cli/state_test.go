@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForState(t *testing.T) {
+	defer func() { describeEnclaves = DescribeEnclaves }()
+
+	calls := 0
+	describeEnclaves = func() ([]EnclaveInfo, error) {
+		calls++
+		if calls < 3 {
+			return []EnclaveInfo{{EnclaveID: "enc-1", State: StateTerminating}}, nil
+		}
+		return []EnclaveInfo{}, nil
+	}
+
+	err := WaitForState(context.Background(), "enc-1", StateTerminated, 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestWaitForStateTimeout(t *testing.T) {
+	defer func() { describeEnclaves = DescribeEnclaves }()
+
+	describeEnclaves = func() ([]EnclaveInfo, error) {
+		return []EnclaveInfo{{EnclaveID: "enc-1", State: StateRunning}}, nil
+	}
+
+	err := WaitForState(context.Background(), "enc-1", StateTerminated, time.Second)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
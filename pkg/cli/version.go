@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// MaxEnclavesPerInstance is how many enclaves a parent instance can run at once.
+	MaxEnclavesPerInstance = 4
+)
+
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// Version returns the major, minor and patch version of the nitro-cli of the node.
+func Version() ([3]int, error) {
+	return backend.Version()
+}
+
+func parseVersion(s string) ([3]int, error) {
+	var version [3]int
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return version, fmt.Errorf("invalid nitro-cli version %q", s)
+	}
+	for i := range version {
+		version[i], _ = strconv.Atoi(m[i+1])
+	}
+	return version, nil
+}
+
+// MaxEnclaves returns how many enclaves can run at once with the given
+// nitro-cli version. Running several enclaves requires nitro-cli 1.2.0.
+func MaxEnclaves(version [3]int) int {
+	if version[0] > 1 || (version[0] == 1 && version[1] >= 2) {
+		return MaxEnclavesPerInstance
+	}
+	return 1
+}
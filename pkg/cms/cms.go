@@ -0,0 +1,350 @@
+// Package cms decrypts the enveloped data of the Cryptographic Message
+// Syntax (RFC 5652) which KMS returns to the recipients of attested
+// requests: a content key encrypted to the RSA key of the enclave with
+// RSAES-OAEP and SHA-256, and the content encrypted with AES-CBC.
+//
+// KMS encodes it in BER, with indefinite lengths, which encoding/asn1 does
+// not parse, and identifies the recipient by subject key identifier, while
+// PKCS #7 packages match recipients against a certificate the enclave does
+// not have. The package reads the few structures it needs itself.
+package cms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAESOAEP     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidAES128CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// Tags of the universal class.
+const (
+	tagOctetString = 4
+	tagOID         = 6
+	tagSequence    = 16
+	tagSet         = 17
+)
+
+// Classes of tags.
+const (
+	classUniversal = 0
+	classContext   = 2
+)
+
+// maxDepth bounds the nesting of elements of indefinite length, which are
+// decoded recursively. The enveloped data of KMS nests a few levels only.
+const maxDepth = 32
+
+// ErrNoRecipient is returned when no recipient of the enveloped data has the key.
+var ErrNoRecipient = errors.New("enveloped data is not encrypted to the key")
+
+// Decrypt decrypts enveloped data, in BER or DER, encrypted to the key.
+func Decrypt(data []byte, key *rsa.PrivateKey) ([]byte, error) {
+	contentInfo, _, err := next(data)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := contentInfo.children()
+	if err != nil || len(fields) != 2 || !fields[0].is(classUniversal, tagOID) || !fields[1].is(classContext, 0) {
+		return nil, errors.New("invalid content info")
+	}
+	if oid, err := fields[0].oid(); err != nil || !oid.Equal(oidEnvelopedData) {
+		return nil, errors.New("content is not enveloped data")
+	}
+	envelopedData, err := fields[1].children()
+	if err != nil || len(envelopedData) != 1 {
+		return nil, errors.New("invalid enveloped data")
+	}
+	fields, err = envelopedData[0].children()
+	if err != nil {
+		return nil, errors.New("invalid enveloped data")
+	}
+
+	// version, [0] originatorInfo, recipientInfos, encryptedContentInfo, [1] unprotectedAttrs
+	var recipients, encryptedContent *element
+	for i := range fields {
+		switch f := &fields[i]; {
+		case f.is(classUniversal, tagSet) && recipients == nil:
+			recipients = f
+		case f.is(classUniversal, tagSequence) && recipients != nil && encryptedContent == nil:
+			encryptedContent = f
+		}
+	}
+	if recipients == nil || encryptedContent == nil {
+		return nil, errors.New("enveloped data has no recipients or content")
+	}
+
+	contentKey, err := decryptContentKey(*recipients, key)
+	if err != nil {
+		return nil, err
+	}
+	return decryptContent(*encryptedContent, contentKey)
+}
+
+// decryptContentKey decrypts the content key of the first key transport
+// recipient the key can decrypt.
+func decryptContentKey(recipients element, key *rsa.PrivateKey) ([]byte, error) {
+	infos, err := recipients.children()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient infos: %v", err)
+	}
+	for _, info := range infos {
+		// Key transport recipients are plain sequences, the others are tagged.
+		if !info.is(classUniversal, tagSequence) {
+			continue
+		}
+		// version, rid, keyEncryptionAlgorithm, encryptedKey
+		fields, err := info.children()
+		if err != nil || len(fields) != 4 || !fields[2].is(classUniversal, tagSequence) {
+			return nil, errors.New("invalid key transport recipient info")
+		}
+		if err := checkOAEP(fields[2]); err != nil {
+			return nil, err
+		}
+		encryptedKey, err := fields[3].bytes()
+		if err != nil {
+			return nil, err
+		}
+		if contentKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, encryptedKey, nil); err == nil {
+			return contentKey, nil
+		}
+	}
+	return nil, ErrNoRecipient
+}
+
+// checkOAEP checks a key encryption algorithm is RSAES-OAEP with SHA-256.
+func checkOAEP(algorithm element) error {
+	fields, err := algorithm.children()
+	if err != nil || len(fields) == 0 {
+		return errors.New("invalid key encryption algorithm")
+	}
+	if oid, err := fields[0].oid(); err != nil || !oid.Equal(oidRSAESOAEP) {
+		return fmt.Errorf("unsupported key encryption algorithm %v", oid)
+	}
+	// The hash algorithm of RSAES-OAEP-params is [0], SHA-1 when absent.
+	if len(fields) > 1 {
+		params, err := fields[1].children()
+		if err != nil {
+			return errors.New("invalid RSAES-OAEP parameters")
+		}
+		for _, param := range params {
+			if !param.is(classContext, 0) {
+				continue
+			}
+			hash, err := param.children()
+			if err != nil || len(hash) != 1 {
+				return errors.New("invalid RSAES-OAEP hash algorithm")
+			}
+			fields, err := hash[0].children()
+			if err != nil || len(fields) == 0 {
+				return errors.New("invalid RSAES-OAEP hash algorithm")
+			}
+			if oid, err := fields[0].oid(); err == nil && oid.Equal(oidSHA256) {
+				return nil
+			}
+		}
+	}
+	return errors.New("unsupported RSAES-OAEP hash algorithm, SHA-256 is expected")
+}
+
+// decryptContent decrypts the encrypted content info with the content key.
+func decryptContent(info element, contentKey []byte) ([]byte, error) {
+	// contentType, contentEncryptionAlgorithm, [0] encryptedContent
+	fields, err := info.children()
+	if err != nil || len(fields) != 3 || !fields[1].is(classUniversal, tagSequence) || !fields[2].is(classContext, 0) {
+		return nil, errors.New("invalid encrypted content info")
+	}
+	algorithm, err := fields[1].children()
+	if err != nil || len(algorithm) != 2 {
+		return nil, errors.New("invalid content encryption algorithm")
+	}
+	oid, err := algorithm[0].oid()
+	if err != nil {
+		return nil, errors.New("invalid content encryption algorithm")
+	}
+	keySize := map[string]int{oidAES128CBC.String(): 16, oidAES192CBC.String(): 24, oidAES256CBC.String(): 32}[oid.String()]
+	if keySize == 0 {
+		return nil, fmt.Errorf("unsupported content encryption algorithm %v", oid)
+	}
+	if len(contentKey) != keySize {
+		return nil, fmt.Errorf("invalid content key of %d bytes", len(contentKey))
+	}
+	iv, err := algorithm[1].bytes()
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid content encryption IV")
+	}
+	content, err := fields[2].bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 || len(content)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted content length")
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(content))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, content)
+	// The content is padded as PKCS #7 does.
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("invalid content padding")
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// element is a BER encoded element.
+type element struct {
+	class       int
+	tag         int
+	constructed bool
+	// content is the value of primitive elements and the encoded
+	// elements of constructed ones.
+	content []byte
+}
+
+// next decodes the first element of data and returns it with the rest.
+func next(data []byte) (element, []byte, error) {
+	return nextAt(data, 0)
+}
+
+// nextAt decodes the first element of data nested in depth elements of
+// indefinite length.
+func nextAt(data []byte, depth int) (element, []byte, error) {
+	var e element
+	if depth > maxDepth {
+		return e, nil, errors.New("elements nested too deeply")
+	}
+	if len(data) < 2 {
+		return e, nil, errors.New("truncated element")
+	}
+	e.class = int(data[0] >> 6)
+	e.constructed = data[0]&0x20 != 0
+	e.tag = int(data[0] & 0x1f)
+	i := 1
+	if e.tag == 0x1f {
+		// High tag numbers follow in base 128.
+		e.tag = 0
+		for {
+			if i >= len(data) || i > 4 {
+				return e, nil, errors.New("invalid tag")
+			}
+			b := data[i]
+			i++
+			e.tag = e.tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	if i >= len(data) {
+		return e, nil, errors.New("truncated element")
+	}
+	length := int(data[i])
+	i++
+
+	switch {
+	case length == 0x80:
+		// The content of indefinite lengths ends with two zero bytes.
+		if !e.constructed {
+			return e, nil, errors.New("indefinite length of a primitive element")
+		}
+		rest := data[i:]
+		for {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				e.content = data[i : len(data)-len(rest)]
+				return e, rest[2:], nil
+			}
+			var err error
+			if _, rest, err = nextAt(rest, depth+1); err != nil {
+				return e, nil, err
+			}
+		}
+	case length > 0x80:
+		n := length & 0x7f
+		if n > 4 || i+n > len(data) {
+			return e, nil, errors.New("invalid length")
+		}
+		length = 0
+		for _, b := range data[i : i+n] {
+			length = length<<8 | int(b)
+		}
+		i += n
+	}
+	if length < 0 || length > len(data)-i {
+		return e, nil, errors.New("truncated element")
+	}
+	e.content = data[i : i+length]
+	return e, data[i+length:], nil
+}
+
+// is tells whether the element has the class and tag.
+func (e element) is(class, tag int) bool {
+	return e.class == class && e.tag == tag
+}
+
+// children decodes the elements of a constructed element.
+func (e element) children() ([]element, error) {
+	if !e.constructed {
+		return nil, errors.New("element is not constructed")
+	}
+	var children []element
+	for rest := e.content; len(rest) > 0; {
+		child, r, err := next(rest)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		rest = r
+	}
+	return children, nil
+}
+
+// bytes returns the value of an octet string, which BER may split into
+// constructed segments.
+func (e element) bytes() ([]byte, error) {
+	if !e.constructed {
+		return e.content, nil
+	}
+	children, err := e.children()
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	for _, child := range children {
+		if !child.is(classUniversal, tagOctetString) {
+			return nil, errors.New("invalid octet string segment")
+		}
+		segment, err := child.bytes()
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, segment...)
+	}
+	return value, nil
+}
+
+// oid decodes an object identifier.
+func (e element) oid() (asn1.ObjectIdentifier, error) {
+	if !e.is(classUniversal, tagOID) || e.constructed || len(e.content) >= 0x80 {
+		return nil, errors.New("invalid object identifier")
+	}
+	var oid asn1.ObjectIdentifier
+	_, err := asn1.Unmarshal(append([]byte{tagOID, byte(len(e.content))}, e.content...), &oid)
+	return oid, err
+}
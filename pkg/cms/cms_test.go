@@ -0,0 +1,101 @@
+package cms_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cms"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cms/cmstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	for _, encrypt := range []func([]byte, *rsa.PublicKey) ([]byte, error){cmstest.Encrypt, cmstest.EncryptDER} {
+		for _, content := range [][]byte{[]byte("s3cr3t"), bytes.Repeat([]byte("x"), 300)} {
+			data, err := encrypt(content, &key.PublicKey)
+			assert.Nil(t, err)
+			plaintext, err := cms.Decrypt(data, key)
+			assert.Nil(t, err)
+			assert.Equal(t, content, plaintext)
+
+			_, err = cms.Decrypt(data, other)
+			assert.Equal(t, cms.ErrNoRecipient, err)
+		}
+	}
+
+	_, err = cms.Decrypt([]byte("not cms"), key)
+	assert.NotNil(t, err)
+	data, err := cmstest.Encrypt([]byte("s3cr3t"), &key.PublicKey)
+	assert.Nil(t, err)
+	_, err = cms.Decrypt(data[:100], key)
+	assert.NotNil(t, err)
+}
+
+func TestDecryptMalformed(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	for name, data := range map[string][]byte{
+		"empty":                         nil,
+		"truncated header":              {0x30},
+		"truncated length":              {0x30, 0x84, 0x00, 0x00},
+		"truncated content":             {0x30, 0x05, 0x06, 0x01},
+		"oversized length":              {0x30, 0x84, 0xff, 0xff, 0xff, 0xff, 0x06, 0x00},
+		"too many length bytes":         {0x30, 0x85, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00},
+		"reserved length":               {0x30, 0xff, 0x00},
+		"invalid tag":                   {0x1f, 0xff, 0xff, 0xff, 0xff, 0x01, 0x00},
+		"truncated tag":                 {0x1f, 0x81},
+		"indefinite primitive":          {0x04, 0x80, 0x00, 0x00},
+		"indefinite without end":        {0x30, 0x80, 0x04, 0x01, 0x00},
+		"indefinite nested without end": {0x30, 0x80, 0x30, 0x80, 0x00, 0x00},
+		"oversized object identifier":   append([]byte{0x30, 0x81, 0x85, 0x06, 0x81, 0x80}, make([]byte, 0x82)...),
+		"primitive content info":        {0x04, 0x00},
+	} {
+		_, err := cms.Decrypt(data, key)
+		assert.NotNil(t, err, name)
+	}
+
+	// Elements of indefinite length are not nested without bounds.
+	nested := append(bytes.Repeat([]byte{0x30, 0x80}, 100000), make([]byte, 200000)...)
+	_, err = cms.Decrypt(nested, key)
+	assert.EqualError(t, err, "elements nested too deeply")
+
+	// Every truncation of valid enveloped data is rejected.
+	for _, encrypt := range []func([]byte, *rsa.PublicKey) ([]byte, error){cmstest.Encrypt, cmstest.EncryptDER} {
+		data, err := encrypt([]byte("s3cr3t"), &key.PublicKey)
+		assert.Nil(t, err)
+		for i := range data {
+			_, err := cms.Decrypt(data[:i], key)
+			assert.NotNil(t, err, "truncated to %d bytes", i)
+		}
+	}
+}
+
+func FuzzDecrypt(f *testing.F) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, encrypt := range []func([]byte, *rsa.PublicKey) ([]byte, error){cmstest.Encrypt, cmstest.EncryptDER} {
+		data, err := encrypt([]byte("s3cr3t"), &key.PublicKey)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{0x30, 0x80, 0x30, 0x80, 0x00, 0x00, 0x00, 0x00})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Arbitrary input must be rejected or decrypted, never panic.
+		if plaintext, err := cms.Decrypt(data, key); err == nil && plaintext == nil {
+			t.Errorf("decrypted %x to no content", data)
+		}
+	})
+}
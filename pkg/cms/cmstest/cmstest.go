@@ -0,0 +1,104 @@
+// Package cmstest encrypts enveloped data to RSA keys like KMS does for the
+// recipients of attested requests, for the tests of their decryption.
+package cmstest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidRSAESOAEP     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// Encrypt encrypts content to the key as enveloped data encoded in BER, with
+// indefinite lengths and the encrypted content in segments, as KMS does.
+func Encrypt(content []byte, key *rsa.PublicKey) ([]byte, error) {
+	return encrypt(content, key, true)
+}
+
+// EncryptDER encrypts content to the key as enveloped data encoded in DER.
+func EncryptDER(content []byte, key *rsa.PublicKey) ([]byte, error) {
+	return encrypt(content, key, false)
+}
+
+func encrypt(content []byte, key *rsa.PublicKey, ber bool) ([]byte, error) {
+	contentKey := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	padded := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, contentKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	constructed := definite
+	encryptedContent := definite(0x80, encrypted)
+	if ber {
+		constructed = indefinite
+		half := len(encrypted) / 2
+		encryptedContent = indefinite(0xa0, definite(0x04, encrypted[:half]), definite(0x04, encrypted[half:]))
+	}
+	oaep := constructed(0x30, oid(oidRSAESOAEP),
+		constructed(0x30, constructed(0xa0, constructed(0x30, oid(oidSHA256)))))
+	recipient := constructed(0x30,
+		definite(0x02, []byte{2}),
+		definite(0x80, []byte("subject key identifier")),
+		oaep,
+		definite(0x04, encryptedKey))
+	envelopedData := constructed(0x30,
+		definite(0x02, []byte{2}),
+		constructed(0x31, recipient),
+		constructed(0x30,
+			oid(oidData),
+			constructed(0x30, oid(oidAES256CBC), definite(0x04, iv)),
+			encryptedContent))
+	return constructed(0x30, oid(oidEnvelopedData), constructed(0xa0, envelopedData)), nil
+}
+
+// definite encodes an element of definite length.
+func definite(tag byte, content ...[]byte) []byte {
+	value := bytes.Join(content, nil)
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n < 0x100:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+// indefinite encodes a constructed element of indefinite length.
+func indefinite(tag byte, content ...[]byte) []byte {
+	out := append([]byte{tag, 0x80}, bytes.Join(content, nil)...)
+	return append(out, 0, 0)
+}
+
+func oid(id asn1.ObjectIdentifier) []byte {
+	der, _ := asn1.Marshal(id)
+	return der
+}
@@ -0,0 +1,94 @@
+// Package logging configures the logger shared by the binaries of the
+// project: the virtual-kubelet logger, backed by logrus, with a configurable
+// level and format. The output of the standard library logger, still used by
+// some dependencies, goes through it too.
+package logging
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	stdlog "log"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	logruslogger "github.com/virtual-kubelet/virtual-kubelet/log/logrus"
+)
+
+// Formats of the log entries.
+const (
+	// FormatConsole writes entries as human readable lines.
+	FormatConsole = "console"
+	// FormatJSON writes entries as JSON objects, one per line.
+	FormatJSON = "json"
+)
+
+// Config configures the logger.
+type Config struct {
+	// Level is the lowest level logged, such as "debug", "info", "warn" or
+	// "error".
+	Level string
+	// Format is the format of the entries, FormatConsole or FormatJSON.
+	Format string
+}
+
+// DefaultConfig logs info entries and above as console lines.
+func DefaultConfig() Config {
+	return Config{Level: "info", Format: FormatConsole}
+}
+
+// AddFlags adds the -log-level and -log-format flags setting the config.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Level, "log-level", c.Level, `set the log level, e.g. "debug", "info", "warn", "error"`)
+	fs.StringVar(&c.Format, "log-format", c.Format, `set the log format, "console" or "json"`)
+}
+
+// Setup configures the logger, and makes it the virtual-kubelet logger and
+// the output of the standard library logger.
+func Setup(c Config) error {
+	logger := logrus.StandardLogger()
+	if c.Level != "" {
+		lvl, err := logrus.ParseLevel(c.Level)
+		if err != nil {
+			return errors.Wrap(err, "could not parse log level")
+		}
+		logger.SetLevel(lvl)
+	}
+	switch c.Format {
+	case "", FormatConsole:
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case FormatJSON:
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return errors.Errorf("unsupported log format %q, must be %q or %q", c.Format, FormatConsole, FormatJSON)
+	}
+
+	log.L = logruslogger.FromLogrus(logrus.NewEntry(logger))
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(logger.WriterLevel(logrus.InfoLevel))
+	return nil
+}
+
+// Writer returns a writer logging every line written to it with logf, such
+// as the Debug method of a logger, for the output of commands.
+func Writer(logf func(...interface{})) io.Writer {
+	return &lineWriter{logf: logf}
+}
+
+type lineWriter struct {
+	logf func(...interface{})
+	buf  []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.logf(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+}
@@ -0,0 +1,30 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+func TestSetup(t *testing.T) {
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+	var out bytes.Buffer
+	logrus.SetOutput(&out)
+
+	assert.Nil(t, Setup(Config{Level: "warn", Format: FormatJSON}))
+	log.L.Info("dropped")
+	log.L.WithField("pod", "default/nginx").Warn("kept")
+
+	var entry map[string]string
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "kept", entry["msg"])
+	assert.Equal(t, "warning", entry["level"])
+	assert.Equal(t, "default/nginx", entry["pod"])
+
+	assert.NotNil(t, Setup(Config{Level: "loud"}))
+	assert.NotNil(t, Setup(Config{Format: "xml"}))
+}
@@ -0,0 +1,93 @@
+// Package metrics holds the Prometheus metrics of the operation of the
+// provider, served on its metrics address.
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "nitro_enclave_kubelet"
+
+var (
+	// Registry holds the metrics of the provider and of its process.
+	Registry = prometheus.NewRegistry()
+
+	// CreatePodDuration is the latency of the pod creations, by result:
+	// created, rejected or failed.
+	CreatePodDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "create_pod_duration_seconds",
+		Help:      "Latency of the pod creations by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"result"})
+
+	// EIFBuildDuration is the duration of the successful EIF builds.
+	EIFBuildDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "eif_build_duration_seconds",
+		Help:      "Duration of the successful enclave image builds.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	// EIFBuildFailures counts the failed EIF builds by the stage which failed.
+	EIFBuildFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "eif_build_failures_total",
+		Help:      "Failed enclave image builds by failed stage.",
+	}, []string{"stage"})
+
+	// EnclaveLaunchFailures counts the failed enclave launches by nitro-cli
+	// error code, unknown when nitro-cli printed none.
+	EnclaveLaunchFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "enclave_launch_failures_total",
+		Help:      "Failed enclave launches by nitro-cli error code.",
+	}, []string{"code"})
+
+	// ProxyErrors counts the connections and datagrams the proxies of
+	// enclaves failed to forward, by proxy: port, forward, sni, udp, egress
+	// or dns.
+	ProxyErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_errors_total",
+		Help:      "Connections and datagrams the enclave proxies failed to forward, by proxy.",
+	}, []string{"proxy"})
+
+	runningEnclaves   func() int
+	runningEnclavesMu sync.Mutex
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CreatePodDuration,
+		EIFBuildDuration,
+		EIFBuildFailures,
+		EnclaveLaunchFailures,
+		ProxyErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "running_enclaves",
+			Help:      "Enclaves running the pods of the node.",
+		}, func() float64 {
+			runningEnclavesMu.Lock()
+			count := runningEnclaves
+			runningEnclavesMu.Unlock()
+			if count == nil {
+				return 0
+			}
+			return float64(count())
+		}),
+	)
+}
+
+// CountRunningEnclaves sets the function counting the running enclaves.
+func CountRunningEnclaves(count func() int) {
+	runningEnclavesMu.Lock()
+	defer runningEnclavesMu.Unlock()
+	runningEnclaves = count
+}
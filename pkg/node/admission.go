@@ -0,0 +1,177 @@
+package node
+
+import (
+	"context"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Reasons of the rejections of pods whose spec enclaves cannot run.
+	AdmissionReasonDaemonSet          = "UnsupportedDaemonSet"
+	AdmissionReasonMultipleContainers = "UnsupportedMultipleContainers"
+	AdmissionReasonInitContainers     = "UnsupportedInitContainers"
+	AdmissionReasonHostNamespace      = "UnsupportedHostNamespace"
+	AdmissionReasonVolume             = "UnsupportedVolume"
+	AdmissionReasonProbe              = "UnsupportedProbe"
+	AdmissionReasonInvalidAnnotation  = "InvalidAnnotation"
+
+	// PodAdmitted is a pod condition set on the pods the node rejects, which
+	// fail for good, telling why.
+	PodAdmitted corev1.PodConditionType = annotationPrefix + "admitted"
+)
+
+// unsupportedf returns the terminal AdmissionError of a pod enclaves cannot run.
+func unsupportedf(reason, format string, args ...interface{}) *AdmissionError {
+	err := admissionErrorf(reason, format, args...)
+	err.Terminal = true
+	return err
+}
+
+// validatePod rejects the pods whose spec enclaves cannot run, whose
+// annotations are invalid, or which the policies of the node forbid, with a
+// terminal AdmissionError before anything is built for them.
+func validatePod(node *Node, pod *corev1.Pod) error {
+	if err := ValidatePod(pod); err != nil {
+		return err
+	}
+	if node != nil {
+		allowed, denied := node.namespaces()
+		if err := namespaceError(allowed, denied, pod); err != nil {
+			return err
+		}
+		allowed, denied = node.images()
+		if err := imageError(allowed, denied, pod); err != nil {
+			return err
+		}
+		if err := runtimeClassError(node.runtimeClass(), pod); err != nil {
+			return err
+		}
+		if err := taintError(node.dedicatedTaint, pod); err != nil {
+			return err
+		}
+		if node.enclaveResourcesRequired() {
+			if err := accountedError(pod, 0, 0, true); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := podProfile(node, pod); err != nil {
+		return unsupportedf(AdmissionReasonInvalidAnnotation, "%v", err)
+	}
+	if _, err := debugMode(node, pod); err != nil {
+		return unsupportedf(AdmissionReasonInvalidAnnotation, "%v", err)
+	}
+	return nil
+}
+
+// ValidatePod rejects the pods whose spec enclaves cannot run, or whose
+// annotations are invalid, with a terminal AdmissionError. Unlike the node,
+// it does not apply the policies of nodes, so pods can be checked before
+// they are scheduled.
+func ValidatePod(pod *corev1.Pod) error {
+	if IsOwnedByDaemonSet(pod) {
+		return unsupportedf(AdmissionReasonDaemonSet, "daemonsets are not supported")
+	}
+	if len(pod.Spec.Containers) > 1 {
+		return unsupportedf(AdmissionReasonMultipleContainers, "launching more than 1 container is unsupported")
+	}
+	if len(pod.Spec.InitContainers) > 0 {
+		return unsupportedf(AdmissionReasonInitContainers, "init containers are unsupported")
+	}
+	if pod.Spec.HostNetwork || pod.Spec.HostPID || pod.Spec.HostIPC {
+		return unsupportedf(AdmissionReasonHostNamespace, "enclaves cannot share the namespaces of the host")
+	}
+	for _, v := range pod.Spec.Volumes {
+		// Enclaves have no disk, only memory backed volumes and volumes pushed by the node work.
+		if v.EmptyDir == nil && v.Projected == nil {
+			return unsupportedf(AdmissionReasonVolume, "volume %q is unsupported, only emptyDir and projected volumes are", v.Name)
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, probe := range []*corev1.Probe{c.LivenessProbe, c.ReadinessProbe, c.StartupProbe} {
+			if probe != nil && probe.HTTPGet == nil && probe.TCPSocket == nil && probe.Exec == nil {
+				return unsupportedf(AdmissionReasonProbe, "probes of container %q are unsupported, only httpGet, tcpSocket and exec probes are", c.Name)
+			}
+		}
+	}
+
+	validators := []func() error{
+		func() error { _, err := egressAllowlist(pod); return err },
+		func() error { _, err := kmsProxyConfig(pod); return err },
+		func() error { _, err := dnsConfig(pod); return err },
+		func() error { _, err := proxyLimits(pod); return err },
+		func() error { _, err := sniHostnames(pod); return err },
+		func() error { _, err := requestedCID(pod); return err },
+		func() error { _, _, err := raTLSNames(pod); return err },
+		func() error { _, _, err := expectedMeasurements(pod); return err },
+		func() error { _, err := kmsSecretNames(pod); return err },
+		func() error { _, err := vaultConfig(pod); return err },
+	}
+	for _, validate := range validators {
+		if err := validate(); err != nil {
+			return unsupportedf(AdmissionReasonInvalidAnnotation, "%v", err)
+		}
+	}
+	return nil
+}
+
+// EnclaveMemoryMiB returns the memory of the enclave running a pod in MiB:
+// that it requests as the ResourceEnclaveMemoryMiB extended resource,
+// otherwise that of its containers, of its emptyDir volumes and of the
+// overhead of its runtime class.
+func EnclaveMemoryMiB(pod *corev1.Pod) int64 {
+	if _, memory := accountedResources(pod); memory > 0 {
+		return memory
+	}
+	_, memory := podOverhead(pod)
+	for i := range pod.Spec.Containers {
+		spec := &pod.Spec.Containers[i]
+		cntr, err := newContainer(spec)
+		if err != nil {
+			continue
+		}
+		memory += cntr.definition.Memory
+		for _, m := range emptyDirMounts(pod, spec) {
+			memory += m.SizeMiB
+		}
+	}
+	return memory
+}
+
+// RejectPod records a pod the node rejected for good as failed, so it is not
+// created again and its controller replaces it. The rejection is reported by
+// the PodAdmitted condition of the pod and a warning event.
+func (n *Node) RejectPod(ctx context.Context, spec *corev1.Pod, rejection *AdmissionError) {
+	pod := &Pod{
+		namespace:  spec.Namespace,
+		name:       spec.Name,
+		uid:        spec.UID,
+		node:       n,
+		containers: make(map[string]*container),
+		pod:        spec.DeepCopy(),
+		startTime:  metav1.Now(),
+		rejection:  rejection,
+	}
+	pod.tag = pod.buildEnclaveNameTag()
+	n.InsertPod(pod, pod.tag)
+
+	log.G(ctx).Infof("Rejected pod %s/%s: %s", pod.namespace, pod.name, rejection.Message)
+	pod.warning(rejection.Reason, "%s", rejection.Message)
+	pod.setPhase(ctx, corev1.PodFailed, rejection.Reason, rejection.Message)
+}
+
+// admittedCondition reports why the node rejected the pod. The caller holds pod.mu.
+func (pod *Pod) admittedCondition() []corev1.PodCondition {
+	if pod.rejection == nil {
+		return nil
+	}
+	return []corev1.PodCondition{{
+		Type:    PodAdmitted,
+		Status:  corev1.ConditionFalse,
+		Reason:  pod.rejection.Reason,
+		Message: pod.rejection.Message,
+	}}
+}
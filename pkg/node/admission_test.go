@@ -0,0 +1,69 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidatePod(t *testing.T) {
+	newPod := func(mutate func(*corev1.Pod)) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "web"}}},
+		}
+		mutate(pod)
+		return pod
+	}
+	for reason, pod := range map[string]*corev1.Pod{
+		AdmissionReasonMultipleContainers: newPod(func(p *corev1.Pod) {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "sidecar"})
+		}),
+		AdmissionReasonVolume: newPod(func(p *corev1.Pod) {
+			p.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: "/data"},
+			}}}
+		}),
+		AdmissionReasonProbe: newPod(func(p *corev1.Pod) {
+			p.Spec.Containers[0].LivenessProbe = &corev1.Probe{ProbeHandler: corev1.ProbeHandler{GRPC: &corev1.GRPCAction{Port: 8080}}}
+		}),
+		AdmissionReasonInvalidAnnotation: newPod(func(p *corev1.Pod) {
+			p.Annotations = map[string]string{CIDAnnotation: "3"}
+		}),
+	} {
+		err := validatePod(nil, pod)
+		var rejection *AdmissionError
+		if assert.ErrorAs(t, err, &rejection, reason) {
+			assert.Equal(t, reason, rejection.Reason)
+			assert.True(t, rejection.Terminal)
+		}
+	}
+	assert.Nil(t, validatePod(nil, newPod(func(*corev1.Pod) {})))
+}
+
+func TestRejectPod(t *testing.T) {
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+	spec := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"}}
+	n.RejectPod(context.Background(), spec, unsupportedf(AdmissionReasonInitContainers, "init containers are unsupported"))
+
+	pod, err := n.GetPodByUID("default", "web", "1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	status := pod.GetStatus()
+	assert.Equal(t, corev1.PodFailed, status.Phase)
+	assert.Equal(t, AdmissionReasonInitContainers, status.Reason)
+	var admitted *corev1.PodCondition
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == PodAdmitted {
+			admitted = &status.Conditions[i]
+		}
+	}
+	if assert.NotNil(t, admitted) {
+		assert.Equal(t, corev1.ConditionFalse, admitted.Status)
+		assert.Equal(t, AdmissionReasonInitContainers, admitted.Reason)
+	}
+}
@@ -0,0 +1,188 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ModuleIDAnnotation is the ID of the Nitro Secure Module of the pod's enclave.
+	ModuleIDAnnotation = annotationPrefix + "module-id"
+	// AttestationTimeAnnotation is when the published attestation document was signed.
+	AttestationTimeAnnotation = annotationPrefix + "attestation-time"
+	// pcrAnnotationPrefix prefixes the annotations of the published PCRs, followed by their index.
+	pcrAnnotationPrefix = annotationPrefix + "pcr"
+
+	// imageIDPrefix prefixes the PCR0 of enclave images reported as image IDs.
+	imageIDPrefix = "sha384:"
+
+	// attestationTimeout bounds how long the agent is waited for to serve an attestation document.
+	attestationTimeout = time.Minute
+	// attestationRetry is the delay between attempts to reach the agent.
+	attestationRetry = time.Second
+	// attestationRequestTimeout bounds a single attestation request.
+	attestationRequestTimeout = 5 * time.Second
+
+	// MaxAttestationNonce and MaxAttestationUserData bound the nonce and
+	// user data the Nitro Secure Module includes in attestation documents.
+	MaxAttestationNonce    = 512
+	MaxAttestationUserData = 512
+)
+
+// publishedPCRs are the PCRs published as pod annotations: the enclave
+// image, kernel, application, parent instance ID, parent IAM role and signing certificate.
+var publishedPCRs = []uint{0, 1, 2, 3, 4, 8}
+
+// PCRAnnotation returns the pod annotation holding a PCR of the pod's enclave.
+func PCRAnnotation(index uint) string {
+	return pcrAnnotationPrefix + strconv.FormatUint(uint64(index), 10)
+}
+
+// publishAttestation requests an attestation document from the agent of the
+// enclave and publishes its measurements as annotations of the pod, so
+// clients can tell what the enclave runs.
+func (pod *Pod) publishAttestation(ctx context.Context, cid uint32) error {
+	if pod.node == nil || pod.node.client == nil {
+		return nil
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := requestAttestation(ctx, cid, nonce, nil)
+	if err != nil {
+		return err
+	}
+	doc, err := attestation.Parse(data)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(doc.Nonce, nonce) {
+		return fmt.Errorf("attestation document does not include the nonce")
+	}
+
+	// The enclave image is identified by its measurement, unless zeroed in debug mode.
+	if pcr0 := doc.PCR(0); strings.Trim(pcr0, "0") != "" {
+		pod.mu.Lock()
+		pod.imageID = imageIDPrefix + pcr0
+		pod.mu.Unlock()
+		pod.notify(ctx)
+	}
+
+	// The UID makes the patch fail rather than annotate a recreated pod.
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":         pod.uid,
+			"annotations": attestationAnnotations(doc),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = pod.node.client.CoreV1().Pods(pod.namespace).Patch(ctx, pod.name, k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate pod: %v", err)
+	}
+	pod.event(corev1.EventTypeNormal, eventReasonAttested, "Published attestation of enclave module %s", doc.ModuleID)
+	return nil
+}
+
+// requestAttestation requests an attestation document including the nonce
+// and user data from the agent, retrying while it starts.
+func requestAttestation(ctx context.Context, cid uint32, nonce, userData []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, attestationTimeout)
+	defer cancel()
+
+	for {
+		conn, err := dialAgent(int(cid), agent.AttestPort)
+		if err == nil {
+			var data []byte
+			data, err = agent.RequestAttestation(conn, nonce, userData, attestationRequestTimeout)
+			conn.Close()
+			if err == nil {
+				return data, nil
+			}
+		}
+		log.G(ctx).Debugf("failed to request attestation document from enclave %d: %v", cid, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to request attestation document: %v", err)
+		case <-time.After(attestationRetry):
+		}
+	}
+}
+
+// Attest requests a fresh attestation document from the enclave of a pod,
+// including the nonce and user data of the caller, so verifiers can
+// challenge the live enclave. The enclave must be running.
+func (n *Node) Attest(ctx context.Context, namespace, name string, nonce, userData []byte) ([]byte, error) {
+	if len(nonce) == 0 {
+		return nil, errdefs.InvalidInput("a nonce is required")
+	}
+	if len(nonce) > MaxAttestationNonce || len(userData) > MaxAttestationUserData {
+		return nil, errdefs.InvalidInputf("the nonce and user data are limited to %d and %d bytes", MaxAttestationNonce, MaxAttestationUserData)
+	}
+	pod, err := n.GetPod(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	pod.mu.RLock()
+	cid := pod.info.EnclaveCID
+	running := pod.state == containerRunning
+	pod.mu.RUnlock()
+	if !running {
+		return nil, errdefs.InvalidInputf("enclave of pod %s/%s is not running", namespace, name)
+	}
+
+	conn, err := dialAgent(cid, agent.AttestPort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the enclave agent: %v", err)
+	}
+	defer conn.Close()
+	data, err := agent.RequestAttestation(conn, nonce, userData, attestationRequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verifiers check the signature, the node only checks the document answers the request.
+	doc, err := attestation.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(doc.Nonce, nonce) || !bytes.Equal(doc.UserData, userData) {
+		return nil, fmt.Errorf("attestation document does not include the nonce and user data")
+	}
+	log.G(ctx).Infof("Attested enclave of pod %s/%s with module %s", namespace, name, doc.ModuleID)
+	return data, nil
+}
+
+// attestationAnnotations returns the pod annotations publishing an attestation document.
+func attestationAnnotations(doc *attestation.Document) map[string]string {
+	annotations := map[string]string{
+		ModuleIDAnnotation:        doc.ModuleID,
+		AttestationTimeAnnotation: doc.Time().Format(time.RFC3339Nano),
+	}
+	for _, index := range publishedPCRs {
+		if pcr := doc.PCR(index); pcr != "" {
+			annotations[PCRAnnotation(index)] = pcr
+		}
+	}
+	return annotations
+}
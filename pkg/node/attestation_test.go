@@ -0,0 +1,43 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+)
+
+func TestAttestationAnnotations(t *testing.T) {
+	doc := &attestation.Document{
+		ModuleID:  "i-0123456789abcdef0-enc0123456789abcdef",
+		Timestamp: 1700000000123,
+		PCRs:      map[uint][]byte{0: {0xab, 0xcd}, 2: {0x01}, 15: {0xff}},
+	}
+	assert.Equal(t, map[string]string{
+		ModuleIDAnnotation:        "i-0123456789abcdef0-enc0123456789abcdef",
+		AttestationTimeAnnotation: "2023-11-14T22:13:20.123Z",
+		PCRAnnotation(0):          "abcd",
+		PCRAnnotation(2):          "01",
+	}, attestationAnnotations(doc))
+	assert.Equal(t, "nitro-enclave-kubelet.brave.com/pcr8", PCRAnnotation(8))
+}
+
+func TestAttest(t *testing.T) {
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+	pod := &Pod{namespace: "default", name: "web", uid: "1", node: n, containers: make(map[string]*container)}
+	pod.tag = pod.buildEnclaveNameTag()
+	n.InsertPod(pod, pod.tag)
+
+	_, err := n.Attest(context.Background(), "default", "web", nil, nil)
+	assert.True(t, errdefs.IsInvalidInput(err))
+	_, err = n.Attest(context.Background(), "default", "web", make([]byte, MaxAttestationNonce+1), nil)
+	assert.True(t, errdefs.IsInvalidInput(err))
+	_, err = n.Attest(context.Background(), "default", "other", []byte("nonce"), nil)
+	assert.True(t, errdefs.IsNotFound(err))
+
+	// Only running enclaves are attested.
+	_, err = n.Attest(context.Background(), "default", "web", []byte("nonce"), nil)
+	assert.EqualError(t, err, "enclave of pod default/web is not running")
+}
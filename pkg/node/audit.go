@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// auditActor is the actor of the operations the node drives on its own,
+// named after the node as the kubelet authenticates to the API server.
+func (n *Node) auditActor() string {
+	return "system:node:" + n.name
+}
+
+// AuditRequest records a request of the kubelet API on the enclave of a pod
+// in the audit log, along with what the enclave runs. Log requests are
+// recorded as console requests for enclaves in debug mode, whose logs are
+// their console output.
+func (n *Node) AuditRequest(ctx context.Context, record audit.Record) {
+	if n.audit == nil {
+		return
+	}
+	pod, err := n.GetPod(record.Namespace, record.Pod)
+	if err != nil {
+		// The request fails, but is recorded nonetheless.
+		record.Node = n.name
+		if err := n.audit.Record(record); err != nil {
+			log.G(ctx).Errorf("Failed to record %s request in the audit log: %v", record.Operation, err)
+		}
+		return
+	}
+	pod.mu.RLock()
+	debug := pod.config.DebugMode
+	pod.mu.RUnlock()
+	if record.Operation == audit.OperationLogs && debug {
+		record.Operation = audit.OperationConsole
+	}
+	pod.audit(ctx, record)
+}
+
+// audit records an operation on the enclave of the pod in the audit log of
+// its node, if any, completed with the pod, its image and measurements. The
+// node is the actor unless the record has one.
+func (pod *Pod) audit(ctx context.Context, record audit.Record) {
+	if pod.node == nil || pod.node.audit == nil {
+		return
+	}
+	pod.mu.RLock()
+	spec := pod.pod
+	enclaveID := pod.info.EnclaveID
+	debug := pod.config.DebugMode
+	pcrs := pod.pcrs
+	pod.mu.RUnlock()
+
+	if record.Actor == "" {
+		record.Actor = pod.node.auditActor()
+	}
+	record.Node = pod.node.name
+	record.Namespace = pod.namespace
+	record.Pod = pod.name
+	record.UID = string(pod.uid)
+	if record.EnclaveID == "" {
+		record.EnclaveID = enclaveID
+	}
+	record.DebugMode = debug
+	record.PCRs = pcrs
+	if spec != nil {
+		record.ServiceAccount = spec.Spec.ServiceAccountName
+		if len(spec.Spec.Containers) > 0 {
+			record.Image = spec.Spec.Containers[0].Image
+			record.ImageDigest = imageDigest(record.Image)
+		}
+	}
+	if err := pod.node.audit.Record(record); err != nil {
+		log.G(ctx).Errorf("Failed to record %s of enclave in the audit log: %v", record.Operation, err)
+	}
+}
+
+// auditBuild records the build of the enclave image of the pod, with its
+// measurements, and err when the image may not be launched.
+func (pod *Pod) auditBuild(ctx context.Context, describe func() (*cli.EifInfo, error), err error) {
+	if pod.node == nil || pod.node.audit == nil {
+		return
+	}
+	if info, err := describe(); err != nil {
+		log.G(ctx).Warnf("Failed to measure enclave image for the audit log: %v", err)
+	} else {
+		pcrs := map[uint]string{0: info.Measurements.Pcr0, 1: info.Measurements.Pcr1, 2: info.Measurements.Pcr2}
+		if info.Measurements.Pcr8 != "" {
+			pcrs[8] = info.Measurements.Pcr8
+		}
+		pod.mu.Lock()
+		pod.pcrs = pcrs
+		pod.mu.Unlock()
+	}
+	pod.audit(ctx, audit.Record{Operation: audit.OperationBuild, Error: auditError(err)})
+}
+
+// auditError returns the error of an audit record, empty when err is nil.
+func auditError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// imageDigest returns the digest of a container image reference pinning
+// it, such as repository@sha256:..., empty otherwise.
+func imageDigest(image string) string {
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return digest
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package node
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := audit.Open(path, nil)
+	assert.Nil(t, err)
+	n := &Node{name: "node-1", pods: make(map[string]*Pod), current: make(map[string]string), audit: log}
+	spec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: corev1.PodSpec{ServiceAccountName: "web", Containers: []corev1.Container{
+			{Name: "web", Image: "registry.example.com/web@sha256:abcd"},
+		}},
+	}
+	pod := &Pod{namespace: "default", name: "web", uid: "1", node: n, pod: spec, containers: make(map[string]*container)}
+	pod.tag = pod.buildEnclaveNameTag()
+	n.InsertPod(pod, pod.tag)
+
+	info := &cli.EifInfo{}
+	info.Measurements.Pcr0 = "00"
+	info.Measurements.Pcr1 = "01"
+	info.Measurements.Pcr2 = "02"
+	pod.auditBuild(context.Background(), func() (*cli.EifInfo, error) { return info, nil }, nil)
+	pod.info.EnclaveID = "i-1-enc-1"
+	pod.config.DebugMode = true
+	n.AuditRequest(context.Background(), audit.Record{Operation: audit.OperationLogs, Actor: "alice", Namespace: "default", Pod: "web", Container: "web"})
+	n.AuditRequest(context.Background(), audit.Record{Operation: audit.OperationExec, Actor: "bob", Namespace: "default", Pod: "gone", Error: "forbidden"})
+	assert.Nil(t, log.Close())
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	var records []audit.Record
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		var r audit.Record
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &r))
+		r.Time = r.Time.UTC()
+		records = append(records, r)
+	}
+	assert.Len(t, records, 3)
+
+	build := records[0]
+	assert.Equal(t, audit.OperationBuild, build.Operation)
+	assert.Equal(t, "system:node:node-1", build.Actor)
+	assert.Equal(t, "web", build.ServiceAccount)
+	assert.Equal(t, "sha256:abcd", build.ImageDigest)
+	assert.Equal(t, map[uint]string{0: "00", 1: "01", 2: "02"}, build.PCRs)
+
+	// The logs of enclaves in debug mode are their console.
+	console := records[1]
+	assert.Equal(t, audit.OperationConsole, console.Operation)
+	assert.Equal(t, "alice", console.Actor)
+	assert.Equal(t, "i-1-enc-1", console.EnclaveID)
+	assert.True(t, console.DebugMode)
+	assert.Equal(t, build.PCRs, console.PCRs)
+
+	// Requests on unknown pods are recorded as they are.
+	assert.Equal(t, audit.Record{Time: records[2].Time, Operation: audit.OperationExec, Actor: "bob", Node: "node-1",
+		Namespace: "default", Pod: "gone", Error: "forbidden"}, records[2])
+}
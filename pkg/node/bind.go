@@ -0,0 +1,69 @@
+package node
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	// BindAddressAnnotation is the host address the port proxies of a pod
+	// listen on, overriding the node's bind address. It is an IPv4 or IPv6
+	// address, such as one assigned to the pod, or one of the BindAny,
+	// BindInternalIP and BindLocalhost keywords.
+	BindAddressAnnotation = annotationPrefix + "bind-address"
+
+	// BindAny binds the port proxies to every host interface, of both IP families.
+	BindAny = "any"
+	// BindInternalIP binds the port proxies to the node's internal IPs, IPv4 and IPv6.
+	BindInternalIP = "internal-ip"
+	// BindLocalhost binds the port proxies to the loopback interface.
+	BindLocalhost = "localhost"
+)
+
+// resolveBindAddresses returns the IP addresses a bind address designates,
+// given the internal IPs of the node. An empty address stands for every
+// address, which Go listens on dual-stack when the host supports it.
+func resolveBindAddresses(value string, internalIPs []string) ([]string, error) {
+	switch value {
+	case "", BindAny:
+		return []string{""}, nil
+	case BindInternalIP:
+		if len(internalIPs) == 0 {
+			return nil, fmt.Errorf("the node has no internal IP to bind to")
+		}
+		return internalIPs, nil
+	case BindLocalhost:
+		// IPv6-only hosts have no IPv4 loopback.
+		if len(internalIPs) > 0 && !isIPv4(internalIPs[0]) {
+			return []string{"::1"}, nil
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+	if net.ParseIP(value) == nil {
+		return nil, fmt.Errorf("invalid bind address %q, expected an IP address, %q, %q or %q", value, BindAny, BindInternalIP, BindLocalhost)
+	}
+	return []string{value}, nil
+}
+
+// isIPv4 tells whether an address is an IPv4 address.
+func isIPv4(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() != nil
+}
+
+// bindAddresses returns the host addresses the port proxies of the pod listen on.
+func (pod *Pod) bindAddresses() ([]string, error) {
+	var value string
+	var internalIPs []string
+	if pod.node != nil {
+		value, internalIPs = pod.node.bindAddress, pod.node.internalIPs()
+	}
+	pod.mu.RLock()
+	if pod.pod != nil {
+		if annotation, ok := pod.pod.Annotations[BindAddressAnnotation]; ok {
+			value = annotation
+		}
+	}
+	pod.mu.RUnlock()
+	return resolveBindAddresses(value, internalIPs)
+}
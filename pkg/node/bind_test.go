@@ -0,0 +1,67 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBindAddresses(t *testing.T) {
+	pod := &Pod{
+		node: &Node{ip: "10.0.0.5"},
+		pod:  &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}},
+	}
+
+	// Every address, of both families.
+	addresses, err := pod.bindAddresses()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{""}, addresses)
+
+	pod.node.bindAddress = BindInternalIP
+	addresses, err = pod.bindAddresses()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.5"}, addresses)
+
+	// Dual-stack nodes bind both their internal IPs.
+	pod.node.ipv6 = "fd00::5"
+	addresses, err = pod.bindAddresses()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.5", "fd00::5"}, addresses)
+
+	// Pods override the node's bind address.
+	pod.pod.Annotations[BindAddressAnnotation] = BindLocalhost
+	addresses, err = pod.bindAddresses()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addresses)
+
+	pod.pod.Annotations[BindAddressAnnotation] = "10.0.0.42"
+	addresses, err = pod.bindAddresses()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.42"}, addresses)
+
+	pod.pod.Annotations[BindAddressAnnotation] = "fd00::42"
+	addresses, err = pod.bindAddresses()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fd00::42"}, addresses)
+
+	pod.pod.Annotations[BindAddressAnnotation] = "eth0"
+	_, err = pod.bindAddresses()
+	assert.NotNil(t, err)
+
+	_, err = resolveBindAddresses(BindInternalIP, nil)
+	assert.EqualError(t, err, "the node has no internal IP to bind to")
+
+	// IPv6-only nodes have no IPv4 loopback.
+	addresses, err = resolveBindAddresses(BindLocalhost, []string{"fd00::5"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"::1"}, addresses)
+}
+
+func TestPodIPs(t *testing.T) {
+	pod := &Pod{node: &Node{ip: "10.0.0.5", ipv6: "fd00::5"}, phase: corev1.PodPending}
+	status := pod.GetStatus()
+	assert.Equal(t, "10.0.0.5", status.PodIP)
+	assert.Equal(t, []corev1.PodIP{{IP: "10.0.0.5"}, {IP: "fd00::5"}}, status.PodIPs)
+}
@@ -0,0 +1,211 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// firstEnclaveCID is the lowest CID given to enclaves, lower CIDs are reserved.
+	firstEnclaveCID = 16
+	// lastEnclaveCID bounds the CIDs given to enclaves, so the host vsock
+	// ports derived from them by the agent protocol do not overlap.
+	lastEnclaveCID = agent.EventPortBase - agent.LogPortBase - 1
+
+	// ResourceEnclaveMemoryMiB is the extended resource of the hugepage memory
+	// of enclaves, in MiB. Pods requesting it get as much enclave memory.
+	ResourceEnclaveMemoryMiB corev1.ResourceName = "aws.ec2.nitro/enclave_memory_mib"
+
+	// Reasons of the admission errors of pods the enclave pools cannot fit.
+	AdmissionReasonOutOfMemory = "OutOfMemory"
+	AdmissionReasonOutOfCPU    = "OutOfCPU"
+)
+
+// AdmissionError rejects a pod the node cannot run.
+type AdmissionError struct {
+	// Reason tells why the pod was rejected, such as AdmissionReasonOutOfMemory.
+	Reason  string
+	Message string
+	// Terminal tells the node can never run the pod, whereas pods rejected
+	// for lack of resources may fit later.
+	Terminal bool
+}
+
+func (e *AdmissionError) Error() string {
+	return e.Message
+}
+
+func admissionErrorf(reason, format string, args ...interface{}) *AdmissionError {
+	return &AdmissionError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// readCapacity returns the enclave capacity of the host. It is a variable so tests can replace it.
+var readCapacity = allocator.ReadCapacity
+
+// reservation is what a pod holds of the enclave resources of the host.
+type reservation struct {
+	active    bool
+	memoryMiB int64
+	cpus      int64
+	cid       int
+}
+
+// reservation returns the enclave resources held by the pod, none once it has finished.
+func (pod *Pod) reservation() reservation {
+	pod.mu.RLock()
+	defer pod.mu.RUnlock()
+
+	switch pod.phase {
+	case corev1.PodSucceeded, corev1.PodFailed:
+		return reservation{}
+	}
+	// Pods adopted from existing enclaves only know their enclave.
+	if pod.config.MemoryMib == 0 {
+		return reservation{true, pod.info.MemoryMiB, pod.info.NumberOfCPUs, pod.info.EnclaveCID}
+	}
+	return reservation{true, pod.config.MemoryMib, pod.config.CPUCount, pod.config.EnclaveCid}
+}
+
+// ForeignReservation is what the enclaves of the host which the node does
+// not run, such as enclaves launched by hand, hold of its enclave resources.
+type ForeignReservation struct {
+	Enclaves  int
+	MemoryMiB int64
+	CPUs      int64
+}
+
+// ForeignReservation returns the enclave resources held by the enclaves of
+// the host which the node does not run, which its pods cannot use.
+func (n *Node) ForeignReservation() (ForeignReservation, error) {
+	var r ForeignReservation
+	foreign, err := describeEnclaves()
+	if err != nil {
+		return r, err
+	}
+
+	n.RLock()
+	defer n.RUnlock()
+	for _, info := range foreign {
+		if n.isForeign(info) {
+			r.Enclaves++
+			r.MemoryMiB += info.MemoryMiB
+			r.CPUs += info.NumberOfCPUs
+		}
+	}
+	return r, nil
+}
+
+// isForeign tells whether an enclave of the host is not run by a pod of the
+// node and holds enclave resources. The enclaves of pods are reserved by
+// their pod, terminating enclaves still hold theirs. The caller holds the node lock.
+func (n *Node) isForeign(info cli.EnclaveInfo) bool {
+	_, ok := n.pods[info.EnclaveName]
+	return !ok && info.State != cli.StateTerminated
+}
+
+// AdmitPod inserts a pod to this node if the host has enough enclave
+// resources left for it, rather than letting nitro-cli fail to launch it, and
+// gives its enclave the CID it requests, or else a CID no other enclave uses.
+// The resources of the pods of the node and of the other enclaves running on
+// the host are reserved, pods they leave too little room for are rejected with
+// an AdmissionError. When the pools cannot be read, for instance without the
+// enclave driver, their sizes are not checked. Pods claiming host ports or
+// CIDs other pods claim are rejected too, and so are new pods while the node
+// is cordoned or drained.
+func (n *Node) AdmitPod(pod *Pod, tag string) error {
+	if n.Drain().Draining() {
+		// Pods being rebuilt are not new.
+		if _, err := n.GetPodByUID(pod.namespace, pod.name, pod.uid); err != nil {
+			return admissionErrorf(AdmissionReasonNodeDraining, "the node is cordoned or drained and admits no new pods")
+		}
+	}
+
+	capacity, err := readCapacity()
+	var foreign []cli.EnclaveInfo
+	if err == nil {
+		// Enclaves may be described while launching, they are left out rather than failing admission.
+		foreign, _ = describeEnclaves()
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	var enclaves int
+	var memory, cpus int64
+	cids := make(map[int]bool)
+	for _, info := range foreign {
+		if !n.isForeign(info) {
+			continue
+		}
+		memory += info.MemoryMiB
+		cpus += info.NumberOfCPUs
+		cids[info.EnclaveCID] = true
+	}
+	for _, other := range n.pods {
+		// A pod being rebuilt is replaced by its new incarnation.
+		if other.uid == pod.uid && other.namespace == pod.namespace && other.name == pod.name {
+			continue
+		}
+		r := other.reservation()
+		if !r.active {
+			continue
+		}
+		if err := hostPortConflict(pod, other); err != nil {
+			return err
+		}
+		enclaves++
+		memory += r.memoryMiB
+		cpus += r.cpus
+		cids[r.cid] = true
+	}
+
+	if n.maxEnclaves > 0 && enclaves >= n.maxEnclaves {
+		return fmt.Errorf("insufficient enclaves: the node runs at most %d enclaves at once", n.maxEnclaves)
+	}
+	if err == nil {
+		if required := pod.config.MemoryMib; required > capacity.MemoryMib {
+			return admissionErrorf(AdmissionReasonOutOfMemory, "insufficient enclave memory: the pod requires %d MiB but the hugepage pool only has %d MiB",
+				required, capacity.MemoryMib)
+		} else if memory+required > capacity.MemoryMib {
+			return admissionErrorf(AdmissionReasonOutOfMemory, "insufficient enclave memory: the pod requires %d MiB but only %d MiB of the %d MiB hugepage pool is unreserved",
+				required, unreserved(capacity.MemoryMib, memory), capacity.MemoryMib)
+		}
+		if required := pod.config.CPUCount; required > capacity.CPUs {
+			return admissionErrorf(AdmissionReasonOutOfCPU, "insufficient enclave CPUs: the pod requires %d CPUs but the CPU pool only has %d",
+				required, capacity.CPUs)
+		} else if cpus+required > capacity.CPUs {
+			return admissionErrorf(AdmissionReasonOutOfCPU, "insufficient enclave CPUs: the pod requires %d CPUs but only %d of the %d CPU pool are unreserved",
+				required, unreserved(capacity.CPUs, cpus), capacity.CPUs)
+		}
+	}
+
+	if cid := pod.config.EnclaveCid; cid != 0 {
+		if cids[cid] {
+			return admissionErrorf(AdmissionReasonCIDConflict, "enclave CID %d is already used by another enclave", cid)
+		}
+	} else {
+		cid = firstEnclaveCID
+		for cids[cid] {
+			cid++
+		}
+		if cid > lastEnclaveCID {
+			return fmt.Errorf("no enclave CID left")
+		}
+		pod.config.EnclaveCid = cid
+	}
+
+	n.pods[tag] = pod
+	n.current[podKey(pod.namespace, pod.name)] = tag
+	return nil
+}
+
+func unreserved(capacity, reserved int64) int64 {
+	if reserved > capacity {
+		return 0
+	}
+	return capacity - reserved
+}
@@ -0,0 +1,118 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+func TestAdmitPod(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) {
+		return &allocator.Capacity{CPUs: 16, MemoryMib: 2048}, nil
+	}
+	defer func(f func() ([]cli.EnclaveInfo, error)) { describeEnclaves = f }(describeEnclaves)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) { return nil, errors.New("no nitro-cli") }
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), maxEnclaves: 2}
+	newPod := func(name string, uid k8sTypes.UID, memory string) (*Pod, error) {
+		return NewPod(context.Background(), n, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  name,
+				Image: name,
+				Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse(memory),
+				}},
+			}}},
+		})
+	}
+
+	first, err := newPod("first", "1", "1Gi")
+	assert.Nil(t, err)
+	assert.Equal(t, firstEnclaveCID, first.config.EnclaveCid)
+	_, err = newPod("second", "2", "1536Mi")
+	assert.EqualError(t, err, "insufficient enclave memory: the pod requires 1536 MiB but only 1024 MiB of the 2048 MiB hugepage pool is unreserved")
+	var admissionErr *AdmissionError
+	if assert.ErrorAs(t, err, &admissionErr) {
+		assert.Equal(t, AdmissionReasonOutOfMemory, admissionErr.Reason)
+	}
+	_, err = newPod("huge", "6", "64Gi")
+	assert.EqualError(t, err, "insufficient enclave memory: the pod requires 65536 MiB but the hugepage pool only has 2048 MiB")
+
+	// A rebuilt pod replaces its previous incarnation.
+	rebuilt, err := newPod("first", "1", "2Gi")
+	assert.Nil(t, err)
+
+	// Finished pods release their memory.
+	rebuilt.phase = corev1.PodSucceeded
+	second, err := newPod("second", "2", "1536Mi")
+	assert.Nil(t, err)
+	assert.Equal(t, firstEnclaveCID, second.config.EnclaveCid)
+	small, err := newPod("small", "4", "256Mi")
+	assert.Nil(t, err)
+	assert.Equal(t, firstEnclaveCID+1, small.config.EnclaveCid)
+
+	// The node runs a limited number of enclaves.
+	_, err = newPod("tiny", "5", "64Mi")
+	assert.EqualError(t, err, "insufficient enclaves: the node runs at most 2 enclaves at once")
+	n.maxEnclaves = 0
+
+	// Pods are admitted unchecked when the pool cannot be read.
+	readCapacity = func() (*allocator.Capacity, error) {
+		return nil, errors.New("no enclave driver")
+	}
+	_, err = newPod("third", "3", "4Gi")
+	assert.Nil(t, err)
+}
+
+func TestAdmitPodForeignEnclaves(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) {
+		return &allocator.Capacity{CPUs: 4, MemoryMib: 4096}, nil
+	}
+	defer func(f func() ([]cli.EnclaveInfo, error)) { describeEnclaves = f }(describeEnclaves)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) {
+		return []cli.EnclaveInfo{
+			{EnclaveName: "manual", EnclaveCID: firstEnclaveCID, State: cli.StateRunning, NumberOfCPUs: 2, MemoryMiB: 1024},
+		}, nil
+	}
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+	newPod := func(name string, uid k8sTypes.UID, cpu string) (*Pod, error) {
+		return NewPod(context.Background(), n, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  name,
+				Image: name,
+				Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				}},
+			}}},
+		})
+	}
+
+	// Enclaves launched outside of the node hold their resources and CID.
+	_, err := newPod("large", "1", "4")
+	var admissionErr *AdmissionError
+	if assert.ErrorAs(t, err, &admissionErr) {
+		assert.Equal(t, AdmissionReasonOutOfCPU, admissionErr.Reason)
+		assert.Equal(t, "insufficient enclave CPUs: the pod requires 4 CPUs but only 2 of the 4 CPU pool are unreserved", admissionErr.Message)
+	}
+	small, err := newPod("small", "2", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, firstEnclaveCID+1, small.config.EnclaveCid)
+
+	foreign, err := n.ForeignReservation()
+	assert.Nil(t, err)
+	assert.Equal(t, ForeignReservation{Enclaves: 1, MemoryMiB: 1024, CPUs: 2}, foreign)
+}
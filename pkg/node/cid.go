@@ -0,0 +1,69 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// CIDAnnotation requests a fixed CID for the enclave of a pod, for
+	// applications and tooling configured with the CID of their enclave.
+	CIDAnnotation = annotationPrefix + "cid"
+	// AssignedCIDAnnotation is the CID the enclave of a pod was launched with,
+	// published by the provider.
+	AssignedCIDAnnotation = annotationPrefix + "assigned-cid"
+
+	// AdmissionReasonCIDConflict rejects a pod requesting a CID another enclave uses.
+	AdmissionReasonCIDConflict = "CIDConflict"
+)
+
+// requestedCID returns the CID requested by a pod, 0 when it requests none.
+func requestedCID(pod *corev1.Pod) (int, error) {
+	value, ok := pod.Annotations[CIDAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	cid, err := strconv.Atoi(value)
+	if err != nil || cid < firstEnclaveCID || cid > lastEnclaveCID {
+		return 0, fmt.Errorf("invalid %s annotation %q, expected a CID between %d and %d", CIDAnnotation, value, firstEnclaveCID, lastEnclaveCID)
+	}
+	return cid, nil
+}
+
+// publishCID annotates the pod with the CID of its enclave, unless it already is.
+func (pod *Pod) publishCID(ctx context.Context, cid int) error {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if pod.node == nil || pod.node.client == nil || spec == nil {
+		return nil
+	}
+	value := strconv.Itoa(cid)
+	if spec.Annotations[AssignedCIDAnnotation] == value {
+		return nil
+	}
+
+	// The UID makes the patch fail rather than annotate a recreated pod.
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid":         pod.uid,
+			"annotations": map[string]string{AssignedCIDAnnotation: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = pod.node.client.CoreV1().Pods(pod.namespace).Patch(ctx, pod.name, k8sTypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to annotate pod: %v", err)
+	}
+	log.G(ctx).Debugf("published CID %d of pod %s/%s", cid, pod.namespace, pod.name)
+	return nil
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRequestedCID(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) {
+		return nil, errors.New("no enclave driver")
+	}
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+	newPod := func(name string, uid k8sTypes.UID, cid string) (*Pod, error) {
+		annotations := map[string]string{}
+		if cid != "" {
+			annotations[CIDAnnotation] = cid
+		}
+		return NewPod(context.Background(), n, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid, Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: name}}},
+		})
+	}
+
+	_, err := newPod("reserved", "1", "3")
+	assert.EqualError(t, err, `invalid nitro-enclave-kubelet.brave.com/cid annotation "3", expected a CID between 16 and 999`)
+
+	fixed, err := newPod("fixed", "2", "16")
+	assert.Nil(t, err)
+	assert.Equal(t, 16, fixed.config.EnclaveCid)
+	_, err = newPod("other", "3", "16")
+	var rejection *AdmissionError
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonCIDConflict, rejection.Reason)
+	}
+
+	// Pods requesting no CID get the first one left.
+	free, err := newPod("free", "4", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 17, free.config.EnclaveCid)
+}
+
+func TestPublishCID(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"}})
+	pod := &Pod{namespace: "default", name: "web", uid: "1", node: &Node{client: client},
+		pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"}}}
+
+	assert.Nil(t, pod.publishCID(context.Background(), 42))
+	published, err := client.CoreV1().Pods("default").Get(context.Background(), "web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "42", published.Annotations[AssignedCIDAnnotation])
+}
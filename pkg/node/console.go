@@ -0,0 +1,164 @@
+package node
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+)
+
+// consoleBufferSize bounds the console output of an enclave kept for its readers.
+const consoleBufferSize = 1024 * 1024
+
+// openConsole attaches to the console of an enclave, stubbed in tests.
+var openConsole = cli.Console
+
+// consoleLog owns the single console reader of an enclave in debug mode, as
+// concurrent nitro-cli console processes conflict, and multiplexes its output
+// to any number of readers. The most recent output is kept for new readers.
+type consoleLog struct {
+	enclaveID string
+	console   io.ReadCloser
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	// base is the offset in the output of the first buffered byte.
+	base   int64
+	closed bool
+}
+
+// newConsoleLog attaches to the console of the enclave and starts buffering its output.
+func newConsoleLog(enclaveID string) (*consoleLog, error) {
+	console, err := openConsole(enclaveID)
+	if err != nil {
+		return nil, err
+	}
+	c := &consoleLog{enclaveID: enclaveID, console: console}
+	c.cond = sync.NewCond(&c.mu)
+	go c.run()
+	return c, nil
+}
+
+// run buffers the console output until the console ends or is closed.
+func (c *consoleLog) run() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.console.Read(buf)
+		c.mu.Lock()
+		c.buf = append(c.buf, buf[:n]...)
+		if drop := len(c.buf) - consoleBufferSize; drop > 0 {
+			c.buf = append(c.buf[:0:0], c.buf[drop:]...)
+			c.base += int64(drop)
+		}
+		if err != nil {
+			c.closed = true
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close detaches from the console. Readers get the output buffered so far.
+func (c *consoleLog) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if closed {
+		return nil
+	}
+	return c.console.Close()
+}
+
+// Logs returns the console output, honoring the tail and byte limit options.
+// Followers keep receiving the output until the console is closed.
+func (c *consoleLog) Logs(opts api.ContainerLogOpts) io.ReadCloser {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data := c.buf
+	if opts.Tail > 0 {
+		data = tailLines(data, opts.Tail)
+	}
+	if !opts.Follow {
+		if opts.LimitBytes > 0 && len(data) > opts.LimitBytes {
+			data = data[:opts.LimitBytes]
+		}
+		return io.NopCloser(bytes.NewReader(append([]byte(nil), data...)))
+	}
+
+	r := &consoleReader{c: c, offset: c.base + int64(len(c.buf)-len(data))}
+	if opts.LimitBytes > 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(r, int64(opts.LimitBytes)), r}
+	}
+	return r
+}
+
+// consoleReader follows the output of a console from an offset.
+type consoleReader struct {
+	c      *consoleLog
+	offset int64
+	closed bool
+}
+
+func (r *consoleReader) Read(p []byte) (int, error) {
+	c := r.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for r.offset >= c.base+int64(len(c.buf)) && !c.closed && !r.closed {
+		c.cond.Wait()
+	}
+	if r.closed {
+		return 0, io.EOF
+	}
+	// Output dropped before being read is skipped.
+	if r.offset < c.base {
+		r.offset = c.base
+	}
+	n := copy(p, c.buf[r.offset-c.base:])
+	r.offset += int64(n)
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (r *consoleReader) Close() error {
+	r.c.mu.Lock()
+	r.closed = true
+	r.c.cond.Broadcast()
+	r.c.mu.Unlock()
+	return nil
+}
+
+// consoleLogs returns the console output of the pod's enclave, attaching to
+// its console unless already attached.
+func (pod *Pod) consoleLogs(opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	pod.mu.Lock()
+	enclaveID := pod.info.EnclaveID
+	console := pod.console
+	if console == nil || console.enclaveID != enclaveID {
+		if console != nil {
+			console.Close()
+		}
+		var err error
+		if console, err = newConsoleLog(enclaveID); err != nil {
+			pod.mu.Unlock()
+			return nil, err
+		}
+		pod.console = console
+	}
+	pod.mu.Unlock()
+	return console.Logs(opts), nil
+}
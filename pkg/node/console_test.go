@@ -0,0 +1,49 @@
+package node
+
+import (
+	"io"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+)
+
+func TestConsoleLogs(t *testing.T) {
+	pr, pw := io.Pipe()
+	opened := 0
+	defer func(open func(string) (io.ReadCloser, error)) { openConsole = open }(openConsole)
+	openConsole = func(enclaveID string) (io.ReadCloser, error) {
+		opened++
+		return pr, nil
+	}
+
+	pod := &Pod{namespace: "default", name: "nginx", info: cli.EnclaveInfo{EnclaveID: "i-1"}}
+	follower, err := pod.consoleLogs(api.ContainerLogOpts{Follow: true})
+	assert.Nil(t, err)
+	other, err := pod.consoleLogs(api.ContainerLogOpts{Follow: true, Tail: 1})
+	assert.Nil(t, err)
+
+	pw.Write([]byte("hello\nworld\n")) //nolint:errcheck
+	buf := make([]byte, 64)
+	n, err := io.ReadAtLeast(follower, buf, 12)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\nworld\n", string(buf[:n]))
+	n, err = io.ReadAtLeast(other, buf, 12)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\nworld\n", string(buf[:n]))
+
+	// Readers share the console, and get its buffered output.
+	r, err := pod.consoleLogs(api.ContainerLogOpts{Tail: 1})
+	assert.Nil(t, err)
+	data, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "world\n", string(data))
+	assert.Equal(t, 1, opened)
+
+	// Followers end with the console.
+	assert.Nil(t, pod.console.Close())
+	_, err = follower.Read(buf)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, other.Close())
+}
@@ -139,6 +139,18 @@ func (cntr *container) setResourceRequirements(reqs *corev1.ResourceRequirements
 		}
 	}
 
+	// Pods accounting for enclave memory apart from the memory of the host
+	// request it as an extended resource, whose requests equal its limits.
+	if reqs != nil {
+		quantity, ok := reqs.Limits[ResourceEnclaveMemoryMiB]
+		if !ok {
+			quantity, ok = reqs.Requests[ResourceEnclaveMemoryMiB]
+		}
+		if ok {
+			memory = quantity.Value()
+		}
+	}
+
 	// Set final values.
 	cntr.definition.Cpu = cpu
 	cntr.definition.Memory = memory
@@ -0,0 +1,26 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSetResourceRequirements(t *testing.T) {
+	var cntr container
+	cntr.setResourceRequirements(&corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1500M")},
+	})
+	assert.Equal(t, int64(1431), cntr.definition.Memory)
+
+	// Enclave memory requested as an extended resource wins over host memory.
+	cntr.setResourceRequirements(&corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory:    resource.MustParse("128Mi"),
+			ResourceEnclaveMemoryMiB: resource.MustParse("2048"),
+		},
+	})
+	assert.Equal(t, int64(2048), cntr.definition.Memory)
+}
@@ -0,0 +1,191 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RoleARNAnnotation binds a service account to the IAM role whose
+	// credentials are vended to the enclaves of its pods, as with IRSA.
+	RoleARNAnnotation = "eks.amazonaws.com/role-arn"
+
+	// credentialsAddress is where the agent accepts the credential requests
+	// of the workload, which it forwards to the pod's credential endpoint.
+	credentialsAddress = "127.0.0.1:9911"
+	// credentialsAudience is the audience of the service account tokens exchanged for credentials.
+	credentialsAudience = "sts.amazonaws.com"
+	// credentialsTokenExpiration is the lifetime of the exchanged service account tokens, in seconds.
+	credentialsTokenExpiration = 3600
+	// credentialsRefreshMargin is how long before they expire credentials are refreshed.
+	credentialsRefreshMargin = 5 * time.Minute
+	// credentialsRetryInterval is how long to wait before refreshing credentials again after a failure.
+	credentialsRetryInterval = 30 * time.Second
+	// defaultSTSRegion is the region of the STS endpoint when the host configures none.
+	defaultSTSRegion = "us-east-1"
+)
+
+// roleCredentials are temporary credentials of an IAM role.
+type roleCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// assumeRole exchanges a web identity token for credentials of a role. It is a variable so tests can replace it.
+var assumeRole = assumeRoleWithWebIdentity
+
+func assumeRoleWithWebIdentity(ctx context.Context, roleARN, sessionName, token string) (*roleCredentials, error) {
+	// The exchange is not signed, the token authenticates it.
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultSTSRegion
+	}
+	output, err := sts.NewFromConfig(cfg).AssumeRoleWithWebIdentity(ctx, &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(roleARN),
+		RoleSessionName:  aws.String(sessionName),
+		WebIdentityToken: aws.String(token),
+	})
+	if err != nil {
+		return nil, err
+	}
+	creds := output.Credentials
+	return &roleCredentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		Expiration:      aws.ToTime(creds.Expiration),
+	}, nil
+}
+
+// invalidSessionNameChars are the characters role session names cannot contain.
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// roleSessionName returns the name of the role sessions of the pod, telling
+// which pod the credentials were vended to in CloudTrail.
+func (pod *Pod) roleSessionName() string {
+	name := invalidSessionNameChars.ReplaceAllString(fmt.Sprintf("%s.%s", pod.namespace, pod.name), "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// roleARN returns the IAM role bound to the service account of the pod, if any.
+func (pod *Pod) roleARN(ctx context.Context) (string, error) {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil || pod.node == nil || pod.node.client == nil {
+		return "", nil
+	}
+	if spec.Spec.AutomountServiceAccountToken != nil && !*spec.Spec.AutomountServiceAccountToken {
+		return "", nil
+	}
+
+	serviceAccount := spec.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	account, err := pod.node.client.CoreV1().ServiceAccounts(pod.namespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get service account %s/%s: %w", pod.namespace, serviceAccount, err)
+	}
+	return account.Annotations[RoleARNAnnotation], nil
+}
+
+// credentialVendor vends the credentials of the role of a pod to its enclave,
+// exchanging tokens of the pod's service account for them.
+type credentialVendor struct {
+	pod     *Pod
+	roleARN string
+
+	mu    sync.Mutex
+	creds *roleCredentials
+}
+
+func newCredentialVendor(pod *Pod, roleARN string) *credentialVendor {
+	return &credentialVendor{pod: pod, roleARN: roleARN}
+}
+
+// credentials returns the credentials of the role, refreshing them when they are about to expire.
+func (v *credentialVendor) credentials(ctx context.Context) (*roleCredentials, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.creds != nil && time.Until(v.creds.Expiration) > credentialsRefreshMargin {
+		return v.creds, nil
+	}
+	expiration := int64(credentialsTokenExpiration)
+	token, _, err := v.pod.requestToken(ctx, &corev1.ServiceAccountTokenProjection{
+		Audience:          credentialsAudience,
+		ExpirationSeconds: &expiration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	creds, err := assumeRole(ctx, v.roleARN, v.pod.roleSessionName(), token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role %s: %w", v.roleARN, err)
+	}
+	v.creds = creds
+	return creds, nil
+}
+
+// refresh keeps the credentials fresh until ctx is done, so requests of the
+// enclave are answered from the cache.
+func (v *credentialVendor) refresh(ctx context.Context) error {
+	for {
+		wait := credentialsRetryInterval
+		creds, err := v.credentials(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.G(ctx).Warnf("failed to refresh credentials of pod %s/%s: %v", v.pod.namespace, v.pod.name, err)
+		} else if until := time.Until(creds.Expiration) - credentialsRefreshMargin; until > wait {
+			wait = until
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// ServeHTTP answers the credential requests of the enclave in the format of
+// the ECS container credential provider, understood by the AWS SDKs.
+func (v *credentialVendor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	creds, err := v.credentials(r.Context())
+	if err != nil {
+		log.G(r.Context()).Errorf("Failed to vend credentials to pod %s/%s: %v.\n", v.pod.namespace, v.pod.name, err)
+		http.Error(w, "credentials unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"AccessKeyId":     creds.AccessKeyID,
+		"SecretAccessKey": creds.SecretAccessKey,
+		"Token":           creds.SessionToken,
+		"Expiration":      creds.Expiration.UTC().Format(time.RFC3339),
+		"RoleArn":         v.roleARN,
+	})
+}
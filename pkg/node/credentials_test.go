@@ -0,0 +1,78 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestCredentialVendor(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "web",
+			Annotations: map[string]string{RoleARNAnnotation: "arn:aws:iam::123456789012:role/web"},
+		}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "default"}},
+	)
+	var audiences []string
+	client.PrependReactor("create", "serviceaccounts", func(action ktesting.Action) (bool, runtime.Object, error) {
+		request := action.(ktesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		audiences = request.Spec.Audiences
+		response := request.DeepCopy()
+		response.Status = authenticationv1.TokenRequestStatus{Token: "token", ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour))}
+		return true, response, nil
+	})
+
+	exchanges := 0
+	defer func(f func(context.Context, string, string, string) (*roleCredentials, error)) { assumeRole = f }(assumeRole)
+	assumeRole = func(ctx context.Context, roleARN, sessionName, token string) (*roleCredentials, error) {
+		exchanges++
+		assert.Equal(t, "arn:aws:iam::123456789012:role/web", roleARN)
+		assert.Equal(t, "default.web-7f9c", sessionName)
+		assert.Equal(t, "token", token)
+		return &roleCredentials{"AKID", "secret", "session", time.Now().Add(time.Hour)}, nil
+	}
+
+	pod := &Pod{
+		namespace: "default",
+		name:      "web-7f9c",
+		uid:       "1234",
+		node:      &Node{client: client},
+		pod:       &corev1.Pod{Spec: corev1.PodSpec{ServiceAccountName: "web"}},
+	}
+	roleARN, err := pod.roleARN(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/web", roleARN)
+
+	vendor := newCredentialVendor(pod, roleARN)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		vendor.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/credentials", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var creds map[string]string
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &creds))
+		assert.Equal(t, "AKID", creds["AccessKeyId"])
+		assert.Equal(t, "session", creds["Token"])
+	}
+	// Credentials are cached until they are about to expire.
+	assert.Equal(t, 1, exchanges)
+	assert.Equal(t, []string{credentialsAudience}, audiences)
+
+	// Pods of service accounts without a role get no credentials.
+	pod.pod.Spec.ServiceAccountName = ""
+	roleARN, err = pod.roleARN(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "", roleARN)
+}
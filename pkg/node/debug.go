@@ -0,0 +1,90 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationPrefix prefixes the pod annotations understood by the provider.
+	annotationPrefix = "nitro-enclave-kubelet.brave.com/"
+	// DebugModeAnnotation requests an enclave launched in debug mode, exposing
+	// its console. The measurements of such enclaves are zeroed, so they cannot be attested.
+	DebugModeAnnotation = annotationPrefix + "debug-mode"
+
+	// PodAttestable is a pod condition telling whether the enclave of a pod can be attested.
+	PodAttestable corev1.PodConditionType = annotationPrefix + "attestable"
+	// podReasonDebugMode explains why an enclave cannot be attested.
+	podReasonDebugMode = "DebugMode"
+)
+
+// debugMode tells whether a pod requests its enclave to run in debug mode,
+// or its profile runs enclaves in debug mode, and whether the node, its
+// namespace and the profile allow it.
+func debugMode(node *Node, pod *corev1.Pod) (bool, error) {
+	profile, err := podProfile(node, pod)
+	if err != nil {
+		return false, err
+	}
+	value, ok := pod.Annotations[DebugModeAnnotation]
+	var debug bool
+	if ok {
+		if debug, err = strconv.ParseBool(value); err != nil {
+			return false, fmt.Errorf("invalid %s annotation %q", DebugModeAnnotation, value)
+		}
+	}
+	if profile != nil {
+		switch name := pod.Annotations[ProfileAnnotation]; profile.DebugMode {
+		case ProfileDebugModeDeny:
+			if debug {
+				return false, fmt.Errorf("debug mode is not allowed by profile %q", name)
+			}
+		case ProfileDebugModeAlways:
+			if ok && !debug {
+				return false, fmt.Errorf("profile %q runs enclaves in debug mode", name)
+			}
+			debug = true
+		}
+	}
+	if debug && (node == nil || !node.allowsDebugMode()) {
+		return false, fmt.Errorf("debug mode is not allowed on this node")
+	}
+	// Debug mode zeroes the PCRs, namespaces running production enclaves
+	// may not let them go unattested.
+	if debug {
+		if allowed, denied := node.debugModeNamespaces(); containsString(denied, pod.Namespace) || len(allowed) > 0 && !containsString(allowed, pod.Namespace) {
+			return false, fmt.Errorf("debug mode is not allowed in namespace %q", pod.Namespace)
+		}
+	}
+	return debug, nil
+}
+
+// attestableCondition reports whether the enclave of the pod can be attested.
+func (pod *Pod) attestableCondition() corev1.PodCondition {
+	if pod.config.DebugMode {
+		return corev1.PodCondition{
+			Type:    PodAttestable,
+			Status:  corev1.ConditionFalse,
+			Reason:  podReasonDebugMode,
+			Message: "the enclave runs in debug mode, its measurements are zeroed",
+		}
+	}
+	return corev1.PodCondition{Type: PodAttestable, Status: corev1.ConditionTrue}
+}
+
+// allowsDebugMode tells whether the node lets pods run their enclave in debug mode.
+func (n *Node) allowsDebugMode() bool {
+	n.RLock()
+	defer n.RUnlock()
+	return n.allowDebugMode
+}
+
+// debugModeNamespaces returns the namespaces whose pods may and may not run
+// their enclave in debug mode.
+func (n *Node) debugModeNamespaces() (allowed, denied []string) {
+	n.RLock()
+	defer n.RUnlock()
+	return n.debugModeAllowedNamespaces, n.debugModeDeniedNamespaces
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDebugMode(t *testing.T) {
+	spec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx", UID: "1234"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+
+	// Enclaves are attestable by default.
+	pod, err := NewPod(context.Background(), nil, spec)
+	assert.Nil(t, err)
+	assert.False(t, pod.config.DebugMode)
+	assert.Equal(t, corev1.ConditionTrue, pod.attestableCondition().Status)
+
+	// Debug mode is opt-in, and must be allowed by the node.
+	debug := spec.DeepCopy()
+	debug.Annotations = map[string]string{DebugModeAnnotation: "true"}
+	_, err = NewPod(context.Background(), nil, debug)
+	assert.EqualError(t, err, "debug mode is not allowed on this node")
+
+	mode, err := debugMode(&Node{allowDebugMode: true}, debug)
+	assert.Nil(t, err)
+	assert.True(t, mode)
+
+	// Namespaces may forbid debug mode, or be the only ones allowing it.
+	_, err = debugMode(&Node{allowDebugMode: true, debugModeDeniedNamespaces: []string{"prod", "default"}}, debug)
+	assert.EqualError(t, err, `debug mode is not allowed in namespace "default"`)
+	_, err = debugMode(&Node{allowDebugMode: true, debugModeAllowedNamespaces: []string{"dev"}}, debug)
+	assert.EqualError(t, err, `debug mode is not allowed in namespace "default"`)
+	mode, err = debugMode(&Node{allowDebugMode: true, debugModeAllowedNamespaces: []string{"default"}}, debug)
+	assert.Nil(t, err)
+	assert.True(t, mode)
+	mode, err = debugMode(&Node{debugModeDeniedNamespaces: []string{"default"}}, spec)
+	assert.Nil(t, err)
+	assert.False(t, mode)
+
+	debug.Annotations[DebugModeAnnotation] = "yes please"
+	_, err = debugMode(&Node{allowDebugMode: true}, debug)
+	assert.NotNil(t, err)
+
+	pod.config.DebugMode = true
+	condition := pod.attestableCondition()
+	assert.Equal(t, corev1.ConditionFalse, condition.Status)
+	assert.Equal(t, podReasonDebugMode, condition.Reason)
+}
@@ -0,0 +1,161 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DNSAnnotation lets the enclave of a pod resolve names through the DNS proxy.
+	DNSAnnotation = annotationPrefix + "dns"
+	// DNSAllowAnnotation lists the domains, separated by commas, whose names
+	// the enclave may resolve. All are allowed when empty. It implies DNSAnnotation.
+	DNSAllowAnnotation = annotationPrefix + "dns-allow"
+	// DNSDenyAnnotation lists the domains, separated by commas, whose names
+	// the enclave may not resolve. It implies DNSAnnotation.
+	DNSDenyAnnotation = annotationPrefix + "dns-deny"
+)
+
+// dnsFilter is the domains a pod may and may not resolve.
+type dnsFilter struct {
+	allow []string
+	deny  []string
+}
+
+// dnsConfig returns the DNS filter configured by the annotations of a pod,
+// nil when it does not resolve names. Enclaves resolve nothing by default,
+// as names can leak data.
+func dnsConfig(pod *corev1.Pod) (*dnsFilter, error) {
+	filter := &dnsFilter{
+		allow: domainList(pod.Annotations[DNSAllowAnnotation]),
+		deny:  domainList(pod.Annotations[DNSDenyAnnotation]),
+	}
+	if value, ok := pod.Annotations[DNSAnnotation]; ok {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation %q", DNSAnnotation, value)
+		}
+		if !enabled {
+			return nil, nil
+		}
+		return filter, nil
+	}
+	if len(filter.allow) == 0 && len(filter.deny) == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// domainList splits a comma separated list of domains.
+func domainList(value string) []string {
+	var domains []string
+	for _, domain := range strings.Split(value, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// dns returns the DNS filter of the pod, nil when it does not resolve names.
+func (pod *Pod) dns() *dnsFilter {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotations were validated when the pod was created.
+	filter, _ := dnsConfig(spec)
+	return filter
+}
+
+// resolverAddress is where the agent serves the DNS queries of the workload.
+const resolverAddress = "127.0.0.1"
+
+// defaultClusterDomain is the domain of the cluster when the node is configured with none.
+const defaultClusterDomain = "cluster.local"
+
+// resolvConf returns the resolv.conf of the enclave of a pod, pointing at the
+// agent relaying its queries to the DNS proxy, with the search domains and
+// options of its DNS policy and config, as the kubelet generates it.
+func resolvConf(pod *corev1.Pod, clusterDomain string) []byte {
+	var searches []string
+	options := map[string]string{}
+	var names []string
+	setOption := func(name, value string) {
+		if _, ok := options[name]; !ok {
+			names = append(names, name)
+		}
+		options[name] = value
+	}
+
+	switch pod.Spec.DNSPolicy {
+	case "", corev1.DNSClusterFirst, corev1.DNSClusterFirstWithHostNet:
+		searches = []string{
+			fmt.Sprintf("%s.svc.%s", pod.Namespace, clusterDomain),
+			"svc." + clusterDomain,
+			clusterDomain,
+		}
+		setOption("ndots", "5")
+	}
+	if config := pod.Spec.DNSConfig; config != nil {
+		for _, search := range config.Searches {
+			if !containsString(searches, search) {
+				searches = append(searches, search)
+			}
+		}
+		for _, option := range config.Options {
+			value := ""
+			if option.Value != nil {
+				value = *option.Value
+			}
+			setOption(option.Name, value)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "nameserver %s\n", resolverAddress)
+	if len(searches) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(searches, " "))
+	}
+	if len(names) > 0 {
+		formatted := make([]string, 0, len(names))
+		for _, name := range names {
+			if value := options[name]; value != "" {
+				name += ":" + value
+			}
+			formatted = append(formatted, name)
+		}
+		fmt.Fprintf(&b, "options %s\n", strings.Join(formatted, " "))
+	}
+	return []byte(b.String())
+}
+
+// dnsUpstream returns the name server resolving the queries of the pod: the
+// first one of its DNS config when its policy is None, the node's otherwise.
+func (pod *Pod) dnsUpstream() string {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec != nil && spec.Spec.DNSPolicy == corev1.DNSNone && spec.Spec.DNSConfig != nil && len(spec.Spec.DNSConfig.Nameservers) > 0 {
+		return net.JoinHostPort(spec.Spec.DNSConfig.Nameservers[0], "53")
+	}
+	if pod.node == nil {
+		return ""
+	}
+	return pod.node.dnsServer
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSConfig(t *testing.T) {
+	config := func(annotations map[string]string) (*dnsFilter, error) {
+		return dnsConfig(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}})
+	}
+
+	// Enclaves resolve nothing by default.
+	filter, err := config(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+
+	filter, err = config(map[string]string{DNSAnnotation: "true"})
+	assert.Nil(t, err)
+	assert.Equal(t, &dnsFilter{}, filter)
+
+	// Filters imply DNS, unless it is disabled.
+	filter, err = config(map[string]string{DNSAllowAnnotation: "example.com, amazonaws.com", DNSDenyAnnotation: "secret.example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, &dnsFilter{allow: []string{"example.com", "amazonaws.com"}, deny: []string{"secret.example.com"}}, filter)
+
+	filter, err = config(map[string]string{DNSAnnotation: "false", DNSAllowAnnotation: "example.com"})
+	assert.Nil(t, err)
+	assert.Nil(t, filter)
+
+	_, err = config(map[string]string{DNSAnnotation: "maybe"})
+	assert.NotNil(t, err)
+}
+
+func TestResolvConf(t *testing.T) {
+	ndots := "2"
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "web"}}
+	assert.Equal(t, "nameserver 127.0.0.1\nsearch web.svc.cluster.local svc.cluster.local cluster.local\noptions ndots:5\n",
+		string(resolvConf(pod, "cluster.local")))
+
+	// The DNS config adds search domains and overrides options.
+	pod.Spec.DNSConfig = &corev1.PodDNSConfig{
+		Searches: []string{"example.com", "cluster.local"},
+		Options:  []corev1.PodDNSConfigOption{{Name: "ndots", Value: &ndots}, {Name: "edns0"}},
+	}
+	assert.Equal(t, "nameserver 127.0.0.1\nsearch web.svc.cluster.local svc.cluster.local cluster.local example.com\noptions ndots:2 edns0\n",
+		string(resolvConf(pod, "cluster.local")))
+
+	pod.Spec.DNSPolicy = corev1.DNSNone
+	pod.Spec.DNSConfig = &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}}
+	assert.Equal(t, "nameserver 127.0.0.1\n", string(resolvConf(pod, "cluster.local")))
+	assert.Equal(t, "10.0.0.10:53", (&Pod{pod: pod}).dnsUpstream())
+}
+
+func TestHostsFile(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web-0"},
+		Spec: corev1.PodSpec{
+			Subdomain:   "web",
+			HostAliases: []corev1.HostAlias{{IP: "10.0.0.2", Hostnames: []string{"db", "db.internal"}}},
+		},
+	}
+	hosts := string(hostsFile(pod, "cluster.local"))
+	assert.Contains(t, hosts, "127.0.0.1\tlocalhost\n")
+	assert.Contains(t, hosts, "127.0.0.1\tweb-0.web.web.svc.cluster.local\tweb-0\n")
+	assert.Contains(t, hosts, "# Entries added by HostAliases.\n10.0.0.2\tdb\tdb.internal\n")
+}
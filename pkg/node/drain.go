@@ -0,0 +1,163 @@
+package node
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DrainAnnotation, set to true on the node, drains it: the node admits
+	// no pods and evicts its pods, as for host maintenance or AMI rotation.
+	DrainAnnotation = annotationPrefix + "drain"
+
+	// NodeEnclaveDraining is a node condition telling whether the node is
+	// cordoned or drained, and admits no pods.
+	NodeEnclaveDraining corev1.NodeConditionType = "EnclaveDraining"
+
+	// AdmissionReasonNodeDraining rejects the pods bound to a draining node.
+	AdmissionReasonNodeDraining = "NodeDraining"
+
+	// drainEvictionInterval is how often the pods of a drained node are
+	// evicted, as disruption budgets may refuse their eviction for a while.
+	drainEvictionInterval = 5 * time.Second
+)
+
+// Drain tells whether the node is cordoned or drained.
+type Drain struct {
+	// Cordoned tells the node is unschedulable, as kubectl cordon and drain make it.
+	Cordoned bool
+	// Requested tells the DrainAnnotation of the node is set, so the node
+	// evicts its pods itself.
+	Requested bool
+}
+
+// Draining tells the node admits no pods.
+func (d Drain) Draining() bool {
+	return d.Cordoned || d.Requested
+}
+
+// Drain returns whether the node is cordoned or drained, as last watched.
+func (n *Node) Drain() Drain {
+	n.drainMu.Lock()
+	defer n.drainMu.Unlock()
+	return n.drain
+}
+
+// NotifyDrain sets the function called whenever the node is cordoned,
+// drained, or made schedulable again.
+func (n *Node) NotifyDrain(notifier func(Drain)) {
+	n.drainMu.Lock()
+	defer n.drainMu.Unlock()
+	n.drainNotifier = notifier
+}
+
+func (n *Node) setDrain(drain Drain) {
+	n.drainMu.Lock()
+	changed := n.drain != drain
+	n.drain = drain
+	notifier := n.drainNotifier
+	n.drainMu.Unlock()
+
+	if changed && notifier != nil {
+		notifier(drain)
+	}
+}
+
+// WatchDrain follows the node object until the context is done, so the
+// node admits no pods while it is cordoned or drained, and evicts its pods
+// while its DrainAnnotation is set. Evictions go through the eviction API,
+// honouring disruption budgets and the grace periods of the pods.
+func (n *Node) WatchDrain(ctx context.Context) {
+	if n.client == nil {
+		return
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(n.client, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", n.name).String()
+		}))
+	handle := func(obj interface{}) {
+		if node, ok := obj.(*corev1.Node); ok {
+			n.setDrain(drainOf(node))
+		}
+	}
+	_, err := factory.Core().V1().Nodes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+	})
+	if err != nil {
+		log.G(ctx).Errorf("Failed to watch the node for drains: %v.\n", err)
+		return
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	ticker := time.NewTicker(drainEvictionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if n.Drain().Requested {
+			n.evictPods(ctx)
+		}
+	}
+}
+
+// drainOf tells whether a node is cordoned or drained.
+func drainOf(node *corev1.Node) Drain {
+	requested, _ := strconv.ParseBool(node.Annotations[DrainAnnotation])
+	return Drain{Cordoned: node.Spec.Unschedulable, Requested: requested}
+}
+
+// evictPods requests the eviction of the pods of the node which are not
+// finished or terminating already.
+func (n *Node) evictPods(ctx context.Context) {
+	pods, err := n.GetPods()
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, pod := range pods {
+		pod.mu.RLock()
+		finished := pod.phase == corev1.PodSucceeded || pod.phase == corev1.PodFailed
+		terminating := pod.terminating
+		pod.mu.RUnlock()
+		if finished || terminating || pod.uid == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(pod *Pod) {
+			defer wg.Done()
+			// The grace period of the pod applies when the eviction sets none.
+			err := n.client.PolicyV1().Evictions(pod.namespace).Evict(ctx, &policyv1.Eviction{
+				ObjectMeta:    metav1.ObjectMeta{Namespace: pod.namespace, Name: pod.name},
+				DeleteOptions: &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &pod.uid}},
+			})
+			switch {
+			case err == nil:
+				log.G(ctx).Infof("Evicted pod %s/%s to drain the node", pod.namespace, pod.name)
+			case apierrors.IsTooManyRequests(err):
+				log.G(ctx).Debugf("eviction of pod %s/%s refused for now: %v", pod.namespace, pod.name, err)
+			case !apierrors.IsNotFound(err) && !apierrors.IsConflict(err):
+				log.G(ctx).Warnf("Failed to evict pod %s/%s: %v", pod.namespace, pod.name, err)
+			}
+		}(pod)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,57 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestDrainOf(t *testing.T) {
+	node := &corev1.Node{}
+	assert.False(t, drainOf(node).Draining())
+
+	node.Spec.Unschedulable = true
+	assert.Equal(t, Drain{Cordoned: true}, drainOf(node))
+
+	node.Annotations = map[string]string{DrainAnnotation: "true"}
+	assert.Equal(t, Drain{Cordoned: true, Requested: true}, drainOf(node))
+}
+
+func TestDrain(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var evicted []string
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		evicted = append(evicted, eviction.Name)
+		return true, nil, nil
+	})
+	n := &Node{client: client, pods: make(map[string]*Pod), current: make(map[string]string)}
+	running := &Pod{namespace: "default", name: "web", uid: "1", node: n, phase: corev1.PodRunning}
+	n.InsertPod(running, running.buildEnclaveNameTag())
+	done := &Pod{namespace: "default", name: "job", uid: "2", node: n, phase: corev1.PodSucceeded}
+	n.InsertPod(done, done.buildEnclaveNameTag())
+
+	var notified []Drain
+	n.NotifyDrain(func(drain Drain) { notified = append(notified, drain) })
+	n.setDrain(Drain{Requested: true})
+	n.setDrain(Drain{Requested: true})
+	assert.Equal(t, []Drain{{Requested: true}}, notified)
+
+	// New pods are rejected while the node drains.
+	err := n.AdmitPod(&Pod{namespace: "default", name: "new", uid: "3"}, "tag")
+	assert.Equal(t, AdmissionReasonNodeDraining, err.(*AdmissionError).Reason)
+	assert.False(t, err.(*AdmissionError).Terminal)
+
+	// Only the pods which are not finished are evicted.
+	n.evictPods(context.Background())
+	assert.Equal(t, []string{"web"}, evicted)
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// EgressAnnotation lists the host:port destinations, separated by commas,
+	// which the enclave of a pod may reach through the egress proxy.
+	EgressAnnotation = annotationPrefix + "egress"
+
+	// egressProxyAddress is where the agent accepts the HTTP proxy
+	// connections of the workload, which it forwards to the egress proxy.
+	egressProxyAddress = "127.0.0.1:3128"
+)
+
+// egressAllowlist returns the destinations a pod may reach, parsed from its EgressAnnotation.
+func egressAllowlist(pod *corev1.Pod) ([]string, error) {
+	value, ok := pod.Annotations[EgressAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var allowed []string
+	for _, dest := range strings.Split(value, ",") {
+		dest = strings.ToLower(strings.TrimSpace(dest))
+		if dest == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(dest)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid %s destination %q, expected host:port", EgressAnnotation, dest)
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid %s destination %q, bad port", EgressAnnotation, dest)
+		}
+		allowed = append(allowed, dest)
+	}
+	return allowed, nil
+}
+
+// egress returns the destinations the pod's enclave may reach.
+func (pod *Pod) egress() []string {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotation was validated when the pod was created.
+	allowed, _ := egressAllowlist(spec)
+	return allowed
+}
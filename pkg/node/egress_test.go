@@ -0,0 +1,32 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEgressAllowlist(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	allowed, err := egressAllowlist(pod)
+	assert.Nil(t, err)
+	assert.Empty(t, allowed)
+
+	pod.Annotations[EgressAnnotation] = "api.example.com:443, KMS.us-east-1.amazonaws.com:443,,[::1]:8080"
+	allowed, err = egressAllowlist(pod)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api.example.com:443", "kms.us-east-1.amazonaws.com:443", "[::1]:8080"}, allowed)
+
+	for _, invalid := range []string{"api.example.com", ":443", "api.example.com:https", "api.example.com:0"} {
+		pod.Annotations[EgressAnnotation] = invalid
+		_, err := egressAllowlist(pod)
+		assert.NotNil(t, err, invalid)
+	}
+
+	// The agent is configured at launch, so changing destinations relaunches the enclave.
+	updated := pod.DeepCopy()
+	updated.Annotations[EgressAnnotation] = "api.example.com:443"
+	assert.True(t, requiresRebuild(pod, updated))
+}
@@ -0,0 +1,149 @@
+package node
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ResourceGetter looks up the objects referenced by pod specs.
+type ResourceGetter interface {
+	GetConfigMap(name, namespace string) (*corev1.ConfigMap, error)
+	GetSecret(name, namespace string) (*corev1.Secret, error)
+}
+
+// resolveEnvironment returns the environment of a container with its envFrom
+// sources and valueFrom references to ConfigMaps and Secrets replaced by their
+// values. As with the kubelet, variables in env override those of envFrom.
+func resolveEnvironment(resources ResourceGetter, namespace string, spec *corev1.Container) ([]corev1.EnvVar, error) {
+	env := make([]corev1.EnvVar, 0, len(spec.Env))
+	index := make(map[string]int)
+	set := func(name, value string) {
+		if i, ok := index[name]; ok {
+			env[i].Value = value
+			return
+		}
+		index[name] = len(env)
+		env = append(env, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	for _, from := range spec.EnvFrom {
+		var data map[string]string
+		switch {
+		case from.ConfigMapRef != nil:
+			ref := from.ConfigMapRef
+			cm, err := getConfigMap(resources, ref.Name, namespace, ref.Optional)
+			if err != nil {
+				return nil, err
+			}
+			if cm != nil {
+				data = cm.Data
+			}
+		case from.SecretRef != nil:
+			ref := from.SecretRef
+			secret, err := getSecret(resources, ref.Name, namespace, ref.Optional)
+			if err != nil {
+				return nil, err
+			}
+			if secret != nil {
+				data = make(map[string]string, len(secret.Data))
+				for key, value := range secret.Data {
+					data[key] = string(value)
+				}
+			}
+		}
+		for key, value := range data {
+			name := from.Prefix + key
+			// Keys which are not valid variable names are skipped, as the kubelet does.
+			if len(validation.IsEnvVarName(name)) > 0 {
+				continue
+			}
+			set(name, value)
+		}
+	}
+
+	for _, e := range spec.Env {
+		if e.ValueFrom == nil {
+			set(e.Name, e.Value)
+			continue
+		}
+
+		switch {
+		case e.ValueFrom.ConfigMapKeyRef != nil:
+			ref := e.ValueFrom.ConfigMapKeyRef
+			cm, err := getConfigMap(resources, ref.Name, namespace, ref.Optional)
+			if err != nil {
+				return nil, err
+			}
+			if cm == nil {
+				continue
+			}
+			value, ok := cm.Data[ref.Key]
+			if !ok {
+				if isOptional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("couldn't find key %q in configmap %s/%s", ref.Key, namespace, ref.Name)
+			}
+			set(e.Name, value)
+		case e.ValueFrom.SecretKeyRef != nil:
+			ref := e.ValueFrom.SecretKeyRef
+			secret, err := getSecret(resources, ref.Name, namespace, ref.Optional)
+			if err != nil {
+				return nil, err
+			}
+			if secret == nil {
+				continue
+			}
+			value, ok := secret.Data[ref.Key]
+			if !ok {
+				if isOptional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("couldn't find key %q in secret %s/%s", ref.Key, namespace, ref.Name)
+			}
+			set(e.Name, string(value))
+		default:
+			// Field references are resolved by the pod controller before the pod reaches the provider.
+			set(e.Name, e.Value)
+		}
+	}
+
+	return env, nil
+}
+
+// getConfigMap returns the named ConfigMap, or nil if it is optional and does not exist.
+func getConfigMap(resources ResourceGetter, name, namespace string, optional *bool) (*corev1.ConfigMap, error) {
+	if resources == nil {
+		return nil, fmt.Errorf("cannot resolve configmap %s/%s: no resource getter configured", namespace, name)
+	}
+	cm, err := resources.GetConfigMap(name, namespace)
+	if apierrors.IsNotFound(err) && isOptional(optional) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get configmap %s/%s: %w", namespace, name, err)
+	}
+	return cm, nil
+}
+
+// getSecret returns the named Secret, or nil if it is optional and does not exist.
+func getSecret(resources ResourceGetter, name, namespace string, optional *bool) (*corev1.Secret, error) {
+	if resources == nil {
+		return nil, fmt.Errorf("cannot resolve secret %s/%s: no resource getter configured", namespace, name)
+	}
+	secret, err := resources.GetSecret(name, namespace)
+	if apierrors.IsNotFound(err) && isOptional(optional) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get secret %s/%s: %w", namespace, name, err)
+	}
+	return secret, nil
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
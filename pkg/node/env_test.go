@@ -0,0 +1,89 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeResources struct {
+	configMaps map[string]*corev1.ConfigMap
+	secrets    map[string]*corev1.Secret
+}
+
+func (f fakeResources) GetConfigMap(name, namespace string) (*corev1.ConfigMap, error) {
+	if cm, ok := f.configMaps[namespace+"/"+name]; ok {
+		return cm, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+}
+
+func (f fakeResources) GetSecret(name, namespace string) (*corev1.Secret, error) {
+	if secret, ok := f.secrets[namespace+"/"+name]; ok {
+		return secret, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+}
+
+func TestResolveEnvironment(t *testing.T) {
+	optional := true
+	resources := fakeResources{
+		configMaps: map[string]*corev1.ConfigMap{
+			"default/config": {
+				ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "default"},
+				Data:       map[string]string{"MODE": "prod", "LEVEL": "info", "NOT=VALID": "x"},
+			},
+		},
+		secrets: map[string]*corev1.Secret{
+			"default/creds": {
+				ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+				Data:       map[string][]byte{"password": []byte("hunter2")},
+			},
+		},
+	}
+
+	spec := &corev1.Container{
+		EnvFrom: []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+			{Prefix: "DB_", SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}}},
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &optional}},
+		},
+		Env: []corev1.EnvVar{
+			{Name: "LEVEL", Value: "debug"},
+			{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}, Key: "password",
+			}}},
+			{Name: "OPTIONAL", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "config"}, Key: "absent", Optional: &optional,
+			}}},
+		},
+	}
+
+	env, err := resolveEnvironment(resources, "default", spec)
+	assert.Nil(t, err)
+	values := make(map[string]string)
+	for _, e := range env {
+		values[e.Name] = e.Value
+	}
+	assert.Equal(t, map[string]string{
+		"MODE":        "prod",
+		"LEVEL":       "debug",
+		"DB_password": "hunter2",
+		"PASSWORD":    "hunter2",
+	}, values)
+
+	// Required references must exist.
+	spec = &corev1.Container{
+		Env: []corev1.EnvVar{
+			{Name: "MODE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "other"}, Key: "MODE",
+			}}},
+		},
+	}
+	_, err = resolveEnvironment(resources, "default", spec)
+	assert.NotNil(t, err)
+}
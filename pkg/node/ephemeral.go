@@ -0,0 +1,225 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ephemeralResyncPeriod is how often the ephemeral containers of pods are
+	// checked again, starting those added before the enclave of their pod ran.
+	ephemeralResyncPeriod = 10 * time.Second
+
+	// Reasons reported for ephemeral containers, matching the kubelet's.
+	containerReasonCreating   = "ContainerCreating"
+	containerReasonStartError = "StartError"
+)
+
+// ephemeralContainer is an ephemeral container of a pod, such as the debug
+// container added by kubectl debug. It runs as a helper process of the agent
+// inside the enclave of the pod, not in an enclave of its own, so its image
+// is not used: its command must exist in the enclave image.
+type ephemeralContainer struct {
+	state      containerState
+	startedAt  metav1.Time
+	terminated *corev1.ContainerStateTerminated
+}
+
+// WatchEphemeralContainers starts the ephemeral containers added to the pods
+// of the node, until the context is done. The pod controller does not pass
+// them to the provider, which only receives the updates of regular containers.
+func (n *Node) WatchEphemeralContainers(ctx context.Context) {
+	if n.client == nil {
+		return
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(n.client, ephemeralResyncPeriod,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", n.name).String()
+		}))
+	handle := func(obj interface{}) {
+		spec, ok := obj.(*corev1.Pod)
+		if !ok || len(spec.Spec.EphemeralContainers) == 0 {
+			return
+		}
+		pod, err := n.GetPodByUID(spec.Namespace, spec.Name, spec.UID)
+		if err != nil {
+			return
+		}
+		pod.syncEphemeralContainers(ctx, spec.Spec.EphemeralContainers)
+	}
+	_, err := factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    handle,
+		UpdateFunc: func(_, obj interface{}) { handle(obj) },
+	})
+	if err != nil {
+		log.G(ctx).Errorf("Failed to watch ephemeral containers: %v.\n", err)
+		return
+	}
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+// syncEphemeralContainers records the ephemeral containers of the pod, and
+// starts those which did not run yet once its enclave runs. Like the kubelet,
+// ephemeral containers run once and are never restarted.
+func (pod *Pod) syncEphemeralContainers(ctx context.Context, containers []corev1.EphemeralContainer) {
+	pod.mu.Lock()
+	changed := false
+	if pod.pod != nil && !equality.Semantic.DeepEqual(pod.pod.Spec.EphemeralContainers, containers) {
+		// Replace the spec rather than modify it, snapshots are read outside the lock.
+		spec := *pod.pod
+		spec.Spec.EphemeralContainers = append([]corev1.EphemeralContainer(nil), containers...)
+		pod.pod = &spec
+		changed = true
+	}
+	var start []corev1.EphemeralContainer
+	if pod.state == containerRunning {
+		if pod.ephemeral == nil {
+			pod.ephemeral = make(map[string]*ephemeralContainer)
+		}
+		for _, c := range containers {
+			if _, ok := pod.ephemeral[c.Name]; ok {
+				continue
+			}
+			pod.ephemeral[c.Name] = &ephemeralContainer{state: containerWaiting}
+			start = append(start, c)
+		}
+	}
+	cid := pod.info.EnclaveCID
+	hasAgent := pod.hasAgent
+	pod.mu.Unlock()
+
+	for _, c := range start {
+		if !hasAgent {
+			pod.ephemeralExited(ctx, c.Name, containerReasonStartError, "the enclave has no agent to run ephemeral containers", exitCodeUnknown)
+			continue
+		}
+		go pod.runEphemeralContainer(ctx, cid, c)
+	}
+	if changed && len(start) == 0 {
+		pod.notify(ctx)
+	}
+}
+
+// runEphemeralContainer runs an ephemeral container as a helper process in
+// the enclave with the given CID, until it exits.
+func (pod *Pod) runEphemeralContainer(ctx context.Context, cid int, c corev1.EphemeralContainer) {
+	command := append(append([]string(nil), c.Command...), c.Args...)
+	if len(command) == 0 {
+		pod.ephemeralExited(ctx, c.Name, containerReasonStartError, "ephemeral containers run in the enclave of the pod, their command must be set", exitCodeUnknown)
+		return
+	}
+	env, err := resolveEnvironment(pod.resources(), pod.namespace, (*corev1.Container)(&c.EphemeralContainerCommon))
+	if err != nil {
+		pod.ephemeralExited(ctx, c.Name, containerReasonStartError, err.Error(), exitCodeUnknown)
+		return
+	}
+	req := agent.HelperRequest{Name: c.Name, Command: command, Stdin: c.Stdin, TTY: c.TTY}
+	for _, e := range env {
+		req.Env = append(req.Env, e.Name+"="+e.Value)
+	}
+
+	conn, err := dialAgent(cid, agent.HelperPort)
+	if err != nil {
+		pod.ephemeralExited(ctx, c.Name, containerReasonStartError, "failed to reach the enclave agent: "+err.Error(), exitCodeUnknown)
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	wait, err := agent.StartHelper(conn, req)
+	if err != nil {
+		pod.ephemeralExited(ctx, c.Name, containerReasonStartError, err.Error(), exitCodeUnknown)
+		return
+	}
+	log.G(ctx).Infof("started ephemeral container %s of pod %s/%s", c.Name, pod.namespace, pod.name)
+	pod.event(corev1.EventTypeNormal, eventReasonStarted, "Started ephemeral container %s", c.Name)
+	pod.mu.Lock()
+	if e := pod.ephemeral[c.Name]; e != nil {
+		e.state = containerRunning
+		e.startedAt = metav1.Now()
+	}
+	pod.mu.Unlock()
+	pod.notify(ctx)
+
+	result, err := wait()
+	switch {
+	case err != nil:
+		pod.ephemeralExited(ctx, c.Name, containerReasonError, "the enclave agent went away", exitCodeUnknown)
+	case result.ExitCode != 0:
+		pod.ephemeralExited(ctx, c.Name, containerReasonError, "", int32(result.ExitCode))
+	default:
+		pod.ephemeralExited(ctx, c.Name, containerReasonCompleted, "", 0)
+	}
+}
+
+// ephemeralExited records that an ephemeral container exited, or could not start.
+func (pod *Pod) ephemeralExited(ctx context.Context, name, reason, message string, exitCode int32) {
+	if reason == containerReasonStartError {
+		log.G(ctx).Warnf("Failed to start ephemeral container %s of pod %s/%s: %s", name, pod.namespace, pod.name, message)
+		pod.warning(eventReasonFailed, "Error: %s", message)
+	}
+
+	pod.mu.Lock()
+	if e := pod.ephemeral[name]; e != nil {
+		e.state = containerTerminated
+		e.terminated = &corev1.ContainerStateTerminated{
+			ExitCode:   exitCode,
+			Reason:     reason,
+			Message:    message,
+			StartedAt:  e.startedAt,
+			FinishedAt: metav1.Now(),
+		}
+	}
+	pod.mu.Unlock()
+	pod.notify(ctx)
+}
+
+// isEphemeralContainer tells whether the pod has an ephemeral container of the given name.
+func (pod *Pod) isEphemeralContainer(name string) bool {
+	pod.mu.RLock()
+	defer pod.mu.RUnlock()
+	_, ok := pod.ephemeral[name]
+	return ok
+}
+
+// ephemeralContainerStatuses returns the statuses of the ephemeral containers
+// of the pod. The caller holds pod.mu.
+func (pod *Pod) ephemeralContainerStatuses() []corev1.ContainerStatus {
+	if pod.pod == nil {
+		return nil
+	}
+	var statuses []corev1.ContainerStatus
+	for _, c := range pod.pod.Spec.EphemeralContainers {
+		status := corev1.ContainerStatus{Name: c.Name, Image: c.Image}
+		e := pod.ephemeral[c.Name]
+		switch {
+		case e == nil || e.state == containerWaiting:
+			status.State.Waiting = &corev1.ContainerStateWaiting{Reason: containerReasonCreating}
+		case e.state == containerRunning:
+			started := true
+			status.Started = &started
+			status.State.Running = &corev1.ContainerStateRunning{StartedAt: e.startedAt}
+		default:
+			status.State.Terminated = e.terminated.DeepCopy()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
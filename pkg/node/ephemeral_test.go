@@ -0,0 +1,43 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncEphemeralContainers(t *testing.T) {
+	pod := &Pod{
+		namespace: "default",
+		name:      "web",
+		pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}},
+		state:     containerWaiting,
+	}
+	debugger := corev1.EphemeralContainer{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+		Name: "debugger", Image: "busybox", Command: []string{"sh"},
+	}}
+
+	// Ephemeral containers wait for the enclave of their pod.
+	pod.syncEphemeralContainers(context.Background(), []corev1.EphemeralContainer{debugger})
+	statuses := pod.GetStatus().EphemeralContainerStatuses
+	if assert.Len(t, statuses, 1) && assert.NotNil(t, statuses[0].State.Waiting) {
+		assert.Equal(t, containerReasonCreating, statuses[0].State.Waiting.Reason)
+	}
+	assert.False(t, pod.isEphemeralContainer("debugger"))
+
+	// Enclaves without agent cannot run them.
+	pod.state = containerRunning
+	pod.syncEphemeralContainers(context.Background(), []corev1.EphemeralContainer{debugger})
+	statuses = pod.GetStatus().EphemeralContainerStatuses
+	if assert.Len(t, statuses, 1) && assert.NotNil(t, statuses[0].State.Terminated) {
+		assert.Equal(t, containerReasonStartError, statuses[0].State.Terminated.Reason)
+	}
+	assert.True(t, pod.isEphemeralContainer("debugger"))
+
+	// They run once.
+	pod.syncEphemeralContainers(context.Background(), []corev1.EphemeralContainer{debugger})
+	assert.NotNil(t, pod.GetStatus().EphemeralContainerStatuses[0].State.Terminated)
+}
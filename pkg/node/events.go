@@ -0,0 +1,52 @@
+package node
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of the events recorded for the enclave lifecycle.
+const (
+	eventReasonBuilding         = "Building"
+	eventReasonBuilt            = "Built"
+	eventReasonBuildFailed      = "BuildFailed"
+	eventReasonEnclaveStarted   = "EnclaveStarted"
+	eventReasonEnclaveFailed    = "EnclaveFailed"
+	eventReasonEnclaveExited    = "EnclaveExited"
+	eventReasonBackOff          = "BackOff"
+	eventReasonKilling          = "Killing"
+	eventReasonAttested         = "Attested"
+	eventReasonAttestFailed     = "AttestationFailed"
+	eventReasonEvicted          = "Evicted"
+	eventReasonEnclaveLost      = "EnclaveLost"
+	eventReasonHostPortConflict = "HostPortConflict"
+	eventReasonImageAllowed     = "ImageAllowed"
+
+	// Reasons of the events recorded for failed lifecycle hooks, matching the kubelet's.
+	eventReasonFailedPostStartHook = "FailedPostStartHook"
+	eventReasonFailedPreStopHook   = "FailedPreStopHook"
+
+	// Reasons of the events recorded for ephemeral containers, matching the kubelet's.
+	eventReasonStarted = "Started"
+	eventReasonFailed  = "Failed"
+)
+
+// event records a Kubernetes event about the pod, shown by kubectl describe.
+func (pod *Pod) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if pod.node == nil || pod.node.recorder == nil {
+		return
+	}
+
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return
+	}
+
+	pod.node.recorder.Eventf(spec, eventType, reason, messageFmt, args...)
+}
+
+// warning records a warning event about the pod.
+func (pod *Pod) warning(reason, messageFmt string, args ...interface{}) {
+	pod.event(corev1.EventTypeWarning, reason, messageFmt, args...)
+}
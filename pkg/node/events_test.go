@@ -0,0 +1,33 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEvent(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	pod := &Pod{
+		namespace: "default",
+		name:      "nginx",
+		node:      &Node{recorder: recorder},
+		pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"}},
+	}
+	pod.info.EnclaveID = "i-1234-enc5678"
+
+	pod.kill(context.Background(), "liveness probe failed")
+	assert.Equal(t, "Normal Killing Killing enclave i-1234-enc5678: liveness probe failed", <-recorder.Events)
+
+	pod.warning(eventReasonBackOff, "Back-off %s restarting failed enclave", "10s")
+	assert.Equal(t, "Warning BackOff Back-off 10s restarting failed enclave", <-recorder.Events)
+
+	// Nothing is recorded without a recorder.
+	pod.node = &Node{}
+	pod.warning(eventReasonBackOff, "ignored")
+	assert.Len(t, recorder.Events, 0)
+}
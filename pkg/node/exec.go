@@ -0,0 +1,140 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	utilexec "k8s.io/utils/exec"
+)
+
+// dialAgent connects to a port of the agent of the enclave with the given CID.
+func dialAgent(cid int, port uint32) (net.Conn, error) {
+	return transport.Dial(uint32(cid), port)
+}
+
+// RunInContainer runs a command in the enclave of a pod through its agent,
+// streaming the command's stdio from and to attach.
+func (n *Node) RunInContainer(ctx context.Context, namespace, name string, cmd []string, attach api.AttachIO) error {
+	pod, err := n.GetPod(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	pod.mu.RLock()
+	cid := pod.info.EnclaveCID
+	running := pod.state == containerRunning
+	pod.mu.RUnlock()
+	if !running {
+		return errdefs.InvalidInputf("container of pod %s/%s is not running", namespace, name)
+	}
+
+	conn, err := dialAgent(cid, agent.ExecPort)
+	if err != nil {
+		return fmt.Errorf("failed to reach the enclave agent: %v", err)
+	}
+	defer conn.Close()
+
+	// Abort the session when the client goes away.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	result, err := agent.ExecInteractive(conn, agent.ExecRequest{Command: cmd, TTY: attach.TTY()}, execStreams(ctx, attach))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with non-zero exit code %d", result.ExitCode),
+			Code: result.ExitCode,
+		}
+	}
+	return nil
+}
+
+// AttachToContainer attaches to the stdio of the workload running in the
+// enclave of a pod, or to that of one of its ephemeral containers, through its agent.
+func (n *Node) AttachToContainer(ctx context.Context, namespace, name, container string, attach api.AttachIO) error {
+	pod, err := n.GetPod(namespace, name)
+	if err != nil {
+		return err
+	}
+
+	pod.mu.RLock()
+	cid := pod.info.EnclaveCID
+	running := pod.state == containerRunning
+	pod.mu.RUnlock()
+	if !running {
+		return errdefs.InvalidInputf("container of pod %s/%s is not running", namespace, name)
+	}
+
+	ephemeral := pod.isEphemeralContainer(container)
+	port := uint32(agent.AttachPort)
+	if ephemeral {
+		port = agent.HelperPort
+	}
+	conn, err := dialAgent(cid, port)
+	if err != nil {
+		return fmt.Errorf("failed to reach the enclave agent: %v", err)
+	}
+	defer conn.Close()
+
+	// Detach when the client goes away.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if ephemeral {
+		_, err = agent.AttachHelper(conn, container, execStreams(ctx, attach))
+		return err
+	}
+	_, err = agent.Attach(conn, execStreams(ctx, attach))
+	return err
+}
+
+// execStreams adapts the streams of an exec or attach request to the agent's.
+func execStreams(ctx context.Context, attach api.AttachIO) agent.ExecStreams {
+	streams := agent.ExecStreams{
+		Stdin: attach.Stdin(),
+	}
+	if stdout := attach.Stdout(); stdout != nil {
+		streams.Stdout = stdout
+	}
+	if stderr := attach.Stderr(); stderr != nil {
+		streams.Stderr = stderr
+	}
+	if attach.TTY() {
+		resize := make(chan agent.TermSize)
+		go func() {
+			defer close(resize)
+			for {
+				select {
+				case size, ok := <-attach.Resize():
+					if !ok {
+						return
+					}
+					select {
+					case resize <- agent.TermSize{Width: size.Width, Height: size.Height}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		streams.Resize = resize
+	}
+	return streams
+}
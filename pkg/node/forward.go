@@ -0,0 +1,61 @@
+package node
+
+import (
+	"errors"
+	"io"
+	"net"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// vsockForward is a host vsock port shared by the enclaves forwarded through it.
+type vsockForward struct {
+	forwarder *nitro.Forwarder
+	listener  net.Listener
+}
+
+// forwardVsock forwards the connections which the enclave with the given
+// CID makes to a host vsock port to a TCP destination, until the returned
+// closer is closed. The port is listened on while any enclave uses it.
+func (n *Node) forwardVsock(port, cid uint32, dest string) (io.Closer, error) {
+	n.forwardMu.Lock()
+	defer n.forwardMu.Unlock()
+
+	fwd, ok := n.forwards[port]
+	if !ok {
+		listener, err := transport.Listen(port)
+		if err != nil {
+			return nil, err
+		}
+		fwd = &vsockForward{forwarder: nitro.NewForwarder(), listener: listener}
+		if n.forwards == nil {
+			n.forwards = make(map[uint32]*vsockForward)
+		}
+		n.forwards[port] = fwd
+		go func() {
+			if err := fwd.forwarder.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.L.Errorf("vsock forwarder on port %d stopped: %v", port, err)
+			}
+		}()
+	}
+	fwd.forwarder.Route(cid, dest)
+
+	return closerFunc(func() error {
+		n.forwardMu.Lock()
+		defer n.forwardMu.Unlock()
+		if fwd.forwarder.Unroute(cid) > 0 || n.forwards[port] != fwd {
+			return nil
+		}
+		delete(n.forwards, port)
+		return fwd.listener.Close()
+	}), nil
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
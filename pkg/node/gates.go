@@ -0,0 +1,71 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podReasonReadinessGatesNotReady tells a pod whose container is ready is not,
+// as some of its readiness gates are not true. It matches the kubelet's.
+const podReasonReadinessGatesNotReady = "ReadinessGatesNotReady"
+
+// workloadCondition is a pod condition set by the workload of the enclave.
+type workloadCondition struct {
+	status  corev1.ConditionStatus
+	message string
+}
+
+// setCondition records a pod condition reported by the workload through the
+// agent, and notifies the pod controller when it changes.
+func (pod *Pod) setCondition(ctx context.Context, e agent.Event) {
+	if e.Condition == "" {
+		log.G(ctx).Warnf("ignoring condition event without condition from pod %s/%s", pod.namespace, pod.name)
+		return
+	}
+	condition := workloadCondition{status: corev1.ConditionFalse, message: e.Message}
+	if e.Status {
+		condition.status = corev1.ConditionTrue
+	}
+
+	pod.mu.Lock()
+	if pod.conditions == nil {
+		pod.conditions = make(map[corev1.PodConditionType]workloadCondition)
+	}
+	conditionType := corev1.PodConditionType(e.Condition)
+	changed := pod.conditions[conditionType] != condition
+	pod.conditions[conditionType] = condition
+	pod.mu.Unlock()
+
+	if changed {
+		log.G(ctx).Infof("workload of pod %s/%s set condition %s to %s", pod.namespace, pod.name, conditionType, condition.status)
+		pod.notify(ctx)
+	}
+}
+
+// readinessGates returns the conditions of the readiness gates of the pod, as
+// set by its workload, and why they keep the pod from being ready, if they
+// do. The caller holds pod.mu.
+func (pod *Pod) readinessGates() ([]corev1.PodCondition, string) {
+	if pod.pod == nil {
+		return nil, ""
+	}
+	var conditions []corev1.PodCondition
+	var messages []string
+	for _, gate := range pod.pod.Spec.ReadinessGates {
+		condition, ok := pod.conditions[gate.ConditionType]
+		if !ok {
+			messages = append(messages, fmt.Sprintf("corresponding condition of pod readiness gate %q does not exist.", gate.ConditionType))
+			continue
+		}
+		conditions = append(conditions, corev1.PodCondition{Type: gate.ConditionType, Status: condition.status, Message: condition.message})
+		if condition.status != corev1.ConditionTrue {
+			messages = append(messages, fmt.Sprintf("the status of pod readiness gate %q is not \"True\", but %s.", gate.ConditionType, condition.status))
+		}
+	}
+	return conditions, strings.Join(messages, ", ")
+}
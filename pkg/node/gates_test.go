@@ -0,0 +1,46 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestReadinessGates(t *testing.T) {
+	pod := &Pod{namespace: "default", name: "web", phase: corev1.PodRunning, state: containerRunning, ready: true,
+		pod: &corev1.Pod{Spec: corev1.PodSpec{
+			Containers:     []corev1.Container{{Name: "web"}},
+			ReadinessGates: []corev1.PodReadinessGate{{ConditionType: "attestation-complete"}},
+		}}}
+	condition := func(status corev1.PodStatus, conditionType corev1.PodConditionType) *corev1.PodCondition {
+		for i := range status.Conditions {
+			if status.Conditions[i].Type == conditionType {
+				return &status.Conditions[i]
+			}
+		}
+		return nil
+	}
+
+	// The container is ready, the pod waits for its gates.
+	status := pod.GetStatus()
+	assert.True(t, status.ContainerStatuses[0].Ready)
+	assert.Equal(t, corev1.ConditionTrue, condition(status, corev1.ContainersReady).Status)
+	ready := condition(status, corev1.PodReady)
+	assert.Equal(t, corev1.ConditionFalse, ready.Status)
+	assert.Equal(t, podReasonReadinessGatesNotReady, ready.Reason)
+	assert.Equal(t, `corresponding condition of pod readiness gate "attestation-complete" does not exist.`, ready.Message)
+	assert.Nil(t, condition(status, "attestation-complete"))
+
+	pod.handleEvent(context.Background(), agent.Event{Type: agent.EventCondition, Condition: "attestation-complete", Message: "attesting"})
+	status = pod.GetStatus()
+	assert.Equal(t, corev1.ConditionFalse, condition(status, corev1.PodReady).Status)
+	assert.Equal(t, "attesting", condition(status, "attestation-complete").Message)
+
+	pod.handleEvent(context.Background(), agent.Event{Type: agent.EventCondition, Condition: "attestation-complete", Status: true})
+	status = pod.GetStatus()
+	assert.Equal(t, corev1.ConditionTrue, condition(status, corev1.PodReady).Status)
+	assert.Equal(t, corev1.ConditionTrue, condition(status, "attestation-complete").Status)
+}
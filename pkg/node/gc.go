@@ -0,0 +1,73 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+// orphanCollectionInterval is how often enclaves are checked against the pods of the API server.
+const orphanCollectionInterval = time.Minute
+
+// CollectOrphans periodically terminates the enclaves whose pods no longer
+// exist in the API server, such as pods deleted while the node was down,
+// until the context is done. The pod controller only removes such pods when
+// it starts, and by name, missing pods recreated with the same name.
+func (n *Node) CollectOrphans(ctx context.Context) {
+	if n.client == nil {
+		return
+	}
+
+	ticker := time.NewTicker(orphanCollectionInterval)
+	defer ticker.Stop()
+	for {
+		if err := n.collectOrphans(ctx); err != nil {
+			log.G(ctx).Warnf("failed to collect orphan enclaves: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectOrphans stops the pods of this node which are missing from the API server.
+func (n *Node) collectOrphans(ctx context.Context) error {
+	// Pods are listed after taking the node's pods, so pods created meanwhile are not mistaken for orphans.
+	pods, err := n.GetPods()
+	if err != nil {
+		return err
+	}
+
+	list, err := n.client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", n.name).String(),
+	})
+	if err != nil {
+		return err
+	}
+	uids := make(map[k8sTypes.UID]bool)
+	names := make(map[string]bool)
+	for _, p := range list.Items {
+		uids[p.UID] = true
+		names[podKey(p.Namespace, p.Name)] = true
+	}
+
+	for _, pod := range pods {
+		// Enclaves launched before the UID was part of their tag only know their pod by name.
+		if pod.uid != "" && uids[pod.uid] || pod.uid == "" && names[podKey(pod.namespace, pod.name)] {
+			continue
+		}
+
+		log.G(ctx).Infof("Terminating orphan enclave of deleted pod %s/%s.", pod.namespace, pod.name)
+		if err := pod.Stop(ctx); err != nil {
+			log.G(ctx).Errorf("Failed to stop orphan pod %s/%s: %v.\n", pod.namespace, pod.name, err)
+		}
+	}
+	return nil
+}
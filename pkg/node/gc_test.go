@@ -0,0 +1,52 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCollectOrphans(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kept", UID: "1234"},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "recreated", UID: "9999"},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "legacy", UID: "4321"},
+			Spec:       corev1.PodSpec{NodeName: "node"},
+		},
+	)
+	n := &Node{name: "node", client: client, pods: make(map[string]*Pod), current: make(map[string]string)}
+
+	add := func(name string, uid k8sTypes.UID) {
+		pod := &Pod{namespace: "default", name: name, uid: uid, node: n, phase: corev1.PodRunning}
+		pod.tag = encodeTag(pod.namespace, pod.name, pod.uid)
+		n.InsertPod(pod, pod.tag)
+	}
+	add("kept", "1234")
+	add("deleted", "5678")
+	// Recreated while the node was down, the enclave runs the previous incarnation.
+	add("recreated", "1111")
+	// Enclaves with legacy tags have no UID.
+	add("legacy", "")
+
+	assert.Nil(t, n.collectOrphans(context.Background()))
+
+	pods, err := n.GetPods()
+	assert.Nil(t, err)
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.name)
+	}
+	assert.ElementsMatch(t, []string{"kept", "legacy"}, names)
+}
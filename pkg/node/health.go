@@ -0,0 +1,203 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Node conditions telling which part of the enclave runtime of the host is
+	// broken, so operators and the cluster autoscaler notice broken hosts.
+	NodeEnclaveRuntimeUnavailable   corev1.NodeConditionType = "EnclaveRuntimeUnavailable"
+	NodeEnclaveAllocatorUnavailable corev1.NodeConditionType = "EnclaveAllocatorUnavailable"
+	NodeEnclavePoolUnavailable      corev1.NodeConditionType = "EnclavePoolUnavailable"
+
+	// Reasons of the node not being ready.
+	HealthReasonNitroDriverMissing   = "NitroDriverMissing"
+	HealthReasonAllocatorFailed      = "AllocatorFailed"
+	HealthReasonEnclavePoolUnusable  = "EnclavePoolUnusable"
+	HealthReasonAPIServerUnreachable = "APIServerUnreachable"
+
+	// healthCheckInterval is how often the health of the node is checked.
+	healthCheckInterval = 10 * time.Second
+	// healthCheckTimeout bounds how long the API server is given to answer a health check.
+	healthCheckTimeout = 5 * time.Second
+	// healthStaleness is how old the last health check may get before the
+	// node is considered hung, letting its lease expire.
+	healthStaleness = 6 * healthCheckInterval
+)
+
+var (
+	// nitroDevice is the device of the nitro_enclaves driver, which launches enclaves.
+	nitroDevice = "/dev/nitro_enclaves"
+	// allocatorFailed is swapped out in tests.
+	allocatorFailed = allocator.ServiceFailed
+)
+
+// DeviceHealth tells which parts of the enclave runtime of the host are
+// broken, by their problem, empty when they work.
+type DeviceHealth struct {
+	// Driver is the problem of the nitro_enclaves driver.
+	Driver string
+	// Allocator is the problem of the allocator service.
+	Allocator string
+	// Pool is the problem of the enclave hugepage and CPU pools.
+	Pool string
+}
+
+// Health tells whether the node can run enclaves, and why not when it cannot.
+type Health struct {
+	// Reason is why the node is not ready, empty when it is.
+	Reason string
+	// Message details the reason.
+	Message string
+	// Devices is the health of the enclave runtime of the host.
+	Devices DeviceHealth
+	// Since is when the node last became ready or not ready.
+	Since time.Time
+	// Checked is when the health of the node was last checked.
+	Checked time.Time
+}
+
+// Ready tells whether the node can run enclaves.
+func (h Health) Ready() bool {
+	return h.Reason == ""
+}
+
+// Health returns the health of the node as of the last check.
+func (n *Node) Health() Health {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	return n.health
+}
+
+// NotifyHealth sets the function called whenever the node becomes ready or not ready.
+func (n *Node) NotifyHealth(notifier func(Health)) {
+	n.healthMu.Lock()
+	defer n.healthMu.Unlock()
+	n.healthNotifier = notifier
+}
+
+// Alive tells whether the health of the node is still being checked. A
+// monitor hung on the host makes the node stop renewing its lease, so the
+// cluster marks it unreachable.
+func (n *Node) Alive() error {
+	health := n.Health()
+	if health.Checked.IsZero() {
+		return nil
+	}
+	if since := time.Since(health.Checked); since > healthStaleness {
+		return fmt.Errorf("the health of the node was last checked %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (n *Node) setHealth(health Health) {
+	n.healthMu.Lock()
+	changed := n.health.Checked.IsZero() || n.health.Reason != health.Reason || n.health.Message != health.Message || n.health.Devices != health.Devices
+	if n.health.Checked.IsZero() || n.health.Ready() != health.Ready() {
+		health.Since = health.Checked
+	} else {
+		health.Since = n.health.Since
+	}
+	n.health = health
+	notifier := n.healthNotifier
+	n.healthMu.Unlock()
+
+	if changed && notifier != nil {
+		notifier(health)
+	}
+}
+
+// MonitorHealth periodically checks whether the node can run enclaves until
+// the context is done.
+func (n *Node) MonitorHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		health := n.checkHealth(ctx)
+		if !health.Ready() && health.Reason != n.Health().Reason {
+			log.G(ctx).Warnf("node is not ready: %s", health.Message)
+		}
+		n.setHealth(health)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth checks the nitro_enclaves driver is loaded, the allocator
+// service reserved usable enclave pools and the API server is reachable.
+func (n *Node) checkHealth(ctx context.Context) Health {
+	health := Health{Checked: time.Now(), Devices: checkDevices(ctx)}
+	switch {
+	case health.Devices.Driver != "":
+		health.Reason = HealthReasonNitroDriverMissing
+		health.Message = health.Devices.Driver
+	case health.Devices.Allocator != "":
+		health.Reason = HealthReasonAllocatorFailed
+		health.Message = health.Devices.Allocator
+	case health.Devices.Pool != "":
+		health.Reason = HealthReasonEnclavePoolUnusable
+		health.Message = health.Devices.Pool
+	case n.client != nil:
+		if err := n.pingAPIServer(ctx); err != nil {
+			health.Reason = HealthReasonAPIServerUnreachable
+			health.Message = fmt.Sprintf("the API server is unreachable: %v", err)
+		}
+	}
+	return health
+}
+
+// checkDevices probes the nitro_enclaves driver, the allocator service and
+// the enclave pools it reserved.
+func checkDevices(ctx context.Context) DeviceHealth {
+	var devices DeviceHealth
+	if _, err := os.Stat(nitroDevice); err != nil {
+		devices.Driver = fmt.Sprintf("the nitro_enclaves driver is not loaded: %v", err)
+	}
+
+	// Hosts without systemd reserve the pools otherwise, only a failed service is a problem.
+	if failed, err := allocatorFailed(ctx); err != nil {
+		log.G(ctx).Debugf("failed to check the allocator service: %v", err)
+	} else if failed {
+		devices.Allocator = fmt.Sprintf("%s failed", allocator.ServiceName)
+	}
+
+	capacity, err := readCapacity()
+	switch {
+	case err != nil:
+		devices.Pool = fmt.Sprintf("failed to read the enclave pools: %v", err)
+	case capacity.MemoryMib <= 0:
+		devices.Pool = "the allocator reserved no hugepages for enclaves"
+	case capacity.CPUs <= 0:
+		devices.Pool = "the allocator reserved no CPUs for enclaves"
+	}
+	return devices
+}
+
+// pingAPIServer asks the API server its version, within healthCheckTimeout.
+func (n *Node) pingAPIServer(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := n.client.Discovery().ServerVersion()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
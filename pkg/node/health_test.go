@@ -0,0 +1,57 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckHealth(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	defer func(device string) { nitroDevice = device }(nitroDevice)
+	defer func(f func(context.Context) (bool, error)) { allocatorFailed = f }(allocatorFailed)
+	failed := true
+	allocatorFailed = func(context.Context) (bool, error) { return failed, nil }
+	capacity := &allocator.Capacity{CPUs: 2, MemoryMib: 0}
+	readCapacity = func() (*allocator.Capacity, error) { return capacity, nil }
+	nitroDevice = filepath.Join(t.TempDir(), "nitro_enclaves")
+
+	n := &Node{client: fake.NewSimpleClientset()}
+	var notified []Health
+	n.NotifyHealth(func(h Health) { notified = append(notified, h) })
+
+	n.setHealth(n.checkHealth(context.Background()))
+	assert.Equal(t, HealthReasonNitroDriverMissing, n.Health().Reason)
+	// Every broken part of the enclave runtime is reported, not only the first.
+	devices := n.Health().Devices
+	assert.NotEmpty(t, devices.Driver)
+	assert.Equal(t, "nitro-enclaves-allocator.service failed", devices.Allocator)
+	assert.Equal(t, "the allocator reserved no hugepages for enclaves", devices.Pool)
+
+	assert.Nil(t, os.WriteFile(nitroDevice, nil, 0600))
+	failed = false
+	n.setHealth(n.checkHealth(context.Background()))
+	assert.Equal(t, HealthReasonEnclavePoolUnusable, n.Health().Reason)
+	assert.Equal(t, "the allocator reserved no hugepages for enclaves", n.Health().Message)
+	notReadySince := n.Health().Since
+
+	capacity.MemoryMib = 2048
+	n.setHealth(n.checkHealth(context.Background()))
+	assert.True(t, n.Health().Ready())
+	assert.True(t, n.Health().Since.After(notReadySince))
+	assert.Nil(t, n.Alive())
+
+	// Checks finding the node unchanged notify nothing.
+	n.setHealth(n.checkHealth(context.Background()))
+	assert.Len(t, notified, 3)
+	assert.Equal(t, DeviceHealth{}, n.Health().Devices)
+
+	n.health.Checked = time.Now().Add(-2 * healthStaleness)
+	assert.Error(t, n.Alive())
+}
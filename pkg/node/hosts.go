@@ -0,0 +1,62 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// hostsFile returns the /etc/hosts of the enclave of a pod, as the kubelet
+// generates it. The hostname of the pod resolves to the loopback, where the
+// workload listens inside the enclave, and the host aliases of the pod follow.
+func hostsFile(pod *corev1.Pod, clusterDomain string) []byte {
+	var b strings.Builder
+	b.WriteString("# Kubernetes-managed hosts file.\n")
+	b.WriteString("127.0.0.1\tlocalhost\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	b.WriteString("fe00::0\tip6-localnet\n")
+	b.WriteString("fe00::0\tip6-mcastprefix\n")
+	b.WriteString("fe00::1\tip6-allnodes\n")
+	b.WriteString("fe00::2\tip6-allrouters\n")
+
+	hostname := pod.Name
+	if pod.Spec.Hostname != "" {
+		hostname = pod.Spec.Hostname
+	}
+	if pod.Spec.Subdomain != "" {
+		fmt.Fprintf(&b, "127.0.0.1\t%s.%s.%s.svc.%s\t%s\n", hostname, pod.Spec.Subdomain, pod.Namespace, clusterDomain, hostname)
+	} else {
+		fmt.Fprintf(&b, "127.0.0.1\t%s\n", hostname)
+	}
+
+	if len(pod.Spec.HostAliases) > 0 {
+		b.WriteString("\n# Entries added by HostAliases.\n")
+		for _, alias := range pod.Spec.HostAliases {
+			fmt.Fprintf(&b, "%s\t%s\n", alias.IP, strings.Join(alias.Hostnames, "\t"))
+		}
+	}
+	return []byte(b.String())
+}
+
+// resolverFiles returns the /etc/hosts and, when the pod resolves names, the
+// /etc/resolv.conf generated for its enclave, replacing the base image's.
+func (pod *Pod) resolverFiles() []build.File {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	clusterDomain := defaultClusterDomain
+	if pod.node != nil && pod.node.clusterDomain != "" {
+		clusterDomain = pod.node.clusterDomain
+	}
+
+	files := []build.File{{Path: "/etc/hosts", Content: hostsFile(spec, clusterDomain), Mode: "0644"}}
+	if pod.dns() != nil {
+		files = append(files, build.File{Path: "/etc/resolv.conf", Content: resolvConf(spec, clusterDomain), Mode: "0644"})
+	}
+	return files
+}
@@ -0,0 +1,216 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AdmissionReasonImage rejects the pods whose images may not run in enclaves
+// on the node.
+const AdmissionReasonImage = "ImageNotAllowed"
+
+// defaultRegistry is the registry of the images whose reference names none.
+const defaultRegistry = "docker.io"
+
+// imageReference is a normalized image reference, with the registry and the
+// library/ namespace of Docker Hub it may leave out.
+type imageReference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseImageReference parses an image reference such as nginx,
+// registry.example.com:5000/team/web:1.0 or web@sha256:... .
+func parseImageReference(image string) (imageReference, error) {
+	var ref imageReference
+	name, digest, pinned := strings.Cut(image, "@")
+	if pinned {
+		if !validDigest(digest) {
+			return ref, fmt.Errorf("invalid digest %q in image %q", digest, image)
+		}
+		ref.digest = digest
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.tag = name[:i], name[i+1:]
+		if ref.tag == "" {
+			return ref, fmt.Errorf("invalid image %q, empty tag", image)
+		}
+	}
+	ref.registry, ref.repository = splitRegistry(name)
+	if ref.repository == "" || strings.HasPrefix(ref.repository, "/") || strings.HasSuffix(ref.repository, "/") ||
+		strings.Contains(ref.repository, "//") || ref.repository != strings.ToLower(ref.repository) {
+		return ref, fmt.Errorf("invalid image %q", image)
+	}
+	if ref.registry == defaultRegistry && !strings.Contains(ref.repository, "/") {
+		ref.repository = "library/" + ref.repository
+	}
+	return ref, nil
+}
+
+// splitRegistry splits a name in the registry it starts with, the default
+// registry when it starts with none, and the path after it.
+func splitRegistry(name string) (registry, path string) {
+	first, rest, found := strings.Cut(name, "/")
+	if !found || !isRegistry(first) {
+		return defaultRegistry, name
+	}
+	if first == "index.docker.io" {
+		first = defaultRegistry
+	}
+	return first, rest
+}
+
+// isRegistry tells whether the first component of a name is a registry host,
+// which has a domain, a port or is localhost, rather than a repository.
+func isRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// validDigest tells whether a digest is a sha256 one.
+func validDigest(digest string) bool {
+	hex, ok := strings.CutPrefix(digest, "sha256:")
+	if !ok || len(hex) != 64 {
+		return false
+	}
+	for _, c := range hex {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isRegistryPattern tells whether a pattern is a registry, such as
+// registry.example.com:5000 or localhost, rather than a repository such as
+// nginx:1.25 which has no domain.
+func isRegistryPattern(pattern string) bool {
+	host, _, _ := strings.Cut(pattern, ":")
+	return !strings.Contains(pattern, "/") && (strings.Contains(host, ".") || host == "localhost")
+}
+
+// ValidateImagePattern checks a pattern of the images allowed or denied on a
+// node. See matchImage for the patterns.
+func ValidateImagePattern(pattern string) error {
+	if strings.HasPrefix(pattern, "sha256:") {
+		if !validDigest(pattern) {
+			return fmt.Errorf("invalid digest %q", pattern)
+		}
+		return nil
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		if prefix == "" || strings.ContainsAny(prefix, "*@") {
+			return fmt.Errorf("invalid image prefix %q", pattern)
+		}
+		_, err := parseImageReference(prefix + "/image")
+		return err
+	}
+	if strings.Contains(pattern, "*") {
+		return fmt.Errorf("invalid image pattern %q, wildcards may only end a prefix such as registry.example.com/team/*", pattern)
+	}
+	if isRegistryPattern(pattern) {
+		return nil
+	}
+	_, err := parseImageReference(pattern)
+	return err
+}
+
+// matchImage tells whether an image matches a pattern, which is one of:
+//   - a registry, such as registry.example.com, matching all of its images;
+//   - a prefix ending with /*, such as registry.example.com/team/*, matching
+//     the repositories under it;
+//   - a repository, such as nginx or registry.example.com/web, matching all
+//     of its tags and digests, or a reference with a tag or digest matching
+//     only those;
+//   - a digest, such as sha256:..., matching the images pinned to it.
+func matchImage(pattern string, image imageReference) bool {
+	if strings.HasPrefix(pattern, "sha256:") {
+		return image.digest == pattern
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		registry, path := splitRegistry(prefix + "/")
+		path = strings.TrimSuffix(path, "/")
+		return image.registry == registry && (path == "" || strings.HasPrefix(image.repository, path+"/"))
+	}
+	if isRegistryPattern(pattern) {
+		registry, _ := splitRegistry(pattern + "/")
+		return image.registry == registry
+	}
+	ref, err := parseImageReference(pattern)
+	if err != nil || ref.registry != image.registry || ref.repository != image.repository {
+		return false
+	}
+	return (ref.tag == "" || ref.tag == image.tag) && (ref.digest == "" || ref.digest == image.digest)
+}
+
+// imageAllowed returns the pattern allowing an image given the patterns
+// allowed and denied on a node, empty when every image is allowed, or why
+// the image is not allowed. Every image is allowed when allowed is empty,
+// and denied ones are denied even if allowed.
+func imageAllowed(allowed, denied []string, image string) (string, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return "", err
+	}
+	for _, pattern := range denied {
+		if matchImage(pattern, ref) {
+			return "", fmt.Errorf("image %q is denied by %q", image, pattern)
+		}
+	}
+	if len(allowed) == 0 {
+		return "", nil
+	}
+	for _, pattern := range allowed {
+		if matchImage(pattern, ref) {
+			return pattern, nil
+		}
+	}
+	return "", fmt.Errorf("image %q is not allowed", image)
+}
+
+// imageError returns why a pod cannot run on a node given the images allowed
+// and denied to run in enclaves there, nil if it can.
+func imageError(allowed, denied []string, pod *corev1.Pod) *AdmissionError {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if _, err := imageAllowed(allowed, denied, c.Image); err != nil {
+			return unsupportedf(AdmissionReasonImage, "container %q may not run in an enclave on this node: %v", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// images returns the image patterns allowed and denied to run in enclaves on the node.
+func (n *Node) images() (allowed, denied []string) {
+	n.RLock()
+	defer n.RUnlock()
+	return n.allowedImages, n.deniedImages
+}
+
+// recordImagesAllowed records that the image policy of the node allows the
+// images of an admitted pod with an event, naming the pattern allowing them.
+func (pod *Pod) recordImagesAllowed() {
+	if pod.node == nil {
+		return
+	}
+	allowed, denied := pod.node.images()
+	if len(allowed) == 0 && len(denied) == 0 {
+		return
+	}
+	for _, c := range pod.pod.Spec.Containers {
+		pattern, err := imageAllowed(allowed, denied, c.Image)
+		if err != nil {
+			continue
+		}
+		if pattern == "" {
+			pod.event(corev1.EventTypeNormal, eventReasonImageAllowed, "Image %q of container %q is not denied on this node", c.Image, c.Name)
+		} else {
+			pod.event(corev1.EventTypeNormal, eventReasonImageAllowed, "Image %q of container %q is allowed by %q", c.Image, c.Name, pattern)
+		}
+	}
+}
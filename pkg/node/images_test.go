@@ -0,0 +1,97 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	for image, expected := range map[string]imageReference{
+		"nginx":                                  {registry: "docker.io", repository: "library/nginx"},
+		"nginx:1.25":                             {registry: "docker.io", repository: "library/nginx", tag: "1.25"},
+		"index.docker.io/brave/web":              {registry: "docker.io", repository: "brave/web"},
+		"localhost/web":                          {registry: "localhost", repository: "web"},
+		"registry.example.com:5000/team/web:1.0": {registry: "registry.example.com:5000", repository: "team/web", tag: "1.0"},
+		"registry.example.com/web:1.0@" + digest: {registry: "registry.example.com", repository: "web", tag: "1.0", digest: digest},
+	} {
+		ref, err := parseImageReference(image)
+		assert.Nil(t, err, image)
+		assert.Equal(t, expected, ref, image)
+	}
+	for _, image := range []string{"", "Nginx", "web:", "web@sha256:abcd", "registry.example.com/"} {
+		_, err := parseImageReference(image)
+		assert.NotNil(t, err, image)
+	}
+}
+
+func TestImageAllowed(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	allowed := []string{"registry.example.com", "docker.io/brave/*", "nginx:1.25", digest}
+	denied := []string{"registry.example.com/legacy/*"}
+	for image, pattern := range map[string]string{
+		"registry.example.com/team/web:1.0": "registry.example.com",
+		"brave/web":                         "docker.io/brave/*",
+		"docker.io/library/nginx:1.25":      "nginx:1.25",
+		"quay.io/team/web@" + digest:        digest,
+	} {
+		allowedBy, err := imageAllowed(allowed, denied, image)
+		assert.Nil(t, err, image)
+		assert.Equal(t, pattern, allowedBy, image)
+	}
+	for _, image := range []string{"nginx", "nginx:latest", "quay.io/team/web", "braveweb", "registry.example.com/legacy/web"} {
+		_, err := imageAllowed(allowed, denied, image)
+		assert.NotNil(t, err, image)
+	}
+
+	// Every image but the denied ones is allowed without allowed images.
+	pattern, err := imageAllowed(nil, denied, "quay.io/team/web")
+	assert.Nil(t, err)
+	assert.Equal(t, "", pattern)
+
+	for _, pattern := range append(allowed, denied...) {
+		assert.Nil(t, ValidateImagePattern(pattern), pattern)
+	}
+	for _, pattern := range []string{"", "/*", "registry.example.com/*/web", "sha256:abcd", "Web"} {
+		assert.NotNil(t, ValidateImagePattern(pattern), pattern)
+	}
+}
+
+func TestImages(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), recorder: recorder,
+		allowedImages: []string{"registry.example.com/*"}, deniedImages: []string{"registry.example.com/legacy/*"}}
+	newPod := func(image string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: image}}},
+		}
+	}
+
+	assert.Nil(t, validatePod(n, newPod("registry.example.com/team/web")))
+	for _, image := range []string{"web", "registry.example.com/legacy/web"} {
+		var rejection *AdmissionError
+		if assert.True(t, errors.As(validatePod(n, newPod(image)), &rejection), image) {
+			assert.Equal(t, AdmissionReasonImage, rejection.Reason)
+			assert.True(t, rejection.Terminal)
+		}
+	}
+
+	// Admitted pods tell which pattern allowed their image.
+	pod := &Pod{node: n, pod: newPod("registry.example.com/team/web")}
+	pod.recordImagesAllowed()
+	assert.Equal(t, `Normal ImageAllowed Image "registry.example.com/team/web" of container "web" is allowed by "registry.example.com/*"`, <-recorder.Events)
+
+	// Rejected pods are told why with an event.
+	var rejection *AdmissionError
+	errors.As(validatePod(n, newPod("web")), &rejection)
+	n.RejectPod(context.Background(), newPod("web"), rejection)
+	assert.Equal(t, `Warning ImageNotAllowed container "web" may not run in an enclave on this node: image "web" is not allowed`, <-recorder.Events)
+}
@@ -0,0 +1,79 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// KMSRegionAnnotation enables the KMS proxy of a pod, forwarding to the KMS endpoint of the region.
+	KMSRegionAnnotation = annotationPrefix + "kms-region"
+	// KMSEndpointAnnotation overrides the host[:port] of the KMS endpoint the
+	// proxy forwards to, such as a VPC endpoint. The requests of the node
+	// itself go to the endpoint of its configuration.
+	KMSEndpointAnnotation = annotationPrefix + "kms-endpoint"
+	// KMSPortAnnotation is the host vsock port on which the enclave reaches the KMS proxy.
+	KMSPortAnnotation = annotationPrefix + "kms-vsock-port"
+
+	// defaultKMSPort is the proxy port kmstool-enclave-cli uses by default.
+	defaultKMSPort = 8000
+)
+
+// kmsProxy is where the KMS proxy of a pod listens and forwards to.
+type kmsProxy struct {
+	port     uint32
+	endpoint string
+}
+
+// kmsProxyConfig returns the KMS proxy configured by the annotations of a
+// pod, nil when it has none. The proxy forwards TLS as is, so in-enclave
+// clients such as kmstool-enclave-cli talk to KMS directly, attesting their
+// enclave in their Decrypt requests.
+func kmsProxyConfig(pod *corev1.Pod) (*kmsProxy, error) {
+	region := pod.Annotations[KMSRegionAnnotation]
+	endpoint := pod.Annotations[KMSEndpointAnnotation]
+	if region == "" && endpoint == "" {
+		return nil, nil
+	}
+
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("kms.%s.amazonaws.com", region)
+	}
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		endpoint = net.JoinHostPort(endpoint, "443")
+	}
+	if host, _, err := net.SplitHostPort(endpoint); err != nil || host == "" {
+		return nil, fmt.Errorf("invalid %s %q", KMSEndpointAnnotation, endpoint)
+	}
+
+	proxy := &kmsProxy{port: defaultKMSPort, endpoint: endpoint}
+	if value, ok := pod.Annotations[KMSPortAnnotation]; ok {
+		port, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid %s %q", KMSPortAnnotation, value)
+		}
+		// The ports of the agent protocol are reserved.
+		if port >= agent.LogPortBase && port <= agent.VaultPortBase+lastEnclaveCID {
+			return nil, fmt.Errorf("%s %d is reserved", KMSPortAnnotation, port)
+		}
+		proxy.port = uint32(port)
+	}
+	return proxy, nil
+}
+
+// kmsProxy returns the KMS proxy of the pod, if any.
+func (pod *Pod) kmsProxy() *kmsProxy {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotations were validated when the pod was created.
+	proxy, _ := kmsProxyConfig(spec)
+	return proxy
+}
@@ -0,0 +1,35 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKMSProxyConfig(t *testing.T) {
+	config := func(annotations map[string]string) (*kmsProxy, error) {
+		return kmsProxyConfig(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}})
+	}
+
+	proxy, err := config(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, proxy)
+
+	proxy, err = config(map[string]string{KMSRegionAnnotation: "us-east-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, &kmsProxy{port: 8000, endpoint: "kms.us-east-1.amazonaws.com:443"}, proxy)
+
+	proxy, err = config(map[string]string{
+		KMSEndpointAnnotation: "vpce-0123.kms.us-east-1.vpce.amazonaws.com",
+		KMSPortAnnotation:     "8001",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &kmsProxy{port: 8001, endpoint: "vpce-0123.kms.us-east-1.vpce.amazonaws.com:443"}, proxy)
+
+	for _, port := range []string{"0", "port", "10016"} {
+		_, err := config(map[string]string{KMSRegionAnnotation: "us-east-1", KMSPortAnnotation: port})
+		assert.NotNil(t, err, port)
+	}
+}
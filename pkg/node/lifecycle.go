@@ -0,0 +1,115 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// hookBootTimeout bounds how long the postStart hook waits for the agent
+	// to start the workload, which waits for projected volumes first.
+	hookBootTimeout = projectedVolumeBootTimeout + 30*time.Second
+	// postStartHookTimeout bounds the postStart hook, which the kubelet does not.
+	postStartHookTimeout = 2 * time.Minute
+	// defaultTerminationGracePeriod bounds the preStop hook of pods setting no grace period, matching the API server's default.
+	defaultTerminationGracePeriod = 30 * time.Second
+	// minimumGracePeriod is the least time given to the preStop hook, matching the kubelet's.
+	minimumGracePeriod = 2 * time.Second
+)
+
+// runHook runs a lifecycle hook handler against the enclave. Commands are run
+// by the agent, and HTTP requests reach the enclave over vsock, like probes.
+func (p *prober) runHook(ctx context.Context, handler *corev1.LifecycleHandler, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case handler.Exec != nil:
+		return p.exec(handler.Exec, timeout)
+	case handler.HTTPGet != nil:
+		return p.httpGet(ctx, handler.HTTPGet)
+	}
+	return fmt.Errorf("unsupported lifecycle hook handler")
+}
+
+// lifecycle returns the lifecycle hooks of the pod's container, if any.
+func (pod *Pod) lifecycle() *corev1.Lifecycle {
+	pod.mu.RLock()
+	defer pod.mu.RUnlock()
+	if pod.pod == nil || len(pod.pod.Spec.Containers) == 0 {
+		return nil
+	}
+	return pod.pod.Spec.Containers[0].Lifecycle
+}
+
+// postStart runs the postStart hook of the container once the agent of the
+// launched enclave started the workload. The container is not reported
+// running before the hook completes, and is killed when it fails.
+func (pod *Pod) postStart(ctx context.Context) error {
+	lifecycle := pod.lifecycle()
+	if lifecycle == nil || lifecycle.PostStart == nil {
+		return nil
+	}
+
+	pod.mu.RLock()
+	booted := pod.booted
+	info := pod.info
+	container := &pod.pod.Spec.Containers[0]
+	pod.mu.RUnlock()
+	if booted != nil {
+		select {
+		case <-booted:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(hookBootTimeout):
+			return fmt.Errorf("the agent did not start the workload within %s", hookBootTimeout)
+		}
+	}
+
+	log.G(ctx).Infof("running postStart hook of %s/%s", pod.namespace, pod.name)
+	return newProber(info, container).runHook(ctx, lifecycle.PostStart, postStartHookTimeout)
+}
+
+// preStop runs the preStop hook of the container of the running enclave,
+// within the termination grace period of the pod, before it is terminated.
+func (pod *Pod) preStop(ctx context.Context) {
+	lifecycle := pod.lifecycle()
+	if lifecycle == nil || lifecycle.PreStop == nil {
+		return
+	}
+
+	pod.mu.RLock()
+	running := pod.state == containerRunning
+	info := pod.info
+	container := &pod.pod.Spec.Containers[0]
+	gracePeriod := terminationGracePeriod(pod.pod)
+	pod.mu.RUnlock()
+	if !running || info.EnclaveID == "" {
+		return
+	}
+	if gracePeriod < minimumGracePeriod {
+		gracePeriod = minimumGracePeriod
+	}
+
+	log.G(ctx).Infof("running preStop hook of %s/%s", pod.namespace, pod.name)
+	if err := newProber(info, container).runHook(ctx, lifecycle.PreStop, gracePeriod); err != nil {
+		log.G(ctx).Warnf("preStop hook of %s/%s failed: %v", pod.namespace, pod.name, err)
+		pod.warning(eventReasonFailedPreStopHook, "PreStopHook failed: %v", err)
+	}
+}
+
+// terminationGracePeriod returns how long a pod is given to stop, the grace
+// period of its deletion when it is being deleted.
+func terminationGracePeriod(pod *corev1.Pod) time.Duration {
+	switch {
+	case pod.DeletionGracePeriodSeconds != nil:
+		return time.Duration(*pod.DeletionGracePeriodSeconds) * time.Second
+	case pod.Spec.TerminationGracePeriodSeconds != nil:
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	return defaultTerminationGracePeriod
+}
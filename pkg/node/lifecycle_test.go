@@ -0,0 +1,54 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestRunHook(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+	}))
+	defer server.Close()
+	_, serverPort, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(serverPort)
+
+	p := &prober{
+		container: &corev1.Container{},
+		dial: func(port uint32) (net.Conn, error) {
+			return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		},
+	}
+	handler := &corev1.LifecycleHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/deregister", Port: intstr.FromInt(port)}}
+	assert.Nil(t, p.runHook(context.Background(), handler, time.Second))
+	assert.Equal(t, []string{"/deregister"}, requests)
+
+	// TCP socket handlers are deprecated, the kubelet does not run them either.
+	handler = &corev1.LifecycleHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(port)}}
+	assert.EqualError(t, p.runHook(context.Background(), handler, time.Second), "unsupported lifecycle hook handler")
+}
+
+func TestTerminationGracePeriod(t *testing.T) {
+	pod := &corev1.Pod{}
+	assert.Equal(t, defaultTerminationGracePeriod, terminationGracePeriod(pod))
+
+	seconds := int64(60)
+	pod.Spec.TerminationGracePeriodSeconds = &seconds
+	assert.Equal(t, time.Minute, terminationGracePeriod(pod))
+
+	// Deletions may shorten the grace period.
+	deletion := int64(5)
+	pod.DeletionGracePeriodSeconds = &deletion
+	assert.Equal(t, 5*time.Second, terminationGracePeriod(pod))
+}
@@ -0,0 +1,243 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+)
+
+// logDir returns the directory holding the log files of the pod, one per
+// enclave instance, named after the pod's restart count when it was launched.
+func (pod *Pod) logDir() string {
+	if pod.node == nil || pod.node.logDir == "" {
+		return ""
+	}
+	return filepath.Join(pod.node.logDir, fmt.Sprintf("%s_%s_%s", pod.namespace, pod.name, pod.uid))
+}
+
+// logPath returns the path of the log file of the given enclave instance.
+func (pod *Pod) logPath(instance int32) string {
+	return filepath.Join(pod.logDir(), strconv.Itoa(int(instance))+".log")
+}
+
+// openLog creates the log file of a new enclave instance. Only the logs of the
+// previous instance are retained.
+func (pod *Pod) openLog(instance int32) (*os.File, error) {
+	if pod.logDir() == "" {
+		return nil, fmt.Errorf("no log directory configured")
+	}
+	if err := os.MkdirAll(pod.logDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(pod.logDir())
+	if err != nil {
+		return nil, err
+	}
+	keep := map[string]bool{
+		filepath.Base(pod.logPath(instance)):     true,
+		filepath.Base(pod.logPath(instance - 1)): true,
+	}
+	for _, entry := range entries {
+		if !keep[entry.Name()] {
+			os.Remove(filepath.Join(pod.logDir(), entry.Name()))
+		}
+	}
+
+	return os.OpenFile(pod.logPath(instance), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// removeLogs deletes the log files of the pod.
+func (pod *Pod) removeLogs() error {
+	if pod.logDir() == "" {
+		return nil
+	}
+	return os.RemoveAll(pod.logDir())
+}
+
+// previousLogs returns the logs of the enclave instance which ran before the current one.
+func (pod *Pod) previousLogs(containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	pod.mu.RLock()
+	restarts := pod.restarts
+	pod.mu.RUnlock()
+
+	notFound := errdefs.NotFoundf("previous terminated container %q in pod %q not found", containerName, pod.name)
+	if restarts == 0 || pod.logDir() == "" {
+		return nil, notFound
+	}
+	r, err := readLogFile(pod.logPath(restarts-1), opts)
+	if os.IsNotExist(err) {
+		return nil, notFound
+	}
+	return r, err
+}
+
+// currentLogs returns the logs of the current enclave instance, as written by its log server.
+func (pod *Pod) currentLogs(containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	pod.mu.RLock()
+	restarts := pod.restarts
+	pod.mu.RUnlock()
+
+	notFound := errdefs.NotFoundf("logs of container %q in pod %q not found", containerName, pod.name)
+	if pod.logDir() == "" {
+		return nil, notFound
+	}
+	r, err := readLogFile(pod.logPath(restarts), opts)
+	if os.IsNotExist(err) {
+		return nil, notFound
+	}
+	return r, err
+}
+
+// podLogWriter writes the output of the workload to its log file in the CRI
+// log format, "<time> <stream> <F|P> <content>", so the streams can be told
+// apart. Lines split across writes are tagged P(artial) until their last part,
+// tagged F(ull). The output is also written to the log sink of each stream.
+type podLogWriter struct {
+	mu    sync.Mutex
+	file  io.Writer
+	sinks map[agent.LogStream]io.WriteCloser
+}
+
+func (w *podLogWriter) WriteLog(stream agent.LogStream, p []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if sink := w.sinks[stream]; sink != nil {
+		_, _ = sink.Write(p)
+	}
+	if w.file == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for len(p) > 0 {
+		tag, line := "P", p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			tag, line = "F", p[:i]
+			p = p[i+1:]
+		} else {
+			p = nil
+		}
+		fmt.Fprintf(&buf, "%s %s %s %s\n", now, stream, tag, line)
+	}
+	_, err := w.file.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the log sinks of the streams.
+func (w *podLogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, sink := range w.sinks {
+		sink.Close()
+	}
+	w.sinks = nil
+	return nil
+}
+
+// decodeLogs returns the contents of a log file written by podLogWriter,
+// honoring the timestamps and since options. Lines which are not in the CRI
+// log format, written before the streams were told apart, are kept as is.
+func decodeLogs(data []byte, opts api.ContainerLogOpts) []byte {
+	since := opts.SinceTime
+	if opts.SinceSeconds > 0 {
+		since = time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	}
+
+	var out bytes.Buffer
+	partial := false
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		t, tag, content, ok := parseLogLine(bytes.TrimSuffix(line, []byte("\n")))
+		if !ok {
+			out.Write(line)
+			partial = false
+			continue
+		}
+		if !since.IsZero() && t.Before(since) {
+			continue
+		}
+		if opts.Timestamps && !partial {
+			out.WriteString(t.Format(time.RFC3339Nano) + " ")
+		}
+		out.Write(content)
+		partial = tag == "P"
+		if !partial {
+			out.WriteByte('\n')
+		}
+	}
+	return out.Bytes()
+}
+
+// parseLogLine parses a line in the CRI log format.
+func parseLogLine(line []byte) (time.Time, string, []byte, bool) {
+	fields := bytes.SplitN(line, []byte(" "), 4)
+	if len(fields) < 3 {
+		return time.Time{}, "", nil, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return time.Time{}, "", nil, false
+	}
+	if stream := string(fields[1]); stream != agent.LogStdout.String() && stream != agent.LogStderr.String() {
+		return time.Time{}, "", nil, false
+	}
+	tag := string(fields[2])
+	if tag != "F" && tag != "P" {
+		return time.Time{}, "", nil, false
+	}
+	var content []byte
+	if len(fields) == 4 {
+		content = fields[3]
+	}
+	return t, tag, content, true
+}
+
+// readLogFile reads a log file, honoring the tail and byte limit options.
+func readLogFile(path string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data = decodeLogs(data, opts)
+	if opts.Tail > 0 {
+		data = tailLines(data, opts.Tail)
+	}
+	if opts.LimitBytes > 0 && len(data) > opts.LimitBytes {
+		data = data[:opts.LimitBytes]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// tailLines returns the last n lines of data.
+func tailLines(data []byte, n int) []byte {
+	end := len(data)
+	// A trailing newline ends the last line rather than starting a new one.
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
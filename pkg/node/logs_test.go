@@ -0,0 +1,85 @@
+package node
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+)
+
+func TestPreviousLogs(t *testing.T) {
+	pod := &Pod{
+		namespace: "default",
+		name:      "nginx",
+		uid:       "1234",
+		node:      &Node{logDir: t.TempDir()},
+	}
+
+	_, err := pod.previousLogs("nginx", api.ContainerLogOpts{Previous: true})
+	assert.True(t, errdefs.IsNotFound(err))
+
+	for instance := int32(0); instance < 3; instance++ {
+		f, err := pod.openLog(instance)
+		assert.Nil(t, err)
+		f.WriteString("first\nsecond\nthird " + string(rune('0'+instance)) + "\n")
+		f.Close()
+	}
+	pod.restarts = 2
+
+	// Only the current and previous instances are retained.
+	_, err = os.Stat(pod.logPath(0))
+	assert.True(t, os.IsNotExist(err))
+
+	r, err := pod.previousLogs("nginx", api.ContainerLogOpts{Previous: true, Tail: 2})
+	assert.Nil(t, err)
+	data, _ := io.ReadAll(r)
+	assert.Equal(t, "second\nthird 1\n", string(data))
+
+	r, err = pod.previousLogs("nginx", api.ContainerLogOpts{Previous: true, LimitBytes: 5})
+	assert.Nil(t, err)
+	data, _ = io.ReadAll(r)
+	assert.Equal(t, "first", string(data))
+
+	assert.Nil(t, pod.removeLogs())
+	_, err = os.Stat(pod.logDir())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPodLogWriter(t *testing.T) {
+	var file bytes.Buffer
+	var stderr bytes.Buffer
+	w := &podLogWriter{
+		file:  &file,
+		sinks: map[agent.LogStream]io.WriteCloser{agent.LogStderr: nopWriteCloser{&stderr}},
+	}
+	assert.Nil(t, w.WriteLog(agent.LogStdout, []byte("hello\nwor")))
+	assert.Nil(t, w.WriteLog(agent.LogStderr, []byte("oops\n")))
+	assert.Nil(t, w.WriteLog(agent.LogStdout, []byte("ld\n")))
+	assert.Equal(t, "oops\n", stderr.String())
+
+	lines := strings.Split(strings.TrimSuffix(file.String(), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasSuffix(lines[0], " stdout F hello"))
+	assert.True(t, strings.HasSuffix(lines[1], " stdout P wor"))
+	assert.True(t, strings.HasSuffix(lines[2], " stderr F oops"))
+
+	// Partial lines are joined back, and lines of legacy log files kept.
+	data := decodeLogs(append([]byte("legacy line\n"), file.Bytes()...), api.ContainerLogOpts{})
+	assert.Equal(t, "legacy line\nhello\nworoops\nld\n", string(data))
+
+	data = decodeLogs(file.Bytes(), api.ContainerLogOpts{SinceTime: time.Now().Add(time.Hour)})
+	assert.Empty(t, data)
+	data = decodeLogs([]byte("2023-01-02T03:04:05Z stdout F hello\n"), api.ContainerLogOpts{Timestamps: true})
+	assert.Equal(t, "2023-01-02T03:04:05Z hello\n", string(data))
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
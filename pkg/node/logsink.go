@@ -0,0 +1,144 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+)
+
+// remoteLogSinkDialTimeout bounds how long the remote log sink waits to connect.
+const remoteLogSinkDialTimeout = 5 * time.Second
+
+// LogSink ships the logs of enclaves somewhere, on top of the log files
+// read by kubectl logs.
+type LogSink interface {
+	// Writer returns the writer receiving a stream of the logs of an enclave instance of a pod.
+	Writer(namespace, name string, instance int32, stream agent.LogStream) (io.WriteCloser, error)
+}
+
+// NewLogSink creates the log sink described by spec:
+//   - "" or "file" keeps the logs in their log files only,
+//   - "stdout" also writes them to the provider's stdout, prefixed by their pod,
+//   - "tcp://host:port" also sends them as JSON lines to a remote collector.
+func NewLogSink(spec string) (LogSink, error) {
+	switch spec {
+	case "", "file":
+		return nil, nil
+	case "stdout":
+		return &writerLogSink{w: os.Stdout}, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Scheme != "tcp" || u.Host == "" {
+		return nil, fmt.Errorf("invalid log sink %q", spec)
+	}
+	return &remoteLogSink{addr: u.Host}, nil
+}
+
+// writerLogSink writes the log lines of all enclaves to a writer, prefixed by their pod.
+type writerLogSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *writerLogSink) Writer(namespace, name string, instance int32, stream agent.LogStream) (io.WriteCloser, error) {
+	prefix := []byte(fmt.Sprintf("%s/%s: ", namespace, name))
+	return newLineWriter(func(line []byte) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		_, _ = s.w.Write(append(append(append([]byte{}, prefix...), line...), '\n'))
+	}), nil
+}
+
+// remoteLogSink sends the log lines of enclaves to a TCP collector, one JSON
+// object per line. Lines logged while the collector is unreachable are dropped.
+type remoteLogSink struct {
+	addr string
+}
+
+// remoteLogLine is a log line sent to the remote collector.
+type remoteLogLine struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Instance  int32     `json:"instance"`
+	Stream    string    `json:"stream"`
+	Log       string    `json:"log"`
+}
+
+func (s *remoteLogSink) Writer(namespace, name string, instance int32, stream agent.LogStream) (io.WriteCloser, error) {
+	var conn net.Conn
+	w := newLineWriter(func(line []byte) {
+		data, err := json.Marshal(remoteLogLine{time.Now().UTC(), namespace, name, instance, stream.String(), string(line)})
+		if err != nil {
+			return
+		}
+		if conn == nil {
+			if conn, err = net.DialTimeout("tcp", s.addr, remoteLogSinkDialTimeout); err != nil {
+				conn = nil
+				return
+			}
+		}
+		if _, err := conn.Write(append(data, '\n')); err != nil {
+			// Reconnect with the next line.
+			conn.Close()
+			conn = nil
+		}
+	})
+	w.close = func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+	return w, nil
+}
+
+// lineWriter splits what is written to it in lines, handing complete lines
+// to emit. The last line is emitted on Close even when incomplete.
+type lineWriter struct {
+	mu    sync.Mutex
+	buf   []byte
+	emit  func(line []byte)
+	close func()
+}
+
+func newLineWriter(emit func(line []byte)) *lineWriter {
+	return &lineWriter{emit: emit}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	if w.close != nil {
+		w.close()
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package node
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogSink(t *testing.T) {
+	sink, err := NewLogSink("")
+	assert.Nil(t, err)
+	assert.Nil(t, sink)
+
+	sink, err = NewLogSink("stdout")
+	assert.Nil(t, err)
+	assert.NotNil(t, sink)
+
+	_, err = NewLogSink("udp://collector:514")
+	assert.EqualError(t, err, `invalid log sink "udp://collector:514"`)
+}
+
+func TestWriterLogSink(t *testing.T) {
+	var out bytes.Buffer
+	sink := &writerLogSink{w: &out}
+
+	w, err := sink.Writer("default", "nginx", 0, agent.LogStdout)
+	assert.Nil(t, err)
+	w.Write([]byte("hello\nwor"))  //nolint:errcheck
+	w.Write([]byte("ld\npartial")) //nolint:errcheck
+	assert.Equal(t, "default/nginx: hello\ndefault/nginx: world\n", out.String())
+
+	assert.Nil(t, w.Close())
+	assert.Equal(t, "default/nginx: hello\ndefault/nginx: world\ndefault/nginx: partial\n", out.String())
+}
+
+func TestRemoteLogSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	sink, err := NewLogSink("tcp://" + ln.Addr().String())
+	assert.Nil(t, err)
+	w, err := sink.Writer("default", "nginx", 2, agent.LogStderr)
+	assert.Nil(t, err)
+	w.Write([]byte("hello\n")) //nolint:errcheck
+
+	conn, err := ln.Accept()
+	assert.Nil(t, err)
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	assert.Nil(t, err)
+
+	var got remoteLogLine
+	assert.Nil(t, json.Unmarshal(line, &got))
+	assert.Equal(t, "default", got.Namespace)
+	assert.Equal(t, "nginx", got.Pod)
+	assert.Equal(t, int32(2), got.Instance)
+	assert.Equal(t, "stderr", got.Stream)
+	assert.Equal(t, "hello", got.Log)
+	assert.Nil(t, w.Close())
+}
@@ -0,0 +1,213 @@
+package node
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/policy"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// podReasonPolicyViolation fails the pods whose enclave image does not
+	// comply with the enclave policies of their namespace.
+	podReasonPolicyViolation   = "EnclavePolicyViolation"
+	eventReasonPolicyViolation = "EnclavePolicyViolation"
+	// podReasonUntrustedImage fails the pods whose enclave image is not
+	// signed as the signing policy of the node requires.
+	podReasonUntrustedImage   = "UntrustedImage"
+	eventReasonUntrustedImage = "UntrustedImage"
+	// podReasonMeasurementMismatch fails the pods whose enclave image does
+	// not have the PCRs their annotations expect.
+	podReasonMeasurementMismatch   = "MeasurementMismatch"
+	eventReasonMeasurementMismatch = "MeasurementMismatch"
+	eventReasonMeasured            = "MeasurementsVerified"
+
+	// expectedPCRAnnotationPrefix prefixes the annotations of the PCRs a pod
+	// expects of its enclave image, followed by their index.
+	expectedPCRAnnotationPrefix = annotationPrefix + "expected-pcr"
+)
+
+// ExpectedPCRAnnotation returns the pod annotation holding the value, in hex,
+// a PCR of the enclave image must have: 0 for the image, 1 for its kernel, 2
+// for the application and 8 for the signing certificate.
+func ExpectedPCRAnnotation(index uint) string {
+	return expectedPCRAnnotationPrefix + strconv.FormatUint(uint64(index), 10)
+}
+
+// expectedMeasurements returns the measurements a pod expects of its
+// enclave image, and whether it expects any.
+func expectedMeasurements(pod *corev1.Pod) (policy.Measurements, bool, error) {
+	var expected policy.Measurements
+	pcrs := map[string]*string{
+		ExpectedPCRAnnotation(0): &expected.PCR0,
+		ExpectedPCRAnnotation(1): &expected.PCR1,
+		ExpectedPCRAnnotation(2): &expected.PCR2,
+		ExpectedPCRAnnotation(8): &expected.PCR8,
+	}
+	found := false
+	for annotation, value := range pod.Annotations {
+		if !strings.HasPrefix(annotation, expectedPCRAnnotationPrefix) {
+			continue
+		}
+		pcr, ok := pcrs[annotation]
+		if !ok {
+			return expected, false, fmt.Errorf("invalid %s annotation, only PCR0, 1, 2 and 8 of enclave images are measured", annotation)
+		}
+		value = strings.ToLower(strings.TrimSpace(value))
+		if b, err := hex.DecodeString(value); err != nil || len(b) != sha512.Size384 {
+			return expected, false, fmt.Errorf("invalid %s annotation %q, expected a SHA-384 digest in hex", annotation, value)
+		}
+		*pcr = value
+		found = true
+	}
+	return expected, found, nil
+}
+
+// listPolicies lists the enclave policies of a namespace, swapped by tests.
+var listPolicies = func(ctx context.Context, client kubernetes.Interface, namespace string) ([]policy.EnclavePolicy, error) {
+	restClient := client.Discovery().RESTClient()
+	if restClient == nil {
+		// Fake clients have none, nor any policy.
+		return nil, nil
+	}
+	return policy.List(ctx, restClient, namespace)
+}
+
+// describeEif measures an enclave image, swapped by tests.
+var describeEif = cli.DescribeEif
+
+// eifMeasurements returns the measurements of an enclave image.
+func eifMeasurements(info *cli.EifInfo) policy.Measurements {
+	return policy.Measurements{
+		PCR0: info.Measurements.Pcr0,
+		PCR1: info.Measurements.Pcr1,
+		PCR2: info.Measurements.Pcr2,
+		PCR8: info.Measurements.Pcr8,
+	}
+}
+
+// checkImage checks the built enclave image of the pod before it is
+// launched, and records its build in the audit log. The pod fails rather
+// than launch an image the policies do not allow, or when they cannot be
+// checked. The image is described once, only when a policy applies or the
+// build is audited, as describing hashes the whole image.
+func (pod *Pod) checkImage(ctx context.Context) error {
+	var info *cli.EifInfo
+	describe := func() (*cli.EifInfo, error) {
+		if info != nil {
+			return info, nil
+		}
+		var err error
+		info, err = describeEif(pod.config.EifPath)
+		return info, err
+	}
+	err := pod.checkSignature(ctx, describe)
+	if err == nil {
+		err = pod.checkExpectedMeasurements(ctx, describe)
+	}
+	if err == nil {
+		err = pod.checkMeasurements(ctx, describe)
+	}
+	pod.auditBuild(ctx, describe, err)
+	return err
+}
+
+// checkExpectedMeasurements checks the built enclave image of the pod has
+// the PCRs its annotations expect, recording both for audit.
+func (pod *Pod) checkExpectedMeasurements(ctx context.Context, describe func() (*cli.EifInfo, error)) error {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotations were validated when the pod was created.
+	expected, ok, _ := expectedMeasurements(spec)
+	if !ok {
+		return nil
+	}
+
+	info, err := describe()
+	if err != nil {
+		return pod.refuseLaunch(ctx, podReasonMeasurementMismatch, eventReasonMeasurementMismatch, fmt.Errorf("failed to measure enclave image: %v", err))
+	}
+	actual := eifMeasurements(info)
+	if !expected.Matches(actual) {
+		return pod.refuseLaunch(ctx, podReasonMeasurementMismatch, eventReasonMeasurementMismatch,
+			fmt.Errorf("enclave image measurements differ from the expected ones: expected %s, actual %s", expected, actual))
+	}
+	pod.event(corev1.EventTypeNormal, eventReasonMeasured, "Enclave image has the expected measurements %s", expected)
+	return nil
+}
+
+// checkMeasurements checks the built enclave image of the pod against the
+// enclave policies of its namespace which select it.
+func (pod *Pod) checkMeasurements(ctx context.Context, describe func() (*cli.EifInfo, error)) error {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if pod.node == nil || pod.node.client == nil || spec == nil {
+		return nil
+	}
+	policies, err := listPolicies(ctx, pod.node.client, pod.namespace)
+	if errors.Is(err, policy.ErrNotInstalled) && !pod.node.requireEnclavePolicies {
+		log.G(ctx).Warnf("Not checking the enclave image against enclave policies: %v", err)
+		return nil
+	}
+	if err != nil {
+		return pod.policyViolation(ctx, "failed to list enclave policies: %v", err)
+	}
+	var applicable []*policy.EnclavePolicy
+	for i := range policies {
+		applies, err := policies[i].Applies(spec.Labels)
+		if err != nil {
+			return pod.policyViolation(ctx, "%v", err)
+		}
+		if !applies {
+			continue
+		}
+		// Invalid policies fail the pods they select rather than be skipped.
+		if err := policies[i].Validate(); err != nil {
+			return pod.policyViolation(ctx, "%v", err)
+		}
+		applicable = append(applicable, &policies[i])
+	}
+	if len(applicable) == 0 {
+		return nil
+	}
+
+	info, err := describe()
+	if err != nil {
+		return pod.policyViolation(ctx, "failed to measure enclave image: %v", err)
+	}
+	measurements := eifMeasurements(info)
+	for _, p := range applicable {
+		if !p.Allows(measurements) {
+			return pod.policyViolation(ctx, "enclave image %s is not allowed by enclave policy %s", measurements, p.Name)
+		}
+	}
+	log.G(ctx).Debugf("enclave image %s complies with %d enclave policies", measurements, len(applicable))
+	return nil
+}
+
+// policyViolation fails the pod as its enclave image is not allowed.
+func (pod *Pod) policyViolation(ctx context.Context, format string, args ...interface{}) error {
+	return pod.refuseLaunch(ctx, podReasonPolicyViolation, eventReasonPolicyViolation, fmt.Errorf(format, args...))
+}
+
+// refuseLaunch fails the pod for the reason its enclave image cannot be launched.
+func (pod *Pod) refuseLaunch(ctx context.Context, podReason, eventReason string, err error) error {
+	log.G(ctx).Errorf("refusing to launch enclave: %v", err)
+	pod.warning(eventReason, "Refusing to launch enclave: %v", err)
+	pod.setPhase(ctx, corev1.PodFailed, podReason, err.Error())
+	return err
+}
@@ -0,0 +1,113 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/policy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCheckMeasurements(t *testing.T) {
+	var policies []policy.EnclavePolicy
+	var listErr error
+	defer func(f func(context.Context, kubernetes.Interface, string) ([]policy.EnclavePolicy, error)) {
+		listPolicies = f
+	}(listPolicies)
+	listPolicies = func(context.Context, kubernetes.Interface, string) ([]policy.EnclavePolicy, error) {
+		return policies, listErr
+	}
+	defer func(f func(string) (*cli.EifInfo, error)) { describeEif = f }(describeEif)
+	describeEif = func(string) (*cli.EifInfo, error) {
+		info := new(cli.EifInfo)
+		info.Measurements.Pcr0 = "aa"
+		info.Measurements.Pcr8 = "cc"
+		return info, nil
+	}
+
+	newPod := func() *Pod {
+		return &Pod{namespace: "default", name: "web", uid: "1", node: &Node{client: fake.NewSimpleClientset()},
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1", Labels: map[string]string{"app": "web"}}}}
+	}
+
+	// Pods selected by no policy run any image.
+	pod := newPod()
+	assert.Nil(t, pod.checkImage(context.Background()))
+	policies = []policy.EnclavePolicy{{
+		ObjectMeta: metav1.ObjectMeta{Name: "db"},
+		Spec: policy.EnclavePolicySpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+	}}
+	assert.Nil(t, pod.checkImage(context.Background()))
+
+	// Every policy selecting the pod must allow its image.
+	policies = append(policies,
+		policy.EnclavePolicy{ObjectMeta: metav1.ObjectMeta{Name: "signed"}, Spec: policy.EnclavePolicySpec{Allowed: []policy.Measurements{{PCR8: "cc"}}}},
+		policy.EnclavePolicy{ObjectMeta: metav1.ObjectMeta{Name: "pinned"}, Spec: policy.EnclavePolicySpec{Allowed: []policy.Measurements{{PCR0: "aa"}}}},
+	)
+	assert.Nil(t, pod.checkImage(context.Background()))
+
+	policies[2].Spec.Allowed[0].PCR0 = "bb"
+	assert.EqualError(t, pod.checkImage(context.Background()), "enclave image PCR0=aa PCR8=cc is not allowed by enclave policy pinned")
+	status := pod.GetStatus()
+	assert.Equal(t, corev1.PodFailed, status.Phase)
+	assert.Equal(t, podReasonPolicyViolation, status.Reason)
+
+	// An entry setting no PCR allows nothing.
+	policies[2].Spec.Allowed[0] = policy.Measurements{}
+	assert.EqualError(t, newPod().checkImage(context.Background()), "invalid enclave policy pinned: allowed[0] sets no PCR")
+
+	// Images are launched with a warning while policies are not installed,
+	// unless the node requires them.
+	policies, listErr = nil, policy.ErrNotInstalled
+	pod = newPod()
+	assert.Nil(t, pod.checkImage(context.Background()))
+	pod.node.requireEnclavePolicies = true
+	assert.EqualError(t, pod.checkImage(context.Background()), "failed to list enclave policies: the EnclavePolicy resource is not installed")
+	assert.Equal(t, podReasonPolicyViolation, pod.GetStatus().Reason)
+}
+
+func TestExpectedMeasurements(t *testing.T) {
+	pcr0 := strings.Repeat("ab", 48)
+	defer func(f func(string) (*cli.EifInfo, error)) { describeEif = f }(describeEif)
+	describeEif = func(string) (*cli.EifInfo, error) {
+		info := new(cli.EifInfo)
+		info.Measurements.Pcr0 = pcr0
+		info.Measurements.Pcr1 = strings.Repeat("01", 48)
+		return info, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+	spec := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1", Annotations: map[string]string{}}}
+	pod := &Pod{namespace: "default", name: "web", uid: "1", node: &Node{recorder: recorder}, pod: spec}
+
+	// Pods expecting no PCR run any image.
+	assert.Nil(t, pod.checkImage(context.Background()))
+
+	spec.Annotations[ExpectedPCRAnnotation(0)] = strings.ToUpper(pcr0)
+	assert.Nil(t, pod.checkImage(context.Background()))
+	assert.Equal(t, "Normal MeasurementsVerified Enclave image has the expected measurements PCR0="+pcr0, <-recorder.Events)
+
+	// Mismatches are recorded with both values.
+	spec.Annotations[ExpectedPCRAnnotation(2)] = strings.Repeat("02", 48)
+	err := pod.checkImage(context.Background())
+	assert.ErrorContains(t, err, "expected PCR0="+pcr0+" PCR2="+strings.Repeat("02", 48)+", actual PCR0="+pcr0+" PCR1=")
+	assert.Contains(t, <-recorder.Events, "Warning MeasurementMismatch Refusing to launch enclave")
+	assert.Equal(t, podReasonMeasurementMismatch, pod.GetStatus().Reason)
+
+	for _, invalid := range []map[string]string{
+		{ExpectedPCRAnnotation(3): pcr0},
+		{ExpectedPCRAnnotation(0): "abcd"},
+		{ExpectedPCRAnnotation(1): strings.Repeat("zz", 48)},
+	} {
+		_, _, err := expectedMeasurements(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: invalid}})
+		assert.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,117 @@
+package node
+
+import (
+	"context"
+
+	dto "github.com/prometheus/client_model/go"
+	stats "github.com/virtual-kubelet/virtual-kubelet/node/api/statsv1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Resource metrics, as exposed by the kubelet on /metrics/resource.
+const (
+	metricNodeCPU         = "node_cpu_usage_seconds_total"
+	metricNodeMemory      = "node_memory_working_set_bytes"
+	metricPodCPU          = "pod_cpu_usage_seconds_total"
+	metricPodMemory       = "pod_memory_working_set_bytes"
+	metricContainerCPU    = "container_cpu_usage_seconds_total"
+	metricContainerMemory = "container_memory_working_set_bytes"
+	metricScrapeError     = "scrape_error"
+)
+
+// GetMetricsResource returns the resource metrics of the node and its pods,
+// derived from the same usage as GetStatsSummary.
+func (n *Node) GetMetricsResource(ctx context.Context) ([]*dto.MetricFamily, error) {
+	summary, err := n.GetStatsSummary(ctx)
+	if err != nil {
+		return []*dto.MetricFamily{
+			gaugeFamily(metricScrapeError, "1 if there was an error while getting container metrics, 0 otherwise",
+				gaugeMetric(1, metav1.Now(), nil)),
+		}, nil
+	}
+
+	nodeCPU := counterFamily(metricNodeCPU, "Cumulative cpu time consumed by the node in core-seconds")
+	nodeMemory := gaugeFamily(metricNodeMemory, "Current working set of the node in bytes")
+	podCPU := counterFamily(metricPodCPU, "Cumulative cpu time consumed by the pod in core-seconds")
+	podMemory := gaugeFamily(metricPodMemory, "Current working set of the pod in bytes")
+	containerCPU := counterFamily(metricContainerCPU, "Cumulative cpu time consumed by the container in core-seconds")
+	containerMemory := gaugeFamily(metricContainerMemory, "Current working set of the container in bytes")
+
+	addCPU(nodeCPU, summary.Node.CPU, nil)
+	addMemory(nodeMemory, summary.Node.Memory, nil)
+	for _, pod := range summary.Pods {
+		labels := map[string]string{"namespace": pod.PodRef.Namespace, "pod": pod.PodRef.Name}
+		addCPU(podCPU, pod.CPU, labels)
+		addMemory(podMemory, pod.Memory, labels)
+
+		for _, container := range pod.Containers {
+			labels := map[string]string{"namespace": pod.PodRef.Namespace, "pod": pod.PodRef.Name, "container": container.Name}
+			addCPU(containerCPU, container.CPU, labels)
+			addMemory(containerMemory, container.Memory, labels)
+		}
+	}
+
+	return []*dto.MetricFamily{
+		nodeCPU,
+		nodeMemory,
+		podCPU,
+		podMemory,
+		containerCPU,
+		containerMemory,
+		gaugeFamily(metricScrapeError, "1 if there was an error while getting container metrics, 0 otherwise",
+			gaugeMetric(0, metav1.Now(), nil)),
+	}, nil
+}
+
+func addCPU(family *dto.MetricFamily, cpu *stats.CPUStats, labels map[string]string) {
+	if cpu == nil || cpu.UsageCoreNanoSeconds == nil {
+		return
+	}
+	seconds := float64(*cpu.UsageCoreNanoSeconds) / 1e9
+	family.Metric = append(family.Metric, &dto.Metric{
+		Label:       labelPairs(labels),
+		Counter:     &dto.Counter{Value: &seconds},
+		TimestampMs: timestampMs(cpu.Time),
+	})
+}
+
+func addMemory(family *dto.MetricFamily, memory *stats.MemoryStats, labels map[string]string) {
+	if memory == nil || memory.WorkingSetBytes == nil {
+		return
+	}
+	family.Metric = append(family.Metric, gaugeMetric(float64(*memory.WorkingSetBytes), memory.Time, labels))
+}
+
+func counterFamily(name, help string) *dto.MetricFamily {
+	t := dto.MetricType_COUNTER
+	return &dto.MetricFamily{Name: &name, Help: &help, Type: &t}
+}
+
+func gaugeFamily(name, help string, metrics ...*dto.Metric) *dto.MetricFamily {
+	t := dto.MetricType_GAUGE
+	return &dto.MetricFamily{Name: &name, Help: &help, Type: &t, Metric: metrics}
+}
+
+func gaugeMetric(value float64, t metav1.Time, labels map[string]string) *dto.Metric {
+	return &dto.Metric{
+		Label:       labelPairs(labels),
+		Gauge:       &dto.Gauge{Value: &value},
+		TimestampMs: timestampMs(t),
+	}
+}
+
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for _, name := range []string{"container", "namespace", "pod"} {
+		if value, ok := labels[name]; ok {
+			name, value := name, value
+			pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+		}
+	}
+	return pairs
+}
+
+func timestampMs(t metav1.Time) *int64 {
+	ms := t.UnixMilli()
+	return &ms
+}
@@ -0,0 +1,26 @@
+package node
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AdmissionReasonNamespace rejects the pods of the namespaces which may not
+// run enclaves on the node.
+const AdmissionReasonNamespace = "NamespaceNotAllowed"
+
+// namespaceError returns why a pod cannot run on a node given the namespaces
+// allowed and denied to run enclaves there, nil if it can. Every namespace is
+// allowed when allowed is empty, and denied ones are denied even if allowed.
+func namespaceError(allowed, denied []string, pod *corev1.Pod) *AdmissionError {
+	if containsString(denied, pod.Namespace) || len(allowed) > 0 && !containsString(allowed, pod.Namespace) {
+		return unsupportedf(AdmissionReasonNamespace, "pods of namespace %q may not run in enclaves on this node", pod.Namespace)
+	}
+	return nil
+}
+
+// namespaces returns the namespaces allowed and denied to run enclaves on the node.
+func (n *Node) namespaces() (allowed, denied []string) {
+	n.RLock()
+	defer n.RUnlock()
+	return n.allowedNamespaces, n.deniedNamespaces
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestNamespaces(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), recorder: recorder,
+		allowedNamespaces: []string{"security", "payments"}, deniedNamespaces: []string{"payments"}}
+	newPod := func(namespace string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "web", UID: "1"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "web"}}},
+		}
+	}
+
+	assert.Nil(t, validatePod(n, newPod("security")))
+	for _, namespace := range []string{"default", "payments"} {
+		var rejection *AdmissionError
+		if assert.True(t, errors.As(validatePod(n, newPod(namespace)), &rejection), namespace) {
+			assert.Equal(t, AdmissionReasonNamespace, rejection.Reason)
+			assert.True(t, rejection.Terminal)
+		}
+	}
+
+	// Every namespace but the denied ones is allowed without allowed namespaces.
+	n.allowedNamespaces = nil
+	assert.Nil(t, validatePod(n, newPod("default")))
+
+	// Rejected pods are told why with an event.
+	var rejection *AdmissionError
+	errors.As(validatePod(n, newPod("payments")), &rejection)
+	n.RejectPod(context.Background(), newPod("payments"), rejection)
+	assert.Equal(t, `Warning NamespaceNotAllowed pods of namespace "payments" may not run in enclaves on this node`, <-recorder.Events)
+
+	// Creating the pod reports its terminal rejection once, when rejecting it.
+	_, err := NewPod(context.Background(), n, newPod("payments"))
+	if assert.True(t, errors.As(err, &rejection)) {
+		n.RejectPod(context.Background(), newPod("payments"), rejection)
+	}
+	assert.Equal(t, `Warning NamespaceNotAllowed pods of namespace "payments" may not run in enclaves on this node`, <-recorder.Events)
+	assert.Len(t, recorder.Events, 0)
+}
@@ -4,24 +4,195 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"time"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
 	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 // NodeConfig contains a node's configurable parameters
 type NodeConfig struct {
 	Name string
+	// AgentPath is the host path of the agent binary installed in every enclave.
+	// Enclaves are built without an agent when it does not exist.
+	AgentPath string
+	// BlobsPath is the directory of the blobs enclave images are built with,
+	// build.DefaultBlobsPath when empty.
+	BlobsPath string
+	// LogDir is the directory where the logs of enclaves are kept.
+	LogDir string
+	// StateDir is the directory where the specs of pods are kept, so their
+	// enclaves are recovered when the node restarts. Pods are not persisted when empty.
+	StateDir string
+	// Resources looks up the ConfigMaps and Secrets referenced by pods.
+	Resources ResourceGetter
+	// Client is used to request the service account tokens of pods.
+	Client kubernetes.Interface
+	// Recorder records the events of the enclave lifecycle.
+	Recorder record.EventRecorder
+	// MaxEnclaves is how many enclaves can run at once, unlimited if zero.
+	MaxEnclaves int
+	// AllowDebugMode lets pods run their enclave in debug mode with the DebugModeAnnotation.
+	AllowDebugMode bool
+	// DebugModeAllowedNamespaces, when set, are the only namespaces whose
+	// pods may run their enclave in debug mode, when it is allowed.
+	DebugModeAllowedNamespaces []string
+	// DebugModeDeniedNamespaces are the namespaces whose pods may not run
+	// their enclave in debug mode, even when allowed.
+	DebugModeDeniedNamespaces []string
+	// Profiles are the size classes of enclaves pods select with the ProfileAnnotation, by name.
+	Profiles map[string]Profile
+	// RuntimeClass is the runtime class pods must request to run on the
+	// node, such as nitro-enclave. Pods need none when empty.
+	RuntimeClass string
+	// DedicatedTaint is the taint of the node pods must tolerate, such as
+	// aws.ec2.nitro/enclave=true:NoSchedule. Pods need none when nil.
+	DedicatedTaint *corev1.Taint
+	// AllowedNamespaces are the namespaces whose pods may run enclaves on the
+	// node, every namespace when empty.
+	AllowedNamespaces []string
+	// DeniedNamespaces are the namespaces whose pods may not run enclaves on
+	// the node, even when allowed.
+	DeniedNamespaces []string
+	// AllowedImages are the images pods may run in enclaves on the node, by
+	// registry, repository, prefix or digest, every image when empty.
+	AllowedImages []string
+	// DeniedImages are the images pods may not run in enclaves on the node,
+	// even when allowed.
+	DeniedImages []string
+	// RequireEnclaveResources rejects the pods which do not request their
+	// enclave vCPUs and memory as extended resources, so resource quotas cap
+	// the enclaves of every pod.
+	RequireEnclaveResources bool
+	// Signing is the policy on the signatures of the enclave images, which
+	// are launched unsigned when nil.
+	Signing *SigningPolicy
+	// RequireEnclavePolicies fails the pods whose enclave image cannot be
+	// checked as the EnclavePolicy resource is not installed, rather than
+	// launch it unchecked.
+	RequireEnclavePolicies bool
+	// KMSEndpoint is the URL of the KMS requests releasing the secrets of
+	// pods, the KMS endpoint of their region when empty.
+	KMSEndpoint string
+	// KMSNodeCredentials signs the KMS requests of the pods with no role
+	// bound to their service account with the credentials of the node.
+	KMSNodeCredentials bool
+	// DNSServer is the host:port of the name server resolving the queries of enclaves.
+	DNSServer string
+	// ClusterDomain is the DNS domain of the cluster, searched by enclaves
+	// with the ClusterFirst DNS policy. It defaults to cluster.local.
+	ClusterDomain string
+	// LogSink ships the logs of enclaves, which are only kept in their log files when nil.
+	LogSink LogSink
+	// Audit records the builds, launches and terminations of enclaves, and
+	// the requests on them, which are not audited when nil.
+	Audit *audit.Log
+	// BindAddress is the host address the port proxies listen on, every
+	// interface when empty. See BindAddressAnnotation for its values.
+	BindAddress string
+	// InternalIPv6 is the IPv6 address of dual-stack nodes, whose internal IP is IPv4.
+	InternalIPv6 string
+	// StatusUpdateInterval is the minimum interval between the status
+	// notifications of a pod, which are coalesced meanwhile. Every status
+	// change is notified right away when zero.
+	StatusUpdateInterval time.Duration
 }
 
 // Node represents an enclave enabled node.
 type Node struct {
 	name string
 	ip   string
-	pods map[string]*Pod
+	// ipv6 is the IPv6 address of dual-stack nodes.
+	ipv6      string
+	agentPath string
+	blobsPath string
+	logDir    string
+	stateDir  string
+	resources ResourceGetter
+	client    kubernetes.Interface
+	recorder  record.EventRecorder
+	// maxEnclaves is how many enclaves can run at once, unlimited if zero.
+	maxEnclaves int
+	// allowDebugMode lets pods run their enclave in debug mode.
+	allowDebugMode bool
+	// debugModeAllowedNamespaces and debugModeDeniedNamespaces are those
+	// whose pods may and may not run their enclave in debug mode.
+	debugModeAllowedNamespaces, debugModeDeniedNamespaces []string
+	// profiles are the size classes of enclaves, by name.
+	profiles map[string]Profile
+	// runtimeClassName is the runtime class pods must request, none when empty.
+	runtimeClassName string
+	// dedicatedTaint is the taint pods must tolerate, none when nil.
+	dedicatedTaint *corev1.Taint
+	// allowedNamespaces and deniedNamespaces are those whose pods may and
+	// may not run enclaves.
+	allowedNamespaces, deniedNamespaces []string
+	// allowedImages and deniedImages are the patterns of the images pods may
+	// and may not run in enclaves.
+	allowedImages, deniedImages []string
+	// requireEnclaveResources rejects the pods which do not request their
+	// enclave resources.
+	requireEnclaveResources bool
+	// signing is the policy on the signatures of enclave images, if any.
+	signing *SigningPolicy
+	// requireEnclavePolicies fails the pods when the EnclavePolicy resource is not installed.
+	requireEnclavePolicies bool
+	// kmsEndpoint is the URL of the KMS requests, that of their region when empty.
+	kmsEndpoint string
+	// kmsNodeCredentials signs the KMS requests of pods with no role with those of the node.
+	kmsNodeCredentials bool
+	// dnsServer resolves the DNS queries of enclaves.
+	dnsServer string
+	// clusterDomain is the DNS domain of the cluster.
+	clusterDomain string
+	// logSink ships the logs of enclaves, if any.
+	logSink LogSink
+	// audit records the operations on enclaves, if any.
+	audit *audit.Log
+	// bindAddress is the host address the port proxies listen on by default.
+	bindAddress string
+	// forwards are the host vsock ports forwarded for enclaves, guarded by forwardMu.
+	forwards  map[uint32]*vsockForward
+	forwardMu sync.Mutex
+	// sharedPorts are the host ports shared by pods routed by server name,
+	// by listen address, guarded by sharedPortsMu.
+	sharedPorts   map[string]*sharedPort
+	sharedPortsMu sync.Mutex
+	// launchMu serializes enclave launches, which contend for the CPU pool.
+	launchMu sync.Mutex
+	// pressure is the pressure on the enclave pools, guarded by pressureMu.
+	pressure         Pressure
+	pressureNotifier func(Pressure)
+	pressureMu       sync.Mutex
+	// health is the health of the node as of the last check, guarded by healthMu.
+	health         Health
+	healthNotifier func(Health)
+	healthMu       sync.Mutex
+	// drain tells whether the node is cordoned or drained, guarded by drainMu.
+	drain         Drain
+	drainNotifier func(Drain)
+	drainMu       sync.Mutex
+	// drift holds the enclaves the last reconciliation found drifting, guarded by reconcileMu.
+	drift       map[string]bool
+	reconcileMu sync.Mutex
+	startTime   time.Time
+	pods        map[string]*Pod
+	// current maps the namespace and name of pods to the tag of their most
+	// recent incarnation, as a recreated pod may share them with a terminating one.
+	current  map[string]string
+	notifier func(*corev1.Pod)
+	// throttle coalesces the status notifications of pods, if they are throttled.
+	throttle *statusThrottle
 	sync.RWMutex
 }
 
@@ -29,9 +200,67 @@ type Node struct {
 func NewNode(ctx context.Context, config *NodeConfig, internalIP string) (*Node, error) {
 	// Initialize the node.
 	node := &Node{
-		name: config.Name,
-		pods: make(map[string]*Pod),
-		ip:   internalIP,
+		name:           config.Name,
+		pods:           make(map[string]*Pod),
+		current:        make(map[string]string),
+		ip:             internalIP,
+		ipv6:           config.InternalIPv6,
+		agentPath:      config.AgentPath,
+		blobsPath:      config.BlobsPath,
+		logDir:         config.LogDir,
+		stateDir:       config.StateDir,
+		resources:      config.Resources,
+		client:         config.Client,
+		recorder:       config.Recorder,
+		maxEnclaves:    config.MaxEnclaves,
+		allowDebugMode: config.AllowDebugMode,
+		profiles:       config.Profiles,
+		dnsServer:      config.DNSServer,
+		clusterDomain:  config.ClusterDomain,
+		logSink:        config.LogSink,
+		audit:          config.Audit,
+		bindAddress:    config.BindAddress,
+		startTime:      time.Now(),
+
+		runtimeClassName: config.RuntimeClass,
+		dedicatedTaint:   config.DedicatedTaint,
+
+		allowedNamespaces: config.AllowedNamespaces,
+		deniedNamespaces:  config.DeniedNamespaces,
+
+		allowedImages: config.AllowedImages,
+		deniedImages:  config.DeniedImages,
+
+		debugModeAllowedNamespaces: config.DebugModeAllowedNamespaces,
+		debugModeDeniedNamespaces:  config.DebugModeDeniedNamespaces,
+
+		requireEnclaveResources: config.RequireEnclaveResources,
+		signing:                 config.Signing,
+		requireEnclavePolicies:  config.RequireEnclavePolicies,
+		kmsEndpoint:             config.KMSEndpoint,
+		kmsNodeCredentials:      config.KMSNodeCredentials,
+	}
+	if config.StatusUpdateInterval > 0 {
+		node.throttle = newStatusThrottle(config.StatusUpdateInterval, node.send)
+	}
+
+	if config.InternalIPv6 != "" && (net.ParseIP(config.InternalIPv6) == nil || isIPv4(config.InternalIPv6)) {
+		return nil, fmt.Errorf("invalid internal IPv6 address %q", config.InternalIPv6)
+	}
+	if _, err := resolveBindAddresses(config.BindAddress, node.internalIPs()); err != nil {
+		return nil, err
+	}
+	for name, profile := range config.Profiles {
+		if err := profile.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid profile %q: %v", name, err)
+		}
+	}
+
+	// Builds interrupted by a crash leave their artifacts behind, no build runs yet.
+	if removed, err := build.RemoveArtifacts(build.TempDir(), node.startTime); err != nil {
+		log.G(ctx).Warnf("Failed to remove stale build artifacts: %v.", err)
+	} else if removed > 0 {
+		log.G(ctx).Infof("Removed %d stale build artifacts.", removed)
 	}
 
 	// Load existing pod state from enclaves to the local cache.
@@ -43,7 +272,29 @@ func NewNode(ctx context.Context, config *NodeConfig, internalIP string) (*Node,
 	return node, nil
 }
 
+// Reconfigure applies the admission policies of a new configuration of the
+// node, how many enclaves run at once, whether and in which namespaces debug
+// mode is allowed, the profiles of enclaves, the runtime class pods request, the namespaces
+// allowed to run enclaves, the images allowed in them and whether pods must request their enclave
+// resources, to the pods admitted from now on. Its other settings apply on restart.
+func (n *Node) Reconfigure(config *NodeConfig) {
+	n.Lock()
+	defer n.Unlock()
+	n.maxEnclaves = config.MaxEnclaves
+	n.allowDebugMode = config.AllowDebugMode
+	n.debugModeAllowedNamespaces = config.DebugModeAllowedNamespaces
+	n.debugModeDeniedNamespaces = config.DebugModeDeniedNamespaces
+	n.profiles = config.Profiles
+	n.runtimeClassName = config.RuntimeClass
+	n.allowedNamespaces = config.AllowedNamespaces
+	n.deniedNamespaces = config.DeniedNamespaces
+	n.allowedImages = config.AllowedImages
+	n.deniedImages = config.DeniedImages
+	n.requireEnclaveResources = config.RequireEnclaveResources
+}
+
 // LoadPodState rebuilds pod and container objects in this node by loading existing enclaves
+// and the persisted state of their pods.
 func (n *Node) loadPodState(ctx context.Context) error {
 	log.G(ctx).Infof("Loading pod state for node %s", n.name)
 
@@ -56,6 +307,7 @@ func (n *Node) loadPodState(ctx context.Context) error {
 	log.G(ctx).Infof("Found %d enclaves on node %s.", len(enclaves), n.name)
 
 	pods := make(map[string]*Pod)
+	current := make(map[string]string)
 
 	// For each enclave running on this node...
 	for _, info := range enclaves {
@@ -71,15 +323,47 @@ func (n *Node) loadPodState(ctx context.Context) error {
 		}
 
 		pod.info = info
+		if info.State == cli.StateRunning {
+			pod.phase = corev1.PodRunning
+			pod.state = containerRunning
+			pod.ready = true
+		}
+
+		// Recover the spec of the pod, so the enclave is served and relaunched as before.
+		restored, err := pod.loadState()
+		if err != nil {
+			log.G(ctx).Warnf("Failed to load state of pod %s/%s: %v.", pod.namespace, pod.name, err)
+		}
+		if !restored {
+			pod.config.DebugMode = info.Flags == cli.FlagDebugMode
+		}
+		if restored && info.State == cli.StateRunning {
+			pod.Resume(ctx)
+		} else {
+			// Follow the enclave so its exit is noticed without a status query.
+			exit := make(chan struct{})
+			pod.mu.Lock()
+			pod.exit = exit
+			pod.mu.Unlock()
+			go pod.watch(ctx, exit)
+		}
 
 		log.G(ctx).Infof("Found pod %s/%s on node %s.", pod.namespace, pod.name, n.name)
 
 		pods[tag] = pod
+		key := podKey(pod.namespace, pod.name)
+		if other, ok := current[key]; ok {
+			log.G(ctx).Warnf("Found several enclaves for pod %s: %s and %s.", key, other, tag)
+		}
+		current[key] = tag
 	}
 
+	n.pruneStates(ctx, pods)
+
 	// Update local state.
 	n.Lock()
 	n.pods = pods
+	n.current = current
 	n.Unlock()
 
 	return nil
@@ -90,8 +374,7 @@ func (n *Node) GetPod(namespace string, name string) (*Pod, error) {
 	n.RLock()
 	defer n.RUnlock()
 
-	tag := buildEnclaveNameTag(namespace, name)
-	pod, ok := n.pods[tag]
+	pod, ok := n.pods[n.current[podKey(namespace, name)]]
 	if !ok {
 		return nil, errdefs.NotFoundf("pod %s/%s is not found", namespace, name)
 	}
@@ -99,6 +382,53 @@ func (n *Node) GetPod(namespace string, name string) (*Pod, error) {
 	return pod, nil
 }
 
+// GetPodByUID returns the incarnation of a Kubernetes pod with the given UID.
+// Pods adopted from enclaves launched without a UID in their tag match any UID.
+func (n *Node) GetPodByUID(namespace string, name string, uid k8sTypes.UID) (*Pod, error) {
+	n.RLock()
+	defer n.RUnlock()
+
+	for _, pod := range n.pods {
+		if pod.namespace == namespace && pod.name == name && pod.uid == uid {
+			return pod, nil
+		}
+	}
+	if pod, ok := n.pods[n.current[podKey(namespace, name)]]; ok && pod.uid == "" {
+		return pod, nil
+	}
+	return nil, errdefs.NotFoundf("pod %s/%s with UID %s is not found", namespace, name, uid)
+}
+
+// podKey identifies a pod by namespace and name.
+func podKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// internalIPs returns the internal IPs of the node, its primary one first.
+func (n *Node) internalIPs() []string {
+	var ips []string
+	for _, ip := range []string{n.ip, n.ipv6} {
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// RunningEnclaves returns how many enclaves of the pods of the node run.
+func (n *Node) RunningEnclaves() int {
+	pods, _ := n.GetPods()
+	var running int
+	for _, pod := range pods {
+		pod.mu.RLock()
+		if pod.state == containerRunning {
+			running++
+		}
+		pod.mu.RUnlock()
+	}
+	return running
+}
+
 // GetPods returns all Kubernetes pods deployed on this node.
 func (n *Node) GetPods() ([]*Pod, error) {
 	n.RLock()
@@ -113,12 +443,42 @@ func (n *Node) GetPods() ([]*Pod, error) {
 	return pods, nil
 }
 
+// NotifyPods sets the callback used to push pod status changes to Kubernetes.
+func (n *Node) NotifyPods(notifier func(*corev1.Pod)) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.notifier = notifier
+}
+
+// notify passes the pod to the notifier, if one has been set, throttling
+// the notifications of each pod.
+func (n *Node) notify(pod *corev1.Pod) {
+	if n.throttle != nil {
+		n.throttle.notify(pod)
+		return
+	}
+	n.send(pod)
+}
+
+// send passes the pod to the notifier, if one has been set.
+func (n *Node) send(pod *corev1.Pod) {
+	n.RLock()
+	notifier := n.notifier
+	n.RUnlock()
+
+	if notifier != nil {
+		notifier(pod)
+	}
+}
+
 // InsertPod inserts a Kubernetes pod to this node.
 func (n *Node) InsertPod(pod *Pod, tag string) {
 	n.Lock()
 	defer n.Unlock()
 
 	n.pods[tag] = pod
+	n.current[podKey(pod.namespace, pod.name)] = tag
 }
 
 // RemovePod removes a Kubernetes pod from this node.
@@ -126,45 +486,39 @@ func (n *Node) RemovePod(tag string) {
 	n.Lock()
 	defer n.Unlock()
 
+	pod, ok := n.pods[tag]
+	if !ok {
+		return
+	}
 	delete(n.pods, tag)
-}
 
-type truncatedReader struct {
-	r io.ReadCloser
-}
-
-func (tr truncatedReader) Read(p []byte) (n int, err error) {
-	n, err = tr.r.Read(p)
-	if err == io.EOF {
-		err := tr.r.Close()
-		if err != nil {
-			return n, err
-		}
+	key := podKey(pod.namespace, pod.name)
+	if n.current[key] == tag {
+		delete(n.current, key)
 	}
-	return n, err
-}
-
-func (tr truncatedReader) Close() error {
-	return tr.r.Close()
 }
 
 // GetContainerLogs returns the logs of a container from this node.
 func (n *Node) GetContainerLogs(namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
-	tag := buildEnclaveNameTag(namespace, podName)
-	pod, ok := n.pods[tag]
-	if !ok {
-		return nil, errdefs.NotFoundf("pod %s/%s is not found", namespace, podName)
-	}
-
-	// TODO add support for logging server, merge with console when available
-	// FIXME bunch of weird bugs atm, switch to writing to a file in the background
-	// FIXME only use console when enclave is running in debug mode
-	r, err := cli.Console(pod.info.EnclaveID)
+	pod, err := n.GetPod(namespace, podName)
 	if err != nil {
 		return nil, err
 	}
-	if !opts.Follow {
-		return truncatedReader{r}, nil
+
+	if opts.Previous {
+		return pod.previousLogs(containerName, opts)
+	}
+
+	// Only enclaves in debug mode have a console, the logs of others come from their agent.
+	pod.mu.RLock()
+	enclaveID := pod.info.EnclaveID
+	debug := pod.config.DebugMode
+	pod.mu.RUnlock()
+	if !debug {
+		return pod.currentLogs(containerName, opts)
+	}
+	if enclaveID == "" {
+		return nil, errdefs.NotFoundf("logs of container %q in pod %q not found", containerName, podName)
 	}
-	return r, nil
+	return pod.consoleLogs(opts)
 }
@@ -0,0 +1,40 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+)
+
+func TestRecreatedPod(t *testing.T) {
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+
+	old := &Pod{namespace: "default", name: "nginx", uid: "1111", node: n}
+	n.InsertPod(old, old.buildEnclaveNameTag())
+	recreated := &Pod{namespace: "default", name: "nginx", uid: "2222", node: n}
+	n.InsertPod(recreated, recreated.buildEnclaveNameTag())
+
+	// Lookups by name find the most recent pod, lookups by UID the terminating one.
+	pod, err := n.GetPod("default", "nginx")
+	assert.Nil(t, err)
+	assert.Equal(t, recreated, pod)
+	pod, err = n.GetPodByUID("default", "nginx", "1111")
+	assert.Nil(t, err)
+	assert.Equal(t, old, pod)
+
+	n.RemovePod(old.buildEnclaveNameTag())
+	pod, err = n.GetPod("default", "nginx")
+	assert.Nil(t, err)
+	assert.Equal(t, recreated, pod)
+	_, err = n.GetPodByUID("default", "nginx", "1111")
+	assert.True(t, errdefs.IsNotFound(err))
+
+	// Tags are parsed back to the pod they were built from.
+	parsed, err := NewPodFromTag(n, recreated.buildEnclaveNameTag())
+	assert.Nil(t, err)
+	assert.Equal(t, recreated.uid, parsed.uid)
+	parsed, err = NewPodFromTag(n, "vk-podspec_default_nginx")
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", parsed.name)
+}
@@ -2,16 +2,24 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/audit"
 	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
 	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
 	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
-	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/wait"
-	"github.com/mdlayher/vsock"
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -21,17 +29,43 @@ import (
 )
 
 const (
-	// Prefixes for objects created in Fargate.
-	enclaveNamePrefix = "vk-podspec"
+	// How long to wait for an enclave to terminate.
+	enclaveTerminateTimeout = 30 * time.Second
 
-	// Enclave state strings.
-	enclaveStateTerminating = "TERMINATING"
-	enclaveStateRunning     = "RUNNING"
+	// Reasons reported in the pod status.
+	podReasonBuilding         = "Building"
+	podReasonBuildFailed      = "BuildFailed"
+	podReasonLaunchFailed     = "LaunchFailed"
+	podReasonEnclaveExited    = "EnclaveExited"
+	podReasonCrashLoopBackOff = "CrashLoopBackOff"
+	podReasonTerminated       = "Terminated"
+	podReasonEnclaveBooting   = "EnclaveBooting"
+
+	// Reasons reported for a terminated container, matching the kubelet's.
+	containerReasonCompleted = "Completed"
+	containerReasonError     = "Error"
+
+	// containerIDPrefix prefixes the enclave IDs reported as container IDs.
+	containerIDPrefix = "nitro://"
+
+	// Exit code reported when the agent did not report the workload's exit,
+	// matching the kubelet's report for containers whose status was lost.
+	exitCodeUnknown = 137
+)
+
+// containerState is the state of the pod's enclave, reported as its container's state.
+type containerState int
+
+const (
+	containerWaiting containerState = iota
+	containerRunning
+	containerTerminated
 )
 
 type portMapping struct {
 	containerPort int32
 	hostPort      int32
+	protocol      corev1.Protocol
 }
 
 // Pod is the representation of a Kubernetes pod as a Nitro Enclave.
@@ -40,6 +74,7 @@ type Pod struct {
 	namespace string
 	name      string
 	uid       k8sTypes.UID
+	tag       string
 
 	// Enclave properties.
 	info       cli.EnclaveInfo
@@ -47,14 +82,65 @@ type Pod struct {
 	image      string
 	node       *Node
 	ports      []portMapping
+	tmpfs      []agent.TmpfsMount
 	containers map[string]*container
+	// kernel is the directory of the blobs of the profile of the pod, those
+	// of the node when empty.
+	kernel string
 
 	// Utilities
-	listeners []net.Listener
-	pod       *corev1.Pod
-	exit      chan struct{}
-	restarts  int32
-	startedAt metav1.Time
+	listeners []io.Closer
+	// proxies are the listeners of the TCP port proxies, also in listeners,
+	// and proxyServers the servers draining their connections.
+	proxies      []io.Closer
+	proxyServers sync.WaitGroup
+	logFile      *os.File
+	logWriter    *podLogWriter
+	// portConflicts describes the host ports the port proxies failed to bind.
+	portConflicts []string
+	console       *consoleLog
+	servers       sync.WaitGroup
+	pod           *corev1.Pod
+	exit          chan struct{}
+	done          chan struct{}
+	restarts      int32
+	startTime     metav1.Time
+	startedAt     metav1.Time
+	lastUsage     processUsage
+	// hasAgent tells the enclave image wraps the workload with the agent.
+	hasAgent bool
+
+	// Condition transition times, guarded by transitionsMu.
+	transitionsMu sync.Mutex
+	transitions   map[corev1.PodConditionType]conditionTransition
+
+	// Status, guarded by mu.
+	mu              sync.RWMutex
+	phase           corev1.PodPhase
+	state           containerState
+	imageID         string
+	ready           bool
+	reason          string
+	message         string
+	termination     *corev1.ContainerStateTerminated
+	lastTermination *corev1.ContainerStateTerminated
+	exitEvent       *agent.Event
+	killMessage     string
+	// booted is closed once the agent of the enclave reports the workload
+	// started, it is nil for enclaves without agent.
+	booted chan struct{}
+	// terminating tells the pod is being stopped, its enclave is not restarted.
+	terminating bool
+	// conditions are the pod conditions set by the workload of the enclave.
+	conditions map[corev1.PodConditionType]workloadCondition
+	// rejection is why the node rejected the pod for good, if it did.
+	rejection *AdmissionError
+	// pcrs are the measurements of the enclave image, when audited.
+	pcrs map[uint]string
+	// ephemeral are the ephemeral containers started in the enclave, by name.
+	ephemeral map[string]*ephemeralContainer
+	// lost stops waiting for the enclave, found gone by the reconciliation loop.
+	lost context.CancelFunc
 }
 
 func IsOwnedBy(pod *corev1.Pod, gvks []schema.GroupVersionKind) bool {
@@ -76,10 +162,6 @@ func IsOwnedByDaemonSet(pod *corev1.Pod) bool {
 
 // NewPod creates a new Kubernetes pod as a Nitro Enclave.
 func NewPod(ctx context.Context, node *Node, pod *corev1.Pod) (*Pod, error) {
-	if IsOwnedByDaemonSet(pod) {
-		return nil, fmt.Errorf("daemonsets are not supported")
-	}
-
 	// Initialize the pod.
 	nitroPod := &Pod{
 		namespace:  pod.Namespace,
@@ -89,17 +171,41 @@ func NewPod(ctx context.Context, node *Node, pod *corev1.Pod) (*Pod, error) {
 		ports:      make([]portMapping, 0),
 		containers: make(map[string]*container),
 		pod:        pod.DeepCopy(),
+		startTime:  metav1.Now(),
+		phase:      corev1.PodPending,
 	}
 
 	tag := nitroPod.buildEnclaveNameTag()
+	nitroPod.tag = tag
 	nitroPod.config.EnclaveName = tag
 
-	if len(pod.Spec.Containers) > 1 {
-		return nil, fmt.Errorf("launching more than 1 container is unsupported")
+	err := validatePod(node, pod)
+	if err == nil {
+		if _, bindErr := nitroPod.bindAddresses(); bindErr != nil {
+			err = unsupportedf(AdmissionReasonInvalidAnnotation, "%v", bindErr)
+		}
+	}
+	if err != nil {
+		var rejection *AdmissionError
+		if errors.As(err, &rejection) {
+			nitroPod.warnRejection(rejection)
+		}
+		return nil, err
 	}
+	nitroPod.recordImagesAllowed()
+	nitroPod.config.DebugMode, _ = debugMode(node, pod)
+	nitroPod.config.EnclaveCid, _ = requestedCID(pod)
 
 	// For each container in the pod...
 	for _, containerSpec := range pod.Spec.Containers {
+		// Merge the referenced ConfigMaps and Secrets into the environment.
+		env, err := resolveEnvironment(nitroPod.resources(), pod.Namespace, &containerSpec)
+		if err != nil {
+			return nil, err
+		}
+		containerSpec.Env = env
+		containerSpec.EnvFrom = nil
+
 		// Create a container definition.
 		cntr, err := newContainer(&containerSpec)
 		if err != nil {
@@ -110,178 +216,989 @@ func NewPod(ctx context.Context, node *Node, pod *corev1.Pod) (*Pod, error) {
 		nitroPod.config.CPUCount += cntr.definition.Cpu
 		nitroPod.config.MemoryMib += cntr.definition.Memory
 
-		for _, port := range containerSpec.Ports {
-			nitroPod.ports = append(nitroPod.ports, portMapping{
-				containerPort: port.ContainerPort,
-				hostPort:      port.HostPort,
-			})
+		// emptyDir volumes live in enclave memory, on top of the container's.
+		for _, m := range emptyDirMounts(pod, &containerSpec) {
+			nitroPod.tmpfs = append(nitroPod.tmpfs, m)
+			nitroPod.config.MemoryMib += m.SizeMiB
 		}
 
+		nitroPod.ports = append(nitroPod.ports, containerPorts(&containerSpec)...)
+
 		// Insert the container to its pod.
 		nitroPod.containers[containerSpec.Name] = cntr
 	}
 
+	// Enclave resources requested as extended resources, and profiles, size
+	// the whole enclave, whose memory holds the emptyDir volumes, otherwise
+	// the overhead of the runtime class is added.
+	cpus, memory := podOverhead(pod)
+	nitroPod.config.CPUCount += cpus
+	nitroPod.config.MemoryMib += memory
+	var volumes int64
+	for _, m := range nitroPod.tmpfs {
+		volumes += m.SizeMiB
+	}
+	if cpus, memory := accountedResources(pod); cpus > 0 || memory > 0 {
+		if memory > 0 && volumes >= memory {
+			rejection := unsupportedf(AdmissionReasonOutOfMemory, "the emptyDir volumes of %d MiB do not fit in the %d MiB of %s",
+				volumes, memory, ResourceEnclaveMemoryMiB)
+			nitroPod.warnRejection(rejection)
+			return nil, rejection
+		}
+		if cpus > 0 {
+			nitroPod.config.CPUCount = cpus
+		}
+		if memory > 0 {
+			nitroPod.config.MemoryMib = memory
+		}
+	}
+	if profile, _ := podProfile(node, pod); profile != nil {
+		if volumes >= profile.MemoryMiB() {
+			rejection := unsupportedf(AdmissionReasonOutOfMemory, "the emptyDir volumes of %d MiB do not fit in the %d MiB of profile %q",
+				volumes, profile.MemoryMiB(), pod.Annotations[ProfileAnnotation])
+			nitroPod.warnRejection(rejection)
+			return nil, rejection
+		}
+		nitroPod.config.CPUCount = profile.CPUs
+		nitroPod.config.MemoryMib = profile.MemoryMiB()
+		nitroPod.kernel = profile.Kernel
+
+		// The resources the pod requests must still account for the enclave.
+		if rejection := accountedError(pod, profile.CPUs, profile.MemoryMiB(), false); rejection != nil {
+			nitroPod.warnRejection(rejection)
+			return nil, rejection
+		}
+	}
+
 	// Register the task definition with Fargate.
 	log.G(ctx).Infof("produced EnclaveInfo %+v", nitroPod.config)
 
 	if node != nil {
-		node.InsertPod(nitroPod, tag)
+		if err := node.AdmitPod(nitroPod, tag); err != nil {
+			var rejection *AdmissionError
+			if errors.As(err, &rejection) {
+				nitroPod.warnRejection(rejection)
+			}
+			return nil, err
+		}
 	}
 
 	return nitroPod, nil
 }
 
+// warnRejection records a warning event for a rejection the pod is retried
+// after. Terminal rejections are reported once, by RejectPod.
+func (pod *Pod) warnRejection(rejection *AdmissionError) {
+	if !rejection.Terminal {
+		pod.warning(rejection.Reason, "%s", rejection.Message)
+	}
+}
+
+// containerPorts returns the port mappings of a container.
+func containerPorts(spec *corev1.Container) []portMapping {
+	var ports []portMapping
+	for _, port := range spec.Ports {
+		ports = append(ports, portMapping{
+			containerPort: port.ContainerPort,
+			hostPort:      port.HostPort,
+			protocol:      port.Protocol,
+		})
+	}
+	return ports
+}
+
 // NewPodFromTag creates a new pod identified by a tag.
 func NewPodFromTag(node *Node, tag string) (*Pod, error) {
-	data := strings.Split(tag, "_")
-
-	if len(data) < 3 ||
-		data[0] != enclaveNamePrefix {
-		return nil, fmt.Errorf("invalid tag")
+	namespace, name, uid, err := decodeTag(tag)
+	if err != nil {
+		return nil, err
 	}
 
 	pod := &Pod{
-		namespace:  data[1],
-		name:       data[2],
+		namespace:  namespace,
+		name:       name,
+		uid:        uid,
+		tag:        tag,
 		node:       node,
 		containers: make(map[string]*container),
+		phase:      corev1.PodUnknown,
 	}
 
 	return pod, nil
 }
 
-// Start deploys and runs a Kubernetes pod in an enclave.
-func (pod *Pod) Start(ctx context.Context, notifier func(*corev1.Pod)) error {
-	// Build the enclave image
-	var d containerDefinition
-	for _, v := range pod.containers {
-		d = v.definition
+// Start deploys and runs a Kubernetes pod in an enclave. The enclave image is
+// built and launched in the background, status transitions are pushed to the
+// node's pod notifier as they happen.
+func (pod *Pod) Start(ctx context.Context) error {
+	exit := make(chan struct{})
+	done := make(chan struct{})
+	pod.mu.Lock()
+	pod.exit = exit
+	pod.mu.Unlock()
+	pod.done = done
+
+	pod.setPhase(ctx, corev1.PodPending, podReasonBuilding, "building the enclave image")
+	if err := pod.saveState(); err != nil {
+		log.G(ctx).Errorf("Failed to save pod state: %v.\n", err)
 	}
 
-	eif, err := os.CreateTemp("", pod.config.EnclaveName)
-	if err != nil {
-		return err
+	go func() {
+		defer close(done)
+		pod.run(ctx, exit, nil)
+	}()
+
+	return nil
+}
+
+// Resume follows the running enclave of a pod recovered from its persisted
+// state, restarting its port proxies and servers, and relaunches the enclave
+// according to the pod's restart policy once it exits.
+func (pod *Pod) Resume(ctx context.Context) {
+	exit := make(chan struct{})
+	done := make(chan struct{})
+	pod.mu.Lock()
+	pod.exit = exit
+	pod.mu.Unlock()
+	pod.done = done
+
+	pod.mu.RLock()
+	info := pod.info
+	pod.mu.RUnlock()
+
+	go func() {
+		defer close(done)
+		pod.run(ctx, exit, &info)
+	}()
+}
+
+// run builds the enclave image and keeps the enclave running until the pod
+// is stopped. A resumed enclave is followed first, the image is only built
+// when it must be relaunched.
+func (pod *Pod) run(ctx context.Context, exit chan struct{}, resumed *cli.EnclaveInfo) {
+	ctx, cancel := exitContext(ctx, exit)
+	defer cancel()
+	defer func() {
+		if pod.config.EifPath != "" {
+			os.Remove(pod.config.EifPath)
+		}
+	}()
+
+	// checked tells whether the image was checked since its last launch.
+	checked := false
+	if resumed == nil {
+		if err := pod.build(ctx); err != nil {
+			return
+		}
+		checked = true
+	}
+
+	// Follow the process and relaunch it according to the pod's restart policy.
+	var backoff restartBackoff
+	for {
+		select {
+		case <-exit:
+			return
+		default:
+		}
+
+		launchedAt := time.Now()
+		var terminated *corev1.ContainerStateTerminated
+
+		var info *cli.EnclaveInfo
+		var err error
+		launched := resumed == nil
+		if resumed != nil {
+			// The enclave outlived the previous node process.
+			info = resumed
+			resumed = nil
+			log.G(ctx).Infof("resuming enclave %+v", info)
+			pod.attach(ctx, info)
+		} else {
+			if _, statErr := os.Stat(pod.config.EifPath); statErr != nil {
+				// The image of a resumed enclave may be gone, build it again.
+				if err := pod.build(ctx); err != nil {
+					return
+				}
+				checked = true
+			}
+			// Relaunched images are checked again, as their signing
+			// certificate may have expired or been revoked since.
+			if !checked {
+				describe := func() (*cli.EifInfo, error) { return describeEif(pod.config.EifPath) }
+				if err := pod.checkSignature(ctx, describe); err != nil {
+					return
+				}
+			}
+			checked = false
+			info, err = pod.launch(ctx)
+		}
+		if err != nil {
+			log.G(ctx).Errorf("failed to run enclave %v", err)
+			pod.warning(eventReasonEnclaveFailed, "Failed to run enclave: %v", err)
+			terminated = &corev1.ContainerStateTerminated{
+				ExitCode:   exitCodeUnknown,
+				Reason:     podReasonLaunchFailed,
+				Message:    fmt.Sprintf("failed to run enclave: %v", err),
+				StartedAt:  metav1.NewTime(launchedAt),
+				FinishedAt: metav1.Now(),
+			}
+		} else {
+			if launched {
+				pod.event(corev1.EventTypeNormal, eventReasonEnclaveStarted, "Started enclave %s with CID %d, %d CPUs and %d MiB",
+					info.EnclaveID, info.EnclaveCID, info.NumberOfCPUs, info.MemoryMiB)
+				if err := pod.postStart(ctx); err != nil && ctx.Err() == nil {
+					pod.warning(eventReasonFailedPostStartHook, "PostStartHook failed: %v", err)
+					pod.kill(ctx, fmt.Sprintf("PostStartHook failed: %v", err))
+				}
+			}
+			pod.setPhase(ctx, corev1.PodRunning, "", "")
+			stopProbes := pod.startProbes(ctx, *info)
+
+			// Wait for the process to exit
+			if err := pod.waitForEnclave(ctx, *info); err != nil {
+				log.G(ctx).Debugf("stopped watching enclave %s: %v", info.EnclaveID, err)
+			} else {
+				log.G(ctx).Infof("enclave terminated %+v", info)
+			}
+			stopProbes()
+			pod.closeListeners()
+			terminated = pod.terminated()
+		}
+
+		select {
+		case <-exit:
+			return
+		default:
+		}
+		// The enclave of a pod being stopped may exit during its preStop hook.
+		pod.mu.RLock()
+		terminating := pod.terminating
+		pod.mu.RUnlock()
+		if terminating {
+			<-exit
+			return
+		}
+
+		if terminated.Reason != podReasonLaunchFailed {
+			eventType := corev1.EventTypeNormal
+			if terminated.ExitCode != 0 {
+				eventType = corev1.EventTypeWarning
+			}
+			pod.event(eventType, eventReasonEnclaveExited, "Enclave exited with code %d", terminated.ExitCode)
+		}
+
+		failed := terminated.ExitCode != 0
+		pod.mu.RLock()
+		restartPolicy := pod.pod.Spec.RestartPolicy
+		pod.mu.RUnlock()
+		if !shouldRestart(restartPolicy, failed) {
+			pod.mu.Lock()
+			pod.termination = terminated
+			pod.mu.Unlock()
+			pod.setPhase(ctx, finalPhase(terminated), terminated.Reason, terminated.Message)
+			return
+		}
+
+		delay := backoff.next(time.Since(launchedAt))
+		log.G(ctx).Infof("restarting enclave %s/%s in %s", pod.namespace, pod.name, delay)
+
+		pod.mu.Lock()
+		pod.restarts += 1
+		pod.lastTermination = terminated
+		pod.mu.Unlock()
+		pod.warning(eventReasonBackOff, "Back-off %s restarting failed enclave", delay)
+		pod.setWaiting(ctx, podReasonCrashLoopBackOff, fmt.Sprintf("back-off %s restarting failed enclave", delay))
+
+		select {
+		case <-exit:
+			return
+		case <-time.After(delay):
+		}
 	}
+}
+
+// launch runs the enclave along with its port proxies, log and agent event servers.
+func (pod *Pod) launch(ctx context.Context) (*cli.EnclaveInfo, error) {
+	pod.mu.Lock()
+	pod.exitEvent = nil
+	pod.killMessage = ""
+	pod.conditions = nil
+	pod.mu.Unlock()
 
-	err = build.BuildEif("/usr/share/nitro_enclaves/blobs/", d.Image, append(d.EntryPoint, d.Command...), d.Environment, eif.Name())
+	// Start the enclave.
+	if pod.node != nil {
+		pod.node.launchMu.Lock()
+	}
+	info, err := cli.RunEnclave(&pod.config)
+	if pod.node != nil {
+		pod.node.launchMu.Unlock()
+	}
 	if err != nil {
-		err = fmt.Errorf("failed to build enclave image: %v", err)
-		return err
+		code := cli.ErrorCode(err)
+		if code == "" {
+			code = "unknown"
+		}
+		metrics.EnclaveLaunchFailures.WithLabelValues(code).Inc()
+		pod.audit(ctx, audit.Record{Operation: audit.OperationLaunch, Error: err.Error()})
+		return nil, err
 	}
-	log.G(ctx).Infof("built eif %s %+v %+v %s", d.Image, append(d.EntryPoint, d.Command...), d.Environment, eif.Name())
+	log.G(ctx).Infof("launched enclave %+v", info)
+	pod.audit(ctx, audit.Record{Operation: audit.OperationLaunch, EnclaveID: info.EnclaveID})
 
-	pod.config.EifPath = eif.Name()
-	// FIXME always debug for now
-	pod.config.DebugMode = true
+	pod.mu.Lock()
+	pod.startedAt = metav1.Now()
+	// The agent seeds the enclave's entropy before starting the workload,
+	// the pod is not ready until it reports it did.
+	pod.booted = nil
+	if pod.hasAgent {
+		pod.booted = make(chan struct{})
+	}
+	pod.mu.Unlock()
+	pod.attach(ctx, info)
+	if err := pod.saveState(); err != nil {
+		log.G(ctx).Errorf("Failed to save pod state: %v.\n", err)
+	}
 
-	// Follow the process and notify on termination
+	return info, nil
+}
 
-	exit := make(chan struct{})
-	go func() {
-		defer os.Remove(eif.Name())
+// attach starts the port proxies, log and agent event servers of a running enclave.
+func (pod *Pod) attach(ctx context.Context, info *cli.EnclaveInfo) {
+	// Start the port proxies
+	var listeners, proxies []io.Closer
+	limits := pod.proxyLimits()
+	bindAddresses, err := pod.bindAddresses()
+	if err != nil {
+		// The address was validated when the pod was created, the node's internal IP is gone.
+		log.G(ctx).Errorf("failed to resolve the bind address of the port proxies: %v", err)
+	}
+	hostnames := pod.sniHostnames()
+	var portConflicts []string
+	for _, mapping := range pod.ports {
+		for _, bindAddress := range bindAddresses {
+			name := fmt.Sprintf("proxy %d -> %d/%s", mapping.hostPort, mapping.containerPort, mapping.protocol)
+			address := net.JoinHostPort(bindAddress, strconv.Itoa(int(mapping.hostPort)))
+			if len(bindAddresses) > 1 {
+				name = fmt.Sprintf("proxy %s -> %d/%s", address, mapping.containerPort, mapping.protocol)
+			}
 
-		for {
-			select {
-			case <-exit:
-				break
-			default:
-				// Start the enclave.
-				info, err := cli.RunEnclave(&pod.config)
+			switch mapping.protocol {
+			case corev1.ProtocolUDP:
+				proxy := nitro.UDPProxy(uint32(info.EnclaveCID), uint32(mapping.containerPort))
+				conn, err := net.ListenPacket("udp", address)
 				if err != nil {
-					log.G(ctx).Errorf("failed to run enclave %v", err)
+					log.G(ctx).Errorf("failed to start %s: %v", name, err)
+					portConflicts = append(portConflicts, fmt.Sprintf("%s/UDP: %v", address, err))
+					continue
 				}
-				log.G(ctx).Infof("launched enclave %+v", info)
-				pod.startedAt = metav1.Now()
-
-				pod.pod.Status = pod.GetStatus()
-				notifier(pod.pod)
-
-				// Start the TCP proxies
-				for _, mapping := range pod.ports {
-					proxy := nitro.TCPProxy(uint32(info.EnclaveCID), uint32(mapping.containerPort))
-					listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", mapping.hostPort))
+				listeners = append(listeners, conn)
+				pod.serve(ctx, name, func() error {
+					return proxy.Serve(conn)
+				})
+			case corev1.ProtocolTCP, "":
+				if len(hostnames) > 0 && mapping.hostPort != 0 && pod.node != nil {
+					route, err := pod.node.routeSNI(address, hostnames, uint32(info.EnclaveCID), uint32(mapping.containerPort), limits)
 					if err != nil {
-						log.G(ctx).Errorf("failed to start proxy listener")
+						log.G(ctx).Errorf("failed to start %s: %v", name, err)
+						portConflicts = append(portConflicts, fmt.Sprintf("%s/TCP: %v", address, err))
 						continue
 					}
-					pod.listeners = append(pod.listeners, listener)
-					proxy.Serve(listener)
+					listeners = append(listeners, route)
+					proxies = append(proxies, route)
+					pod.proxyServers.Add(1)
+					pod.serve(ctx, name, func() error {
+						defer pod.proxyServers.Done()
+						return route.Serve()
+					})
+					continue
 				}
-
-				// Start the log server
-				// FIXME don't just write logs to stdout
-				logPort := uint32(info.EnclaveCID + 10000)
-				listener, err := vsock.Listen(logPort, &vsock.Config{})
+				proxy := nitro.TCPProxy(uint32(info.EnclaveCID), uint32(mapping.containerPort), limits)
+				listener, err := net.Listen("tcp", address)
 				if err != nil {
-					log.G(ctx).Errorf("failed to start log server listener")
-				} else {
-					pod.listeners = append(pod.listeners, listener)
-					logserve := nitro.NewVsockLogServer(ctx, os.Stdout, logPort)
-					go func() {
-						if err := logserve.Serve(listener); err != nil {
-							log.G(ctx).Errorf("failed to start log server")
-						}
-					}()
+					log.G(ctx).Errorf("failed to start %s: %v", name, err)
+					portConflicts = append(portConflicts, fmt.Sprintf("%s/TCP: %v", address, err))
+					continue
 				}
+				listeners = append(listeners, listener)
+				proxies = append(proxies, listener)
+				pod.proxyServers.Add(1)
+				pod.serve(ctx, name, func() error {
+					defer pod.proxyServers.Done()
+					return proxy.Serve(listener)
+				})
+			default:
+				log.G(ctx).Errorf("failed to start %s: unsupported protocol", name)
+			}
+		}
+	}
 
-				// Save the enclave info
-				pod.info = *info
+	// Start the log server, keeping a log file per enclave instance and
+	// shipping the logs to the node's log sink.
+	pod.mu.RLock()
+	instance := pod.restarts
+	pod.mu.RUnlock()
+	logWriter := &podLogWriter{sinks: make(map[agent.LogStream]io.WriteCloser)}
+	logFile, err := pod.openLog(instance)
+	if err != nil {
+		log.G(ctx).Warnf("failed to open log file: %v", err)
+	} else {
+		logWriter.file = logFile
+	}
+	if pod.node != nil && pod.node.logSink != nil {
+		for _, stream := range []agent.LogStream{agent.LogStdout, agent.LogStderr} {
+			sink, err := pod.node.logSink.Writer(pod.namespace, pod.name, instance, stream)
+			if err != nil {
+				log.G(ctx).Warnf("failed to open log sink: %v", err)
+				continue
+			}
+			logWriter.sinks[stream] = sink
+		}
+	}
 
-				// Wait for the process to exit
-				wait.ForPID(info.ProcessID)
-				log.G(ctx).Infof("enclave terminated %+v", info)
+	logPort := agent.LogPort(uint32(info.EnclaveCID))
+	listener, err := transport.Listen(logPort)
+	if err != nil {
+		log.G(ctx).Errorf("failed to start log server listener: %v", err)
+	} else {
+		listeners = append(listeners, listener)
+		logserve := nitro.NewVsockLogServer(ctx, logWriter, logPort)
+		pod.serve(ctx, "log server", func() error {
+			return logserve.Serve(listener)
+		})
+	}
 
-				pod.pod.Status = pod.GetStatus()
-				notifier(pod.pod)
+	// Start the agent event server
+	eventPort := agent.EventPort(uint32(info.EnclaveCID))
+	eventListener, err := transport.Listen(eventPort)
+	if err != nil {
+		log.G(ctx).Errorf("failed to start agent event listener: %v", err)
+	} else {
+		listeners = append(listeners, eventListener)
+		events := agent.NewEventServer(func(e agent.Event) {
+			pod.handleEvent(ctx, e)
+		})
+		pod.serve(ctx, "agent event server", func() error {
+			return events.Serve(eventListener)
+		})
+	}
 
-				// Terminate any existing listeners
-				if len(pod.listeners) > 0 {
-					for _, listener := range pod.listeners {
-						listener.Close()
-					}
-				}
-				pod.listeners = nil
+	// Start the time service, keeping the enclave's clock in sync
+	clockListener, err := transport.Listen(agent.ClockPort(uint32(info.EnclaveCID)))
+	if err != nil {
+		log.G(ctx).Errorf("failed to start time service listener: %v", err)
+	} else {
+		listeners = append(listeners, clockListener)
+		pod.serve(ctx, "time service", func() error {
+			return agent.ClockServer{}.Serve(clockListener)
+		})
+	}
+
+	// Start the egress proxy, forwarding the enclave's traffic to the allowed destinations
+	if allowed := pod.egress(); len(allowed) > 0 {
+		egressPort := agent.EgressPort(uint32(info.EnclaveCID))
+		egressListener, err := transport.Listen(egressPort)
+		if err != nil {
+			log.G(ctx).Errorf("failed to start egress proxy listener: %v", err)
+		} else {
+			listeners = append(listeners, egressListener)
+			egress := nitro.EgressProxy(allowed, nitro.DefaultEgressConnectTimeout)
+			pod.serve(ctx, "egress proxy", func() error {
+				return egress.Serve(egressListener)
+			})
+		}
+	}
+
+	// Start the DNS proxy
+	if filter := pod.dns(); filter != nil && pod.node != nil {
+		dnsListener, err := transport.Listen(agent.DNSPort(uint32(info.EnclaveCID)))
+		if err != nil {
+			log.G(ctx).Errorf("failed to start DNS proxy listener: %v", err)
+		} else {
+			listeners = append(listeners, dnsListener)
+			dns := nitro.DNSProxy(pod.dnsUpstream(), filter.allow, filter.deny, nitro.DefaultDNSTimeout)
+			pod.serve(ctx, "DNS proxy", func() error {
+				return dns.Serve(dnsListener)
+			})
+		}
+	}
+
+	// Forward the enclave's KMS requests
+	if kms := pod.kmsProxy(); kms != nil && pod.node != nil {
+		closer, err := pod.node.forwardVsock(kms.port, uint32(info.EnclaveCID), kms.endpoint)
+		if err != nil {
+			log.G(ctx).Errorf("failed to start KMS proxy: %v", err)
+		} else {
+			listeners = append(listeners, closer)
+		}
+	}
+
+	// Forward the enclave's Vault connections
+	if v := pod.vault(); v != nil && pod.node != nil {
+		closer, err := pod.node.forwardVsock(agent.VaultPort(uint32(info.EnclaveCID)), uint32(info.EnclaveCID), v.hostPort)
+		if err != nil {
+			log.G(ctx).Errorf("failed to start Vault forwarder: %v", err)
+		} else {
+			listeners = append(listeners, closer)
+		}
+	}
 
-				// FIXME can we disambiguate successful exit from failure?
-				if pod.pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
-					pod.exit = nil
-					break
+	// Vend the credentials of the role bound to the pod's service account
+	if roleARN, err := pod.roleARN(ctx); err != nil {
+		log.G(ctx).Errorf("failed to start credential endpoint: %v", err)
+	} else if roleARN != "" {
+		credentialsListener, err := transport.Listen(agent.CredentialsPort(uint32(info.EnclaveCID)))
+		if err != nil {
+			log.G(ctx).Errorf("failed to start credential endpoint listener: %v", err)
+		} else {
+			listeners = append(listeners, credentialsListener)
+			vendor := newCredentialVendor(pod, roleARN)
+			mux := http.NewServeMux()
+			mux.Handle(agent.CredentialsPath, vendor)
+			server := &http.Server{Handler: mux, ReadHeaderTimeout: credentialsRetryInterval}
+			pod.serve(ctx, "credential endpoint", func() error {
+				return server.Serve(credentialsListener)
+			})
+			refreshCtx, cancel := context.WithCancel(ctx)
+			listeners = append(listeners, cancelCloser(cancel))
+			pod.serve(ctx, "credential refresher", func() error {
+				return vendor.refresh(refreshCtx)
+			})
+		}
+	}
+
+	// Push the projected volumes, such as the service account token, to the agent
+	if len(pod.projectedMounts()) > 0 {
+		volumesCtx, cancel := context.WithCancel(ctx)
+		listeners = append(listeners, cancelCloser(cancel))
+		pod.serve(ctx, "projected volume updater", func() error {
+			return pod.updateProjectedVolumes(volumesCtx, uint32(info.EnclaveCID))
+		})
+	}
+
+	// Release the KMS secrets of the pod into its enclave once attested
+	if names := pod.kmsSecrets(); len(names) > 0 {
+		secretsCtx, cancel := context.WithCancel(ctx)
+		listeners = append(listeners, cancelCloser(cancel))
+		pod.serve(ctx, "KMS secret release", func() error {
+			if err := pod.releaseSecrets(secretsCtx, uint32(info.EnclaveCID), names); err != nil && secretsCtx.Err() == nil {
+				pod.warning(eventReasonSecretReleaseFailed, "Failed to release KMS secrets: %v", err)
+			}
+			return nil
+		})
+	}
+
+	// Publish the measurements of the enclave
+	attestCtx, cancel := context.WithCancel(ctx)
+	listeners = append(listeners, cancelCloser(cancel))
+	pod.serve(ctx, "CID publisher", func() error {
+		if err := pod.publishCID(attestCtx, info.EnclaveCID); err != nil && attestCtx.Err() == nil {
+			log.G(ctx).Warnf("failed to publish CID of pod %s/%s: %v", pod.namespace, pod.name, err)
+		}
+		return nil
+	})
+	pod.serve(ctx, "attestation publisher", func() error {
+		if err := pod.publishAttestation(attestCtx, uint32(info.EnclaveCID)); err != nil && attestCtx.Err() == nil {
+			pod.warning(eventReasonAttestFailed, "Failed to publish attestation: %v", err)
+		}
+		return nil
+	})
+
+	// Save the enclave info
+	pod.mu.Lock()
+	pod.info = *info
+	pod.portConflicts = portConflicts
+	pod.listeners = listeners
+	pod.proxies = proxies
+	pod.logFile = logFile
+	pod.logWriter = logWriter
+	pod.mu.Unlock()
+
+	if len(portConflicts) > 0 {
+		pod.warning(eventReasonHostPortConflict, "Failed to bind host ports: %s", strings.Join(portConflicts, "; "))
+	}
+}
+
+// build builds the enclave image of the pod, failing the pod if it cannot be built.
+func (pod *Pod) build(ctx context.Context) error {
+	var d containerDefinition
+	for _, v := range pod.containers {
+		d = v.definition
+	}
+
+	eif, err := build.CreateEif(pod.config.EnclaveName)
+	if err != nil {
+		pod.warning(eventReasonBuildFailed, "Failed to create enclave image file: %v", err)
+		pod.setPhase(ctx, corev1.PodFailed, podReasonBuildFailed, err.Error())
+		pod.audit(ctx, audit.Record{Operation: audit.OperationBuild, Error: err.Error()})
+		return err
+	}
+	pod.mu.Lock()
+	pod.config.EifPath = eif
+	pod.mu.Unlock()
+
+	cmds := append(d.EntryPoint, d.Command...)
+	var files []build.File
+	if pod.node != nil && pod.node.agentPath != "" {
+		if _, err := os.Stat(pod.node.agentPath); err == nil {
+			// The agent wraps the workload to report its exit code and serve its stdio.
+			agentCmd := []string{agent.Path}
+			pod.mu.RLock()
+			spec := pod.pod
+			pod.mu.RUnlock()
+			if spec != nil && len(spec.Spec.Containers) > 0 {
+				if spec.Spec.Containers[0].Stdin {
+					agentCmd = append(agentCmd, "-stdin")
+				}
+				if spec.Spec.Containers[0].TTY {
+					agentCmd = append(agentCmd, "-tty")
 				}
-				log.G(ctx).Infof("restarting enclave %+v", info)
-				pod.restarts += 1
 			}
+			for _, m := range pod.tmpfs {
+				agentCmd = append(agentCmd, "-tmpfs="+m.String())
+			}
+			if len(pod.projectedMounts()) > 0 {
+				agentCmd = append(agentCmd, fmt.Sprintf("-wait-files=%s", projectedVolumeBootTimeout))
+			}
+			if len(pod.egress()) > 0 {
+				agentCmd = append(agentCmd, "-egress-proxy="+egressProxyAddress)
+			}
+			if pod.dns() != nil {
+				agentCmd = append(agentCmd, "-dns")
+			}
+			agentCmd = append(agentCmd, "-sync-clock")
+			agentCmd = append(agentCmd, pod.raTLSFlags()...)
+			agentCmd = append(agentCmd, pod.vaultFlags()...)
+			if len(pod.kmsSecrets()) > 0 {
+				agentCmd = append(agentCmd, fmt.Sprintf("-kms-secrets=%s", secretsReleaseTimeout))
+			}
+			if roleARN, err := pod.roleARN(ctx); err != nil {
+				log.G(ctx).Warnf("building enclave without AWS credentials: %v", err)
+			} else if roleARN != "" {
+				agentCmd = append(agentCmd, "-credentials="+credentialsAddress)
+			}
+			cmds = append(append(agentCmd, "--"), cmds...)
+			files = append(files, build.File{Path: agent.Path, Source: pod.node.agentPath, Mode: "0755"})
+			pod.hasAgent = true
+		} else {
+			log.G(ctx).Warnf("building enclave without agent, volumes are not mounted: %v", err)
+		}
+	}
+
+	files = append(files, pod.resolverFiles()...)
+
+	pod.event(corev1.EventTypeNormal, eventReasonBuilding, "Building enclave image from %q", d.Image)
+	buildStart := time.Now()
+	blobsPath := build.DefaultBlobsPath
+	if pod.kernel != "" {
+		blobsPath = pod.kernel
+	} else if pod.node != nil && pod.node.blobsPath != "" {
+		blobsPath = pod.node.blobsPath
+	}
+	err = build.BuildEif(blobsPath, d.Image, cmds, d.Environment, eif, files...)
+	if err != nil {
+		metrics.EIFBuildFailures.WithLabelValues(build.FailedStage(err)).Inc()
+		log.G(ctx).Errorf("failed to build enclave image: %v", err)
+		pod.warning(eventReasonBuildFailed, "Failed to build enclave image from %q: %v", d.Image, err)
+		pod.setPhase(ctx, corev1.PodFailed, podReasonBuildFailed, fmt.Sprintf("failed to build enclave image: %v", err))
+		pod.audit(ctx, audit.Record{Operation: audit.OperationBuild, Error: err.Error()})
+		return err
+	}
+	metrics.EIFBuildDuration.Observe(time.Since(buildStart).Seconds())
+	pod.event(corev1.EventTypeNormal, eventReasonBuilt, "Built enclave image from %q in %s", d.Image, time.Since(buildStart).Round(time.Millisecond))
+	log.G(ctx).Infof("built eif %s %+v %+v %s", d.Image, append(d.EntryPoint, d.Command...), d.Environment, eif)
+	return pod.checkImage(ctx)
+}
+
+// serve runs a server of the pod in the background until its listener is closed.
+func (pod *Pod) serve(ctx context.Context, name string, serve func() error) {
+	pod.servers.Add(1)
+	go func() {
+		defer pod.servers.Done()
+
+		if err := serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.G(ctx).Errorf("%s stopped: %v", name, err)
 		}
 	}()
-	pod.exit = exit
+}
 
-	pod.pod.Status = pod.GetStatus()
-	notifier(pod.pod)
+// cancelCloser cancels a context when closed, so background work stops along with the listeners.
+type cancelCloser context.CancelFunc
 
+func (c cancelCloser) Close() error {
+	c()
 	return nil
 }
 
-// Stop stops a running Kubernetes pod running as an enclave.
-func (pod *Pod) Stop(ctx context.Context) error {
-	if pod.exit != nil {
-		close(pod.exit)
-		pod.exit = nil
+// handleEvent records an event reported by the enclave's agent.
+func (pod *Pod) handleEvent(ctx context.Context, e agent.Event) {
+	log.G(ctx).Debugf("received agent event %+v", e)
+
+	switch e.Type {
+	case agent.EventStarted:
+		pod.mu.Lock()
+		booting := pod.booting()
+		if booting {
+			close(pod.booted)
+		}
+		pod.mu.Unlock()
+		if booting {
+			pod.notify(ctx)
+		}
+	case agent.EventExit:
+		pod.mu.Lock()
+		pod.exitEvent = &e
+		pod.mu.Unlock()
+	case agent.EventCondition:
+		pod.setCondition(ctx, e)
+	}
+}
+
+// booting tells whether the agent did not report the workload started yet. The caller holds pod.mu.
+func (pod *Pod) booting() bool {
+	if pod.booted == nil {
+		return false
 	}
+	select {
+	case <-pod.booted:
+		return false
+	default:
+		return true
+	}
+}
+
+// terminated returns the terminated state of the enclave which just exited,
+// using the exit status reported by its agent when available.
+func (pod *Pod) terminated() *corev1.ContainerStateTerminated {
+	pod.mu.RLock()
+	defer pod.mu.RUnlock()
 
-	_, err := cli.TerminateEnclave(pod.info.EnclaveID)
+	terminated := &corev1.ContainerStateTerminated{
+		ExitCode:   exitCodeUnknown,
+		Reason:     podReasonEnclaveExited,
+		Message:    "the enclave exited",
+		StartedAt:  pod.startedAt,
+		FinishedAt: metav1.Now(),
+	}
+	if pod.killMessage != "" {
+		terminated.Reason = containerReasonError
+		terminated.Message = pod.killMessage
+	}
+	if e := pod.exitEvent; e != nil {
+		terminated.ExitCode = int32(e.ExitCode)
+		terminated.Reason = containerReasonError
+		terminated.Message = ""
+		if e.ExitCode == 0 {
+			terminated.Reason = containerReasonCompleted
+		}
+		if e.Signal != "" {
+			terminated.Message = fmt.Sprintf("the workload was terminated by %s", e.Signal)
+		}
+		if !e.Time.IsZero() {
+			terminated.FinishedAt = metav1.NewTime(e.Time)
+		}
+	}
+	return terminated
+}
+
+// kill terminates the running enclave after its preStop hook, recording why
+// in its terminated state. The enclave is then restarted according to the
+// pod's restart policy.
+func (pod *Pod) kill(ctx context.Context, message string) {
+	pod.mu.Lock()
+	pod.killMessage = message
+	enclaveID := pod.info.EnclaveID
+	pod.mu.Unlock()
+
+	pod.preStop(ctx)
+
+	log.G(ctx).Infof("killing enclave %s of %s/%s: %s", enclaveID, pod.namespace, pod.name, message)
+	pod.event(corev1.EventTypeNormal, eventReasonKilling, "Killing enclave %s: %s", enclaveID, message)
+	_, err := cli.TerminateEnclave(enclaveID)
 	if err != nil {
-		log.G(ctx).Errorf("Failed to stop enclave: %v.\n", err)
+		log.G(ctx).Errorf("failed to kill enclave %s: %v", enclaveID, err)
 	}
+	pod.audit(ctx, audit.Record{Operation: audit.OperationTerminate, EnclaveID: enclaveID, Reason: message, Error: auditError(err)})
+}
+
+// closeListeners terminates the proxy, log and agent listeners and the projected
+// volume updater of the pod, and waits for their servers to stop.
+func (pod *Pod) closeListeners() {
+	pod.mu.Lock()
+	for _, listener := range pod.listeners {
+		listener.Close()
+	}
+	pod.listeners = nil
+	pod.proxies = nil
+	pod.mu.Unlock()
+
+	pod.servers.Wait()
+
+	pod.mu.Lock()
+	if pod.logFile != nil {
+		pod.logFile.Close()
+		pod.logFile = nil
+	}
+	if pod.logWriter != nil {
+		pod.logWriter.Close()
+		pod.logWriter = nil
+	}
+	// The console output stays readable until the next enclave is attached to.
+	if pod.console != nil {
+		pod.console.Close()
+	}
+	pod.mu.Unlock()
+}
+
+// Stop stops a running Kubernetes pod running as an enclave.
+func (pod *Pod) Stop(ctx context.Context) error {
+	pod.shutdown(ctx, "the pod was deleted")
 
 	// Remove the pod from its node.
 	if pod.node != nil {
 		pod.node.RemovePod(pod.buildEnclaveNameTag())
 	}
+	if err := pod.removeLogs(); err != nil {
+		log.G(ctx).Errorf("Failed to remove pod logs: %v.\n", err)
+	}
+	if err := pod.removeState(); err != nil {
+		log.G(ctx).Errorf("Failed to remove pod state: %v.\n", err)
+	}
+
+	// Let the pod controller know the containers have terminated so the deletion can complete.
+	pod.mu.Lock()
+	pod.termination = nil
+	pod.mu.Unlock()
+	pod.setPhase(ctx, corev1.PodSucceeded, podReasonTerminated, "the pod was deleted")
 
 	return nil
 }
 
-// GetSpec returns the specification of a Kubernetes pod on Fargate.
+// shutdown terminates the enclave for the given reason and waits for the
+// pod's servers to stop.
+func (pod *Pod) shutdown(ctx context.Context, reason string) {
+	// The preStop hook runs while the enclave still serves its connections.
+	pod.mu.Lock()
+	pod.terminating = true
+	pod.mu.Unlock()
+	pod.preStop(ctx)
+
+	// Shutdown may be reached concurrently from Stop, drains, evictions and
+	// the node shutdown, only the first one closes the exit channel.
+	pod.mu.Lock()
+	exit := pod.exit
+	pod.exit = nil
+	pod.mu.Unlock()
+	if exit != nil {
+		close(exit)
+	}
+
+	pod.mu.RLock()
+	enclaveID := pod.info.EnclaveID
+	pod.mu.RUnlock()
+
+	if enclaveID != "" {
+		// Let the connections to the enclave end before terminating it.
+		pod.drainProxies()
+		pod.event(corev1.EventTypeNormal, eventReasonKilling, "Stopping enclave %s", enclaveID)
+		_, err := cli.TerminateEnclave(enclaveID)
+		pod.audit(ctx, audit.Record{Operation: audit.OperationTerminate, EnclaveID: enclaveID, Reason: reason, Error: auditError(err)})
+		if err != nil {
+			log.G(ctx).Errorf("Failed to stop enclave: %v.\n", err)
+		} else if err := cli.WaitForState(ctx, enclaveID, cli.StateTerminated, enclaveTerminateTimeout); err != nil {
+			log.G(ctx).Errorf("Failed to wait for enclave termination: %v.\n", err)
+		}
+	}
+
+	// Wait for the proxies to release their ports.
+	if pod.done != nil {
+		select {
+		case <-pod.done:
+		case <-time.After(enclaveTerminateTimeout):
+			log.G(ctx).Errorf("Timed out waiting for pod %s/%s to stop.\n", pod.namespace, pod.name)
+		}
+	}
+}
+
+// setPhase records a pod phase transition and notifies the pod controller.
+func (pod *Pod) setPhase(ctx context.Context, phase corev1.PodPhase, reason, message string) {
+	pod.mu.Lock()
+	pod.phase = phase
+	pod.reason = reason
+	pod.message = message
+	switch phase {
+	case corev1.PodRunning:
+		pod.state = containerRunning
+		// Containers with readiness or startup probes become ready once they pass.
+		pod.ready = true
+		if pod.pod != nil && len(pod.pod.Spec.Containers) > 0 {
+			container := pod.pod.Spec.Containers[0]
+			pod.ready = container.ReadinessProbe == nil && container.StartupProbe == nil
+		}
+	case corev1.PodSucceeded, corev1.PodFailed:
+		pod.state = containerTerminated
+	default:
+		pod.state = containerWaiting
+	}
+	pod.mu.Unlock()
+
+	pod.notify(ctx)
+}
+
+// setReady records the result of the readiness probe, notifying the pod
+// controller when it changes.
+func (pod *Pod) setReady(ctx context.Context, ready bool) {
+	pod.mu.Lock()
+	changed := pod.ready != ready
+	pod.ready = ready
+	pod.mu.Unlock()
+
+	if changed {
+		pod.notify(ctx)
+	}
+}
+
+// setWaiting marks the enclave as waiting to be (re)started without changing
+// the pod phase, and notifies the pod controller.
+func (pod *Pod) setWaiting(ctx context.Context, reason, message string) {
+	pod.mu.Lock()
+	pod.state = containerWaiting
+	pod.reason = reason
+	pod.message = message
+	pod.mu.Unlock()
+
+	pod.notify(ctx)
+}
+
+// notify pushes the current status of the pod to the node's pod notifier.
+func (pod *Pod) notify(ctx context.Context) {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if pod.node == nil || spec == nil {
+		return
+	}
+
+	p := spec.DeepCopy()
+	p.Status = pod.GetStatus()
+
+	log.G(ctx).Debugf("notifying pod %s/%s status %s", pod.namespace, pod.name, p.Status.Phase)
+	pod.node.notify(p)
+}
+
+// GetSpec returns the specification of a Kubernetes pod running as an enclave.
+// Pods adopted from existing enclaves only have the spec derived from their
+// container definitions until they are updated.
 func (pod *Pod) GetSpec() (*corev1.Pod, error) {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec != nil {
+		p := spec.DeepCopy()
+		p.Status = pod.GetStatus()
+		return p, nil
+	}
+
 	containers := make([]corev1.Container, 0, len(pod.containers))
 
 	for _, c := range pod.containers {
@@ -352,56 +1269,157 @@ func (pod *Pod) GetSpec() (*corev1.Pod, error) {
 
 // GetStatus returns the status of a Kubernetes pod running as an enclave.
 func (pod *Pod) GetStatus() corev1.PodStatus {
+	pod.mu.RLock()
+	defer pod.mu.RUnlock()
+
 	status := corev1.PodStatus{
-		Phase: corev1.PodUnknown,
-		ContainerStatuses: []corev1.ContainerStatus{
-			corev1.ContainerStatus{
-				Ready:        false,
-				RestartCount: pod.restarts,
-			},
-		},
+		Phase:             pod.phase,
+		ContainerStatuses: []corev1.ContainerStatus{pod.containerStatus()},
 	}
-	if pod.exit == nil {
-		status.Phase = corev1.PodSucceeded
-		return status
+	if pod.phase != corev1.PodRunning {
+		status.Reason = pod.reason
+		status.Message = pod.message
 	}
-	status.Phase = corev1.PodRunning
-	status.HostIP = pod.node.ip
-	status.PodIP = pod.node.ip
-	status.Conditions = []corev1.PodCondition{
-		corev1.PodCondition{Type: corev1.PodInitialized, Status: "True"},
+	if pod.node != nil {
+		// Enclaves are reached through the port proxies of the host.
+		status.HostIP = pod.node.ip
+		status.PodIP = pod.node.ip
+		for _, ip := range pod.node.internalIPs() {
+			status.PodIPs = append(status.PodIPs, corev1.PodIP{IP: ip})
+		}
 	}
-
-	enclaves, err := cli.DescribeEnclaves()
-	if err != nil {
-		return status
+	if !pod.startTime.IsZero() {
+		startTime := pod.startTime
+		status.StartTime = &startTime
 	}
 
-	for _, info := range enclaves {
-		if info.EnclaveName == pod.buildEnclaveNameTag() {
-			if info.State == enclaveStateRunning {
-				status.ContainerStatuses[0].Ready = true
-				status.ContainerStatuses[0].State.Running = &corev1.ContainerStateRunning{
-					StartedAt: pod.startedAt,
-				}
-				status.Conditions = append(status.Conditions, []corev1.PodCondition{
-					corev1.PodCondition{Type: corev1.PodReady, Status: "True"},
-					corev1.PodCondition{Type: corev1.ContainersReady, Status: "True"},
-				}...)
+	switch pod.state {
+	case containerWaiting:
+		status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{
+			Reason:  pod.reason,
+			Message: pod.message,
+		}
+		status.Conditions = pod.stampConditions(notReadyConditions())
+	case containerRunning:
+		isReady := pod.ready
+		var reason, message string
+		if pod.booting() {
+			isReady, reason, message = false, podReasonEnclaveBooting, "waiting for the agent to start the workload"
+		}
+		if conflicts := pod.portConflictMessage(); conflicts != "" {
+			isReady, reason, message = false, podReasonHostPortConflict, conflicts
+		}
+		containersReady := corev1.ConditionFalse
+		if isReady {
+			containersReady = corev1.ConditionTrue
+		}
+		started := true
+		status.ContainerStatuses[0].Ready = isReady
+		status.ContainerStatuses[0].Started = &started
+		status.ContainerStatuses[0].State.Running = &corev1.ContainerStateRunning{
+			StartedAt: pod.startedAt,
+		}
+
+		// The pod is ready once its container is and its readiness gates are true.
+		gates, gatesMessage := pod.readinessGates()
+		ready, podReason, podMessage := containersReady, reason, message
+		if isReady && gatesMessage != "" {
+			ready, podReason, podMessage = corev1.ConditionFalse, podReasonReadinessGatesNotReady, gatesMessage
+		}
+		status.Conditions = pod.stampConditions(append([]corev1.PodCondition{
+			corev1.PodCondition{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+			corev1.PodCondition{Type: corev1.PodReady, Status: ready, Reason: podReason, Message: podMessage},
+			corev1.PodCondition{Type: corev1.ContainersReady, Status: containersReady, Reason: reason, Message: message},
+			pod.attestableCondition(),
+		}, gates...))
+	case containerTerminated:
+		status.Conditions = pod.stampConditions(append(notReadyConditions(), pod.admittedCondition()...))
+		if pod.termination != nil {
+			status.ContainerStatuses[0].State.Terminated = pod.termination.DeepCopy()
+		} else {
+			status.ContainerStatuses[0].State.Terminated = &corev1.ContainerStateTerminated{
+				Reason:    pod.reason,
+				Message:   pod.message,
+				StartedAt: pod.startedAt,
 			}
 		}
 	}
+	if pod.lastTermination != nil {
+		status.ContainerStatuses[0].LastTerminationState.Terminated = pod.lastTermination.DeepCopy()
+	}
+	if terminated := status.ContainerStatuses[0].State.Terminated; terminated != nil && terminated.ContainerID == "" {
+		terminated.ContainerID = status.ContainerStatuses[0].ContainerID
+	}
+	status.EphemeralContainerStatuses = pod.ephemeralContainerStatuses()
 
 	return status
 }
 
-// buildEnclaveNameTag returns the enclave name tag for this pod.
-func (pod *Pod) buildEnclaveNameTag() string {
-	return buildEnclaveNameTag(pod.namespace, pod.name)
+// notReadyConditions returns the conditions of a pod whose enclave is not running.
+func notReadyConditions() []corev1.PodCondition {
+	return []corev1.PodCondition{
+		corev1.PodCondition{Type: corev1.PodInitialized, Status: corev1.ConditionTrue},
+		corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionFalse},
+		corev1.PodCondition{Type: corev1.ContainersReady, Status: corev1.ConditionFalse},
+	}
+}
+
+// conditionTransition records when a pod condition last changed status.
+type conditionTransition struct {
+	status corev1.ConditionStatus
+	time   metav1.Time
+}
+
+// stampConditions sets the time the conditions last changed status, which
+// is now for conditions reported for the first time or with a new status.
+func (pod *Pod) stampConditions(conditions []corev1.PodCondition) []corev1.PodCondition {
+	pod.transitionsMu.Lock()
+	defer pod.transitionsMu.Unlock()
+
+	if pod.transitions == nil {
+		pod.transitions = make(map[corev1.PodConditionType]conditionTransition)
+	}
+	now := metav1.Now()
+	for i := range conditions {
+		c := &conditions[i]
+		last, ok := pod.transitions[c.Type]
+		if !ok || last.status != c.Status {
+			last = conditionTransition{status: c.Status, time: now}
+			pod.transitions[c.Type] = last
+		}
+		c.LastTransitionTime = last.time
+	}
+	return conditions
 }
 
-// buildEnclaveNameTag builds an enclave name tag from its components.
-func buildEnclaveNameTag(namespace string, name string) string {
-	// namespace_podname
-	return fmt.Sprintf("%s_%s_%s", enclaveNamePrefix, namespace, name)
+// containerStatus returns the identity of the pod's container, its enclave,
+// without its state. The caller holds pod.mu.
+func (pod *Pod) containerStatus() corev1.ContainerStatus {
+	status := corev1.ContainerStatus{
+		RestartCount: pod.restarts,
+		ImageID:      pod.imageID,
+	}
+	if pod.pod != nil && len(pod.pod.Spec.Containers) > 0 {
+		status.Name = pod.pod.Spec.Containers[0].Name
+		status.Image = pod.pod.Spec.Containers[0].Image
+	} else {
+		for _, c := range pod.containers {
+			status.Name = c.definition.Name
+			status.Image = c.definition.Image
+		}
+	}
+	if pod.info.EnclaveID != "" && pod.state != containerWaiting {
+		status.ContainerID = containerIDPrefix + pod.info.EnclaveID
+	}
+	return status
+}
+
+// buildEnclaveNameTag returns the enclave name tag for this pod. Pods adopted
+// from existing enclaves keep the tag they were launched with, which may be in
+// the legacy format.
+func (pod *Pod) buildEnclaveNameTag() string {
+	if pod.tag != "" {
+		return pod.tag
+	}
+	return encodeTag(pod.namespace, pod.name, pod.uid)
 }
@@ -0,0 +1,112 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podReasonHostPortConflict tells a running pod is not ready as some of its host ports could not be bound.
+const podReasonHostPortConflict = "HostPortConflict"
+
+// hostPortClaim is a host port a pod proxies to its enclave.
+type hostPortClaim struct {
+	address  string
+	port     int32
+	protocol corev1.Protocol
+	// hostnames are the server names the port is shared by, see SNIHostnamesAnnotation.
+	hostnames []string
+}
+
+func (c hostPortClaim) String() string {
+	address := c.address
+	if address == "" {
+		address = "*"
+	}
+	s := fmt.Sprintf("%s/%s", net.JoinHostPort(address, fmt.Sprint(c.port)), c.protocol)
+	if len(c.hostnames) > 0 {
+		s += " for " + strings.Join(c.hostnames, ",")
+	}
+	return s
+}
+
+// overlaps tells whether two claims cannot be bound at once, as they have the
+// same port and protocol and one of them binds every address. Ports shared
+// by server name overlap when they share a server name.
+func (c hostPortClaim) overlaps(other hostPortClaim) bool {
+	if c.port != other.port || c.protocol != other.protocol {
+		return false
+	}
+	if c.address != other.address {
+		return isUnspecified(c.address) || isUnspecified(other.address)
+	}
+	if len(c.hostnames) == 0 || len(other.hostnames) == 0 {
+		return true
+	}
+	for _, hostname := range c.hostnames {
+		for _, otherHostname := range other.hostnames {
+			if hostname == otherHostname {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isUnspecified tells whether a bind address stands for every address.
+func isUnspecified(address string) bool {
+	return address == "" || net.ParseIP(address).IsUnspecified()
+}
+
+// hostPorts returns the host ports the pod claims.
+func (pod *Pod) hostPorts() []hostPortClaim {
+	addresses, err := pod.bindAddresses()
+	if err != nil {
+		// Unresolved addresses conflict with any.
+		addresses = []string{""}
+	}
+	hostnames := pod.sniHostnames()
+	var claims []hostPortClaim
+	for _, mapping := range pod.ports {
+		if mapping.hostPort == 0 {
+			continue
+		}
+		protocol := mapping.protocol
+		if protocol == "" {
+			protocol = corev1.ProtocolTCP
+		}
+		claim := hostPortClaim{port: mapping.hostPort, protocol: protocol}
+		if protocol == corev1.ProtocolTCP {
+			claim.hostnames = hostnames
+		}
+		for _, address := range addresses {
+			claim.address = address
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
+// hostPortConflict returns the error rejecting a pod claiming a host port another pod claims.
+func hostPortConflict(pod, other *Pod) error {
+	claims := other.hostPorts()
+	for _, claim := range pod.hostPorts() {
+		for _, otherClaim := range claims {
+			if claim.overlaps(otherClaim) {
+				return fmt.Errorf("host port %s is already claimed by pod %s/%s as %s", claim, other.namespace, other.name, otherClaim)
+			}
+		}
+	}
+	return nil
+}
+
+// portConflictMessage describes the host ports the pod's port proxies failed to bind, if any.
+// The caller holds pod.mu.
+func (pod *Pod) portConflictMessage() string {
+	if len(pod.portConflicts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("failed to bind host ports: %s", strings.Join(pod.portConflicts, "; "))
+}
@@ -0,0 +1,66 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+func TestHostPortConflicts(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) {
+		return nil, errors.New("no enclave driver")
+	}
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), ip: "10.0.0.1"}
+	newPod := func(name string, uid k8sTypes.UID, bindAddress string, ports ...corev1.ContainerPort) (*Pod, error) {
+		annotations := map[string]string{}
+		if bindAddress != "" {
+			annotations[BindAddressAnnotation] = bindAddress
+		}
+		return NewPod(context.Background(), n, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: uid, Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: name, Ports: ports}}},
+		})
+	}
+
+	web, err := newPod("web", "1", BindLocalhost, corev1.ContainerPort{ContainerPort: 80, HostPort: 8080})
+	assert.Nil(t, err)
+	_, err = newPod("other", "2", "", corev1.ContainerPort{ContainerPort: 80, HostPort: 8080})
+	assert.EqualError(t, err, "host port *:8080/TCP is already claimed by pod default/web as 127.0.0.1:8080/TCP")
+
+	// Ports differing by address or protocol do not conflict, nor ports without a host port.
+	_, err = newPod("internal", "3", BindInternalIP, corev1.ContainerPort{ContainerPort: 80, HostPort: 8080})
+	assert.Nil(t, err)
+	_, err = newPod("dns", "4", BindLocalhost, corev1.ContainerPort{ContainerPort: 53, HostPort: 8080, Protocol: corev1.ProtocolUDP})
+	assert.Nil(t, err)
+	_, err = newPod("unexposed", "5", "", corev1.ContainerPort{ContainerPort: 80})
+	assert.Nil(t, err)
+
+	// Finished pods release their ports, and rebuilt pods replace their previous incarnation.
+	_, err = newPod("web", "1", "", corev1.ContainerPort{ContainerPort: 80, HostPort: 9090})
+	assert.Nil(t, err)
+	web.phase = corev1.PodFailed
+	_, err = newPod("replacement", "6", BindLocalhost, corev1.ContainerPort{ContainerPort: 80, HostPort: 8080})
+	assert.Nil(t, err)
+}
+
+func TestPortConflictStatus(t *testing.T) {
+	pod := &Pod{namespace: "default", name: "web", phase: corev1.PodRunning, state: containerRunning, ready: true,
+		portConflicts: []string{"0.0.0.0:8080/TCP: address already in use"}}
+	status := pod.GetStatus()
+	assert.False(t, status.ContainerStatuses[0].Ready)
+	for _, condition := range status.Conditions {
+		if condition.Type == corev1.PodReady {
+			assert.Equal(t, corev1.ConditionFalse, condition.Status)
+			assert.Equal(t, podReasonHostPortConflict, condition.Reason)
+			assert.Equal(t, "failed to bind host ports: 0.0.0.0:8080/TCP: address already in use", condition.Message)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// NodeEnclaveCPUPressure is a node condition telling whether the enclave
+	// CPU pool is overcommitted. The hugepage pool is reported by MemoryPressure.
+	NodeEnclaveCPUPressure corev1.NodeConditionType = "EnclaveCPUPressure"
+
+	// pressureCheckInterval is how often the enclave pools are checked against the enclaves reserving them.
+	pressureCheckInterval = 30 * time.Second
+
+	podReasonEvicted = "Evicted"
+)
+
+// Pressure tells which enclave pools of the host are overcommitted, as when
+// the allocator configuration shrank under running enclaves.
+type Pressure struct {
+	// Memory tells the enclaves reserve more than the hugepage pool.
+	Memory bool
+	// CPUs tells the enclaves reserve more than the CPU pool.
+	CPUs bool
+}
+
+// Pressure returns the pressure on the enclave pools as of the last check.
+func (n *Node) Pressure() Pressure {
+	n.pressureMu.Lock()
+	defer n.pressureMu.Unlock()
+	return n.pressure
+}
+
+// NotifyPressure sets the function called whenever the pressure on the enclave pools changes.
+func (n *Node) NotifyPressure(notifier func(Pressure)) {
+	n.pressureMu.Lock()
+	defer n.pressureMu.Unlock()
+	n.pressureNotifier = notifier
+}
+
+func (n *Node) setPressure(pressure Pressure) {
+	n.pressureMu.Lock()
+	changed := n.pressure != pressure
+	n.pressure = pressure
+	notifier := n.pressureNotifier
+	n.pressureMu.Unlock()
+
+	if changed && notifier != nil {
+		notifier(pressure)
+	}
+}
+
+// MonitorPressure periodically checks the enclave pools of the host against
+// the enclaves reserving them until the context is done, evicting pods while
+// the pools are overcommitted. Overcommitted pools would otherwise make the
+// launches and restarts of arbitrary enclaves fail.
+func (n *Node) MonitorPressure(ctx context.Context) {
+	ticker := time.NewTicker(pressureCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := n.relievePressure(ctx); err != nil {
+			log.G(ctx).Debugf("failed to check enclave resource pressure: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relievePressure records the pressure on the enclave pools, and evicts the
+// pods of the lowest priority until the remaining enclaves fit in the pools.
+func (n *Node) relievePressure(ctx context.Context) error {
+	capacity, err := readCapacity()
+	if err != nil {
+		return err
+	}
+	pods, err := n.GetPods()
+	if err != nil {
+		return err
+	}
+
+	var memory, cpus int64
+	var candidates []*Pod
+	for _, pod := range pods {
+		r := pod.reservation()
+		if !r.active {
+			continue
+		}
+		memory += r.memoryMiB
+		cpus += r.cpus
+		candidates = append(candidates, pod)
+	}
+	n.setPressure(Pressure{Memory: memory > capacity.MemoryMib, CPUs: cpus > capacity.CPUs})
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return evictsBefore(candidates[i], candidates[j])
+	})
+	for _, pod := range candidates {
+		var message string
+		switch {
+		case memory > capacity.MemoryMib:
+			message = fmt.Sprintf("The node was low on resource: enclave memory. Enclaves reserve %d MiB of the %d MiB hugepage pool.", memory, capacity.MemoryMib)
+		case cpus > capacity.CPUs:
+			message = fmt.Sprintf("The node was low on resource: enclave CPUs. Enclaves reserve %d of the %d CPU pool.", cpus, capacity.CPUs)
+		default:
+			return nil
+		}
+
+		r := pod.reservation()
+		log.G(ctx).Infof("Evicting pod %s/%s: %s", pod.namespace, pod.name, message)
+		pod.evict(ctx, message)
+		memory -= r.memoryMiB
+		cpus -= r.cpus
+	}
+	return nil
+}
+
+// evictsBefore tells whether a pod is evicted before another: pods of lower
+// priority first, then the most recently started.
+func evictsBefore(a, b *Pod) bool {
+	if pa, pb := a.priority(), b.priority(); pa != pb {
+		return pa < pb
+	}
+	a.mu.RLock()
+	startedA := a.startedAt
+	a.mu.RUnlock()
+	b.mu.RLock()
+	startedB := b.startedAt
+	b.mu.RUnlock()
+	return startedB.Before(&startedA)
+}
+
+// priority returns the scheduling priority of the pod, 0 when it has none.
+func (pod *Pod) priority() int32 {
+	pod.mu.RLock()
+	defer pod.mu.RUnlock()
+	if pod.pod == nil || pod.pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.pod.Spec.Priority
+}
+
+// evict terminates the enclave of the pod for good and fails the pod, so
+// its controller recreates it on another node.
+func (pod *Pod) evict(ctx context.Context, message string) {
+	pod.warning(eventReasonEvicted, "%s", message)
+	pod.shutdown(ctx, message)
+	if err := pod.removeState(); err != nil {
+		log.G(ctx).Errorf("Failed to remove pod state: %v.\n", err)
+	}
+
+	pod.mu.Lock()
+	pod.termination = &corev1.ContainerStateTerminated{
+		ExitCode:   exitCodeUnknown,
+		Reason:     containerReasonError,
+		Message:    message,
+		StartedAt:  pod.startedAt,
+		FinishedAt: metav1.Now(),
+	}
+	pod.mu.Unlock()
+	pod.setPhase(ctx, corev1.PodFailed, podReasonEvicted, message)
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRelievePressure(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	capacity := &allocator.Capacity{CPUs: 8, MemoryMib: 4096}
+	readCapacity = func() (*allocator.Capacity, error) { return capacity, nil }
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+	var notified []Pressure
+	n.NotifyPressure(func(p Pressure) { notified = append(notified, p) })
+
+	add := func(name string, priority int32, memory int64) *Pod {
+		pod := &Pod{
+			namespace: "default",
+			name:      name,
+			node:      n,
+			pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+				Spec:       corev1.PodSpec{Priority: &priority},
+			},
+			config: cli.EnclaveConfig{MemoryMib: memory, CPUCount: 2},
+			phase:  corev1.PodRunning,
+			state:  containerRunning,
+		}
+		n.InsertPod(pod, name)
+		return pod
+	}
+	critical := add("critical", 1000, 2048)
+	low := add("low", -10, 1024)
+	normal := add("normal", 0, 1024)
+
+	assert.Nil(t, n.relievePressure(context.Background()))
+	assert.Equal(t, Pressure{}, n.Pressure())
+	assert.Empty(t, notified)
+
+	// The allocator configuration shrank under the enclaves.
+	capacity = &allocator.Capacity{CPUs: 8, MemoryMib: 3072}
+	assert.Nil(t, n.relievePressure(context.Background()))
+	assert.Equal(t, []Pressure{{Memory: true}}, notified)
+
+	assert.Equal(t, corev1.PodFailed, low.phase)
+	assert.Equal(t, podReasonEvicted, low.reason)
+	assert.Equal(t, corev1.PodRunning, normal.phase)
+	assert.Equal(t, corev1.PodRunning, critical.phase)
+
+	// Once relieved, the pressure is cleared.
+	assert.Nil(t, n.relievePressure(context.Background()))
+	assert.Equal(t, Pressure{}, n.Pressure())
+	assert.Equal(t, corev1.PodRunning, normal.phase)
+}
@@ -0,0 +1,314 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// Probe kinds, as named in the kubelet's messages.
+	probeStartup   = "Startup"
+	probeReadiness = "Readiness"
+	probeLiveness  = "Liveness"
+
+	// Probe defaults, matching the API server's.
+	defaultProbePeriod           = 10 * time.Second
+	defaultProbeTimeout          = 1 * time.Second
+	defaultProbeFailureThreshold = 3
+)
+
+// prober runs the probes of a container against its enclave.
+type prober struct {
+	container *corev1.Container
+	dial      func(port uint32) (net.Conn, error)
+}
+
+// newProber creates a prober reaching the enclave's ports over vsock.
+func newProber(info cli.EnclaveInfo, container *corev1.Container) *prober {
+	cid := uint32(info.EnclaveCID)
+	return &prober{
+		container: container,
+		dial: func(port uint32) (net.Conn, error) {
+			return transport.Dial(cid, port)
+		},
+	}
+}
+
+// run executes a probe once, returning whether it succeeded and why it failed.
+func (p *prober) run(ctx context.Context, probe *corev1.Probe) (bool, string) {
+	timeout := time.Duration(probe.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var err error
+	switch {
+	case probe.HTTPGet != nil:
+		err = p.httpGet(ctx, probe.HTTPGet)
+	case probe.TCPSocket != nil:
+		err = p.tcpSocket(probe.TCPSocket)
+	case probe.Exec != nil:
+		err = p.exec(probe.Exec, timeout)
+	default:
+		err = fmt.Errorf("unsupported probe handler")
+	}
+	if err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+func (p *prober) httpGet(ctx context.Context, action *corev1.HTTPGetAction) error {
+	port, err := p.resolvePort(action.Port)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if action.Scheme == corev1.URISchemeHTTPS {
+		scheme = "https"
+	}
+	host := action.Host
+	if host == "" {
+		host = "localhost"
+	}
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(int(port))),
+		Path:   action.Path,
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	for _, header := range action.HTTPHeaders {
+		if header.Name == "Host" {
+			req.Host = header.Value
+			continue
+		}
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return p.dial(port)
+			},
+			// Like the kubelet, HTTPS probes do not verify certificates.
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("HTTP probe failed with statuscode: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *prober) tcpSocket(action *corev1.TCPSocketAction) error {
+	port, err := p.resolvePort(action.Port)
+	if err != nil {
+		return err
+	}
+	conn, err := p.dial(port)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *prober) exec(action *corev1.ExecAction, timeout time.Duration) error {
+	conn, err := p.dial(agent.ExecPort)
+	if err != nil {
+		return fmt.Errorf("failed to reach agent: %v", err)
+	}
+	defer conn.Close()
+
+	result, err := agent.Exec(conn, agent.ExecRequest{Command: action.Command, Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("command exited with %d: %s", result.ExitCode, result.Output)
+	}
+	return nil
+}
+
+// resolvePort returns the port number of a probe, looking up named ports in the container spec.
+func (p *prober) resolvePort(port intstr.IntOrString) (uint32, error) {
+	if port.Type == intstr.Int {
+		if port.IntVal <= 0 || port.IntVal > 65535 {
+			return 0, fmt.Errorf("invalid port number: %d", port.IntVal)
+		}
+		return uint32(port.IntVal), nil
+	}
+	for _, p := range p.container.Ports {
+		if p.Name == port.StrVal {
+			return uint32(p.ContainerPort), nil
+		}
+	}
+	return 0, fmt.Errorf("couldn't find port %q in container", port.StrVal)
+}
+
+// startProbes runs the container's probes against the launched enclave, until
+// the returned function is called. Readiness follows the readiness probe, and
+// the enclave is terminated when the startup or liveness probe fails.
+func (pod *Pod) startProbes(ctx context.Context, info cli.EnclaveInfo) func() {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil || len(spec.Spec.Containers) == 0 {
+		return func() {}
+	}
+	container := &spec.Spec.Containers[0]
+	p := newProber(info, container)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	worker := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	// Readiness and liveness are only probed once the container has started.
+	started := make(chan struct{})
+	if probe := container.StartupProbe; probe != nil {
+		worker(func() {
+			pod.probe(ctx, p, probeStartup, probe, func(ok bool, message string) bool {
+				if ok {
+					close(started)
+				} else {
+					pod.kill(ctx, fmt.Sprintf("%s probe failed: %s", probeStartup, message))
+				}
+				return true
+			})
+		})
+	} else {
+		close(started)
+	}
+
+	waitStarted := func() bool {
+		select {
+		case <-started:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if probe := container.ReadinessProbe; probe != nil {
+		worker(func() {
+			if !waitStarted() {
+				return
+			}
+			pod.probe(ctx, p, probeReadiness, probe, func(ok bool, message string) bool {
+				pod.setReady(ctx, ok)
+				return false
+			})
+		})
+	}
+	if probe := container.LivenessProbe; probe != nil {
+		worker(func() {
+			if !waitStarted() {
+				return
+			}
+			pod.probe(ctx, p, probeLiveness, probe, func(ok bool, message string) bool {
+				if !ok {
+					pod.kill(ctx, fmt.Sprintf("%s probe failed: %s", probeLiveness, message))
+				}
+				return !ok
+			})
+		})
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// probe runs a probe periodically until the context is done. Every time the
+// probe reaches its success or failure threshold the result is passed to
+// handle, which returns whether to stop probing.
+func (pod *Pod) probe(ctx context.Context, p *prober, kind string, probe *corev1.Probe, handle func(ok bool, message string) bool) {
+	period := time.Duration(probe.PeriodSeconds) * time.Second
+	if period <= 0 {
+		period = defaultProbePeriod
+	}
+	successThreshold := int(probe.SuccessThreshold)
+	if successThreshold <= 0 {
+		successThreshold = 1
+	}
+	failureThreshold := int(probe.FailureThreshold)
+	if failureThreshold <= 0 {
+		failureThreshold = defaultProbeFailureThreshold
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(probe.InitialDelaySeconds) * time.Second):
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	var successes, failures int
+	for {
+		ok, message := p.run(ctx, probe)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var stop bool
+		if ok {
+			successes, failures = successes+1, 0
+			if successes == successThreshold {
+				stop = handle(true, "")
+			}
+		} else {
+			successes, failures = 0, failures+1
+			log.G(ctx).Infof("%s probe failed for %s/%s: %s", kind, pod.namespace, pod.name, message)
+			if failures == failureThreshold {
+				stop = handle(false, message)
+			}
+		}
+		if stop {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
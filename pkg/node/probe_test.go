@@ -0,0 +1,66 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestProberRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	_, serverPort, _ := net.SplitHostPort(server.Listener.Addr().String())
+	port, _ := strconv.Atoi(serverPort)
+
+	exec, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer exec.Close()
+	go agent.ExecServer{}.Serve(exec) //nolint:errcheck
+
+	// Enclave ports are reached over TCP instead of vsock.
+	p := &prober{
+		container: &corev1.Container{
+			Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: int32(port)}},
+		},
+		dial: func(port uint32) (net.Conn, error) {
+			if port == agent.ExecPort {
+				return net.Dial("tcp", exec.Addr().String())
+			}
+			return net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		},
+	}
+
+	tests := []struct {
+		name    string
+		handler corev1.ProbeHandler
+		ok      bool
+	}{
+		{"http", corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(port)}}, true},
+		{"http named port", corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("http")}}, true},
+		{"http error", corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt(port)}}, false},
+		{"http unknown port", corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromString("metrics")}}, false},
+		{"tcp", corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(port)}}, true},
+		{"exec", corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}}, true},
+		{"exec failure", corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"false"}}}, false},
+		{"no handler", corev1.ProbeHandler{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, message := p.run(context.Background(), &corev1.Probe{ProbeHandler: test.handler, TimeoutSeconds: 5})
+			assert.Equal(t, test.ok, ok, message)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// ProfileAnnotation selects one of the profiles of the node, which sizes
+	// the enclave of the pod instead of the resources of its containers.
+	ProfileAnnotation = annotationPrefix + "profile"
+
+	// Debug mode policies of profiles, see Profile.DebugMode.
+	ProfileDebugModeAllow  = "allow"
+	ProfileDebugModeDeny   = "deny"
+	ProfileDebugModeAlways = "always"
+)
+
+// Profile is a size class of the enclaves of the node, such as small or
+// large, which pods select with the ProfileAnnotation rather than computing
+// the CPUs and memory enclaves can have.
+type Profile struct {
+	// CPUs is the number of vCPUs of the enclaves, even when SMT is active.
+	CPUs int64 `json:"cpus"`
+	// Memory is the memory of the enclaves, rounded up to the MiB, which
+	// their emptyDir volumes are part of.
+	Memory resource.Quantity `json:"memory"`
+	// DebugMode is the debug mode policy of the enclaves: "allow" (the
+	// default) lets pods request debug mode when the node allows it, "deny"
+	// forbids it, and "always" runs the enclaves in debug mode.
+	DebugMode string `json:"debugMode,omitempty"`
+	// Kernel is the directory of the kernel and init blobs the images of the
+	// enclaves are built with, that of the node when empty.
+	Kernel string `json:"kernel,omitempty"`
+}
+
+// MemoryMiB returns the memory of the enclaves of the profile in MiB.
+func (p *Profile) MemoryMiB() int64 {
+	return (p.Memory.Value() + MiB - 1) / MiB
+}
+
+// Validate tells whether enclaves can have the resources of the profile.
+func (p *Profile) Validate() error {
+	if p.CPUs <= 0 {
+		return fmt.Errorf("cpus must be positive")
+	}
+	if smtActive && p.CPUs%2 != 0 {
+		return fmt.Errorf("cpus must be even, SMT is active")
+	}
+	if p.MemoryMiB() <= 0 {
+		return fmt.Errorf("memory must be positive")
+	}
+	switch p.DebugMode {
+	case "", ProfileDebugModeAllow, ProfileDebugModeDeny, ProfileDebugModeAlways:
+	default:
+		return fmt.Errorf("invalid debug mode %q, expected %q, %q or %q", p.DebugMode, ProfileDebugModeAllow, ProfileDebugModeDeny, ProfileDebugModeAlways)
+	}
+	return nil
+}
+
+// podProfile returns the profile of the node a pod selects, nil if it selects none.
+func podProfile(node *Node, pod *corev1.Pod) (*Profile, error) {
+	name, ok := pod.Annotations[ProfileAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	if node != nil {
+		node.RLock()
+		defer node.RUnlock()
+		if profile, ok := node.profiles[name]; ok {
+			return &profile, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid %s annotation: the node has no profile %q", ProfileAnnotation, name)
+}
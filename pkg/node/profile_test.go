@@ -0,0 +1,64 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProfile(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) { return nil, errors.New("no allocator") }
+	defer func(f func() ([]cli.EnclaveInfo, error)) { describeEnclaves = f }(describeEnclaves)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) { return nil, errors.New("no nitro-cli") }
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), allowDebugMode: true, profiles: map[string]Profile{
+		"small": {CPUs: 2, Memory: resource.MustParse("1Gi"), DebugMode: ProfileDebugModeDeny},
+		"dev":   {CPUs: 4, Memory: resource.MustParse("3000M"), DebugMode: ProfileDebugModeAlways, Kernel: "/opt/blobs"},
+	}}
+	newPod := func(name string, annotations map[string]string) (*Pod, error) {
+		return NewPod(context.Background(), n, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: "1", Annotations: annotations},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  name,
+				Image: name,
+				Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("8"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				}},
+			}}},
+		})
+	}
+
+	// The profile sizes the enclave rather than the resources of the container.
+	pod, err := newPod("small", map[string]string{ProfileAnnotation: "small"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), pod.config.CPUCount)
+	assert.Equal(t, int64(1024), pod.config.MemoryMib)
+	assert.False(t, pod.config.DebugMode)
+	_, err = newPod("small", map[string]string{ProfileAnnotation: "small", DebugModeAnnotation: "true"})
+	assert.EqualError(t, err, `debug mode is not allowed by profile "small"`)
+
+	pod, err = newPod("dev", map[string]string{ProfileAnnotation: "dev"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2862), pod.config.MemoryMib)
+	assert.True(t, pod.config.DebugMode)
+	assert.Equal(t, "/opt/blobs", pod.kernel)
+
+	_, err = newPod("large", map[string]string{ProfileAnnotation: "large"})
+	var rejection *AdmissionError
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonInvalidAnnotation, rejection.Reason)
+		assert.True(t, rejection.Terminal)
+	}
+
+	assert.NotNil(t, (&Profile{CPUs: 2}).Validate())
+	assert.NotNil(t, (&Profile{CPUs: 2, Memory: resource.MustParse("1Gi"), DebugMode: "sometimes"}).Validate())
+}
@@ -0,0 +1,263 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// How long the agent waits for the projected volumes before starting the workload.
+	projectedVolumeBootTimeout = 30 * time.Second
+	// How often to retry pushing projected volumes to an agent which is not reachable yet.
+	projectedVolumeRetry = time.Second
+	// How long a push to the agent may take.
+	projectedVolumeTimeout = 5 * time.Second
+	// Default lifetime of service account tokens, matching the API server's.
+	defaultTokenExpiration int64 = 60 * 60
+)
+
+// projectedMount is a projected volume mounted in the pod's container.
+type projectedMount struct {
+	mountPath string
+	volume    *corev1.ProjectedVolumeSource
+}
+
+// projectedMounts returns the projected volumes mounted in the pod's container.
+// They include the service account token volume added by the API server.
+func (pod *Pod) projectedMounts() []projectedMount {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil || len(spec.Spec.Containers) == 0 {
+		return nil
+	}
+
+	volumes := make(map[string]*corev1.ProjectedVolumeSource)
+	for i := range spec.Spec.Volumes {
+		if v := &spec.Spec.Volumes[i]; v.Projected != nil {
+			volumes[v.Name] = v.Projected
+		}
+	}
+
+	var mounts []projectedMount
+	for _, m := range spec.Spec.Containers[0].VolumeMounts {
+		if v, ok := volumes[m.Name]; ok {
+			mounts = append(mounts, projectedMount{mountPath: path.Clean(m.MountPath), volume: v})
+		}
+	}
+	return mounts
+}
+
+// projectedFiles renders the files of the pod's projected volumes. refresh is
+// when they must be rendered again for their tokens to remain valid, or zero
+// if they contain no token.
+func (pod *Pod) projectedFiles(ctx context.Context) (files []agent.File, refresh time.Duration, err error) {
+	for _, m := range pod.projectedMounts() {
+		mode := os.FileMode(0644)
+		if m.volume.DefaultMode != nil {
+			mode = os.FileMode(*m.volume.DefaultMode)
+		}
+		add := func(p string, data []byte, itemMode *int32) {
+			f := agent.File{Path: path.Join(m.mountPath, p), Data: data, Mode: mode}
+			if itemMode != nil {
+				f.Mode = os.FileMode(*itemMode)
+			}
+			files = append(files, f)
+		}
+
+		for _, source := range m.volume.Sources {
+			switch {
+			case source.ServiceAccountToken != nil:
+				token, expiration, err := pod.requestToken(ctx, source.ServiceAccountToken)
+				if err != nil {
+					return nil, 0, err
+				}
+				add(source.ServiceAccountToken.Path, []byte(token), nil)
+
+				// Refresh tokens once 80% of their lifetime has elapsed, as the kubelet does.
+				ttl := time.Until(expiration) * 8 / 10
+				if refresh == 0 || ttl < refresh {
+					refresh = ttl
+				}
+			case source.ConfigMap != nil:
+				cm, err := getConfigMap(pod.resources(), source.ConfigMap.Name, pod.namespace, source.ConfigMap.Optional)
+				if err != nil {
+					return nil, 0, err
+				}
+				if cm == nil {
+					continue
+				}
+				data := make(map[string][]byte, len(cm.Data))
+				for key, value := range cm.Data {
+					data[key] = []byte(value)
+				}
+				if err := projectItems(data, source.ConfigMap.Items, source.ConfigMap.Optional, add); err != nil {
+					return nil, 0, fmt.Errorf("configmap %s/%s: %w", pod.namespace, source.ConfigMap.Name, err)
+				}
+			case source.Secret != nil:
+				secret, err := getSecret(pod.resources(), source.Secret.Name, pod.namespace, source.Secret.Optional)
+				if err != nil {
+					return nil, 0, err
+				}
+				if secret == nil {
+					continue
+				}
+				if err := projectItems(secret.Data, source.Secret.Items, source.Secret.Optional, add); err != nil {
+					return nil, 0, fmt.Errorf("secret %s/%s: %w", pod.namespace, source.Secret.Name, err)
+				}
+			case source.DownwardAPI != nil:
+				for _, item := range source.DownwardAPI.Items {
+					if item.FieldRef == nil {
+						return nil, 0, fmt.Errorf("unsupported downward API item %q", item.Path)
+					}
+					value, err := pod.fieldValue(item.FieldRef.FieldPath)
+					if err != nil {
+						return nil, 0, err
+					}
+					add(item.Path, []byte(value), item.Mode)
+				}
+			}
+		}
+	}
+	return files, refresh, nil
+}
+
+// projectItems adds the selected keys of a ConfigMap or Secret, or all of them if none are selected.
+func projectItems(data map[string][]byte, items []corev1.KeyToPath, optional *bool, add func(string, []byte, *int32)) error {
+	if len(items) == 0 {
+		for key, value := range data {
+			add(key, value, nil)
+		}
+		return nil
+	}
+	for _, item := range items {
+		value, ok := data[item.Key]
+		if !ok {
+			if isOptional(optional) {
+				continue
+			}
+			return fmt.Errorf("couldn't find key %q", item.Key)
+		}
+		add(item.Path, value, item.Mode)
+	}
+	return nil
+}
+
+// fieldValue returns the value of a pod field exposed through the downward API.
+func (pod *Pod) fieldValue(fieldPath string) (string, error) {
+	switch fieldPath {
+	case "metadata.name":
+		return pod.name, nil
+	case "metadata.namespace":
+		return pod.namespace, nil
+	case "metadata.uid":
+		return string(pod.uid), nil
+	default:
+		return "", fmt.Errorf("unsupported downward API field %q", fieldPath)
+	}
+}
+
+// requestToken requests a token of the pod's service account, bound to the pod.
+func (pod *Pod) requestToken(ctx context.Context, source *corev1.ServiceAccountTokenProjection) (string, time.Time, error) {
+	if pod.node == nil || pod.node.client == nil {
+		return "", time.Time{}, fmt.Errorf("cannot request service account token: no client configured")
+	}
+
+	pod.mu.RLock()
+	serviceAccount := pod.pod.Spec.ServiceAccountName
+	pod.mu.RUnlock()
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	expiration := defaultTokenExpiration
+	if source.ExpirationSeconds != nil {
+		expiration = *source.ExpirationSeconds
+	}
+	var audiences []string
+	if source.Audience != "" {
+		audiences = []string{source.Audience}
+	}
+
+	request := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expiration,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       pod.name,
+				UID:        pod.uid,
+			},
+		},
+	}
+	response, err := pod.node.client.CoreV1().ServiceAccounts(pod.namespace).CreateToken(ctx, serviceAccount, request, metav1.CreateOptions{})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request token of service account %s/%s: %w", pod.namespace, serviceAccount, err)
+	}
+	return response.Status.Token, response.Status.ExpirationTimestamp.Time, nil
+}
+
+// resources returns the getter of the objects referenced by the pod.
+func (pod *Pod) resources() ResourceGetter {
+	if pod.node == nil {
+		return nil
+	}
+	return pod.node.resources
+}
+
+// updateProjectedVolumes pushes the pod's projected volumes to the enclave's
+// agent, and refreshes them before their tokens expire, until ctx is done.
+func (pod *Pod) updateProjectedVolumes(ctx context.Context, cid uint32) error {
+	for {
+		files, refresh, err := pod.projectedFiles(ctx)
+		if err != nil {
+			log.G(ctx).Errorf("failed to render projected volumes: %v", err)
+			refresh = projectedVolumeRetry
+		} else {
+			if err := pushFiles(ctx, cid, files); err != nil {
+				return nil
+			}
+			log.G(ctx).Debugf("pushed %d projected files to enclave %d", len(files), cid)
+			if refresh == 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(refresh):
+		}
+	}
+}
+
+// pushFiles sends files to the agent, retrying while the enclave boots. It
+// only fails once ctx is done.
+func pushFiles(ctx context.Context, cid uint32, files []agent.File) error {
+	for {
+		conn, err := dialAgent(int(cid), agent.FilesPort)
+		if err == nil {
+			err = agent.PushFiles(conn, files, projectedVolumeTimeout)
+			conn.Close()
+		}
+		if err == nil {
+			return nil
+		}
+		log.G(ctx).Debugf("failed to push projected volumes to enclave %d: %v", cid, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(projectedVolumeRetry):
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestProjectedFiles(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var requested *authenticationv1.TokenRequest
+	client.PrependReactor("create", "serviceaccounts", func(action ktesting.Action) (bool, runtime.Object, error) {
+		requested = action.(ktesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		response := requested.DeepCopy()
+		response.Status = authenticationv1.TokenRequestStatus{
+			Token:               "token",
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+		}
+		return true, response, nil
+	})
+
+	expiration := int64(3607)
+	pod := &Pod{
+		namespace: "default",
+		name:      "nginx",
+		uid:       "1234",
+		node: &Node{
+			client: client,
+			resources: fakeResources{configMaps: map[string]*corev1.ConfigMap{
+				"default/kube-root-ca.crt": {Data: map[string]string{"ca.crt": "ca"}},
+			}},
+		},
+		pod: &corev1.Pod{Spec: corev1.PodSpec{
+			ServiceAccountName: "web",
+			Volumes: []corev1.Volume{{
+				Name: "kube-api-access",
+				VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+					Sources: []corev1.VolumeProjection{
+						{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token", ExpirationSeconds: &expiration}},
+						{ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+							Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+						}},
+						{DownwardAPI: &corev1.DownwardAPIProjection{Items: []corev1.DownwardAPIVolumeFile{
+							{Path: "namespace", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+						}}},
+					},
+				}},
+			}},
+			Containers: []corev1.Container{{
+				Name: "nginx",
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "kube-api-access", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"},
+				},
+			}},
+		}},
+	}
+
+	files, refresh, err := pod.projectedFiles(context.Background())
+	assert.Nil(t, err)
+	contents := make(map[string]string)
+	for _, f := range files {
+		contents[f.Path] = string(f.Data)
+	}
+	assert.Equal(t, map[string]string{
+		"/var/run/secrets/kubernetes.io/serviceaccount/token":     "token",
+		"/var/run/secrets/kubernetes.io/serviceaccount/ca.crt":    "ca",
+		"/var/run/secrets/kubernetes.io/serviceaccount/namespace": "default",
+	}, contents)
+
+	// The token is bound to the pod and refreshed before it expires.
+	assert.Equal(t, expiration, *requested.Spec.ExpirationSeconds)
+	assert.Equal(t, "nginx", requested.Spec.BoundObjectRef.Name)
+	assert.True(t, refresh > 45*time.Minute && refresh < time.Hour)
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// MaxConnectionsAnnotation bounds how many connections the port proxies
+	// of a pod forward at once, all ports together.
+	MaxConnectionsAnnotation = annotationPrefix + "max-connections"
+	// ConnectionIdleTimeoutAnnotation is how long the port proxies of a pod
+	// keep connections without traffic open, as a duration such as "5m".
+	ConnectionIdleTimeoutAnnotation = annotationPrefix + "connection-idle-timeout"
+	// ConnectionDrainTimeoutAnnotation is how long the connections forwarded by
+	// the port proxies of a pod may last once the pod stops, before its
+	// enclave is terminated.
+	ConnectionDrainTimeoutAnnotation = annotationPrefix + "connection-drain-timeout"
+)
+
+// proxyLimits returns the limits of the port proxies of a pod, parsed from its annotations.
+func proxyLimits(pod *corev1.Pod) (nitro.ProxyLimits, error) {
+	var limits nitro.ProxyLimits
+	if value, ok := pod.Annotations[MaxConnectionsAnnotation]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("invalid %s annotation %q, expected a positive number", MaxConnectionsAnnotation, value)
+		}
+		limits.Conns = nitro.NewConnLimit(n)
+	}
+
+	var err error
+	if limits.IdleTimeout, err = durationAnnotation(pod, ConnectionIdleTimeoutAnnotation); err != nil {
+		return limits, err
+	}
+	if limits.DrainTimeout, err = durationAnnotation(pod, ConnectionDrainTimeoutAnnotation); err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// durationAnnotation parses an annotation of a pod as a positive duration, zero when missing.
+func durationAnnotation(pod *corev1.Pod, annotation string) (time.Duration, error) {
+	value, ok := pod.Annotations[annotation]
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s annotation %q, expected a positive duration", annotation, value)
+	}
+	return d, nil
+}
+
+// proxyLimits returns the limits of the pod's port proxies, shared by all its ports.
+func (pod *Pod) proxyLimits() nitro.ProxyLimits {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nitro.ProxyLimits{}
+	}
+	// The annotations were validated when the pod was created.
+	limits, _ := proxyLimits(spec)
+	return limits
+}
+
+// drainProxies stops the port proxies of the pod from accepting connections,
+// and waits for the connections they forward to be drained.
+func (pod *Pod) drainProxies() {
+	pod.mu.Lock()
+	proxies := pod.proxies
+	pod.proxies = nil
+	pod.mu.Unlock()
+
+	for _, listener := range proxies {
+		listener.Close()
+	}
+	pod.proxyServers.Wait()
+}
@@ -0,0 +1,37 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProxyLimits(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+
+	limits, err := proxyLimits(pod)
+	assert.Nil(t, err)
+	assert.Nil(t, limits.Conns)
+	assert.Zero(t, limits.IdleTimeout)
+
+	pod.Annotations[MaxConnectionsAnnotation] = "100"
+	pod.Annotations[ConnectionIdleTimeoutAnnotation] = "5m"
+	pod.Annotations[ConnectionDrainTimeoutAnnotation] = "30s"
+	limits, err = proxyLimits(pod)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, cap(limits.Conns))
+	assert.Equal(t, 5*time.Minute, limits.IdleTimeout)
+	assert.Equal(t, 30*time.Second, limits.DrainTimeout)
+
+	pod.Annotations[MaxConnectionsAnnotation] = "0"
+	_, err = proxyLimits(pod)
+	assert.EqualError(t, err, `invalid nitro-enclave-kubelet.brave.com/max-connections annotation "0", expected a positive number`)
+
+	pod.Annotations[MaxConnectionsAnnotation] = "10"
+	pod.Annotations[ConnectionIdleTimeoutAnnotation] = "forever"
+	_, err = proxyLimits(pod)
+	assert.EqualError(t, err, `invalid nitro-enclave-kubelet.brave.com/connection-idle-timeout annotation "forever", expected a positive duration`)
+}
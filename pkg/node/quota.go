@@ -0,0 +1,91 @@
+package node
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// ResourceEnclaveCPUs is the extended resource of the vCPUs of enclaves.
+	// Pods requesting it get as many enclave vCPUs.
+	ResourceEnclaveCPUs corev1.ResourceName = "aws.ec2.nitro/enclave_cpus"
+
+	// AdmissionReasonUnaccounted rejects the pods whose enclave is not
+	// accounted by the enclave resources they request, which resource quotas
+	// of their namespace cap.
+	AdmissionReasonUnaccounted = "UnaccountedEnclaveResources"
+)
+
+// accountedResources returns the enclave vCPUs and memory a pod requests as
+// the extended resources ResourceEnclaveCPUs and ResourceEnclaveMemoryMiB,
+// zero for those it does not request. They size its whole enclave, emptyDir
+// volumes and runtime class overhead included.
+func accountedResources(pod *corev1.Pod) (cpus, memoryMiB int64) {
+	for i := range pod.Spec.Containers {
+		reqs := &pod.Spec.Containers[i].Resources
+		cpus += extendedResource(reqs, ResourceEnclaveCPUs)
+		memoryMiB += extendedResource(reqs, ResourceEnclaveMemoryMiB)
+	}
+	return cpus, memoryMiB
+}
+
+// extendedResource returns the quantity of an extended resource a container
+// requests, whose requests equal its limits, zero if it requests none.
+func extendedResource(reqs *corev1.ResourceRequirements, name corev1.ResourceName) int64 {
+	quantity, ok := reqs.Limits[name]
+	if !ok {
+		quantity = reqs.Requests[name]
+	}
+	return quantity.Value()
+}
+
+// EnclaveCPUs returns the vCPUs of the enclave running a pod: those it
+// requests as the ResourceEnclaveCPUs extended resource, otherwise those of
+// its containers and of the overhead of its runtime class.
+func EnclaveCPUs(pod *corev1.Pod) int64 {
+	if cpus, _ := accountedResources(pod); cpus > 0 {
+		return cpus
+	}
+	cpus, _ := podOverhead(pod)
+	for i := range pod.Spec.Containers {
+		cntr, err := newContainer(&pod.Spec.Containers[i])
+		if err != nil {
+			continue
+		}
+		cpus += cntr.definition.Cpu
+	}
+	return cpus
+}
+
+// EnclaveResources returns the enclave vCPUs and memory of a pod as the
+// extended resources accounting for them, so resource quotas cap them.
+func EnclaveResources(pod *corev1.Pod) corev1.ResourceList {
+	return corev1.ResourceList{
+		ResourceEnclaveCPUs:      *resource.NewQuantity(EnclaveCPUs(pod), resource.DecimalSI),
+		ResourceEnclaveMemoryMiB: *resource.NewQuantity(EnclaveMemoryMiB(pod), resource.DecimalSI),
+	}
+}
+
+// accountedError returns why a pod cannot run an enclave with the given
+// vCPUs and memory, nil if the enclave resources it requests account for
+// them. Pods must request them when required, profiles may size enclaves
+// above them otherwise.
+func accountedError(pod *corev1.Pod, cpus, memoryMiB int64, required bool) *AdmissionError {
+	accountedCPUs, accountedMemory := accountedResources(pod)
+	if required && (accountedCPUs == 0 || accountedMemory == 0) {
+		return unsupportedf(AdmissionReasonUnaccounted, "pods running in enclaves on this node must request the %s and %s resources",
+			ResourceEnclaveCPUs, ResourceEnclaveMemoryMiB)
+	}
+	if accountedCPUs > 0 && cpus > accountedCPUs || accountedMemory > 0 && memoryMiB > accountedMemory {
+		return unsupportedf(AdmissionReasonUnaccounted, "the enclave of %d vCPUs and %d MiB exceeds the %s and %s the pod requests",
+			cpus, memoryMiB, ResourceEnclaveCPUs, ResourceEnclaveMemoryMiB)
+	}
+	return nil
+}
+
+// enclaveResourcesRequired tells whether pods must request their enclave resources.
+func (n *Node) enclaveResourcesRequired() bool {
+	n.RLock()
+	defer n.RUnlock()
+	return n.requireEnclaveResources
+}
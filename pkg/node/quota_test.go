@@ -0,0 +1,81 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnclaveResources(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) { return nil, errors.New("no allocator") }
+	defer func(f func() ([]cli.EnclaveInfo, error)) { describeEnclaves = f }(describeEnclaves)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) { return nil, errors.New("no nitro-cli") }
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), profiles: map[string]Profile{
+		"large": {CPUs: 8, Memory: resource.MustParse("8Gi")},
+	}}
+	limit := resource.MustParse("256Mi")
+	newPod := func(name string, limits corev1.ResourceList) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: "1"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:         name,
+					Image:        name,
+					Resources:    corev1.ResourceRequirements{Limits: limits},
+					VolumeMounts: []corev1.VolumeMount{{Name: "cache", MountPath: "/cache"}},
+				}},
+				Volumes:  []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: &limit}}}},
+				Overhead: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+		}
+	}
+
+	// The enclave resources the pod requests size its whole enclave.
+	spec := newPod("web", corev1.ResourceList{
+		corev1.ResourceMemory:    resource.MustParse("1Gi"),
+		ResourceEnclaveCPUs:      resource.MustParse("4"),
+		ResourceEnclaveMemoryMiB: resource.MustParse("2048"),
+	})
+	pod, err := NewPod(context.Background(), n, spec)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), pod.config.CPUCount)
+	assert.Equal(t, int64(2048), pod.config.MemoryMib)
+	resources := EnclaveResources(spec)
+	assert.Equal(t, int64(4), resources.Name(ResourceEnclaveCPUs, resource.DecimalSI).Value())
+	assert.Equal(t, int64(2048), resources.Name(ResourceEnclaveMemoryMiB, resource.DecimalSI).Value())
+
+	// Otherwise they are those of the container, volumes and overhead.
+	resources = EnclaveResources(newPod("web", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}))
+	assert.Equal(t, int64(1024+256+64), resources.Name(ResourceEnclaveMemoryMiB, resource.DecimalSI).Value())
+
+	// The emptyDir volumes must fit in the enclave.
+	_, err = NewPod(context.Background(), n, newPod("small", corev1.ResourceList{ResourceEnclaveMemoryMiB: resource.MustParse("128")}))
+	var rejection *AdmissionError
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonOutOfMemory, rejection.Reason)
+	}
+
+	// Profiles cannot size enclaves above the resources requested.
+	spec.Annotations = map[string]string{ProfileAnnotation: "large"}
+	_, err = NewPod(context.Background(), n, spec)
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonUnaccounted, rejection.Reason)
+	}
+
+	// Nodes may require pods to request them.
+	n.requireEnclaveResources = true
+	err = validatePod(n, newPod("web", corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")}))
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonUnaccounted, rejection.Reason)
+		assert.True(t, rejection.Terminal)
+	}
+}
@@ -0,0 +1,60 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RATLSAnnotation lets the agent of the enclave of a pod generate its key
+// pair and a TLS certificate embedding an attestation document of its
+// public key, for the workload to serve. It lists the DNS names and IP
+// addresses of the certificate, separated by commas, which may be none.
+const RATLSAnnotation = annotationPrefix + "ra-tls"
+
+// raTLSNames returns the names of the attested TLS certificate of a pod,
+// and whether it has one.
+func raTLSNames(pod *corev1.Pod) ([]string, bool, error) {
+	value, ok := pod.Annotations[RATLSAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if net.ParseIP(name) == nil {
+			if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(name, "*.")); len(errs) > 0 {
+				return nil, false, fmt.Errorf("invalid %s name %q: %s", RATLSAnnotation, name, strings.Join(errs, ", "))
+			}
+		}
+		names = append(names, name)
+	}
+	return names, true, nil
+}
+
+// raTLSFlags returns the flags of the agent generating the attested TLS
+// certificate of the pod, none when it has none.
+func (pod *Pod) raTLSFlags() []string {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotation was validated when the pod was created.
+	names, ok, _ := raTLSNames(spec)
+	if !ok {
+		return nil
+	}
+	flags := []string{"-ra-tls"}
+	if len(names) > 0 {
+		flags = append(flags, "-ra-tls-names="+strings.Join(names, ","))
+	}
+	return flags
+}
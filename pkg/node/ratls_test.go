@@ -0,0 +1,39 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRATLSNames(t *testing.T) {
+	spec := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	_, ok, err := raTLSNames(spec)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	pod := &Pod{pod: spec}
+	assert.Empty(t, pod.raTLSFlags())
+
+	// The certificate may have no name, clients identify enclaves by their attestation.
+	spec.Annotations[RATLSAnnotation] = ""
+	assert.Equal(t, []string{"-ra-tls"}, pod.raTLSFlags())
+
+	spec.Annotations[RATLSAnnotation] = "Web.default.svc, *.example.com,,10.0.0.1"
+	names, ok, err := raTLSNames(spec)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"web.default.svc", "*.example.com", "10.0.0.1"}, names)
+	assert.Equal(t, []string{"-ra-tls", "-ra-tls-names=web.default.svc,*.example.com,10.0.0.1"}, pod.raTLSFlags())
+
+	spec.Annotations[RATLSAnnotation] = "web_server"
+	_, _, err = raTLSNames(spec)
+	assert.NotNil(t, err)
+
+	// Enabling it on a running pod relaunches its enclave with the certificate.
+	current := spec.DeepCopy()
+	delete(current.Annotations, RATLSAnnotation)
+	spec.Annotations[RATLSAnnotation] = ""
+	assert.True(t, requiresRebuild(current, spec))
+}
@@ -0,0 +1,125 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// reconcileInterval is how often the enclaves of the host are checked against the pods of the node.
+const reconcileInterval = 30 * time.Second
+
+// Stubbed in tests.
+var (
+	describeEnclaves = cli.DescribeEnclaves
+	terminateEnclave = cli.TerminateEnclave
+)
+
+// Reconcile periodically repairs the drift between the enclaves of the host
+// and the pods of the node until the context is done. Enclaves terminated or
+// launched behind the node's back, such as with nitro-cli, are otherwise only
+// noticed when the API server asks about their pods.
+func (n *Node) Reconcile(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := n.reconcile(ctx); err != nil {
+			log.G(ctx).Warnf("failed to reconcile enclaves: %v", err)
+		}
+	}
+}
+
+// reconcile compares the enclaves of the host with the pods of the node.
+// Enclaves of pods the node does not know are terminated, and pods whose
+// enclave is gone are handled as if it exited, so their restart policy
+// applies and their status is updated. Drift is only repaired once seen by two
+// passes in a row, so launches and terminations in progress are left alone.
+func (n *Node) reconcile(ctx context.Context) error {
+	enclaves, err := describeEnclaves()
+	if err != nil {
+		return err
+	}
+	pods, err := n.GetPods()
+	if err != nil {
+		return err
+	}
+
+	owned := make(map[string]bool)
+	for _, pod := range pods {
+		owned[pod.tag] = true
+	}
+	running := make(map[string]bool)
+	drift := make(map[string]bool)
+
+	for _, info := range enclaves {
+		if info.State != cli.StateRunning {
+			continue
+		}
+		running[info.EnclaveID] = true
+		// Not all enclaves are pods.
+		if _, _, _, err := decodeTag(info.EnclaveName); err != nil || owned[info.EnclaveName] {
+			continue
+		}
+		drift[info.EnclaveID] = true
+		if !n.drifted(info.EnclaveID) {
+			continue
+		}
+
+		log.G(ctx).Infof("Terminating enclave %s of unknown pod %s.", info.EnclaveID, info.EnclaveName)
+		if _, err := terminateEnclave(info.EnclaveID); err != nil {
+			log.G(ctx).Errorf("Failed to terminate enclave %s: %v.\n", info.EnclaveID, err)
+		}
+	}
+
+	for _, pod := range pods {
+		pod.mu.RLock()
+		enclaveID := pod.info.EnclaveID
+		isRunning := pod.state == containerRunning
+		pod.mu.RUnlock()
+		if !isRunning || enclaveID == "" || running[enclaveID] {
+			continue
+		}
+		drift[enclaveID] = true
+		if !n.drifted(enclaveID) {
+			continue
+		}
+
+		log.G(ctx).Warnf("Enclave %s of pod %s/%s is gone.", enclaveID, pod.namespace, pod.name)
+		pod.warning(eventReasonEnclaveLost, "Enclave %s terminated outside of the node", enclaveID)
+		pod.enclaveLost("the enclave terminated outside of the node")
+	}
+
+	n.reconcileMu.Lock()
+	n.drift = drift
+	n.reconcileMu.Unlock()
+	return nil
+}
+
+// drifted tells whether the previous reconciliation pass found the enclave drifting too.
+func (n *Node) drifted(enclaveID string) bool {
+	n.reconcileMu.Lock()
+	defer n.reconcileMu.Unlock()
+	return n.drift[enclaveID]
+}
+
+// enclaveLost stops waiting for the enclave of the pod as if it exited,
+// recording why in its terminated state.
+func (pod *Pod) enclaveLost(message string) {
+	pod.mu.Lock()
+	lost := pod.lost
+	if lost != nil {
+		pod.killMessage = message
+	}
+	pod.mu.Unlock()
+	if lost != nil {
+		lost()
+	}
+}
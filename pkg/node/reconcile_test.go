@@ -0,0 +1,49 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	n := &Node{name: "node", pods: make(map[string]*Pod), current: make(map[string]string)}
+	add := func(name, enclaveID string) *Pod {
+		pod := &Pod{namespace: "default", name: name, node: n, state: containerRunning}
+		pod.tag = encodeTag(pod.namespace, pod.name, pod.uid)
+		pod.info = cli.EnclaveInfo{EnclaveName: pod.tag, EnclaveID: enclaveID, State: cli.StateRunning}
+		n.InsertPod(pod, pod.tag)
+		return pod
+	}
+	kept := add("kept", "i-1")
+	gone := add("gone", "i-2")
+	var lost bool
+	gone.lost = func() { lost = true }
+
+	enclaves := []cli.EnclaveInfo{
+		kept.info,
+		{EnclaveName: encodeTag("default", "unknown", ""), EnclaveID: "i-3", State: cli.StateRunning},
+		{EnclaveName: "not-a-pod", EnclaveID: "i-4", State: cli.StateRunning},
+	}
+	var terminated []string
+	defer func(describe func() ([]cli.EnclaveInfo, error), terminate func(string) (*cli.TerminationResponse, error)) {
+		describeEnclaves, terminateEnclave = describe, terminate
+	}(describeEnclaves, terminateEnclave)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) { return enclaves, nil }
+	terminateEnclave = func(enclaveID string) (*cli.TerminationResponse, error) {
+		terminated = append(terminated, enclaveID)
+		return &cli.TerminationResponse{EnclaveID: enclaveID, Terminated: true}, nil
+	}
+
+	// Drift is only repaired once seen twice.
+	assert.Nil(t, n.reconcile(context.Background()))
+	assert.Empty(t, terminated)
+	assert.False(t, lost)
+
+	assert.Nil(t, n.reconcile(context.Background()))
+	assert.Equal(t, []string{"i-3"}, terminated)
+	assert.True(t, lost)
+	assert.Equal(t, "the enclave terminated outside of the node", gone.killMessage)
+}
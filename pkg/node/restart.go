@@ -0,0 +1,58 @@
+package node
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// Backoff applied between enclave restarts, matching the kubelet's crash loop backoff.
+	restartBackoffInitial = 10 * time.Second
+	restartBackoffMax     = 5 * time.Minute
+	// An enclave which ran for this long before exiting resets the backoff.
+	restartBackoffReset = 10 * time.Minute
+)
+
+// restartBackoff computes the delay before relaunching an enclave which exited.
+type restartBackoff struct {
+	delay time.Duration
+}
+
+// next returns the delay to wait before the next restart, given how long the
+// enclave ran before exiting.
+func (b *restartBackoff) next(ranFor time.Duration) time.Duration {
+	if b.delay == 0 || ranFor >= restartBackoffReset {
+		b.delay = restartBackoffInitial
+		return b.delay
+	}
+	b.delay *= 2
+	if b.delay > restartBackoffMax {
+		b.delay = restartBackoffMax
+	}
+	return b.delay
+}
+
+// shouldRestart reports whether an enclave which exited should be relaunched
+// according to the pod's restart policy.
+func shouldRestart(policy corev1.RestartPolicy, failed bool) bool {
+	switch policy {
+	case corev1.RestartPolicyNever:
+		return false
+	case corev1.RestartPolicyOnFailure:
+		return failed
+	default:
+		// Always is the default policy.
+		return true
+	}
+}
+
+// finalPhase returns the phase of a pod whose enclave exited for good. Only
+// workloads whose exit code the agent reported as 0 completed, enclaves which
+// exited without reporting it crashed.
+func finalPhase(terminated *corev1.ContainerStateTerminated) corev1.PodPhase {
+	if terminated.ExitCode == 0 {
+		return corev1.PodSucceeded
+	}
+	return corev1.PodFailed
+}
@@ -0,0 +1,120 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	var b restartBackoff
+
+	assert.Equal(t, 10*time.Second, b.next(time.Second))
+	assert.Equal(t, 20*time.Second, b.next(time.Second))
+	assert.Equal(t, 40*time.Second, b.next(time.Second))
+	for i := 0; i < 10; i++ {
+		b.next(time.Second)
+	}
+	assert.Equal(t, restartBackoffMax, b.next(time.Second))
+
+	// A long running enclave resets the backoff.
+	assert.Equal(t, restartBackoffInitial, b.next(restartBackoffReset))
+}
+
+func TestShouldRestart(t *testing.T) {
+	assert.True(t, shouldRestart(corev1.RestartPolicyAlways, false))
+	assert.True(t, shouldRestart("", true))
+	assert.True(t, shouldRestart(corev1.RestartPolicyOnFailure, true))
+	assert.False(t, shouldRestart(corev1.RestartPolicyOnFailure, false))
+	assert.False(t, shouldRestart(corev1.RestartPolicyNever, true))
+}
+
+func TestFinalPhase(t *testing.T) {
+	assert.Equal(t, corev1.PodSucceeded, finalPhase(&corev1.ContainerStateTerminated{ExitCode: 0, Reason: containerReasonCompleted}))
+	assert.Equal(t, corev1.PodFailed, finalPhase(&corev1.ContainerStateTerminated{ExitCode: 1, Reason: containerReasonError}))
+	assert.Equal(t, corev1.PodFailed, finalPhase(&corev1.ContainerStateTerminated{ExitCode: exitCodeUnknown, Reason: podReasonEnclaveExited}))
+}
+
+func TestContainerStatus(t *testing.T) {
+	pod := &Pod{
+		pod: &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx", UID: "1234"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+			},
+		},
+		phase: corev1.PodPending,
+		state: containerWaiting,
+	}
+
+	// Waiting containers have no enclave yet.
+	status := pod.GetStatus().ContainerStatuses[0]
+	assert.Equal(t, "nginx", status.Name)
+	assert.Equal(t, "nginx:1.25", status.Image)
+	assert.Empty(t, status.ContainerID)
+	assert.NotNil(t, status.State.Waiting)
+
+	pod.phase = corev1.PodRunning
+	pod.state = containerRunning
+	pod.info = cli.EnclaveInfo{EnclaveID: "i-1234-enc5678"}
+	pod.imageID = imageIDPrefix + "abcd"
+	pod.restarts = 2
+	status = pod.GetStatus().ContainerStatuses[0]
+	assert.Equal(t, "nitro://i-1234-enc5678", status.ContainerID)
+	assert.Equal(t, "sha384:abcd", status.ImageID)
+	assert.Equal(t, int32(2), status.RestartCount)
+	assert.True(t, *status.Started)
+	assert.NotNil(t, status.State.Running)
+
+	pod.phase = corev1.PodFailed
+	pod.state = containerTerminated
+	pod.termination = &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}
+	status = pod.GetStatus().ContainerStatuses[0]
+	assert.Equal(t, "nitro://i-1234-enc5678", status.State.Terminated.ContainerID)
+	assert.Empty(t, pod.termination.ContainerID)
+}
+
+func TestBootingNotReady(t *testing.T) {
+	pod := &Pod{namespace: "default", name: "web", phase: corev1.PodRunning, state: containerRunning, ready: true, booted: make(chan struct{})}
+	status := pod.GetStatus()
+	assert.False(t, status.ContainerStatuses[0].Ready)
+	for _, condition := range status.Conditions {
+		if condition.Type == corev1.PodReady {
+			assert.Equal(t, corev1.ConditionFalse, condition.Status)
+			assert.Equal(t, podReasonEnclaveBooting, condition.Reason)
+		}
+	}
+
+	// The pod is ready once the agent seeded the entropy and started the workload.
+	pod.handleEvent(context.Background(), agent.Event{Type: agent.EventStarted, Time: time.Now()})
+	assert.True(t, pod.GetStatus().ContainerStatuses[0].Ready)
+}
+
+func TestConditionTransitions(t *testing.T) {
+	start := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	pod := &Pod{startTime: start, phase: corev1.PodPending, state: containerWaiting}
+
+	status := pod.GetStatus()
+	assert.Equal(t, start, *status.StartTime)
+	pending := status.Conditions[1].LastTransitionTime
+	assert.False(t, pending.IsZero())
+
+	// Transition times are kept until the status of the condition changes.
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, pending, pod.GetStatus().Conditions[1].LastTransitionTime)
+
+	pod.phase = corev1.PodRunning
+	pod.state = containerRunning
+	pod.ready = true
+	status = pod.GetStatus()
+	assert.Equal(t, corev1.PodReady, status.Conditions[1].Type)
+	assert.True(t, pending.Before(&status.Conditions[1].LastTransitionTime))
+	assert.Equal(t, status.Conditions[0].LastTransitionTime, pending)
+	assert.Equal(t, start, *status.StartTime)
+}
@@ -0,0 +1,50 @@
+package node
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// RuntimeClassLabel labels nodes with the runtime class their pods must
+	// request, so the scheduling node selector of the RuntimeClass targets them.
+	RuntimeClassLabel = annotationPrefix + "runtime-class"
+
+	// AdmissionReasonRuntimeClass rejects the pods which do not request the
+	// runtime class of the node.
+	AdmissionReasonRuntimeClass = "UnsupportedRuntimeClass"
+)
+
+// runtimeClassError returns why a pod cannot run on a node whose pods must
+// request the given runtime class, nil if it can.
+func runtimeClassError(runtimeClass string, pod *corev1.Pod) *AdmissionError {
+	if runtimeClass == "" || pod.Spec.RuntimeClassName != nil && *pod.Spec.RuntimeClassName == runtimeClass {
+		return nil
+	}
+	return unsupportedf(AdmissionReasonRuntimeClass, "pods running in enclaves on this node must request the %q runtime class", runtimeClass)
+}
+
+// runtimeClass returns the runtime class the pods of the node must request, none when empty.
+func (n *Node) runtimeClass() string {
+	n.RLock()
+	defer n.RUnlock()
+	return n.runtimeClassName
+}
+
+// podOverhead returns the overhead of the runtime class of a pod in enclave
+// units, such as the memory of the kernel and agent of the enclave. The
+// RuntimeClass admission controller sets it, and the scheduler accounts it
+// along the resources of the containers, so it is part of the enclave too.
+func podOverhead(pod *corev1.Pod) (cpus int64, memoryMiB int64) {
+	if quantity, ok := pod.Spec.Overhead[corev1.ResourceCPU]; ok {
+		cpus = quantity.ScaledValue(resource.Milli) / 1000
+		// If SMT is active we must specify CPUs in pairs
+		if smtActive {
+			cpus = cpus * 2
+		}
+	}
+	if quantity, ok := pod.Spec.Overhead[corev1.ResourceMemory]; ok {
+		memoryMiB = (quantity.Value() + MiB - 1) / MiB
+	}
+	return cpus, memoryMiB
+}
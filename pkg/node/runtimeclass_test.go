@@ -0,0 +1,54 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRuntimeClass(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) { return nil, errors.New("no allocator") }
+	defer func(f func() ([]cli.EnclaveInfo, error)) { describeEnclaves = f }(describeEnclaves)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) { return nil, errors.New("no nitro-cli") }
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), runtimeClassName: "nitro-enclave"}
+	spec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "web",
+			Image: "web",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}},
+		}}},
+	}
+
+	// Pods must request the runtime class of the node.
+	_, err := NewPod(context.Background(), n, spec)
+	var rejection *AdmissionError
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonRuntimeClass, rejection.Reason)
+		assert.True(t, rejection.Terminal)
+	}
+	other := "kata"
+	spec.Spec.RuntimeClassName = &other
+	_, err = NewPod(context.Background(), n, spec)
+	assert.ErrorAs(t, err, &rejection)
+
+	// The overhead of the runtime class is part of the enclave.
+	runtimeClass := "nitro-enclave"
+	spec.Spec.RuntimeClassName = &runtimeClass
+	spec.Spec.Overhead = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}
+	pod, err := NewPod(context.Background(), n, spec)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1088), pod.config.MemoryMib)
+	assert.Equal(t, int64(1088), EnclaveMemoryMiB(spec))
+}
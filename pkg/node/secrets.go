@@ -0,0 +1,245 @@
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// KMSSecretsAnnotation lists the Secrets of the pod's namespace, separated
+	// by commas, whose values are KMS ciphertexts released into its enclave
+	// once attested. They are decrypted for the attested key of the enclave,
+	// so their plaintext never leaves it, and written to
+	// /run/kms-secrets/<secret>/<key> before the workload starts.
+	KMSSecretsAnnotation = annotationPrefix + "kms-secrets"
+
+	eventReasonSecretsReleased     = "SecretsReleased"
+	eventReasonSecretReleaseFailed = "SecretReleaseFailed"
+
+	// secretsReleaseTimeout bounds how long the agent waits for the secrets
+	// before starting the workload, and how long the release is retried.
+	secretsReleaseTimeout = time.Minute
+	// secretsRequestTimeout bounds each exchange with the agent, including
+	// the KMS requests sealing the secrets.
+	secretsRequestTimeout = 30 * time.Second
+	// secretsMode is the mode of the released files.
+	secretsMode = 0400
+)
+
+// kmsSecretNames returns the names of the KMS secrets of a pod.
+func kmsSecretNames(pod *corev1.Pod) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(pod.Annotations[KMSSecretsAnnotation], ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s secret %q: %s", KMSSecretsAnnotation, name, strings.Join(errs, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// kmsSecrets returns the names of the KMS secrets of the pod, if any.
+func (pod *Pod) kmsSecrets() []string {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotation was validated when the pod was created.
+	names, _ := kmsSecretNames(spec)
+	return names
+}
+
+// secretsRoots are the roots attestation documents are verified with,
+// AWSNitroRoots when nil. It is a variable so tests can replace it.
+var secretsRoots *x509.CertPool
+
+// kmsDecrypt decrypts a KMS ciphertext for the recipient attested by the
+// document, returning the enveloped data only it can decrypt. It is a
+// variable so tests can replace it.
+var kmsDecrypt = decryptForRecipient
+
+// releaseSecrets releases the KMS secrets of the pod into its enclave,
+// retrying while the agent starts.
+func (pod *Pod) releaseSecrets(ctx context.Context, cid uint32, names []string) error {
+	ctx, cancel := context.WithTimeout(ctx, secretsReleaseTimeout)
+	defer cancel()
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	var moduleID string
+	seal := func(document []byte) ([]agent.SealedFile, error) {
+		doc, files, err := pod.sealSecrets(ctx, names, nonce, document)
+		if doc != nil {
+			moduleID = doc.ModuleID
+		}
+		return files, err
+	}
+
+	for {
+		conn, err := dialAgent(int(cid), agent.SecretsPort)
+		if err == nil {
+			err = agent.ReleaseSecrets(conn, nonce, seal, secretsRequestTimeout)
+			conn.Close()
+			if err != nil {
+				return err
+			}
+			pod.event(corev1.EventTypeNormal, eventReasonSecretsReleased, "Released KMS secrets %s into enclave module %s", strings.Join(names, ", "), moduleID)
+			return nil
+		}
+		log.G(ctx).Debugf("failed to reach the secret server of enclave %d: %v", cid, err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to reach the enclave agent: %v", err)
+		case <-time.After(attestationRetry):
+		}
+	}
+}
+
+// sealSecrets verifies the attestation document of the enclave, which must
+// include the nonce and the PCRs the pod expects of its image, and returns
+// the files of its secrets decrypted by KMS for the key of the document.
+// KMS checks the document again against the conditions of the key policies.
+func (pod *Pod) sealSecrets(ctx context.Context, names []string, nonce, document []byte) (*attestation.Document, []agent.SealedFile, error) {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil, nil, fmt.Errorf("pod has no spec")
+	}
+
+	opts := attestation.VerifyOptions{Roots: secretsRoots, Nonce: nonce, PCRs: map[uint]string{}}
+	// The annotations were validated when the pod was created.
+	expected, _, _ := expectedMeasurements(spec)
+	for index, value := range map[uint]string{0: expected.PCR0, 1: expected.PCR1, 2: expected.PCR2, 8: expected.PCR8} {
+		if value != "" {
+			opts.PCRs[index] = value
+		}
+	}
+	doc, err := attestation.Verify(document, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(doc.PublicKey) == 0 {
+		return doc, nil, fmt.Errorf("attestation document has no public key")
+	}
+
+	cfg, endpoint, err := pod.kmsConfig(ctx)
+	if err != nil {
+		return doc, nil, err
+	}
+	var files []agent.SealedFile
+	for _, name := range names {
+		secret, err := getSecret(pod.resources(), name, pod.namespace, nil)
+		if err != nil {
+			return doc, nil, err
+		}
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ciphertext, err := kmsDecrypt(ctx, cfg, endpoint, secret.Data[key], document)
+			if err != nil {
+				return doc, nil, fmt.Errorf("failed to decrypt key %q of secret %s/%s: %v", key, pod.namespace, name, err)
+			}
+			files = append(files, agent.SealedFile{
+				Path:       path.Join(agent.SecretsDir, name, key),
+				Ciphertext: ciphertext,
+				Mode:       secretsMode,
+			})
+		}
+	}
+	return doc, files, nil
+}
+
+// kmsConfig returns the AWS configuration and the endpoint of the KMS
+// requests of the pod, in the region of its KMS proxy annotation, if any.
+// They are signed with the credentials of the role bound to its service
+// account, or with those of the node when it allows it, and sent to the
+// endpoint of the node: the endpoint annotation of the pod only applies to
+// the requests of its enclave.
+func (pod *Pod) kmsConfig(ctx context.Context) (aws.Config, string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return cfg, "", err
+	}
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if region := spec.Annotations[KMSRegionAnnotation]; region != "" {
+		cfg.Region = region
+	}
+	if cfg.Region == "" {
+		return cfg, "", fmt.Errorf("no KMS region, set the %s annotation", KMSRegionAnnotation)
+	}
+	endpoint := fmt.Sprintf("https://kms.%s.amazonaws.com/", cfg.Region)
+	if pod.node != nil && pod.node.kmsEndpoint != "" {
+		endpoint = pod.node.kmsEndpoint
+	}
+
+	roleARN, err := pod.roleARN(ctx)
+	if err != nil {
+		return cfg, "", err
+	}
+	if roleARN == "" {
+		if pod.node == nil || !pod.node.kmsNodeCredentials {
+			return cfg, "", fmt.Errorf("no IAM role bound to the service account of the pod with the %s annotation", RoleARNAnnotation)
+		}
+		return cfg, endpoint, nil
+	}
+	creds, err := newCredentialVendor(pod, roleARN).credentials(ctx)
+	if err != nil {
+		return cfg, "", err
+	}
+	cfg.Credentials = credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	return cfg, endpoint, nil
+}
+
+// decryptForRecipient calls the Decrypt action of KMS with the attestation
+// document as recipient, whose conditions key policies check, such as
+// kms:RecipientAttestation:PCR0.
+func decryptForRecipient(ctx context.Context, cfg aws.Config, endpoint string, ciphertext, document []byte) ([]byte, error) {
+	client := kms.NewFromConfig(cfg, func(o *kms.Options) {
+		o.EndpointResolver = kms.EndpointResolverFromURL(endpoint)
+	})
+	out, err := client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: ciphertext,
+		Recipient: &kmstypes.RecipientInfo{
+			AttestationDocument:    document,
+			KeyEncryptionAlgorithm: kmstypes.KeyEncryptionMechanismRsaesOaepSha256,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("KMS Decrypt failed: %w", err)
+	}
+	if len(out.CiphertextForRecipient) == 0 {
+		return nil, fmt.Errorf("KMS returned no ciphertext for the enclave")
+	}
+	return out.CiphertextForRecipient, nil
+}
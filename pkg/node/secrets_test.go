@@ -0,0 +1,126 @@
+package node
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation/attestationtest"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSealSecrets(t *testing.T) {
+	module, err := attestationtest.NewModule()
+	assert.Nil(t, err)
+	defer func(roots *x509.CertPool) { secretsRoots = roots }(secretsRoots)
+	secretsRoots = module.Roots()
+	defer func(f func(context.Context, aws.Config, string, []byte, []byte) ([]byte, error)) { kmsDecrypt = f }(kmsDecrypt)
+	var endpoint string
+	kmsDecrypt = func(_ context.Context, _ aws.Config, e string, ciphertext, _ []byte) ([]byte, error) {
+		endpoint = e
+		return append([]byte("sealed:"), ciphertext...), nil
+	}
+
+	spec := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{
+		KMSSecretsAnnotation:     "db, api",
+		KMSRegionAnnotation:      "eu-west-1",
+		ExpectedPCRAnnotation(0): hex.EncodeToString(module.PCRs[0]),
+	}}}
+	pod := &Pod{namespace: "default", name: "web", pod: spec, node: &Node{kmsNodeCredentials: true, resources: fakeResources{secrets: map[string]*corev1.Secret{
+		"default/db":  {Data: map[string][]byte{"user": []byte("u"), "password": []byte("p")}},
+		"default/api": {Data: map[string][]byte{"token": []byte("t")}},
+	}}}}
+	names := pod.kmsSecrets()
+	assert.Equal(t, []string{"db", "api"}, names)
+
+	nonce := []byte("nonce")
+	document, err := module.Attest(nonce, nil, []byte("public key"))
+	assert.Nil(t, err)
+	doc, files, err := pod.sealSecrets(context.Background(), names, nonce, document)
+	assert.Nil(t, err)
+	assert.Equal(t, module.ModuleID, doc.ModuleID)
+	assert.Equal(t, []agent.SealedFile{
+		{Path: "/run/kms-secrets/db/password", Ciphertext: []byte("sealed:p"), Mode: secretsMode},
+		{Path: "/run/kms-secrets/db/user", Ciphertext: []byte("sealed:u"), Mode: secretsMode},
+		{Path: "/run/kms-secrets/api/token", Ciphertext: []byte("sealed:t"), Mode: secretsMode},
+	}, files)
+	assert.Equal(t, "https://kms.eu-west-1.amazonaws.com/", endpoint)
+
+	// The endpoint annotation only applies to the proxy of the enclave.
+	spec.Annotations[KMSEndpointAnnotation] = "kms.example.com"
+	pod.node.kmsEndpoint = "https://vpce-1.kms.eu-west-1.vpce.amazonaws.com"
+	_, _, err = pod.sealSecrets(context.Background(), names, nonce, document)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://vpce-1.kms.eu-west-1.vpce.amazonaws.com", endpoint)
+
+	// Pods with no role use the credentials of the node only when it allows it.
+	pod.node.kmsNodeCredentials = false
+	_, _, err = pod.sealSecrets(context.Background(), names, nonce, document)
+	assert.EqualError(t, err, "no IAM role bound to the service account of the pod with the "+RoleARNAnnotation+" annotation")
+	pod.node.kmsNodeCredentials = true
+
+	// Nothing is decrypted for stale documents, other images or documents without a key.
+	_, _, err = pod.sealSecrets(context.Background(), names, []byte("other nonce"), document)
+	assert.NotNil(t, err)
+	noKey, err := module.Attest(nonce, nil, nil)
+	assert.Nil(t, err)
+	_, _, err = pod.sealSecrets(context.Background(), names, nonce, noKey)
+	assert.EqualError(t, err, "attestation document has no public key")
+	spec.Annotations[ExpectedPCRAnnotation(0)] = hex.EncodeToString(module.PCRs[1])
+	_, _, err = pod.sealSecrets(context.Background(), names, nonce, document)
+	assert.NotNil(t, err)
+	delete(spec.Annotations, ExpectedPCRAnnotation(0))
+
+	_, _, err = pod.sealSecrets(context.Background(), []string{"missing"}, nonce, document)
+	assert.NotNil(t, err)
+
+	spec.Annotations[KMSSecretsAnnotation] = "db,Not_A_Secret"
+	_, err = kmsSecretNames(spec)
+	assert.NotNil(t, err)
+}
+
+func TestDecryptForRecipient(t *testing.T) {
+	var request struct {
+		CiphertextBlob []byte
+		Recipient      struct {
+			AttestationDocument    []byte
+			KeyEncryptionAlgorithm string
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "TrentService.Decrypt", r.Header.Get("X-Amz-Target"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request")
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		if string(request.CiphertextBlob) == "denied" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"AccessDeniedException","message":"denied by the key policy"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]byte{"CiphertextForRecipient": []byte("sealed")})
+	}))
+	defer server.Close()
+
+	cfg := aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+	sealed, err := decryptForRecipient(context.Background(), cfg, server.URL, []byte("ciphertext"), []byte("document"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sealed"), sealed)
+	assert.Equal(t, []byte("ciphertext"), request.CiphertextBlob)
+	assert.Equal(t, []byte("document"), request.Recipient.AttestationDocument)
+	assert.Equal(t, "RSAES_OAEP_SHA_256", request.Recipient.KeyEncryptionAlgorithm)
+
+	_, err = decryptForRecipient(context.Background(), cfg, server.URL, []byte("denied"), []byte("document"))
+	assert.ErrorContains(t, err, "AccessDeniedException")
+}
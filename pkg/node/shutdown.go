@@ -0,0 +1,103 @@
+package node
+
+import (
+	"context"
+	"sync"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// nodeShutdownMessage is the status message of the pods stopped by the
+// shutdown of the node, matching the kubelet's.
+const nodeShutdownMessage = "Pod was terminated in response to imminent node shutdown."
+
+// Shutdown prepares the pods of the node for the exit of the provider. When
+// drain is set, their enclaves are stopped gracefully and the pods failed,
+// so their controllers replace them elsewhere, until the context is done.
+// Otherwise the enclaves keep running, and their persisted pods are adopted
+// by the next provider.
+func (n *Node) Shutdown(ctx context.Context, drain bool) error {
+	pods, err := n.GetPods()
+	if err != nil {
+		return err
+	}
+
+	if !drain {
+		for _, pod := range pods {
+			if err := pod.saveState(); err != nil {
+				log.G(ctx).Errorf("Failed to save pod state: %v.\n", err)
+			}
+		}
+		log.G(ctx).Infof("Leaving %d enclaves running for the next provider to adopt", len(pods))
+		return nil
+	}
+
+	log.G(ctx).Infof("Draining %d pods before shutting down", len(pods))
+	var wg sync.WaitGroup
+	for _, pod := range pods {
+		pod.mu.RLock()
+		finished := pod.phase == corev1.PodSucceeded || pod.phase == corev1.PodFailed
+		pod.mu.RUnlock()
+		if finished {
+			continue
+		}
+
+		wg.Add(1)
+		go func(pod *Pod) {
+			defer wg.Done()
+			pod.drain(ctx)
+		}(pod)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		log.G(ctx).Warnf("Shutdown grace period elapsed before the pods were drained")
+		return ctx.Err()
+	}
+}
+
+// drain stops the enclave of a pod for the shutdown of the node, and reports
+// the pod failed to the API server, as the pod controller is stopped already.
+func (pod *Pod) drain(ctx context.Context) {
+	pod.shutdown(ctx, nodeShutdownMessage)
+	if err := pod.removeState(); err != nil {
+		log.G(ctx).Errorf("Failed to remove pod state: %v.\n", err)
+	}
+
+	pod.mu.Lock()
+	pod.termination = nil
+	pod.mu.Unlock()
+	pod.setPhase(ctx, corev1.PodFailed, podReasonTerminated, nodeShutdownMessage)
+
+	if err := pod.updateStatus(ctx); err != nil {
+		log.G(ctx).Errorf("Failed to update the status of pod %s/%s: %v.\n", pod.namespace, pod.name, err)
+	}
+}
+
+// updateStatus writes the status of the pod to the API server.
+func (pod *Pod) updateStatus(ctx context.Context) error {
+	if pod.node == nil || pod.node.client == nil {
+		return nil
+	}
+	pods := pod.node.client.CoreV1().Pods(pod.namespace)
+	current, err := pods.Get(ctx, pod.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pod.uid != "" && current.UID != pod.uid {
+		// The pod was recreated meanwhile.
+		return nil
+	}
+	current.Status = pod.GetStatus()
+	_, err = pods.UpdateStatus(ctx, current, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,51 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestShutdown(t *testing.T) {
+	spec := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"}}
+	client := fake.NewSimpleClientset(spec.DeepCopy())
+	n := &Node{client: client, pods: make(map[string]*Pod), current: make(map[string]string)}
+	pod := &Pod{namespace: "default", name: "web", uid: "1", node: n, pod: spec, phase: corev1.PodRunning, state: containerRunning}
+	n.InsertPod(pod, pod.buildEnclaveNameTag())
+
+	// Kept enclaves are left running.
+	assert.Nil(t, n.Shutdown(context.Background(), false))
+	assert.Equal(t, corev1.PodRunning, pod.GetStatus().Phase)
+
+	// Drained pods fail, and the API server is told so.
+	assert.Nil(t, n.Shutdown(context.Background(), true))
+	updated, err := client.CoreV1().Pods("default").Get(context.Background(), "web", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, corev1.PodFailed, updated.Status.Phase)
+	assert.Equal(t, nodeShutdownMessage, updated.Status.Message)
+}
+
+func TestPodShutdownConcurrent(t *testing.T) {
+	exit := make(chan struct{})
+	pod := &Pod{namespace: "default", name: "web", exit: exit}
+
+	// Stop, drains and evictions may shut the same pod down at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pod.shutdown(context.Background(), "test")
+		}()
+	}
+	wg.Wait()
+
+	_, open := <-exit
+	assert.False(t, open)
+	assert.Nil(t, pod.exit)
+}
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+)
+
+// SigningPolicy is the policy of the node on the signatures of the enclave
+// images it launches, which it builds and signs itself.
+type SigningPolicy struct {
+	// Certificate is the PEM file of the certificate images are signed with.
+	Certificate string
+	// Required refuses to launch the images which are not signed with a
+	// valid signature by Certificate, while it is trusted.
+	Required bool
+	// Roots are the certificate authorities Certificate must chain to, any when nil.
+	Roots *x509.CertPool
+	// Fingerprints are SHA-256 fingerprints of certificates, in hex, one of
+	// which Certificate must have, any when empty.
+	Fingerprints []string
+}
+
+// Fingerprint normalizes a SHA-256 certificate fingerprint, in hex with or
+// without colons, such as openssl x509 -fingerprint prints it.
+func Fingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+	}
+	return fingerprint, nil
+}
+
+// VerifyCertificate returns the signing certificate once checked that it
+// is valid at the given time and trusted by the roots and fingerprints.
+func (p *SigningPolicy) VerifyCertificate(now time.Time) (*x509.Certificate, error) {
+	data, err := os.ReadFile(p.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s has no PEM certificate", p.Certificate)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("signing certificate %s is not valid at %s", cert.Subject, now.UTC().Format(time.RFC3339))
+	}
+
+	if len(p.Fingerprints) > 0 {
+		sum := sha256.Sum256(cert.Raw)
+		if !containsString(p.Fingerprints, hex.EncodeToString(sum[:])) {
+			return nil, fmt.Errorf("signing certificate %s has no trusted fingerprint", cert.Subject)
+		}
+	}
+	if p.Roots != nil {
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:       p.Roots,
+			CurrentTime: now,
+			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return nil, fmt.Errorf("untrusted signing certificate %s: %v", cert.Subject, err)
+		}
+	}
+	return cert, nil
+}
+
+// signingCertificatePCR measures a signing certificate, swapped by tests.
+var signingCertificatePCR = cli.SigningCertificatePCR
+
+// checkSignature checks, when the node requires signed images, that the
+// built image of the pod is signed by the trusted signing certificate of the
+// node, as PCR8 measures the certificate which signed it. It is checked
+// before every launch, as the certificate may expire or be revoked.
+func (pod *Pod) checkSignature(ctx context.Context, describe func() (*cli.EifInfo, error)) error {
+	if pod.node == nil || pod.node.signing == nil || !pod.node.signing.Required {
+		return nil
+	}
+	policy := pod.node.signing
+
+	info, err := describe()
+	if err != nil {
+		return pod.untrustedImage(ctx, "failed to describe enclave image: %v", err)
+	}
+	if !info.IsSigned {
+		return pod.untrustedImage(ctx, "enclave image is not signed")
+	}
+	if info.SignatureCheck == nil {
+		return pod.untrustedImage(ctx, "enclave image signature was not checked by nitro-cli")
+	}
+	if !*info.SignatureCheck {
+		return pod.untrustedImage(ctx, "enclave image signature is invalid")
+	}
+	if _, err := policy.VerifyCertificate(time.Now()); err != nil {
+		return pod.untrustedImage(ctx, "%v", err)
+	}
+	expected, err := signingCertificatePCR(policy.Certificate)
+	if err != nil {
+		return pod.untrustedImage(ctx, "failed to measure signing certificate: %v", err)
+	}
+	if !strings.EqualFold(info.Measurements.Pcr8, expected) {
+		return pod.untrustedImage(ctx, "enclave image is signed by another certificate, PCR8 %s", info.Measurements.Pcr8)
+	}
+	return nil
+}
+
+// untrustedImage fails the pod as its enclave image cannot be trusted.
+func (pod *Pod) untrustedImage(ctx context.Context, format string, args ...interface{}) error {
+	return pod.refuseLaunch(ctx, podReasonUntrustedImage, eventReasonUntrustedImage, fmt.Errorf(format, args...))
+}
@@ -0,0 +1,103 @@
+package node
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSigningPolicy(t *testing.T) {
+	// A certificate authority issues the signing certificate.
+	caKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.Nil(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	ca, err = x509.ParseCertificate(caDER)
+	assert.Nil(t, err)
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+	certificate := filepath.Join(t.TempDir(), "signer.pem")
+	assert.Nil(t, os.WriteFile(certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	sum := sha256.Sum256(der)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	policy := &SigningPolicy{Certificate: certificate, Required: true, Roots: roots}
+	_, err = policy.VerifyCertificate(time.Now())
+	assert.Nil(t, err)
+	_, err = policy.VerifyCertificate(time.Now().Add(2 * time.Hour))
+	assert.ErrorContains(t, err, "is not valid at")
+	_, err = (&SigningPolicy{Certificate: certificate, Roots: x509.NewCertPool()}).VerifyCertificate(time.Now())
+	assert.ErrorContains(t, err, "untrusted signing certificate")
+	fingerprint, err := Fingerprint(strings.ToUpper(hex.EncodeToString(sum[:2])) + ":" + hex.EncodeToString(sum[2:]))
+	assert.Nil(t, err)
+	_, err = (&SigningPolicy{Certificate: certificate, Fingerprints: []string{fingerprint}}).VerifyCertificate(time.Now())
+	assert.Nil(t, err)
+	_, err = (&SigningPolicy{Certificate: certificate, Fingerprints: []string{strings.Repeat("00", 32)}}).VerifyCertificate(time.Now())
+	assert.ErrorContains(t, err, "has no trusted fingerprint")
+	_, err = Fingerprint("abcd")
+	assert.NotNil(t, err)
+
+	// Images must be signed by the certificate, which PCR8 measures.
+	defer func(f func(string) (string, error)) { signingCertificatePCR = f }(signingCertificatePCR)
+	signingCertificatePCR = func(string) (string, error) { return "AA", nil }
+	info := new(cli.EifInfo)
+	defer func(f func(string) (*cli.EifInfo, error)) { describeEif = f }(describeEif)
+	describeEif = func(string) (*cli.EifInfo, error) { return info, nil }
+
+	pod := &Pod{namespace: "default", name: "web", uid: "1", node: &Node{signing: policy},
+		pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"}}}
+	assert.EqualError(t, pod.checkImage(context.Background()), "enclave image is not signed")
+	assert.Equal(t, podReasonUntrustedImage, pod.GetStatus().Reason)
+	info.IsSigned = true
+	invalid := false
+	info.SignatureCheck = &invalid
+	assert.EqualError(t, pod.checkImage(context.Background()), "enclave image signature is invalid")
+	info.SignatureCheck = nil
+	assert.EqualError(t, pod.checkImage(context.Background()), "enclave image signature was not checked by nitro-cli")
+	valid := true
+	info.SignatureCheck = &valid
+	info.Measurements.Pcr8 = "bb"
+	assert.EqualError(t, pod.checkImage(context.Background()), "enclave image is signed by another certificate, PCR8 bb")
+	info.Measurements.Pcr8 = "aa"
+	assert.Nil(t, pod.checkImage(context.Background()))
+
+	// Unsigned images run when signatures are not required.
+	policy.Required = false
+	info.IsSigned = false
+	assert.Nil(t, pod.checkImage(context.Background()))
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// SNIHostnamesAnnotation is the comma separated server names the TCP host
+// ports of a pod are routed by, rather than being bound by the pod alone.
+// Pods with distinct server names then share host ports such as 443, the TLS
+// connections being routed to them by the server name of their ClientHello.
+// Names starting with "*." match any server name with one more label.
+const SNIHostnamesAnnotation = annotationPrefix + "sni-hostnames"
+
+// sniHostnames returns the server names the host ports of a pod are routed by, parsed from its annotations.
+func sniHostnames(pod *corev1.Pod) ([]string, error) {
+	value, ok := pod.Annotations[SNIHostnamesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var hostnames []string
+	for _, hostname := range strings.Split(value, ",") {
+		hostname = strings.TrimSpace(hostname)
+		errs := validation.IsDNS1123Subdomain(hostname)
+		if strings.HasPrefix(hostname, "*.") {
+			errs = validation.IsWildcardDNS1123Subdomain(hostname)
+		}
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s annotation %q: %s", SNIHostnamesAnnotation, value, strings.Join(errs, ", "))
+		}
+		hostnames = append(hostnames, hostname)
+	}
+	return hostnames, nil
+}
+
+// sniHostnames returns the server names the host ports of the pod are routed by.
+func (pod *Pod) sniHostnames() []string {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotation was validated when the pod was created.
+	hostnames, _ := sniHostnames(spec)
+	return hostnames
+}
+
+// sharedPort is a host port shared by the pods routed by server name.
+type sharedPort struct {
+	router   *nitro.SNIRouter
+	listener net.Listener
+}
+
+// sniRoute is the route of a pod on a shared port, releasing the port once closed.
+type sniRoute struct {
+	*nitro.SNIRoute
+	release sync.Once
+	node    *Node
+	address string
+}
+
+func (r *sniRoute) Close() error {
+	err := r.SNIRoute.Close()
+	r.release.Do(func() {
+		r.node.releaseSharedPort(r.address)
+	})
+	return err
+}
+
+// routeSNI routes the connections to a shared host port with the given server
+// names to a vsock port of an enclave, listening on the host port unless
+// other pods already do.
+func (n *Node) routeSNI(address string, hostnames []string, cid, port uint32, limits nitro.ProxyLimits) (*sniRoute, error) {
+	n.sharedPortsMu.Lock()
+	defer n.sharedPortsMu.Unlock()
+
+	shared := n.sharedPorts[address]
+	if shared == nil {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		shared = &sharedPort{router: nitro.NewSNIRouter(), listener: listener}
+		go shared.router.Serve(listener) //nolint:errcheck
+		if n.sharedPorts == nil {
+			n.sharedPorts = make(map[string]*sharedPort)
+		}
+		n.sharedPorts[address] = shared
+	}
+
+	route, err := shared.router.Route(hostnames, cid, port, limits)
+	if err != nil {
+		n.closeUnusedPort(address, shared)
+		return nil, err
+	}
+	return &sniRoute{SNIRoute: route, node: n, address: address}, nil
+}
+
+// releaseSharedPort stops listening on a shared host port once no pod is routed through it.
+func (n *Node) releaseSharedPort(address string) {
+	n.sharedPortsMu.Lock()
+	defer n.sharedPortsMu.Unlock()
+
+	if shared := n.sharedPorts[address]; shared != nil {
+		n.closeUnusedPort(address, shared)
+	}
+}
+
+// closeUnusedPort closes a shared port without routes. The caller holds n.sharedPortsMu.
+func (n *Node) closeUnusedPort(address string, shared *sharedPort) {
+	if shared.router.Len() > 0 {
+		return
+	}
+	shared.listener.Close()
+	delete(n.sharedPorts, address)
+}
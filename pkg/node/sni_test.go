@@ -0,0 +1,76 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/nitro"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+func TestSNIHostnames(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	hostnames, err := sniHostnames(pod)
+	assert.Nil(t, err)
+	assert.Nil(t, hostnames)
+
+	pod.Annotations[SNIHostnamesAnnotation] = "a.example.com, *.b.example.com"
+	hostnames, err = sniHostnames(pod)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a.example.com", "*.b.example.com"}, hostnames)
+
+	pod.Annotations[SNIHostnamesAnnotation] = "a.example.com,Not_A_Host"
+	_, err = sniHostnames(pod)
+	assert.NotNil(t, err)
+}
+
+func TestSharedHostPorts(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) {
+		return nil, errors.New("no enclave driver")
+	}
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string)}
+	newPod := func(name, hostnames string) error {
+		annotations := map[string]string{}
+		if hostnames != "" {
+			annotations[SNIHostnamesAnnotation] = hostnames
+		}
+		_, err := NewPod(context.Background(), n, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: k8sTypes.UID("uid-" + name), Annotations: annotations},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: name, Image: name,
+				Ports: []corev1.ContainerPort{{ContainerPort: 8443, HostPort: 443}}}}},
+		})
+		return err
+	}
+
+	assert.Nil(t, newPod("a", "a.example.com"))
+	assert.Nil(t, newPod("b", "b.example.com"))
+	assert.EqualError(t, newPod("other-a", "a.example.com"),
+		"host port *:443/TCP for a.example.com is already claimed by pod default/a as *:443/TCP for a.example.com")
+	// Ports bound by a pod alone are not shared.
+	assert.NotNil(t, newPod("exclusive", ""))
+}
+
+func TestRouteSNI(t *testing.T) {
+	n := &Node{}
+	a, err := n.routeSNI("127.0.0.1:0", []string{"a.example.com"}, 16, 8443, nitro.ProxyLimits{})
+	assert.Nil(t, err)
+	b, err := n.routeSNI("127.0.0.1:0", []string{"b.example.com"}, 17, 8443, nitro.ProxyLimits{})
+	assert.Nil(t, err)
+	_, err = n.routeSNI("127.0.0.1:0", []string{"b.example.com"}, 18, 8443, nitro.ProxyLimits{})
+	assert.EqualError(t, err, "server name b.example.com is already routed")
+	assert.Len(t, n.sharedPorts, 1)
+
+	// The port is released with its last route.
+	assert.Nil(t, a.Close())
+	assert.Nil(t, a.Close())
+	assert.Len(t, n.sharedPorts, 1)
+	assert.Nil(t, b.Close())
+	assert.Len(t, n.sharedPorts, 0)
+}
@@ -0,0 +1,179 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// stateFileSuffix is the extension of the files persisting the state of pods.
+const stateFileSuffix = ".json"
+
+// podState is the state of a pod persisted to the node's state directory,
+// enough to recover the pod along with its enclave when the node restarts.
+// Container environments hold resolved secrets, so state files are only
+// readable by their owner.
+type podState struct {
+	Pod        *corev1.Pod                    `json:"pod"`
+	Config     cli.EnclaveConfig              `json:"config"`
+	Containers map[string]containerDefinition `json:"containers"`
+	Tmpfs      []agent.TmpfsMount             `json:"tmpfs,omitempty"`
+	Restarts   int32                          `json:"restarts"`
+	StartTime  metav1.Time                    `json:"startTime,omitempty"`
+	StartedAt  metav1.Time                    `json:"startedAt,omitempty"`
+}
+
+// stateDir returns the directory where the pod state is persisted, if any.
+func (pod *Pod) stateDir() string {
+	if pod.node == nil {
+		return ""
+	}
+	return pod.node.stateDir
+}
+
+// statePath returns the file persisting the state of the pod. Namespaces and
+// names cannot contain "_", so the file name is unambiguous.
+func (pod *Pod) statePath() string {
+	return filepath.Join(pod.stateDir(), fmt.Sprintf("%s_%s_%s%s", pod.namespace, pod.name, pod.uid, stateFileSuffix))
+}
+
+// saveState persists the state of the pod, replacing the previous state atomically.
+func (pod *Pod) saveState() error {
+	if pod.stateDir() == "" {
+		return nil
+	}
+
+	pod.mu.RLock()
+	state := podState{
+		Pod:        pod.pod,
+		Config:     pod.config,
+		Containers: make(map[string]containerDefinition, len(pod.containers)),
+		Tmpfs:      pod.tmpfs,
+		Restarts:   pod.restarts,
+		StartTime:  pod.startTime,
+		StartedAt:  pod.startedAt,
+	}
+	for name, c := range pod.containers {
+		state.Containers[name] = c.definition
+	}
+	data, err := json.Marshal(state)
+	pod.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if state.Pod == nil {
+		// Pods adopted from enclaves launched before pods were persisted have no spec to save.
+		return nil
+	}
+
+	if err := os.MkdirAll(pod.stateDir(), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(pod.stateDir(), ".state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), pod.statePath())
+}
+
+// loadState restores the persisted state of the pod. It returns false when
+// the pod has no persisted state.
+func (pod *Pod) loadState() (bool, error) {
+	if pod.stateDir() == "" {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(pod.statePath())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var state podState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("invalid state %s: %v", pod.statePath(), err)
+	}
+	if state.Pod == nil {
+		return false, fmt.Errorf("invalid state %s: missing pod", pod.statePath())
+	}
+
+	pod.mu.Lock()
+	defer pod.mu.Unlock()
+	pod.pod = state.Pod
+	pod.config = state.Config
+	pod.tmpfs = state.Tmpfs
+	pod.restarts = state.Restarts
+	pod.startTime = state.StartTime
+	pod.startedAt = state.StartedAt
+	if pod.startTime.IsZero() {
+		// States saved before the start time was persisted.
+		pod.startTime = state.StartedAt
+	}
+	pod.containers = make(map[string]*container, len(state.Containers))
+	for name, d := range state.Containers {
+		pod.containers[name] = &container{definition: d}
+	}
+	pod.ports = nil
+	for i := range state.Pod.Spec.Containers {
+		pod.ports = append(pod.ports, containerPorts(&state.Pod.Spec.Containers[i])...)
+	}
+	return true, nil
+}
+
+// removeState deletes the persisted state of the pod.
+func (pod *Pod) removeState() error {
+	if pod.stateDir() == "" {
+		return nil
+	}
+	if err := os.Remove(pod.statePath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// pruneStates deletes the persisted state of pods whose enclave is gone.
+// Pods which still exist are created again by the pod controller.
+func (n *Node) pruneStates(ctx context.Context, pods map[string]*Pod) {
+	if n.stateDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(n.stateDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.G(ctx).Warnf("failed to read pod states: %v", err)
+		}
+		return
+	}
+
+	keep := make(map[string]bool)
+	for _, pod := range pods {
+		keep[filepath.Base(pod.statePath())] = true
+	}
+	for _, entry := range entries {
+		if keep[entry.Name()] || !strings.HasSuffix(entry.Name(), stateFileSuffix) {
+			continue
+		}
+		log.G(ctx).Infof("Removing state of terminated pod %s.", strings.TrimSuffix(entry.Name(), stateFileSuffix))
+		if err := os.Remove(filepath.Join(n.stateDir, entry.Name())); err != nil {
+			log.G(ctx).Warnf("failed to remove pod state: %v", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodState(t *testing.T) {
+	dir := t.TempDir()
+	n := &Node{stateDir: dir}
+
+	spec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx", UID: "1234"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "nginx",
+				Image: "nginx:1.25",
+				Env:   []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+				Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: 8080, Protocol: corev1.ProtocolTCP}},
+			}},
+		},
+	}
+	pod, err := NewPod(context.Background(), nil, spec)
+	assert.Nil(t, err)
+	pod.node = n
+	pod.config.EnclaveCid = 17
+	pod.restarts = 2
+	assert.Nil(t, pod.saveState())
+
+	info, err := os.Stat(pod.statePath())
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A pod adopted from its enclave recovers its spec.
+	restored, err := NewPodFromTag(n, pod.tag)
+	assert.Nil(t, err)
+	ok, err := restored.loadState()
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, spec, restored.pod)
+	assert.Equal(t, pod.config, restored.config)
+	assert.Equal(t, pod.ports, restored.ports)
+	assert.Equal(t, int32(2), restored.restarts)
+	assert.Equal(t, "bar", restored.containers["nginx"].definition.Environment["FOO"])
+
+	// The state of pods without an enclave is pruned.
+	other := filepath.Join(dir, "default_gone_5678"+stateFileSuffix)
+	assert.Nil(t, os.WriteFile(other, []byte("{}"), 0600))
+	n.pruneStates(context.Background(), map[string]*Pod{pod.tag: restored})
+	_, err = os.Stat(other)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(pod.statePath())
+	assert.Nil(t, err)
+
+	assert.Nil(t, restored.removeState())
+	ok, err = restored.loadState()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
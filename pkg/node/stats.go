@@ -0,0 +1,178 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	stats "github.com/virtual-kubelet/virtual-kubelet/node/api/statsv1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Clock ticks per second of the times in /proc/<pid>/stat, as on every Linux host.
+	clockTicks = 100
+)
+
+// procPath is swapped out in tests.
+var procPath = "/proc"
+
+// processUsage is a sample of the resources used by a process.
+type processUsage struct {
+	time       time.Time
+	cpuNanos   uint64
+	rssBytes   uint64
+	cpuSampled bool
+}
+
+// readProcessUsage reads the CPU time and resident memory of a process.
+func readProcessUsage(pid int) (processUsage, error) {
+	usage := processUsage{time: time.Now()}
+
+	data, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return usage, err
+	}
+
+	// The command name may contain spaces, the fields start after its closing parenthesis.
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return usage, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+	// Fields are numbered from the state, the 3rd field of the stat file.
+	if len(fields) < 22 {
+		return usage, fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return usage, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return usage, err
+	}
+	rss, err := strconv.ParseUint(fields[21], 10, 64)
+	if err != nil {
+		return usage, err
+	}
+
+	usage.cpuNanos = (utime + stime) * uint64(time.Second/clockTicks)
+	usage.rssBytes = rss * uint64(os.Getpagesize())
+	usage.cpuSampled = true
+	return usage, nil
+}
+
+// GetStatsSummary returns the resource usage of the pods running on this node.
+// The memory of an enclave is fully committed at launch, so it is reported as
+// in use along with the memory of the enclave's process on the host.
+func (n *Node) GetStatsSummary(ctx context.Context) (*stats.Summary, error) {
+	pods, err := n.GetPods()
+	if err != nil {
+		return nil, err
+	}
+
+	now := metav1.Now()
+	summary := &stats.Summary{
+		Node: stats.NodeStats{
+			NodeName:  n.name,
+			StartTime: metav1.NewTime(n.startTime),
+		},
+	}
+
+	var nodeCPU, nodeCPURate, nodeMemory uint64
+	for _, pod := range pods {
+		podStats, ok := pod.stats(now)
+		if !ok {
+			continue
+		}
+		summary.Pods = append(summary.Pods, *podStats)
+
+		nodeCPU += *podStats.CPU.UsageCoreNanoSeconds
+		if podStats.CPU.UsageNanoCores != nil {
+			nodeCPURate += *podStats.CPU.UsageNanoCores
+		}
+		nodeMemory += *podStats.Memory.WorkingSetBytes
+	}
+
+	summary.Node.CPU = &stats.CPUStats{
+		Time:                 now,
+		UsageCoreNanoSeconds: &nodeCPU,
+		UsageNanoCores:       &nodeCPURate,
+	}
+	summary.Node.Memory = &stats.MemoryStats{
+		Time:            now,
+		UsageBytes:      &nodeMemory,
+		WorkingSetBytes: &nodeMemory,
+	}
+
+	return summary, nil
+}
+
+// stats returns the resource usage of the pod, if its enclave is running.
+func (pod *Pod) stats(now metav1.Time) (*stats.PodStats, bool) {
+	pod.mu.Lock()
+	defer pod.mu.Unlock()
+
+	if pod.state != containerRunning || pod.info.ProcessID == 0 {
+		return nil, false
+	}
+
+	usage, err := readProcessUsage(pod.info.ProcessID)
+	if err != nil {
+		return nil, false
+	}
+
+	// The usage rate is averaged since the previous sample.
+	var rate *uint64
+	if last := pod.lastUsage; last.cpuSampled && usage.cpuNanos >= last.cpuNanos {
+		if elapsed := usage.time.Sub(last.time); elapsed > 0 {
+			r := uint64(float64(usage.cpuNanos-last.cpuNanos) / elapsed.Seconds())
+			rate = &r
+		}
+	}
+	pod.lastUsage = usage
+
+	cpuNanos := usage.cpuNanos
+	memory := uint64(pod.info.MemoryMiB)*1024*1024 + usage.rssBytes
+	cpu := &stats.CPUStats{
+		Time:                 now,
+		UsageNanoCores:       rate,
+		UsageCoreNanoSeconds: &cpuNanos,
+	}
+	mem := &stats.MemoryStats{
+		Time:            now,
+		UsageBytes:      &memory,
+		WorkingSetBytes: &memory,
+	}
+
+	var containerName string
+	for name := range pod.containers {
+		containerName = name
+	}
+
+	return &stats.PodStats{
+		PodRef: stats.PodReference{
+			Name:      pod.name,
+			Namespace: pod.namespace,
+			UID:       string(pod.uid),
+		},
+		StartTime: pod.startedAt,
+		Containers: []stats.ContainerStats{
+			{
+				Name:      containerName,
+				StartTime: pod.startedAt,
+				CPU:       cpu,
+				Memory:    mem,
+			},
+		},
+		CPU:    cpu,
+		Memory: mem,
+	}, true
+}
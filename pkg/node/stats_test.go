@@ -0,0 +1,27 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProcessUsage(t *testing.T) {
+	procPath = t.TempDir()
+	defer func() { procPath = "/proc" }()
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(procPath, "42"), 0755))
+	stat := "42 (nitro-cli (run)) S 1 42 42 0 -1 4194560 1234 0 0 0 150 50 0 0 20 0 4 0 12345 123456789 2048 18446744073709551615"
+	assert.Nil(t, os.WriteFile(filepath.Join(procPath, "42", "stat"), []byte(stat), 0644))
+
+	usage, err := readProcessUsage(42)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2*time.Second), usage.cpuNanos)
+	assert.Equal(t, uint64(2048*os.Getpagesize()), usage.rssBytes)
+
+	_, err = readProcessUsage(43)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// Prefix of enclave names encoding the pod as length-prefixed fields.
+	enclaveTagPrefix = "vk-pod:"
+	// Prefix of enclave names launched by earlier versions, with fields separated by "_".
+	legacyEnclaveNamePrefix = "vk-podspec"
+)
+
+// encodeTag encodes the namespace, name and UID of a pod as an enclave name.
+// Every field is prefixed by its length, so the encoding remains unambiguous
+// whatever the fields contain and more can be appended later.
+func encodeTag(namespace, name string, uid k8sTypes.UID) string {
+	var b strings.Builder
+	b.WriteString(enclaveTagPrefix)
+	for _, field := range []string{namespace, name, string(uid)} {
+		b.WriteString(strconv.Itoa(len(field)))
+		b.WriteByte(':')
+		b.WriteString(field)
+	}
+	return b.String()
+}
+
+// decodeTag returns the namespace, name and UID of the pod encoded in an
+// enclave name, which may be in the legacy format.
+func decodeTag(tag string) (namespace, name string, uid k8sTypes.UID, err error) {
+	if strings.HasPrefix(tag, legacyEnclaveNamePrefix+"_") {
+		return decodeLegacyTag(tag)
+	}
+	if !strings.HasPrefix(tag, enclaveTagPrefix) {
+		return "", "", "", fmt.Errorf("invalid tag")
+	}
+
+	rest := tag[len(enclaveTagPrefix):]
+	var fields []string
+	for rest != "" {
+		i := strings.IndexByte(rest, ':')
+		if i < 0 {
+			return "", "", "", fmt.Errorf("invalid tag: missing field length")
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil || n < 0 || n > len(rest)-i-1 {
+			return "", "", "", fmt.Errorf("invalid tag: bad field length %q", rest[:i])
+		}
+		fields = append(fields, rest[i+1:i+1+n])
+		rest = rest[i+1+n:]
+	}
+	// Unknown trailing fields are ignored so newer tags can still be read.
+	if len(fields) < 3 || fields[0] == "" || fields[1] == "" {
+		return "", "", "", fmt.Errorf("invalid tag: missing fields")
+	}
+	return fields[0], fields[1], k8sTypes.UID(fields[2]), nil
+}
+
+// decodeLegacyTag decodes prefix_namespace_name[_uid] enclave names.
+func decodeLegacyTag(tag string) (namespace, name string, uid k8sTypes.UID, err error) {
+	data := strings.Split(tag, "_")
+	// Enclaves launched before the UID was part of the tag have no UID.
+	if len(data) < 3 || len(data) > 4 ||
+		data[0] != legacyEnclaveNamePrefix {
+		return "", "", "", fmt.Errorf("invalid tag")
+	}
+	if len(data) == 4 {
+		uid = k8sTypes.UID(data[3])
+	}
+	return data[1], data[2], uid, nil
+}
@@ -0,0 +1,35 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+func TestTag(t *testing.T) {
+	for _, c := range []struct {
+		namespace, name string
+		uid             k8sTypes.UID
+	}{
+		{"default", "nginx", "1234"},
+		{"a_b", "c_d", ""},
+		{"ns:5", "12:x", "e2b1f1a0-0d6c-4d1e-9a9b-5b0b0e6e3b1c"},
+	} {
+		namespace, name, uid, err := decodeTag(encodeTag(c.namespace, c.name, c.uid))
+		assert.Nil(t, err)
+		assert.Equal(t, c.namespace, namespace)
+		assert.Equal(t, c.name, name)
+		assert.Equal(t, c.uid, uid)
+	}
+
+	// Enclaves launched with the legacy encoding are still recognized.
+	namespace, name, uid, err := decodeTag("vk-podspec_default_nginx_1234")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"default", "nginx", "1234"}, []string{namespace, name, string(uid)})
+
+	for _, invalid := range []string{"", "other-enclave", "vk-pod:", "vk-pod:7:default", "vk-pod:7:default5:nginx9:12", "vk-podspec_a"} {
+		_, _, _, err := decodeTag(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,52 @@
+package node
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// DedicatedTaintKey is the key of the default dedicated taint of enclave
+	// nodes, which keeps the pods not meant for enclaves off them.
+	DedicatedTaintKey = "aws.ec2.nitro/enclave"
+	// DedicatedTaintNone disables the dedicated taint.
+	DedicatedTaintNone = "none"
+
+	// AdmissionReasonUntolerated rejects the pods which do not tolerate the
+	// dedicated taint of the node, such as those bound without the scheduler.
+	AdmissionReasonUntolerated = "UntoleratedTaint"
+)
+
+// ParseDedicatedTaint parses a dedicated taint written "key=value" or
+// "key", whose effect is NoSchedule. It returns nil for DedicatedTaintNone.
+func ParseDedicatedTaint(spec string) (*corev1.Taint, error) {
+	if spec == DedicatedTaintNone {
+		return nil, nil
+	}
+	key, value, _ := strings.Cut(spec, "=")
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid key %q: %s", key, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid value %q: %s", value, strings.Join(errs, ", "))
+	}
+	return &corev1.Taint{Key: key, Value: value, Effect: corev1.TaintEffectNoSchedule}, nil
+}
+
+// taintError returns why a pod cannot run on a node with the given
+// dedicated taint, nil if it tolerates it or there is none. The scheduler
+// keeps such pods off the node, but pods bound to it by name skip it.
+func taintError(taint *corev1.Taint, pod *corev1.Pod) *AdmissionError {
+	if taint == nil {
+		return nil
+	}
+	for i := range pod.Spec.Tolerations {
+		if pod.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return nil
+		}
+	}
+	return unsupportedf(AdmissionReasonUntolerated, "pods running in enclaves on this node must tolerate the %s taint", taint.ToString())
+}
@@ -0,0 +1,59 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/allocator"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDedicatedTaint(t *testing.T) {
+	defer func(f func() (*allocator.Capacity, error)) { readCapacity = f }(readCapacity)
+	readCapacity = func() (*allocator.Capacity, error) { return nil, errors.New("no allocator") }
+	defer func(f func() ([]cli.EnclaveInfo, error)) { describeEnclaves = f }(describeEnclaves)
+	describeEnclaves = func() ([]cli.EnclaveInfo, error) { return nil, errors.New("no nitro-cli") }
+
+	taint, err := ParseDedicatedTaint(DedicatedTaintKey + "=true")
+	assert.Nil(t, err)
+	assert.Equal(t, &corev1.Taint{Key: DedicatedTaintKey, Value: "true", Effect: corev1.TaintEffectNoSchedule}, taint)
+	taint, err = ParseDedicatedTaint(DedicatedTaintNone)
+	assert.Nil(t, err)
+	assert.Nil(t, taint)
+	_, err = ParseDedicatedTaint("not a key=true")
+	assert.NotNil(t, err)
+
+	n := &Node{pods: make(map[string]*Pod), current: make(map[string]string), dedicatedTaint: &corev1.Taint{
+		Key: DedicatedTaintKey, Value: "true", Effect: corev1.TaintEffectNoSchedule,
+	}}
+	spec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "web",
+			Image: "web",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}},
+		}}},
+	}
+
+	// Pods must tolerate the dedicated taint, even when bound by name.
+	_, err = NewPod(context.Background(), n, spec)
+	var rejection *AdmissionError
+	if assert.ErrorAs(t, err, &rejection) {
+		assert.Equal(t, AdmissionReasonUntolerated, rejection.Reason)
+		assert.True(t, rejection.Terminal)
+	}
+	spec.Spec.Tolerations = []corev1.Toleration{{Key: DedicatedTaintKey, Value: "false", Effect: corev1.TaintEffectNoSchedule}}
+	_, err = NewPod(context.Background(), n, spec)
+	assert.ErrorAs(t, err, &rejection)
+
+	spec.Spec.Tolerations = []corev1.Toleration{{Key: DedicatedTaintKey, Operator: corev1.TolerationOpExists}}
+	_, err = NewPod(context.Background(), n, spec)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// statusThrottle coalesces the status notifications of pods, so pods whose
+// status flaps do not hammer the API server. A pod notified less than the
+// interval after its last notification is sent once the interval elapsed,
+// with its latest status only.
+type statusThrottle struct {
+	interval time.Duration
+	send     func(*corev1.Pod)
+
+	mu sync.Mutex
+	// last is when the status of pods was last sent, by UID.
+	last map[string]time.Time
+	// pending are the latest statuses of pods waiting for their interval, by UID.
+	pending map[string]*corev1.Pod
+}
+
+func newStatusThrottle(interval time.Duration, send func(*corev1.Pod)) *statusThrottle {
+	return &statusThrottle{
+		interval: interval,
+		send:     send,
+		last:     make(map[string]time.Time),
+		pending:  make(map[string]*corev1.Pod),
+	}
+}
+
+// notify sends the status of a pod, or holds it until the interval since
+// the last status of the pod elapsed.
+func (t *statusThrottle) notify(pod *corev1.Pod) {
+	key := throttleKey(pod)
+	now := time.Now()
+
+	t.mu.Lock()
+	if _, ok := t.pending[key]; ok {
+		// The held status is replaced, its timer sends this one.
+		t.pending[key] = pod
+		t.mu.Unlock()
+		return
+	}
+	if wait := t.interval - now.Sub(t.last[key]); wait > 0 {
+		t.pending[key] = pod
+		time.AfterFunc(wait, func() { t.flush(key) })
+		t.mu.Unlock()
+		return
+	}
+	t.last[key] = now
+	// Forget the pods notified long ago, such as deleted pods.
+	for k, last := range t.last {
+		if now.Sub(last) >= t.interval {
+			delete(t.last, k)
+		}
+	}
+	t.mu.Unlock()
+
+	t.send(pod)
+}
+
+// flush sends the status of a pod held by notify.
+func (t *statusThrottle) flush(key string) {
+	t.mu.Lock()
+	pod, ok := t.pending[key]
+	delete(t.pending, key)
+	t.last[key] = time.Now()
+	t.mu.Unlock()
+
+	if ok {
+		t.send(pod)
+	}
+}
+
+// throttleKey identifies a pod, whose recreations have a new UID.
+func throttleKey(pod *corev1.Pod) string {
+	if pod.UID != "" {
+		return string(pod.UID)
+	}
+	return podKey(pod.Namespace, pod.Name)
+}
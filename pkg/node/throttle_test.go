@@ -0,0 +1,44 @@
+package node
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+)
+
+func TestStatusThrottle(t *testing.T) {
+	var mu sync.Mutex
+	var sent []corev1.PodPhase
+	throttle := newStatusThrottle(100*time.Millisecond, func(pod *corev1.Pod) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, pod.Status.Phase)
+	})
+	notify := func(uid string, phase corev1.PodPhase) {
+		throttle.notify(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: k8sTypes.UID(uid)}, Status: corev1.PodStatus{Phase: phase}})
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent)
+	}
+
+	// The first status is sent right away, the flaps following it are coalesced.
+	notify("1", corev1.PodPending)
+	notify("1", corev1.PodRunning)
+	notify("1", corev1.PodFailed)
+	assert.Equal(t, 1, count())
+	// Other pods are not held back.
+	notify("2", corev1.PodPending)
+	assert.Equal(t, 2, count())
+
+	assert.Eventually(t, func() bool { return count() == 3 }, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []corev1.PodPhase{corev1.PodPending, corev1.PodPending, corev1.PodFailed}, sent)
+	mu.Unlock()
+}
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"context"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// Update applies an updated spec of the pod. Changes to the containers, such
+// as a new image, are baked into the enclave image so the enclave is rebuilt
+// and relaunched, counting as a restart. Other mutable fields, labels and
+// annotations are applied in place. The pod running the updated spec is returned.
+func (pod *Pod) Update(ctx context.Context, spec *corev1.Pod) (*Pod, error) {
+	pod.mu.Lock()
+	current := pod.pod
+	finished := pod.phase == corev1.PodSucceeded || pod.phase == corev1.PodFailed
+	if current == nil || finished || !requiresRebuild(current, spec) {
+		// Pods adopted from existing enclaves have no spec to compare with,
+		// their enclave is assumed to run the spec they are updated with.
+		// Finished pods are not relaunched.
+		pod.pod = spec.DeepCopy()
+		pod.mu.Unlock()
+
+		log.G(ctx).Infof("updated pod %s/%s in place", pod.namespace, pod.name)
+		if err := pod.saveState(); err != nil {
+			log.G(ctx).Errorf("Failed to save pod state: %v.\n", err)
+		}
+		pod.notify(ctx)
+		return pod, nil
+	}
+	restarts := pod.restarts
+	pod.killMessage = "the container definition changed, the enclave is rebuilt"
+	killMessage := pod.killMessage
+	pod.mu.Unlock()
+
+	log.G(ctx).Infof("rebuilding enclave of pod %s/%s after its containers changed", pod.namespace, pod.name)
+
+	// The new pod has the same tag, and replaces this one in the node.
+	updated, err := NewPod(ctx, pod.node, spec)
+	if err != nil {
+		return nil, err
+	}
+	pod.shutdown(ctx, killMessage)
+
+	updated.restarts = restarts + 1
+	updated.lastTermination = pod.terminated()
+	if err := updated.Start(ctx); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// rebuildAnnotations are the annotations applied when the enclave is launched.
+var rebuildAnnotations = []string{DebugModeAnnotation, EgressAnnotation,
+	KMSRegionAnnotation, KMSEndpointAnnotation, KMSPortAnnotation,
+	DNSAnnotation, DNSAllowAnnotation, DNSDenyAnnotation,
+	MaxConnectionsAnnotation, ConnectionIdleTimeoutAnnotation, ConnectionDrainTimeoutAnnotation,
+	BindAddressAnnotation, SNIHostnamesAnnotation, CIDAnnotation, ProfileAnnotation, RATLSAnnotation,
+	ExpectedPCRAnnotation(0), ExpectedPCRAnnotation(1), ExpectedPCRAnnotation(2), ExpectedPCRAnnotation(8),
+	KMSSecretsAnnotation, VaultAddressAnnotation, VaultRoleAnnotation, VaultAuthAnnotation, VaultAuthPathAnnotation}
+
+// requiresRebuild tells whether the enclave must be rebuilt to apply the
+// updated spec, including the annotations applied when it is launched.
+func requiresRebuild(current, updated *corev1.Pod) bool {
+	for _, annotation := range rebuildAnnotations {
+		// Some annotations, such as RATLSAnnotation, apply even when empty.
+		value, ok := current.Annotations[annotation]
+		if updatedValue, updatedOK := updated.Annotations[annotation]; value != updatedValue || ok != updatedOK {
+			return true
+		}
+	}
+	return !equality.Semantic.DeepEqual(current.Spec.Containers, updated.Spec.Containers) ||
+		!equality.Semantic.DeepEqual(current.Spec.InitContainers, updated.Spec.InitContainers)
+}
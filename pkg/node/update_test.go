@@ -0,0 +1,37 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdate(t *testing.T) {
+	spec := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx", UID: "1234"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "nginx", Image: "nginx:1.25"}},
+		},
+	}
+	pod, err := NewPod(context.Background(), nil, spec)
+	assert.Nil(t, err)
+
+	// Metadata changes are applied in place.
+	relabeled := spec.DeepCopy()
+	relabeled.Labels = map[string]string{"app": "web"}
+	assert.False(t, requiresRebuild(spec, relabeled))
+	updated, err := pod.Update(context.Background(), relabeled)
+	assert.Nil(t, err)
+	assert.Equal(t, pod, updated)
+	current, err := pod.GetSpec()
+	assert.Nil(t, err)
+	assert.Equal(t, "web", current.Labels["app"])
+
+	// A new image needs a new enclave image.
+	upgraded := relabeled.DeepCopy()
+	upgraded.Spec.Containers[0].Image = "nginx:1.26"
+	assert.True(t, requiresRebuild(relabeled, upgraded))
+}
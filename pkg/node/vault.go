@@ -0,0 +1,111 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// VaultAddressAnnotation is the URL of the Vault server of a pod, as the
+	// node reaches it, such as https://vault.vault.svc:8200. The node forwards
+	// the connections of the enclave to it over vsock, TLS passing through.
+	VaultAddressAnnotation = annotationPrefix + "vault-address"
+	// VaultRoleAnnotation lets the agent log into Vault as the role before
+	// starting the workload, and keep its token in agent.VaultTokenFile.
+	VaultRoleAnnotation = annotationPrefix + "vault-role"
+	// VaultAuthAnnotation is the auth method the agent logs in with:
+	// kubernetes, the default, with the token of the pod's service account,
+	// or attestation, with an attestation document of the enclave.
+	VaultAuthAnnotation = annotationPrefix + "vault-auth"
+	// VaultAuthPathAnnotation is the mount path of the auth method, the
+	// name of the method by default.
+	VaultAuthPathAnnotation = annotationPrefix + "vault-auth-path"
+
+	// vaultAddress is where the agent accepts the Vault connections of the
+	// workload, which it forwards to the pod's Vault server.
+	vaultAddress = "127.0.0.1:8200"
+)
+
+// vault is the Vault server of a pod and how its enclave logs into it.
+type vault struct {
+	url *url.URL
+	// hostPort is the TCP destination of the forwarded connections.
+	hostPort string
+	role     string
+	auth     string
+	authPath string
+}
+
+// vaultConfig returns the Vault server configured by the annotations of a
+// pod, nil when it has none.
+func vaultConfig(pod *corev1.Pod) (*vault, error) {
+	address, ok := pod.Annotations[VaultAddressAnnotation]
+	if !ok {
+		for _, annotation := range []string{VaultRoleAnnotation, VaultAuthAnnotation, VaultAuthPathAnnotation} {
+			if _, ok := pod.Annotations[annotation]; ok {
+				return nil, fmt.Errorf("%s requires %s", annotation, VaultAddressAnnotation)
+			}
+		}
+		return nil, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("invalid %s %q, expected http[s]://host[:port]", VaultAddressAnnotation, address)
+	}
+	v := &vault{
+		url:      u,
+		hostPort: u.Host,
+		role:     pod.Annotations[VaultRoleAnnotation],
+		auth:     agent.VaultAuthKubernetes,
+		authPath: pod.Annotations[VaultAuthPathAnnotation],
+	}
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		v.hostPort = net.JoinHostPort(u.Hostname(), port)
+	}
+	if auth, ok := pod.Annotations[VaultAuthAnnotation]; ok {
+		if auth != agent.VaultAuthKubernetes && auth != agent.VaultAuthAttestation {
+			return nil, fmt.Errorf("invalid %s %q, expected %s or %s", VaultAuthAnnotation, auth, agent.VaultAuthKubernetes, agent.VaultAuthAttestation)
+		}
+		v.auth = auth
+	}
+	return v, nil
+}
+
+// vault returns the Vault server of the pod, if any.
+func (pod *Pod) vault() *vault {
+	pod.mu.RLock()
+	spec := pod.pod
+	pod.mu.RUnlock()
+	if spec == nil {
+		return nil
+	}
+	// The annotations were validated when the pod was created.
+	v, _ := vaultConfig(spec)
+	return v
+}
+
+// vaultFlags returns the flags of the agent forwarding the Vault connections
+// of the pod and logging into Vault, none when it has no Vault server.
+func (pod *Pod) vaultFlags() []string {
+	v := pod.vault()
+	if v == nil {
+		return nil
+	}
+	flags := []string{"-vault=" + vaultAddress, "-vault-url=" + v.url.String()}
+	if v.role != "" {
+		flags = append(flags, "-vault-role="+v.role, "-vault-auth="+v.auth)
+		if v.authPath != "" {
+			flags = append(flags, "-vault-auth-path="+v.authPath)
+		}
+	}
+	return flags
+}
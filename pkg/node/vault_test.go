@@ -0,0 +1,44 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVaultConfig(t *testing.T) {
+	spec := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+	pod := &Pod{pod: spec}
+	v, err := vaultConfig(spec)
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	assert.Empty(t, pod.vaultFlags())
+
+	spec.Annotations[VaultAddressAnnotation] = "https://vault.vault.svc"
+	v, err = vaultConfig(spec)
+	assert.Nil(t, err)
+	assert.Equal(t, "vault.vault.svc:443", v.hostPort)
+	assert.Equal(t, []string{"-vault=127.0.0.1:8200", "-vault-url=https://vault.vault.svc"}, pod.vaultFlags())
+
+	spec.Annotations[VaultAddressAnnotation] = "http://vault.vault.svc:8200"
+	spec.Annotations[VaultRoleAnnotation] = "web"
+	spec.Annotations[VaultAuthAnnotation] = "attestation"
+	spec.Annotations[VaultAuthPathAnnotation] = "nitro"
+	v, err = vaultConfig(spec)
+	assert.Nil(t, err)
+	assert.Equal(t, "vault.vault.svc:8200", v.hostPort)
+	assert.Equal(t, []string{"-vault=127.0.0.1:8200", "-vault-url=http://vault.vault.svc:8200",
+		"-vault-role=web", "-vault-auth=attestation", "-vault-auth-path=nitro"}, pod.vaultFlags())
+
+	for _, invalid := range []map[string]string{
+		{VaultAddressAnnotation: "vault.vault.svc:8200"},
+		{VaultAddressAnnotation: "https://vault.vault.svc/v1"},
+		{VaultAddressAnnotation: "https://vault.vault.svc", VaultAuthAnnotation: "approle"},
+		{VaultRoleAnnotation: "web"},
+	} {
+		_, err := vaultConfig(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: invalid}})
+		assert.NotNil(t, err, invalid)
+	}
+}
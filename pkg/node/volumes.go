@@ -0,0 +1,41 @@
+package node
+
+import (
+	"path"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultEmptyDirSizeMiB bounds emptyDir volumes which have no sizeLimit.
+const defaultEmptyDirSizeMiB int64 = 64
+
+// emptyDirMounts returns the emptyDir volumes mounted in a container as the
+// tmpfs mounts of its enclave. Enclaves have no disk, so every emptyDir is
+// memory backed whatever its medium, and bounded by its sizeLimit. A volume
+// mounted more than once is only mounted at its first mount path.
+func emptyDirMounts(pod *corev1.Pod, container *corev1.Container) []agent.TmpfsMount {
+	sizes := make(map[string]int64)
+	for _, v := range pod.Spec.Volumes {
+		if v.EmptyDir == nil {
+			continue
+		}
+		size := defaultEmptyDirSizeMiB
+		if limit := v.EmptyDir.SizeLimit; limit != nil && !limit.IsZero() {
+			// Round up to the next MiB, like container memory.
+			size = (limit.Value() + MiB - 1) / MiB
+		}
+		sizes[v.Name] = size
+	}
+
+	var mounts []agent.TmpfsMount
+	for _, m := range container.VolumeMounts {
+		size, ok := sizes[m.Name]
+		if !ok {
+			continue
+		}
+		delete(sizes, m.Name)
+		mounts = append(mounts, agent.TmpfsMount{Path: path.Clean(m.MountPath), SizeMiB: size})
+	}
+	return mounts
+}
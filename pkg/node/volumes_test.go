@@ -0,0 +1,44 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEmptyDirMounts(t *testing.T) {
+	limit := resource.MustParse("100M")
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: &limit}}},
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "unused", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+			Containers: []corev1.Container{{
+				Name:  "nginx",
+				Image: "nginx",
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "cache", MountPath: "/var/cache/nginx/"},
+					{Name: "scratch", MountPath: "/tmp"},
+				},
+			}},
+		},
+	}
+
+	nitroPod, err := NewPod(context.Background(), nil, pod)
+	assert.Nil(t, err)
+	assert.Equal(t, []agent.TmpfsMount{
+		{Path: "/var/cache/nginx", SizeMiB: 96},
+		{Path: "/tmp", SizeMiB: defaultEmptyDirSizeMiB},
+	}, nitroPod.tmpfs)
+
+	// The volumes are counted against the enclave's memory.
+	assert.Equal(t, containerDefaultMemoryLimit+96+defaultEmptyDirSizeMiB, nitroPod.config.MemoryMib)
+}
@@ -0,0 +1,82 @@
+package node
+
+import (
+	"context"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/utils/wait"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// exitContext returns a context which outlives the request that started the
+// pod and is cancelled once the pod's exit channel is closed.
+func exitContext(ctx context.Context, exit chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(log.WithLogger(context.Background(), log.G(ctx)))
+	go func() {
+		select {
+		case <-exit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// waitForExit blocks until the enclave terminates or the context is done.
+// The enclave's nitro-cli process is followed through a pidfd, falling back to
+// polling describe-enclaves when the process cannot be watched.
+func waitForExit(ctx context.Context, info cli.EnclaveInfo) error {
+	err := wait.ForPIDContext(ctx, info.ProcessID)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+
+	log.G(ctx).Debugf("cannot watch enclave process %d, polling enclave %s instead: %v", info.ProcessID, info.EnclaveID, err)
+	return cli.WaitForState(ctx, info.EnclaveID, cli.StateTerminated, 0)
+}
+
+// watch follows an enclave adopted from a previous kubelet run and marks the
+// pod Failed the moment it exits, unless the pod is being stopped.
+func (pod *Pod) watch(ctx context.Context, exit chan struct{}) {
+	ctx, cancel := exitContext(ctx, exit)
+	defer cancel()
+
+	pod.mu.RLock()
+	info := pod.info
+	pod.mu.RUnlock()
+
+	if err := pod.waitForEnclave(ctx, info); err != nil {
+		return
+	}
+	log.G(ctx).Infof("enclave terminated %+v", info)
+
+	select {
+	case <-exit:
+		return
+	default:
+	}
+	pod.setPhase(ctx, corev1.PodFailed, podReasonEnclaveExited, "the enclave exited")
+}
+
+// waitForEnclave blocks until the enclave of the pod terminates, or is found
+// gone by the reconciliation loop, or the context is done.
+func (pod *Pod) waitForEnclave(ctx context.Context, info cli.EnclaveInfo) error {
+	waitCtx, lost := context.WithCancel(ctx)
+	defer lost()
+	pod.mu.Lock()
+	pod.lost = lost
+	pod.mu.Unlock()
+	defer func() {
+		pod.mu.Lock()
+		pod.lost = nil
+		pod.mu.Unlock()
+	}()
+
+	err := waitForExit(waitCtx, info)
+	if err != nil && ctx.Err() == nil {
+		// The enclave was found gone.
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+// Package policy implements the EnclavePolicy custom resource, which lists
+// the measurements the enclaves of the pods of a namespace may have.
+package policy
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// Group, Version and Resource locate the EnclavePolicy API.
+	Group    = "nitro-enclave-kubelet.brave.com"
+	Version  = "v1alpha1"
+	Resource = "enclavepolicies"
+	Kind     = "EnclavePolicy"
+)
+
+// ErrNotInstalled is returned by List when the EnclavePolicy resource is not installed.
+var ErrNotInstalled = errors.New("the EnclavePolicy resource is not installed")
+
+// EnclavePolicy restricts the enclaves of the pods of its namespace it
+// selects to the measurements it allows.
+type EnclavePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EnclavePolicySpec `json:"spec"`
+}
+
+// EnclavePolicySpec is the specification of an EnclavePolicy.
+type EnclavePolicySpec struct {
+	// Selector selects the pods of the namespace the policy applies to,
+	// every pod when nil.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Allowed lists the measurements the enclaves may have. An enclave
+	// complies with the policy when it matches one of them, and none when empty.
+	Allowed []Measurements `json:"allowed"`
+}
+
+// EnclavePolicyList is a list of EnclavePolicy.
+type EnclavePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []EnclavePolicy `json:"items"`
+}
+
+// Measurements are the platform configuration registers of an enclave
+// image, in hex: PCR0 hashes the image, PCR1 its kernel and boot ramdisk,
+// PCR2 the application, and PCR8 the certificate it is signed with, which
+// alone allows every image of a signing identity.
+type Measurements struct {
+	PCR0 string `json:"pcr0,omitempty"`
+	PCR1 string `json:"pcr1,omitempty"`
+	PCR2 string `json:"pcr2,omitempty"`
+	PCR8 string `json:"pcr8,omitempty"`
+
+	// unknown are the keys of the decoded entry which are not PCRs.
+	unknown []string
+}
+
+// UnmarshalJSON decodes measurements, recording their unknown keys for Validate.
+func (m *Measurements) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	type measurements Measurements
+	*m = Measurements{}
+	if err := json.Unmarshal(data, (*measurements)(m)); err != nil {
+		return err
+	}
+	for key := range fields {
+		switch strings.ToLower(key) {
+		case "pcr0", "pcr1", "pcr2", "pcr8":
+		default:
+			m.unknown = append(m.unknown, key)
+		}
+	}
+	sort.Strings(m.unknown)
+	return nil
+}
+
+// empty tells whether m sets no PCR.
+func (m Measurements) empty() bool {
+	return m.PCR0 == "" && m.PCR1 == "" && m.PCR2 == "" && m.PCR8 == ""
+}
+
+// Matches tells whether the measurements of an enclave image have every PCR
+// set in m. Measurements which set no PCR match no image.
+func (m Measurements) Matches(actual Measurements) bool {
+	if m.empty() {
+		return false
+	}
+	return pcrMatches(m.PCR0, actual.PCR0) &&
+		pcrMatches(m.PCR1, actual.PCR1) &&
+		pcrMatches(m.PCR2, actual.PCR2) &&
+		pcrMatches(m.PCR8, actual.PCR8)
+}
+
+func pcrMatches(expected, actual string) bool {
+	return expected == "" || strings.EqualFold(expected, actual)
+}
+
+// String formats the PCRs set in m.
+func (m Measurements) String() string {
+	var pcrs []string
+	for _, pcr := range []struct{ name, value string }{
+		{"PCR0", m.PCR0}, {"PCR1", m.PCR1}, {"PCR2", m.PCR2}, {"PCR8", m.PCR8},
+	} {
+		if pcr.value != "" {
+			pcrs = append(pcrs, pcr.name+"="+pcr.value)
+		}
+	}
+	return strings.Join(pcrs, " ")
+}
+
+// Applies tells whether the policy applies to a pod with the given labels.
+func (p *EnclavePolicy) Applies(podLabels map[string]string) (bool, error) {
+	if p.Spec.Selector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(p.Spec.Selector)
+	if err != nil {
+		return false, fmt.Errorf("invalid selector of enclave policy %s: %w", p.Name, err)
+	}
+	return selector.Matches(labels.Set(podLabels)), nil
+}
+
+// Validate checks every entry of the policy sets PCRs, in hex, and nothing
+// else, so a mistyped entry is reported rather than ignored.
+func (p *EnclavePolicy) Validate() error {
+	for i, m := range p.Spec.Allowed {
+		if len(m.unknown) > 0 {
+			return fmt.Errorf("invalid enclave policy %s: allowed[%d] has unknown keys %s", p.Name, i, strings.Join(m.unknown, ", "))
+		}
+		if m.empty() {
+			return fmt.Errorf("invalid enclave policy %s: allowed[%d] sets no PCR", p.Name, i)
+		}
+		for _, pcr := range []struct{ name, value string }{
+			{"pcr0", m.PCR0}, {"pcr1", m.PCR1}, {"pcr2", m.PCR2}, {"pcr8", m.PCR8},
+		} {
+			if _, err := hex.DecodeString(pcr.value); err != nil {
+				return fmt.Errorf("invalid enclave policy %s: allowed[%d].%s %q is not in hex", p.Name, i, pcr.name, pcr.value)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows tells whether an enclave image with the given measurements
+// complies with the policy.
+func (p *EnclavePolicy) Allows(actual Measurements) bool {
+	for _, m := range p.Spec.Allowed {
+		if m.Matches(actual) {
+			return true
+		}
+	}
+	return false
+}
+
+// List lists the enclave policies of a namespace with a client of the API
+// server, failing with ErrNotInstalled when the EnclavePolicy resource is not installed.
+func List(ctx context.Context, client rest.Interface, namespace string) ([]EnclavePolicy, error) {
+	raw, err := client.Get().
+		AbsPath("/apis", Group, Version, "namespaces", namespace, Resource).
+		Do(ctx).
+		Raw()
+	if apierrors.IsNotFound(err) {
+		return nil, ErrNotInstalled
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list EnclavePolicyList
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("decoding enclave policies: %w", err)
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPolicy(t *testing.T) {
+	p := &EnclavePolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: EnclavePolicySpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Allowed: []Measurements{
+				{PCR0: "AA", PCR1: "bb"},
+				{PCR8: "cc"},
+			},
+		},
+	}
+
+	applies, err := p.Applies(map[string]string{"app": "web"})
+	assert.Nil(t, err)
+	assert.True(t, applies)
+	applies, err = p.Applies(map[string]string{"app": "db"})
+	assert.Nil(t, err)
+	assert.False(t, applies)
+
+	// Every PCR of an allowed entry must match, the others are ignored.
+	assert.True(t, p.Allows(Measurements{PCR0: "aa", PCR1: "bb", PCR2: "dd"}))
+	assert.False(t, p.Allows(Measurements{PCR0: "aa", PCR1: "dd"}))
+	// A signing identity allows every image it signs.
+	assert.True(t, p.Allows(Measurements{PCR0: "ee", PCR8: "cc"}))
+	assert.False(t, p.Allows(Measurements{PCR0: "ee"}))
+	assert.False(t, (&EnclavePolicy{}).Allows(Measurements{PCR0: "aa"}))
+
+	assert.Equal(t, "PCR0=aa PCR8=cc", Measurements{PCR0: "aa", PCR8: "cc"}.String())
+}
+
+func TestValidate(t *testing.T) {
+	decode := func(allowed string) *EnclavePolicy {
+		p := &EnclavePolicy{ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+		assert.Nil(t, json.Unmarshal([]byte(`{"allowed": `+allowed+`}`), &p.Spec))
+		return p
+	}
+
+	p := decode(`[{"pcr0": "AA", "pcr8": "cc"}]`)
+	assert.Nil(t, p.Validate())
+	assert.True(t, p.Allows(Measurements{PCR0: "aa", PCR8: "cc"}))
+
+	// An empty entry matches no image, rather than every one.
+	p = decode(`[{}]`)
+	assert.EqualError(t, p.Validate(), "invalid enclave policy web: allowed[0] sets no PCR")
+	assert.False(t, p.Allows(Measurements{PCR0: "aa"}))
+
+	// So does an entry whose keys are all mistyped.
+	p = decode(`[{"pcr8": "cc"}, {"pcr_0": "aa", "PCR3": "bb"}]`)
+	assert.EqualError(t, p.Validate(), "invalid enclave policy web: allowed[1] has unknown keys PCR3, pcr_0")
+	assert.False(t, p.Allows(Measurements{PCR0: "aa"}))
+
+	p = decode(`[{"pcr2": "not hex"}]`)
+	assert.EqualError(t, p.Validate(), `invalid enclave policy web: allowed[0].pcr2 "not hex" is not in hex`)
+}
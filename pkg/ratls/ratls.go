@@ -0,0 +1,150 @@
+// Package ratls binds the TLS certificates of enclaves to their attestation
+// documents. The self-signed certificate of an enclave embeds a document
+// attesting its public key, whose private key never leaves the enclave, so
+// clients verifying the document know the TLS session ends in an enclave
+// with the measurements they expect.
+package ratls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+)
+
+// ExtensionOID identifies the certificate extension embedding the
+// attestation document, an OCTET STRING. It is in the experimental arc of
+// the Internet, there is no standard one for Nitro attestation documents.
+var ExtensionOID = asn1.ObjectIdentifier{1, 3, 6, 1, 3, 4710, 1}
+
+// Attester returns an attestation document of the enclave including the
+// public key, in DER, such as the Nitro Secure Module signs.
+type Attester func(publicKey []byte) ([]byte, error)
+
+// NewCertificate generates a key pair and returns a self-signed
+// certificate for it, valid for the DNS names or IP addresses and the
+// duration, which embeds an attestation document of its public key.
+func NewCertificate(attest Attester, names []string, validity time.Duration) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := attest(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attest public key: %w", err)
+	}
+	ext, err := asn1.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: "enclave"},
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(validity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: []pkix.Extension{{Id: ExtensionOID, Value: ext}},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	if len(names) > 0 {
+		template.Subject.CommonName = names[0]
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// VerifyCertificate verifies the attestation document embedded in a
+// certificate with the options, and that it attests the public key of the
+// certificate. The certificate must be valid now, its document is verified
+// at the time it was signed unless the options tell otherwise, as the
+// certificates of the Nitro Secure Module only last hours.
+func VerifyCertificate(cert *x509.Certificate, opts attestation.VerifyOptions) (*attestation.Document, error) {
+	var data []byte
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(ExtensionOID) {
+			if _, err := asn1.Unmarshal(ext.Value, &data); err != nil {
+				return nil, fmt.Errorf("invalid attestation extension: %v", err)
+			}
+		}
+	}
+	if data == nil {
+		return nil, errors.New("certificate has no attestation document")
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("certificate is not valid at %s", now.UTC().Format(time.RFC3339))
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return nil, fmt.Errorf("invalid certificate signature: %v", err)
+	}
+
+	if opts.CurrentTime.IsZero() {
+		doc, err := attestation.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		opts.CurrentTime = doc.Time()
+	}
+	doc, err := attestation.Verify(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(doc.PublicKey, cert.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("attestation document does not attest the key of the certificate")
+	}
+	return doc, nil
+}
+
+// ClientConfig returns the TLS configuration of clients of enclaves, which
+// trust the certificates whose attestation documents verify with the
+// options, such as the PCRs of the enclave, rather than certificate
+// authorities. The server name is not checked, the attestation identifies
+// the enclave, and the options have no nonce, the document is not fresh.
+func ClientConfig(opts attestation.VerifyOptions) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// VerifyConnection replaces the verification of the certificate.
+		InsecureSkipVerify: true, //nolint:gosec
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("enclave presented no certificate")
+			}
+			_, err := VerifyCertificate(cs.PeerCertificates[0], opts)
+			return err
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package ratls
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/attestation/attestationtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRATLS(t *testing.T) {
+	module, err := attestationtest.NewModule()
+	assert.Nil(t, err)
+	attest := func(publicKey []byte) ([]byte, error) { return module.Attest(nil, nil, publicKey) }
+
+	cert, err := NewCertificate(attest, []string{"web.default.svc", "127.0.0.1"}, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"web.default.svc"}, cert.Leaf.DNSNames)
+	assert.Len(t, cert.Leaf.IPAddresses, 1)
+
+	// The document verifies against the PCRs of the enclave.
+	pcr0 := hex.EncodeToString(module.PCRs[0])
+	doc, err := VerifyCertificate(cert.Leaf, attestation.VerifyOptions{Roots: module.Roots(), PCRs: map[uint]string{0: pcr0}})
+	assert.Nil(t, err)
+	if assert.NotNil(t, doc) {
+		assert.Equal(t, module.ModuleID, doc.ModuleID)
+	}
+	_, err = VerifyCertificate(cert.Leaf, attestation.VerifyOptions{Roots: module.Roots(), PCRs: map[uint]string{0: hex.EncodeToString(module.PCRs[1])}})
+	assert.ErrorContains(t, err, "attestation document PCR0")
+	_, err = VerifyCertificate(cert.Leaf, attestation.VerifyOptions{})
+	assert.ErrorContains(t, err, "untrusted attestation document certificate")
+
+	// A document attesting another key does not vouch for the certificate.
+	other, err := NewCertificate(func([]byte) ([]byte, error) { return module.Attest(nil, nil, []byte("key")) }, nil, time.Hour)
+	assert.Nil(t, err)
+	_, err = VerifyCertificate(other.Leaf, attestation.VerifyOptions{Roots: module.Roots()})
+	assert.EqualError(t, err, "attestation document does not attest the key of the certificate")
+
+	_, err = NewCertificate(func([]byte) ([]byte, error) { return nil, errors.New("no NSM") }, nil, time.Hour)
+	assert.EqualError(t, err, "failed to attest public key: no NSM")
+
+	// Clients complete handshakes with attested enclaves only.
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake() //nolint:errcheck
+			conn.Close()
+		}
+	}()
+	dial := func(opts attestation.VerifyOptions) error {
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", l.Addr().String(), ClientConfig(opts))
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	assert.Nil(t, dial(attestation.VerifyOptions{Roots: module.Roots(), PCRs: map[uint]string{0: pcr0}}))
+	assert.NotNil(t, dial(attestation.VerifyOptions{Roots: module.Roots(), PCRs: map[uint]string{0: "00"}}))
+}
@@ -0,0 +1,233 @@
+// Package sandbox runs the helper binaries of the node, linuxkit, eif_build
+// and nitro-cli, with less privileges than the provider: as a dedicated
+// user, unable to gain privileges, with their temporary files confined to a
+// directory of their own and none of the provider's environment but the
+// variables they need. A compromised blob then cannot act as the provider.
+package sandbox
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Config is how the helper binaries are restricted.
+type Config struct {
+	// User is the dedicated user the helpers run as, by name or ID, and
+	// Group its group, the primary group of the user when empty. The
+	// helpers run as the provider when empty.
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Groups are the supplementary groups of the helpers, such as ne, the
+	// group of the Nitro Enclaves device nitro-cli opens.
+	Groups []string `json:"groups,omitempty"`
+	// NoNewPrivs sets no_new_privs on the helpers, so setuid binaries and
+	// file capabilities grant them nothing.
+	NoNewPrivs bool `json:"noNewPrivs,omitempty"`
+	// TempDir is where the helpers keep their files: their working and home
+	// directory and TMPDIR, where the images are built. It is created owned
+	// by the user, readable by it only.
+	TempDir string `json:"tempDir,omitempty"`
+	// Env names the variables of the provider's environment passed to the
+	// helpers on top of PATH, such as HTTPS_PROXY for linuxkit. The others,
+	// such as the credentials of the provider, are not.
+	Env []string `json:"env,omitempty"`
+}
+
+// Sandbox runs commands restricted by a Config. A nil Sandbox runs them
+// unrestricted.
+type Sandbox struct {
+	credential *syscall.Credential
+	noNewPrivs bool
+	tempDir    string
+	// home is the home directory of the helpers, and env the names of the
+	// variables passed to them.
+	home string
+	env  []string
+}
+
+// New creates the sandbox of a configuration, creating its temporary directory.
+func New(c Config) (*Sandbox, error) {
+	s := &Sandbox{noNewPrivs: c.NoNewPrivs, tempDir: c.TempDir, home: os.Getenv("HOME")}
+	for _, name := range c.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return nil, fmt.Errorf("invalid helper variable %q", name)
+		}
+		s.env = append(s.env, name)
+	}
+	if c.User != "" {
+		credential, home, err := lookupCredential(c)
+		if err != nil {
+			return nil, err
+		}
+		s.home = home
+		if os.Geteuid() != 0 && credential.Uid != uint32(os.Geteuid()) {
+			return nil, fmt.Errorf("running helpers as user %s requires the provider to run as root", c.User)
+		}
+		s.credential = credential
+	} else if c.Group != "" || len(c.Groups) > 0 {
+		return nil, fmt.Errorf("helper groups require a helper user")
+	}
+	if s.tempDir != "" {
+		if !filepath.IsAbs(s.tempDir) {
+			return nil, fmt.Errorf("invalid temporary directory %q, expected an absolute path", s.tempDir)
+		}
+		if err := os.MkdirAll(s.tempDir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(s.tempDir, 0700); err != nil {
+			return nil, err
+		}
+		if err := s.Chown(s.tempDir); err != nil {
+			return nil, err
+		}
+		s.home = s.tempDir
+	}
+	return s, nil
+}
+
+// lookupCredential returns the user and groups of a configuration, and the
+// home directory of the user.
+func lookupCredential(c Config) (*syscall.Credential, string, error) {
+	u, err := user.Lookup(c.User)
+	if err != nil {
+		if u, err = user.LookupId(c.User); err != nil {
+			return nil, "", fmt.Errorf("unknown helper user %q", c.User)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, "", fmt.Errorf("user %s has no numeric ID", c.User)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, "", fmt.Errorf("user %s has no numeric group ID", c.User)
+	}
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}
+	if c.Group != "" {
+		if credential.Gid, err = lookupGroup(c.Group); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, group := range c.Groups {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return nil, "", err
+		}
+		credential.Groups = append(credential.Groups, gid)
+	}
+	return credential, u.HomeDir, nil
+}
+
+// lookupGroup returns the ID of a group given by name or ID.
+func lookupGroup(name string) (uint32, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		if g, err = user.LookupGroupId(name); err != nil {
+			return 0, fmt.Errorf("unknown helper group %q", name)
+		}
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("group %s has no numeric ID", name)
+	}
+	return uint32(gid), nil
+}
+
+// TempDir returns the directory the helpers keep their files in, the
+// default temporary directory when empty.
+func (s *Sandbox) TempDir() string {
+	if s == nil {
+		return ""
+	}
+	return s.tempDir
+}
+
+// Command returns the command running a helper in the sandbox, to be
+// started with Start or Run.
+func (s *Sandbox) Command(name string, arg ...string) *exec.Cmd {
+	cmd := exec.Command(name, arg...)
+	if s == nil {
+		return cmd
+	}
+	if s.credential != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: s.credential}
+	}
+	if s.tempDir != "" {
+		cmd.Dir = s.tempDir
+	}
+	cmd.Env = s.environ()
+	return cmd
+}
+
+// environ returns the environment of the helpers: PATH and the variables of
+// the configuration, taken from the provider's environment, with TMPDIR and
+// HOME.
+func (s *Sandbox) environ() []string {
+	var env []string
+	for _, name := range append([]string{"PATH"}, s.env...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	tempDir := s.tempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	env = append(env, "TMPDIR="+tempDir)
+	if s.home != "" {
+		env = append(env, "HOME="+s.home)
+	}
+	return env
+}
+
+// Start starts a command, setting no_new_privs on it when the sandbox does.
+func (s *Sandbox) Start(cmd *exec.Cmd) error {
+	if s == nil || !s.noNewPrivs {
+		return cmd.Start()
+	}
+	// The flag cannot be unset: it is set on a thread the command is forked
+	// from, which exits along with the goroutine still locked to it.
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			errc <- fmt.Errorf("failed to set no_new_privs: %v", err)
+			return
+		}
+		errc <- cmd.Start()
+	}()
+	return <-errc
+}
+
+// Run starts a command like Start and waits for it to complete.
+func (s *Sandbox) Run(cmd *exec.Cmd) error {
+	if err := s.Start(cmd); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// Chown gives a file, or a directory and its contents, to the user of the
+// sandbox, so the helpers can use the files the provider prepared for them.
+func (s *Sandbox) Chown(path string) error {
+	if s == nil || s.credential == nil {
+		return nil
+	}
+	uid, gid := int(s.credential.Uid), int(s.credential.Gid)
+	return filepath.WalkDir(path, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
@@ -0,0 +1,95 @@
+package sandbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// status runs cat /proc/self/status in the sandbox and returns the value of a field.
+func status(t *testing.T, s *Sandbox, field string) string {
+	cmd := s.Command("cat", "/proc/self/status")
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	assert.Nil(t, s.Run(cmd))
+	for _, line := range strings.Split(output.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func TestSandbox(t *testing.T) {
+	var unrestricted *Sandbox
+	assert.Equal(t, "", unrestricted.TempDir())
+	assert.Equal(t, "0", status(t, unrestricted, "NoNewPrivs"))
+	assert.Nil(t, unrestricted.Chown(t.TempDir()))
+
+	dir := filepath.Join(t.TempDir(), "helpers")
+	s, err := New(Config{NoNewPrivs: true, TempDir: dir})
+	assert.Nil(t, err)
+	assert.Equal(t, dir, s.TempDir())
+	info, err := os.Stat(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	assert.Equal(t, "1", status(t, s, "NoNewPrivs"))
+	// The flag stays off the provider.
+	assert.Equal(t, "0", status(t, unrestricted, "NoNewPrivs"))
+
+	cmd := s.Command("sh", "-c", "echo $TMPDIR $HOME; pwd")
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	assert.Nil(t, s.Run(cmd))
+	assert.Equal(t, dir+" "+dir+"\n"+dir+"\n", output.String())
+
+	// The helpers get none of the provider's environment but PATH and the
+	// variables of the configuration.
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("NEK_CPU", "2")
+	t.Setenv("HTTPS_PROXY", "http://proxy:3128")
+	s, err = New(Config{TempDir: dir, Env: []string{"HTTPS_PROXY", "UNSET_VARIABLE"}})
+	assert.Nil(t, err)
+	cmd = s.Command("env")
+	output.Reset()
+	cmd.Stdout = output
+	assert.Nil(t, s.Run(cmd))
+	env := strings.Split(strings.TrimSpace(output.String()), "\n")
+	assert.ElementsMatch(t, []string{"PATH=" + os.Getenv("PATH"), "HTTPS_PROXY=http://proxy:3128", "TMPDIR=" + dir, "HOME=" + dir}, env)
+
+	_, err = New(Config{Env: []string{"A=B"}})
+	assert.NotNil(t, err)
+	_, err = New(Config{TempDir: "relative"})
+	assert.NotNil(t, err)
+	_, err = New(Config{Groups: []string{"ne"}})
+	assert.NotNil(t, err)
+	_, err = New(Config{User: "no-such-helper-user"})
+	assert.NotNil(t, err)
+}
+
+func TestSandboxUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users requires root")
+	}
+	// The helpers reach their directory through those of the test.
+	parent := t.TempDir()
+	assert.Nil(t, os.Chmod(parent, 0755))
+	assert.Nil(t, os.Chmod(filepath.Dir(parent), 0755))
+	dir := filepath.Join(parent, "helpers")
+	s, err := New(Config{User: "65534", Group: "65534", TempDir: dir})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(status(t, s, "Uid"), "65534\t"))
+	assert.True(t, strings.HasPrefix(status(t, s, "Gid"), "65534\t"))
+	assert.Equal(t, "", status(t, s, "Groups"))
+
+	// The helpers own their directory and the files given to them.
+	file := filepath.Join(dir, "config.json")
+	assert.Nil(t, os.WriteFile(file, []byte("{}"), 0600))
+	assert.Nil(t, s.Chown(file))
+	cmd := s.Command("cat", file)
+	assert.Nil(t, s.Run(cmd))
+}
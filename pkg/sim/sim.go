@@ -0,0 +1,377 @@
+// Package sim simulates Nitro enclaves for development without Nitro
+// hardware: enclave images only record what to run, and enclaves are
+// processes of the host whose agents reach the provider over TCP loopback
+// rather than vsock. Images are not pulled, the command of the pod runs from
+// the host, and nothing is isolated nor attested.
+package sim
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+)
+
+const (
+	// firstCID is the first CID given to simulated enclaves, like nitro-cli.
+	firstCID = 16
+	// consolePollInterval is how often the console of an enclave is read
+	// once all of its output was.
+	consolePollInterval = 200 * time.Millisecond
+
+	infoFile    = "info.json"
+	consoleFile = "console.log"
+	rootDir     = "root"
+)
+
+// Version is the version of nitro-cli simulated, which runs several enclaves.
+var Version = [3]int{1, 2, 2}
+
+// Image is the enclave image file of a simulated enclave: what it runs,
+// rather than its root filesystem.
+type Image struct {
+	Image string            `json:"image"`
+	Cmd   []string          `json:"cmd"`
+	Env   map[string]string `json:"env,omitempty"`
+	Files []build.File      `json:"files,omitempty"`
+}
+
+// Build implements build.Builder, writing the image of a simulated enclave.
+// The files copied from the host are read right away, like in enclave images.
+func Build(blobsPath string, image string, cmds []string, envs map[string]string, output string, files ...build.File) error {
+	img := Image{Image: image, Cmd: cmds, Env: envs}
+	for _, f := range files {
+		if f.Content == nil {
+			content, err := os.ReadFile(f.Source)
+			if err != nil {
+				return &build.Error{Stage: build.StageImage, Err: err}
+			}
+			f.Content, f.Source = content, ""
+		}
+		img.Files = append(img.Files, f)
+	}
+	data, err := json.Marshal(img)
+	if err != nil {
+		return &build.Error{Stage: build.StageEIF, Err: err}
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return &build.Error{Stage: build.StageEIF, Err: err}
+	}
+	return nil
+}
+
+// Simulator implements cli.Backend, running the enclaves as processes. Every
+// enclave has a directory holding its info, console and root directory, so
+// the enclaves survive the restarts of the provider, like real ones.
+type Simulator struct {
+	dir string
+	// mu serializes the launches, which pick the CIDs of the enclaves.
+	mu sync.Mutex
+}
+
+// New creates a Simulator keeping its enclaves in dir.
+func New(dir string) (*Simulator, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Simulator{dir: dir}, nil
+}
+
+// Enable simulates the enclaves of the node with a Simulator keeping its
+// enclaves in dir. It is called before any enclave is built or runs.
+func Enable(dir string) (*Simulator, error) {
+	s, err := New(dir)
+	if err != nil {
+		return nil, err
+	}
+	cli.SetBackend(s)
+	build.SetBuilder(Build)
+	transport.UseLoopback()
+	return s, nil
+}
+
+// simEnclave is the info of a simulated enclave.
+type simEnclave struct {
+	cli.EnclaveInfo
+	// Dir is the directory of the enclave.
+	Dir string `json:"-"`
+}
+
+// RunEnclave implements cli.Backend.
+func (s *Simulator) RunEnclave(c *cli.EnclaveConfig) (*cli.EnclaveInfo, error) {
+	data, err := os.ReadFile(c.EifPath)
+	if err != nil {
+		return nil, err
+	}
+	var img Image
+	if err := json.Unmarshal(data, &img); err != nil {
+		return nil, fmt.Errorf("invalid simulated enclave image %s: %v", c.EifPath, err)
+	}
+	if len(img.Cmd) == 0 {
+		return nil, fmt.Errorf("simulated enclave image %s runs no command", c.EifPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enclaves, err := s.enclaves()
+	if err != nil {
+		return nil, err
+	}
+	cid, err := pickCID(enclaves, c.EnclaveCid)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	info := cli.EnclaveInfo{
+		EnclaveName:  c.EnclaveName,
+		EnclaveID:    "sim-enc" + hex.EncodeToString(id),
+		EnclaveCID:   cid,
+		NumberOfCPUs: c.CPUCount,
+		CPUIDs:       c.CPUIds,
+		MemoryMiB:    c.MemoryMib,
+		State:        cli.StateRunning,
+		Flags:        "NONE",
+	}
+	if info.NumberOfCPUs == 0 {
+		info.NumberOfCPUs = int64(len(c.CPUIds))
+	}
+	if c.DebugMode {
+		info.Flags = cli.FlagDebugMode
+	}
+
+	dir := filepath.Join(s.dir, info.EnclaveID)
+	root := filepath.Join(dir, rootDir)
+	if err := writeRoot(root, img.Files); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	console, err := os.Create(filepath.Join(dir, consoleFile))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	defer console.Close()
+
+	// The agent, like any file of the image, runs from the root of the enclave.
+	name := img.Cmd[0]
+	for _, f := range img.Files {
+		if f.Path == name {
+			name = filepath.Join(root, name)
+		}
+	}
+	cmd := exec.Command(name, img.Cmd[1:]...)
+	cmd.Dir = root
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + root}
+	for k, v := range img.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Env = append(cmd.Env,
+		transport.CIDEnv+"="+strconv.Itoa(cid),
+		agent.SimulatedRootEnv+"="+root,
+	)
+	cmd.Stdout = console
+	cmd.Stderr = console
+	// The enclave is terminated with all the processes it started.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start simulated enclave: %v", err)
+	}
+	info.ProcessID = cmd.Process.Pid
+
+	data, _ = json.Marshal(info)
+	if err := os.WriteFile(filepath.Join(dir, infoFile), data, 0644); err != nil {
+		syscall.Kill(-info.ProcessID, syscall.SIGKILL) //nolint:errcheck
+		cmd.Wait()                                     //nolint:errcheck
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	// Enclaves which exit are gone, like real ones.
+	go func() {
+		cmd.Wait() //nolint:errcheck
+		os.RemoveAll(dir)
+	}()
+	return &info, nil
+}
+
+// DescribeEnclaves implements cli.Backend.
+func (s *Simulator) DescribeEnclaves() ([]cli.EnclaveInfo, error) {
+	enclaves, err := s.enclaves()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]cli.EnclaveInfo, 0, len(enclaves))
+	for _, e := range enclaves {
+		infos = append(infos, e.EnclaveInfo)
+	}
+	return infos, nil
+}
+
+// TerminateEnclave implements cli.Backend.
+func (s *Simulator) TerminateEnclave(enclaveID string) (*cli.TerminationResponse, error) {
+	e, err := s.enclave(enclaveID)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Kill(-e.ProcessID, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return nil, err
+	}
+	// The enclave is gone right away, even when the provider did not start it.
+	if err := os.RemoveAll(e.Dir); err != nil {
+		return nil, err
+	}
+	return &cli.TerminationResponse{EnclaveID: enclaveID, Terminated: true}, nil
+}
+
+// Console implements cli.Backend, following the output of the enclave until
+// it is gone or the console is closed.
+func (s *Simulator) Console(enclaveID string) (io.ReadCloser, error) {
+	e, err := s.enclave(enclaveID)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(e.Dir, consoleFile))
+	if err != nil {
+		return nil, err
+	}
+	return &console{file: file, info: filepath.Join(e.Dir, infoFile), closed: make(chan struct{})}, nil
+}
+
+// Version implements cli.Backend.
+func (s *Simulator) Version() ([3]int, error) {
+	return Version, nil
+}
+
+// enclave returns the running enclave with the given ID.
+func (s *Simulator) enclave(enclaveID string) (*simEnclave, error) {
+	enclaves, err := s.enclaves()
+	if err != nil {
+		return nil, err
+	}
+	for i := range enclaves {
+		if enclaves[i].EnclaveID == enclaveID {
+			return &enclaves[i], nil
+		}
+	}
+	return nil, fmt.Errorf("enclave %s not found", enclaveID)
+}
+
+// enclaves returns the running enclaves, removing those whose process is
+// gone while the provider did not run, such as across reboots.
+func (s *Simulator) enclaves() ([]simEnclave, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var enclaves []simEnclave
+	for _, entry := range entries {
+		dir := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, infoFile))
+		if err != nil {
+			// Enclaves being launched or terminated.
+			continue
+		}
+		e := simEnclave{Dir: dir}
+		if err := json.Unmarshal(data, &e.EnclaveInfo); err != nil {
+			continue
+		}
+		if err := syscall.Kill(e.ProcessID, 0); errors.Is(err, syscall.ESRCH) {
+			os.RemoveAll(dir)
+			continue
+		}
+		enclaves = append(enclaves, e)
+	}
+	return enclaves, nil
+}
+
+// pickCID returns the CID requested, or the first one free.
+func pickCID(enclaves []simEnclave, requested int) (int, error) {
+	used := make(map[int]bool, len(enclaves))
+	for _, e := range enclaves {
+		used[e.EnclaveCID] = true
+	}
+	if requested != 0 {
+		if used[requested] {
+			return 0, fmt.Errorf("CID %d is used by another enclave", requested)
+		}
+		return requested, nil
+	}
+	cid := firstCID
+	for used[cid] {
+		cid++
+	}
+	return cid, nil
+}
+
+// writeRoot writes the files of an image into the root directory of an enclave.
+func writeRoot(root string, files []build.File) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		mode := uint64(0644)
+		if f.Mode != "" {
+			var err error
+			if mode, err = strconv.ParseUint(f.Mode, 8, 32); err != nil {
+				return fmt.Errorf("invalid mode %q of %s: %v", f.Mode, f.Path, err)
+			}
+		}
+		path := filepath.Join(root, filepath.Clean("/"+f.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, f.Content, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// console follows the output of an enclave.
+type console struct {
+	file *os.File
+	// info is the info file of the enclave, which is gone with the enclave.
+	info   string
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (c *console) Read(p []byte) (int, error) {
+	for {
+		n, err := c.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if _, err := os.Stat(c.info); err != nil {
+			return 0, io.EOF
+		}
+		select {
+		case <-c.closed:
+			return 0, io.EOF
+		case <-time.After(consolePollInterval):
+		}
+	}
+}
+
+func (c *console) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.file.Close()
+}
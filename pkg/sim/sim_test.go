@@ -0,0 +1,70 @@
+package sim
+
+import (
+	"bufio"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/build"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulator(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(filepath.Join(dir, "enclaves"))
+	assert.Nil(t, err)
+
+	eif := filepath.Join(dir, "nginx.eif")
+	files := []build.File{{Path: "/etc/greeting", Content: []byte("hello"), Mode: "0600"}}
+	assert.Nil(t, Build("", "nginx", []string{"/bin/sh", "-c", "echo $NAME; cat etc/greeting; echo; exec sleep 60"}, map[string]string{"NAME": "world"}, eif, files...))
+
+	info, err := s.RunEnclave(&cli.EnclaveConfig{EnclaveName: "nginx", EifPath: eif, MemoryMib: 512, CPUCount: 2, DebugMode: true})
+	assert.Nil(t, err)
+	assert.Equal(t, firstCID, info.EnclaveCID)
+	assert.Equal(t, cli.FlagDebugMode, info.Flags)
+
+	// The files of the image are in the root of the enclave, its working directory.
+	console, err := s.Console(info.EnclaveID)
+	assert.Nil(t, err)
+	defer console.Close()
+	lines := bufio.NewScanner(console)
+	assert.True(t, lines.Scan())
+	assert.Equal(t, "world", lines.Text())
+	assert.True(t, lines.Scan())
+	assert.Equal(t, "hello", lines.Text())
+
+	// CIDs are not reused while their enclave runs.
+	_, err = s.RunEnclave(&cli.EnclaveConfig{EnclaveName: "conflict", EifPath: eif, EnclaveCid: firstCID})
+	assert.NotNil(t, err)
+
+	enclaves, err := s.DescribeEnclaves()
+	assert.Nil(t, err)
+	assert.Equal(t, []cli.EnclaveInfo{*info}, enclaves)
+
+	_, err = s.TerminateEnclave(info.EnclaveID)
+	assert.Nil(t, err)
+	enclaves, err = s.DescribeEnclaves()
+	assert.Nil(t, err)
+	assert.Empty(t, enclaves)
+
+	// Enclaves which exit are gone.
+	assert.Nil(t, Build("", "true", []string{"true"}, nil, eif))
+	info, err = s.RunEnclave(&cli.EnclaveConfig{EnclaveName: "true", EifPath: eif})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		state, _ := stateOf(s, info.EnclaveID)
+		return state == cli.StateTerminated
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func stateOf(s *Simulator, enclaveID string) (cli.State, error) {
+	enclaves, err := s.DescribeEnclaves()
+	for _, info := range enclaves {
+		if info.EnclaveID == enclaveID {
+			return info.State, err
+		}
+	}
+	return cli.StateTerminated, err
+}
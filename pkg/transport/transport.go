@@ -0,0 +1,112 @@
+// Package transport connects the provider and the agents of its enclaves:
+// over vsock, or over TCP on loopback addresses when enclaves are simulated
+// by processes of the host. Every simulated CID has its own loopback
+// address, so the agents of several enclaves listen on the same ports.
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/mdlayher/vsock"
+)
+
+const (
+	// CIDEnv is the environment variable the simulator sets to the CID of
+	// the simulated enclave an agent runs in, which switches the agent to
+	// loopback.
+	CIDEnv = "NITRO_ENCLAVE_SIM_CID"
+
+	// parentCID is the CID of the parent instance as seen from an enclave.
+	parentCID = 3
+)
+
+var (
+	// loopback tells whether vsock is replaced with TCP loopback.
+	loopback bool
+	// localCID is the CID of the simulated enclave the process runs in, or
+	// the parent CID outside of enclaves.
+	localCID uint32 = parentCID
+)
+
+func init() {
+	if value := os.Getenv(CIDEnv); value != "" {
+		cid, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s %q: %v", CIDEnv, value, err))
+		}
+		loopback = true
+		localCID = uint32(cid)
+	}
+}
+
+// UseLoopback replaces vsock with TCP loopback, for the provider of
+// simulated enclaves. It is called before any connection is made.
+func UseLoopback() {
+	loopback = true
+}
+
+// Loopback tells whether vsock is replaced with TCP loopback.
+func Loopback() bool {
+	return loopback
+}
+
+// Host returns the loopback address standing for a CID: 127.0.0.1 for the
+// parent instance, 127.1.x.y for the enclave whose CID is x*256+y.
+func Host(cid uint32) string {
+	if cid <= parentCID {
+		return "127.0.0.1"
+	}
+	return fmt.Sprintf("127.1.%d.%d", cid>>8&0xff, cid&0xff)
+}
+
+// LocalHost returns the loopback address of the process, see Host.
+func LocalHost() string {
+	return Host(localCID)
+}
+
+// Listen listens on a port of the local CID.
+func Listen(port uint32) (net.Listener, error) {
+	if !loopback {
+		return vsock.Listen(port, &vsock.Config{})
+	}
+	return net.Listen("tcp", net.JoinHostPort(LocalHost(), strconv.FormatUint(uint64(port), 10)))
+}
+
+// Dial connects to a port of a CID. Connections come from the local CID,
+// see PeerCID.
+func Dial(cid, port uint32) (net.Conn, error) {
+	if !loopback {
+		return vsock.Dial(cid, port, &vsock.Config{})
+	}
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(LocalHost())}}
+	return dialer.Dial("tcp", net.JoinHostPort(Host(cid), strconv.FormatUint(uint64(port), 10)))
+}
+
+// PeerCID returns the CID a connection comes from.
+func PeerCID(addr net.Addr) (uint32, bool) {
+	switch addr := addr.(type) {
+	case *vsock.Addr:
+		return addr.ContextID, true
+	case *net.TCPAddr:
+		ip := addr.IP.To4()
+		if !loopback || ip == nil || ip[0] != 127 {
+			return 0, false
+		}
+		if ip[1] != 1 {
+			return parentCID, true
+		}
+		return uint32(ip[2])<<8 | uint32(ip[3]), true
+	}
+	return 0, false
+}
+
+// ContextID returns the local CID.
+func ContextID() (uint32, error) {
+	if !loopback {
+		return vsock.ContextID()
+	}
+	return localCID, nil
+}
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHost(t *testing.T) {
+	assert.Equal(t, "127.0.0.1", Host(parentCID))
+	assert.Equal(t, "127.1.0.16", Host(16))
+	assert.Equal(t, "127.1.3.231", Host(999))
+}
+
+func TestLoopback(t *testing.T) {
+	defer func(l bool, cid uint32) { loopback, localCID = l, cid }(loopback, localCID)
+	UseLoopback()
+
+	// The provider listens on the parent address, which the enclaves dial.
+	l, err := Listen(10016)
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Write([]byte("ok")) //nolint:errcheck
+			conn.Close()
+		}
+	}()
+
+	localCID = 16
+	cid, err := ContextID()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(16), cid)
+	conn, err := Dial(parentCID, 10016)
+	assert.Nil(t, err)
+	data, _ := io.ReadAll(conn)
+	assert.Equal(t, "ok", string(data))
+
+	cid, ok := PeerCID(conn.LocalAddr())
+	assert.True(t, ok)
+	assert.Equal(t, uint32(16), cid)
+}
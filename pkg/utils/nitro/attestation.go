@@ -2,10 +2,10 @@ package nitro
 
 import (
 	"errors"
-	"log"
 
 	"github.com/hf/nsm"
 	"github.com/hf/nsm/request"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 // Attest takes as input a nonce, user-provided data and a public key, and then
@@ -18,7 +18,7 @@ func Attest(nonce, userData, publicKey []byte) ([]byte, error) {
 	}
 	defer func() {
 		if err = s.Close(); err != nil {
-			log.Printf("Attestation: Failed to close default NSM session: %s", err)
+			log.L.Warnf("Attestation: Failed to close default NSM session: %s", err)
 		}
 	}()
 
@@ -3,15 +3,14 @@ package aws
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"golang.org/x/net/http2"
 
-	"github.com/brave-intl/bat-go/libs/logging"
 	"github.com/brave-intl/bat-go/libs/nitro"
 )
 
@@ -19,23 +18,15 @@ import (
 // proxy listening on a vsock address, it automatically retrieves any EC2
 // role credentials of the instance hosting the enclave
 func NewAWSConfig(ctx context.Context, proxyAddr string, region string) (config.Config, error) {
-	logger := logging.Logger(ctx, "aws.NewAWSConfig")
-
-	logger.Info().
-		Str("proxyAddr", proxyAddr).
-		Str("region", region).
-		Msg("setting up new aws config")
+	log.G(ctx).WithFields(log.Fields{"proxyAddr": proxyAddr, "region": region}).Debug("Setting up new AWS config")
 
 	var client http.Client
 	tr := nitro.NewProxyRoundTripper(ctx, proxyAddr)
 
-	logger.Info().
-		Str("transport", fmt.Sprintf("%+v", tr)).
-		Msg("transport is setup")
 	// So client makes HTTP/2 requests
 	err := http2.ConfigureTransport(tr.(*http.Transport))
 	if err != nil {
-		log.Panic(err)
+		panic(err)
 	}
 
 	client = http.Client{
@@ -0,0 +1,231 @@
+package nitro
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSTimeout bounds how long the DNS proxy waits for its upstream server.
+const DefaultDNSTimeout = 5 * time.Second
+
+type dnsProxy struct {
+	upstream string
+	allow    []string
+	deny     []string
+	timeout  time.Duration
+}
+
+// DNSProxy creates a DNS proxy resolving the queries of an enclave with the
+// upstream server. Names under a denied domain are refused, and when domains
+// are allowed, names under none of them are refused too.
+func DNSProxy(upstream string, allow, deny []string, timeout time.Duration) dnsProxy {
+	return dnsProxy{upstream, normalizeDomains(allow), normalizeDomains(deny), timeout}
+}
+
+// Serve answers the queries sent over the connections accepted on ln until
+// ln is closed. Messages are framed as in DNS over TCP, prefixed by their
+// 2 bytes length. Connections still open are closed before Serve returns.
+func (p dnsProxy) Serve(ln net.Listener) error {
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+		wg    sync.WaitGroup
+	)
+	defer func() {
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(conn)
+
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
+
+func (p dnsProxy) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		query, err := agent.ReadDNSMessage(r)
+		if err != nil {
+			return
+		}
+		response, err := p.resolve(query)
+		if err != nil {
+			log.L.Warnf("Failed to resolve DNS query: %s", err)
+			metrics.ProxyErrors.WithLabelValues("dns").Inc()
+			if response, err = fail(query); err != nil {
+				continue
+			}
+		}
+		if err := agent.WriteDNSMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// resolve answers a query, refusing names which are not allowed and queries
+// without exactly one question.
+func (p dnsProxy) resolve(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	// Servers answer a single question per query, others would bypass the filter.
+	if len(questions) != 1 {
+		log.L.Infof("Refused DNS query with %d questions", len(questions))
+		return reply(header, questions, dnsmessage.RCodeRefused)
+	}
+	for _, q := range questions {
+		if name := q.Name.String(); !p.permitted(name) {
+			log.L.Infof("Refused DNS query for %s", name)
+			return reply(header, questions, dnsmessage.RCodeRefused)
+		}
+	}
+
+	response, err := exchange("udp", p.upstream, query, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	// Retry truncated responses over TCP.
+	if len(response) > 2 && response[2]&0x02 != 0 {
+		return exchange("tcp", p.upstream, query, p.timeout)
+	}
+	return response, nil
+}
+
+// permitted tells whether a name may be resolved.
+func (p dnsProxy) permitted(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if inDomains(name, p.deny) {
+		return false
+	}
+	return len(p.allow) == 0 || inDomains(name, p.allow)
+}
+
+// inDomains tells whether a name is one of the domains or under one of them.
+func inDomains(name string, domains []string) bool {
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		normalized = append(normalized, strings.ToLower(strings.Trim(domain, ".")))
+	}
+	return normalized
+}
+
+// fail builds a response telling the query could not be resolved.
+func fail(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	return reply(header, questions, dnsmessage.RCodeServerFailure)
+}
+
+// reply builds a response without answers to the query.
+func reply(query dnsmessage.Header, questions []dnsmessage.Question, rcode dnsmessage.RCode) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               query.ID,
+		Response:         true,
+		OpCode:           query.OpCode,
+		RecursionDesired: query.RecursionDesired,
+		RCode:            rcode,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// exchange sends a query to a DNS server and returns its response.
+func exchange(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if network == "tcp" {
+		if err := agent.WriteDNSMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return agent.ReadDNSMessage(conn)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// SystemDNSServer returns the first name server of the host's resolv.conf,
+// or the local resolver when it has none.
+func SystemDNSServer(resolvConf string) string {
+	data, err := os.ReadFile(resolvConf)
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
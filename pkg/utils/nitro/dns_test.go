@@ -0,0 +1,69 @@
+package nitro
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSProxyServe(t *testing.T) {
+	// The upstream server answers every query with an empty success.
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			response, _ := fail(buf[:n])
+			response[3] &^= 0x0f             // RCodeSuccess
+			upstream.WriteTo(response, addr) //nolint:errcheck
+		}
+	}()
+
+	proxy := DNSProxy(upstream.LocalAddr().String(), []string{"example.com"}, []string{"secret.example.com."}, time.Second)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go proxy.Serve(ln) //nolint:errcheck
+
+	resolve := func(names ...string) dnsmessage.RCode {
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+		b.StartQuestions() //nolint:errcheck
+		for _, name := range names {
+			b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}) //nolint:errcheck
+		}
+		query, err := b.Finish()
+		assert.Nil(t, err)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		assert.Nil(t, agent.WriteDNSMessage(conn, query))
+		response, err := agent.ReadDNSMessage(conn)
+		assert.Nil(t, err)
+
+		var parser dnsmessage.Parser
+		header, err := parser.Start(response)
+		assert.Nil(t, err)
+		assert.Equal(t, uint16(42), header.ID)
+		return header.RCode
+	}
+
+	assert.Equal(t, dnsmessage.RCodeSuccess, resolve("www.example.com."))
+	assert.Equal(t, dnsmessage.RCodeSuccess, resolve("Example.COM."))
+	assert.Equal(t, dnsmessage.RCodeRefused, resolve("secret.example.com."))
+	assert.Equal(t, dnsmessage.RCodeRefused, resolve("api.secret.example.com."))
+	assert.Equal(t, dnsmessage.RCodeRefused, resolve("notexample.com."))
+	// Queries without a question, or with several, are not forwarded.
+	assert.Equal(t, dnsmessage.RCodeRefused, resolve())
+	assert.Equal(t, dnsmessage.RCodeRefused, resolve("www.example.com.", "www.example.com."))
+}
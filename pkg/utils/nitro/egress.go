@@ -0,0 +1,204 @@
+package nitro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/brave-intl/bat-go/libs/closers"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// DefaultEgressConnectTimeout bounds how long the egress proxy waits to connect to a destination.
+const DefaultEgressConnectTimeout = 10 * time.Second
+
+type egressProxy struct {
+	allowed        map[string]bool
+	connectTimeout time.Duration
+	dial           func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// EgressProxy creates an HTTP proxy which only forwards requests and CONNECT
+// tunnels to the given host:port destinations, giving enclaves access to
+// the few endpoints they need rather than the whole network.
+func EgressProxy(allowed []string, connectTimeout time.Duration) egressProxy {
+	p := egressProxy{
+		allowed:        make(map[string]bool, len(allowed)),
+		connectTimeout: connectTimeout,
+		dial:           (&net.Dialer{Timeout: connectTimeout}).DialContext,
+	}
+	for _, dest := range allowed {
+		p.allowed[strings.ToLower(dest)] = true
+	}
+	return p
+}
+
+// Serve proxies the connections accepted on ln until ln is closed. Tunnels
+// still open are interrupted and closed before Serve returns.
+func (p egressProxy) Serve(ln net.Listener) error {
+	var (
+		mu     sync.Mutex
+		conns  = make(map[net.Conn]struct{})
+		wg     sync.WaitGroup
+		closed bool
+	)
+	track := func(c ...net.Conn) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return false
+		}
+		for _, conn := range c {
+			conns[conn] = struct{}{}
+		}
+		wg.Add(1)
+		return true
+	}
+	untrack := func(c ...net.Conn) {
+		mu.Lock()
+		for _, conn := range c {
+			delete(conns, conn)
+		}
+		mu.Unlock()
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			p.forward(w, r)
+			return
+		}
+		client, upstream, ok := p.connect(w, r)
+		if !ok {
+			return
+		}
+		if !track(client, upstream) {
+			client.Close()
+			upstream.Close()
+			return
+		}
+		go func() {
+			defer wg.Done()
+			bidirectionalCopy(context.TODO(), client, upstream)
+			untrack(client, upstream)
+		}()
+	})}
+	err := server.Serve(ln)
+
+	// Expire the tunnels rather than closing them, bidirectionalCopy closes
+	// them once the copies are interrupted.
+	mu.Lock()
+	closed = true
+	for conn := range conns {
+		conn.SetDeadline(time.Now())
+	}
+	mu.Unlock()
+	wg.Wait()
+	return err
+}
+
+// destination returns the host:port a proxied request is for.
+func destination(r *http.Request) string {
+	if r.Method == http.MethodConnect {
+		return strings.ToLower(r.Host)
+	}
+	host := strings.ToLower(r.URL.Host)
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	return host
+}
+
+// permit checks that the destination of a request is allowed, failing the request otherwise.
+func (p egressProxy) permit(w http.ResponseWriter, r *http.Request) (string, bool) {
+	dest := destination(r)
+	if !p.allowed[dest] {
+		log.L.Infof("Denied egress to %s", dest)
+		http.Error(w, fmt.Sprintf("egress to %s is not allowed", dest), http.StatusForbidden)
+		return "", false
+	}
+	return dest, true
+}
+
+// connect opens a CONNECT tunnel, returning the client and upstream connections.
+func (p egressProxy) connect(w http.ResponseWriter, r *http.Request) (net.Conn, net.Conn, bool) {
+	dest, ok := p.permit(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	upstream, err := p.dial(r.Context(), "tcp", dest)
+	if err != nil {
+		metrics.ProxyErrors.WithLabelValues("egress").Inc()
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			http.Error(w, "upstream connect timed out", http.StatusGatewayTimeout)
+			return nil, nil, false
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return nil, nil, false
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "webserver doesn't support hijacking", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	client, _, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return nil, nil, false
+	}
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		client.Close()
+		upstream.Close()
+		return nil, nil, false
+	}
+	return client, upstream, true
+}
+
+// forward proxies a plain HTTP request.
+func (p egressProxy) forward(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Host == "" {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+	dest, ok := p.permit(w, r)
+	if !ok {
+		return
+	}
+
+	// Only dial the permitted destination, whatever proxies the host is configured with.
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return p.dial(ctx, network, dest)
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		metrics.ProxyErrors.WithLabelValues("egress").Inc()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer closers.Panic(r.Context(), resp.Body)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
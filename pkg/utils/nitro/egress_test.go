@@ -0,0 +1,78 @@
+package nitro
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEgressProxyServe(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer upstream.Close()
+	allowed := upstream.Listener.Addr().String()
+
+	proxy := EgressProxy([]string{allowed}, time.Second)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		done <- proxy.Serve(ln)
+	}()
+	proxyURL, _ := url.Parse("http://" + ln.Addr().String())
+
+	// Plain requests to allowed destinations are forwarded.
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(upstream.URL)
+	assert.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "hello", string(body))
+
+	// Others are denied.
+	resp, err = client.Get("http://example.com/")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// CONNECT tunnels are opened to allowed destinations only.
+	connect := func(dest string) (net.Conn, *http.Response) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", dest, dest)
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+		assert.Nil(t, err)
+		return conn, resp
+	}
+	denied, resp := connect("example.com:443")
+	denied.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	tunnel, resp := connect(allowed)
+	defer tunnel.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	fmt.Fprintf(tunnel, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", allowed)
+	resp, err = http.ReadResponse(bufio.NewReader(tunnel), nil)
+	assert.Nil(t, err)
+	body, _ = io.ReadAll(io.LimitReader(resp.Body, 5))
+	assert.Equal(t, "hello", string(body))
+
+	// Closing the listener stops the proxy along with its tunnels.
+	ln.Close()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not stop")
+	}
+}
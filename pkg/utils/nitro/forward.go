@@ -0,0 +1,88 @@
+package nitro
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// DefaultForwardConnectTimeout bounds how long a Forwarder waits to connect to a destination.
+const DefaultForwardConnectTimeout = 10 * time.Second
+
+// Forwarder forwards the connections which enclaves make to a host vsock
+// port to TCP destinations, chosen by the CID of the enclave connecting.
+// Enclaves share host vsock ports, so one listener serves all of them.
+type Forwarder struct {
+	mu     sync.Mutex
+	routes map[uint32]string
+
+	dial    func(network, addr string) (net.Conn, error)
+	peerCID func(net.Conn) (uint32, bool)
+}
+
+// NewForwarder creates a Forwarder without routes.
+func NewForwarder() *Forwarder {
+	return &Forwarder{
+		routes: make(map[uint32]string),
+		dial: func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, DefaultForwardConnectTimeout)
+		},
+		peerCID: func(conn net.Conn) (uint32, bool) {
+			return transport.PeerCID(conn.RemoteAddr())
+		},
+	}
+}
+
+// Route forwards the connections of the enclave with the given CID to the host:port destination.
+func (f *Forwarder) Route(cid uint32, dest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[cid] = dest
+}
+
+// Unroute stops forwarding the connections of the enclave with the given
+// CID, and returns how many routes remain.
+func (f *Forwarder) Unroute(cid uint32) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.routes, cid)
+	return len(f.routes)
+}
+
+// Serve forwards the connections accepted on ln until ln is closed.
+// Connections from enclaves without a route are rejected.
+func (f *Forwarder) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go f.forward(conn)
+	}
+}
+
+func (f *Forwarder) forward(conn net.Conn) {
+	cid, ok := f.peerCID(conn)
+	f.mu.Lock()
+	dest, routed := f.routes[cid]
+	f.mu.Unlock()
+	if !ok || !routed {
+		log.L.Warnf("Rejected connection from %s without a route", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	upstream, err := f.dial("tcp", dest)
+	if err != nil {
+		log.L.Warnf("Failed to establish forwarding connection to %s: %s", dest, err)
+		metrics.ProxyErrors.WithLabelValues("forward").Inc()
+		conn.Close()
+		return
+	}
+	bidirectionalCopy(context.TODO(), conn, upstream)
+}
@@ -0,0 +1,57 @@
+package nitro
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwarderServe(t *testing.T) {
+	// An echo server stands in for the destination.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn) //nolint:errcheck
+		}
+	}()
+
+	// Every client of the test listener stands for the same enclave.
+	var cid uint32 = 16
+	f := NewForwarder()
+	f.peerCID = func(net.Conn) (uint32, bool) { return cid, true }
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go f.Serve(ln) //nolint:errcheck
+
+	roundTrip := func() error {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		return err
+	}
+
+	// Enclaves without a route are rejected.
+	assert.NotNil(t, roundTrip())
+
+	f.Route(cid, upstream.Addr().String())
+	assert.Nil(t, roundTrip())
+
+	assert.Equal(t, 0, f.Unroute(cid))
+	assert.NotNil(t, roundTrip())
+}
@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/brave-intl/bat-go/libs/logging"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 // EnclaveHealthCheck - status check handler for nitro enclave service
 func EnclaveHealthCheck(w http.ResponseWriter, r *http.Request) {
-	logging.Logger(r.Context(), "health-check").Info().Msg("in health-check handler")
+	log.G(r.Context()).Debug("in health-check handler")
 	fmt.Fprintf(w, "OK\n")
 }
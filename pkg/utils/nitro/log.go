@@ -1,13 +1,15 @@
 package nitro
 
 import (
+	"bufio"
 	"context"
 	"io"
-	"log"
 	"net"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
 	"github.com/brave-intl/bat-go/libs/closers"
-	"github.com/mdlayher/vsock"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 // VsockWriter - structure definition of a vsock writer
@@ -63,17 +65,19 @@ func (w VsockWriter) Write(p []byte) (n int, err error) {
 
 // VsockLogServer - implementation of a log server over vsock
 type VsockLogServer struct {
-	baseCtx context.Context
-	port    uint32
-	writer  io.Writer
+	baseCtx  context.Context
+	port     uint32
+	writer   agent.LogWriter
+	receiver *agent.LogReceiver
 }
 
 // NewVsockLogServer - create a new VsockLogServer
-func NewVsockLogServer(ctx context.Context, writer io.Writer, port uint32) VsockLogServer {
+func NewVsockLogServer(ctx context.Context, writer agent.LogWriter, port uint32) VsockLogServer {
 	return VsockLogServer{
-		baseCtx: ctx,
-		port:    port,
-		writer:  writer,
+		baseCtx:  ctx,
+		port:     port,
+		writer:   writer,
+		receiver: agent.NewLogReceiver(),
 	}
 }
 
@@ -81,38 +85,55 @@ func NewVsockLogServer(ctx context.Context, writer io.Writer, port uint32) Vsock
 func (s VsockLogServer) Serve(l net.Listener) error {
 	if l == nil {
 		var err error
-		l, err = vsock.Listen(s.port, &vsock.Config{})
+		l, err = transport.Listen(s.port)
 		if err != nil {
 			return err
 		}
 		defer closers.Panic(s.baseCtx, l)
 	}
-	log.Printf("Listening to connections on vsock port %d\n", s.port)
+	log.G(s.baseCtx).Infof("Listening to connections on vsock port %d", s.port)
 
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			log.Printf("Accept failed: %s", err)
+			log.G(s.baseCtx).Warnf("Accept failed: %s", err)
 			return err
 		}
 
-		go handleLogConn(s.baseCtx, s.writer, conn)
+		go handleLogConn(s.baseCtx, s.receiver, s.writer, conn)
 	}
 }
 
-func handleLogConn(ctx context.Context, writer io.Writer, conn net.Conn) {
-	log.Println("Accepted connection.")
+func handleLogConn(ctx context.Context, receiver *agent.LogReceiver, writer agent.LogWriter, conn net.Conn) {
+	log.G(ctx).Debug("Accepted log connection")
 	defer closers.Panic(ctx, conn)
-	defer log.Println("Closed connection.")
+	defer log.G(ctx).Debug("Closed log connection")
 
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		return
+	}
+	if !agent.IsVersioned(first[0]) {
+		// Agents predating the log protocol send their raw output.
+		copyRawLogs(writer, r)
+		return
+	}
+	if err := receiver.Serve(conn, r, writer); err != nil && err != io.EOF {
+		log.G(ctx).Warnf("Log connection failed: %s", err)
+	}
+}
+
+// copyRawLogs writes the unframed output of an agent as its standard output.
+func copyRawLogs(writer agent.LogWriter, r io.Reader) {
+	buf := make([]byte, 1024)
 	for {
-		buf := make([]byte, 1024)
-		size, err := conn.Read(buf)
+		size, err := r.Read(buf)
 		if err != nil {
 			return
 		}
-		if _, err := writer.Write(buf[:size]); err != nil {
-			log.Printf("failed to write: %s", err.Error())
+		if err := writer.WriteLog(agent.LogStdout, buf[:size]); err != nil {
+			log.L.Warnf("Failed to write: %s", err)
 		}
 	}
 }
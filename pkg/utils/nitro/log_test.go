@@ -6,15 +6,20 @@ import (
 	"testing"
 	"time"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
 	"github.com/rs/zerolog"
 )
 
+type discardLogs struct{}
+
+func (discardLogs) WriteLog(agent.LogStream, []byte) error { return nil }
+
 func TestServe(t *testing.T) {
 	l, err := net.Listen("tcp", "127.0.0.1:1234")
 	if err != nil {
 		t.Error("Unexpected error listening")
 	}
-	s := NewVsockLogServer(context.Background(), 1234)
+	s := NewVsockLogServer(context.Background(), discardLogs{}, 1234)
 	go func() {
 		if err := s.Serve(l); err != nil {
 			t.Error("failed to serve log server")
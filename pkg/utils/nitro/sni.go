@@ -0,0 +1,191 @@
+package nitro
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// clientHelloTimeout bounds how long clients of an SNI router take to send their ClientHello.
+const clientHelloTimeout = 10 * time.Second
+
+// SNIRouter routes the TLS connections accepted on a port shared by several
+// enclaves by the server name of their ClientHello. The TLS sessions are
+// passed through untouched, the enclaves terminate them.
+type SNIRouter struct {
+	mu     sync.RWMutex
+	routes map[string]*SNIRoute
+}
+
+// NewSNIRouter creates a router without routes.
+func NewSNIRouter() *SNIRouter {
+	return &SNIRouter{routes: make(map[string]*SNIRoute)}
+}
+
+// SNIRoute forwards the connections to some server names to a vsock port of an enclave.
+type SNIRoute struct {
+	router    *SNIRouter
+	hostnames []string
+	cid       uint32
+	port      uint32
+	forwarder *forwarder
+
+	once sync.Once
+	done chan struct{}
+}
+
+// Route forwards the connections to the given server names to a vsock port of
+// the enclave with the given CID, within the limits. Hostnames starting with
+// "*." match any server name with one more label. Routes are exclusive.
+func (r *SNIRouter) Route(hostnames []string, cid, port uint32, limits ProxyLimits) (*SNIRoute, error) {
+	return r.route(hostnames, cid, port, limits, func() (net.Conn, error) {
+		return transport.Dial(cid, port)
+	})
+}
+
+func (r *SNIRouter) route(hostnames []string, cid, port uint32, limits ProxyLimits, dial func() (net.Conn, error)) (*SNIRoute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, hostname := range hostnames {
+		if _, ok := r.routes[hostname]; ok {
+			return nil, fmt.Errorf("server name %s is already routed", hostname)
+		}
+	}
+	route := &SNIRoute{
+		router:    r,
+		hostnames: hostnames,
+		cid:       cid,
+		port:      port,
+		forwarder: newForwarder(limits, dial),
+		done:      make(chan struct{}),
+	}
+	for _, hostname := range hostnames {
+		r.routes[hostname] = route
+	}
+	return route, nil
+}
+
+// Len returns how many server names are routed.
+func (r *SNIRouter) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.routes)
+}
+
+// lookup returns the route of a server name, nil when it is not routed.
+func (r *SNIRouter) lookup(serverName string) *SNIRoute {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if route, ok := r.routes[serverName]; ok {
+		return route
+	}
+	if i := strings.IndexByte(serverName, '.'); i > 0 {
+		return r.routes["*"+serverName[i:]]
+	}
+	return nil
+}
+
+// Serve accepts connections on ln and forwards them along their route until
+// ln is closed. Connections to server names without a route are closed.
+func (r *SNIRouter) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go r.dispatch(conn)
+	}
+}
+
+func (r *SNIRouter) dispatch(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	serverName, hello, err := readServerName(conn)
+	if err != nil {
+		log.L.Warnf("Failed to read the ClientHello of %s: %s", conn.RemoteAddr(), err)
+		metrics.ProxyErrors.WithLabelValues("sni").Inc()
+		conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	route := r.lookup(serverName)
+	if route == nil {
+		log.L.Warnf("Refused connection of %s to %q: no route", conn.RemoteAddr(), serverName)
+		conn.Close()
+		return
+	}
+	if route.forwarder.forward(conn, hello, conn.LocalAddr().String()) {
+		log.L.Debugf("Dispatched forwarders for %s <-> vm(%d):%d", serverName, route.cid, route.port)
+	}
+}
+
+// Close stops routing new connections. It does not wait for the connections
+// being forwarded, Serve does.
+func (route *SNIRoute) Close() error {
+	route.once.Do(func() {
+		route.router.mu.Lock()
+		for _, hostname := range route.hostnames {
+			if route.router.routes[hostname] == route {
+				delete(route.router.routes, hostname)
+			}
+		}
+		route.router.mu.Unlock()
+		close(route.done)
+	})
+	return nil
+}
+
+// Serve waits for the route to be closed, then drains the connections it
+// forwards like the TCP proxies do.
+func (route *SNIRoute) Serve() error {
+	<-route.done
+	route.forwarder.drain()
+	return nil
+}
+
+// errClientHelloRead stops the TLS handshake once the ClientHello is read.
+var errClientHelloRead = errors.New("client hello read")
+
+// readServerName reads the ClientHello of a TLS connection, returning its
+// server name and the bytes read, to be replayed to the enclave.
+func readServerName(conn net.Conn) (string, []byte, error) {
+	var hello bytes.Buffer
+	var serverName string
+	read := false
+	err := tls.Server(readOnlyConn{r: io.TeeReader(conn, &hello)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, read = info.ServerName, true
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	if !read {
+		return "", nil, err
+	}
+	return serverName, hello.Bytes(), nil
+}
+
+// readOnlyConn lets the TLS server read a ClientHello without answering it.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
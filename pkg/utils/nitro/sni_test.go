@@ -0,0 +1,66 @@
+package nitro
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSNIRouter(t *testing.T) {
+	router := NewSNIRouter()
+	// Upstreams stand in for the enclaves, recording the server name of the ClientHello they get.
+	upstream := func() (func() (net.Conn, error), chan string) {
+		names := make(chan string, 1)
+		return func() (net.Conn, error) {
+			enclave, conn := net.Pipe()
+			go func() {
+				name, _, _ := readServerName(enclave)
+				names <- name
+				enclave.Close()
+			}()
+			return conn, nil
+		}, names
+	}
+	dialA, namesA := upstream()
+	routeA, err := router.route([]string{"a.example.com"}, 16, 443, ProxyLimits{}, dialA)
+	assert.Nil(t, err)
+	dialB, namesB := upstream()
+	routeB, err := router.route([]string{"*.b.example.com"}, 17, 443, ProxyLimits{}, dialB)
+	assert.Nil(t, err)
+	_, err = router.route([]string{"a.example.com"}, 18, 443, ProxyLimits{}, dialA)
+	assert.EqualError(t, err, "server name a.example.com is already routed")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go router.Serve(ln) //nolint:errcheck
+
+	hello := func(serverName string) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.Nil(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		// The handshake fails, as the upstreams do not answer.
+		tls.Client(conn, &tls.Config{ServerName: serverName}).Handshake() //nolint:errcheck
+	}
+
+	hello("a.example.com")
+	assert.Equal(t, "a.example.com", <-namesA)
+	hello("www.b.example.com")
+	assert.Equal(t, "www.b.example.com", <-namesB)
+	hello("c.example.com")
+	assert.Empty(t, namesA)
+	assert.Empty(t, namesB)
+
+	// Closed routes no longer route, and drain.
+	assert.Nil(t, routeA.Close())
+	assert.Nil(t, routeA.Serve())
+	assert.Equal(t, 1, router.Len())
+	hello("a.example.com")
+	assert.Empty(t, namesA)
+	assert.Nil(t, routeB.Close())
+	assert.Equal(t, 0, router.Len())
+}
@@ -0,0 +1,123 @@
+package nitro
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+)
+
+// DefaultUDPIdleTimeout is how long a UDP client may stay silent before its
+// relay connection to the enclave is closed.
+const DefaultUDPIdleTimeout = 2 * time.Minute
+
+type udpProxy struct {
+	cid         uint32
+	port        uint32
+	idleTimeout time.Duration
+	dial        func() (net.Conn, error)
+}
+
+// UDPProxy creates a proxy forwarding UDP datagrams to a port of the enclave
+// with the given CID, through the UDP relay of the enclave's agent.
+func UDPProxy(cid uint32, port uint32) udpProxy {
+	return udpProxy{cid, port, DefaultUDPIdleTimeout, func() (net.Conn, error) {
+		return transport.Dial(cid, agent.UDPRelayPort)
+	}}
+}
+
+// Serve reads datagrams from pc and forwards them to the enclave until pc is
+// closed. Each client address gets its own relay connection, replies are sent
+// back to the client it came from.
+func (u udpProxy) Serve(pc net.PacketConn) error {
+	var (
+		mu       sync.Mutex
+		sessions = make(map[string]net.Conn)
+		wg       sync.WaitGroup
+	)
+	defer func() {
+		mu.Lock()
+		for _, conn := range sessions {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+
+	buf := make([]byte, agent.MaxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		mu.Lock()
+		conn, ok := sessions[addr.String()]
+		mu.Unlock()
+		if !ok {
+			conn, err = u.open()
+			if err != nil {
+				log.L.Warnf("Failed to establish forwarding connection: %s", err)
+				metrics.ProxyErrors.WithLabelValues("udp").Inc()
+				continue
+			}
+
+			mu.Lock()
+			sessions[addr.String()] = conn
+			mu.Unlock()
+
+			wg.Add(1)
+			go func(addr net.Addr) {
+				defer wg.Done()
+				u.reply(pc, addr, conn)
+
+				mu.Lock()
+				delete(sessions, addr.String())
+				mu.Unlock()
+			}(addr)
+			log.L.Debugf("Dispatched forwarders for %s <-> vm(%d):%d/udp", addr, u.cid, u.port)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(u.idleTimeout))
+		if err := agent.WriteDatagram(conn, buf[:n]); err != nil {
+			log.L.Warnf("Failed to forward datagram from %s: %s", addr, err)
+			metrics.ProxyErrors.WithLabelValues("udp").Inc()
+			conn.Close()
+		}
+	}
+}
+
+// open dials the enclave's UDP relay for the proxied port.
+func (u udpProxy) open() (net.Conn, error) {
+	conn, err := u.dial()
+	if err != nil {
+		return nil, err
+	}
+	if err := agent.WriteUDPRelayHeader(conn, uint16(u.port)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// reply sends the datagrams coming back from the enclave to the client at
+// addr, until the relay connection fails or stays idle for too long.
+func (u udpProxy) reply(pc net.PacketConn, addr net.Addr, conn net.Conn) {
+	defer conn.Close()
+
+	buf := make([]byte, agent.MaxDatagramSize)
+	for {
+		conn.SetReadDeadline(time.Now().Add(u.idleTimeout))
+		n, err := agent.ReadDatagram(conn, buf)
+		if err != nil {
+			return
+		}
+		if _, err := pc.WriteTo(buf[:n], addr); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package nitro
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPProxyServe(t *testing.T) {
+	// A UDP echo server stands in for the workload.
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, agent.MaxDatagramSize)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr) //nolint:errcheck
+		}
+	}()
+
+	// The agent's relay is reached over TCP instead of vsock.
+	relay, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer relay.Close()
+	go agent.UDPRelay{}.Serve(relay) //nolint:errcheck
+
+	proxy := UDPProxy(0, uint32(echo.LocalAddr().(*net.UDPAddr).Port))
+	proxy.dial = func() (net.Conn, error) {
+		return net.Dial("tcp", relay.Addr().String())
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		done <- proxy.Serve(pc)
+	}()
+
+	client, err := net.Dial("udp", pc.LocalAddr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	buf := make([]byte, 16)
+	for _, msg := range []string{"ping", "pong"} {
+		_, err = client.Write([]byte(msg))
+		assert.Nil(t, err)
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := client.Read(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, msg, string(buf[:n]))
+	}
+
+	pc.Close()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not stop")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -15,9 +14,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/metrics"
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/transport"
 	"github.com/brave-intl/bat-go/libs/closers"
-	"github.com/brave-intl/bat-go/libs/logging"
-	"github.com/mdlayher/vsock"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 )
 
 // NotVsockAddrError indicates that the string does not have the correct structure for a vsock address
@@ -57,30 +57,20 @@ func parseVsockAddr(addr string) (uint32, uint32, error) {
 
 // DialContext is a net.Dial wrapper which additionally allows connecting to vsock networks
 func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	logger := logging.Logger(ctx, "nitro.DialContext")
-	logger.Info().
-		Str("network", fmt.Sprintf("%v", network)).
-		Str("addr", fmt.Sprintf("%v", addr)).
-		Msg("DialContext")
+	logger := log.G(ctx).WithFields(log.Fields{"network": network, "addr": addr})
 
 	cid, port, err := parseVsockAddr(addr)
 	if err != nil {
 		if _, ok := err.(NotVsockAddrError); ok {
 			// fallback to net.Dial
-			logger.Error().Err(err).
-				Str("cid", fmt.Sprintf("%v", cid)).
-				Str("port", fmt.Sprintf("%v", port)).
-				Msg("vsock dialing now")
+			logger.Debug("Dialing")
 			return net.Dial(network, addr)
 		}
 		return nil, err
 	}
 
-	logger.Info().
-		Str("cid", fmt.Sprintf("%v", cid)).
-		Str("port", fmt.Sprintf("%v", port)).
-		Msg("vsock dialing now")
-	return vsock.Dial(cid, port, &vsock.Config{})
+	logger.WithFields(log.Fields{"cid": cid, "port": port}).Debug("Dialing vsock")
+	return transport.Dial(cid, port)
 }
 
 type proxyClientConfig struct {
@@ -89,15 +79,9 @@ type proxyClientConfig struct {
 }
 
 func (p *proxyClientConfig) Proxy(*http.Request) (*url.URL, error) {
-	logger := logging.Logger(p.Ctx, "nitro.Proxy")
-	logger.Info().
-		Str("addr", p.Addr).
-		Msg("performing proxy")
 	v, err := url.Parse(p.Addr)
 	if err != nil {
-		logger.Error().Err(err).
-			Str("addr", p.Addr).
-			Msg("error parsing address")
+		log.G(p.Ctx).WithError(err).WithField("addr", p.Addr).Error("Failed to parse the proxy address")
 	}
 
 	return v, err
@@ -138,35 +122,224 @@ func NewReverseProxyServer(
 	}, nil
 }
 
+// ConnLimit bounds how many connections the proxies sharing it forward at once.
+type ConnLimit chan struct{}
+
+// NewConnLimit creates a limit of n connections, nil for no limit when n is not positive.
+func NewConnLimit(n int) ConnLimit {
+	if n <= 0 {
+		return nil
+	}
+	return make(ConnLimit, n)
+}
+
+// acquire takes a connection from the limit, failing when none is left.
+func (l ConnLimit) acquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l ConnLimit) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// ProxyLimits bounds the connections forwarded by a proxy.
+type ProxyLimits struct {
+	// Conns bounds the connections forwarded at once, connections beyond it are refused.
+	Conns ConnLimit
+	// IdleTimeout closes connections without traffic for that long, unless zero.
+	IdleTimeout time.Duration
+	// DrainTimeout is how long connections may keep being forwarded once the
+	// proxy stops accepting new ones, before they are interrupted.
+	DrainTimeout time.Duration
+}
+
 type tcpProxy struct {
-	cid  uint32
-	port uint32
+	cid    uint32
+	port   uint32
+	limits ProxyLimits
+	dial   func() (net.Conn, error)
 }
 
-func TCPProxy(cid uint32, port uint32) tcpProxy {
-	return tcpProxy{cid, port}
+// TCPProxy creates a proxy forwarding TCP connections to a vsock port of the enclave with the given CID.
+func TCPProxy(cid uint32, port uint32, limits ProxyLimits) tcpProxy {
+	return tcpProxy{cid, port, limits, func() (net.Conn, error) {
+		return transport.Dial(cid, port)
+	}}
 }
 
-func (t tcpProxy) Serve(ln net.Listener) {
-	go func() {
-		for {
-			inConn, err := ln.Accept()
-			if err != nil {
-				log.Printf("Accept failed: %s", err)
-				return
-			}
-
-			outConn, err := vsock.Dial(t.cid, t.port, &vsock.Config{})
-			if err != nil {
-				log.Printf("Failed to establish forwarding connection: %s", err)
-				inConn.Close()
-				continue
-			}
-
-			go bidirectionalCopy(context.TODO(), inConn, outConn)
-			log.Printf("Dispatched forwarders for %s <-> vm(%d):%d", ln.Addr(), t.cid, t.port)
+// Serve accepts connections on ln and forwards them to the enclave until ln
+// is closed. Connections still being forwarded are drained, then interrupted
+// and closed before Serve returns.
+func (t tcpProxy) Serve(ln net.Listener) error {
+	f := newForwarder(t.limits, t.dial)
+	defer f.drain()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
 		}
+		if f.forward(conn, nil, ln.Addr().String()) {
+			log.L.Debugf("Dispatched forwarders for %s <-> vm(%d):%d", ln.Addr(), t.cid, t.port)
+		}
+	}
+}
+
+// forwarder forwards connections to an enclave within the limits of a proxy,
+// tracking them so they can be drained.
+type forwarder struct {
+	limits ProxyLimits
+	dial   func() (net.Conn, error)
+
+	mu      sync.Mutex
+	conns   map[*idleConn]struct{}
+	wg      sync.WaitGroup
+	drained bool
+}
+
+func newForwarder(limits ProxyLimits, dial func() (net.Conn, error)) *forwarder {
+	return &forwarder{limits: limits, dial: dial, conns: make(map[*idleConn]struct{})}
+}
+
+// forward forwards conn to the enclave, sending it what was already read from
+// conn first, and tells whether it is being forwarded. It takes ownership of conn.
+func (f *forwarder) forward(conn net.Conn, read []byte, addr string) bool {
+	if !f.limits.Conns.acquire() {
+		log.L.Warnf("Refused connection to %s: too many connections", addr)
+		conn.Close()
+		return false
+	}
+
+	outConn, err := f.dial()
+	if err == nil && len(read) > 0 {
+		if _, err = outConn.Write(read); err != nil {
+			outConn.Close()
+		}
+	}
+	if err != nil {
+		log.L.Warnf("Failed to establish forwarding connection: %s", err)
+		metrics.ProxyErrors.WithLabelValues("port").Inc()
+		conn.Close()
+		f.limits.Conns.release()
+		return false
+	}
+
+	inConn := newIdleConn(conn, f.limits.IdleTimeout)
+	upstream := newIdleConn(outConn, f.limits.IdleTimeout)
+	f.mu.Lock()
+	if f.drained {
+		f.mu.Unlock()
+		conn.Close()
+		outConn.Close()
+		f.limits.Conns.release()
+		return false
+	}
+	f.conns[inConn] = struct{}{}
+	f.conns[upstream] = struct{}{}
+	f.wg.Add(1)
+	f.mu.Unlock()
+
+	go func() {
+		defer f.wg.Done()
+		defer f.limits.Conns.release()
+		bidirectionalCopy(context.TODO(), inConn, upstream)
+
+		f.mu.Lock()
+		delete(f.conns, inConn)
+		delete(f.conns, upstream)
+		f.mu.Unlock()
+	}()
+	return true
+}
+
+// drain stops forwarding new connections and waits for the forwarded ones
+// to end, interrupting those still open after the drain timeout.
+func (f *forwarder) drain() {
+	f.mu.Lock()
+	f.drained = true
+	f.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(drained)
 	}()
+	select {
+	case <-drained:
+		return
+	case <-time.After(f.limits.DrainTimeout):
+	}
+
+	// Expire the connections rather than closing them, bidirectionalCopy
+	// closes them once the copies are interrupted.
+	f.mu.Lock()
+	for conn := range f.conns {
+		conn.expire()
+	}
+	f.mu.Unlock()
+	<-drained
+}
+
+// idleConn is a connection which times out after being idle for a while,
+// its deadline being pushed back by every read and write.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func newIdleConn(conn net.Conn, timeout time.Duration) *idleConn {
+	c := &idleConn{Conn: conn, timeout: timeout}
+	c.extend()
+	return c
+}
+
+// extend pushes back the deadline of the connection, unless it expired.
+func (c *idleConn) extend() {
+	if c.timeout <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.expired {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+// expire interrupts the pending and future reads and writes of the connection.
+func (c *idleConn) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expired = true
+	c.Conn.SetDeadline(time.Now())
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.extend()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.extend()
+	}
+	return n, err
 }
 
 type openProxy struct {
@@ -180,22 +353,20 @@ func ServeOpenProxy(
 	connectTimeout time.Duration,
 ) error {
 
-	logger := logging.Logger(ctx, "nitro")
-	logger.Info().Msg("!!!! starting open proxy")
+	log.G(ctx).Info("Starting open proxy")
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
 		Handler: openProxy{ConnectTimeout: connectTimeout},
 	}
 
-	l, err := vsock.Listen(port, &vsock.Config{})
+	l, err := transport.Listen(port)
 	if err != nil {
-		logger.Error().Err(err).Msg(fmt.Sprintf("listening on vsock port: %v", port))
 		return fmt.Errorf("listening on vsock port failed: %v", err)
 	}
 	defer closers.Panic(ctx, l)
 
-	logger.Info().Msg(fmt.Sprintf("listening on vsock port: %v", port))
+	log.G(ctx).Infof("Open proxy listening on vsock port %d", port)
 
 	return server.Serve(l)
 }
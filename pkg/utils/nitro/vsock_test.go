@@ -0,0 +1,120 @@
+package nitro
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTCPProxyServe(t *testing.T) {
+	// An echo server stands in for the enclave.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn) //nolint:errcheck
+		}
+	}()
+
+	proxy := TCPProxy(0, 0, ProxyLimits{})
+	proxy.dial = func() (net.Conn, error) {
+		return net.Dial("tcp", upstream.Addr().String())
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		done <- proxy.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// Closing the listener stops the proxy along with its connections.
+	ln.Close()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, net.ErrClosed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("proxy did not stop")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestTCPProxyLimits(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn) //nolint:errcheck
+		}
+	}()
+
+	proxy := TCPProxy(0, 0, ProxyLimits{Conns: NewConnLimit(1), IdleTimeout: 200 * time.Millisecond})
+	proxy.dial = func() (net.Conn, error) {
+		return net.Dial("tcp", upstream.Addr().String())
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	go proxy.Serve(ln) //nolint:errcheck
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer first.Close()
+	_, err = first.Write([]byte("ping"))
+	assert.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(first, buf)
+	assert.Nil(t, err)
+
+	// Connections beyond the limit are refused.
+	second, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = second.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Idle connections are closed, freeing their slot.
+	first.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = first.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+
+	third, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer third.Close()
+	assert.Eventually(t, func() bool {
+		_, err := third.Write([]byte("ping"))
+		if err != nil {
+			return false
+		}
+		third.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadFull(third, buf)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -1,14 +1,21 @@
 package wait
 
 import (
+	"context"
+	"time"
+
 	"golang.org/x/sys/unix"
 )
 
+// pollInterval is how often ForPIDContext checks whether the context is done.
+const pollInterval = time.Second
+
 func ForPID(pid int) error {
 	pidfd, err := unix.PidfdOpen(pid, 0)
 	if err != nil {
 		return err
 	}
+	defer unix.Close(pidfd)
 
 	pollfd := unix.PollFd{
 		Fd:      int32(pidfd),
@@ -18,3 +25,29 @@ func ForPID(pid int) error {
 	_, err = unix.Poll([]unix.PollFd{pollfd}, -1)
 	return err
 }
+
+// ForPIDContext waits for the process to exit or for the context to be done.
+func ForPIDContext(ctx context.Context, pid int) error {
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(pidfd)
+
+	pollfds := []unix.PollFd{{
+		Fd:     int32(pidfd),
+		Events: unix.POLLIN,
+	}}
+	for {
+		n, err := unix.Poll(pollfds, int(pollInterval/time.Millisecond))
+		if err != nil && err != unix.EINTR {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/node"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Mutator is a mutating admission webhook setting the vCPUs and memory of
+// the enclaves of the pods targeting enclave nodes as extended resources of
+// their container, which the resource quotas of their namespace cap.
+type Mutator struct {
+	webhook *Webhook
+}
+
+// patchOperation is an operation of a JSON patch.
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// NewMutator creates a new Mutator.
+func NewMutator(config Config) *Mutator {
+	return &Mutator{webhook: New(config)}
+}
+
+// Patch returns the JSON patch setting the enclave resources of a pod, nil
+// when it needs none: it does not target enclave nodes, requests both
+// already, or a profile of the node sizes its enclave. Pods with several
+// containers, which enclave nodes reject, are not patched either.
+func (m *Mutator) Patch(pod *corev1.Pod) ([]byte, error) {
+	if !m.webhook.TargetsEnclaves(pod) || len(pod.Spec.Containers) != 1 {
+		return nil, nil
+	}
+	if _, ok := pod.Annotations[node.ProfileAnnotation]; ok {
+		return nil, nil
+	}
+	reqs := pod.Spec.Containers[0].Resources.DeepCopy()
+	_, hasCPUs := reqs.Limits[node.ResourceEnclaveCPUs]
+	_, hasMemory := reqs.Limits[node.ResourceEnclaveMemoryMiB]
+	if hasCPUs && hasMemory {
+		return nil, nil
+	}
+
+	// Extended resources are not overcommitted, their requests equal their limits.
+	if reqs.Limits == nil {
+		reqs.Limits = corev1.ResourceList{}
+	}
+	if reqs.Requests == nil {
+		reqs.Requests = corev1.ResourceList{}
+	}
+	for name, quantity := range node.EnclaveResources(pod) {
+		reqs.Limits[name] = quantity
+		reqs.Requests[name] = quantity
+	}
+	return json.Marshal([]patchOperation{{Op: "add", Path: "/spec/containers/0/resources", Value: reqs}})
+}
+
+// ServeHTTP answers the admission reviews of pods sent by the API server.
+func (m *Mutator) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	serveReview(rw, r, m.review)
+}
+
+// review answers an admission request.
+func (m *Mutator) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	pod, response := decodePod(req)
+	if pod == nil {
+		return response
+	}
+	patch, err := m.Patch(pod)
+	if err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: fmt.Sprintf("failed to patch the pod: %v", err),
+			Reason:  metav1.StatusReasonInternalError,
+			Code:    http.StatusInternalServerError,
+		}
+		return response
+	}
+	if patch != nil {
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = patch
+		response.PatchType = &patchType
+	}
+	return response
+}
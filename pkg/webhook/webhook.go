@@ -0,0 +1,158 @@
+// Package webhook implements a validating admission webhook rejecting the
+// pods targeting enclave nodes which the provider cannot run, so they fail
+// when they are applied instead of once they reach the node, and a mutating
+// one accounting for their enclaves in resource quotas.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/brave-experiments/nitro-enclave-kubelet/pkg/node"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultTaintKey is the key of the taint of virtual-kubelet nodes.
+	DefaultTaintKey = "virtual-kubelet.io/provider"
+
+	// nodeTypeLabel and nodeType label virtual-kubelet nodes.
+	nodeTypeLabel = "type"
+	nodeType      = "virtual-kubelet"
+
+	// maxReviewSize bounds the admission reviews read, pods are much smaller.
+	maxReviewSize = 3 << 20
+)
+
+// Config configures the webhook.
+type Config struct {
+	// TaintKey is the key of the taint of enclave nodes, DefaultTaintKey when
+	// empty. Pods tolerating it target enclave nodes.
+	TaintKey string
+	// RuntimeClassName is the runtime class of the pods running in enclaves.
+	// Pods requesting it target enclave nodes, other pods targeting them are
+	// rejected. Pods need no runtime class when empty.
+	RuntimeClassName string
+	// MaxMemoryMiB is the most memory an enclave can have, such as the size
+	// of the enclave pools of the nodes. Enclaves are not limited when zero.
+	MaxMemoryMiB int64
+}
+
+// Webhook validates the pods targeting enclave nodes.
+type Webhook struct {
+	config Config
+}
+
+// New creates a new Webhook.
+func New(config Config) *Webhook {
+	if config.TaintKey == "" {
+		config.TaintKey = DefaultTaintKey
+	}
+	return &Webhook{config: config}
+}
+
+// TargetsEnclaves tells whether a pod targets enclave nodes: whether it
+// requests their runtime class, tolerates their taint or selects them.
+func (w *Webhook) TargetsEnclaves(pod *corev1.Pod) bool {
+	if w.config.RuntimeClassName != "" && pod.Spec.RuntimeClassName != nil && *pod.Spec.RuntimeClassName == w.config.RuntimeClassName {
+		return true
+	}
+	// Tolerations of every taint, such as those of daemonsets, do not count.
+	for _, toleration := range pod.Spec.Tolerations {
+		if toleration.Key == w.config.TaintKey {
+			return true
+		}
+	}
+	return pod.Spec.NodeSelector[nodeTypeLabel] == nodeType
+}
+
+// Validate rejects the pods targeting enclave nodes which the provider
+// cannot run. Other pods are allowed.
+func (w *Webhook) Validate(pod *corev1.Pod) error {
+	if !w.TargetsEnclaves(pod) {
+		return nil
+	}
+	if name := w.config.RuntimeClassName; name != "" && (pod.Spec.RuntimeClassName == nil || *pod.Spec.RuntimeClassName != name) {
+		return fmt.Errorf("pods running in enclaves must request the %q runtime class", name)
+	}
+	if err := node.ValidatePod(pod); err != nil {
+		return err
+	}
+	if memory := node.EnclaveMemoryMiB(pod); w.config.MaxMemoryMiB > 0 && memory > w.config.MaxMemoryMiB {
+		return fmt.Errorf("the enclave of the pod needs %d MiB of memory, enclaves have at most %d MiB", memory, w.config.MaxMemoryMiB)
+	}
+	return nil
+}
+
+// ServeHTTP answers the admission reviews of pods sent by the API server.
+func (w *Webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	serveReview(rw, r, w.review)
+}
+
+// serveReview answers an admission review posted by the API server with review.
+func serveReview(rw http.ResponseWriter, r *http.Request, review func(*admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "admission reviews are posted", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxReviewSize))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ar admissionv1.AdmissionReview
+	if err := json.Unmarshal(data, &ar); err != nil || ar.Request == nil {
+		http.Error(rw, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	ar.Response = review(ar.Request)
+	ar.Request = nil
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(ar) //nolint:errcheck
+}
+
+// review answers an admission request.
+func (w *Webhook) review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	pod, response := decodePod(req)
+	if pod == nil {
+		return response
+	}
+	if err := w.Validate(pod); err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+		}
+	}
+	return response
+}
+
+// decodePod returns the pod of an admission request and the response
+// allowing it. The pod is nil when the request is not about one, or denied
+// if it cannot be decoded.
+func decodePod(req *admissionv1.AdmissionRequest) (*corev1.Pod, *admissionv1.AdmissionResponse) {
+	response := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Kind != "Pod" || req.SubResource != "" {
+		return nil, response
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: fmt.Sprintf("failed to decode the pod: %v", err),
+			Reason:  metav1.StatusReasonBadRequest,
+			Code:    http.StatusBadRequest,
+		}
+		return nil, response
+	}
+	return &pod, response
+}
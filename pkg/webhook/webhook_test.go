@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidate(t *testing.T) {
+	w := New(Config{RuntimeClassName: "nitro-enclave", MaxMemoryMiB: 4096})
+	runtimeClass := "nitro-enclave"
+	newPod := func(memory string) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{
+			RuntimeClassName: &runtimeClass,
+			Containers: []corev1.Container{{Name: "web", Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(memory)},
+			}}},
+		}}
+	}
+
+	assert.Nil(t, w.Validate(newPod("2Gi")))
+	assert.EqualError(t, w.Validate(newPod("8Gi")), "the enclave of the pod needs 8192 MiB of memory, enclaves have at most 4096 MiB")
+
+	pod := newPod("2Gi")
+	pod.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/data"}}}}
+	assert.EqualError(t, w.Validate(pod), `volume "data" is unsupported, only emptyDir and projected volumes are`)
+
+	// Pods tolerating the taint of enclave nodes target them too.
+	pod = newPod("2Gi")
+	pod.Spec.RuntimeClassName = nil
+	pod.Spec.Tolerations = []corev1.Toleration{{Key: DefaultTaintKey, Operator: corev1.TolerationOpExists}}
+	assert.EqualError(t, w.Validate(pod), `pods running in enclaves must request the "nitro-enclave" runtime class`)
+
+	// Other pods are not checked.
+	pod.Spec.Tolerations = []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+	assert.Nil(t, w.Validate(pod))
+}
+
+func TestServeHTTP(t *testing.T) {
+	pod := corev1.Pod{Spec: corev1.PodSpec{
+		NodeSelector: map[string]string{nodeTypeLabel: nodeType},
+		Containers:   []corev1.Container{{Name: "web"}, {Name: "sidecar"}},
+	}}
+	raw, err := json.Marshal(pod)
+	assert.Nil(t, err)
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:    "1",
+			Kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Object: runtime.RawExtension{Raw: raw},
+		},
+	})
+	assert.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	New(Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var review admissionv1.AdmissionReview
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &review))
+	if assert.NotNil(t, review.Response) {
+		assert.Equal(t, "1", string(review.Response.UID))
+		assert.False(t, review.Response.Allowed)
+		assert.Equal(t, "launching more than 1 container is unsupported", review.Response.Result.Message)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	m := NewMutator(Config{})
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		NodeSelector: map[string]string{nodeTypeLabel: nodeType},
+		Containers: []corev1.Container{{Name: "web", Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		}}},
+		Overhead: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+	}}
+
+	// The enclave resources of the pod are set, overhead included.
+	patch, err := m.Patch(pod)
+	assert.Nil(t, err)
+	var ops []struct {
+		Op    string                      `json:"op"`
+		Path  string                      `json:"path"`
+		Value corev1.ResourceRequirements `json:"value"`
+	}
+	if assert.Nil(t, json.Unmarshal(patch, &ops)) && assert.Len(t, ops, 1) {
+		assert.Equal(t, "/spec/containers/0/resources", ops[0].Path)
+		limits := ops[0].Value.Limits
+		assert.Equal(t, "1088", ops[0].Value.Requests.Name("aws.ec2.nitro/enclave_memory_mib", resource.DecimalSI).String())
+		assert.Equal(t, "1088", limits.Name("aws.ec2.nitro/enclave_memory_mib", resource.DecimalSI).String())
+		assert.Equal(t, "1Gi", limits.Memory().String())
+	}
+
+	// Pods requesting them already, or not targeting enclave nodes, are left alone.
+	pod.Spec.Containers[0].Resources.Limits = ops[0].Value.Limits
+	patch, err = m.Patch(pod)
+	assert.Nil(t, err)
+	assert.Nil(t, patch)
+	pod.Spec.Containers[0].Resources.Limits = nil
+	pod.Spec.NodeSelector = nil
+	patch, err = m.Patch(pod)
+	assert.Nil(t, err)
+	assert.Nil(t, patch)
+}